@@ -1,15 +0,0 @@
-package main
-
-import (
-	"github.com/VadimBorzenkov/online-song-library/internal/app"
-)
-
-// @title Online Song Library API
-// @version 1.0
-// @description API for managing time tracking tasks
-// @termsOfService https://example.com
-// @host localhost:8080
-// @BasePath /
-func main() {
-	app.Run()
-}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/cli"
+)
+
+// @title Online Song Library API
+// @version 1.0
+// @description API for managing time tracking tasks
+// @termsOfService https://example.com
+// @host localhost:8080
+// @BasePath /
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+func main() {
+	os.Exit(cli.Run(os.Args[1:]))
+}
@@ -1,34 +1,245 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"os"
-
-	"github.com/joho/godotenv"
+	"strings"
+	"time"
 )
 
+// Config is loaded in layers: defaults from the struct tags, then the config
+// file (--config or CONFIG_FILE), then environment variables (including a
+// .env file if present), then command line flags. Every field is named by its
+// env tag; the same name in lower case is the file key and, with dashes, the
+// flag (DB_HOST, db_host, --db-host). KEY_FILE variables read the value from
+// a file, which is how secrets are passed in containers.
 type Config struct {
-	Port           string
-	DBHost         string
-	DBPort         string
-	DBUser         string
-	DBPass         string
-	DBName         string
-	ExternalApiURL string
+	Port         int           `env:"PORT" default:"8080" desc:"HTTP port"`
+	ReadTimeout  time.Duration `env:"HTTP_READ_TIMEOUT" default:"15s" desc:"maximum time to read a request"`
+	WriteTimeout time.Duration `env:"HTTP_WRITE_TIMEOUT" default:"0s" desc:"maximum time to write a response, 0 disables it so exports can stream"`
+	IdleTimeout  time.Duration `env:"HTTP_IDLE_TIMEOUT" default:"60s" desc:"keep-alive idle timeout"`
+	CORSOrigins  []string      `env:"CORS_ALLOW_ORIGINS" default:"*" desc:"comma separated allowed CORS origins"`
+	TLSCertFile  string        `env:"TLS_CERT_FILE" desc:"serve HTTPS with this certificate"`
+	TLSKeyFile   string        `env:"TLS_KEY_FILE" desc:"private key for TLS_CERT_FILE"`
+
+	// ProxyHeader is only read from requests of TrustedProxies, so clients
+	// cannot pick the IP they are rate limited by.
+	ProxyHeader    string   `env:"PROXY_HEADER" desc:"header the reverse proxy puts the client IP in, e.g. X-Forwarded-For; empty uses the connection address"`
+	TrustedProxies []string `env:"TRUSTED_PROXIES" desc:"comma separated IPs or CIDRs of the proxies allowed to set PROXY_HEADER"`
+
+	LogLevel  string `env:"LOG_LEVEL" default:"info" desc:"debug, info, warn or error"`
+	LogFormat string `env:"LOG_FORMAT" default:"text" desc:"text or json"`
+
+	Storage    string `env:"STORAGE" default:"postgres" desc:"postgres, sqlite or memory"`
+	SQLitePath string `env:"SQLITE_PATH" default:"songlib.db" desc:"SQLite database file, :memory: for a throwaway one"`
+
+	// DatabaseURL, if set, replaces the DB_HOST ... DB_NAME settings.
+	DatabaseURL        string        `env:"DATABASE_URL" secret:"true" desc:"full postgres:// URL or key=value DSN of the primary"`
+	DatabaseReplicaURL string        `env:"DATABASE_REPLICA_URL" secret:"true" desc:"read replica DSN used for song listings"`
+	DBHost             string        `env:"DB_HOST" desc:"database host"`
+	DBPort             int           `env:"DB_PORT" default:"5432" desc:"database port"`
+	DBUser             string        `env:"DB_USER" desc:"database user"`
+	DBPass             string        `env:"DB_PASSWORD" secret:"true" desc:"database password"`
+	DBName             string        `env:"DB_NAME" desc:"database name"`
+	DBSSLMode          string        `env:"DB_SSLMODE" default:"disable" desc:"disable, allow, prefer, require, verify-ca or verify-full"`
+	DBSSLRootCert      string        `env:"DB_SSLROOTCERT" desc:"CA certificate to verify the server"`
+	DBSSLCert          string        `env:"DB_SSLCERT" desc:"client certificate"`
+	DBSSLKey           string        `env:"DB_SSLKEY" desc:"client certificate key"`
+	DBApplicationName  string        `env:"DB_APPLICATION_NAME" default:"online-song-library" desc:"application_name reported to Postgres"`
+	DBStatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT" default:"0s" desc:"server side statement timeout, 0 disables it"`
+	DBMaxOpenConns     int           `env:"DB_MAX_OPEN_CONNS" default:"10" desc:"maximum open database connections"`
+	DBMaxIdleConns     int           `env:"DB_MAX_IDLE_CONNS" default:"5" desc:"maximum idle database connections"`
+	DBConnMaxLifetime  time.Duration `env:"DB_CONN_MAX_LIFETIME" default:"30m" desc:"recycle connections after this age, 0 keeps them forever"`
+	DBConnMaxIdleTime  time.Duration `env:"DB_CONN_MAX_IDLE_TIME" default:"5m" desc:"close connections idle for this long, 0 keeps them"`
+
+	ExternalApiURL     string        `env:"EXTERNAL_API_URL" required:"true" desc:"song details provider URL"`
+	ExternalApiTimeout time.Duration `env:"EXTERNAL_API_TIMEOUT" default:"10s" desc:"timeout for provider requests"`
+
+	ExplicitWordlist string `env:"EXPLICIT_WORDLIST" desc:"file of words marking lyrics explicit, one per line, a trailing * matches any ending; the built-in list if empty"`
+
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" default:"15s" desc:"time to drain requests on shutdown"`
+
+	MigrateOnStart bool `env:"MIGRATE_ON_START" default:"true" desc:"apply pending migrations on startup"`
+
+	DBConnectRetries       int           `env:"DB_CONNECT_RETRIES" default:"10" desc:"database connection attempts on startup"`
+	DBConnectRetryDelay    time.Duration `env:"DB_CONNECT_RETRY_DELAY" default:"1s" desc:"initial delay between connection attempts"`
+	HealthCheckExternalApi bool          `env:"HEALTH_CHECK_EXTERNAL_API" default:"false" desc:"check the provider in /readyz"`
+
+	AllowAnonymousRead bool          `env:"AUTH_ALLOW_ANONYMOUS_READ" default:"false" desc:"allow reads without credentials"`
+	JWTSecret          string        `env:"JWT_SECRET" secret:"true" desc:"HS256 token secret"`
+	JWTPublicKeyFile   string        `env:"JWT_PUBLIC_KEY_FILE" desc:"PEM public key for RS256 tokens"`
+	JWTIssuer          string        `env:"JWT_ISSUER" desc:"expected token issuer"`
+	JWTAudience        string        `env:"JWT_AUDIENCE" desc:"expected token audience"`
+	SessionTTL         time.Duration `env:"SESSION_TTL" default:"720h" desc:"lifetime of user login sessions"`
+
+	DefaultLibrary   string `env:"DEFAULT_LIBRARY" default:"default" desc:"library used when none is selected"`
+	TenantBaseDomain string `env:"TENANT_BASE_DOMAIN" desc:"base domain for subdomain library selection"`
+
+	RateLimitStore         string  `env:"RATE_LIMIT_STORE" default:"memory" desc:"memory or postgres"`
+	RateLimitReadRate      float64 `env:"RATE_LIMIT_READ_RATE" default:"10" desc:"read requests per second, 0 disables"`
+	RateLimitReadBurst     int     `env:"RATE_LIMIT_READ_BURST" default:"20" desc:"read burst size"`
+	RateLimitWriteRate     float64 `env:"RATE_LIMIT_WRITE_RATE" default:"2" desc:"write requests per second, 0 disables"`
+	RateLimitWriteBurst    int     `env:"RATE_LIMIT_WRITE_BURST" default:"5" desc:"write burst size"`
+	RateLimitProviderRate  float64 `env:"RATE_LIMIT_PROVIDER_RATE" default:"0.5" desc:"provider requests per second, 0 disables"`
+	RateLimitProviderBurst int     `env:"RATE_LIMIT_PROVIDER_BURST" default:"3" desc:"provider burst size"`
+
+	TracesExporter string `env:"OTEL_TRACES_EXPORTER" default:"none" desc:"none, otlp or stdout"`
+
+	// sources records which layer each key came from, for Print.
+	sources map[string]string
+}
+
+// Storage backends.
+const (
+	StoragePostgres = "postgres"
+	StorageSQLite   = "sqlite"
+	StorageMemory   = "memory"
+)
+
+// Addr is the listen address for Port.
+func (c *Config) Addr() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+// ValidationError lists every invalid or missing key found while loading.
+type ValidationError struct {
+	Problems []string
 }
 
-func LoadConfig() (*Config, error) {
-	err := godotenv.Load()
-	if err != nil {
-		return nil, err
-	}
-
-	return &Config{
-		Port:           ":" + os.Getenv("PORT"),
-		DBHost:         os.Getenv("DB_HOST"),
-		DBPort:         os.Getenv("DB_PORT"),
-		DBUser:         os.Getenv("DB_USER"),
-		DBPass:         os.Getenv("DB_PASSWORD"),
-		DBName:         os.Getenv("DB_NAME"),
-		ExternalApiURL: os.Getenv("EXTERNAL_API_URL"),
-	}, nil
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// validate checks values that parse fine but make no sense together.
+func (c *Config) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		add("%s: must be one of %s, got %q", key, strings.Join(allowed, ", "), value)
+	}
+	oneOf("LOG_LEVEL", c.LogLevel, "debug", "info", "warn", "error")
+	oneOf("LOG_FORMAT", c.LogFormat, "text", "json")
+	oneOf("RATE_LIMIT_STORE", c.RateLimitStore, "memory", "postgres")
+	oneOf("OTEL_TRACES_EXPORTER", c.TracesExporter, "none", "otlp", "stdout")
+	oneOf("DB_SSLMODE", c.DBSSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+
+	oneOf("STORAGE", c.Storage, StoragePostgres, StorageSQLite, StorageMemory)
+	if c.Storage != StoragePostgres {
+		if c.RateLimitStore == "postgres" {
+			add("RATE_LIMIT_STORE: postgres requires STORAGE=postgres")
+		}
+		if c.DatabaseReplicaURL != "" {
+			add("DATABASE_REPLICA_URL: requires STORAGE=postgres")
+		}
+	}
+	if c.Storage == StorageSQLite && c.SQLitePath == "" {
+		add("SQLITE_PATH: required when STORAGE=sqlite")
+	}
+
+	if c.Storage == StoragePostgres && c.DatabaseURL == "" {
+		required := map[string]string{
+			"DB_HOST": c.DBHost,
+			"DB_USER": c.DBUser,
+			"DB_NAME": c.DBName,
+		}
+		for key, value := range required {
+			if value == "" {
+				add("%s: required unless DATABASE_URL is set", key)
+			}
+		}
+	}
+	if (c.DBSSLCert == "") != (c.DBSSLKey == "") {
+		add("DB_SSLCERT and DB_SSLKEY must be set together")
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		add("PORT: must be between 1 and 65535, got %d", c.Port)
+	}
+	if c.DBPort < 1 || c.DBPort > 65535 {
+		add("DB_PORT: must be between 1 and 65535, got %d", c.DBPort)
+	}
+	if c.DBMaxOpenConns < 0 {
+		add("DB_MAX_OPEN_CONNS: must not be negative")
+	}
+	if c.DBMaxIdleConns < 0 {
+		add("DB_MAX_IDLE_CONNS: must not be negative")
+	}
+	if c.DBConnectRetries < 1 {
+		add("DB_CONNECT_RETRIES: must be at least 1")
+	}
+
+	durations := map[string]time.Duration{
+		"HTTP_READ_TIMEOUT":      c.ReadTimeout,
+		"HTTP_WRITE_TIMEOUT":     c.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":      c.IdleTimeout,
+		"EXTERNAL_API_TIMEOUT":   c.ExternalApiTimeout,
+		"SHUTDOWN_TIMEOUT":       c.ShutdownTimeout,
+		"DB_CONNECT_RETRY_DELAY": c.DBConnectRetryDelay,
+		"DB_STATEMENT_TIMEOUT":   c.DBStatementTimeout,
+		"DB_CONN_MAX_LIFETIME":   c.DBConnMaxLifetime,
+		"DB_CONN_MAX_IDLE_TIME":  c.DBConnMaxIdleTime,
+	}
+	for key, d := range durations {
+		if d < 0 {
+			add("%s: must not be negative", key)
+		}
+	}
+
+	rates := map[string]float64{
+		"RATE_LIMIT_READ_RATE":     c.RateLimitReadRate,
+		"RATE_LIMIT_WRITE_RATE":    c.RateLimitWriteRate,
+		"RATE_LIMIT_PROVIDER_RATE": c.RateLimitProviderRate,
+	}
+	for key, r := range rates {
+		if r < 0 {
+			add("%s: must not be negative", key)
+		}
+	}
+
+	if len(c.CORSOrigins) == 0 {
+		add("CORS_ALLOW_ORIGINS: must list at least one origin")
+	}
+
+	if c.ProxyHeader != "" && len(c.TrustedProxies) == 0 {
+		add("PROXY_HEADER: requires TRUSTED_PROXIES")
+	}
+	for _, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				add("TRUSTED_PROXIES: %q is not an IP or CIDR", proxy)
+			}
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		add("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	files := map[string]string{
+		"TLS_CERT_FILE":       c.TLSCertFile,
+		"TLS_KEY_FILE":        c.TLSKeyFile,
+		"JWT_PUBLIC_KEY_FILE": c.JWTPublicKeyFile,
+		"DB_SSLROOTCERT":      c.DBSSLRootCert,
+		"DB_SSLCERT":          c.DBSSLCert,
+		"DB_SSLKEY":           c.DBSSLKey,
+		"EXPLICIT_WORDLIST":   c.ExplicitWordlist,
+	}
+	for key, path := range files {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			add("%s: %v", key, err)
+		}
+	}
+
+	return problems
 }
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Sources a value can come from, reported by Print.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+type field struct {
+	index    int
+	key      string
+	def      string
+	desc     string
+	required bool
+	secret   bool
+}
+
+func (f field) fileKey() string {
+	return strings.ToLower(f.key)
+}
+
+func (f field) flagName() string {
+	return strings.ReplaceAll(f.fileKey(), "_", "-")
+}
+
+// fields lists the Config fields in declaration order.
+func fields() []field {
+	t := reflect.TypeOf(Config{})
+	var out []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("env")
+		if key == "" {
+			continue
+		}
+		out = append(out, field{
+			index:    i,
+			key:      key,
+			def:      sf.Tag.Get("default"),
+			desc:     sf.Tag.Get("desc"),
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+		})
+	}
+	return out
+}
+
+type value struct {
+	raw    string
+	source string
+}
+
+// LoadConfig builds the configuration from defaults, the config file, the
+// environment and the given command line flags, in increasing priority. All
+// problems are reported at once in a *ValidationError.
+func LoadConfig(args []string) (*Config, error) {
+	// Values from .env never override the real environment.
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("load .env: %w", err)
+	}
+
+	all := fields()
+
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	flagValues := make(map[string]*string, len(all))
+	for _, f := range all {
+		flagValues[f.key] = flags.String(f.flagName(), "", f.desc+" ("+f.key+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	setFlags := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	var problems []string
+	values := make(map[string]value, len(all))
+	for _, f := range all {
+		values[f.key] = value{raw: f.def, source: SourceDefault}
+	}
+
+	if *configFile != "" {
+		fileValues, err := readFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		known := make(map[string]string, len(all))
+		for _, f := range all {
+			known[f.fileKey()] = f.key
+		}
+		for key, raw := range fileValues {
+			envKey, ok := known[strings.ToLower(key)]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown key %q", *configFile, key))
+				continue
+			}
+			values[envKey] = value{raw: raw, source: SourceFile}
+		}
+	}
+
+	for _, f := range all {
+		env, hasEnv := os.LookupEnv(f.key)
+		path, hasFile := os.LookupEnv(f.key + "_FILE")
+		switch {
+		case hasEnv && env != "" && hasFile && path != "":
+			problems = append(problems, fmt.Sprintf("%s: set either %s or %s_FILE, not both", f.key, f.key, f.key))
+		case hasFile && path != "":
+			data, err := os.ReadFile(path)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s_FILE: %v", f.key, err))
+				continue
+			}
+			values[f.key] = value{raw: strings.TrimRight(string(data), "\r\n"), source: SourceEnv}
+		case hasEnv && env != "":
+			values[f.key] = value{raw: env, source: SourceEnv}
+		}
+
+		if setFlags[f.flagName()] {
+			values[f.key] = value{raw: *flagValues[f.key], source: SourceFlag}
+		}
+	}
+
+	cfg := &Config{sources: make(map[string]string, len(all))}
+	target := reflect.ValueOf(cfg).Elem()
+	failed := map[string]bool{}
+	for _, f := range all {
+		v := values[f.key]
+		cfg.sources[f.key] = v.source
+		if v.raw == "" {
+			if f.required {
+				failed[f.key] = true
+				problems = append(problems, fmt.Sprintf("%s: required", f.key))
+			}
+			continue
+		}
+		if err := setField(target.Field(f.index), v.raw); err != nil {
+			failed[f.key] = true
+			problems = append(problems, fmt.Sprintf("%s: %v", f.key, err))
+		}
+	}
+
+	// Skip follow-up complaints about keys that already failed to parse.
+	for _, problem := range cfg.validate() {
+		key, _, _ := strings.Cut(problem, ":")
+		if !failed[key] {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	return cfg, nil
+}
+
+// readFile flattens a YAML or TOML file into raw string values. Lists are
+// joined with commas, the same as in env variables.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	doc := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	out := make(map[string]string, len(doc))
+	for key, v := range doc {
+		if list, ok := v.([]interface{}); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+			continue
+		}
+		out[key] = fmt.Sprint(v)
+	}
+	return out, nil
+}
+
+func setField(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func formatField(v reflect.Value) string {
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return x.String()
+	case []string:
+		return strings.Join(x, ",")
+	default:
+		return fmt.Sprint(x)
+	}
+}
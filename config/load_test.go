@@ -0,0 +1,230 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv hides the configuration of the environment running the tests.
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("CONFIG_FILE", "")
+	for _, f := range fields() {
+		t.Setenv(f.key, "")
+		t.Setenv(f.key+"_FILE", "")
+	}
+}
+
+// minimalEnv sets what LoadConfig requires without a config file.
+func minimalEnv(t *testing.T) {
+	t.Helper()
+
+	clearEnv(t)
+	t.Setenv("EXTERNAL_API_URL", "http://provider.test")
+	t.Setenv("STORAGE", StorageMemory)
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func source(t *testing.T, cfg *Config, key string) string {
+	t.Helper()
+
+	for _, s := range cfg.Settings() {
+		if s.Key == key {
+			return s.Source
+		}
+	}
+	t.Fatalf("no setting %s", key)
+	return ""
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		env    string
+		flag   string
+		port   int
+		source string
+	}{
+		{"default", "", "", "", 8080, SourceDefault},
+		{"file over default", "8081", "", "", 8081, SourceFile},
+		{"env over file", "8081", "8082", "", 8082, SourceEnv},
+		{"flag over env", "8081", "8082", "8083", 8083, SourceFlag},
+		{"flag over file", "8081", "", "8083", 8083, SourceFlag},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minimalEnv(t)
+
+			var args []string
+			if tt.file != "" {
+				args = append(args, "--config", writeFile(t, "songlib.yaml", "port: "+tt.file+"\n"))
+			}
+			if tt.env != "" {
+				t.Setenv("PORT", tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "--port", tt.flag)
+			}
+
+			cfg, err := LoadConfig(args)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.Port != tt.port {
+				t.Errorf("Port = %d, want %d", cfg.Port, tt.port)
+			}
+			if got := source(t, cfg, "PORT"); got != tt.source {
+				t.Errorf("PORT source = %q, want %q", got, tt.source)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"yaml", "songlib.yaml", "external_api_url: http://provider.test\nstorage: memory\ncors_allow_origins:\n  - https://a.test\n  - https://b.test\nshutdown_timeout: 5s\n"},
+		{"toml", "songlib.toml", "external_api_url = \"http://provider.test\"\nstorage = \"memory\"\ncors_allow_origins = [\"https://a.test\", \"https://b.test\"]\nshutdown_timeout = \"5s\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("CONFIG_FILE", writeFile(t, tt.file, tt.content))
+
+			cfg, err := LoadConfig(nil)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if want := []string{"https://a.test", "https://b.test"}; !reflect.DeepEqual(cfg.CORSOrigins, want) {
+				t.Errorf("CORSOrigins = %v, want %v", cfg.CORSOrigins, want)
+			}
+			if cfg.ShutdownTimeout != 5*time.Second || cfg.ExternalApiURL != "http://provider.test" {
+				t.Errorf("config = %+v", cfg)
+			}
+		})
+	}
+
+	t.Run("unsupported format", func(t *testing.T) {
+		minimalEnv(t)
+		if _, err := LoadConfig([]string{"--config", writeFile(t, "songlib.json", "{}")}); err == nil || !strings.Contains(err.Error(), "unsupported format") {
+			t.Fatalf("LoadConfig error = %v, want unsupported format", err)
+		}
+	})
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		file    string
+		missing bool
+		secret  string
+		problem string
+	}{
+		{"from file", "", "s3cret\n", false, "s3cret", ""},
+		{"trailing newlines only", "", "s3cret \r\n\n", false, "s3cret ", ""},
+		{"from env", "plain", "", false, "plain", ""},
+		{"both set", "plain", "s3cret\n", false, "", "JWT_SECRET: set either JWT_SECRET or JWT_SECRET_FILE, not both"},
+		{"missing file", "", "", true, "", "JWT_SECRET_FILE: open"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minimalEnv(t)
+			if tt.env != "" {
+				t.Setenv("JWT_SECRET", tt.env)
+			}
+			switch {
+			case tt.file != "":
+				t.Setenv("JWT_SECRET_FILE", writeFile(t, "jwt_secret", tt.file))
+			case tt.missing:
+				t.Setenv("JWT_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+			}
+
+			cfg, err := LoadConfig(nil)
+			if tt.problem != "" {
+				var verr *ValidationError
+				if !errors.As(err, &verr) || len(verr.Problems) != 1 || !strings.HasPrefix(verr.Problems[0], tt.problem) {
+					t.Fatalf("LoadConfig error = %v, want %q", err, tt.problem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.JWTSecret != tt.secret {
+				t.Errorf("JWTSecret = %q, want %q", cfg.JWTSecret, tt.secret)
+			}
+			if got := source(t, cfg, "JWT_SECRET"); got != SourceEnv {
+				t.Errorf("JWT_SECRET source = %q, want %q", got, SourceEnv)
+			}
+			for _, s := range cfg.Settings() {
+				if s.Key == "JWT_SECRET" && s.Value != redactedValue {
+					t.Errorf("JWT_SECRET printed as %q", s.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigReportsAllProblems(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("PORT", "eighty")
+	t.Setenv("HTTP_READ_TIMEOUT", "soon")
+	t.Setenv("LOG_LEVEL", "loud")
+	t.Setenv("STORAGE", StorageSQLite)
+	t.Setenv("RATE_LIMIT_STORE", "postgres")
+	t.Setenv("PROXY_HEADER", "X-Forwarded-For")
+	t.Setenv("RATE_LIMIT_READ_RATE", "-1")
+	config := writeFile(t, "songlib.yaml", "db_hots: localhost\n")
+
+	_, err := LoadConfig([]string{"--config", config, "--db-port", "0"})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("LoadConfig error = %v, want a *ValidationError", err)
+	}
+
+	want := []string{
+		config + `: unknown key "db_hots"`,
+		"DB_PORT: must be between 1 and 65535, got 0",
+		"EXTERNAL_API_URL: required",
+		`HTTP_READ_TIMEOUT: invalid duration "soon"`,
+		`LOG_LEVEL: must be one of debug, info, warn, error, got "loud"`,
+		`PORT: invalid integer "eighty"`,
+		"PROXY_HEADER: requires TRUSTED_PROXIES",
+		"RATE_LIMIT_READ_RATE: must not be negative",
+		"RATE_LIMIT_STORE: postgres requires STORAGE=postgres",
+	}
+	if !reflect.DeepEqual(verr.Problems, want) {
+		t.Fatalf("problems:\n  %s\nwant:\n  %s", strings.Join(verr.Problems, "\n  "), strings.Join(want, "\n  "))
+	}
+}
+
+func TestLoadConfigArguments(t *testing.T) {
+	minimalEnv(t)
+
+	if _, err := LoadConfig([]string{"--port", "8081", "extra"}); err == nil || !strings.Contains(err.Error(), "unexpected arguments: extra") {
+		t.Fatalf("LoadConfig error = %v, want unexpected arguments", err)
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+const redactedValue = "******"
+
+// Setting is one effective configuration value and the layer it came from.
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Settings lists the effective configuration in declaration order. Secrets
+// are redacted.
+func (c *Config) Settings() []Setting {
+	v := reflect.ValueOf(c).Elem()
+	var out []Setting
+	for _, f := range fields() {
+		value := formatField(v.Field(f.index))
+		if f.secret && value != "" {
+			value = redactedValue
+		}
+
+		source := c.sources[f.key]
+		if source == "" {
+			source = SourceDefault
+		}
+
+		out = append(out, Setting{Key: f.key, Value: value, Source: source})
+	}
+	return out
+}
+
+// Print writes the effective configuration in .env format, annotated with
+// the layer each value came from. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	for _, s := range c.Settings() {
+		if _, err := fmt.Fprintf(w, "%s=%s # %s\n", s.Key, s.Value, s.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,48 +1,93 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/VadimBorzenkov/online-song-library/config"
-	"github.com/VadimBorzenkov/online-song-library/internal/db"
-	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
-	"github.com/VadimBorzenkov/online-song-library/internal/delivery/routes"
 	"github.com/VadimBorzenkov/online-song-library/internal/log"
-	"github.com/VadimBorzenkov/online-song-library/internal/repository"
-	"github.com/VadimBorzenkov/online-song-library/internal/service"
-	"github.com/VadimBorzenkov/online-song-library/pkg/migrator"
-	"github.com/gofiber/fiber/v2"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/sirupsen/logrus"
 )
 
-func Run() {
-	logger := log.InitLogger()
-
-	config, err := config.LoadConfig()
+// Run starts the server with the given command line flags and returns the
+// process exit code.
+func Run(args []string) int {
+	config, err := config.LoadConfig(args)
 	if err != nil {
-		logger.Fatalf("Failed to load config: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	logger := log.InitLogger(config.LogLevel, config.LogFormat)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := serve(ctx, config, logger); err != nil {
+		logger.Errorf("Server stopped with error: %v", err)
+		return 1
 	}
 
-	dbase := db.Init(config)
+	logger.Info("Server stopped")
+	return 0
+}
+
+// serve runs the server until ctx is cancelled. On cancellation it stops
+// accepting connections, drains in-flight requests for up to
+// SHUTDOWN_TIMEOUT, and then closes the server's resources. Cleanup runs
+// through defers, so it also happens when startup fails halfway.
+func serve(ctx context.Context, config *config.Config, logger *logrus.Logger) error {
+	shutdownTracing, err := tracing.Init(ctx, config.TracesExporter)
+	if err != nil {
+		return fmt.Errorf("initialize tracing: %w", err)
+	}
 	defer func() {
-		if err := db.Close(dbase); err != nil {
-			logger.Errorf("Failed to close database: %v", err)
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Errorf("Failed to flush traces: %v", err)
 		}
 	}()
 
-	if err := migrator.RunDatabaseMigrations(dbase); err != nil {
-		logger.Fatalf("Failed to run migrations: %v", err)
+	srv, err := NewServer(ctx, config, logger)
+	defer srv.Close()
+	if err != nil {
+		return err
 	}
 
-	repo := repository.NewApiRepository(dbase, logger)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- srv.Listen()
+	}()
 
-	svc := service.NewApiService(repo, logger, config)
+	select {
+	case err := <-listenErr:
+		return fmt.Errorf("listen: %w", err)
+	case <-ctx.Done():
+	}
 
-	handler := handler.NewApiHandler(svc, logger)
+	logger.Infof("Shutdown signal received, draining in-flight requests (timeout %s)", config.ShutdownTimeout)
 
-	app := fiber.New()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
 
-	routes.RegistrationRoutes(app, handler)
+	if err := srv.App.ShutdownWithContext(shutdownCtx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			logger.Warn("Shutdown timeout exceeded, remaining connections were closed")
+		} else {
+			logger.Errorf("Error during server shutdown: %v", err)
+		}
+	}
 
-	logger.Infof("Starting server on port %s", config.Port)
-	if err := app.Listen(config.Port); err != nil {
-		logger.Fatalf("Error starting server: %v", err)
+	if err := <-listenErr; err != nil {
+		logger.Errorf("Listener returned error on shutdown: %v", err)
 	}
+
+	return nil
 }
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/sirupsen/logrus"
+)
+
+// slowProvider answers once release is closed, reporting each request it
+// gets on started. /readyz waits for it with HEALTH_CHECK_EXTERNAL_API set.
+func slowProvider(t *testing.T) (srv *httptest.Server, started <-chan struct{}, release chan struct{}) {
+	t.Helper()
+
+	requests := make(chan struct{}, 10)
+	release = make(chan struct{})
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests, release
+}
+
+// startServe runs serve on a free port with memory storage until the
+// returned cancel is called; its result arrives on done.
+func startServe(t *testing.T, providerURL string, shutdownTimeout time.Duration) (base string, cancel context.CancelFunc, done <-chan error) {
+	t.Helper()
+
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("free port: %v", err)
+	}
+	cfg, err := config.LoadConfig([]string{
+		"--port", strconv.Itoa(port),
+		"--storage", config.StorageMemory,
+		"--external-api-url", providerURL,
+		"--health-check-external-api=true",
+		"--shutdown-timeout", shutdownTimeout.String(),
+	})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	result := make(chan error, 1)
+	go func() { result <- serve(ctx, cfg, logger) }()
+
+	base = fmt.Sprintf("http://127.0.0.1:%d", port)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if resp, err := http.Get(base + "/healthz"); err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return base, cancel, result
+}
+
+type response struct {
+	status int
+	err    error
+}
+
+func get(url string) <-chan response {
+	result := make(chan response, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			result <- response{err: err}
+			return
+		}
+		resp.Body.Close()
+		result <- response{status: resp.StatusCode}
+	}()
+	return result
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	provider, started, release := slowProvider(t)
+	base, cancel, done := startServe(t, provider.URL, 10*time.Second)
+
+	inFlight := get(base + "/readyz")
+	<-started
+	cancel()
+
+	// New connections are refused while the request is drained.
+	addr := base[len("http://"):]
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("server accepts connections after shutdown started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("serve returned %v before the in-flight request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if resp := <-inFlight; resp.err != nil || resp.status != http.StatusOK {
+		t.Fatalf("in-flight request = %d, %v, want 200", resp.status, resp.err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after draining")
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	provider, started, release := slowProvider(t)
+	defer close(release)
+	base, cancel, done := startServe(t, provider.URL, 200*time.Millisecond)
+
+	get(base + "/readyz")
+	<-started
+
+	stopped := time.Now()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve waited for a request past SHUTDOWN_TIMEOUT")
+	}
+	if elapsed := time.Since(stopped); elapsed < 200*time.Millisecond {
+		t.Errorf("serve returned after %s, before SHUTDOWN_TIMEOUT", elapsed)
+	}
+}
@@ -0,0 +1,1320 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// The integration suite drives the fully wired server through every route
+// against a real Postgres and a stub of the song details provider. The
+// database comes from TEST_DATABASE_URL or, failing that, from a throwaway
+// cluster started with initdb and pg_ctl found in PG_BIN or on PATH. Without
+// either the suite is skipped.
+
+// testDSN is the Postgres the suite runs against, empty if there is none.
+var testDSN string
+
+// testDSNSkip says why testDSN is empty.
+var testDSNSkip string
+
+func TestMain(m *testing.M) {
+	// The /docs route serves ./docs/swagger.json, like the server does when
+	// started from the repository root.
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		fmt.Fprintln(os.Stderr, "chdir to repository root:", err)
+		os.Exit(1)
+	}
+
+	stop := func() {}
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		testDSN = dsn
+	} else {
+		var err error
+		testDSN, stop, err = startPostgres()
+		if err != nil {
+			testDSNSkip = err.Error()
+		}
+	}
+
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startPostgres initialises a cluster in a temporary directory and starts it
+// on a free port, listening only on a unix socket in that directory.
+func startPostgres() (dsn string, stop func(), err error) {
+	stop = func() {}
+
+	initdb, err := pgBinary("initdb")
+	if err != nil {
+		return "", stop, err
+	}
+	pgCtl, err := pgBinary("pg_ctl")
+	if err != nil {
+		return "", stop, err
+	}
+	if os.Geteuid() == 0 {
+		return "", stop, fmt.Errorf("initdb refuses to run as root; set TEST_DATABASE_URL instead")
+	}
+
+	dir, err := os.MkdirTemp("", "songlib-pg-")
+	if err != nil {
+		return "", stop, err
+	}
+	data := filepath.Join(dir, "data")
+
+	out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", stop, fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", stop, err
+	}
+
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses='' -c fsync=off", port, dir)
+	out, err = exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", stop, fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+
+	stop = func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+	return fmt.Sprintf("host=%s port=%d user=postgres dbname=postgres sslmode=disable", dir, port), stop, nil
+}
+
+func pgBinary(name string) (string, error) {
+	if dir := os.Getenv("PG_BIN"); dir != "" {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("PG_BIN: %w", err)
+		}
+		return path, nil
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("neither TEST_DATABASE_URL nor %s is available", name)
+	}
+	return path, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// testSchemaDSN creates a fresh schema in the test database, dropped when
+// the test ends, and returns a DSN whose connections use it.
+func testSchemaDSN(t *testing.T) string {
+	t.Helper()
+
+	if testDSN == "" {
+		t.Skip(testDSNSkip)
+	}
+
+	admin, err := sql.Open("postgres", testDSN)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	name := fmt.Sprintf("app_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + name); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + name + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	separator := " "
+	if strings.Contains(testDSN, "://") {
+		separator = "&"
+		if !strings.Contains(testDSN, "?") {
+			separator = "?"
+		}
+	}
+	return testDSN + separator + "search_path=" + name
+}
+
+// stubProvider serves song details for the songs in details and 404 for
+// any other.
+func stubProvider(t *testing.T, details map[string]map[string]string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		detail, ok := details[r.URL.Query().Get("group")+"/"+r.URL.Query().Get("song")]
+		if r.URL.Path != "/info" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(detail)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+type testClient struct {
+	t   *testing.T
+	srv *Server
+}
+
+// newTestServer builds the server against a fresh schema and the given
+// provider, with rate limits high enough not to get in the way.
+func newTestServer(t *testing.T, providerURL string) *testClient {
+	t.Helper()
+
+	cfg, err := config.LoadConfig([]string{
+		"--storage", config.StoragePostgres,
+		"--database-url", testSchemaDSN(t),
+		"--db-connect-retries", "1",
+		"--external-api-url", providerURL + "/info?",
+		"--external-api-timeout", "5s",
+		"--rate-limit-read-rate", "1000",
+		"--rate-limit-read-burst", "1000",
+		"--rate-limit-write-rate", "1000",
+		"--rate-limit-write-burst", "1000",
+		"--rate-limit-provider-rate", "1000",
+		"--rate-limit-provider-burst", "1000",
+	})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	srv, err := NewServer(context.Background(), cfg, logger)
+	t.Cleanup(srv.Close)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return &testClient{t: t, srv: srv}
+}
+
+// apiKey creates a key with the given scopes, limited to library if it is
+// not empty.
+func (c *testClient) apiKey(name string, scopes []string, library string) string {
+	c.t.Helper()
+	return c.cleanApiKey(name, scopes, library, auth.CleanModeOff)
+}
+
+// cleanApiKey is apiKey for a key in the given clean mode.
+func (c *testClient) cleanApiKey(name string, scopes []string, library, cleanMode string) string {
+	c.t.Helper()
+
+	authSvc, err := service.NewApiAuthService(c.srv.Storage.Store, c.srv.logger, c.srv.config)
+	if err != nil {
+		c.t.Fatalf("NewApiAuthService: %v", err)
+	}
+	key, _, err := authSvc.CreateApiKey(context.Background(), name, scopes, library, cleanMode)
+	if err != nil {
+		c.t.Fatalf("CreateApiKey: %v", err)
+	}
+	return key
+}
+
+// actor returns the actor mutations made with the api key are recorded as.
+func (c *testClient) actor(key string) string {
+	c.t.Helper()
+
+	authSvc, err := service.NewApiAuthService(c.srv.Storage.Store, c.srv.logger, c.srv.config)
+	if err != nil {
+		c.t.Fatalf("NewApiAuthService: %v", err)
+	}
+	principal, err := authSvc.Authenticate(context.Background(), key)
+	if err != nil {
+		c.t.Fatalf("Authenticate: %v", err)
+	}
+	return principal.Actor()
+}
+
+// do sends the request and decodes a JSON response into out, if given. It
+// returns the status code.
+func (c *testClient) do(method, path, key string, headers map[string]string, body, out interface{}) int {
+	c.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.srv.App.Test(req, -1)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatalf("%s %s: read body: %v", method, path, err)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			c.t.Fatalf("%s %s: decode %q: %v", method, path, data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (c *testClient) expect(want int, method, path, key string, headers map[string]string, body, out interface{}) {
+	c.t.Helper()
+
+	if got := c.do(method, path, key, headers, body, out); got != want {
+		c.t.Fatalf("%s %s: status %d, want %d", method, path, got, want)
+	}
+}
+
+func TestServerIntegration(t *testing.T) {
+	provider := stubProvider(t, map[string]map[string]string{
+		"Muse/Supermassive": {
+			"releaseDate": "2006-07-16",
+			"text":        "Ooh baby, don't you know I suffer?\nOoh baby, can you hear me moan?\n\nYou set my soul alight\nGlaciers melting in the dead of night",
+			"link":        "https://www.youtube.com/watch?v=Xsp3_a-PMTw",
+		},
+		"Кино/Кукушка": {
+			"releaseDate": "1990-01-12",
+			"text":        "Песен ещё ненаписанных сколько?\nСкажи, кукушка, пропой\n\nВ городе мне жить или на выселках",
+			"link":        "https://example.com/kukushka",
+		},
+		"Rapper/Again": {
+			"releaseDate": "2001-05-01",
+			"text":        "Oh shit, here we go again\nNo more lies",
+			"link":        "https://example.com/again",
+		},
+		"Sunny/Day": {
+			"releaseDate": "2003-06-01",
+			"text":        "Nothing but blue skies",
+			"link":        "https://example.com/day",
+		},
+		"Queen/Innuendo": {
+			"releaseDate": "1991-01-14",
+			"text":        "While the sun hangs in the sky",
+			"link":        "https://example.com/innuendo",
+		},
+	})
+	c := newTestServer(t, provider.URL)
+
+	admin := c.apiKey("admin", []string{auth.ScopeAdmin}, "")
+	reader := c.apiKey("reader", []string{auth.ScopeSongsRead, auth.ScopeSongsInteract}, "")
+	viewer := c.apiKey("viewer", []string{auth.ScopeSongsRead}, "")
+	// Only admins pick a library per request, so the other callers get keys
+	// bound to the archive library once it exists.
+	var archiveReader, archiveViewer string
+
+	t.Run("Health", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		c.expect(http.StatusOK, http.MethodGet, "/healthz", "", nil, nil, nil)
+
+		var report models.HealthReport
+		c.expect(http.StatusOK, http.MethodGet, "/readyz", "", nil, nil, &report)
+		if report.Status != "ok" {
+			t.Fatalf("readyz status %q, checks %+v", report.Status, report.Checks)
+		}
+		for _, check := range []string{"database", "migrations"} {
+			if _, ok := report.Checks[check]; !ok {
+				t.Errorf("readyz has no %s check: %+v", check, report.Checks)
+			}
+		}
+	})
+
+	t.Run("Authentication", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		c.expect(http.StatusUnauthorized, http.MethodGet, "/songs/", "", nil, nil, nil)
+		c.expect(http.StatusUnauthorized, http.MethodGet, "/songs/", "sl_not_a_key", nil, nil, nil)
+		c.expect(http.StatusForbidden, http.MethodPost, "/songs/add_song", reader, nil, map[string]string{"group": "Muse", "song": "Supermassive"}, nil)
+		c.expect(http.StatusForbidden, http.MethodGet, "/libraries/", reader, nil, nil, nil)
+		c.expect(http.StatusForbidden, http.MethodGet, "/audit/", reader, nil, nil, nil)
+	})
+
+	var songID int
+	t.Run("Songs", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		var added handler.DataResponseSong
+		c.expect(http.StatusCreated, http.MethodPost, "/songs/add_song", admin, nil, map[string]string{"group": "Muse", "song": "Supermassive"}, &added)
+		if added.Data == nil || added.Data.ID == 0 {
+			t.Fatalf("add_song returned %+v", added)
+		}
+		if !strings.HasPrefix(added.Data.ReleaseDate, "2006-07-16") || added.Data.Link != "https://www.youtube.com/watch?v=Xsp3_a-PMTw" {
+			t.Errorf("add_song did not take the provider details: %+v", added.Data)
+		}
+		if added.Data.CreatedBy != c.actor(admin) {
+			t.Errorf("created_by = %q", added.Data.CreatedBy)
+		}
+		songID = added.Data.ID
+
+		c.expect(http.StatusInternalServerError, http.MethodPost, "/songs/add_song", admin, nil, map[string]string{"group": "Nobody", "song": "Unknown"}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPost, "/songs/add_song", admin, nil, map[string]string{"group": "Muse"}, nil)
+
+		var list handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?group=muse", reader, nil, nil, &list)
+		if len(list.Data) != 1 || list.Data[0].ID != songID {
+			t.Fatalf("filtered list = %+v", list.Data)
+		}
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?group=Queen", reader, nil, nil, &list)
+		if len(list.Data) != 0 {
+			t.Fatalf("list for another group = %+v", list.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/?limit=zero", reader, nil, nil, nil)
+
+		var verses handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?limit=1&offset=1", songID), reader, nil, nil, &verses)
+		if verses.Data == nil || verses.Data.Text != "You set my soul alight\nGlaciers melting in the dead of night" {
+			t.Fatalf("second verse = %+v", verses.Data)
+		}
+
+		var updated handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/update_song/%d", songID), admin, nil, map[string]string{"link": "https://example.com/supermassive"}, &updated)
+		var song handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", songID), reader, nil, nil, &song)
+		if song.Data.Link != "https://example.com/supermassive" || song.Data.Group != "Muse" || song.Data.ReleaseDate != "2006-07-16" {
+			t.Fatalf("after update = %+v", song.Data)
+		}
+		c.expect(http.StatusNotFound, http.MethodPut, "/songs/update_song/999999", admin, nil, map[string]string{"link": "x"}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/songs/update_song/%d", songID), admin, nil, map[string]string{}, nil)
+	})
+
+	t.Run("Libraries", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		var created handler.DataResponseLibrary
+		c.expect(http.StatusCreated, http.MethodPost, "/libraries/", admin, nil, map[string]string{"slug": "archive", "name": "Archive"}, &created)
+		if created.Data == nil || created.Data.Slug != "archive" {
+			t.Fatalf("created library = %+v", created)
+		}
+		c.expect(http.StatusBadRequest, http.MethodPost, "/libraries/", admin, nil, map[string]string{"name": "No slug"}, nil)
+
+		var libraries handler.DataResponseLibraries
+		c.expect(http.StatusOK, http.MethodGet, "/libraries/", admin, nil, nil, &libraries)
+		slugs := map[string]bool{}
+		for _, l := range libraries.Data {
+			slugs[l.Slug] = true
+		}
+		if !slugs["default"] || !slugs["archive"] {
+			t.Fatalf("libraries = %+v", libraries.Data)
+		}
+
+		// The copies are made by the copier, not by the author of the songs.
+		copier := c.apiKey("copier", []string{auth.ScopeAdmin}, "")
+		var copied handler.CopySongsResponse
+		c.expect(http.StatusOK, http.MethodPost, "/libraries/default/copy", copier, nil, map[string]interface{}{"target": "archive"}, &copied)
+		if copied.Copied != 1 {
+			t.Fatalf("copied %d songs, want 1", copied.Copied)
+		}
+		c.expect(http.StatusNotFound, http.MethodPost, "/libraries/missing/copy", admin, nil, map[string]interface{}{"target": "archive"}, nil)
+
+		archiveReader = c.apiKey("archive reader", []string{auth.ScopeSongsRead, auth.ScopeSongsInteract}, "archive")
+		archiveViewer = c.apiKey("archive viewer", []string{auth.ScopeSongsRead}, "archive")
+
+		archive := map[string]string{"X-Library": "archive"}
+		var list handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", archiveReader, archive, nil, &list)
+		if len(list.Data) != 1 || list.Data[0].Song != "Supermassive" || list.Data[0].ID == songID {
+			t.Fatalf("archive songs = %+v", list.Data)
+		}
+		if list.Data[0].CreatedBy != c.actor(copier) || list.Data[0].UpdatedBy != c.actor(copier) {
+			t.Fatalf("copied song by %q/%q, want %s", list.Data[0].CreatedBy, list.Data[0].UpdatedBy, c.actor(copier))
+		}
+
+		c.expect(http.StatusCreated, http.MethodPost, "/songs/add_song", admin, archive, map[string]string{"group": "Queen", "song": "Innuendo"}, nil)
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?group=Queen", reader, nil, nil, &list)
+		if len(list.Data) != 0 {
+			t.Fatalf("song added to archive shows in default: %+v", list.Data)
+		}
+
+		scoped := c.apiKey("archivist", []string{auth.ScopeSongsRead}, "archive")
+		c.expect(http.StatusForbidden, http.MethodGet, "/songs/", scoped, map[string]string{"X-Library": "default"}, nil, nil)
+
+		// An admin bound to a library stays inside it.
+		curator := c.apiKey("curator", []string{auth.ScopeAdmin}, "archive")
+		libraries = handler.DataResponseLibraries{}
+		c.expect(http.StatusOK, http.MethodGet, "/libraries/", curator, nil, nil, &libraries)
+		if len(libraries.Data) != 1 || libraries.Data[0].Slug != "archive" {
+			t.Fatalf("libraries of a bound admin = %+v", libraries.Data)
+		}
+		c.expect(http.StatusForbidden, http.MethodPost, "/libraries/", curator, nil, map[string]string{"slug": "rogue"}, nil)
+		c.expect(http.StatusForbidden, http.MethodPost, "/libraries/default/copy", curator, nil, map[string]interface{}{"target": "archive"}, nil)
+		c.expect(http.StatusForbidden, http.MethodPost, "/libraries/archive/copy", curator, nil, map[string]interface{}{"target": "default"}, nil)
+		c.expect(http.StatusNotFound, http.MethodGet, "/songs/", admin, map[string]string{"X-Library": "missing"}, nil, nil)
+
+		// Callers without the admin scope stay in the default library.
+		c.expect(http.StatusForbidden, http.MethodGet, "/songs/", reader, archive, nil, nil)
+		c.expect(http.StatusForbidden, http.MethodPost, "/songs/add_song", c.apiKey("writer", []string{auth.ScopeSongsWrite}, ""), archive, map[string]string{"group": "Queen", "song": "Innuendo"}, nil)
+		c.expect(http.StatusUnauthorized, http.MethodGet, "/songs/", "", archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", reader, map[string]string{"X-Library": "default"}, nil, nil)
+	})
+
+	t.Run("Playlists", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		archive := map[string]string{"X-Library": "archive"}
+		dj := c.apiKey("dj", []string{auth.ScopeSongsRead, auth.ScopeSongsWrite}, "archive")
+		guest := c.apiKey("guest", []string{auth.ScopeSongsRead, auth.ScopeSongsWrite}, "archive")
+
+		var songs handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 2 {
+			t.Fatalf("archive songs = %+v", songs.Data)
+		}
+
+		var created handler.DataResponsePlaylist
+		c.expect(http.StatusCreated, http.MethodPost, "/playlists/", dj, archive, map[string]string{"name": "Road trip"}, &created)
+		if created.Data == nil || created.Data.Visibility != models.PlaylistPrivate || created.Data.Owner != c.actor(dj) {
+			t.Fatalf("created playlist = %+v", created.Data)
+		}
+		base := fmt.Sprintf("/playlists/%d", created.Data.ID)
+		c.expect(http.StatusBadRequest, http.MethodPost, "/playlists/", dj, archive, map[string]string{"name": ""}, nil)
+		c.expect(http.StatusForbidden, http.MethodPost, "/playlists/", archiveReader, archive, map[string]string{"name": "Nope"}, nil)
+
+		var playlist handler.DataResponsePlaylist
+		for _, song := range songs.Data {
+			c.expect(http.StatusCreated, http.MethodPost, base+"/entries", dj, archive, map[string]int{"song_id": song.ID}, &playlist)
+		}
+		c.expect(http.StatusBadRequest, http.MethodPost, base+"/entries", dj, archive, map[string]int{"song_id": songID}, nil)
+		if len(playlist.Data.Entries) != 2 || playlist.Data.Entries[0].SongID != songs.Data[0].ID {
+			t.Fatalf("entries = %+v", playlist.Data.Entries)
+		}
+
+		last := playlist.Data.Entries[1]
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("%s/entries/%d/position", base, last.ID), dj, archive, map[string]int{"position": 1}, &playlist)
+		if playlist.Data.Entries[0].ID != last.ID || playlist.Data.Entries[0].Position != 1 {
+			t.Fatalf("after move = %+v", playlist.Data.Entries)
+		}
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("%s/entries/%d/position", base, last.ID), dj, archive, map[string]int{"position": 3}, nil)
+
+		c.expect(http.StatusNotFound, http.MethodGet, base, guest, archive, nil, nil)
+		// A key with the same name is someone else.
+		namesake := c.apiKey("dj", []string{auth.ScopeSongsRead, auth.ScopeSongsWrite}, "archive")
+		c.expect(http.StatusNotFound, http.MethodGet, base, namesake, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, base, admin, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodGet, base, admin, nil, nil, nil)
+		c.expect(http.StatusOK, http.MethodPut, base, dj, archive, map[string]string{"visibility": models.PlaylistPublic}, nil)
+		c.expect(http.StatusOK, http.MethodGet, base, guest, archive, nil, nil)
+		c.expect(http.StatusForbidden, http.MethodPut, base, guest, archive, map[string]string{"name": "Mine now"}, nil)
+
+		var lists handler.DataResponsePlaylists
+		c.expect(http.StatusOK, http.MethodGet, "/playlists/", guest, archive, nil, &lists)
+		if len(lists.Data) != 1 || lists.Data[0].ID != created.Data.ID {
+			t.Fatalf("guest playlists = %+v", lists.Data)
+		}
+
+		var copied handler.DataResponsePlaylist
+		c.expect(http.StatusCreated, http.MethodPost, base+"/duplicate", guest, archive, nil, &copied)
+		if copied.Data.Owner != c.actor(guest) || copied.Data.Name != "Road trip (copy)" || len(copied.Data.Entries) != 2 {
+			t.Fatalf("duplicate = %+v", copied.Data)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, base+"/export?format=m3u", nil)
+		req.Header.Set("X-API-Key", guest)
+		req.Header.Set("X-Library", "archive")
+		resp, err := c.srv.App.Test(req, -1)
+		if err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "#EXTM3U") || !strings.Contains(string(body), "https://example.com/innuendo") {
+			t.Fatalf("export: status %d, body %q", resp.StatusCode, body)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, base+"/export?format=pls", guest, archive, nil, nil)
+
+		c.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("%s/entries/%d", base, last.ID), dj, archive, nil, &playlist)
+		if len(playlist.Data.Entries) != 1 {
+			t.Fatalf("after remove = %+v", playlist.Data.Entries)
+		}
+		c.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("%s/entries/%d", base, last.ID), dj, archive, nil, nil)
+
+		c.expect(http.StatusForbidden, http.MethodDelete, base, guest, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodDelete, base, dj, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodGet, base, dj, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 2 {
+			t.Fatalf("deleting a playlist removed songs: %+v", songs.Data)
+		}
+	})
+
+	t.Run("Listening", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		archive := map[string]string{"X-Library": "archive"}
+		authSvc, err := service.NewApiAuthService(c.srv.Storage.Store, c.srv.logger, c.srv.config)
+		if err != nil {
+			t.Fatalf("NewApiAuthService: %v", err)
+		}
+		if _, err := authSvc.CreateUser(context.Background(), "alice", "correct horse", auth.RoleReader, "archive"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+
+		c.expect(http.StatusUnauthorized, http.MethodPost, "/auth/login", "", nil, map[string]string{"username": "alice", "password": "wrong password"}, nil)
+		c.expect(http.StatusUnauthorized, http.MethodPost, "/auth/login", "", nil, map[string]string{"username": "bob", "password": "correct horse"}, nil)
+		var login handler.LoginResponse
+		c.expect(http.StatusOK, http.MethodPost, "/auth/login", "", nil, map[string]string{"username": "alice", "password": "correct horse"}, &login)
+		if !auth.IsSessionToken(login.Token) || !login.ExpiresAt.After(time.Now()) {
+			t.Fatalf("login = %+v", login)
+		}
+		alice := login.Token
+
+		var me handler.DataResponsePrincipal
+		c.expect(http.StatusOK, http.MethodGet, "/me/", alice, nil, nil, &me)
+		if me.Data == nil || me.Data.Actor() != auth.MethodUser+":alice" || me.Data.Library != "archive" {
+			t.Fatalf("me = %+v", me.Data)
+		}
+		c.expect(http.StatusForbidden, http.MethodPost, "/songs/add_song", alice, nil, map[string]string{"group": "Muse", "song": "Supermassive"}, nil)
+
+		var songs handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", alice, archive, nil, &songs)
+		if len(songs.Data) != 2 {
+			t.Fatalf("archive songs = %+v", songs.Data)
+		}
+		first, second := songs.Data[0], songs.Data[1]
+
+		for _, id := range []int{second.ID, first.ID, second.ID} {
+			c.expect(http.StatusCreated, http.MethodPost, fmt.Sprintf("/songs/%d/plays", id), alice, archive, nil, nil)
+		}
+		c.expect(http.StatusCreated, http.MethodPost, fmt.Sprintf("/songs/%d/plays", first.ID), archiveReader, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodPost, "/songs/999999/plays", alice, archive, nil, nil)
+		c.expect(http.StatusForbidden, http.MethodPost, fmt.Sprintf("/songs/%d/plays", first.ID), archiveViewer, archive, nil, nil)
+		c.expect(http.StatusForbidden, http.MethodPut, fmt.Sprintf("/songs/%d/favorite", first.ID), archiveViewer, archive, nil, nil)
+		c.expect(http.StatusUnauthorized, http.MethodPost, fmt.Sprintf("/songs/%d/plays", first.ID), "", archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/songs/%d/plays", first.ID), reader, nil, nil, nil)
+		// A user bound to a library cannot leave it.
+		c.expect(http.StatusForbidden, http.MethodGet, "/songs/", alice, map[string]string{"X-Library": "default"}, nil, nil)
+
+		var history handler.DataResponsePlays
+		c.expect(http.StatusOK, http.MethodGet, "/me/history?limit=2", alice, archive, nil, &history)
+		if len(history.Data) != 2 || history.Data[0].SongID != second.ID || history.Data[1].SongID != first.ID {
+			t.Fatalf("history = %+v", history.Data)
+		}
+
+		var top handler.DataResponseTop
+		c.expect(http.StatusOK, http.MethodGet, "/me/top?window=7d", alice, archive, nil, &top)
+		if top.Data == nil || top.Data.Since == nil || len(top.Data.Songs) != 2 || top.Data.Songs[0].SongID != second.ID || top.Data.Songs[0].Plays != 2 {
+			t.Fatalf("top = %+v", top.Data)
+		}
+		if len(top.Data.Groups) == 0 || top.Data.Groups[0].Plays < 2 {
+			t.Fatalf("top groups = %+v", top.Data.Groups)
+		}
+		top = handler.DataResponseTop{}
+		c.expect(http.StatusOK, http.MethodGet, "/me/top?window=all&limit=1", alice, archive, nil, &top)
+		if top.Data == nil || top.Data.Since != nil || len(top.Data.Songs) != 1 {
+			t.Fatalf("all time top = %+v", top.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/me/top?window=soon", alice, archive, nil, nil)
+
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?sort=-play_count", alice, archive, nil, &songs)
+		if len(songs.Data) != 2 || songs.Data[0].PlayCount != 2 || songs.Data[1].PlayCount != 2 {
+			t.Fatalf("sorted by play count = %+v", songs.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/?sort=name", alice, archive, nil, nil)
+
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/favorite", first.ID), alice, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/favorite", first.ID), alice, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodPut, "/songs/999999/favorite", alice, archive, nil, nil)
+		var favorites handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/me/favorites", alice, archive, nil, &favorites)
+		if len(favorites.Data) != 1 || favorites.Data[0].ID != first.ID {
+			t.Fatalf("favorites = %+v", favorites.Data)
+		}
+		c.expect(http.StatusOK, http.MethodGet, "/me/favorites", archiveReader, archive, nil, &favorites)
+		if len(favorites.Data) != 0 {
+			t.Fatalf("another caller's favorites = %+v", favorites.Data)
+		}
+		c.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/songs/%d/favorite", first.ID), alice, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, "/me/favorites", alice, archive, nil, &favorites)
+		if len(favorites.Data) != 0 {
+			t.Fatalf("favorites after removal = %+v", favorites.Data)
+		}
+
+		c.expect(http.StatusBadRequest, http.MethodPost, "/auth/logout", reader, nil, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/auth/logout", alice, nil, nil, nil)
+		c.expect(http.StatusUnauthorized, http.MethodGet, "/me/", alice, nil, nil, nil)
+	})
+
+	t.Run("Reviews", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		archive := map[string]string{"X-Library": "archive"}
+		critic := c.apiKey("critic", []string{auth.ScopeSongsRead, auth.ScopeSongsInteract}, "archive")
+
+		var songs handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 2 {
+			t.Fatalf("archive songs = %+v", songs.Data)
+		}
+		first, second := songs.Data[0], songs.Data[1]
+
+		var review handler.DataResponseReview
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/review", first.ID), critic, archive, map[string]interface{}{"rating": 2, "body": "Overplayed"}, &review)
+		if review.Data == nil || review.Data.Status != models.ReviewPending || review.Data.Actor != c.actor(critic) {
+			t.Fatalf("review = %+v", review.Data)
+		}
+		pending := review.Data.ID
+		c.expect(http.StatusForbidden, http.MethodPut, fmt.Sprintf("/songs/%d/review", first.ID), archiveViewer, archive, map[string]int{"rating": 1}, nil)
+		c.expect(http.StatusForbidden, http.MethodDelete, fmt.Sprintf("/songs/%d/review", first.ID), archiveViewer, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/review", first.ID), archiveReader, archive, map[string]int{"rating": 5}, &review)
+		if review.Data.Status != models.ReviewApproved {
+			t.Fatalf("rating without a body = %+v", review.Data)
+		}
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/review", second.ID), archiveReader, archive, map[string]int{"rating": 4}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/songs/%d/review", first.ID), critic, archive, map[string]int{"rating": 6}, nil)
+		c.expect(http.StatusNotFound, http.MethodPut, "/songs/999999/review", critic, archive, map[string]int{"rating": 3}, nil)
+
+		var song handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", first.ID), archiveReader, archive, nil, &song)
+		if song.Data.Rating != 5 || song.Data.RatingCount != 1 {
+			t.Fatalf("rating with a pending review = %v of %d, want 5 of 1", song.Data.Rating, song.Data.RatingCount)
+		}
+
+		var reviews handler.DataResponseReviews
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/reviews", first.ID), critic, archive, nil, &reviews)
+		if len(reviews.Data) != 1 || reviews.Data[0].Actor != c.actor(archiveReader) {
+			t.Fatalf("approved reviews = %+v", reviews.Data)
+		}
+		c.expect(http.StatusForbidden, http.MethodGet, fmt.Sprintf("/songs/%d/reviews?status=pending", first.ID), critic, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/review", first.ID), critic, archive, nil, &review)
+		if review.Data.ID != pending {
+			t.Fatalf("own review = %+v", review.Data)
+		}
+
+		c.expect(http.StatusForbidden, http.MethodGet, "/reviews/", critic, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, "/reviews/", admin, archive, nil, &reviews)
+		if len(reviews.Data) != 1 || reviews.Data[0].ID != pending {
+			t.Fatalf("moderation queue = %+v", reviews.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/reviews/%d/status", pending), admin, archive, map[string]string{"status": "deleted"}, nil)
+		c.expect(http.StatusNotFound, http.MethodPut, fmt.Sprintf("/reviews/%d/status", pending), admin, nil, map[string]string{"status": models.ReviewRejected}, nil)
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/reviews/%d/status", pending), admin, archive, map[string]string{"status": models.ReviewRejected}, &review)
+		if review.Data.Status != models.ReviewRejected {
+			t.Fatalf("moderated review = %+v", review.Data)
+		}
+
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?sort=-rating", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 2 || songs.Data[0].ID != first.ID || songs.Data[0].Rating != 5 || songs.Data[0].RatingCount != 1 {
+			t.Fatalf("sorted by rating = %+v", songs.Data)
+		}
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?min_rating=4.5", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 1 || songs.Data[0].ID != first.ID {
+			t.Fatalf("min_rating 4.5 = %+v", songs.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/?min_rating=lots", archiveReader, archive, nil, nil)
+
+		c.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/songs/%d/review", first.ID), archiveReader, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/songs/%d/review", first.ID), archiveReader, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", first.ID), archiveReader, archive, nil, &song)
+		if song.Data.Rating != 0 || song.Data.RatingCount != 0 {
+			t.Fatalf("rating after deleting the review = %v of %d", song.Data.Rating, song.Data.RatingCount)
+		}
+	})
+
+	t.Run("Annotations", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		fan := c.apiKey("fan", []string{auth.ScopeSongsRead, auth.ScopeSongsInteract}, "")
+
+		var glaciers, soul handler.DataResponseAnnotation
+		c.expect(http.StatusCreated, http.MethodPost, fmt.Sprintf("/songs/%d/annotations", songID), reader, nil,
+			map[string]interface{}{"verse": 1, "unit": models.AnchorLines, "start": 1, "end": 2, "body": "Global warming"}, &glaciers)
+		if glaciers.Data == nil || glaciers.Data.Quote != "Glaciers melting in the dead of night" || glaciers.Data.Author != c.actor(reader) {
+			t.Fatalf("line annotation = %+v", glaciers.Data)
+		}
+		c.expect(http.StatusCreated, http.MethodPost, fmt.Sprintf("/songs/%d/annotations", songID), fan, nil,
+			map[string]interface{}{"verse": 1, "unit": models.AnchorChars, "start": 11, "end": 15, "body": "Soul"}, &soul)
+		if soul.Data == nil || soul.Data.Quote != "soul" {
+			t.Fatalf("char annotation = %+v", soul.Data)
+		}
+		c.expect(http.StatusForbidden, http.MethodPost, fmt.Sprintf("/songs/%d/annotations", songID), viewer, nil,
+			map[string]interface{}{"verse": 1, "unit": models.AnchorLines, "start": 0, "end": 1, "body": "Read only"}, nil)
+		c.expect(http.StatusForbidden, http.MethodPut, fmt.Sprintf("/annotations/%d/vote", soul.Data.ID), viewer, nil, map[string]int{"value": 1}, nil)
+		c.expect(http.StatusForbidden, http.MethodDelete, fmt.Sprintf("/annotations/%d", soul.Data.ID), viewer, nil, nil, nil)
+		c.expect(http.StatusBadRequest, http.MethodPost, fmt.Sprintf("/songs/%d/annotations", songID), fan, nil,
+			map[string]interface{}{"verse": 5, "unit": models.AnchorLines, "start": 0, "end": 1, "body": "Nowhere"}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPost, fmt.Sprintf("/songs/%d/annotations", songID), fan, nil,
+			map[string]interface{}{"verse": 0, "unit": "word", "start": 0, "end": 1, "body": "Words"}, nil)
+		c.expect(http.StatusNotFound, http.MethodPost, "/songs/999999/annotations", fan, nil,
+			map[string]interface{}{"verse": 0, "unit": models.AnchorLines, "start": 0, "end": 1, "body": "Missing"}, nil)
+
+		var vote handler.DataResponseAnnotation
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/annotations/%d/vote", glaciers.Data.ID), fan, nil, map[string]int{"value": 1}, &vote)
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/annotations/%d/vote", glaciers.Data.ID), admin, nil, map[string]int{"value": 1}, &vote)
+		if vote.Data.Votes != 2 {
+			t.Fatalf("votes = %d, want 2", vote.Data.Votes)
+		}
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/annotations/%d/vote", glaciers.Data.ID), fan, nil, map[string]int{"value": 3}, nil)
+
+		c.expect(http.StatusForbidden, http.MethodPut, fmt.Sprintf("/annotations/%d", glaciers.Data.ID), fan, nil, map[string]string{"body": "Mine now"}, nil)
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/annotations/%d", glaciers.Data.ID), reader, nil, map[string]string{"body": "Climate"}, nil)
+
+		// A new first verse moves both annotations down one verse; the
+		// reworded line orphans the character annotation.
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/update_song/%d", songID), admin, nil, map[string]string{
+			"text": "Intro\n\nOoh baby, don't you know I suffer?\nOoh baby, can you hear me moan?\n\nYou set my heart alight\nGlaciers melting in the dead of night",
+		}, nil)
+
+		var annotations handler.DataResponseAnnotations
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/annotations", songID), reader, nil, nil, &annotations)
+		if len(annotations.Data) != 2 {
+			t.Fatalf("annotations after update = %+v", annotations.Data)
+		}
+		for _, a := range annotations.Data {
+			switch a.ID {
+			case glaciers.Data.ID:
+				if a.Verse != 2 || a.Start != 1 || a.Orphaned || a.Body != "Climate" || a.Votes != 2 {
+					t.Errorf("remapped line annotation = %+v", a)
+				}
+			case soul.Data.ID:
+				if !a.Orphaned {
+					t.Errorf("char annotation on a reworded line = %+v, want orphaned", a)
+				}
+			}
+		}
+
+		var song handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?limit=1&offset=2&annotations=true", songID), reader, nil, nil, &song)
+		if len(song.Data.Annotations) != 1 || song.Data.Annotations[0].ID != glaciers.Data.ID {
+			t.Fatalf("inlined annotations = %+v", song.Data.Annotations)
+		}
+		song = handler.DataResponseSong{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?limit=1&offset=1&annotations=true", songID), reader, nil, nil, &song)
+		if len(song.Data.Annotations) != 0 {
+			t.Fatalf("annotations of a verse without any = %+v", song.Data.Annotations)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?annotations=maybe", songID), reader, nil, nil, nil)
+
+		c.expect(http.StatusForbidden, http.MethodDelete, fmt.Sprintf("/annotations/%d", soul.Data.ID), reader, nil, nil, nil)
+		c.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/annotations/%d", soul.Data.ID), admin, nil, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/annotations/%d", soul.Data.ID), fan, nil, nil, nil)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		archive := map[string]string{"X-Library": "archive"}
+
+		var songs handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 2 {
+			t.Fatalf("archive songs = %+v", songs.Data)
+		}
+		first, second := songs.Data[0], songs.Data[1]
+
+		var tagged handler.TagSongsResponse
+		c.expect(http.StatusOK, http.MethodPost, "/songs/tags", admin, archive, map[string]interface{}{
+			"song_ids": []int{first.ID, second.ID},
+			"add":      []string{"genre:Rock", "mood:calm"},
+		}, &tagged)
+		if tagged.Added != 4 || tagged.Removed != 0 {
+			t.Fatalf("tagged = %+v", tagged)
+		}
+		c.expect(http.StatusOK, http.MethodPost, "/songs/tags", admin, archive, map[string]interface{}{
+			"song_ids": []int{second.ID},
+			"remove":   []string{"mood:calm"},
+		}, &tagged)
+		if tagged.Added != 0 || tagged.Removed != 1 {
+			t.Fatalf("untagged = %+v", tagged)
+		}
+		c.expect(http.StatusForbidden, http.MethodPost, "/songs/tags", archiveReader, archive, map[string]interface{}{
+			"song_ids": []int{first.ID}, "add": []string{"era:80s"},
+		}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPost, "/songs/tags", admin, archive, map[string]interface{}{
+			"song_ids": []int{first.ID}, "add": []string{"colour:red"},
+		}, nil)
+		c.expect(http.StatusNotFound, http.MethodPost, "/songs/tags", admin, archive, map[string]interface{}{
+			"song_ids": []int{first.ID, 999999}, "add": []string{"era:80s"},
+		}, nil)
+
+		var songTags handler.DataResponseSongTags
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/tags", first.ID), archiveReader, archive, nil, &songTags)
+		if len(songTags.Data) != 2 || songTags.Data[0] != (models.Tag{Type: models.TagGenre, Name: "rock"}) {
+			t.Fatalf("song tags = %+v", songTags.Data)
+		}
+		c.expect(http.StatusNotFound, http.MethodGet, "/songs/999999/tags", archiveReader, archive, nil, nil)
+
+		var tags handler.DataResponseTags
+		c.expect(http.StatusOK, http.MethodGet, "/tags/?type=genre", archiveReader, archive, nil, &tags)
+		if len(tags.Data) != 1 || tags.Data[0].Count != 2 {
+			t.Fatalf("genre tags = %+v", tags.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/tags/?type=colour", archiveReader, archive, nil, nil)
+
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?tags=genre:rock,mood:calm", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 1 || songs.Data[0].ID != first.ID {
+			t.Fatalf("songs with all tags = %+v", songs.Data)
+		}
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?tags=mood:calm,era:80s&tag_mode=any", archiveReader, archive, nil, &songs)
+		if len(songs.Data) != 1 || songs.Data[0].ID != first.ID {
+			t.Fatalf("songs with any tag = %+v", songs.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/?tags=rock", archiveReader, archive, nil, nil)
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/?tags=genre:rock&tag_mode=some", archiveReader, archive, nil, nil)
+
+		var facets handler.DataResponseFacets
+		c.expect(http.StatusOK, http.MethodGet, "/songs/facets?tags=genre:rock", archiveReader, archive, nil, &facets)
+		if facets.Data == nil || len(facets.Data.Tags) != 2 || facets.Data.Tags[0].Name != "rock" || facets.Data.Tags[0].Count != 2 || facets.Data.Tags[1].Count != 1 {
+			t.Fatalf("tag facets = %+v", facets.Data)
+		}
+		groups := 0
+		for _, group := range facets.Data.Groups {
+			groups += group.Count
+		}
+		if groups != 2 {
+			t.Fatalf("group facets = %+v", facets.Data.Groups)
+		}
+		facets = handler.DataResponseFacets{}
+		c.expect(http.StatusOK, http.MethodGet, "/songs/facets?tags=era:80s&limit=1", archiveReader, archive, nil, &facets)
+		if facets.Data == nil || len(facets.Data.Tags) != 0 || len(facets.Data.Years) != 0 || len(facets.Data.Groups) != 0 {
+			t.Fatalf("facets without matches = %+v", facets.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/facets?limit=0", archiveReader, archive, nil, nil)
+	})
+
+	t.Run("Translations", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		// The song has three verses since the annotations test.
+		var translation handler.DataResponseTranslation
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/translations/ru", songID), admin, nil, map[string]interface{}{
+			"text":       "Вступление\n\nО, детка, разве ты не знаешь, что я страдаю?\n\nТы зажгла мое сердце",
+			"translator": "Anna",
+		}, &translation)
+		if translation.Data == nil || translation.Data.Language != "ru" || !reflect.DeepEqual(translation.Data.Alignment, []int{0, 1, 2}) {
+			t.Fatalf("translation = %+v", translation.Data)
+		}
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/translations/pt-br", songID), admin, nil, map[string]interface{}{
+			"text":       "Você incendiou meu coração",
+			"translator": "Bia",
+			"alignment":  []int{2},
+		}, &translation)
+		if translation.Data.Language != "pt-BR" {
+			t.Fatalf("canonical language = %q, want pt-BR", translation.Data.Language)
+		}
+		c.expect(http.StatusForbidden, http.MethodPut, fmt.Sprintf("/songs/%d/translations/de", songID), reader, nil,
+			map[string]string{"text": "Einleitung", "translator": "Max"}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/songs/%d/translations/toolongtag", songID), admin, nil,
+			map[string]string{"text": "x", "translator": "x"}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/songs/%d/translations/de", songID), admin, nil,
+			map[string]interface{}{"text": "Einleitung", "translator": "Max", "alignment": []int{0, 1}}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPut, fmt.Sprintf("/songs/%d/translations/de", songID), admin, nil,
+			map[string]string{"text": "Einleitung"}, nil)
+		c.expect(http.StatusNotFound, http.MethodPut, "/songs/999999/translations/de", admin, nil,
+			map[string]string{"text": "Einleitung", "translator": "Max"}, nil)
+
+		var translations handler.DataResponseTranslations
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/translations", songID), reader, nil, nil, &translations)
+		if len(translations.Data) != 2 || translations.Data[0].Language != "pt-BR" || translations.Data[1].Translator != "Anna" {
+			t.Fatalf("translations = %+v", translations.Data)
+		}
+
+		var verses handler.DataResponseVerses
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/verses?lang=ru&with=original&offset=1&limit=1", songID), reader, nil, nil, &verses)
+		if verses.Data == nil || verses.Data.Language != "ru" || len(verses.Data.Verses) != 1 {
+			t.Fatalf("verses = %+v", verses.Data)
+		}
+		verse := verses.Data.Verses[0]
+		if verse.Index != 1 || verse.OriginalVerse == nil || *verse.OriginalVerse != 1 || !strings.HasPrefix(verse.Original, "Ooh baby") {
+			t.Fatalf("paired verse = %+v", verse)
+		}
+
+		verses = handler.DataResponseVerses{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/verses", songID), reader,
+			map[string]string{"Accept-Language": "fr-FR, pt;q=0.8, ru;q=0.5"}, nil, &verses)
+		if verses.Data.Language != "pt-BR" || verses.Data.Translator != "Bia" || len(verses.Data.Verses) != 1 || verses.Data.Verses[0].OriginalVerse != nil {
+			t.Fatalf("negotiated verses = %+v", verses.Data)
+		}
+		verses = handler.DataResponseVerses{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/verses", songID), reader,
+			map[string]string{"Accept-Language": "fr"}, nil, &verses)
+		if verses.Data.Language != "" || len(verses.Data.Verses) != 3 || verses.Data.Verses[0].Text != "Intro" {
+			t.Fatalf("verses without a matching translation = %+v", verses.Data)
+		}
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/%d/verses?lang=fr", songID), reader, nil, nil, nil)
+		c.expect(http.StatusBadRequest, http.MethodGet, fmt.Sprintf("/songs/%d/verses?with=everything", songID), reader, nil, nil, nil)
+		c.expect(http.StatusBadRequest, http.MethodGet, fmt.Sprintf("/songs/%d/verses?lang=ru&offset=10", songID), reader, nil, nil, nil)
+
+		c.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/songs/%d/translations/pt-BR", songID), admin, nil, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/songs/%d/translations/pt-BR", songID), admin, nil, nil, nil)
+	})
+
+	t.Run("Language", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+		archive := map[string]string{"X-Library": "archive"}
+
+		var added handler.DataResponseSong
+		c.expect(http.StatusCreated, http.MethodPost, "/songs/add_song", admin, archive, map[string]string{"group": "Кино", "song": "Кукушка"}, &added)
+		if added.Data == nil || added.Data.Language != "ru" || added.Data.LanguageConfidence <= 0 {
+			t.Fatalf("added song = %+v", added.Data)
+		}
+		kinoID := added.Data.ID
+
+		var list handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?language=ru", archiveReader, archive, nil, &list)
+		if len(list.Data) != 1 || list.Data[0].ID != kinoID {
+			t.Fatalf("songs in ru = %+v", list.Data)
+		}
+		list = handler.DataResponseSongs{}
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?language=en&min_language_confidence=0.1", archiveReader, archive, nil, &list)
+		for _, song := range list.Data {
+			if song.Language != "en" || song.LanguageConfidence < 0.1 {
+				t.Fatalf("songs in en = %+v", list.Data)
+			}
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/?min_language_confidence=2", archiveReader, archive, nil, nil)
+
+		// The lyrics are found in either script.
+		list = handler.DataResponseSongs{}
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?text=%25skazhi,%20kukushka%25", archiveReader, archive, nil, &list)
+		if len(list.Data) != 1 || list.Data[0].ID != kinoID {
+			t.Fatalf("songs matching latin text = %+v", list.Data)
+		}
+
+		var song handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?transliterate=gost&limit=1", kinoID), archiveReader, archive, nil, &song)
+		if song.Data == nil || song.Data.Text != "Pesen eshhyo nenapisanny`x skol`ko?\nSkazhi, kukushka, propoj" {
+			t.Fatalf("transliterated song = %+v", song.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?transliterate=klingon", kinoID), archiveReader, archive, nil, nil)
+
+		// The original takes part in language negotiation.
+		var verses handler.DataResponseVerses
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/verses?lang=ru&transliterate=iso9", kinoID), archiveReader, archive, nil, &verses)
+		if verses.Data == nil || verses.Data.Language != "" || verses.Data.OriginalLanguage != "ru" || verses.Data.Transliteration != "iso9" ||
+			len(verses.Data.Verses) != 2 || verses.Data.Verses[1].Text != "V gorode mne žitʹ ili na vyselkah" {
+			t.Fatalf("transliterated verses = %+v", verses.Data)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, fmt.Sprintf("/songs/%d/verses?transliterate=klingon", kinoID), archiveReader, archive, nil, nil)
+	})
+
+	t.Run("Explicit", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+		archive := map[string]string{"X-Library": "archive"}
+		exclude := c.cleanApiKey("exclude", []string{auth.ScopeSongsRead, auth.ScopeSongsInteract}, "archive", auth.CleanModeExclude)
+		mask := c.cleanApiKey("mask", []string{auth.ScopeSongsRead}, "archive", auth.CleanModeMask)
+
+		var added handler.DataResponseSong
+		c.expect(http.StatusCreated, http.MethodPost, "/songs/add_song", admin, archive, map[string]string{"group": "Rapper", "song": "Again"}, &added)
+		if added.Data == nil || !added.Data.Explicit || added.Data.ExplicitOverride != nil {
+			t.Fatalf("added song = %+v", added.Data)
+		}
+		explicitID := added.Data.ID
+
+		listed := func(key, query string) map[int]bool {
+			t.Helper()
+			var list handler.DataResponseSongs
+			c.expect(http.StatusOK, http.MethodGet, "/songs/?limit=100"+query, key, archive, nil, &list)
+			ids := map[int]bool{}
+			for _, song := range list.Data {
+				ids[song.ID] = true
+			}
+			return ids
+		}
+		if !listed(archiveReader, "&explicit=true")[explicitID] || listed(archiveReader, "&explicit=false")[explicitID] {
+			t.Fatalf("explicit filter does not tell song %d apart", explicitID)
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/songs/?explicit=maybe", archiveReader, archive, nil, nil)
+
+		// Clean keys never see explicit songs.
+		if ids := listed(exclude, ""); ids[explicitID] || len(ids) == 0 {
+			t.Fatalf("songs for a clean key = %v", ids)
+		}
+		if ids := listed(exclude, "&explicit=true"); ids[explicitID] {
+			t.Fatalf("explicit songs for a clean key = %v", ids)
+		}
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", explicitID), exclude, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/%d/verses", explicitID), mask, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", explicitID), archiveReader, archive, nil, nil)
+
+		// Overriding the flag lets clean keys see the song, masked if asked.
+		c.expect(http.StatusForbidden, http.MethodPut, fmt.Sprintf("/songs/%d/explicit", explicitID), archiveReader, archive, map[string]interface{}{"explicit": false}, nil)
+		c.expect(http.StatusNotFound, http.MethodPut, "/songs/999999/explicit", admin, archive, map[string]interface{}{"explicit": false}, nil)
+		var song handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/explicit", explicitID), admin, archive, map[string]interface{}{"explicit": false}, &song)
+		if song.Data == nil || song.Data.Explicit || song.Data.ExplicitOverride == nil || *song.Data.ExplicitOverride {
+			t.Fatalf("overridden song = %+v", song.Data)
+		}
+		song = handler.DataResponseSong{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?limit=1", explicitID), mask, archive, nil, &song)
+		if song.Data == nil || song.Data.Text != "Oh s***, here we go again\nNo more lies" {
+			t.Fatalf("masked song = %+v", song.Data)
+		}
+		song = handler.DataResponseSong{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d?limit=1", explicitID), exclude, archive, nil, &song)
+		if song.Data == nil || song.Data.Text != "Oh shit, here we go again\nNo more lies" {
+			t.Fatalf("song for a key that does not mask = %+v", song.Data)
+		}
+
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/favorite", explicitID), exclude, archive, nil, nil)
+		var annotation handler.DataResponseAnnotation
+		c.expect(http.StatusCreated, http.MethodPost, fmt.Sprintf("/songs/%d/annotations", explicitID), admin, archive,
+			map[string]interface{}{"verse": 0, "unit": models.AnchorLines, "start": 0, "end": 1, "body": "Catchphrase"}, &annotation)
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/translations/de", explicitID), admin, archive,
+			map[string]string{"text": "Oh shit, nicht schon wieder", "translator": "Max"}, nil)
+
+		// Every path to the lyrics masks them.
+		var list handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?limit=100", mask, archive, nil, &list)
+		for _, song := range list.Data {
+			if song.ID == explicitID && strings.Contains(song.Text, "shit") {
+				t.Fatalf("listed song for a masking key = %+v", song)
+			}
+		}
+		var verses handler.DataResponseVerses
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/verses?limit=1", explicitID), mask, archive, nil, &verses)
+		if verses.Data == nil || len(verses.Data.Verses) != 1 || verses.Data.Verses[0].Text != "Oh s***, here we go again\nNo more lies" {
+			t.Fatalf("masked verses = %+v", verses.Data)
+		}
+		verses = handler.DataResponseVerses{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/verses?lang=de&with=original", explicitID), mask, archive, nil, &verses)
+		if verses.Data == nil || len(verses.Data.Verses) != 1 || verses.Data.Verses[0].Text != "Oh s***, nicht schon wieder" ||
+			verses.Data.Verses[0].Original != "Oh s***, here we go again\nNo more lies" {
+			t.Fatalf("masked translation = %+v", verses.Data)
+		}
+		var translations handler.DataResponseTranslations
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/translations", explicitID), mask, archive, nil, &translations)
+		if len(translations.Data) != 1 || translations.Data[0].Text != "Oh s***, nicht schon wieder" {
+			t.Fatalf("masked translations = %+v", translations.Data)
+		}
+		var annotations handler.DataResponseAnnotations
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/annotations", explicitID), mask, archive, nil, &annotations)
+		if len(annotations.Data) != 1 || annotations.Data[0].Quote != "Oh s***, here we go again" {
+			t.Fatalf("masked annotations = %+v", annotations.Data)
+		}
+
+		// Translations are checked too: with a clean text the song stays
+		// explicit for its translation.
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/update_song/%d", explicitID), admin, archive,
+			map[string]string{"text": "Oh dear, here we go again\nNo more lies"}, nil)
+
+		// Dropping the override hands the flag back to the wordlist.
+		song = handler.DataResponseSong{}
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/explicit", explicitID), admin, archive, map[string]interface{}{"explicit": nil}, &song)
+		if song.Data == nil || !song.Data.Explicit || song.Data.ExplicitOverride != nil {
+			t.Fatalf("song without override = %+v", song.Data)
+		}
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", explicitID), mask, archive, nil, nil)
+
+		// Nor does any other read path lead to it.
+		var favorites handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/me/favorites", exclude, archive, nil, &favorites)
+		if len(favorites.Data) != 0 {
+			t.Fatalf("favorites for a clean key = %+v", favorites.Data)
+		}
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/%d/annotations", explicitID), exclude, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/%d/translations", explicitID), exclude, archive, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/%d/tags", explicitID), exclude, archive, nil, nil)
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/%d/translations", explicitID), archiveReader, archive, nil, nil)
+
+		// A clean-mode editor may make a song explicit, which then hides it
+		// from them.
+		editor := c.cleanApiKey("editor", []string{auth.ScopeSongsRead, auth.ScopeSongsWrite}, "archive", auth.CleanModeExclude)
+		added = handler.DataResponseSong{}
+		c.expect(http.StatusCreated, http.MethodPost, "/songs/add_song", admin, archive, map[string]string{"group": "Sunny", "song": "Day"}, &added)
+		if added.Data == nil || added.Data.Explicit {
+			t.Fatalf("added song = %+v", added.Data)
+		}
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/update_song/%d", added.Data.ID), editor, archive,
+			map[string]string{"text": "Nothing but shit"}, nil)
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", added.Data.ID), editor, archive, nil, nil)
+		song = handler.DataResponseSong{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", added.Data.ID), archiveReader, archive, nil, &song)
+		if song.Data == nil || !song.Data.Explicit {
+			t.Fatalf("song made explicit = %+v", song.Data)
+		}
+
+		// Reordering a playlist around entries hidden from the editor
+		// renumbers those too.
+		var clean []int
+		for id := range listed(exclude, "") {
+			clean = append(clean, id)
+		}
+		sort.Ints(clean)
+		var mix handler.DataResponsePlaylist
+		c.expect(http.StatusCreated, http.MethodPost, "/playlists/", editor, archive, map[string]string{"name": "Mixed"}, &mix)
+		mixBase := fmt.Sprintf("/playlists/%d", mix.Data.ID)
+		c.expect(http.StatusCreated, http.MethodPost, mixBase+"/entries", admin, archive, map[string]int{"song_id": explicitID}, nil)
+		c.expect(http.StatusCreated, http.MethodPost, mixBase+"/entries", editor, archive, map[string]int{"song_id": clean[0]}, nil)
+		c.expect(http.StatusCreated, http.MethodPost, mixBase+"/entries", editor, archive, map[string]int{"song_id": clean[1]}, &mix)
+		if len(mix.Data.Entries) != 2 {
+			t.Fatalf("entries for a clean editor = %+v", mix.Data.Entries)
+		}
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("%s/entries/%d/position", mixBase, mix.Data.Entries[1].ID), editor, archive, map[string]int{"position": 1}, nil)
+		mix = handler.DataResponsePlaylist{}
+		c.expect(http.StatusOK, http.MethodGet, mixBase, admin, archive, nil, &mix)
+		var order []int
+		for i, entry := range mix.Data.Entries {
+			if entry.Position != i+1 {
+				t.Fatalf("positions after reorder = %+v", mix.Data.Entries)
+			}
+			order = append(order, entry.SongID)
+		}
+		if len(order) != 3 || order[0] != explicitID || order[1] != clean[1] || order[2] != clean[0] {
+			t.Fatalf("songs after reorder = %v, want [%d %d %d]", order, explicitID, clean[1], clean[0])
+		}
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		archive := map[string]string{"X-Library": "archive"}
+		c.expect(http.StatusCreated, http.MethodPost, "/songs/add_song", admin, archive, map[string]string{"group": "Queen", "song": "Innuendo"}, nil)
+		var list handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/?group=Queen&sort=id", admin, archive, nil, &list)
+		if len(list.Data) != 2 {
+			t.Fatalf("archive Queen songs = %+v", list.Data)
+		}
+		target, duplicate := list.Data[0], list.Data[1]
+
+		c.expect(http.StatusCreated, http.MethodPost, fmt.Sprintf("/songs/%d/plays", duplicate.ID), archiveReader, nil, nil, nil)
+		c.expect(http.StatusOK, http.MethodPut, fmt.Sprintf("/songs/%d/favorite", duplicate.ID), archiveReader, nil, nil, nil)
+
+		merger := c.apiKey("merger", []string{auth.ScopeSongsRead, auth.ScopeSongsDelete}, "archive")
+		path := fmt.Sprintf("/songs/%d/merge", target.ID)
+		c.expect(http.StatusForbidden, http.MethodPost, path, archiveViewer, nil, map[string]interface{}{"source_ids": []int{duplicate.ID}}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPost, path, merger, nil, map[string]interface{}{"source_ids": []int{}}, nil)
+		c.expect(http.StatusBadRequest, http.MethodPost, path, merger, nil, map[string]interface{}{"source_ids": []int{target.ID}}, nil)
+		c.expect(http.StatusNotFound, http.MethodPost, path, merger, nil, map[string]interface{}{"source_ids": []int{duplicate.ID, 999999}}, nil)
+		c.expect(http.StatusNotFound, http.MethodPost, fmt.Sprintf("/songs/%d/merge", songID), merger, nil, map[string]interface{}{"source_ids": []int{duplicate.ID}}, nil)
+
+		var merged handler.DataResponseSong
+		c.expect(http.StatusOK, http.MethodPost, path, merger, nil, map[string]interface{}{"source_ids": []int{duplicate.ID}}, &merged)
+		if merged.Data == nil || merged.Data.ID != target.ID || merged.Data.PlayCount != target.PlayCount+1 {
+			t.Fatalf("merged song = %+v, want %d with %d plays", merged.Data, target.ID, target.PlayCount+1)
+		}
+		c.expect(http.StatusNotFound, http.MethodGet, fmt.Sprintf("/songs/get_song/%d", duplicate.ID), merger, nil, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodPost, path, merger, nil, map[string]interface{}{"source_ids": []int{duplicate.ID}}, nil)
+
+		var favorites handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/me/favorites", archiveReader, nil, nil, &favorites)
+		favorited := map[int]bool{}
+		for _, song := range favorites.Data {
+			favorited[song.ID] = true
+		}
+		if !favorited[target.ID] || favorited[duplicate.ID] {
+			t.Fatalf("favorites after merge = %+v, want %d instead of %d", favorites.Data, target.ID, duplicate.ID)
+		}
+
+		for _, id := range []int{target.ID, duplicate.ID} {
+			var events handler.DataResponseAuditEvents
+			c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/audit/?entity=song&entity_id=%d&action=merge", id), admin, archive, nil, &events)
+			if len(events.Data) != 1 || events.Data[0].Actor != c.actor(merger) {
+				t.Errorf("merge events of song %d = %+v", id, events.Data)
+			}
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		c.expect(http.StatusForbidden, http.MethodDelete, fmt.Sprintf("/songs/delete_song/%d", songID), reader, nil, nil, nil)
+		c.expect(http.StatusOK, http.MethodDelete, fmt.Sprintf("/songs/delete_song/%d", songID), admin, nil, nil, nil)
+		c.expect(http.StatusNotFound, http.MethodDelete, fmt.Sprintf("/songs/delete_song/%d", songID), admin, nil, nil, nil)
+
+		var list handler.DataResponseSongs
+		c.expect(http.StatusOK, http.MethodGet, "/songs/", reader, nil, nil, &list)
+		if len(list.Data) != 0 {
+			t.Fatalf("deleted song still listed: %+v", list.Data)
+		}
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		var events handler.DataResponseAuditEvents
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/audit/?entity=song&entity_id=%d", songID), admin, nil, nil, &events)
+		actions := map[string]bool{}
+		for _, e := range events.Data {
+			actions[e.Action] = true
+			if e.Actor != c.actor(admin) {
+				t.Errorf("event %d actor = %q", e.ID, e.Actor)
+			}
+		}
+		for _, action := range []string{"create", "update", "delete"} {
+			if !actions[action] {
+				t.Errorf("no %s event for song %d: %+v", action, songID, events.Data)
+			}
+		}
+		c.expect(http.StatusBadRequest, http.MethodGet, "/audit/?entity_id=abc", admin, nil, nil, nil)
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		resp, err := c.srv.App.Test(req, -1)
+		if err != nil {
+			t.Fatalf("GET /metrics: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /metrics: status %d", resp.StatusCode)
+		}
+		if !bytes.Contains(body, []byte("http_request_duration_seconds")) {
+			t.Errorf("metrics have no request histogram:\n%s", body)
+		}
+	})
+
+	t.Run("Docs", func(t *testing.T) {
+		c := &testClient{t: t, srv: c.srv}
+
+		var spec struct {
+			Swagger string                 `json:"swagger"`
+			Paths   map[string]interface{} `json:"paths"`
+		}
+		c.expect(http.StatusOK, http.MethodGet, "/docs/swagger.json", "", nil, nil, &spec)
+		if _, ok := spec.Paths["/songs/add_song"]; !ok {
+			t.Errorf("swagger.json has no /songs/add_song: %v", spec.Paths)
+		}
+		c.expect(http.StatusOK, http.MethodGet, "/swagger/index.html", "", nil, nil, nil)
+	})
+}
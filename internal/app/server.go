@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/middleware"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/routes"
+	"github.com/VadimBorzenkov/online-song-library/internal/metrics"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/VadimBorzenkov/online-song-library/pkg/explicit"
+	"github.com/VadimBorzenkov/online-song-library/pkg/ratelimit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// Server is the fully wired application: the Fiber app with all routes and
+// the storage and workers behind it. It does not listen by itself, so tests
+// can drive App directly with App.Test.
+type Server struct {
+	App     *fiber.App
+	Storage *Storage
+
+	config  *config.Config
+	logger  *logrus.Logger
+	closers []func()
+}
+
+// NewServer opens the storage and builds the services, handlers and routes.
+// Call Close when done, also after an error.
+func NewServer(ctx context.Context, config *config.Config, logger *logrus.Logger) (*Server, error) {
+	srv := &Server{
+		config: config,
+		logger: logger,
+	}
+
+	storage, err := OpenStorage(ctx, config, logger)
+	srv.Storage = storage
+	if err != nil {
+		return srv, err
+	}
+
+	repo := storage.Store
+
+	auditSvc := service.NewApiAuditService(repo, logger)
+
+	words, err := explicit.Load(config.ExplicitWordlist)
+	if err != nil {
+		return srv, fmt.Errorf("load explicit wordlist: %w", err)
+	}
+
+	svc := service.NewApiService(repo, logger, config, auditSvc, words)
+
+	authSvc, err := service.NewApiAuthService(repo, logger, config)
+	if err != nil {
+		return srv, fmt.Errorf("initialize authentication: %w", err)
+	}
+
+	librarySvc := service.NewApiLibraryService(repo, logger, auditSvc)
+
+	playlistSvc := service.NewApiPlaylistService(repo, logger, auditSvc)
+
+	listeningSvc := service.NewApiListeningService(repo, logger, words)
+
+	reviewSvc := service.NewApiReviewService(repo, logger, auditSvc)
+	annotationSvc := service.NewApiAnnotationService(repo, logger, auditSvc, words)
+	tagSvc := service.NewApiTagService(repo, logger, auditSvc)
+	translationSvc := service.NewApiTranslationService(repo, logger, auditSvc, words)
+
+	healthSvc := service.NewApiHealthService(storage.DB, storage.Replica, logger, config)
+
+	healthHandler := handler.NewApiHealthHandler(healthSvc, logger)
+
+	auditHandler := handler.NewApiAuditHandler(auditSvc, logger)
+
+	libraryHandler := handler.NewApiLibraryHandler(librarySvc, logger)
+
+	playlistHandler := handler.NewApiPlaylistHandler(playlistSvc, logger)
+
+	listeningHandler := handler.NewApiListeningHandler(listeningSvc, logger)
+
+	reviewHandler := handler.NewApiReviewHandler(reviewSvc, logger)
+	annotationHandler := handler.NewApiAnnotationHandler(annotationSvc, logger)
+	tagHandler := handler.NewApiTagHandler(tagSvc, logger)
+	translationHandler := handler.NewApiTranslationHandler(translationSvc, logger)
+
+	authHandler := handler.NewApiAuthHandler(authSvc, logger)
+
+	handler := handler.NewApiHandler(svc, logger)
+
+	auth := middleware.NewAuthMiddleware(authSvc, config.AllowAnonymousRead, logger)
+
+	tenant := middleware.NewTenantMiddleware(librarySvc, config.TenantBaseDomain, config.DefaultLibrary, logger)
+
+	limits := map[string]ratelimit.Limit{
+		middleware.BucketRead:     {Rate: config.RateLimitReadRate, Burst: config.RateLimitReadBurst},
+		middleware.BucketWrite:    {Rate: config.RateLimitWriteRate, Burst: config.RateLimitWriteBurst},
+		middleware.BucketProvider: {Rate: config.RateLimitProviderRate, Burst: config.RateLimitProviderBurst},
+	}
+
+	var limitStore ratelimit.Store
+	switch config.RateLimitStore {
+	case "postgres":
+		// Buckets unused for their refill time are full and can go.
+		var idle time.Duration
+		for _, limit := range limits {
+			idle = max(idle, limit.RefillTime())
+		}
+		postgresStore := ratelimit.NewPostgresStore(storage.DB, time.Minute, idle, logger)
+		srv.closers = append(srv.closers, func() {
+			logger.Info("Stopping rate limiter janitor")
+			postgresStore.Close()
+		})
+		limitStore = postgresStore
+	default:
+		memoryStore := ratelimit.NewMemoryStore(time.Minute)
+		srv.closers = append(srv.closers, func() {
+			logger.Info("Stopping rate limiter janitor")
+			memoryStore.Close()
+		})
+		limitStore = memoryStore
+	}
+
+	limiter := middleware.NewRateLimiter(limitStore, limits, logger)
+
+	registry := metrics.NewRegistry(storage.Postgres(), storage.Replica, repo.GetSongStats)
+	metricsHandler := adaptor.HTTPHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	srv.App = fiber.New(fiber.Config{
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	})
+
+	routes.RegistrationRoutes(srv.App, config.CORSOrigins, routes.Handlers{
+		Songs:        handler,
+		Libraries:    libraryHandler,
+		Playlists:    playlistHandler,
+		Listening:    listeningHandler,
+		Reviews:      reviewHandler,
+		Annotations:  annotationHandler,
+		Tags:         tagHandler,
+		Translations: translationHandler,
+		Auth:         authHandler,
+		Audit:        auditHandler,
+		Health:       healthHandler,
+		Metrics:      metricsHandler,
+	}, routes.Middleware{
+		ClientIP:      middleware.NewClientIPResolver(config.ProxyHeader, config.TrustedProxies),
+		Auth:          auth,
+		Tenant:        tenant,
+		RateLimiter:   limiter,
+		RequestLogger: middleware.NewRequestLogger(logger),
+	})
+
+	return srv, nil
+}
+
+// Listen serves HTTP, or HTTPS if a certificate is configured, until the
+// app is shut down.
+func (s *Server) Listen() error {
+	if s.config.TLSCertFile != "" {
+		s.logger.Infof("Starting HTTPS server on port %d", s.config.Port)
+		return s.App.ListenTLS(s.config.Addr(), s.config.TLSCertFile, s.config.TLSKeyFile)
+	}
+	s.logger.Infof("Starting server on port %d", s.config.Port)
+	return s.App.Listen(s.config.Addr())
+}
+
+// Close stops background workers and closes the storage. Shut the app down
+// first.
+func (s *Server) Close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+	if s.Storage != nil {
+		s.Storage.Close()
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/db"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/pkg/migrator"
+	"github.com/sirupsen/logrus"
+)
+
+// Storage is the store selected by STORAGE and the connections behind it.
+type Storage struct {
+	Store repository.Store
+	// DB is the Postgres primary or the SQLite database, nil for memory
+	// storage.
+	DB *sql.DB
+	// Replica is the Postgres read replica, nil if not configured.
+	Replica *sql.DB
+
+	postgres bool
+	logger   *logrus.Logger
+}
+
+// OpenStorage connects to the configured store. For Postgres it waits for
+// the database and the replica and applies migrations if MIGRATE_ON_START is
+// set; SQLite creates its schema on first use. Call Close when done, also
+// after an error.
+func OpenStorage(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (*Storage, error) {
+	s := &Storage{logger: logger}
+
+	switch cfg.Storage {
+	case config.StorageMemory:
+		logger.Warn("Using in-memory storage, data is lost on restart")
+		s.Store = repository.NewMemoryRepository(logger)
+		return s, nil
+
+	case config.StorageSQLite:
+		var err error
+		if s.DB, err = db.OpenSQLite(cfg.SQLitePath); err != nil {
+			return s, fmt.Errorf("open sqlite database: %w", err)
+		}
+		if s.Store, err = repository.NewSqliteRepository(ctx, s.DB, logger); err != nil {
+			return s, err
+		}
+		logger.Infof("Using SQLite storage at %s", cfg.SQLitePath)
+		return s, nil
+	}
+
+	var err error
+	if s.DB, err = db.Init(cfg); err != nil {
+		return s, fmt.Errorf("open database: %w", err)
+	}
+
+	if err := db.WaitForConnection(ctx, s.DB, cfg.DBConnectRetries, cfg.DBConnectRetryDelay, logger); err != nil {
+		return s, err
+	}
+
+	if s.Replica, err = db.InitReplica(cfg); err != nil {
+		return s, fmt.Errorf("open read replica: %w", err)
+	}
+	if s.Replica != nil {
+		if err := db.WaitForConnection(ctx, s.Replica, cfg.DBConnectRetries, cfg.DBConnectRetryDelay, logger); err != nil {
+			return s, fmt.Errorf("read replica: %w", err)
+		}
+		logger.Info("Routing song listings to the read replica")
+	}
+
+	if cfg.MigrateOnStart {
+		if err := migrator.RunDatabaseMigrations(ctx, s.DB); err != nil {
+			return s, fmt.Errorf("run migrations: %w", err)
+		}
+	}
+
+	s.Store = repository.NewApiRepository(s.DB, s.Replica, logger)
+	s.postgres = true
+	return s, nil
+}
+
+// Postgres returns the Postgres primary, or nil for other storages.
+func (s *Storage) Postgres() *sql.DB {
+	if !s.postgres {
+		return nil
+	}
+	return s.DB
+}
+
+// Close closes the database connections.
+func (s *Storage) Close() {
+	if s.Replica != nil {
+		if err := db.Close(s.Replica); err != nil {
+			s.logger.Errorf("Failed to close read replica: %v", err)
+		}
+	}
+	if s.DB != nil {
+		if err := db.Close(s.DB); err != nil {
+			s.logger.Errorf("Failed to close database: %v", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package audit
+
+import "context"
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionImport = "import"
+	ActionPurge  = "purge"
+	ActionMerge  = "merge"
+)
+
+const (
+	EntitySong        = "song"
+	EntityLibrary     = "library"
+	EntityPlaylist    = "playlist"
+	EntityReview      = "review"
+	EntityAnnotation  = "annotation"
+	EntityTranslation = "translation"
+)
+
+// Source identifies the request a mutation originated from.
+type Source struct {
+	RequestID string
+	IP        string
+}
+
+type sourceKey struct{}
+
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func SourceFromContext(ctx context.Context) Source {
+	source, _ := ctx.Value(sourceKey{}).(Source)
+	return source
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	ApiKeyPrefix = "sl_"
+
+	prefixBytes = 4
+	secretBytes = 24
+)
+
+// Clean modes of api keys. In clean mode explicit songs are hidden; with
+// CleanModeMask the words of the explicit wordlist are also masked in the
+// verses of the songs that remain.
+const (
+	CleanModeOff     = "off"
+	CleanModeExclude = "exclude"
+	CleanModeMask    = "mask"
+)
+
+// ValidateCleanMode rejects unknown clean modes.
+func ValidateCleanMode(mode string) error {
+	switch mode {
+	case CleanModeOff, CleanModeExclude, CleanModeMask:
+		return nil
+	}
+	return fmt.Errorf("unknown clean mode %q: must be %s, %s or %s", mode, CleanModeOff, CleanModeExclude, CleanModeMask)
+}
+
+// GenerateApiKey returns a new plaintext key along with its public prefix and
+// the hash that is stored in the database. The plaintext is never persisted.
+func GenerateApiKey() (plain, prefix, hash string, err error) {
+	buf := make([]byte, prefixBytes+secretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(buf[:prefixBytes])
+	plain = ApiKeyPrefix + prefix + "_" + hex.EncodeToString(buf[prefixBytes:])
+
+	return plain, prefix, HashApiKey(plain), nil
+}
+
+func HashApiKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, ApiKeyPrefix)
+}
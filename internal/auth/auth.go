@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+const (
+	MethodApiKey = "api_key"
+	MethodJWT    = "jwt"
+	MethodCLI    = "cli"
+	MethodUser   = "user"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrJWTDisabled        = errors.New("jwt authentication is not configured")
+)
+
+// Principal describes the caller a request was authenticated as.
+type Principal struct {
+	Subject string `json:"subject"`
+	Method  string `json:"method"`
+	KeyID   int    `json:"key_id,omitempty"`
+	// SessionID is set for users logged in with a password.
+	SessionID int      `json:"session_id,omitempty"`
+	Scopes    []string `json:"scopes"`
+	// Library is the slug of the library the credentials are bound to. Empty
+	// means the principal may pick any library.
+	Library string `json:"library,omitempty"`
+	// CleanMode is the clean mode of the api key, empty for other
+	// credentials.
+	CleanMode string `json:"clean_mode,omitempty"`
+}
+
+type principalKey struct{}
+
+// Actor is the identifier recorded against mutations made by the principal.
+// Api keys are told apart by ID, as their names need not be unique.
+func (p *Principal) Actor() string {
+	if p == nil {
+		return "anonymous"
+	}
+	if p.Method == MethodApiKey && p.KeyID != 0 {
+		return p.Method + ":" + strconv.Itoa(p.KeyID)
+	}
+	return p.Method + ":" + p.Subject
+}
+
+// Clean reports whether explicit songs are hidden from the principal.
+func (p *Principal) Clean() bool {
+	return p != nil && (p.CleanMode == CleanModeExclude || p.CleanMode == CleanModeMask)
+}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
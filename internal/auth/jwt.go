@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claims extends the registered claims with the claims the service
+// understands: an OAuth style space separated "scope", a list of "roles" and
+// the "library" the token is bound to.
+type claims struct {
+	jwt.RegisteredClaims
+	Scope   string   `json:"scope,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Library string   `json:"library,omitempty"`
+}
+
+// JWTVerifier validates bearer tokens signed with either a shared HS256 secret
+// or an RS256 key pair. Either or both keys may be configured.
+type JWTVerifier struct {
+	secret    []byte
+	publicKey interface{}
+	issuer    string
+	audience  string
+}
+
+func NewJWTVerifier(secret, publicKeyFile, issuer, audience string) (*JWTVerifier, error) {
+	v := &JWTVerifier{
+		issuer:   issuer,
+		audience: audience,
+	}
+
+	if secret != "" {
+		v.secret = []byte(secret)
+	}
+
+	if publicKeyFile != "" {
+		pem, err := os.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read jwt public key: %w", err)
+		}
+
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("parse jwt public key: %w", err)
+		}
+		v.publicKey = key
+	}
+
+	return v, nil
+}
+
+func (v *JWTVerifier) Enabled() bool {
+	return v != nil && (v.secret != nil || v.publicKey != nil)
+}
+
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	if !v.Enabled() {
+		return nil, ErrJWTDisabled
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(v.methods()),
+		jwt.WithExpirationRequired(),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+
+	claims := claims{}
+	if _, err := jwt.ParseWithClaims(token, &claims, v.keyFunc, opts...); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidCredentials)
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	for _, role := range claims.Roles {
+		roleScopes, err := ScopesForRole(role)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+		}
+		scopes = append(scopes, roleScopes...)
+	}
+
+	return &Principal{
+		Subject: claims.Subject,
+		Method:  MethodJWT,
+		Scopes:  scopes,
+		Library: claims.Library,
+	}, nil
+}
+
+func (v *JWTVerifier) methods() []string {
+	var methods []string
+	if v.secret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if v.publicKey != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	return methods
+}
+
+func (v *JWTVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return v.secret, nil
+	case *jwt.SigningMethodRSA:
+		return v.publicKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
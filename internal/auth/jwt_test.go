@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// testRSAKey returns a new key pair and the PEM file of its public key.
+func testRSAKey(t *testing.T) (*rsa.PrivateKey, string, []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, public, 0o600); err != nil {
+		t.Fatalf("write public key: %v", err)
+	}
+	return key, path, public
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, c jwt.Claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, c).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign %s token: %v", method.Alg(), err)
+	}
+	return token
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, publicKeyFile, publicPEM := testRSAKey(t)
+	otherKey, _, _ := testRSAKey(t)
+
+	hsOnly, err := NewJWTVerifier(testSecret, "", "songlib-issuer", "songlib")
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	rsOnly, err := NewJWTVerifier("", publicKeyFile, "", "")
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	both, err := NewJWTVerifier(testSecret, publicKeyFile, "", "")
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+
+	now := time.Now()
+	valid := func(c claims) claims {
+		if c.Subject == "" {
+			c.Subject = "ops"
+		}
+		if c.ExpiresAt == nil {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(time.Hour))
+		}
+		if c.Issuer == "" {
+			c.Issuer = "songlib-issuer"
+		}
+		if c.Audience == nil {
+			c.Audience = jwt.ClaimStrings{"songlib"}
+		}
+		return c
+	}
+	hs := func(c claims) string { return sign(t, jwt.SigningMethodHS256, []byte(testSecret), valid(c)) }
+	rs := func(c claims) string { return sign(t, jwt.SigningMethodRS256, rsaKey, valid(c)) }
+
+	tests := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		// scopes are those of the principal; nil means the token is
+		// rejected.
+		scopes  []string
+		library string
+	}{
+		{"hs256 scopes", hsOnly, hs(claims{Scope: "songs:read songs:write"}), []string{ScopeSongsRead, ScopeSongsWrite}, ""},
+		{"hs256 role", hsOnly, hs(claims{Roles: []string{"Reader"}}), []string{ScopeSongsRead, ScopeSongsInteract}, ""},
+		{"scope and role", hsOnly, hs(claims{Scope: "songs:delete", Roles: []string{RoleReader}}), []string{ScopeSongsDelete, ScopeSongsRead, ScopeSongsInteract}, ""},
+		{"no scopes", hsOnly, hs(claims{}), []string{}, ""},
+		{"library", hsOnly, hs(claims{Scope: "songs:read", Library: "team-a"}), []string{ScopeSongsRead}, "team-a"},
+		{"rs256", rsOnly, rs(claims{Scope: "admin"}), []string{ScopeAdmin}, ""},
+		{"rs256 with both keys", both, rs(claims{Scope: "admin"}), []string{ScopeAdmin}, ""},
+		{"hs256 with both keys", both, hs(claims{Scope: "admin"}), []string{ScopeAdmin}, ""},
+
+		{"alg none", hsOnly, sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid(claims{Scope: "admin"})), nil, ""},
+		{"hs256 signed with the public key", rsOnly, sign(t, jwt.SigningMethodHS256, publicPEM, valid(claims{Scope: "admin"})), nil, ""},
+		{"hs256 signed with the public key and both keys", both, sign(t, jwt.SigningMethodHS256, publicPEM, valid(claims{Scope: "admin"})), nil, ""},
+		{"rs256 without a public key", hsOnly, rs(claims{Scope: "admin"}), nil, ""},
+		{"rs512", rsOnly, sign(t, jwt.SigningMethodRS512, rsaKey, valid(claims{Scope: "admin"})), nil, ""},
+		{"hs512", hsOnly, sign(t, jwt.SigningMethodHS512, []byte(testSecret), valid(claims{Scope: "admin"})), nil, ""},
+		{"wrong secret", hsOnly, sign(t, jwt.SigningMethodHS256, []byte("another secret of the same size!"), valid(claims{Scope: "admin"})), nil, ""},
+		{"wrong rsa key", rsOnly, sign(t, jwt.SigningMethodRS256, otherKey, valid(claims{Scope: "admin"})), nil, ""},
+		{"expired", hsOnly, hs(claims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(-time.Minute))}}), nil, ""},
+		{"no expiry", hsOnly, sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "ops", Issuer: "songlib-issuer", Audience: jwt.ClaimStrings{"songlib"}}}), nil, ""},
+		{"not yet valid", hsOnly, hs(claims{RegisteredClaims: jwt.RegisteredClaims{NotBefore: jwt.NewNumericDate(now.Add(time.Hour))}}), nil, ""},
+		{"wrong issuer", hsOnly, hs(claims{RegisteredClaims: jwt.RegisteredClaims{Issuer: "someone-else"}}), nil, ""},
+		{"no issuer", hsOnly, sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "ops", ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)), Audience: jwt.ClaimStrings{"songlib"}}}), nil, ""},
+		{"wrong audience", hsOnly, hs(claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"other"}}}), nil, ""},
+		{"no subject", hsOnly, sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)), Issuer: "songlib-issuer", Audience: jwt.ClaimStrings{"songlib"}}}), nil, ""},
+		{"unknown scope", hsOnly, hs(claims{Scope: "songs:read songs:everything"}), nil, ""},
+		{"unknown role", hsOnly, hs(claims{Roles: []string{"superuser"}}), nil, ""},
+		{"garbage", hsOnly, "not.a.token", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := tt.verifier.Verify(tt.token)
+			if tt.scopes == nil {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("Verify = %+v, %v, want ErrInvalidCredentials", principal, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if principal.Method != MethodJWT || principal.Subject != "ops" || principal.Library != tt.library {
+				t.Errorf("principal = %+v", principal)
+			}
+			if len(principal.Scopes)+len(tt.scopes) > 0 && !reflect.DeepEqual(principal.Scopes, tt.scopes) {
+				t.Errorf("scopes = %v, want %v", principal.Scopes, tt.scopes)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierDisabled(t *testing.T) {
+	v, err := NewJWTVerifier("", "", "", "")
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	if v.Enabled() {
+		t.Fatal("verifier without keys is enabled")
+	}
+	token := sign(t, jwt.SigningMethodHS256, []byte(testSecret), claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "ops", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}})
+	if _, err := v.Verify(token); !errors.Is(err, ErrJWTDisabled) {
+		t.Fatalf("Verify error = %v, want ErrJWTDisabled", err)
+	}
+
+	if _, err := NewJWTVerifier("", filepath.Join(t.TempDir(), "missing.pem"), "", ""); err == nil {
+		t.Fatal("NewJWTVerifier accepted a missing public key file")
+	}
+}
+
+func TestActor(t *testing.T) {
+	tests := []struct {
+		principal *Principal
+		want      string
+	}{
+		{nil, "anonymous"},
+		{&Principal{Method: MethodApiKey, KeyID: 12, Subject: "dj"}, "api_key:12"},
+		{&Principal{Method: MethodJWT, Subject: "ops"}, "jwt:ops"},
+		{&Principal{Method: MethodUser, Subject: "alice"}, "user:alice"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.principal.Actor(); got != tt.want {
+			t.Errorf("Actor(%+v) = %q, want %q", tt.principal, got, tt.want)
+		}
+	}
+}
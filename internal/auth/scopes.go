@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ScopeSongsRead = "songs:read"
+	// ScopeSongsInteract covers what listeners do with songs without
+	// changing them: favorites, plays, reviews and annotations.
+	ScopeSongsInteract = "songs:interact"
+	ScopeSongsWrite    = "songs:write"
+	ScopeSongsDelete   = "songs:delete"
+	ScopeAdmin         = "admin"
+)
+
+const (
+	RoleReader = "reader"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var roleScopes = map[string][]string{
+	RoleReader: {ScopeSongsRead, ScopeSongsInteract},
+	RoleEditor: {ScopeSongsRead, ScopeSongsInteract, ScopeSongsWrite},
+	RoleAdmin:  {ScopeSongsRead, ScopeSongsInteract, ScopeSongsWrite, ScopeSongsDelete, ScopeAdmin},
+}
+
+var knownScopes = map[string]bool{
+	ScopeSongsRead:     true,
+	ScopeSongsInteract: true,
+	ScopeSongsWrite:    true,
+	ScopeSongsDelete:   true,
+	ScopeAdmin:         true,
+}
+
+// ScopesForRole expands a role name into the scopes it grants.
+func ScopesForRole(role string) ([]string, error) {
+	scopes, ok := roleScopes[strings.ToLower(role)]
+	if !ok {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	return append([]string(nil), scopes...), nil
+}
+
+// ValidateScopes rejects scopes the service does not know about so that a
+// typo never silently produces a key without the intended permissions.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether the principal was granted the scope. The admin
+// scope implies every other scope.
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
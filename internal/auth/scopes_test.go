@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		scope     string
+		want      bool
+	}{
+		{"anonymous", nil, ScopeSongsRead, false},
+		{"granted", &Principal{Scopes: []string{ScopeSongsRead}}, ScopeSongsRead, true},
+		{"missing", &Principal{Scopes: []string{ScopeSongsRead}}, ScopeSongsWrite, false},
+		{"read does not imply interact", &Principal{Scopes: []string{ScopeSongsRead}}, ScopeSongsInteract, false},
+		{"write does not imply delete", &Principal{Scopes: []string{ScopeSongsWrite}}, ScopeSongsDelete, false},
+		{"admin implies every scope", &Principal{Scopes: []string{ScopeAdmin}}, ScopeSongsDelete, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.principal.HasScope(tt.scope); got != tt.want {
+				t.Errorf("HasScope(%s) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopesForRole(t *testing.T) {
+	tests := []struct {
+		role   string
+		scopes []string
+	}{
+		{RoleReader, []string{ScopeSongsRead, ScopeSongsInteract}},
+		{"Editor", []string{ScopeSongsRead, ScopeSongsInteract, ScopeSongsWrite}},
+		{RoleAdmin, []string{ScopeSongsRead, ScopeSongsInteract, ScopeSongsWrite, ScopeSongsDelete, ScopeAdmin}},
+		{"owner", nil},
+	}
+
+	for _, tt := range tests {
+		scopes, err := ScopesForRole(tt.role)
+		if (err != nil) != (tt.scopes == nil) || !reflect.DeepEqual(scopes, tt.scopes) {
+			t.Errorf("ScopesForRole(%s) = %v, %v, want %v", tt.role, scopes, err, tt.scopes)
+		}
+	}
+
+	// The returned slice is a copy.
+	scopes, _ := ScopesForRole(RoleReader)
+	scopes[0] = ScopeAdmin
+	if again, _ := ScopesForRole(RoleReader); again[0] != ScopeSongsRead {
+		t.Fatalf("ScopesForRole hands out its own slice: %v", again)
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	if err := ValidateScopes([]string{ScopeSongsRead, ScopeSongsInteract, ScopeSongsWrite, ScopeSongsDelete, ScopeAdmin}); err != nil {
+		t.Fatalf("ValidateScopes(known) = %v", err)
+	}
+	for _, scope := range []string{"songs:reed", "SONGS:READ", "songs:*", ""} {
+		if err := ValidateScopes([]string{ScopeSongsRead, scope}); err == nil {
+			t.Errorf("ValidateScopes accepted %q", scope)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SessionTokenPrefix = "sls_"
+
+	sessionTokenBytes = 32
+	// MinPasswordLength is the shortest password accepted for new users.
+	MinPasswordLength = 8
+)
+
+var ErrWeakPassword = errors.New("password must be at least 8 characters long")
+
+// GenerateSessionToken returns a new session token and the hash that is
+// stored in the database. Like api keys, the token itself is never persisted.
+func GenerateSessionToken() (plain, hash string, err error) {
+	buf := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	plain = SessionTokenPrefix + hex.EncodeToString(buf)
+	return plain, HashApiKey(plain), nil
+}
+
+func IsSessionToken(token string) bool {
+	return strings.HasPrefix(token, SessionTokenPrefix)
+}
+
+// HashPassword hashes a password with bcrypt.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyHash is compared against when the user does not exist, so that
+// unknown and known usernames take as long to reject.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// CheckPassword reports whether password matches hash. An empty hash stands
+// for an unknown user and never matches.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
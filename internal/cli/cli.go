@@ -0,0 +1,82 @@
+// Package cli implements the songlib command line: the server and the
+// administration commands, which share the configuration and service layer.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/app"
+)
+
+// Exit codes shared by all commands.
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+	// ExitPartial means a batch command finished but some items failed.
+	ExitPartial = 3
+)
+
+const usage = `usage: songlib <command> [arguments]
+
+commands:
+  serve          run the HTTP server (default when no command is given)
+  migrate        apply or roll back database migrations
+  import         import songs from a JSON or NDJSON file
+  export         export songs as NDJSON
+  enrich         fill in missing song details from the external API
+  purge-trash    permanently remove deleted songs
+  detect-languages
+                 detect the language of songs stored before detection
+  detect-explicit
+                 check songs with the current explicit wordlist
+  key            create or revoke api keys
+  user           create user accounts
+  config         print the effective configuration
+
+Run "songlib <command> -h" for the arguments of a command. Batch commands
+accept --json for machine readable output.`
+
+type command func(args []string) int
+
+var commands = map[string]command{
+	"serve":            app.Run,
+	"migrate":          runMigrate,
+	"import":           runImport,
+	"export":           runExport,
+	"enrich":           runEnrich,
+	"purge-trash":      runPurgeTrash,
+	"detect-languages": runDetectLanguages,
+	"detect-explicit":  runDetectExplicit,
+	"key":              runKey,
+	"keys":             runKey,
+	"user":             runUser,
+	"users":            runUser,
+	"config":           runConfig,
+}
+
+// Run executes the command named by args[0] and returns the exit code.
+func Run(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "--help":
+			fmt.Println(usage)
+			return ExitOK
+		}
+	}
+
+	// Flags without a command start the server, as the binary always did.
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return app.Run(args)
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", args[0], usage)
+		return ExitUsage
+	}
+
+	return cmd(args[1:])
+}
@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+// run runs the command line args and returns its exit code and what it
+// printed on stdout. Stderr is discarded.
+func run(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatalf("create stdout: %v", err)
+	}
+	defer stdout.Close()
+	stderr, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("open %s: %v", os.DevNull, err)
+	}
+	defer stderr.Close()
+
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	code := Run(args)
+	os.Stdout, os.Stderr = oldStdout, oldStderr
+
+	out, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+	return code, string(out)
+}
+
+// sqliteEnv points the commands at a fresh SQLite database.
+func sqliteEnv(t *testing.T) {
+	t.Helper()
+
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("STORAGE", config.StorageSQLite)
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "songlib.db"))
+	t.Setenv("EXTERNAL_API_URL", "http://provider.test")
+	t.Setenv("LOG_LEVEL", "error")
+}
+
+func decode(t *testing.T, out string, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		t.Fatalf("decode %q: %v", out, err)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"help"}, ExitOK},
+		{[]string{"--help"}, ExitOK},
+		{[]string{"frobnicate"}, ExitUsage},
+		{[]string{"import"}, ExitUsage},
+		{[]string{"import", "a.json", "b.json"}, ExitUsage},
+		{[]string{"export", "--unknown"}, ExitUsage},
+		{[]string{"key"}, ExitUsage},
+		{[]string{"key", "rotate", "ci"}, ExitUsage},
+	}
+
+	for _, tt := range tests {
+		if code, _ := run(t, tt.args...); code != tt.code {
+			t.Errorf("songlib %s = %d, want %d", strings.Join(tt.args, " "), code, tt.code)
+		}
+	}
+}
+
+func TestReadSongs(t *testing.T) {
+	type item struct {
+		Line int
+		Song string
+		Err  bool
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		items []item
+		err   bool
+	}{
+		{"empty", " \n\n", nil, false},
+		{"array", `[{"group":"Muse","song":"Uprising"}, {"group":"Muse","song":7}, {"group":"Kino","song":"Kukushka"}]`,
+			[]item{{1, "Uprising", false}, {2, "", true}, {3, "Kukushka", false}}, false},
+		{"ndjson", "\n{\"group\":\"Muse\",\"song\":\"Uprising\"}\n\n{not json}\n  {\"group\":\"Kino\",\"song\":\"Kukushka\"}\n",
+			[]item{{2, "Uprising", false}, {4, "", true}, {5, "Kukushka", false}}, false},
+		{"broken array", `[{"group":"Muse"`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []item
+			err := readSongs(strings.NewReader(tt.input), func(line int, song *models.Song, err error) {
+				items = append(items, item{line, song.Song, err != nil})
+			})
+			if (err != nil) != tt.err {
+				t.Fatalf("readSongs error = %v", err)
+			}
+			if !reflect.DeepEqual(items, tt.items) {
+				t.Errorf("items = %+v, want %+v", items, tt.items)
+			}
+		})
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	sqliteEnv(t)
+
+	input := filepath.Join(t.TempDir(), "songs.ndjson")
+	songs := `{"group":"Muse","song":"Uprising","releaseDate":"07.09.2009","text":"Paranoia is in bloom"}
+{"group":"Muse"}
+{"group":"Kino","song":"Kukushka"}
+`
+	if err := os.WriteFile(input, []byte(songs), 0o600); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	code, out := run(t, "import", "--json", input)
+	if code != ExitPartial {
+		t.Fatalf("import = %d, want %d: %s", code, ExitPartial, out)
+	}
+	var imported importResult
+	decode(t, out, &imported)
+	if imported.Imported != 2 || imported.Failed != 1 || len(imported.Errors) != 1 || imported.Errors[0].Line != 2 {
+		t.Errorf("import result = %+v", imported)
+	}
+
+	output := filepath.Join(t.TempDir(), "export.ndjson")
+	code, out = run(t, "export", "--json", "--out", output)
+	if code != ExitOK {
+		t.Fatalf("export = %d: %s", code, out)
+	}
+	var exported exportResult
+	decode(t, out, &exported)
+	if exported.Exported != 2 || exported.Out != output {
+		t.Errorf("export result = %+v", exported)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("read export: %v", err)
+	}
+	var titles []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var song models.Song
+		decode(t, line, &song)
+		if !strings.HasPrefix(song.CreatedBy, "cli:") {
+			t.Errorf("song %q created by %q, want the operator", song.Song, song.CreatedBy)
+		}
+		titles = append(titles, song.Song)
+	}
+	if want := []string{"Uprising", "Kukushka"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("exported %v, want %v", titles, want)
+	}
+
+	if code, out := run(t, "export", "--library", "missing", "--json"); code != ExitFailure || !strings.Contains(out, `"error"`) {
+		t.Errorf("export of a missing library = %d, %s", code, out)
+	}
+}
+
+func TestKey(t *testing.T) {
+	sqliteEnv(t)
+
+	code, out := run(t, "key", "create", "--role", "editor", "--clean-mode", "mask", "--json", "ci")
+	if code != ExitOK {
+		t.Fatalf("key create = %d: %s", code, out)
+	}
+	var created struct {
+		ID        int      `json:"id"`
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		CleanMode string   `json:"clean_mode"`
+		Key       string   `json:"key"`
+	}
+	decode(t, out, &created)
+	if created.ID == 0 || created.Name != "ci" || created.Key == "" || created.CleanMode != "mask" ||
+		!reflect.DeepEqual(created.Scopes, []string{"songs:read", "songs:interact", "songs:write"}) {
+		t.Errorf("created key = %+v", created)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"unknown role", []string{"key", "create", "--role", "owner", "ops"}, ExitUsage},
+		{"missing library", []string{"key", "create", "--library", "missing", "ops"}, ExitFailure},
+		{"revoke", []string{"key", "revoke", strconv.Itoa(created.ID)}, ExitOK},
+		{"revoke invalid id", []string{"key", "revoke", "ci"}, ExitUsage},
+		{"revoke missing key", []string{"key", "revoke", "999"}, ExitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, out := run(t, tt.args...); code != tt.code {
+				t.Errorf("songlib %s = %d, want %d: %s", strings.Join(tt.args, " "), code, tt.code, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+)
+
+const configUsage = `usage: songlib config print [--json] [--config file] [--key value ...]
+  print the effective configuration with secrets redacted`
+
+func runConfig(args []string) int {
+	if len(args) < 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return ExitUsage
+	}
+
+	// Everything except --json is passed on to the config loader, so that
+	// any server flag can be previewed.
+	asJSON := false
+	var rest []string
+	for _, arg := range args[1:] {
+		if arg == "--json" || arg == "-json" {
+			asJSON = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+
+	cfg, err := config.LoadConfig(rest)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return ExitUsage
+		}
+		return fail(asJSON, ExitFailure, err)
+	}
+
+	report(os.Stdout, asJSON, cfg.Settings(), func(w io.Writer) {
+		if err := cfg.Print(w); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	})
+	return ExitOK
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const enrichUsage = `usage: songlib enrich [--library slug] [--missing-text] [--config file] [--json]
+  fill in missing song details from the external API`
+
+type enrichResult struct {
+	Checked int                   `json:"checked"`
+	Updated int                   `json:"updated"`
+	Failed  int                   `json:"failed"`
+	Songs   []models.EnrichResult `json:"songs"`
+}
+
+func runEnrich(args []string) int {
+	var g globalFlags
+	flags := flag.NewFlagSet("enrich", flag.ContinueOnError)
+	g.register(flags)
+	library := flags.String("library", "", "library to enrich, the default library if empty")
+	missingText := flags.Bool("missing-text", false, "only consider songs without text")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, enrichUsage) }
+	if !parseFlags(flags, args, 0, 0) {
+		return ExitUsage
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx, err = e.withLibrary(operatorContext(ctx), *library)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+
+	songs, err := e.songs.EnrichSongs(ctx, *missingText)
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("enrich songs: %w", err))
+	}
+
+	result := enrichResult{Checked: len(songs), Songs: songs}
+	for _, song := range songs {
+		switch {
+		case song.Error != "":
+			result.Failed++
+		case len(song.Updated) > 0:
+			result.Updated++
+		}
+	}
+
+	report(os.Stdout, g.json, result, func(w io.Writer) {
+		for _, song := range songs {
+			switch {
+			case song.Error != "":
+				fmt.Fprintf(w, "%d %s - %s: %s\n", song.SongID, song.Group, song.Song, song.Error)
+			case len(song.Updated) > 0:
+				fmt.Fprintf(w, "%d %s - %s: updated %s\n", song.SongID, song.Group, song.Song, strings.Join(song.Updated, ", "))
+			}
+		}
+		fmt.Fprintf(w, "checked %d, updated %d, failed %d\n", result.Checked, result.Updated, result.Failed)
+	})
+
+	if result.Failed > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
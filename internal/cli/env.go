@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/app"
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/VadimBorzenkov/online-song-library/internal/tenant"
+	"github.com/VadimBorzenkov/online-song-library/pkg/explicit"
+	"github.com/sirupsen/logrus"
+)
+
+// globalFlags are accepted by every administration command.
+type globalFlags struct {
+	configFile string
+	json       bool
+}
+
+func (g *globalFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&g.configFile, "config", "", "YAML or TOML config file")
+	flags.BoolVar(&g.json, "json", false, "print machine readable JSON")
+}
+
+func (g *globalFlags) configArgs() []string {
+	if g.configFile == "" {
+		return nil
+	}
+	return []string{"--config", g.configFile}
+}
+
+// env holds what the administration commands share with the server: the
+// configuration, the storage and the services on top of it.
+type env struct {
+	cfg       *config.Config
+	logger    *logrus.Logger
+	storage   *app.Storage
+	songs     service.SongService
+	libraries service.LibraryService
+	auth      service.AuthService
+}
+
+// setup loads the configuration and opens the storage like the server
+// does, applying migrations if MIGRATE_ON_START is enabled. Call close when
+// done.
+func setup(ctx context.Context, g *globalFlags) (*env, error) {
+	cfg, err := config.LoadConfig(g.configArgs())
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.InitLogger(cfg.LogLevel, cfg.LogFormat)
+
+	storage, err := app.OpenStorage(ctx, cfg, logger)
+	if err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	repo := storage.Store
+	auditSvc := service.NewApiAuditService(repo, logger)
+
+	authSvc, err := service.NewApiAuthService(repo, logger, cfg)
+	if err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("initialize authentication: %w", err)
+	}
+
+	words, err := explicit.Load(cfg.ExplicitWordlist)
+	if err != nil {
+		storage.Close()
+		return nil, fmt.Errorf("load explicit wordlist: %w", err)
+	}
+
+	return &env{
+		cfg:       cfg,
+		logger:    logger,
+		storage:   storage,
+		songs:     service.NewApiService(repo, logger, cfg, auditSvc, words),
+		libraries: service.NewApiLibraryService(repo, logger, auditSvc),
+		auth:      authSvc,
+	}, nil
+}
+
+func (e *env) close() {
+	e.storage.Close()
+}
+
+// operatorContext returns a context acting as the operator running the
+// command, with full permissions, so that audit events name them.
+func operatorContext(ctx context.Context) context.Context {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	ctx = auth.WithPrincipal(ctx, &auth.Principal{
+		Subject: name,
+		Method:  auth.MethodCLI,
+		Scopes:  []string{auth.ScopeAdmin},
+	})
+	return audit.WithSource(ctx, audit.Source{})
+}
+
+// withLibrary scopes ctx to the library with the given slug, or the default
+// library if slug is empty.
+func (e *env) withLibrary(ctx context.Context, slug string) (context.Context, error) {
+	if slug == "" {
+		slug = e.cfg.DefaultLibrary
+	}
+
+	library, err := e.libraries.GetLibrary(ctx, slug)
+	if err != nil {
+		if errors.Is(err, service.ErrLibraryNotFound) {
+			return nil, fmt.Errorf("library %q not found", slug)
+		}
+		return nil, err
+	}
+
+	return tenant.WithLibrary(ctx, library), nil
+}
+
+// parseFlags parses args and reports a usage error, returning false, if
+// they are invalid or the number of positional arguments is not in
+// [minArgs, maxArgs].
+func parseFlags(flags *flag.FlagSet, args []string, minArgs, maxArgs int) bool {
+	flags.SetOutput(os.Stderr)
+	if err := flags.Parse(args); err != nil {
+		return false
+	}
+	if flags.NArg() < minArgs || flags.NArg() > maxArgs {
+		flags.Usage()
+		return false
+	}
+	return true
+}
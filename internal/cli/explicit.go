@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const explicitUsage = `usage: songlib detect-explicit [--library slug] [--config file] [--json]
+  check the lyrics of songs with the explicit wordlist (EXPLICIT_WORDLIST)
+  if they were last checked with another one or never, in every library
+  unless --library is given`
+
+type explicitResult struct {
+	Changed map[string]int    `json:"changed"`
+	Total   int               `json:"total"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+func runDetectExplicit(args []string) int {
+	var g globalFlags
+	flags := flag.NewFlagSet("detect-explicit", flag.ContinueOnError)
+	g.register(flags)
+	library := flags.String("library", "", "only check songs in this library")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, explicitUsage) }
+	if !parseFlags(flags, args, 0, 0) {
+		return ExitUsage
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx = operatorContext(ctx)
+
+	slugs := []string{*library}
+	if *library == "" {
+		libraries, err := e.libraries.GetLibraries(ctx)
+		if err != nil {
+			return fail(g.json, ExitFailure, fmt.Errorf("list libraries: %w", err))
+		}
+		slugs = slugs[:0]
+		for _, l := range libraries {
+			slugs = append(slugs, l.Slug)
+		}
+	}
+
+	result := explicitResult{Changed: map[string]int{}}
+	for _, slug := range slugs {
+		libCtx, err := e.withLibrary(ctx, slug)
+		if err == nil {
+			var n int
+			if n, err = e.songs.DetectExplicit(libCtx); err == nil {
+				result.Changed[slug] = n
+				result.Total += n
+				continue
+			}
+		}
+		if result.Errors == nil {
+			result.Errors = map[string]string{}
+		}
+		result.Errors[slug] = err.Error()
+	}
+
+	if *library != "" && len(result.Errors) > 0 {
+		return fail(g.json, ExitFailure, fmt.Errorf("detect explicit songs: %s", result.Errors[*library]))
+	}
+
+	report(os.Stdout, g.json, result, func(w io.Writer) {
+		sort.Strings(slugs)
+		for _, slug := range slugs {
+			if msg, failed := result.Errors[slug]; failed {
+				fmt.Fprintf(w, "%s: %s\n", slug, msg)
+				continue
+			}
+			fmt.Fprintf(w, "%s: changed %d\n", slug, result.Changed[slug])
+		}
+		fmt.Fprintf(w, "changed the explicit flag of %d songs\n", result.Total)
+	})
+
+	if len(result.Errors) > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const exportUsage = `usage: songlib export [--library slug] [--out file] [--config file] [--json]
+  write all songs of a library as newline delimited JSON, to stdout by default`
+
+type exportResult struct {
+	Exported int    `json:"exported"`
+	Out      string `json:"out"`
+}
+
+func runExport(args []string) int {
+	var g globalFlags
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	g.register(flags)
+	library := flags.String("library", "", "library to export, the default library if empty")
+	outPath := flags.String("out", "-", `output file, "-" for stdout`)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, exportUsage) }
+	if !parseFlags(flags, args, 0, 0) {
+		return ExitUsage
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx, err = e.withLibrary(operatorContext(ctx), *library)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+
+	// The summary goes to stderr when the songs themselves go to stdout.
+	out, summary := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	var file *os.File
+	if *outPath != "-" {
+		if file, err = os.Create(*outPath); err != nil {
+			return fail(g.json, ExitFailure, err)
+		}
+		out, summary = file, os.Stdout
+	}
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	result := exportResult{Out: *outPath}
+	err = e.songs.ExportSongs(ctx, func(song *models.Song) error {
+		result.Exported++
+		return enc.Encode(song)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if file != nil {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("export songs: %w", err))
+	}
+
+	report(summary, g.json, result, func(w io.Writer) {
+		fmt.Fprintf(w, "exported %d songs\n", result.Exported)
+	})
+	return ExitOK
+}
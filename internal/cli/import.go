@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const importUsage = `usage: songlib import [--library slug] [--config file] [--json] <file|->
+  import songs from a JSON array or newline delimited JSON, "-" reads stdin`
+
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+type importResult struct {
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors"`
+}
+
+func runImport(args []string) int {
+	var g globalFlags
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	g.register(flags)
+	library := flags.String("library", "", "library to import into, the default library if empty")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, importUsage) }
+	if !parseFlags(flags, args, 1, 1) {
+		return ExitUsage
+	}
+
+	in := os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fail(g.json, ExitFailure, err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx, err = e.withLibrary(operatorContext(ctx), *library)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+
+	result := importResult{Errors: []importError{}}
+	err = readSongs(in, func(line int, song *models.Song, err error) {
+		if err == nil {
+			err = e.songs.ImportSong(ctx, song)
+		}
+		if err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, importError{Line: line, Error: err.Error()})
+			return
+		}
+		result.Imported++
+	})
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+
+	report(os.Stdout, g.json, result, func(w io.Writer) {
+		for _, e := range result.Errors {
+			fmt.Fprintf(w, "line %d: %s\n", e.Line, e.Error)
+		}
+		fmt.Fprintf(w, "imported %d, failed %d\n", result.Imported, result.Failed)
+	})
+
+	if result.Failed > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
+
+// readSongs decodes songs from r, which holds either a JSON array or one
+// JSON object per line, and calls fn for each with its line number (array
+// index + 1 for arrays). Decode errors of single items are passed to fn;
+// the returned error means the input could not be read at all.
+func readSongs(r io.Reader, fn func(line int, song *models.Song, err error)) error {
+	br := bufio.NewReader(r)
+
+	first, skipped, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if first == '[' {
+		var items []json.RawMessage
+		if err := json.NewDecoder(br).Decode(&items); err != nil {
+			return fmt.Errorf("parse JSON array: %w", err)
+		}
+		for i, raw := range items {
+			var song models.Song
+			err := json.Unmarshal(raw, &song)
+			fn(i+1, &song, err)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := skipped + 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var song models.Song
+		err := json.Unmarshal(data, &song)
+		fn(line, &song, err)
+	}
+	return scanner.Err()
+}
+
+// peekNonSpace returns the first non-whitespace byte of br without consuming
+// it, and the number of lines skipped before it.
+func peekNonSpace(br *bufio.Reader) (byte, int, error) {
+	lines := 0
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, lines, err
+		}
+		switch b {
+		case '\n':
+			lines++
+			continue
+		case ' ', '\t', '\r':
+			continue
+		}
+		return b, lines, br.UnreadByte()
+	}
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const keyUsage = `usage:
+  songlib key create [--role reader|editor|admin] [--scopes "songs:read songs:write"] [--library slug]
+                     [--clean-mode off|exclude|mask] [--json] <name>
+                       mint a new api key and print it once
+  songlib key revoke [--json] <id>
+                       revoke an api key`
+
+type keyResult struct {
+	*models.ApiKey
+	Key string `json:"key"`
+}
+
+func runKey(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, keyUsage)
+		return ExitUsage
+	}
+
+	var g globalFlags
+	flags := flag.NewFlagSet("key "+args[0], flag.ContinueOnError)
+	g.register(flags)
+	role := flags.String("role", auth.RoleReader, "role granted to the key")
+	scopeList := flags.String("scopes", "", "space separated scopes, overrides --role")
+	library := flags.String("library", "", "bind the key to a single library")
+	cleanMode := flags.String("clean-mode", auth.CleanModeOff, "off, exclude to hide explicit songs from the key, or mask to also mask explicit words")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, keyUsage) }
+	if !parseFlags(flags, args[1:], 1, 1) {
+		return ExitUsage
+	}
+	arg := flags.Arg(0)
+
+	switch args[0] {
+	case "create", "revoke":
+	default:
+		flags.Usage()
+		return ExitUsage
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx = operatorContext(ctx)
+
+	if args[0] == "revoke" {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fail(g.json, ExitUsage, fmt.Errorf("api key ID must be a valid integer"))
+		}
+		if err := e.auth.RevokeApiKey(ctx, id); err != nil {
+			return fail(g.json, ExitFailure, fmt.Errorf("revoke api key: %w", err))
+		}
+		report(os.Stdout, g.json, map[string]int{"revoked": id}, func(w io.Writer) {
+			fmt.Fprintf(w, "api key %d revoked\n", id)
+		})
+		return ExitOK
+	}
+
+	scopes := strings.Fields(*scopeList)
+	if len(scopes) == 0 {
+		if scopes, err = auth.ScopesForRole(*role); err != nil {
+			return fail(g.json, ExitUsage, err)
+		}
+	}
+
+	if *library != "" {
+		if _, err := e.withLibrary(ctx, *library); err != nil {
+			return fail(g.json, ExitFailure, fmt.Errorf("create api key: %w", err))
+		}
+	}
+
+	plain, key, err := e.auth.CreateApiKey(ctx, arg, scopes, *library, *cleanMode)
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("create api key: %w", err))
+	}
+
+	report(os.Stdout, g.json, keyResult{ApiKey: key, Key: plain}, func(w io.Writer) {
+		fmt.Fprintf(w, "id:         %d\nname:       %s\nprefix:     %s\nscopes:     %s\nlibrary:    %s\nclean mode: %s\nkey:        %s\n",
+			key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, " "), key.Library, key.CleanMode, plain)
+		fmt.Fprintln(os.Stderr, "Store the key now, it cannot be shown again.")
+	})
+	return ExitOK
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const languagesUsage = `usage: songlib detect-languages [--library slug] [--config file] [--json]
+  detect the language of songs stored before languages were detected on
+  every write, in every library unless --library is given`
+
+type languagesResult struct {
+	Detected map[string]int    `json:"detected"`
+	Total    int               `json:"total"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+func runDetectLanguages(args []string) int {
+	var g globalFlags
+	flags := flag.NewFlagSet("detect-languages", flag.ContinueOnError)
+	g.register(flags)
+	library := flags.String("library", "", "only detect languages in this library")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, languagesUsage) }
+	if !parseFlags(flags, args, 0, 0) {
+		return ExitUsage
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx = operatorContext(ctx)
+
+	slugs := []string{*library}
+	if *library == "" {
+		libraries, err := e.libraries.GetLibraries(ctx)
+		if err != nil {
+			return fail(g.json, ExitFailure, fmt.Errorf("list libraries: %w", err))
+		}
+		slugs = slugs[:0]
+		for _, l := range libraries {
+			slugs = append(slugs, l.Slug)
+		}
+	}
+
+	result := languagesResult{Detected: map[string]int{}}
+	for _, slug := range slugs {
+		libCtx, err := e.withLibrary(ctx, slug)
+		if err == nil {
+			var n int
+			if n, err = e.songs.DetectLanguages(libCtx); err == nil {
+				result.Detected[slug] = n
+				result.Total += n
+				continue
+			}
+		}
+		if result.Errors == nil {
+			result.Errors = map[string]string{}
+		}
+		result.Errors[slug] = err.Error()
+	}
+
+	if *library != "" && len(result.Errors) > 0 {
+		return fail(g.json, ExitFailure, fmt.Errorf("detect languages: %s", result.Errors[*library]))
+	}
+
+	report(os.Stdout, g.json, result, func(w io.Writer) {
+		sort.Strings(slugs)
+		for _, slug := range slugs {
+			if msg, failed := result.Errors[slug]; failed {
+				fmt.Fprintf(w, "%s: %s\n", slug, msg)
+				continue
+			}
+			fmt.Fprintf(w, "%s: detected %d\n", slug, result.Detected[slug])
+		}
+		fmt.Fprintf(w, "detected the language of %d songs\n", result.Total)
+	})
+
+	if len(result.Errors) > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
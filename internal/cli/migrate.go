@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/db"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/pkg/migrator"
+)
+
+const migrateUsage = `usage: songlib migrate [--config file] [--json] <command>
+
+commands:
+  up           apply all pending migrations
+  down <n>     roll back the last n migrations
+  goto <v>     migrate up or down to version v
+  version      print the current version
+  force <v>    mark version v as applied and clear the dirty flag`
+
+type migrateResult struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+}
+
+func runMigrate(args []string) int {
+	var g globalFlags
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	g.register(flags)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, migrateUsage) }
+	if !parseFlags(flags, args, 1, 2) {
+		return ExitUsage
+	}
+
+	command := flags.Arg(0)
+	var arg int
+	switch command {
+	case "up", "version":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return ExitUsage
+		}
+	case "down", "goto", "force":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return ExitUsage
+		}
+		n, err := strconv.Atoi(flags.Arg(1))
+		if err != nil || n < 0 || (command == "down" && n == 0) {
+			return fail(g.json, ExitUsage, fmt.Errorf("%s: argument must be a non-negative integer", command))
+		}
+		arg = n
+	default:
+		flags.Usage()
+		return ExitUsage
+	}
+
+	// Migrations must run even when MIGRATE_ON_START is off, so this command
+	// does not use setup.
+	cfg, err := config.LoadConfig(g.configArgs())
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+
+	if cfg.Storage != config.StoragePostgres {
+		return fail(g.json, ExitFailure, fmt.Errorf("migrations apply to STORAGE=postgres only, the %s store creates its schema itself", cfg.Storage))
+	}
+
+	logger := log.InitLogger(cfg.LogLevel, cfg.LogFormat)
+
+	dbase, err := db.Init(cfg)
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("open database: %w", err))
+	}
+	defer func() {
+		if err := db.Close(dbase); err != nil {
+			logger.Errorf("Failed to close database: %v", err)
+		}
+	}()
+
+	ctx := context.Background()
+
+	if err := db.WaitForConnection(ctx, dbase, cfg.DBConnectRetries, cfg.DBConnectRetryDelay, logger); err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+
+	m, err := migrator.New(ctx, dbase)
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("initialize migrations: %w", err))
+	}
+	defer m.Close()
+
+	switch command {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Down(arg)
+	case "goto":
+		err = m.Goto(uint(arg))
+	case "force":
+		err = m.Force(arg)
+	}
+	if errors.Is(err, migrator.ErrNoChange) {
+		logger.Info("No migrations to apply")
+		err = nil
+	}
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("migrate %s: %w", command, err))
+	}
+
+	var result migrateResult
+	result.Version, result.Dirty, err = m.Version()
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("read schema version: %w", err))
+	}
+
+	report(os.Stdout, g.json, result, func(w io.Writer) {
+		if result.Dirty {
+			fmt.Fprintf(w, "%d (dirty)\n", result.Version)
+		} else {
+			fmt.Fprintln(w, result.Version)
+		}
+	})
+	return ExitOK
+}
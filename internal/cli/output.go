@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// report prints the result of a command: v as JSON in --json mode,
+// otherwise the text produced by human.
+func report(w io.Writer, asJSON bool, v interface{}, human func(w io.Writer)) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return
+	}
+	human(w)
+}
+
+// fail prints err, as {"error": ...} on stdout in --json mode, and returns
+// code.
+func fail(asJSON bool, code int, err error) int {
+	if asJSON {
+		report(os.Stdout, true, map[string]string{"error": err.Error()}, nil)
+	} else {
+		fmt.Fprintln(os.Stderr, "error:", err)
+	}
+	return code
+}
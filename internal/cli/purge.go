@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const purgeUsage = `usage: songlib purge-trash [--library slug] [--older-than 720h] [--config file] [--json]
+  permanently remove deleted songs, from every library unless --library is given`
+
+type purgeResult struct {
+	Purged map[string]int    `json:"purged"`
+	Total  int               `json:"total"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func runPurgeTrash(args []string) int {
+	var g globalFlags
+	flags := flag.NewFlagSet("purge-trash", flag.ContinueOnError)
+	g.register(flags)
+	library := flags.String("library", "", "only purge this library")
+	olderThan := flags.Duration("older-than", 30*24*time.Hour, "only purge songs deleted longer ago than this")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, purgeUsage) }
+	if !parseFlags(flags, args, 0, 0) {
+		return ExitUsage
+	}
+	if *olderThan < 0 {
+		return fail(g.json, ExitUsage, fmt.Errorf("--older-than must not be negative"))
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx = operatorContext(ctx)
+
+	slugs := []string{*library}
+	if *library == "" {
+		libraries, err := e.libraries.GetLibraries(ctx)
+		if err != nil {
+			return fail(g.json, ExitFailure, fmt.Errorf("list libraries: %w", err))
+		}
+		slugs = slugs[:0]
+		for _, l := range libraries {
+			slugs = append(slugs, l.Slug)
+		}
+	}
+
+	result := purgeResult{Purged: map[string]int{}}
+	for _, slug := range slugs {
+		libCtx, err := e.withLibrary(ctx, slug)
+		if err == nil {
+			var n int
+			if n, err = e.songs.PurgeTrash(libCtx, *olderThan); err == nil {
+				result.Purged[slug] = n
+				result.Total += n
+				continue
+			}
+		}
+		if result.Errors == nil {
+			result.Errors = map[string]string{}
+		}
+		result.Errors[slug] = err.Error()
+	}
+
+	// A single library that failed is a plain failure, not a partial one.
+	if *library != "" && len(result.Errors) > 0 {
+		return fail(g.json, ExitFailure, fmt.Errorf("purge trash: %s", result.Errors[*library]))
+	}
+
+	report(os.Stdout, g.json, result, func(w io.Writer) {
+		sort.Strings(slugs)
+		for _, slug := range slugs {
+			if msg, failed := result.Errors[slug]; failed {
+				fmt.Fprintf(w, "%s: %s\n", slug, msg)
+				continue
+			}
+			fmt.Fprintf(w, "%s: purged %d\n", slug, result.Purged[slug])
+		}
+		fmt.Fprintf(w, "purged %d songs\n", result.Total)
+	})
+
+	if len(result.Errors) > 0 {
+		return ExitPartial
+	}
+	return ExitOK
+}
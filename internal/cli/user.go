@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+)
+
+const userUsage = `usage:
+  songlib user create [--role reader|editor|admin] [--library slug] [--json] <username>
+                       create a user account; the password is read from
+                       SONGLIB_PASSWORD or the first line of standard input`
+
+// passwordEnv names the variable the password is taken from, so it does
+// not have to appear in the process arguments.
+const passwordEnv = "SONGLIB_PASSWORD"
+
+func runUser(args []string) int {
+	if len(args) < 1 || args[0] != "create" {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return ExitUsage
+	}
+
+	var g globalFlags
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	g.register(flags)
+	role := flags.String("role", auth.RoleReader, "role granted to the user")
+	library := flags.String("library", "", "bind the user to a single library")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, userUsage) }
+	if !parseFlags(flags, args[1:], 1, 1) {
+		return ExitUsage
+	}
+
+	password, err := readPassword(os.Stdin)
+	if err != nil {
+		return fail(g.json, ExitUsage, err)
+	}
+
+	ctx := context.Background()
+	e, err := setup(ctx, &g)
+	if err != nil {
+		return fail(g.json, ExitFailure, err)
+	}
+	defer e.close()
+
+	ctx = operatorContext(ctx)
+
+	if *library != "" {
+		if _, err := e.withLibrary(ctx, *library); err != nil {
+			return fail(g.json, ExitFailure, fmt.Errorf("create user: %w", err))
+		}
+	}
+
+	user, err := e.auth.CreateUser(ctx, flags.Arg(0), password, *role, *library)
+	if err != nil {
+		return fail(g.json, ExitFailure, fmt.Errorf("create user: %w", err))
+	}
+
+	report(os.Stdout, g.json, user, func(w io.Writer) {
+		fmt.Fprintf(w, "id:       %d\nusername: %s\nrole:     %s\nlibrary:  %s\n", user.ID, user.Username, user.Role, user.Library)
+	})
+	return ExitOK
+}
+
+func readPassword(in io.Reader) (string, error) {
+	if password := os.Getenv(passwordEnv); password != "" {
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password is required, set %s or pass it on standard input", passwordEnv)
+	}
+	return password, nil
+}
@@ -1,25 +1,86 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/VadimBorzenkov/online-song-library/config"
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
 )
 
 var DB *sql.DB
 
-func Init(cfg *config.Config) *sql.DB {
-	url := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPass, cfg.DBName)
+// Init opens the primary database pool.
+func Init(cfg *config.Config) (*sql.DB, error) {
+	dsn, err := DSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return open(dsn, cfg)
+}
 
-	db, err := sql.Open("postgres", url)
+// InitReplica opens the read replica pool, or returns nil if
+// DATABASE_REPLICA_URL is not set.
+func InitReplica(cfg *config.Config) (*sql.DB, error) {
+	dsn, err := ReplicaDSN(cfg)
+	if err != nil || dsn == "" {
+		return nil, err
+	}
+	return open(dsn, cfg)
+}
 
+func open(dsn string, cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+
+	return db, nil
+}
+
+// WaitForConnection pings the database until it answers, giving up after
+// attempts tries. The delay between tries doubles each time, capped at 30s.
+func WaitForConnection(ctx context.Context, db *sql.DB, attempts int, delay time.Duration, logger *logrus.Logger) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err = db.PingContext(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		logger.WithFields(logrus.Fields{
+			"attempt": attempt,
+			"of":      attempts,
+			"retryIn": delay,
+		}).Warn("Database is not reachable yet: ", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > 30*time.Second {
+			delay = 30 * time.Second
+		}
 	}
 
-	return db
+	return fmt.Errorf("database not reachable after %d attempts: %w", attempts, err)
 }
 
 func Close(db *sql.DB) error {
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/lib/pq"
+)
+
+// DSN returns the lib/pq connection string for the primary database:
+// DATABASE_URL if set, otherwise built from the DB_* settings.
+func DSN(cfg *config.Config) (string, error) {
+	if cfg.DatabaseURL != "" {
+		return withOptions(cfg.DatabaseURL, cfg)
+	}
+
+	return withOptions(strings.Join([]string{
+		param("host", cfg.DBHost),
+		param("port", strconv.Itoa(cfg.DBPort)),
+		param("user", cfg.DBUser),
+		param("password", cfg.DBPass),
+		param("dbname", cfg.DBName),
+	}, " "), cfg)
+}
+
+// ReplicaDSN returns the connection string for the read replica, or "" if
+// none is configured.
+func ReplicaDSN(cfg *config.Config) (string, error) {
+	if cfg.DatabaseReplicaURL == "" {
+		return "", nil
+	}
+	return withOptions(cfg.DatabaseReplicaURL, cfg)
+}
+
+// withOptions converts a postgres:// URL to key=value form and appends the
+// SSL, application_name and statement_timeout settings it does not set
+// itself, so options given in the URL win.
+func withOptions(dsn string, cfg *config.Config) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		converted, err := pq.ParseURL(dsn)
+		if err != nil {
+			return "", fmt.Errorf("parse database url: %w", err)
+		}
+		dsn = converted
+	}
+
+	options := [][2]string{
+		{"sslmode", cfg.DBSSLMode},
+		{"sslrootcert", cfg.DBSSLRootCert},
+		{"sslcert", cfg.DBSSLCert},
+		{"sslkey", cfg.DBSSLKey},
+		{"application_name", cfg.DBApplicationName},
+	}
+	if cfg.DBStatementTimeout > 0 {
+		// Unknown keys are sent to the server as run-time parameters.
+		options = append(options, [2]string{"statement_timeout", strconv.FormatInt(cfg.DBStatementTimeout.Milliseconds(), 10)})
+	}
+
+	parts := []string{dsn}
+	for _, opt := range options {
+		if opt[1] == "" || hasParam(dsn, opt[0]) {
+			continue
+		}
+		parts = append(parts, param(opt[0], opt[1]))
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func hasParam(dsn, key string) bool {
+	return regexp.MustCompile(`(^|\s)` + regexp.QuoteMeta(key) + `\s*=`).MatchString(dsn)
+}
+
+// param formats a key=value pair, quoting the value as lib/pq expects.
+func param(key, value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return key + "=" + value
+	}
+	value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return key + "='" + value + "'"
+}
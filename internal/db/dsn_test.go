@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		want string
+	}{
+		{
+			"settings",
+			config.Config{DBHost: "db", DBPort: 5432, DBUser: "songlib", DBPass: "s3cret", DBName: "songs"},
+			"host=db port=5432 user=songlib password=s3cret dbname=songs",
+		},
+		{
+			"quoted values",
+			config.Config{DBHost: "db", DBPort: 5433, DBUser: "songlib", DBPass: `it's a \ pass`, DBName: ""},
+			`host=db port=5433 user=songlib password='it\'s a \\ pass' dbname=''`,
+		},
+		{
+			"options",
+			config.Config{
+				DBHost: "db", DBPort: 5432, DBUser: "songlib", DBPass: "s3cret", DBName: "songs",
+				DBSSLMode: "verify-full", DBSSLRootCert: "/etc/ssl/root ca.pem", DBSSLCert: "/etc/ssl/client.pem", DBSSLKey: "/etc/ssl/client.key",
+				DBApplicationName: "songlib", DBStatementTimeout: 1500 * time.Millisecond,
+			},
+			"host=db port=5432 user=songlib password=s3cret dbname=songs sslmode=verify-full sslrootcert='/etc/ssl/root ca.pem' sslcert=/etc/ssl/client.pem sslkey=/etc/ssl/client.key application_name=songlib statement_timeout=1500",
+		},
+		{
+			"url",
+			config.Config{DatabaseURL: "postgres://songlib:s3cret@db:5432/songs", DBHost: "ignored", DBSSLMode: "require"},
+			"dbname='songs' host='db' password='s3cret' port='5432' user='songlib' sslmode=require",
+		},
+		{
+			"url options win",
+			config.Config{DatabaseURL: "postgresql://songlib@db/songs?sslmode=disable&application_name=migrations", DBSSLMode: "require", DBApplicationName: "songlib", DBStatementTimeout: time.Second},
+			"application_name='migrations' dbname='songs' host='db' sslmode='disable' user='songlib' statement_timeout=1000",
+		},
+		{
+			"key value url",
+			config.Config{DatabaseURL: "host=/var/run/postgresql dbname=songs sslmode = disable", DBSSLMode: "require"},
+			"host=/var/run/postgresql dbname=songs sslmode = disable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn, err := DSN(&tt.cfg)
+			if err != nil {
+				t.Fatalf("DSN: %v", err)
+			}
+			if dsn != tt.want {
+				t.Errorf("DSN =\n  %s\nwant\n  %s", dsn, tt.want)
+			}
+		})
+	}
+
+	if _, err := DSN(&config.Config{DatabaseURL: "postgres://db:port/songs"}); err == nil {
+		t.Error("DSN accepted an invalid url")
+	}
+}
+
+func TestReplicaDSN(t *testing.T) {
+	cfg := &config.Config{DBSSLMode: "verify-ca", DBApplicationName: "songlib"}
+	if dsn, err := ReplicaDSN(cfg); err != nil || dsn != "" {
+		t.Fatalf("ReplicaDSN without a replica = %q, %v", dsn, err)
+	}
+
+	cfg.DatabaseReplicaURL = "postgres://reader@replica/songs"
+	dsn, err := ReplicaDSN(cfg)
+	if err != nil {
+		t.Fatalf("ReplicaDSN: %v", err)
+	}
+	if want := "dbname='songs' host='replica' user='reader' sslmode=verify-ca application_name=songlib"; dsn != want {
+		t.Errorf("ReplicaDSN = %s, want %s", dsn, want)
+	}
+}
+
+func TestInitPool(t *testing.T) {
+	cfg := &config.Config{DBHost: "127.0.0.1", DBPort: 1, DBMaxOpenConns: 7, DBMaxIdleConns: 3, DBConnMaxLifetime: time.Minute}
+	db, err := Init(cfg)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	defer db.Close()
+
+	if stats := db.Stats(); stats.MaxOpenConnections != 7 {
+		t.Errorf("MaxOpenConnections = %d, want 7", stats.MaxOpenConnections)
+	}
+}
+
+func TestWaitForConnection(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// Nothing listens on port 1, so every ping fails at once.
+	db, err := Init(&config.Config{DBHost: "127.0.0.1", DBPort: 1, DBSSLMode: "disable"})
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	if err := WaitForConnection(context.Background(), db, 3, 10*time.Millisecond, logger); err == nil {
+		t.Fatal("WaitForConnection succeeded without a database")
+	}
+	// Two delays: 10ms, then 20ms.
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("WaitForConnection gave up after %s, before retrying", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := WaitForConnection(ctx, db, 100, time.Second, logger); !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitForConnection after cancel = %v, want context.Canceled", err)
+	}
+}
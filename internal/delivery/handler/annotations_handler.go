@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type annotationRequest struct {
+	models.AnnotationAnchor
+	Body string `json:"body"`
+}
+
+type voteRequest struct {
+	Value int `json:"value"`
+}
+
+// annotationError maps an annotation service error to a response.
+func annotationError(ctx *fiber.Ctx, logger logrus.FieldLogger, err error, message string) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrAnnotationNotFound), errors.Is(err, service.ErrSongNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrInvalidAnnotation):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, service.ErrAnnotationForbidden):
+		status = fiber.StatusForbidden
+	case errors.Is(err, service.ErrAuthenticationRequired):
+		status = fiber.StatusUnauthorized
+	default:
+		logger.WithField("error", err).Error(message)
+	}
+
+	return ctx.Status(status).JSON(ErrorResponse{
+		Error:   err.Error(),
+		Message: message,
+	})
+}
+
+// GetAnnotations lists the annotations of a song.
+// @Summary List song annotations
+// @Description Lists the annotations of a song in text order, including orphaned ones whose passage is no longer in the text
+// @Tags annotations
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 200 {object} DataResponseAnnotations
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/annotations [get]
+func (h *ApiAnnotationHandler) GetAnnotations(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	annotations, err := h.serv.GetAnnotations(ctx.UserContext(), songID)
+	if err != nil {
+		return annotationError(ctx, logger, err, "Failed to fetch annotations")
+	}
+
+	return ctx.JSON(DataResponseAnnotations{
+		Data:    annotations,
+		Message: "Annotations retrieved successfully",
+	})
+}
+
+// AddAnnotation annotates a passage of a song's text.
+// @Summary Annotate song text
+// @Description Annotates a range of lines or characters within a verse. Verses are the blocks of text separated by an empty line, as in get_song; all indexes start at 0 and the end is exclusive
+// @Tags annotations
+// @Accept json
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param request body annotationRequest true "Anchor and text of the annotation"
+// @Success 201 {object} DataResponseAnnotation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/annotations [post]
+func (h *ApiAnnotationHandler) AddAnnotation(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	var req annotationRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	annotation, err := h.serv.AddAnnotation(ctx.UserContext(), songID, req.AnnotationAnchor, req.Body)
+	if err != nil {
+		return annotationError(ctx, logger, err, "Failed to add annotation")
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(DataResponseAnnotation{
+		Data:    annotation,
+		Message: "Annotation added successfully",
+	})
+}
+
+// UpdateAnnotation changes the text or the anchor of an annotation.
+// @Summary Update annotation
+// @Description Changes the text or the anchor of an annotation. Only its author or an admin may change it. A new anchor clears the orphaned flag
+// @Tags annotations
+// @Accept json
+// @Produce json
+// @Param id path int true "Annotation ID"
+// @Param request body models.AnnotationUpdate true "Fields to change"
+// @Success 200 {object} DataResponseAnnotation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /annotations/{id} [put]
+func (h *ApiAnnotationHandler) UpdateAnnotation(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Annotation")
+	if !ok {
+		return err
+	}
+
+	var update models.AnnotationUpdate
+	if err := ctx.BodyParser(&update); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	annotation, err := h.serv.UpdateAnnotation(ctx.UserContext(), id, update)
+	if err != nil {
+		return annotationError(ctx, logger, err, "Failed to update annotation")
+	}
+
+	return ctx.JSON(DataResponseAnnotation{
+		Data:    annotation,
+		Message: "Annotation updated successfully",
+	})
+}
+
+// DeleteAnnotation removes an annotation.
+// @Summary Delete annotation
+// @Description Removes an annotation and its votes. Only its author or an admin may remove it
+// @Tags annotations
+// @Produce json
+// @Param id path int true "Annotation ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /annotations/{id} [delete]
+func (h *ApiAnnotationHandler) DeleteAnnotation(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Annotation")
+	if !ok {
+		return err
+	}
+
+	if err := h.serv.DeleteAnnotation(ctx.UserContext(), id); err != nil {
+		return annotationError(ctx, logger, err, "Failed to delete annotation")
+	}
+
+	return ctx.JSON(SuccessResponse{
+		Message: "Annotation deleted successfully",
+	})
+}
+
+// VoteAnnotation sets the caller's vote on an annotation.
+// @Summary Vote on annotation
+// @Description Votes an annotation up (1) or down (-1), or withdraws the caller's vote (0). Returns the annotation with the new total
+// @Tags annotations
+// @Accept json
+// @Produce json
+// @Param id path int true "Annotation ID"
+// @Param request body voteRequest true "Vote"
+// @Success 200 {object} DataResponseAnnotation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /annotations/{id}/vote [put]
+func (h *ApiAnnotationHandler) VoteAnnotation(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Annotation")
+	if !ok {
+		return err
+	}
+
+	var req voteRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	annotation, err := h.serv.VoteAnnotation(ctx.UserContext(), id, req.Value)
+	if err != nil {
+		return annotationError(ctx, logger, err, "Failed to vote on annotation")
+	}
+
+	return ctx.JSON(DataResponseAnnotation{
+		Data:    annotation,
+		Message: "Vote saved successfully",
+	})
+}
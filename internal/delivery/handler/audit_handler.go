@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// GetAuditEvents lists audit events, newest first, or exports them as NDJSON.
+// @Summary Get audit events
+// @Description Lists recorded mutations with optional filters. With format=ndjson or Accept: application/x-ndjson all matching events are streamed as newline delimited JSON in chronological order, ignoring pagination.
+// @Tags audit
+// @Produce json
+// @Produce application/x-ndjson
+// @Param actor query string false "Filter by actor, e.g. api_key:12"
+// @Param action query string false "Filter by action (create, update, delete, import)"
+// @Param library query string false "Filter by library slug"
+// @Param entity query string false "Filter by entity (song, library)"
+// @Param entity_id query int false "Filter by entity ID"
+// @Param request_id query string false "Filter by request ID"
+// @Param from query string false "Events at or after this RFC 3339 time"
+// @Param to query string false "Events before this RFC 3339 time"
+// @Param format query string false "Set to ndjson to export"
+// @Param limit query int false "Number of results to return (default is 50)"
+// @Param page query int false "Page number for pagination (default is 1)"
+// @Success 200 {object} DataResponseAuditEvents
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /audit/ [get]
+func (h *ApiAuditHandler) GetAuditEvents(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	filter := models.AuditFilter{
+		Actor:     ctx.Query("actor"),
+		Action:    ctx.Query("action"),
+		Library:   ctx.Query("library"),
+		Entity:    ctx.Query("entity"),
+		RequestID: ctx.Query("request_id"),
+	}
+
+	if entityID := ctx.Query("entity_id"); entityID != "" {
+		id, err := strconv.Atoi(entityID)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   err.Error(),
+				Message: "Entity ID must be a valid integer",
+			})
+		}
+		filter.EntityID = id
+	}
+
+	for name, dest := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := ctx.Query(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   err.Error(),
+				Message: "Parameter " + name + " must be an RFC 3339 timestamp",
+			})
+		}
+		*dest = &t
+	}
+
+	// Credentials bound to a library only ever see that library's history.
+	if principal := auth.PrincipalFromContext(ctx.UserContext()); principal != nil && principal.Library != "" {
+		filter.Library = principal.Library
+	}
+
+	if ctx.Query("format") == "ndjson" || strings.Contains(ctx.Get(fiber.HeaderAccept), ndjsonContentType) {
+		// The stream writer runs after the handler returns, so the user
+		// context is captured here while the request is still live.
+		userCtx := ctx.UserContext()
+		ctx.Set(fiber.HeaderContentType, ndjsonContentType)
+		ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := h.serv.ExportAuditEvents(userCtx, filter, w); err != nil {
+				logger.WithField("error", err).Error("Error exporting audit events")
+			}
+			w.Flush()
+		})
+		return nil
+	}
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "50"))
+	if err != nil || limit <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid limit value",
+			Message: "Limit must be a positive integer",
+		})
+	}
+
+	page, err := strconv.Atoi(ctx.Query("page", "1"))
+	if err != nil || page <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid page value",
+			Message: "Page must be a positive integer",
+		})
+	}
+
+	events, err := h.serv.GetAuditEvents(ctx.UserContext(), filter, limit, (page-1)*limit)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"filter": filter,
+			"error":  err,
+		}).Error("Error fetching audit events")
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Failed to fetch audit events",
+		})
+	}
+
+	return ctx.JSON(DataResponseAuditEvents{
+		Data:    events,
+		Message: "Audit events retrieved successfully",
+	})
+}
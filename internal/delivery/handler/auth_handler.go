@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Login exchanges a username and password for a session token.
+// @Summary Log in
+// @Description Checks the password and returns a session token to send as "Authorization: Bearer <token>" or X-API-Key
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body loginRequest true "Credentials"
+// @Success 200 {object} LoginResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /auth/login [post]
+func (h *ApiAuthHandler) Login(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	var req loginRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+	if req.Username == "" || req.Password == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Username and password are required",
+			Message: "Please provide both username and password",
+		})
+	}
+
+	token, session, err := h.serv.Login(ctx.UserContext(), req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return ctx.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Invalid username or password",
+			})
+		}
+		logger.WithField("error", err).Error("Error logging in")
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Failed to log in",
+		})
+	}
+
+	return ctx.JSON(LoginResponse{
+		Token:     token,
+		ExpiresAt: session.ExpiresAt,
+		Message:   "Logged in successfully",
+	})
+}
+
+// Logout ends the session the request is authenticated with.
+// @Summary Log out
+// @Description Revokes the session token used for the request
+// @Tags auth
+// @Produce json
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security BearerAuth
+// @Router /auth/logout [post]
+func (h *ApiAuthHandler) Logout(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	if err := h.serv.Logout(ctx.UserContext()); err != nil {
+		if errors.Is(err, service.ErrNoSession) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   err.Error(),
+				Message: "Only session tokens can be logged out",
+			})
+		}
+		logger.WithField("error", err).Error("Error logging out")
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Failed to log out",
+		})
+	}
+
+	return ctx.JSON(SuccessResponse{
+		Message: "Logged out successfully",
+	})
+}
@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
 	"github.com/VadimBorzenkov/online-song-library/internal/models"
 	"github.com/VadimBorzenkov/online-song-library/internal/service"
 	"github.com/gofiber/fiber/v2"
@@ -13,6 +16,82 @@ type Handler interface {
 	DeleteSong(ctx *fiber.Ctx) error
 	AddNewSong(ctx *fiber.Ctx) error
 	UpdateSong(ctx *fiber.Ctx) error
+	SetExplicit(ctx *fiber.Ctx) error
+	MergeSongs(ctx *fiber.Ctx) error
+}
+
+type LibraryHandler interface {
+	GetLibraries(ctx *fiber.Ctx) error
+	CreateLibrary(ctx *fiber.Ctx) error
+	CopySongs(ctx *fiber.Ctx) error
+}
+
+type PlaylistHandler interface {
+	GetPlaylists(ctx *fiber.Ctx) error
+	GetPlaylist(ctx *fiber.Ctx) error
+	CreatePlaylist(ctx *fiber.Ctx) error
+	UpdatePlaylist(ctx *fiber.Ctx) error
+	DeletePlaylist(ctx *fiber.Ctx) error
+	DuplicatePlaylist(ctx *fiber.Ctx) error
+	AddPlaylistEntry(ctx *fiber.Ctx) error
+	RemovePlaylistEntry(ctx *fiber.Ctx) error
+	MovePlaylistEntry(ctx *fiber.Ctx) error
+	ExportPlaylist(ctx *fiber.Ctx) error
+}
+
+type AuthHandler interface {
+	Login(ctx *fiber.Ctx) error
+	Logout(ctx *fiber.Ctx) error
+}
+
+type ListeningHandler interface {
+	GetMe(ctx *fiber.Ctx) error
+	AddFavorite(ctx *fiber.Ctx) error
+	RemoveFavorite(ctx *fiber.Ctx) error
+	RecordPlay(ctx *fiber.Ctx) error
+	GetFavorites(ctx *fiber.Ctx) error
+	GetHistory(ctx *fiber.Ctx) error
+	GetTop(ctx *fiber.Ctx) error
+}
+
+type ReviewHandler interface {
+	SaveReview(ctx *fiber.Ctx) error
+	GetOwnReview(ctx *fiber.Ctx) error
+	DeleteReview(ctx *fiber.Ctx) error
+	GetSongReviews(ctx *fiber.Ctx) error
+	GetReviews(ctx *fiber.Ctx) error
+	ModerateReview(ctx *fiber.Ctx) error
+}
+
+type AnnotationHandler interface {
+	GetAnnotations(ctx *fiber.Ctx) error
+	AddAnnotation(ctx *fiber.Ctx) error
+	UpdateAnnotation(ctx *fiber.Ctx) error
+	DeleteAnnotation(ctx *fiber.Ctx) error
+	VoteAnnotation(ctx *fiber.Ctx) error
+}
+
+type TagHandler interface {
+	GetTags(ctx *fiber.Ctx) error
+	GetSongTags(ctx *fiber.Ctx) error
+	TagSongs(ctx *fiber.Ctx) error
+	GetSongFacets(ctx *fiber.Ctx) error
+}
+
+type TranslationHandler interface {
+	GetTranslations(ctx *fiber.Ctx) error
+	SaveTranslation(ctx *fiber.Ctx) error
+	DeleteTranslation(ctx *fiber.Ctx) error
+	GetVerses(ctx *fiber.Ctx) error
+}
+
+type AuditHandler interface {
+	GetAuditEvents(ctx *fiber.Ctx) error
+}
+
+type HealthHandler interface {
+	Healthz(ctx *fiber.Ctx) error
+	Readyz(ctx *fiber.Ctx) error
 }
 
 type CommonResponse struct {
@@ -39,6 +118,118 @@ type DataResponseSong struct {
 	Message string       `json:"message"`
 }
 
+type DataResponseLibrary struct {
+	Data    *models.Library `json:"data"`
+	Message string          `json:"message"`
+}
+
+type DataResponseLibraries struct {
+	Data    []models.Library `json:"data"`
+	Message string           `json:"message"`
+}
+
+type CopySongsResponse struct {
+	Copied  int64  `json:"copied"`
+	Message string `json:"message"`
+}
+
+type DataResponsePlaylist struct {
+	Data    *models.Playlist `json:"data"`
+	Message string           `json:"message"`
+}
+
+type DataResponsePlaylists struct {
+	Data    []models.Playlist `json:"data"`
+	Message string            `json:"message"`
+}
+
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Message   string    `json:"message"`
+}
+
+type DataResponsePrincipal struct {
+	Data    *auth.Principal `json:"data"`
+	Message string          `json:"message"`
+}
+
+type DataResponsePlay struct {
+	Data    *models.Play `json:"data"`
+	Message string       `json:"message"`
+}
+
+type DataResponsePlays struct {
+	Data    []models.Play `json:"data"`
+	Message string        `json:"message"`
+}
+
+type DataResponseTop struct {
+	Data    *models.TopReport `json:"data"`
+	Message string            `json:"message"`
+}
+
+type DataResponseReview struct {
+	Data    *models.Review `json:"data"`
+	Message string         `json:"message"`
+}
+
+type DataResponseReviews struct {
+	Data    []models.Review `json:"data"`
+	Message string          `json:"message"`
+}
+
+type DataResponseAnnotation struct {
+	Data    *models.Annotation `json:"data"`
+	Message string             `json:"message"`
+}
+
+type DataResponseAnnotations struct {
+	Data    []models.Annotation `json:"data"`
+	Message string              `json:"message"`
+}
+
+type DataResponseTags struct {
+	Data    []models.TagCount `json:"data"`
+	Message string            `json:"message"`
+}
+
+type DataResponseSongTags struct {
+	Data    []models.Tag `json:"data"`
+	Message string       `json:"message"`
+}
+
+type TagSongsResponse struct {
+	Added   int64  `json:"added"`
+	Removed int64  `json:"removed"`
+	Message string `json:"message"`
+}
+
+type DataResponseFacets struct {
+	Data    *models.SongFacets `json:"data"`
+	Message string             `json:"message"`
+}
+
+type DataResponseTranslation struct {
+	Data    *models.Translation `json:"data"`
+	Message string              `json:"message"`
+}
+
+type DataResponseTranslations struct {
+	Data    []models.Translation `json:"data"`
+	Message string               `json:"message"`
+}
+
+type DataResponseVerses struct {
+	Data    *models.SongVerses `json:"data"`
+	Message string             `json:"message"`
+}
+
+type DataResponseAuditEvents struct {
+	Data    []models.AuditEvent `json:"data"`
+	Message string              `json:"message"`
+}
+
 type ApiHandler struct {
 	serv   service.SongService
 	logger *logrus.Logger
@@ -47,3 +238,93 @@ type ApiHandler struct {
 func NewApiHandler(serv service.SongService, logger *logrus.Logger) *ApiHandler {
 	return &ApiHandler{serv: serv, logger: logger}
 }
+
+type ApiLibraryHandler struct {
+	serv   service.LibraryService
+	logger *logrus.Logger
+}
+
+func NewApiLibraryHandler(serv service.LibraryService, logger *logrus.Logger) *ApiLibraryHandler {
+	return &ApiLibraryHandler{serv: serv, logger: logger}
+}
+
+type ApiPlaylistHandler struct {
+	serv   service.PlaylistService
+	logger *logrus.Logger
+}
+
+func NewApiPlaylistHandler(serv service.PlaylistService, logger *logrus.Logger) *ApiPlaylistHandler {
+	return &ApiPlaylistHandler{serv: serv, logger: logger}
+}
+
+type ApiAuthHandler struct {
+	serv   service.AuthService
+	logger *logrus.Logger
+}
+
+func NewApiAuthHandler(serv service.AuthService, logger *logrus.Logger) *ApiAuthHandler {
+	return &ApiAuthHandler{serv: serv, logger: logger}
+}
+
+type ApiListeningHandler struct {
+	serv   service.ListeningService
+	logger *logrus.Logger
+}
+
+func NewApiListeningHandler(serv service.ListeningService, logger *logrus.Logger) *ApiListeningHandler {
+	return &ApiListeningHandler{serv: serv, logger: logger}
+}
+
+type ApiReviewHandler struct {
+	serv   service.ReviewService
+	logger *logrus.Logger
+}
+
+func NewApiReviewHandler(serv service.ReviewService, logger *logrus.Logger) *ApiReviewHandler {
+	return &ApiReviewHandler{serv: serv, logger: logger}
+}
+
+type ApiAnnotationHandler struct {
+	serv   service.AnnotationService
+	logger *logrus.Logger
+}
+
+func NewApiAnnotationHandler(serv service.AnnotationService, logger *logrus.Logger) *ApiAnnotationHandler {
+	return &ApiAnnotationHandler{serv: serv, logger: logger}
+}
+
+type ApiTagHandler struct {
+	serv   service.TagService
+	logger *logrus.Logger
+}
+
+func NewApiTagHandler(serv service.TagService, logger *logrus.Logger) *ApiTagHandler {
+	return &ApiTagHandler{serv: serv, logger: logger}
+}
+
+type ApiTranslationHandler struct {
+	serv   service.TranslationService
+	logger *logrus.Logger
+}
+
+func NewApiTranslationHandler(serv service.TranslationService, logger *logrus.Logger) *ApiTranslationHandler {
+	return &ApiTranslationHandler{serv: serv, logger: logger}
+}
+
+type ApiAuditHandler struct {
+	serv   service.AuditService
+	logger *logrus.Logger
+}
+
+func NewApiAuditHandler(serv service.AuditService, logger *logrus.Logger) *ApiAuditHandler {
+	return &ApiAuditHandler{serv: serv, logger: logger}
+}
+
+type ApiHealthHandler struct {
+	serv   service.HealthService
+	logger *logrus.Logger
+}
+
+func NewApiHealthHandler(serv service.HealthService, logger *logrus.Logger) *ApiHealthHandler {
+	return &ApiHealthHandler{serv: serv, logger: logger}
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Healthz reports that the process is alive.
+// @Summary Liveness probe
+// @Description Returns 200 as long as the process is able to serve requests
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.HealthReport
+// @Router /healthz [get]
+func (h *ApiHealthHandler) Healthz(ctx *fiber.Ctx) error {
+	return ctx.JSON(h.serv.Liveness())
+}
+
+// Readyz reports whether the service's dependencies are usable.
+// @Summary Readiness probe
+// @Description Checks the database connection, the schema version and, if enabled, the external API. Returns per-dependency status with latencies.
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.HealthReport
+// @Failure 503 {object} models.HealthReport
+// @Router /readyz [get]
+func (h *ApiHealthHandler) Readyz(ctx *fiber.Ctx) error {
+	report := h.serv.Readiness(ctx.UserContext())
+	if report.Status != service.HealthStatusOK {
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(report)
+	}
+
+	return ctx.JSON(report)
+}
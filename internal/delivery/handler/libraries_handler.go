@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type libraryRequest struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+type copySongsRequest struct {
+	Target  string `json:"target"`
+	SongIDs []int  `json:"song_ids"`
+}
+
+// GetLibraries lists all libraries.
+// @Summary List libraries
+// @Description Lists every library of the instance, or only the bound one for credentials bound to a library
+// @Tags libraries
+// @Produce json
+// @Success 200 {object} DataResponseLibraries
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /libraries/ [get]
+func (h *ApiLibraryHandler) GetLibraries(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	libraries, err := h.serv.GetLibraries(ctx.UserContext())
+	if err != nil {
+		logger.WithField("error", err).Error("Error fetching libraries")
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Failed to fetch libraries",
+		})
+	}
+
+	return ctx.JSON(DataResponseLibraries{
+		Data:    libraries,
+		Message: "Libraries retrieved successfully",
+	})
+}
+
+// CreateLibrary creates a new library.
+// @Summary Create library
+// @Description Creates a new library that songs can be added to
+// @Tags libraries
+// @Accept json
+// @Produce json
+// @Param request body libraryRequest true "New library"
+// @Success 201 {object} DataResponseLibrary
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /libraries/ [post]
+func (h *ApiLibraryHandler) CreateLibrary(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	var req libraryRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	if req.Slug == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Slug field is required",
+			Message: "Please provide a library slug",
+		})
+	}
+
+	library, err := h.serv.CreateLibrary(ctx.UserContext(), req.Slug, req.Name)
+	if err != nil {
+		if errors.Is(err, service.ErrLibraryForbidden) {
+			return ctx.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+				Error:   "Forbidden",
+				Message: err.Error(),
+			})
+		}
+		logger.WithFields(logrus.Fields{
+			"slug":  req.Slug,
+			"error": err,
+		}).Error("Error creating library")
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "Failed to create library",
+			Message: err.Error(),
+		})
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(DataResponseLibrary{
+		Data:    library,
+		Message: "Library created successfully",
+	})
+}
+
+// CopySongs copies songs from one library into another.
+// @Summary Copy songs between libraries
+// @Description Copies the given songs, or all songs when song_ids is empty, into the target library
+// @Tags libraries
+// @Accept json
+// @Produce json
+// @Param slug path string true "Source library slug"
+// @Param request body copySongsRequest true "Target library and songs"
+// @Success 200 {object} CopySongsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /libraries/{slug}/copy [post]
+func (h *ApiLibraryHandler) CopySongs(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	var req copySongsRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	if req.Target == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Target field is required",
+			Message: "Please provide the target library slug",
+		})
+	}
+
+	source := ctx.Params("slug")
+	copied, err := h.serv.CopySongs(ctx.UserContext(), source, req.Target, req.SongIDs)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"from":  source,
+			"to":    req.Target,
+			"error": err,
+		}).Error("Error copying songs")
+		if errors.Is(err, service.ErrLibraryNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "Library not found",
+				Message: err.Error(),
+			})
+		}
+		if errors.Is(err, service.ErrLibraryForbidden) {
+			return ctx.Status(fiber.StatusForbidden).JSON(ErrorResponse{
+				Error:   "Forbidden",
+				Message: err.Error(),
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "Failed to copy songs",
+			Message: err.Error(),
+		})
+	}
+
+	return ctx.JSON(CopySongsResponse{
+		Copied:  copied,
+		Message: "Songs copied successfully",
+	})
+}
@@ -0,0 +1,312 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// listeningError maps a listening service error to a response.
+func listeningError(ctx *fiber.Ctx, logger logrus.FieldLogger, err error, message string) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrSongNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrAuthenticationRequired):
+		status = fiber.StatusUnauthorized
+	default:
+		logger.WithField("error", err).Error(message)
+	}
+
+	return ctx.Status(status).JSON(ErrorResponse{
+		Error:   err.Error(),
+		Message: message,
+	})
+}
+
+// pagination parses the limit and page query parameters, writing a 400
+// response if they are invalid.
+func pagination(ctx *fiber.Ctx, defaultLimit int) (limit, offset int, ok bool, err error) {
+	limit, err = strconv.Atoi(ctx.Query("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit <= 0 {
+		return 0, 0, false, ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid limit value",
+			Message: "Limit must be a positive integer",
+		})
+	}
+
+	page, err := strconv.Atoi(ctx.Query("page", "1"))
+	if err != nil || page <= 0 {
+		return 0, 0, false, ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid page value",
+			Message: "Page must be a positive integer",
+		})
+	}
+
+	return limit, (page - 1) * limit, true, nil
+}
+
+// parseWindow parses a top window: a number of days such as 30d, a Go
+// duration such as 12h, or "all" for all time, returned as zero.
+func parseWindow(window string) (time.Duration, error) {
+	if window == "all" {
+		return 0, nil
+	}
+
+	if days, ok := strings.CutSuffix(window, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", window)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", window)
+	}
+	return d, nil
+}
+
+// GetMe describes the caller.
+// @Summary Current principal
+// @Description Returns who the request is authenticated as and with which scopes
+// @Tags me
+// @Produce json
+// @Success 200 {object} DataResponsePrincipal
+// @Failure 401 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /me/ [get]
+func (h *ApiListeningHandler) GetMe(ctx *fiber.Ctx) error {
+	principal := auth.PrincipalFromContext(ctx.UserContext())
+	if principal == nil {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(ErrorResponse{
+			Error:   "Unauthorized",
+			Message: "Authentication required",
+		})
+	}
+
+	return ctx.JSON(DataResponsePrincipal{
+		Data:    principal,
+		Message: "Principal retrieved successfully",
+	})
+}
+
+// AddFavorite marks a song as a favorite of the caller.
+// @Summary Add favorite
+// @Description Marks a song as a favorite of the caller; marking it again has no effect
+// @Tags me
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/favorite [put]
+func (h *ApiListeningHandler) AddFavorite(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	if err := h.serv.AddFavorite(ctx.UserContext(), songID); err != nil {
+		return listeningError(ctx, logger, err, "Failed to add favorite")
+	}
+
+	return ctx.JSON(SuccessResponse{
+		Message: "Song added to favorites",
+	})
+}
+
+// RemoveFavorite unmarks a favorite of the caller.
+// @Summary Remove favorite
+// @Description Removes a song from the caller's favorites
+// @Tags me
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/favorite [delete]
+func (h *ApiListeningHandler) RemoveFavorite(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	if err := h.serv.RemoveFavorite(ctx.UserContext(), songID); err != nil {
+		return listeningError(ctx, logger, err, "Failed to remove favorite")
+	}
+
+	return ctx.JSON(SuccessResponse{
+		Message: "Song removed from favorites",
+	})
+}
+
+// RecordPlay records that the caller played a song.
+// @Summary Record play
+// @Description Adds a play of the song to the caller's history and increments its play count
+// @Tags me
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 201 {object} DataResponsePlay
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/plays [post]
+func (h *ApiListeningHandler) RecordPlay(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	play, err := h.serv.RecordPlay(ctx.UserContext(), songID)
+	if err != nil {
+		return listeningError(ctx, logger, err, "Failed to record play")
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(DataResponsePlay{
+		Data:    play,
+		Message: "Play recorded successfully",
+	})
+}
+
+// GetFavorites lists the caller's favorites.
+// @Summary List favorites
+// @Description Lists the caller's favorite songs, most recently added first
+// @Tags me
+// @Produce json
+// @Param limit query int false "Number of results to return (default is 20)"
+// @Param page query int false "Page number for pagination (default is 1)"
+// @Success 200 {object} DataResponseSongs
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /me/favorites [get]
+func (h *ApiListeningHandler) GetFavorites(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	limit, offset, ok, err := pagination(ctx, 20)
+	if !ok {
+		return err
+	}
+
+	songs, err := h.serv.GetFavorites(ctx.UserContext(), limit, offset)
+	if err != nil {
+		return listeningError(ctx, logger, err, "Failed to fetch favorites")
+	}
+
+	return ctx.JSON(DataResponseSongs{
+		Data:    songs,
+		Message: "Favorites retrieved successfully",
+	})
+}
+
+// GetHistory lists the caller's plays.
+// @Summary Listening history
+// @Description Lists the caller's plays, newest first
+// @Tags me
+// @Produce json
+// @Param limit query int false "Number of results to return (default is 20)"
+// @Param page query int false "Page number for pagination (default is 1)"
+// @Success 200 {object} DataResponsePlays
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /me/history [get]
+func (h *ApiListeningHandler) GetHistory(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	limit, offset, ok, err := pagination(ctx, 20)
+	if !ok {
+		return err
+	}
+
+	plays, err := h.serv.GetHistory(ctx.UserContext(), limit, offset)
+	if err != nil {
+		return listeningError(ctx, logger, err, "Failed to fetch listening history")
+	}
+
+	return ctx.JSON(DataResponsePlays{
+		Data:    plays,
+		Message: "Listening history retrieved successfully",
+	})
+}
+
+// GetTop reports the caller's most played songs and groups.
+// @Summary Most played
+// @Description Reports the caller's most played songs and groups over a window
+// @Tags me
+// @Produce json
+// @Param window query string false "Days such as 7d, a duration such as 12h, or all (default is 30d)"
+// @Param limit query int false "Number of songs and groups to return (default is 10)"
+// @Success 200 {object} DataResponseTop
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /me/top [get]
+func (h *ApiListeningHandler) GetTop(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	window, err := parseWindow(ctx.Query("window", "30d"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Window must be a number of days such as 7d, a duration such as 12h, or all",
+		})
+	}
+
+	limit, _, ok, err := pagination(ctx, 10)
+	if !ok {
+		return err
+	}
+
+	report, err := h.serv.GetTop(ctx.UserContext(), window, limit)
+	if err != nil {
+		return listeningError(ctx, logger, err, "Failed to fetch most played")
+	}
+
+	return ctx.JSON(DataResponseTop{
+		Data:    report,
+		Message: "Most played retrieved successfully",
+	})
+}
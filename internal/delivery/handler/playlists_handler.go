@@ -0,0 +1,506 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type playlistRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Visibility  string `json:"visibility" enums:"private,public"`
+}
+
+type duplicatePlaylistRequest struct {
+	Name string `json:"name"`
+}
+
+type playlistEntryRequest struct {
+	SongID int `json:"song_id"`
+	// Position is where to insert the song, counted from 1. Zero appends it.
+	Position int `json:"position"`
+}
+
+type movePlaylistEntryRequest struct {
+	Position int `json:"position"`
+}
+
+var playlistContentTypes = map[string]string{
+	service.ExportM3U:  "audio/x-mpegurl",
+	service.ExportXSPF: "application/xspf+xml",
+}
+
+// playlistError maps a playlist service error to a response.
+func playlistError(ctx *fiber.Ctx, logger logrus.FieldLogger, err error, message string) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrPlaylistNotFound), errors.Is(err, service.ErrPlaylistEntryNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrPlaylistForbidden):
+		status = fiber.StatusForbidden
+	case errors.Is(err, service.ErrInvalidPlaylist), errors.Is(err, service.ErrUnknownExportFormat):
+		status = fiber.StatusBadRequest
+	default:
+		logger.WithField("error", err).Error(message)
+	}
+
+	return ctx.Status(status).JSON(ErrorResponse{
+		Error:   err.Error(),
+		Message: message,
+	})
+}
+
+// intParam parses the named path parameter as an ID, writing a 400
+// response if it is not one.
+func intParam(ctx *fiber.Ctx, name, what string) (int, bool, error) {
+	id, err := strconv.Atoi(ctx.Params(name))
+	if err != nil {
+		return 0, false, ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid " + what + " ID",
+			Message: what + " ID must be a valid integer",
+		})
+	}
+	return id, true, nil
+}
+
+// GetPlaylists lists the playlists the caller can see.
+// @Summary List playlists
+// @Description Lists public playlists and the caller's own; admins see all
+// @Tags playlists
+// @Produce json
+// @Param limit query int false "Number of results to return (default is 20)"
+// @Param page query int false "Page number for pagination (default is 1)"
+// @Success 200 {object} DataResponsePlaylists
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/ [get]
+func (h *ApiPlaylistHandler) GetPlaylists(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "20"))
+	if err != nil || limit <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid limit value",
+			Message: "Limit must be a positive integer",
+		})
+	}
+
+	page, err := strconv.Atoi(ctx.Query("page", "1"))
+	if err != nil || page <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid page value",
+			Message: "Page must be a positive integer",
+		})
+	}
+
+	playlists, err := h.serv.GetPlaylists(ctx.UserContext(), limit, (page-1)*limit)
+	if err != nil {
+		return playlistError(ctx, logger, err, "Failed to fetch playlists")
+	}
+
+	return ctx.JSON(DataResponsePlaylists{
+		Data:    playlists,
+		Message: "Playlists retrieved successfully",
+	})
+}
+
+// GetPlaylist returns a playlist with its entries in order.
+// @Summary Get playlist
+// @Description Returns a playlist the caller can see, with its entries in order
+// @Tags playlists
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Success 200 {object} DataResponsePlaylist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id} [get]
+func (h *ApiPlaylistHandler) GetPlaylist(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+
+	playlist, err := h.serv.GetPlaylist(ctx.UserContext(), id)
+	if err != nil {
+		return playlistError(ctx, logger, err, "Failed to fetch playlist")
+	}
+
+	return ctx.JSON(DataResponsePlaylist{
+		Data:    playlist,
+		Message: "Playlist retrieved successfully",
+	})
+}
+
+// CreatePlaylist creates a playlist owned by the caller.
+// @Summary Create playlist
+// @Description Creates an empty playlist owned by the caller, private unless visibility is public
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param request body playlistRequest true "New playlist"
+// @Success 201 {object} DataResponsePlaylist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/ [post]
+func (h *ApiPlaylistHandler) CreatePlaylist(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	var req playlistRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	playlist := &models.Playlist{
+		Name:        req.Name,
+		Description: req.Description,
+		Visibility:  req.Visibility,
+	}
+	if err := h.serv.CreatePlaylist(ctx.UserContext(), playlist); err != nil {
+		return playlistError(ctx, logger, err, "Failed to create playlist")
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(DataResponsePlaylist{
+		Data:    playlist,
+		Message: "Playlist created successfully",
+	})
+}
+
+// UpdatePlaylist changes the name, description or visibility of a playlist.
+// @Summary Update playlist
+// @Description Changes the given fields of a playlist owned by the caller
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param request body models.PlaylistUpdate true "Fields to change"
+// @Success 200 {object} DataResponsePlaylist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id} [put]
+func (h *ApiPlaylistHandler) UpdatePlaylist(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+
+	var update models.PlaylistUpdate
+	if err := ctx.BodyParser(&update); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	playlist, err := h.serv.UpdatePlaylist(ctx.UserContext(), id, update)
+	if err != nil {
+		return playlistError(ctx, logger, err, "Failed to update playlist")
+	}
+
+	return ctx.JSON(DataResponsePlaylist{
+		Data:    playlist,
+		Message: "Playlist updated successfully",
+	})
+}
+
+// DeletePlaylist deletes a playlist. The songs stay in the library.
+// @Summary Delete playlist
+// @Description Deletes a playlist owned by the caller; its songs stay in the library
+// @Tags playlists
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id} [delete]
+func (h *ApiPlaylistHandler) DeletePlaylist(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+
+	if err := h.serv.DeletePlaylist(ctx.UserContext(), id); err != nil {
+		return playlistError(ctx, logger, err, "Failed to delete playlist")
+	}
+
+	return ctx.JSON(SuccessResponse{
+		Message: "Playlist deleted successfully",
+	})
+}
+
+// DuplicatePlaylist copies a playlist into a new one owned by the caller.
+// @Summary Duplicate playlist
+// @Description Copies a playlist the caller can see, entries included, into a new private playlist owned by the caller
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param request body duplicatePlaylistRequest false "Name of the copy, defaults to the original name with (copy) appended"
+// @Success 201 {object} DataResponsePlaylist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id}/duplicate [post]
+func (h *ApiPlaylistHandler) DuplicatePlaylist(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+
+	var req duplicatePlaylistRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&req); err != nil {
+			logger.WithField("error", err).Warn("Failed to parse request body")
+			return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+				Error:   "Invalid request body",
+				Message: err.Error(),
+			})
+		}
+	}
+
+	playlist, err := h.serv.DuplicatePlaylist(ctx.UserContext(), id, req.Name)
+	if err != nil {
+		return playlistError(ctx, logger, err, "Failed to duplicate playlist")
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(DataResponsePlaylist{
+		Data:    playlist,
+		Message: "Playlist duplicated successfully",
+	})
+}
+
+// AddPlaylistEntry adds a song to a playlist.
+// @Summary Add song to playlist
+// @Description Inserts a song at the given position, or appends it when position is 0
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param request body playlistEntryRequest true "Song and position"
+// @Success 201 {object} DataResponsePlaylist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id}/entries [post]
+func (h *ApiPlaylistHandler) AddPlaylistEntry(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+
+	var req playlistEntryRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+	if req.SongID <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Song ID field is required",
+			Message: "Please provide the song_id to add",
+		})
+	}
+
+	playlist, err := h.serv.AddEntry(ctx.UserContext(), id, req.SongID, req.Position)
+	if err != nil {
+		return playlistError(ctx, logger, err, "Failed to add song to playlist")
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(DataResponsePlaylist{
+		Data:    playlist,
+		Message: "Song added to playlist successfully",
+	})
+}
+
+// RemovePlaylistEntry removes an entry from a playlist.
+// @Summary Remove song from playlist
+// @Description Removes one entry from a playlist; the song stays in the library
+// @Tags playlists
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param entry_id path int true "Entry ID"
+// @Success 200 {object} DataResponsePlaylist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id}/entries/{entry_id} [delete]
+func (h *ApiPlaylistHandler) RemovePlaylistEntry(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+	entryID, ok, err := intParam(ctx, "entry_id", "Entry")
+	if !ok {
+		return err
+	}
+
+	playlist, err := h.serv.RemoveEntry(ctx.UserContext(), id, entryID)
+	if err != nil {
+		return playlistError(ctx, logger, err, "Failed to remove song from playlist")
+	}
+
+	return ctx.JSON(DataResponsePlaylist{
+		Data:    playlist,
+		Message: "Song removed from playlist successfully",
+	})
+}
+
+// MovePlaylistEntry moves an entry to another position.
+// @Summary Move playlist entry
+// @Description Moves an entry to the given position, counted from 1; the entries in between shift by one
+// @Tags playlists
+// @Accept json
+// @Produce json
+// @Param id path int true "Playlist ID"
+// @Param entry_id path int true "Entry ID"
+// @Param request body movePlaylistEntryRequest true "New position"
+// @Success 200 {object} DataResponsePlaylist
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id}/entries/{entry_id}/position [put]
+func (h *ApiPlaylistHandler) MovePlaylistEntry(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+	entryID, ok, err := intParam(ctx, "entry_id", "Entry")
+	if !ok {
+		return err
+	}
+
+	var req movePlaylistEntryRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	playlist, err := h.serv.MoveEntry(ctx.UserContext(), id, entryID, req.Position)
+	if err != nil {
+		return playlistError(ctx, logger, err, "Failed to move playlist entry")
+	}
+
+	return ctx.JSON(DataResponsePlaylist{
+		Data:    playlist,
+		Message: "Playlist entry moved successfully",
+	})
+}
+
+// ExportPlaylist downloads a playlist for media players.
+// @Summary Export playlist
+// @Description Downloads a playlist as M3U or XSPF built from the song links; songs without a link are left out
+// @Tags playlists
+// @Produce audio/x-mpegurl
+// @Produce application/xspf+xml
+// @Param id path int true "Playlist ID"
+// @Param format query string false "m3u (default) or xspf"
+// @Success 200 {string} string
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /playlists/{id}/export [get]
+func (h *ApiPlaylistHandler) ExportPlaylist(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Playlist")
+	if !ok {
+		return err
+	}
+
+	format := ctx.Query("format", service.ExportM3U)
+
+	// Playlists are small, so the export is rendered before replying and
+	// errors still get a proper status.
+	var buf bytes.Buffer
+	if err := h.serv.ExportPlaylist(ctx.UserContext(), id, format, &buf); err != nil {
+		return playlistError(ctx, logger, err, "Failed to export playlist")
+	}
+
+	ctx.Set(fiber.HeaderContentType, playlistContentTypes[format])
+	ctx.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="playlist-%d.%s"`, id, format))
+	return ctx.Send(buf.Bytes())
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type reviewRequest struct {
+	Rating int    `json:"rating"`
+	Body   string `json:"body"`
+}
+
+type reviewStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// reviewError maps a review service error to a response.
+func reviewError(ctx *fiber.Ctx, logger logrus.FieldLogger, err error, message string) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrReviewNotFound), errors.Is(err, service.ErrSongNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrInvalidReview):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, service.ErrReviewForbidden):
+		status = fiber.StatusForbidden
+	case errors.Is(err, service.ErrAuthenticationRequired):
+		status = fiber.StatusUnauthorized
+	default:
+		logger.WithField("error", err).Error(message)
+	}
+
+	return ctx.Status(status).JSON(ErrorResponse{
+		Error:   err.Error(),
+		Message: message,
+	})
+}
+
+// SaveReview adds or replaces the caller's review of a song.
+// @Summary Rate and review a song
+// @Description Adds or replaces the caller's rating (1-5) and optional review of a song. Reviews with a body wait for moderation
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param request body reviewRequest true "Rating and review"
+// @Success 200 {object} DataResponseReview
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/review [put]
+func (h *ApiReviewHandler) SaveReview(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	var req reviewRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	review, err := h.serv.SaveReview(ctx.UserContext(), songID, req.Rating, req.Body)
+	if err != nil {
+		return reviewError(ctx, logger, err, "Failed to save review")
+	}
+
+	return ctx.JSON(DataResponseReview{
+		Data:    review,
+		Message: "Review saved successfully",
+	})
+}
+
+// GetOwnReview returns the caller's review of a song.
+// @Summary Own review
+// @Description Returns the caller's review of a song in any moderation state
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 200 {object} DataResponseReview
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/review [get]
+func (h *ApiReviewHandler) GetOwnReview(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	review, err := h.serv.GetOwnReview(ctx.UserContext(), songID)
+	if err != nil {
+		return reviewError(ctx, logger, err, "Failed to fetch review")
+	}
+
+	return ctx.JSON(DataResponseReview{
+		Data:    review,
+		Message: "Review retrieved successfully",
+	})
+}
+
+// DeleteReview removes the caller's review of a song.
+// @Summary Delete own review
+// @Description Removes the caller's review of a song and its rating
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/review [delete]
+func (h *ApiReviewHandler) DeleteReview(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	if err := h.serv.DeleteReview(ctx.UserContext(), songID); err != nil {
+		return reviewError(ctx, logger, err, "Failed to delete review")
+	}
+
+	return ctx.JSON(SuccessResponse{
+		Message: "Review deleted successfully",
+	})
+}
+
+// GetSongReviews lists the reviews of a song.
+// @Summary List song reviews
+// @Description Lists approved reviews of a song, most recently updated first. Moderators may list other states
+// @Tags reviews
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param status query string false "approved (default), pending or rejected"
+// @Param limit query int false "Number of results to return (default is 10)"
+// @Param page query int false "Page number for pagination (default is 1)"
+// @Success 200 {object} DataResponseReviews
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/reviews [get]
+func (h *ApiReviewHandler) GetSongReviews(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	limit, offset, ok, err := pagination(ctx, 10)
+	if !ok {
+		return err
+	}
+
+	reviews, err := h.serv.GetSongReviews(ctx.UserContext(), songID, ctx.Query("status"), limit, offset)
+	if err != nil {
+		return reviewError(ctx, logger, err, "Failed to fetch reviews")
+	}
+
+	return ctx.JSON(DataResponseReviews{
+		Data:    reviews,
+		Message: "Reviews retrieved successfully",
+	})
+}
+
+// GetReviews lists the reviews of the library for moderation.
+// @Summary Moderation queue
+// @Description Lists reviews of the library with the given state, pending by default, most recently updated first
+// @Tags reviews
+// @Produce json
+// @Param status query string false "pending (default), approved or rejected"
+// @Param limit query int false "Number of results to return (default is 10)"
+// @Param page query int false "Page number for pagination (default is 1)"
+// @Success 200 {object} DataResponseReviews
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /reviews/ [get]
+func (h *ApiReviewHandler) GetReviews(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	limit, offset, ok, err := pagination(ctx, 10)
+	if !ok {
+		return err
+	}
+
+	reviews, err := h.serv.GetReviews(ctx.UserContext(), ctx.Query("status"), limit, offset)
+	if err != nil {
+		return reviewError(ctx, logger, err, "Failed to fetch reviews")
+	}
+
+	return ctx.JSON(DataResponseReviews{
+		Data:    reviews,
+		Message: "Reviews retrieved successfully",
+	})
+}
+
+// ModerateReview sets the moderation state of a review.
+// @Summary Moderate review
+// @Description Approves or rejects a review, or puts it back to pending. Only approved reviews count towards the song's rating
+// @Tags reviews
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param request body reviewStatusRequest true "New state"
+// @Success 200 {object} DataResponseReview
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /reviews/{id}/status [put]
+func (h *ApiReviewHandler) ModerateReview(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	id, ok, err := intParam(ctx, "id", "Review")
+	if !ok {
+		return err
+	}
+
+	var req reviewStatusRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	review, err := h.serv.ModerateReview(ctx.UserContext(), id, req.Status)
+	if err != nil {
+		return reviewError(ctx, logger, err, "Failed to moderate review")
+	}
+
+	return ctx.JSON(DataResponseReview{
+		Data:    review,
+		Message: "Review moderated successfully",
+	})
+}
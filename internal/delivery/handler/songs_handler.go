@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
 	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
 )
@@ -14,23 +17,18 @@ type request struct {
 	Song  string `json:"song"`
 }
 
-// GetSongs retrieves a list of songs based on optional filters, pagination, and limit.
-// @Summary Get songs
-// @Description Fetches a list of songs with optional filters and pagination
-// @Tags songs
-// @Accept json
-// @Produce json
-// @Param group query string false "Filter by group name"
-// @Param song query string false "Filter by song name"
-// @Param releaseDate query string false "Filter by release date"
-// @Param text query string false "Filter by text content"
-// @Param link query string false "Filter by link"
-// @Param limit query int false "Number of results to return (default is 10)"
-// @Param page query int false "Page number for pagination (default is 1)"
-// @Success 200 {object} DataResponseSongs
-// @Failure 400 {object} ErrorResponse
-// @Router /songs/ [get]
-func (h *ApiHandler) GetSongs(ctx *fiber.Ctx) error {
+type explicitRequest struct {
+	// Explicit overrides the wordlist; null hands the flag back to it.
+	Explicit *bool `json:"explicit"`
+}
+
+type mergeRequest struct {
+	// SourceIDs are the duplicates merged into the song.
+	SourceIDs []int `json:"source_ids"`
+}
+
+// songFilter collects the song filter of the GetSongs query parameters.
+func songFilter(ctx *fiber.Ctx) map[string]string {
 	filters := make(map[string]string)
 
 	if group := ctx.Query("group"); group != "" {
@@ -43,16 +41,51 @@ func (h *ApiHandler) GetSongs(ctx *fiber.Ctx) error {
 		filters["release_date"] = releaseDate
 	}
 
-	for _, key := range []string{"release_date", "text", "link"} {
+	for _, key := range []string{"release_date", "text", "link", "min_rating", "tags", "tag_mode", "language", "min_language_confidence", "explicit"} {
 		value := ctx.Query(key)
 		if value != "" {
 			filters[key] = value
 		}
 	}
+	return filters
+}
+
+// GetSongs retrieves a list of songs based on optional filters, pagination, and limit.
+// @Summary Get songs
+// @Description Fetches a list of songs with optional filters and pagination
+// @Tags songs
+// @Accept json
+// @Produce json
+// @Param group query string false "Filter by group name"
+// @Param song query string false "Filter by song name"
+// @Param releaseDate query string false "Filter by release date"
+// @Param text query string false "Filter by text content, in Cyrillic or Latin letters"
+// @Param link query string false "Filter by link"
+// @Param min_rating query number false "Only songs with an average rating of at least this (0-5)"
+// @Param tags query string false "Comma separated tags as type:name, e.g. genre:rock,mood:calm"
+// @Param tag_mode query string false "all (default) to require every tag, any to require one of them"
+// @Param language query string false "Only songs whose lyrics were detected to be in this language, as an ISO 639-1 code"
+// @Param min_language_confidence query number false "Only songs whose language was detected with at least this confidence (0-1)"
+// @Param explicit query bool false "Only explicit songs (true) or only songs that are not (false). Keys in clean mode never see explicit songs"
+// @Param sort query string false "Sort order: id (default), play_count, rating, or any of them with a leading minus for descending"
+// @Param limit query int false "Number of results to return (default is 10)"
+// @Param page query int false "Page number for pagination (default is 1)"
+// @Success 200 {object} DataResponseSongs
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/ [get]
+func (h *ApiHandler) GetSongs(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	filters := songFilter(ctx)
 
 	limit, err := strconv.Atoi(ctx.Query("limit", "10"))
 	if err != nil || limit <= 0 {
-		h.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"error": err,
 		}).Warn("Invalid limit value")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -63,7 +96,7 @@ func (h *ApiHandler) GetSongs(ctx *fiber.Ctx) error {
 
 	page, err := strconv.Atoi(ctx.Query("page", "1"))
 	if err != nil {
-		h.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"error": err,
 		}).Warn("Invalid page value")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
@@ -74,17 +107,20 @@ func (h *ApiHandler) GetSongs(ctx *fiber.Ctx) error {
 
 	offset := (page - 1) * limit
 	if offset < 0 {
-		h.logger.WithField("error", err).Warn("Invalid offset value")
+		logger.WithField("error", err).Warn("Invalid offset value")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Invalid offset value",
 			Message: "Offset must be a non-negative integer",
 		})
 	}
 
-	songs, err := h.serv.GetSongsWithPaginate(filters, limit, offset)
+	sort := ctx.Query("sort")
+
+	songs, err := h.serv.GetSongsWithPaginate(ctx.UserContext(), filters, sort, limit, offset)
 	if err != nil {
-		h.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"filters": filters,
+			"sort":    sort,
 			"limit":   limit,
 			"offset":  offset,
 			"error":   err,
@@ -95,7 +131,7 @@ func (h *ApiHandler) GetSongs(ctx *fiber.Ctx) error {
 		})
 	}
 
-	h.logger.WithFields(logrus.Fields{
+	logger.WithFields(logrus.Fields{
 		"count": len(songs),
 		"page":  page,
 		"limit": limit,
@@ -116,14 +152,24 @@ func (h *ApiHandler) GetSongs(ctx *fiber.Ctx) error {
 // @Param id path int true "Song ID"
 // @Param limit query int false "Number of verses to return (default is 5)"
 // @Param offset query int false "Offset for verses (default is 0)"
+// @Param annotations query bool false "Include the annotations of the returned verses"
+// @Param transliterate query string false "Write the verses in the Latin script with this scheme" Enums(iso9, gost, icao, informal)
 // @Success 200 {object} DataResponseSong
 // @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
 // @Router /songs/get_song/{id} [get]
 func (h *ApiHandler) GetSongWithVerses(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
 	songID, err := strconv.Atoi(ctx.Params("id"))
 	if err != nil {
-		h.logger.WithField("error", err).Warn("Invalid song ID")
+		logger.WithField("error", err).Warn("Invalid song ID")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   err.Error(),
 			Message: "Song ID must be a valid integer",
@@ -132,7 +178,7 @@ func (h *ApiHandler) GetSongWithVerses(ctx *fiber.Ctx) error {
 
 	limit, err := strconv.Atoi(ctx.Query("limit", "5"))
 	if err != nil || limit <= 0 {
-		h.logger.WithField("error", err).Warn("Invalid limit for verses")
+		logger.WithField("error", err).Warn("Invalid limit for verses")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   err.Error(),
 			Message: "Limit must be a positive integer",
@@ -141,16 +187,37 @@ func (h *ApiHandler) GetSongWithVerses(ctx *fiber.Ctx) error {
 
 	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
 	if err != nil || offset < 0 {
-		h.logger.WithField("error", err).Warn("Invalid offset value")
+		logger.WithField("error", err).Warn("Invalid offset value")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Invalid offset value",
 			Message: "Offset must be a non-negative integer",
 		})
 	}
 
-	song, err := h.serv.GetSongWithVerses(songID, limit, offset)
+	withAnnotations, err := strconv.ParseBool(ctx.Query("annotations", "false"))
+	if err != nil {
+		logger.WithField("error", err).Warn("Invalid annotations flag")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid annotations value",
+			Message: "Annotations must be true or false",
+		})
+	}
+
+	song, err := h.serv.GetSongWithVerses(ctx.UserContext(), songID, limit, offset, withAnnotations, ctx.Query("transliterate"))
+	if errors.Is(err, service.ErrUnknownScheme) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Invalid transliterate value",
+		})
+	}
+	if errors.Is(err, service.ErrSongNotFound) {
+		return ctx.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   "Song not found",
+			Message: fmt.Sprintf("Song with ID %d not found", songID),
+		})
+	}
 	if err != nil {
-		h.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"songID": songID,
 			"limit":  limit,
 			"offset": offset,
@@ -170,7 +237,7 @@ func (h *ApiHandler) GetSongWithVerses(ctx *fiber.Ctx) error {
 
 // DeleteSong removes a specific song by its ID.
 // @Summary Delete song
-// @Description Deletes a specific song by ID
+// @Description Moves a song to the trash. Trashed songs are hidden and removed for good by "songlib purge-trash"
 // @Tags songs
 // @Accept json
 // @Produce json
@@ -178,20 +245,27 @@ func (h *ApiHandler) GetSongWithVerses(ctx *fiber.Ctx) error {
 // @Success 200 {object} SuccessResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
 // @Router /songs/delete_song/{id} [delete]
 func (h *ApiHandler) DeleteSong(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
 	songID, err := strconv.Atoi(ctx.Params("id"))
 	if err != nil {
-		h.logger.WithField("error", err).Warn("Invalid song ID")
+		logger.WithField("error", err).Warn("Invalid song ID")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Invalid song ID",
 			Message: "Song ID must be a valid integer",
 		})
 	}
 
-	h.logger.WithField("songID", songID).Info("Deleting song")
+	logger.WithField("songID", songID).Info("Deleting song")
 
-	err = h.serv.DeleteSong(songID)
+	err = h.serv.DeleteSong(ctx.UserContext(), songID)
 	if err != nil {
 		if err.Error() == fmt.Sprintf("song with ID %d not found", songID) {
 			return ctx.Status(fiber.StatusNotFound).JSON(ErrorResponse{
@@ -200,7 +274,7 @@ func (h *ApiHandler) DeleteSong(ctx *fiber.Ctx) error {
 			})
 		}
 
-		h.logger.WithField("songID", songID).Error("Error deleting song")
+		logger.WithField("songID", songID).Error("Error deleting song")
 		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "Failed to delete song",
 			Message: err.Error(),
@@ -222,12 +296,20 @@ func (h *ApiHandler) DeleteSong(ctx *fiber.Ctx) error {
 // @Param song body models.Song true "Song data"
 // @Success 200 {object} DataResponseSong
 // @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
 // @Router /songs/update_song/{id} [put]
 func (h *ApiHandler) UpdateSong(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
 	songID, err := strconv.Atoi(ctx.Params("id"))
 	if err != nil {
-		h.logger.WithField("error", err).Warn("Invalid song ID")
+		logger.WithField("error", err).Warn("Invalid song ID")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Invalid song ID",
 			Message: "Song ID must be a valid integer",
@@ -236,7 +318,7 @@ func (h *ApiHandler) UpdateSong(ctx *fiber.Ctx) error {
 
 	var songData models.Song
 	if err := ctx.BodyParser(&songData); err != nil {
-		h.logger.WithField("error", err).Warn("Failed to parse request body")
+		logger.WithField("error", err).Warn("Failed to parse request body")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Invalid request body",
 			Message: "Failed to parse JSON",
@@ -246,15 +328,22 @@ func (h *ApiHandler) UpdateSong(ctx *fiber.Ctx) error {
 	songData.ID = songID
 
 	if songData.Song == "" && songData.Group == "" && songData.Text == "" && songData.Link == "" && songData.ReleaseDate == "" {
-		h.logger.WithField("songID", songID).Error("No fields to update")
+		logger.WithField("songID", songID).Error("No fields to update")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Failed to update song",
 			Message: "no fields to update",
 		})
 	}
 
-	if err := h.serv.UpdateSong(&songData); err != nil {
-		h.logger.WithField("songID", songID).Error("Error updating song")
+	if err := h.serv.UpdateSong(ctx.UserContext(), &songData); err != nil {
+		if err.Error() == fmt.Sprintf("song with ID %d not found", songID) {
+			return ctx.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+				Error:   "Song not found",
+				Message: fmt.Sprintf("Song with ID %d not found", songID),
+			})
+		}
+
+		logger.WithField("songID", songID).Error("Error updating song")
 		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
 			Error:   "Failed to update song",
 			Message: err.Error(),
@@ -267,6 +356,131 @@ func (h *ApiHandler) UpdateSong(ctx *fiber.Ctx) error {
 	})
 }
 
+// SetExplicit sets by hand whether a song is explicit.
+// @Summary Set explicit flag
+// @Description Overrides whether a song is explicit, which is otherwise told by the wordlist when its text is written. An explicit of null drops the override
+// @Tags songs
+// @Accept json
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param request body explicitRequest true "Explicit override"
+// @Success 200 {object} DataResponseSong
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/explicit [put]
+func (h *ApiHandler) SetExplicit(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		logger.WithField("error", err).Warn("Invalid song ID")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid song ID",
+			Message: "Song ID must be a valid integer",
+		})
+	}
+
+	var req explicitRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	song, err := h.serv.SetExplicit(ctx.UserContext(), songID, req.Explicit)
+	if errors.Is(err, service.ErrSongNotFound) {
+		return ctx.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   "Song not found",
+			Message: fmt.Sprintf("Song with ID %d not found", songID),
+		})
+	}
+	if err != nil {
+		logger.WithField("songID", songID).Error("Error setting explicit flag: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "Failed to set explicit flag",
+			Message: err.Error(),
+		})
+	}
+
+	return ctx.JSON(DataResponseSong{
+		Data:    song,
+		Message: "Explicit flag set successfully",
+	})
+}
+
+// MergeSongs merges duplicates into a song.
+// @Summary Merge songs
+// @Description Moves the plays, favorites, playlist entries, reviews and tags of the duplicates to the song and puts the duplicates in the trash. An actor's review of the song, or else their latest of the duplicates, wins. Annotations and translations stay with the duplicates
+// @Tags songs
+// @Accept json
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param request body mergeRequest true "Duplicates to merge"
+// @Success 200 {object} DataResponseSong
+// @Failure 400 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/merge [post]
+func (h *ApiHandler) MergeSongs(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		logger.WithField("error", err).Warn("Invalid song ID")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid song ID",
+			Message: "Song ID must be a valid integer",
+		})
+	}
+
+	var req mergeRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	song, err := h.serv.MergeSongs(ctx.UserContext(), songID, req.SourceIDs)
+	switch {
+	case errors.Is(err, service.ErrInvalidMerge):
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid merge",
+			Message: err.Error(),
+		})
+	case errors.Is(err, service.ErrSongNotFound):
+		return ctx.Status(fiber.StatusNotFound).JSON(ErrorResponse{
+			Error:   "Song not found",
+			Message: err.Error(),
+		})
+	case err != nil:
+		logger.WithField("songID", songID).Error("Error merging songs: ", err)
+		return ctx.Status(fiber.StatusInternalServerError).JSON(ErrorResponse{
+			Error:   "Failed to merge songs",
+			Message: err.Error(),
+		})
+	}
+
+	return ctx.JSON(DataResponseSong{
+		Data:    song,
+		Message: "Songs merged successfully",
+	})
+}
+
 // AddNewSong creates a new song entry based on the provided request data.
 // @Summary Add new song
 // @Description Adds a new song to the library
@@ -277,11 +491,18 @@ func (h *ApiHandler) UpdateSong(ctx *fiber.Ctx) error {
 // @Success 201 {object} DataResponseSong
 // @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
 // @Router /songs/add_song [post]
 func (h *ApiHandler) AddNewSong(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
 	var req request
 	if err := ctx.BodyParser(&req); err != nil {
-		h.logger.WithField("error", err).Warn("Failed to parse request body")
+		logger.WithField("error", err).Warn("Failed to parse request body")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Invalid request body",
 			Message: err.Error(),
@@ -289,15 +510,15 @@ func (h *ApiHandler) AddNewSong(ctx *fiber.Ctx) error {
 	}
 
 	if req.Group == "" || req.Song == "" {
-		h.logger.Warn("Group and Song fields are required")
+		logger.Warn("Group and Song fields are required")
 		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
 			Error:   "Group and Song fields are required",
 			Message: "Please provide both group and song names",
 		})
 	}
-	newSong, err := h.serv.AddNewSong(req.Group, req.Song)
+	newSong, err := h.serv.AddNewSong(ctx.UserContext(), req.Group, req.Song)
 	if err != nil {
-		h.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"group": req.Group,
 			"song":  req.Song,
 			"error": err,
@@ -308,7 +529,7 @@ func (h *ApiHandler) AddNewSong(ctx *fiber.Ctx) error {
 		})
 	}
 
-	h.logger.WithFields(logrus.Fields{
+	logger.WithFields(logrus.Fields{
 		"group": newSong.Group,
 		"song":  newSong.Song,
 	}).Info("New song added successfully")
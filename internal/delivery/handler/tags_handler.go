@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type tagSongsRequest struct {
+	SongIDs []int `json:"song_ids"`
+	// Add and Remove hold tags as type:name, e.g. genre:rock.
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// tagError maps a tag service error to a response.
+func tagError(ctx *fiber.Ctx, logger logrus.FieldLogger, err error, message string) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrSongNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrInvalidTag):
+		status = fiber.StatusBadRequest
+	default:
+		logger.WithField("error", err).Error(message)
+	}
+
+	return ctx.Status(status).JSON(ErrorResponse{
+		Error:   err.Error(),
+		Message: message,
+	})
+}
+
+// GetTags lists the tags of the library.
+// @Summary List tags
+// @Description Lists the tags of the library with the number of songs carrying each, optionally of one type only
+// @Tags tags
+// @Produce json
+// @Param type query string false "Tag type" Enums(genre, mood, language, era)
+// @Success 200 {object} DataResponseTags
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /tags [get]
+func (h *ApiTagHandler) GetTags(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	tags, err := h.serv.GetTags(ctx.UserContext(), ctx.Query("type"))
+	if err != nil {
+		return tagError(ctx, logger, err, "Failed to fetch tags")
+	}
+
+	return ctx.JSON(DataResponseTags{
+		Data:    tags,
+		Message: "Tags retrieved successfully",
+	})
+}
+
+// GetSongTags lists the tags of a song.
+// @Summary List song tags
+// @Description Lists the tags of a song ordered by type and name
+// @Tags tags
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 200 {object} DataResponseSongTags
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/tags [get]
+func (h *ApiTagHandler) GetSongTags(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	tags, err := h.serv.GetSongTags(ctx.UserContext(), songID)
+	if err != nil {
+		return tagError(ctx, logger, err, "Failed to fetch song tags")
+	}
+
+	return ctx.JSON(DataResponseSongTags{
+		Data:    tags,
+		Message: "Song tags retrieved successfully",
+	})
+}
+
+// TagSongs adds and removes tags of several songs at once.
+// @Summary Tag songs in bulk
+// @Description Adds and removes tags of up to 500 songs in one transaction. Tags are written as type:name, where the type is genre, mood, language or era; unknown tags are created. Every song must exist
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Param request body tagSongsRequest true "Songs and tags to add or remove"
+// @Success 200 {object} TagSongsResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/tags [post]
+func (h *ApiTagHandler) TagSongs(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	var req tagSongsRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	added, removed, err := h.serv.TagSongs(ctx.UserContext(), req.SongIDs, req.Add, req.Remove)
+	if err != nil {
+		return tagError(ctx, logger, err, "Failed to tag songs")
+	}
+
+	return ctx.JSON(TagSongsResponse{
+		Added:   added,
+		Removed: removed,
+		Message: fmt.Sprintf("Tagged %d songs", len(req.SongIDs)),
+	})
+}
+
+// GetSongFacets counts the songs matching a filter per tag, year and group.
+// @Summary Song facets
+// @Description Counts the songs matching the filter of get songs per tag, per release year and per group. Only the most frequent groups are returned
+// @Tags tags
+// @Produce json
+// @Param group query string false "Group name"
+// @Param song query string false "Song name"
+// @Param release_date query string false "Release date"
+// @Param text query string false "Full-text search query over song lyrics, in Cyrillic or Latin letters"
+// @Param link query string false "Link"
+// @Param min_rating query number false "Only songs with an average rating of at least this (0-5)"
+// @Param tags query string false "Comma separated tags as type:name, e.g. genre:rock,mood:calm"
+// @Param tag_mode query string false "all (default) to require every tag, any to require one of them"
+// @Param language query string false "ISO 639-1 code of the detected language"
+// @Param min_language_confidence query number false "Minimum confidence of the detected language (0-1)"
+// @Param explicit query bool false "Only explicit songs (true) or only songs that are not (false)"
+// @Param limit query int false "Number of groups to return" default(20)
+// @Success 200 {object} DataResponseFacets
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/facets [get]
+func (h *ApiTagHandler) GetSongFacets(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	filters := songFilter(ctx)
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "20"))
+	if err != nil || limit <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid limit value",
+			Message: "Limit must be a positive integer",
+		})
+	}
+
+	facets, err := h.serv.GetFacets(ctx.UserContext(), filters, limit)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"filters": filters,
+			"limit":   limit,
+			"error":   err,
+		}).Error("Error counting song facets")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   err.Error(),
+			Message: "Failed to count song facets",
+		})
+	}
+
+	return ctx.JSON(DataResponseFacets{
+		Data:    facets,
+		Message: "Facets retrieved successfully",
+	})
+}
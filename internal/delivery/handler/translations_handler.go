@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type translationRequest struct {
+	Text       string `json:"text"`
+	Translator string `json:"translator"`
+	// Alignment lists for each verse of the translation the verse of the
+	// original it renders. It may be left out to pair verses in order.
+	Alignment []int `json:"alignment"`
+}
+
+// translationError maps a translation service error to a response.
+func translationError(ctx *fiber.Ctx, logger logrus.FieldLogger, err error, message string) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, service.ErrTranslationNotFound), errors.Is(err, service.ErrSongNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, service.ErrInvalidTranslation), errors.Is(err, service.ErrUnknownScheme), errors.Is(err, repository.ErrOffsetOutOfRange):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, service.ErrAuthenticationRequired):
+		status = fiber.StatusUnauthorized
+	default:
+		logger.WithField("error", err).Error(message)
+	}
+
+	return ctx.Status(status).JSON(ErrorResponse{
+		Error:   err.Error(),
+		Message: message,
+	})
+}
+
+// GetTranslations lists the translations of a song.
+// @Summary List song translations
+// @Description Lists the translations of a song by language
+// @Tags translations
+// @Produce json
+// @Param id path int true "Song ID"
+// @Success 200 {object} DataResponseTranslations
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/translations [get]
+func (h *ApiTranslationHandler) GetTranslations(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	translations, err := h.serv.GetTranslations(ctx.UserContext(), songID)
+	if err != nil {
+		return translationError(ctx, logger, err, "Failed to fetch translations")
+	}
+
+	return ctx.JSON(DataResponseTranslations{
+		Data:    translations,
+		Message: "Translations retrieved successfully",
+	})
+}
+
+// SaveTranslation adds or replaces the translation of a song into a language.
+// @Summary Save song translation
+// @Description Adds the translation of a song into a language, given as a BCP-47 tag, or replaces it. The alignment lists for each verse of the translation the verse of the original it renders, in order; without it verses are paired in order
+// @Tags translations
+// @Accept json
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param lang path string true "BCP-47 language tag, e.g. en or pt-BR"
+// @Param request body translationRequest true "Translation"
+// @Success 200 {object} DataResponseTranslation
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/translations/{lang} [put]
+func (h *ApiTranslationHandler) SaveTranslation(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	var req translationRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		logger.WithField("error", err).Warn("Failed to parse request body")
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+	}
+
+	translation, err := h.serv.SaveTranslation(ctx.UserContext(), songID, ctx.Params("lang"), req.Text, req.Translator, req.Alignment)
+	if err != nil {
+		return translationError(ctx, logger, err, "Failed to save translation")
+	}
+
+	return ctx.JSON(DataResponseTranslation{
+		Data:    translation,
+		Message: "Translation saved successfully",
+	})
+}
+
+// DeleteTranslation removes the translation of a song into a language.
+// @Summary Delete song translation
+// @Description Removes the translation of a song into a language
+// @Tags translations
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param lang path string true "BCP-47 language tag"
+// @Success 200 {object} SuccessResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/translations/{lang} [delete]
+func (h *ApiTranslationHandler) DeleteTranslation(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	if err := h.serv.DeleteTranslation(ctx.UserContext(), songID, ctx.Params("lang")); err != nil {
+		return translationError(ctx, logger, err, "Failed to delete translation")
+	}
+
+	return ctx.JSON(SuccessResponse{
+		Message: "Translation deleted successfully",
+	})
+}
+
+// GetVerses returns verses of a song in the original or in a translation.
+// @Summary Get song verses in a language
+// @Description Returns verses of a song in the language given by lang, which must be the detected language of the original or translated, or else in the best match for the Accept-Language header among the original and its translations, falling back to the original. With with=original each verse of a translation is paired with the verse of the original it renders. With transliterate Cyrillic letters are written in Latin ones
+// @Tags translations
+// @Produce json
+// @Param id path int true "Song ID"
+// @Param lang query string false "BCP-47 language tag of the translation"
+// @Param with query string false "original to pair the verses with the original" Enums(original)
+// @Param transliterate query string false "Write the verses in the Latin script with this scheme" Enums(iso9, gost, icao, informal)
+// @Param limit query int false "Number of verses to return" default(5)
+// @Param offset query int false "Index of the first verse" default(0)
+// @Param Accept-Language header string false "Preferred languages, used without lang"
+// @Success 200 {object} DataResponseVerses
+// @Header 200 {string} Content-Language "Language of the returned verses"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Param X-Library header string false "Library slug, honoured for admins only"
+// @Router /songs/{id}/verses [get]
+func (h *ApiTranslationHandler) GetVerses(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), h.logger)
+
+	songID, ok, err := intParam(ctx, "id", "Song")
+	if !ok {
+		return err
+	}
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "5"))
+	if err != nil || limit <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid limit value",
+			Message: "Limit must be a positive integer",
+		})
+	}
+
+	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
+	if err != nil || offset < 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid offset value",
+			Message: "Offset must be a non-negative integer",
+		})
+	}
+
+	var withOriginal bool
+	switch with := ctx.Query("with"); with {
+	case "":
+	case "original":
+		withOriginal = true
+	default:
+		return ctx.Status(fiber.StatusBadRequest).JSON(ErrorResponse{
+			Error:   "Invalid with value",
+			Message: "With must be original",
+		})
+	}
+
+	verses, err := h.serv.GetVerses(ctx.UserContext(), songID, ctx.Query("lang"), ctx.Get(fiber.HeaderAcceptLanguage), withOriginal, ctx.Query("transliterate"), limit, offset)
+	if err != nil {
+		return translationError(ctx, logger, err, "Failed to fetch verses")
+	}
+
+	ctx.Vary(fiber.HeaderAcceptLanguage)
+	language := verses.Language
+	if language == "" {
+		language = verses.OriginalLanguage
+	}
+	if language != "" {
+		ctx.Set(fiber.HeaderContentLanguage, language)
+	}
+	return ctx.JSON(DataResponseVerses{
+		Data:    verses,
+		Message: "Verses retrieved successfully",
+	})
+}
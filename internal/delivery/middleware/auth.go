@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PrincipalKey = "principal"
+
+	apiKeyHeader = "X-API-Key"
+)
+
+type AuthMiddleware struct {
+	serv               service.AuthService
+	allowAnonymousRead bool
+	logger             *logrus.Logger
+}
+
+func NewAuthMiddleware(serv service.AuthService, allowAnonymousRead bool, logger *logrus.Logger) *AuthMiddleware {
+	return &AuthMiddleware{
+		serv:               serv,
+		allowAnonymousRead: allowAnonymousRead,
+		logger:             logger,
+	}
+}
+
+// Authenticate resolves credentials from the X-API-Key or Authorization
+// header. Requests without credentials pass through anonymously; the
+// Require* handlers decide whether that is acceptable for a route.
+func (m *AuthMiddleware) Authenticate(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), m.logger)
+
+	token := credentials(ctx)
+	if token == "" {
+		return ctx.Next()
+	}
+
+	principal, err := m.serv.Authenticate(ctx.UserContext(), token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) || errors.Is(err, auth.ErrJWTDisabled) {
+			logger.WithField("ip", ClientIP(ctx)).Warn("Rejected invalid credentials")
+			return ctx.Status(fiber.StatusUnauthorized).JSON(handler.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Invalid credentials",
+			})
+		}
+
+		logger.WithField("error", err).Error("Error authenticating request")
+		return ctx.Status(fiber.StatusInternalServerError).JSON(handler.ErrorResponse{
+			Error:   "Failed to authenticate request",
+			Message: err.Error(),
+		})
+	}
+
+	ctx.Locals(PrincipalKey, principal)
+	ctx.SetUserContext(auth.WithPrincipal(ctx.UserContext(), principal))
+	return ctx.Next()
+}
+
+// RequireScope rejects callers that lack the scope with 403, naming the
+// missing scope. Anonymous callers get 401, except for songs:read when
+// AUTH_ALLOW_ANONYMOUS_READ is set.
+func (m *AuthMiddleware) RequireScope(scope string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		principal := Principal(ctx)
+		if principal == nil {
+			if scope == auth.ScopeSongsRead && m.allowAnonymousRead {
+				return ctx.Next()
+			}
+			return ctx.Status(fiber.StatusUnauthorized).JSON(handler.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Authentication required",
+			})
+		}
+
+		if !principal.HasScope(scope) {
+			log.FromContext(ctx.UserContext(), m.logger).WithFields(logrus.Fields{
+				"actor": principal.Actor(),
+				"scope": scope,
+				"path":  ctx.Path(),
+			}).Warn("Permission denied")
+			return ctx.Status(fiber.StatusForbidden).JSON(handler.ErrorResponse{
+				Error:   "Forbidden",
+				Message: "Missing required scope: " + scope,
+			})
+		}
+
+		return ctx.Next()
+	}
+}
+
+func Principal(ctx *fiber.Ctx) *auth.Principal {
+	principal, _ := ctx.Locals(PrincipalKey).(*auth.Principal)
+	return principal
+}
+
+func credentials(ctx *fiber.Ctx) string {
+	if key := ctx.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	header := ctx.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return ""
+}
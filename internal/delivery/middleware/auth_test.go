@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/gofiber/fiber/v2"
+)
+
+// stubAuth knows the tokens "reader", "writer" and "admin", rejects "bad" and
+// "jwt" as invalid or with JWT disabled, and fails on "broken".
+type stubAuth struct {
+	service.AuthService
+}
+
+func (stubAuth) Authenticate(ctx context.Context, token string) (*auth.Principal, error) {
+	switch token {
+	case "reader":
+		return &auth.Principal{Method: auth.MethodApiKey, KeyID: 1, Scopes: []string{auth.ScopeSongsRead, auth.ScopeSongsInteract}}, nil
+	case "writer":
+		return &auth.Principal{Method: auth.MethodApiKey, KeyID: 2, Scopes: []string{auth.ScopeSongsRead, auth.ScopeSongsWrite}}, nil
+	case "admin":
+		return &auth.Principal{Method: auth.MethodJWT, Subject: "ops", Scopes: []string{auth.ScopeAdmin}}, nil
+	case "jwt":
+		return nil, auth.ErrJWTDisabled
+	case "broken":
+		return nil, errors.New("database is down")
+	}
+	return nil, auth.ErrInvalidCredentials
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		anonymous bool
+		header    string
+		value     string
+		scope     string
+		status    int
+		message   string
+	}{
+		{"anonymous", false, "", "", auth.ScopeSongsWrite, fiber.StatusUnauthorized, "Authentication required"},
+		{"anonymous read", false, "", "", auth.ScopeSongsRead, fiber.StatusUnauthorized, "Authentication required"},
+		{"anonymous read allowed", true, "", "", auth.ScopeSongsRead, fiber.StatusOK, ""},
+		{"anonymous interact with reads allowed", true, "", "", auth.ScopeSongsInteract, fiber.StatusUnauthorized, "Authentication required"},
+		{"invalid key", true, "X-API-Key", "bad", auth.ScopeSongsRead, fiber.StatusUnauthorized, "Invalid credentials"},
+		{"invalid bearer", true, "Authorization", "Bearer bad", auth.ScopeSongsRead, fiber.StatusUnauthorized, "Invalid credentials"},
+		{"jwt disabled", false, "Authorization", "Bearer jwt", auth.ScopeSongsRead, fiber.StatusUnauthorized, "Invalid credentials"},
+		{"authentication failure", false, "X-API-Key", "broken", auth.ScopeSongsRead, fiber.StatusInternalServerError, "database is down"},
+		{"key", false, "X-API-Key", "reader", auth.ScopeSongsRead, fiber.StatusOK, ""},
+		{"bearer", false, "Authorization", "Bearer reader", auth.ScopeSongsRead, fiber.StatusOK, ""},
+		{"lowercase bearer", false, "Authorization", "bearer  reader ", auth.ScopeSongsRead, fiber.StatusOK, ""},
+		{"basic auth", false, "Authorization", "Basic cmVhZGVy", auth.ScopeSongsRead, fiber.StatusUnauthorized, "Authentication required"},
+		{"missing write", false, "X-API-Key", "reader", auth.ScopeSongsWrite, fiber.StatusForbidden, "Missing required scope: songs:write"},
+		{"missing interact", false, "X-API-Key", "writer", auth.ScopeSongsInteract, fiber.StatusForbidden, "Missing required scope: songs:interact"},
+		{"missing delete", false, "X-API-Key", "writer", auth.ScopeSongsDelete, fiber.StatusForbidden, "Missing required scope: songs:delete"},
+		{"missing admin", false, "Authorization", "Bearer writer", auth.ScopeAdmin, fiber.StatusForbidden, "Missing required scope: admin"},
+		{"admin", false, "Authorization", "Bearer admin", auth.ScopeSongsDelete, fiber.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewAuthMiddleware(stubAuth{}, tt.anonymous, testLogger())
+			app := fiber.New()
+			app.Get("/", m.Authenticate, m.RequireScope(tt.scope), func(ctx *fiber.Ctx) error {
+				return ctx.SendString(auth.PrincipalFromContext(ctx.UserContext()).Actor())
+			})
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.message == "" {
+				return
+			}
+
+			var body handler.ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if body.Message != tt.message {
+				t.Errorf("message %q, want %q", body.Message, tt.message)
+			}
+		})
+	}
+}
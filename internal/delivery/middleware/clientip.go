@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const ClientIPKey = "clientIP"
+
+type ClientIPResolver struct {
+	proxyHeader    string
+	trustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver creates the middleware finding the client address. The
+// address in proxyHeader is only believed when the request comes from one
+// of trustedProxies, given as IPs or CIDRs.
+func NewClientIPResolver(proxyHeader string, trustedProxies []string) *ClientIPResolver {
+	m := &ClientIPResolver{proxyHeader: proxyHeader}
+
+	for _, proxy := range trustedProxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			bits := 8 * len(ip)
+			m.trustedProxies = append(m.trustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			m.trustedProxies = append(m.trustedProxies, network)
+		}
+	}
+
+	return m
+}
+
+// Resolve stores the client address for ClientIP. It must run before any
+// middleware that logs, traces, audits or rate limits by it.
+func (m *ClientIPResolver) Resolve(ctx *fiber.Ctx) error {
+	ctx.Locals(ClientIPKey, m.clientIP(ctx))
+	return ctx.Next()
+}
+
+// clientIP returns the address of the client in front of the trusted
+// proxies: the last address of the proxy header that is not a trusted
+// proxy, since every proxy appends the address it got the request from and
+// the addresses before can be set by the client itself.
+func (m *ClientIPResolver) clientIP(ctx *fiber.Ctx) string {
+	remote := ctx.Context().RemoteIP()
+	if m.proxyHeader == "" || !m.trusted(remote) {
+		return remote.String()
+	}
+
+	addrs := strings.Split(ctx.Get(m.proxyHeader), ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			break
+		}
+		if !m.trusted(ip) {
+			return ip.String()
+		}
+	}
+	return remote.String()
+}
+
+func (m *ClientIPResolver) trusted(ip net.IP) bool {
+	for _, network := range m.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address found by ClientIPResolver.Resolve, or
+// the connection address if it did not run. Never use ctx.IP(), which takes
+// the first address of the proxy header, set by the client.
+func ClientIP(ctx *fiber.Ctx) string {
+	if ip, ok := ctx.Locals(ClientIPKey).(string); ok {
+		return ip
+	}
+	return ctx.Context().RemoteIP().String()
+}
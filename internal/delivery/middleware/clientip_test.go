@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Requests made with fiber's App.Test come from 0.0.0.0.
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		trusted []string
+		xff     string
+		want    string
+	}{
+		{"no proxy header", "", nil, "203.0.113.7", "0.0.0.0"},
+		{"untrusted peer", "X-Forwarded-For", []string{"10.0.0.0/8"}, "203.0.113.7", "0.0.0.0"},
+		{"trusted peer", "X-Forwarded-For", []string{"0.0.0.0"}, "203.0.113.7", "203.0.113.7"},
+		{"spoofed entries before the client", "X-Forwarded-For", []string{"0.0.0.0"}, "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"chain of trusted proxies", "X-Forwarded-For", []string{"0.0.0.0", "10.0.0.0/8"}, "198.51.100.1, 203.0.113.7, 10.1.2.3", "203.0.113.7"},
+		{"only trusted proxies", "X-Forwarded-For", []string{"0.0.0.0", "10.0.0.0/8"}, "10.0.0.1, 10.0.0.2", "0.0.0.0"},
+		{"garbage before the client", "X-Forwarded-For", []string{"0.0.0.0"}, "junk, 203.0.113.7", "203.0.113.7"},
+		{"garbage after the client", "X-Forwarded-For", []string{"0.0.0.0"}, "203.0.113.7, junk", "0.0.0.0"},
+		{"empty header", "X-Forwarded-For", []string{"0.0.0.0"}, "", "0.0.0.0"},
+		{"ipv6", "X-Forwarded-For", []string{"0.0.0.0"}, "2001:db8::1", "2001:db8::1"},
+		{"other header", "X-Real-IP", []string{"0.0.0.0/0"}, "203.0.113.7", "0.0.0.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(NewClientIPResolver(tt.header, tt.trusted).Resolve)
+			app.Get("/", func(ctx *fiber.Ctx) error { return ctx.SendString(ClientIP(ctx)) })
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if got := string(body); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutResolver(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(ctx *fiber.Ctx) error { return ctx.SendString(ClientIP(ctx)) })
+
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "0.0.0.0" {
+		t.Errorf("ClientIP = %q, want the connection address", body)
+	}
+}
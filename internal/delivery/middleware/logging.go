@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits incoming ids to something safe to log and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+type RequestLogger struct {
+	logger *logrus.Logger
+}
+
+func NewRequestLogger(logger *logrus.Logger) *RequestLogger {
+	return &RequestLogger{logger: logger}
+}
+
+// RequestID honors a well-formed incoming X-Request-ID or generates one,
+// echoes it in the response and stores it in the request context together
+// with a logger carrying request_id and trace_id fields.
+func (m *RequestLogger) RequestID(ctx *fiber.Ctx) error {
+	id := ctx.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = utils.UUIDv4()
+	}
+	ctx.Set(requestIDHeader, id)
+
+	userCtx := log.WithRequestID(ctx.UserContext(), id)
+	fields := logrus.Fields{"request_id": id}
+	if span := trace.SpanFromContext(userCtx); span.SpanContext().IsValid() {
+		fields["trace_id"] = span.SpanContext().TraceID().String()
+		span.SetAttributes(attribute.String("http.request_id", id))
+	}
+
+	ctx.SetUserContext(log.NewContext(userCtx, m.logger.WithFields(fields)))
+	return ctx.Next()
+}
+
+// AccessLog writes one structured line per request. Request headers are
+// included with credentials redacted; the request body is only logged at
+// debug level, with credentials in JSON bodies redacted, and never for
+// /auth routes, whose bodies are credentials.
+func (m *RequestLogger) AccessLog(ctx *fiber.Ctx) error {
+	start := time.Now()
+	err := ctx.Next()
+
+	status, route := responseStatus(ctx, err)
+	fields := logrus.Fields{
+		"method":     ctx.Method(),
+		"route":      route,
+		"path":       ctx.Path(),
+		"status":     status,
+		"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+		"bytes":      responseSize(ctx),
+		"ip":         ClientIP(ctx),
+		"headers":    ctx.GetReqHeaders(),
+	}
+	if m.logger.IsLevelEnabled(logrus.DebugLevel) && len(ctx.Body()) > 0 && !strings.HasPrefix(ctx.Path(), "/auth/") {
+		fields["body"] = log.RedactJSON(ctx.Body())
+	}
+
+	entry := log.FromContext(ctx.UserContext(), m.logger).WithFields(fields)
+	if status >= fiber.StatusInternalServerError {
+		entry.Error("Request completed")
+	} else {
+		entry.Info("Request completed")
+	}
+
+	return err
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newLoggedApp serves every route behind RequestID and AccessLog, logging
+// JSON lines at level to out. Handlers log a line of their own through the
+// request logger and echo the request id they see.
+func newLoggedApp(level string, out *bytes.Buffer) *fiber.App {
+	logger := log.InitLogger(level, "json")
+	logger.SetOutput(out)
+	m := NewRequestLogger(logger)
+
+	app := fiber.New()
+	app.Use(m.RequestID, m.AccessLog)
+	app.All("/*", func(ctx *fiber.Ctx) error {
+		log.FromContext(ctx.UserContext(), logger).Info("Handling")
+		return ctx.SendString(log.RequestIDFromContext(ctx.UserContext()))
+	})
+	return app
+}
+
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var lines []map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("decode %q: %v", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		kept     bool
+	}{
+		{"none", "", false},
+		{"valid", "req-42.a:b_c", true},
+		{"too long", strings.Repeat("a", 65), false},
+		{"unsafe characters", "id\" level=error", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := newLoggedApp("info", &out)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/songs/", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+
+			id := resp.Header.Get("X-Request-ID")
+			if tt.kept && id != tt.incoming || !tt.kept && (id == tt.incoming || !validRequestID.MatchString(id)) {
+				t.Fatalf("X-Request-ID = %q for incoming %q", id, tt.incoming)
+			}
+			var body bytes.Buffer
+			body.ReadFrom(resp.Body)
+			if body.String() != id {
+				t.Errorf("request context has id %q, want %q", body.String(), id)
+			}
+
+			lines := logLines(t, &out)
+			if len(lines) != 2 {
+				t.Fatalf("got %d log lines, want the handler's and the access log", len(lines))
+			}
+			for _, line := range lines {
+				if line["request_id"] != id {
+					t.Errorf("line %q has request_id %v, want %q", line["msg"], line["request_id"], id)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name  string
+		level string
+		path  string
+		body  string
+		// logged is the body as logged, empty if it is not.
+		logged string
+	}{
+		{"info", "info", "/songs/add_song", `{"group":"Muse"}`, ""},
+		{"debug", "debug", "/songs/add_song", `{"group":"Muse","token":"abc"}`, `{"group":"Muse","token":"[REDACTED]"}`},
+		{"debug auth", "debug", "/auth/login", `{"username":"alice","secret":"hunter2"}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			app := newLoggedApp(tt.level, &out)
+
+			req := httptest.NewRequest(fiber.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-API-Key", "sk_live_1")
+			if _, err := app.Test(req); err != nil {
+				t.Fatalf("request: %v", err)
+			}
+
+			lines := logLines(t, &out)
+			line := lines[len(lines)-1]
+			if line["msg"] != "Request completed" || line["method"] != fiber.MethodPost || line["path"] != tt.path ||
+				line["route"] != "/*" || line["status"] != float64(fiber.StatusOK) {
+				t.Errorf("access log = %v", line)
+			}
+
+			headers, _ := line["headers"].(map[string]interface{})
+			if key, _ := headers["X-Api-Key"].([]interface{}); len(key) != 1 || key[0] != "[REDACTED]" {
+				t.Errorf("api key logged as %v", headers["X-Api-Key"])
+			}
+
+			body, logged := line["body"]
+			if tt.logged == "" && logged {
+				t.Errorf("body logged as %v", body)
+			}
+			if tt.logged != "" && body != tt.logged {
+				t.Errorf("body = %v, want %s", body, tt.logged)
+			}
+		})
+	}
+}
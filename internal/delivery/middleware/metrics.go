@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const unmatchedRoute = "unmatched"
+
+// Metrics records request latency and response size labelled by the route
+// pattern (e.g. /songs/get_song/:id) rather than the raw path, keeping label
+// cardinality bounded.
+func Metrics(ctx *fiber.Ctx) error {
+	start := time.Now()
+	err := ctx.Next()
+
+	status, route := responseStatus(ctx, err)
+	// Method points into the request buffer fiber reuses for the next
+	// request; the label must outlive it.
+	metrics.ObserveHTTPRequest(utils.CopyString(ctx.Method()), route, strconv.Itoa(status), time.Since(start), responseSize(ctx))
+	return err
+}
+
+// responseStatus returns the status code and route pattern of a finished
+// request. Errors are turned into responses by the error handler only after
+// the middleware chain returns, so the status is derived from the error here.
+func responseStatus(ctx *fiber.Ctx, err error) (int, string) {
+	status := ctx.Response().StatusCode()
+	route := ctx.Route().Path
+
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			status = fiberErr.Code
+			if status == fiber.StatusNotFound {
+				route = unmatchedRoute
+			}
+		}
+	}
+
+	return status, route
+}
+
+// responseSize returns the response body size without draining streamed
+// bodies such as the NDJSON audit export; those count as their declared
+// Content-Length, or 0 when chunked.
+func responseSize(ctx *fiber.Ctx) int {
+	resp := ctx.Response()
+	if resp.IsBodyStream() {
+		if size := resp.Header.ContentLength(); size > 0 {
+			return size
+		}
+		return 0
+	}
+	return len(resp.Body())
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/metrics"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestCount returns how many requests the request histogram recorded for
+// the labels.
+func requestCount(t *testing.T, method, route, status string) uint64 {
+	t.Helper()
+
+	registry := metrics.NewRegistry(nil, nil, func(ctx context.Context) ([]models.SongStats, error) { return nil, nil })
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "songlib_http_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["method"] == method && labels["route"] == route && labels["status"] == status {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	app := fiber.New()
+	app.Use(Metrics)
+	app.Get("/metrics-test/songs/:id", func(ctx *fiber.Ctx) error {
+		return ctx.SendString("song " + ctx.Params("id"))
+	})
+	app.Post("/metrics-test/songs/:id", func(ctx *fiber.Ctx) error {
+		return ctx.Status(fiber.StatusCreated).SendString("created")
+	})
+	app.Get("/metrics-test/invalid/:id", func(ctx *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid")
+	})
+	app.Get("/metrics-test/failing/:id", func(ctx *fiber.Ctx) error {
+		return errors.New("database is down")
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		route  string
+		status string
+	}{
+		{fiber.MethodGet, "/metrics-test/songs/1", "/metrics-test/songs/:id", "200"},
+		{fiber.MethodGet, "/metrics-test/songs/2", "/metrics-test/songs/:id", "200"},
+		{fiber.MethodPost, "/metrics-test/songs/3", "/metrics-test/songs/:id", "201"},
+		{fiber.MethodGet, "/metrics-test/invalid/1", "/metrics-test/invalid/:id", "400"},
+		{fiber.MethodGet, "/metrics-test/failing/1", "/metrics-test/failing/:id", "500"},
+		{fiber.MethodGet, "/metrics-test/missing/1", unmatchedRoute, "404"},
+		{fiber.MethodGet, "/metrics-test/missing/2", unmatchedRoute, "404"},
+	}
+
+	before := map[string]uint64{}
+	for _, tt := range tests {
+		key := tt.method + " " + tt.route + " " + tt.status
+		before[key] = requestCount(t, tt.method, tt.route, tt.status)
+	}
+
+	want := map[string]uint64{}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest(tt.method, tt.path, nil))
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+		if strconv.Itoa(resp.StatusCode) != tt.status {
+			t.Fatalf("%s %s: status %d, want %s", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+		want[tt.method+" "+tt.route+" "+tt.status]++
+	}
+
+	for _, tt := range tests {
+		key := tt.method + " " + tt.route + " " + tt.status
+		if got := requestCount(t, tt.method, tt.route, tt.status) - before[key]; got != want[key] {
+			t.Errorf("%s recorded %d requests, want %d", key, got, want[key])
+		}
+	}
+	if got := requestCount(t, fiber.MethodGet, "/metrics-test/songs/1", "200"); got != 0 {
+		t.Errorf("the raw path was recorded as a route %d times", got)
+	}
+}
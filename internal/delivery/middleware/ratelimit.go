@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/pkg/ratelimit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// Bucket classes. Each class has its own limit, so a client exhausting its
+// write budget can still read.
+const (
+	BucketRead     = "read"
+	BucketWrite    = "write"
+	BucketProvider = "provider"
+)
+
+type RateLimiter struct {
+	store  ratelimit.Store
+	limits map[string]ratelimit.Limit
+	logger *logrus.Logger
+}
+
+// NewRateLimiter creates the middleware. Anonymous clients are keyed by the
+// address ClientIPResolver found, so it must run first.
+func NewRateLimiter(store ratelimit.Store, limits map[string]ratelimit.Limit, logger *logrus.Logger) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		limits: limits,
+		logger: logger,
+	}
+}
+
+// Limit takes a token from the class bucket of the caller, keyed by api key,
+// token subject or client IP. It must run after Authenticate. Store errors
+// fail open so that a rate limiter outage does not take the API down.
+func (m *RateLimiter) Limit(class string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		limit, ok := m.limits[class]
+		if !ok || !limit.Enabled() {
+			return ctx.Next()
+		}
+
+		res, err := m.store.Take(ctx.UserContext(), class+":"+m.clientKey(ctx), limit)
+		if err != nil {
+			log.FromContext(ctx.UserContext(), m.logger).WithFields(logrus.Fields{
+				"bucket": class,
+				"error":  err,
+			}).Error("Rate limiter unavailable, allowing request")
+			return ctx.Next()
+		}
+
+		ctx.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		ctx.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		ctx.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+		if !res.Allowed {
+			ctx.Set(fiber.HeaderRetryAfter, ceilSeconds(res.RetryAfter))
+			log.FromContext(ctx.UserContext(), m.logger).WithFields(logrus.Fields{
+				"bucket": class,
+				"client": m.clientKey(ctx),
+			}).Warn("Rate limit exceeded")
+			return ctx.Status(fiber.StatusTooManyRequests).JSON(handler.ErrorResponse{
+				Error:   "Too many requests",
+				Message: "Rate limit exceeded for " + class + " requests",
+			})
+		}
+
+		return ctx.Next()
+	}
+}
+
+func (m *RateLimiter) clientKey(ctx *fiber.Ctx) string {
+	principal := Principal(ctx)
+	switch {
+	case principal == nil:
+		return "ip:" + ClientIP(ctx)
+	case principal.KeyID != 0:
+		return "key:" + strconv.Itoa(principal.KeyID)
+	default:
+		return principal.Actor()
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
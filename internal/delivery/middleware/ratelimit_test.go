@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/pkg/ratelimit"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// newRateLimitedApp serves GET / behind the read limit, with the client IP
+// taken from X-Forwarded-For set by trusted proxies.
+func newRateLimitedApp(t *testing.T, store ratelimit.Store, limit ratelimit.Limit, trustedProxies ...string) *fiber.App {
+	t.Helper()
+
+	limiter := NewRateLimiter(store, map[string]ratelimit.Limit{BucketRead: limit}, testLogger())
+	app := fiber.New()
+	app.Use(NewClientIPResolver("X-Forwarded-For", trustedProxies).Resolve)
+	app.Get("/", limiter.Limit(BucketRead), func(ctx *fiber.Ctx) error { return ctx.SendString("ok") })
+	return app
+}
+
+func get(t *testing.T, app *fiber.App, forwardedFor string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	return resp
+}
+
+func TestRateLimiterLimit(t *testing.T) {
+	store := ratelimit.NewMemoryStore(time.Hour)
+	defer store.Close()
+	app := newRateLimitedApp(t, store, ratelimit.Limit{Rate: 0.5, Burst: 2})
+
+	for i, remaining := range []string{"1", "0"} {
+		resp := get(t, app, "")
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, resp.StatusCode)
+		}
+		if got := resp.Header.Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: RateLimit-Limit = %q, want 2", i, got)
+		}
+		if got := resp.Header.Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %s", i, got, remaining)
+		}
+		if resp.Header.Get("Retry-After") != "" {
+			t.Errorf("request %d: Retry-After set on an allowed request", i)
+		}
+	}
+
+	resp := get(t, app, "")
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if got := resp.Header.Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+	if got := resp.Header.Get("RateLimit-Reset"); got != "4" {
+		t.Errorf("RateLimit-Reset = %q, want 4", got)
+	}
+	var body handler.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error != "Too many requests" {
+		t.Errorf("body = %+v, %v", body, err)
+	}
+}
+
+func TestRateLimiterSpoofedForwardedFor(t *testing.T) {
+	store := ratelimit.NewMemoryStore(time.Hour)
+	defer store.Close()
+	app := newRateLimitedApp(t, store, ratelimit.Limit{Rate: 0.5, Burst: 1}, "0.0.0.0", "10.0.0.0/8")
+
+	if resp := get(t, app, "198.51.100.1, 203.0.113.7, 10.0.0.2"); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("first request: status %d, want 200", resp.StatusCode)
+	}
+	// Made-up addresses in front of the client do not buy a new bucket.
+	for _, spoofed := range []string{"198.51.100.2, 203.0.113.7, 10.0.0.2", "203.0.113.7, 10.0.0.3", "1.2.3.4, 203.0.113.7"} {
+		if resp := get(t, app, spoofed); resp.StatusCode != fiber.StatusTooManyRequests {
+			t.Errorf("X-Forwarded-For %q: status %d, want 429", spoofed, resp.StatusCode)
+		}
+	}
+	// Another client behind the same proxies has its own bucket.
+	if resp := get(t, app, "203.0.113.8, 10.0.0.2"); resp.StatusCode != fiber.StatusOK {
+		t.Errorf("other client: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRateLimiterUntrustedForwardedFor(t *testing.T) {
+	store := ratelimit.NewMemoryStore(time.Hour)
+	defer store.Close()
+	app := newRateLimitedApp(t, store, ratelimit.Limit{Rate: 0.5, Burst: 1}, "10.0.0.0/8")
+
+	if resp := get(t, app, "203.0.113.7"); resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("first request: status %d, want 200", resp.StatusCode)
+	}
+	// The header of a peer that is not a trusted proxy is ignored.
+	if resp := get(t, app, "203.0.113.8"); resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("status %d, want 429", resp.StatusCode)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store down")
+}
+
+func TestRateLimiterFailsOpen(t *testing.T) {
+	app := newRateLimitedApp(t, failingStore{}, ratelimit.Limit{Rate: 1, Burst: 1})
+
+	for i := 0; i < 3; i++ {
+		if resp := get(t, app, ""); resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, resp.StatusCode)
+		}
+	}
+}
+
+func TestRateLimiterDisabledLimit(t *testing.T) {
+	store := ratelimit.NewMemoryStore(time.Hour)
+	defer store.Close()
+	app := newRateLimitedApp(t, store, ratelimit.Limit{})
+
+	resp := get(t, app, "")
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get("RateLimit-Limit") != "" {
+		t.Errorf("status %d, RateLimit-Limit %q, want 200 without headers", resp.StatusCode, resp.Header.Get("RateLimit-Limit"))
+	}
+}
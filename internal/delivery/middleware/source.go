@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestSource stores the request id and client address in the request
+// context so that audit events can be traced back to the request. It must
+// run after RequestLogger.RequestID.
+func RequestSource(ctx *fiber.Ctx) error {
+	ctx.SetUserContext(audit.WithSource(ctx.UserContext(), audit.Source{
+		RequestID: log.RequestIDFromContext(ctx.UserContext()),
+		IP:        ClientIP(ctx),
+	}))
+	return ctx.Next()
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/VadimBorzenkov/online-song-library/internal/tenant"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	LibraryKey = "library"
+
+	libraryHeader = "X-Library"
+)
+
+type TenantMiddleware struct {
+	serv           service.LibraryService
+	baseDomain     string
+	defaultLibrary string
+	logger         *logrus.Logger
+}
+
+func NewTenantMiddleware(serv service.LibraryService, baseDomain, defaultLibrary string, logger *logrus.Logger) *TenantMiddleware {
+	return &TenantMiddleware{
+		serv:           serv,
+		baseDomain:     strings.ToLower(strings.TrimPrefix(baseDomain, ".")),
+		defaultLibrary: defaultLibrary,
+		logger:         logger,
+	}
+}
+
+// Resolve picks the library for the request. A library bound to the
+// credentials wins. Otherwise the X-Library header, then the subdomain of
+// TENANT_BASE_DOMAIN are honoured for admins only, and everyone else gets
+// the default library. Asking for any other library is rejected, so that
+// callers cannot write to libraries they were never given.
+func (m *TenantMiddleware) Resolve(ctx *fiber.Ctx) error {
+	logger := log.FromContext(ctx.UserContext(), m.logger)
+
+	requested := ctx.Get(libraryHeader)
+	if requested == "" {
+		requested = m.subdomain(ctx.Hostname())
+	}
+
+	principal := Principal(ctx)
+	bound := principal != nil && principal.Library != ""
+
+	slug := m.defaultLibrary
+	if bound {
+		slug = principal.Library
+	}
+
+	if requested != "" && requested != slug {
+		if principal == nil {
+			return ctx.Status(fiber.StatusUnauthorized).JSON(handler.ErrorResponse{
+				Error:   "Unauthorized",
+				Message: "Authentication required for library " + requested,
+			})
+		}
+		if bound || !principal.HasScope(auth.ScopeAdmin) {
+			logger.WithFields(logrus.Fields{
+				"actor":     principal.Actor(),
+				"library":   slug,
+				"requested": requested,
+			}).Warn("Credentials used outside of their library")
+			return ctx.Status(fiber.StatusForbidden).JSON(handler.ErrorResponse{
+				Error:   "Forbidden",
+				Message: "Credentials are not valid for library " + requested,
+			})
+		}
+		slug = requested
+	}
+
+	library, err := m.serv.GetLibrary(ctx.UserContext(), slug)
+	if err != nil {
+		if errors.Is(err, service.ErrLibraryNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(handler.ErrorResponse{
+				Error:   "Library not found",
+				Message: err.Error(),
+			})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(handler.ErrorResponse{
+			Error:   "Failed to resolve library",
+			Message: err.Error(),
+		})
+	}
+
+	ctx.Locals(LibraryKey, library)
+	ctx.SetUserContext(tenant.WithLibrary(ctx.UserContext(), library))
+	return ctx.Next()
+}
+
+func (m *TenantMiddleware) subdomain(host string) string {
+	if m.baseDomain == "" {
+		return ""
+	}
+
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, "."+m.baseDomain) {
+		return ""
+	}
+
+	label := strings.TrimSuffix(host, "."+m.baseDomain)
+	if strings.Contains(label, ".") {
+		return ""
+	}
+
+	return label
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/service"
+	"github.com/VadimBorzenkov/online-song-library/internal/tenant"
+	"github.com/gofiber/fiber/v2"
+)
+
+// stubLibraries knows the default, team-a and team-b libraries.
+type stubLibraries struct {
+	service.LibraryService
+}
+
+func (stubLibraries) GetLibrary(ctx context.Context, slug string) (*models.Library, error) {
+	for i, known := range []string{"default", "team-a", "team-b"} {
+		if slug == known {
+			return &models.Library{ID: i + 1, Slug: slug}, nil
+		}
+	}
+	return nil, service.ErrLibraryNotFound
+}
+
+func TestTenantResolve(t *testing.T) {
+	admin := &auth.Principal{Method: auth.MethodApiKey, KeyID: 1, Scopes: []string{auth.ScopeAdmin}}
+	editor := &auth.Principal{Method: auth.MethodApiKey, KeyID: 2, Scopes: []string{auth.ScopeSongsRead, auth.ScopeSongsWrite}}
+	user := &auth.Principal{Method: auth.MethodUser, Subject: "alice", Scopes: []string{auth.ScopeSongsRead}}
+	boundEditor := &auth.Principal{Method: auth.MethodApiKey, KeyID: 3, Scopes: []string{auth.ScopeSongsWrite}, Library: "team-a"}
+	boundAdmin := &auth.Principal{Method: auth.MethodJWT, Subject: "ops", Scopes: []string{auth.ScopeAdmin}, Library: "team-a"}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		header    string
+		host      string
+		status    int
+		library   string
+	}{
+		{"anonymous", nil, "", "", fiber.StatusOK, "default"},
+		{"anonymous asking for the default library", nil, "default", "", fiber.StatusOK, "default"},
+		{"anonymous switching by header", nil, "team-a", "", fiber.StatusUnauthorized, ""},
+		{"anonymous switching by subdomain", nil, "", "team-a.songs.example.com", fiber.StatusUnauthorized, ""},
+		{"admin switching by header", admin, "team-a", "", fiber.StatusOK, "team-a"},
+		{"admin switching by subdomain", admin, "", "team-b.songs.example.com", fiber.StatusOK, "team-b"},
+		{"header wins over subdomain", admin, "team-a", "team-b.songs.example.com", fiber.StatusOK, "team-a"},
+		{"admin asking for a missing library", admin, "missing", "", fiber.StatusNotFound, ""},
+		{"unbound editor", editor, "", "", fiber.StatusOK, "default"},
+		{"unbound editor switching by header", editor, "team-a", "", fiber.StatusForbidden, ""},
+		{"unbound editor switching by subdomain", editor, "", "team-a.songs.example.com", fiber.StatusForbidden, ""},
+		{"unbound user switching", user, "team-b", "", fiber.StatusForbidden, ""},
+		{"unbound editor asking for a missing library", editor, "missing", "", fiber.StatusForbidden, ""},
+		{"bound editor", boundEditor, "", "", fiber.StatusOK, "team-a"},
+		{"bound editor naming its library", boundEditor, "team-a", "team-a.songs.example.com", fiber.StatusOK, "team-a"},
+		{"bound editor asking for the default library", boundEditor, "default", "", fiber.StatusForbidden, ""},
+		{"bound admin switching", boundAdmin, "", "team-b.songs.example.com", fiber.StatusForbidden, ""},
+		{"other domain", editor, "", "team-a.example.org", fiber.StatusOK, "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(func(ctx *fiber.Ctx) error {
+				if tt.principal != nil {
+					ctx.Locals(PrincipalKey, tt.principal)
+				}
+				return ctx.Next()
+			})
+			app.Use(NewTenantMiddleware(stubLibraries{}, "songs.example.com", "default", testLogger()).Resolve)
+			app.Get("/", func(ctx *fiber.Ctx) error {
+				return ctx.SendString(tenant.LibraryFromContext(ctx.UserContext()).Slug)
+			})
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Library", tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.library != "" {
+				if body, _ := io.ReadAll(resp.Body); string(body) != tt.library {
+					t.Errorf("library %q, want %q", body, tt.library)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Tracing starts a server span for every request, continuing the trace from
+// incoming W3C traceparent headers, and stores it in the user context so
+// service and repository spans become its children. The span is renamed to
+// the route pattern once routing is done.
+func Tracing(ctx *fiber.Ctx) error {
+	header := http.Header{}
+	for key, values := range ctx.GetReqHeaders() {
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+	parent := otel.GetTextMapPropagator().Extract(ctx.UserContext(), propagation.HeaderCarrier(header))
+
+	userCtx, span := tracing.StartServer(parent, ctx.Method(),
+		semconv.HTTPRequestMethodKey.String(ctx.Method()),
+		semconv.URLPath(ctx.Path()),
+		semconv.ClientAddress(ClientIP(ctx)),
+	)
+	defer span.End()
+
+	ctx.SetUserContext(userCtx)
+	err := ctx.Next()
+
+	status, route := responseStatus(ctx, err)
+	span.SetName(ctx.Method() + " " + route)
+	span.SetAttributes(
+		semconv.HTTPRoute(route),
+		semconv.HTTPResponseStatusCode(status),
+	)
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+
+	return err
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	recorder     = tracetest.NewSpanRecorder()
+	recorderOnce sync.Once
+)
+
+// recordSpans installs a tracer provider recording every span. The global
+// provider can be set only once per test binary.
+func recordSpans() {
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+}
+
+func TestTracing(t *testing.T) {
+	recordSpans()
+
+	app := fiber.New()
+	app.Use(Tracing)
+	app.Get("/songs/get_song/:id", func(ctx *fiber.Ctx) error {
+		_, span := tracing.Start(ctx.UserContext(), "service.GetSong")
+		span.End()
+		return ctx.SendString("ok")
+	})
+	app.Get("/failing", func(ctx *fiber.Ctx) error {
+		return errors.New("database is down")
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		traceID string
+		route   string
+		status  int
+		failed  bool
+	}{
+		{"route", "/songs/get_song/7", "4bf92f3577b34da6a3ce929d0e0e4736", "/songs/get_song/:id", fiber.StatusOK, false},
+		{"server error", "/failing", "4bf92f3577b34da6a3ce929d0e0e4737", "/failing", fiber.StatusInternalServerError, true},
+		{"not found", "/missing", "4bf92f3577b34da6a3ce929d0e0e4738", unmatchedRoute, fiber.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodGet, tt.path, nil)
+			req.Header.Set("traceparent", "00-"+tt.traceID+"-00f067aa0ba902b7-01")
+			if _, err := app.Test(req); err != nil {
+				t.Fatalf("request: %v", err)
+			}
+
+			traceID, _ := trace.TraceIDFromHex(tt.traceID)
+			spans := map[string]sdktrace.ReadOnlySpan{}
+			for _, span := range recorder.Ended() {
+				if span.SpanContext().TraceID() == traceID {
+					spans[span.Name()] = span
+				}
+			}
+
+			server, ok := spans["GET "+tt.route]
+			if !ok {
+				t.Fatalf("no server span named after the route in %v", spans)
+			}
+			if server.SpanKind() != trace.SpanKindServer || server.Parent().SpanID().String() != "00f067aa0ba902b7" || !server.Parent().IsRemote() {
+				t.Errorf("server span kind %s, parent %v: the trace was not continued", server.SpanKind(), server.Parent())
+			}
+
+			attrs := map[attribute.Key]attribute.Value{}
+			for _, attr := range server.Attributes() {
+				attrs[attr.Key] = attr.Value
+			}
+			if attrs[semconv.HTTPRouteKey].AsString() != tt.route || attrs[semconv.HTTPResponseStatusCodeKey].AsInt64() != int64(tt.status) || attrs[semconv.URLPathKey].AsString() != tt.path {
+				t.Errorf("server span attributes = %v", server.Attributes())
+			}
+			if failed := server.Status().Code == codes.Error; failed != tt.failed {
+				t.Errorf("server span status = %+v", server.Status())
+			}
+
+			if child, ok := spans["service.GetSong"]; ok != (tt.status == fiber.StatusOK) || ok && child.Parent().SpanID() != server.SpanContext().SpanID() {
+				t.Errorf("service span %v is not a child of the server span", child)
+			}
+		})
+	}
+}
@@ -1,24 +1,140 @@
 package routes
 
 import (
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
 	"github.com/VadimBorzenkov/online-song-library/internal/delivery/handler"
+	"github.com/VadimBorzenkov/online-song-library/internal/delivery/middleware"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/swagger"
 )
 
-func RegistrationRoutes(app *fiber.App, h handler.Handler) {
+// Handlers are the handlers the routes dispatch to.
+type Handlers struct {
+	Songs        handler.Handler
+	Libraries    handler.LibraryHandler
+	Playlists    handler.PlaylistHandler
+	Listening    handler.ListeningHandler
+	Reviews      handler.ReviewHandler
+	Annotations  handler.AnnotationHandler
+	Tags         handler.TagHandler
+	Translations handler.TranslationHandler
+	Auth         handler.AuthHandler
+	Audit        handler.AuditHandler
+	Health       handler.HealthHandler
+	Metrics      fiber.Handler
+}
+
+// Middleware is the middleware the routes are guarded with.
+type Middleware struct {
+	ClientIP      *middleware.ClientIPResolver
+	Auth          *middleware.AuthMiddleware
+	Tenant        *middleware.TenantMiddleware
+	RateLimiter   *middleware.RateLimiter
+	RequestLogger *middleware.RequestLogger
+}
+
+func RegistrationRoutes(app *fiber.App, corsOrigins []string, handlers Handlers, mw Middleware) {
+	h, lh, ph, mh := handlers.Songs, handlers.Libraries, handlers.Playlists, handlers.Listening
+	rh, nh, th, xh := handlers.Reviews, handlers.Annotations, handlers.Tags, handlers.Translations
+	uh, ah, hh := handlers.Auth, handlers.Audit, handlers.Health
+	authMw, tenantMw, rl, reqLog := mw.Auth, mw.Tenant, mw.RateLimiter, mw.RequestLogger
+
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
+		AllowOrigins: strings.Join(corsOrigins, ","),
 		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
 	}))
-	songsRoutes := app.Group("/songs")
+	app.Use(mw.ClientIP.Resolve)
+	app.Use(middleware.Tracing)
+	app.Use(reqLog.RequestID)
+	app.Use(reqLog.AccessLog)
+	app.Use(middleware.Metrics)
+	app.Use(middleware.RequestSource)
+
+	app.Get("/healthz", hh.Healthz)
+	app.Get("/readyz", hh.Readyz)
+	app.Get("/metrics", handlers.Metrics)
+	songsRoutes := app.Group("/songs", authMw.Authenticate, tenantMw.Resolve)
+
+	read := rl.Limit(middleware.BucketRead)
+	write := rl.Limit(middleware.BucketWrite)
+	provider := rl.Limit(middleware.BucketProvider)
+
+	songsRoutes.Get("/", read, authMw.RequireScope(auth.ScopeSongsRead), h.GetSongs)
+	songsRoutes.Get("/facets", read, authMw.RequireScope(auth.ScopeSongsRead), th.GetSongFacets)
+	songsRoutes.Post("/tags", write, authMw.RequireScope(auth.ScopeSongsWrite), th.TagSongs)
+	songsRoutes.Get("/get_song/:id", read, authMw.RequireScope(auth.ScopeSongsRead), h.GetSongWithVerses)
+	songsRoutes.Post("/add_song", write, provider, authMw.RequireScope(auth.ScopeSongsWrite), h.AddNewSong)
+	songsRoutes.Put("/update_song/:id", write, authMw.RequireScope(auth.ScopeSongsWrite), h.UpdateSong)
+	songsRoutes.Put("/:id/explicit", write, authMw.RequireScope(auth.ScopeSongsWrite), h.SetExplicit)
+	songsRoutes.Delete("/delete_song/:id", write, authMw.RequireScope(auth.ScopeSongsDelete), h.DeleteSong)
+	songsRoutes.Post("/:id/merge", write, authMw.RequireScope(auth.ScopeSongsDelete), h.MergeSongs)
+	songsRoutes.Put("/:id/favorite", write, authMw.RequireScope(auth.ScopeSongsInteract), mh.AddFavorite)
+	songsRoutes.Delete("/:id/favorite", write, authMw.RequireScope(auth.ScopeSongsInteract), mh.RemoveFavorite)
+	songsRoutes.Post("/:id/plays", write, authMw.RequireScope(auth.ScopeSongsInteract), mh.RecordPlay)
+	songsRoutes.Get("/:id/review", read, authMw.RequireScope(auth.ScopeSongsRead), rh.GetOwnReview)
+	songsRoutes.Put("/:id/review", write, authMw.RequireScope(auth.ScopeSongsInteract), rh.SaveReview)
+	songsRoutes.Delete("/:id/review", write, authMw.RequireScope(auth.ScopeSongsInteract), rh.DeleteReview)
+	songsRoutes.Get("/:id/reviews", read, authMw.RequireScope(auth.ScopeSongsRead), rh.GetSongReviews)
+	songsRoutes.Get("/:id/annotations", read, authMw.RequireScope(auth.ScopeSongsRead), nh.GetAnnotations)
+	songsRoutes.Post("/:id/annotations", write, authMw.RequireScope(auth.ScopeSongsInteract), nh.AddAnnotation)
+	songsRoutes.Get("/:id/tags", read, authMw.RequireScope(auth.ScopeSongsRead), th.GetSongTags)
+	songsRoutes.Get("/:id/verses", read, authMw.RequireScope(auth.ScopeSongsRead), xh.GetVerses)
+	songsRoutes.Get("/:id/translations", read, authMw.RequireScope(auth.ScopeSongsRead), xh.GetTranslations)
+	songsRoutes.Put("/:id/translations/:lang", write, authMw.RequireScope(auth.ScopeSongsWrite), xh.SaveTranslation)
+	songsRoutes.Delete("/:id/translations/:lang", write, authMw.RequireScope(auth.ScopeSongsWrite), xh.DeleteTranslation)
+
+	reviewsRoutes := app.Group("/reviews", authMw.Authenticate, tenantMw.Resolve, authMw.RequireScope(auth.ScopeAdmin))
+
+	reviewsRoutes.Get("/", read, rh.GetReviews)
+	reviewsRoutes.Put("/:id/status", write, rh.ModerateReview)
+
+	annotationsRoutes := app.Group("/annotations", authMw.Authenticate, tenantMw.Resolve, authMw.RequireScope(auth.ScopeSongsInteract))
+
+	annotationsRoutes.Put("/:id", write, nh.UpdateAnnotation)
+	annotationsRoutes.Delete("/:id", write, nh.DeleteAnnotation)
+	annotationsRoutes.Put("/:id/vote", write, nh.VoteAnnotation)
+
+	tagsRoutes := app.Group("/tags", authMw.Authenticate, tenantMw.Resolve, authMw.RequireScope(auth.ScopeSongsRead))
+
+	tagsRoutes.Get("/", read, th.GetTags)
+
+	authRoutes := app.Group("/auth")
+
+	authRoutes.Post("/login", write, uh.Login)
+	authRoutes.Post("/logout", write, authMw.Authenticate, uh.Logout)
+
+	meRoutes := app.Group("/me", authMw.Authenticate, tenantMw.Resolve, authMw.RequireScope(auth.ScopeSongsRead))
+
+	meRoutes.Get("/", read, mh.GetMe)
+	meRoutes.Get("/favorites", read, mh.GetFavorites)
+	meRoutes.Get("/history", read, mh.GetHistory)
+	meRoutes.Get("/top", read, mh.GetTop)
+
+	playlistsRoutes := app.Group("/playlists", authMw.Authenticate, tenantMw.Resolve)
+
+	playlistsRoutes.Get("/", read, authMw.RequireScope(auth.ScopeSongsRead), ph.GetPlaylists)
+	playlistsRoutes.Post("/", write, authMw.RequireScope(auth.ScopeSongsWrite), ph.CreatePlaylist)
+	playlistsRoutes.Get("/:id", read, authMw.RequireScope(auth.ScopeSongsRead), ph.GetPlaylist)
+	playlistsRoutes.Put("/:id", write, authMw.RequireScope(auth.ScopeSongsWrite), ph.UpdatePlaylist)
+	playlistsRoutes.Delete("/:id", write, authMw.RequireScope(auth.ScopeSongsWrite), ph.DeletePlaylist)
+	playlistsRoutes.Post("/:id/duplicate", write, authMw.RequireScope(auth.ScopeSongsWrite), ph.DuplicatePlaylist)
+	playlistsRoutes.Get("/:id/export", read, authMw.RequireScope(auth.ScopeSongsRead), ph.ExportPlaylist)
+	playlistsRoutes.Post("/:id/entries", write, authMw.RequireScope(auth.ScopeSongsWrite), ph.AddPlaylistEntry)
+	playlistsRoutes.Delete("/:id/entries/:entry_id", write, authMw.RequireScope(auth.ScopeSongsWrite), ph.RemovePlaylistEntry)
+	playlistsRoutes.Put("/:id/entries/:entry_id/position", write, authMw.RequireScope(auth.ScopeSongsWrite), ph.MovePlaylistEntry)
+
+	librariesRoutes := app.Group("/libraries", authMw.Authenticate, authMw.RequireScope(auth.ScopeAdmin))
+
+	librariesRoutes.Get("/", read, lh.GetLibraries)
+	librariesRoutes.Post("/", write, lh.CreateLibrary)
+	librariesRoutes.Post("/:slug/copy", write, lh.CopySongs)
+
+	auditRoutes := app.Group("/audit", authMw.Authenticate, authMw.RequireScope(auth.ScopeAdmin))
 
-	songsRoutes.Get("/", h.GetSongs)
-	songsRoutes.Get("/get_song/:id", h.GetSongWithVerses)
-	songsRoutes.Post("/add_song", h.AddNewSong)
-	songsRoutes.Put("/update_song/:id", h.UpdateSong)
-	songsRoutes.Delete("/delete_song/:id", h.DeleteSong)
+	auditRoutes.Get("/", read, ah.GetAuditEvents)
 
 	//Including swagger
 	app.Get("/swagger/*", swagger.New(swagger.Config{
@@ -1,21 +1,12 @@
 package log
 
 import (
-	"os"
-
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 )
 
-func InitLogger() *logrus.Logger {
+func InitLogger(logLevel, logFormat string) *logrus.Logger {
 	logger := logrus.New()
 
-	err := godotenv.Load(".env")
-	if err != nil {
-		logger.Warn("Error loading .env file, using default configuration")
-	}
-
-	logLevel := os.Getenv("LOG_LEVEL")
 	switch logLevel {
 	case "debug":
 		logger.SetLevel(logrus.DebugLevel)
@@ -29,14 +20,15 @@ func InitLogger() *logrus.Logger {
 		logger.SetLevel(logrus.InfoLevel)
 	}
 
-	logFormat := os.Getenv("LOG_FORMAT")
+	var formatter logrus.Formatter
 	if logFormat == "json" {
-		logger.SetFormatter(&logrus.JSONFormatter{})
+		formatter = &logrus.JSONFormatter{}
 	} else {
-		logger.SetFormatter(&logrus.TextFormatter{
+		formatter = &logrus.TextFormatter{
 			FullTimestamp: true,
-		})
+		}
 	}
+	logger.SetFormatter(&redactingFormatter{next: formatter, logger: logger})
 
 	return logger
 }
@@ -0,0 +1,132 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+// credentialKeys are field, header and JSON key names whose values are
+// always hidden. Lookups are case-insensitive.
+var credentialKeys = map[string]bool{
+	"authorization": true,
+	"x-api-key":     true,
+	"api_key":       true,
+	"token":         true,
+	"password":      true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+// contentKeys are field names whose values are hidden unless the logger
+// runs at debug level, to keep local troubleshooting possible.
+var contentKeys = map[string]bool{
+	"text":   true,
+	"lyrics": true,
+	"verses": true,
+	"body":   true,
+}
+
+func isCredential(key string) bool {
+	return credentialKeys[strings.ToLower(key)]
+}
+
+func isSensitive(key string) bool {
+	return isCredential(key) || contentKeys[strings.ToLower(key)]
+}
+
+// redactingFormatter hides credentials, and lyrics unless at debug level,
+// before the entry is formatted.
+type redactingFormatter struct {
+	next   logrus.Formatter
+	logger *logrus.Logger
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	hide := isSensitive
+	if f.logger.IsLevelEnabled(logrus.DebugLevel) {
+		hide = isCredential
+	}
+
+	// Entries share their Data map with the Entry they were derived from,
+	// so redact a copy.
+	clean := *entry
+	clean.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		if hide(key) {
+			clean.Data[key] = redacted
+			continue
+		}
+		clean.Data[key] = redactValue(value, hide)
+	}
+
+	return f.next.Format(&clean)
+}
+
+// RedactJSON returns a JSON document, such as a request body, with the
+// values of credential keys hidden at any depth. Anything else is returned
+// as is.
+func RedactJSON(data []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		return string(data)
+	}
+
+	clean, err := json.Marshal(redactValue(doc, isCredential))
+	if err != nil {
+		return string(data)
+	}
+	return string(clean)
+}
+
+// redactValue redacts the keys hide matches in nested maps such as request
+// headers or decoded JSON.
+func redactValue(value interface{}, hide func(key string) bool) interface{} {
+	switch v := value.(type) {
+	case http.Header:
+		return redactValue(map[string][]string(v), hide)
+	case map[string][]string:
+		out := make(map[string][]string, len(v))
+		for key, values := range v {
+			if hide(key) {
+				values = []string{redacted}
+			}
+			out[key] = values
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for key, s := range v {
+			if hide(key) {
+				s = redacted
+			}
+			out[key] = s
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			if hide(key) {
+				out[key] = redacted
+				continue
+			}
+			out[key] = redactValue(nested, hide)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, nested := range v {
+			out[i] = redactValue(nested, hide)
+		}
+		return out
+	default:
+		return value
+	}
+}
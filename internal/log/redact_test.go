@@ -0,0 +1,105 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logLine logs one JSON line with fields through a logger at level and
+// returns it decoded.
+func logLine(t *testing.T, level string, fields logrus.Fields) map[string]interface{} {
+	t.Helper()
+
+	var out bytes.Buffer
+	logger := InitLogger(level, "json")
+	logger.SetOutput(&out)
+	logger.WithFields(fields).Info("request")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("decode %q: %v", out.String(), err)
+	}
+	return line
+}
+
+func TestRedactingFormatter(t *testing.T) {
+	headers := http.Header{
+		"Authorization": {"Bearer s3cret"},
+		"X-Api-Key":     {"sk_live_1"},
+		"Accept":        {"application/json"},
+	}
+	fields := logrus.Fields{
+		"token":    "s3cret",
+		"Password": "hunter2",
+		"text":     "Ooh baby, don't you know I suffer?",
+		"song":     "Supermassive Black Hole",
+		"headers":  headers,
+		"params":   map[string]string{"api_key": "sk_live_2", "group": "Muse"},
+	}
+
+	tests := []struct {
+		level string
+		text  interface{}
+	}{
+		{"info", redacted},
+		{"debug", "Ooh baby, don't you know I suffer?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			line := logLine(t, tt.level, fields)
+
+			if line["token"] != redacted || line["Password"] != redacted {
+				t.Errorf("credentials logged: token %v, Password %v", line["token"], line["Password"])
+			}
+			if line["text"] != tt.text {
+				t.Errorf("text = %v, want %v", line["text"], tt.text)
+			}
+			if line["song"] != "Supermassive Black Hole" {
+				t.Errorf("song = %v", line["song"])
+			}
+
+			want := map[string]interface{}{
+				"Authorization": []interface{}{redacted},
+				"X-Api-Key":     []interface{}{redacted},
+				"Accept":        []interface{}{"application/json"},
+			}
+			if !reflect.DeepEqual(line["headers"], want) {
+				t.Errorf("headers = %v, want %v", line["headers"], want)
+			}
+			if want := map[string]interface{}{"api_key": redacted, "group": "Muse"}; !reflect.DeepEqual(line["params"], want) {
+				t.Errorf("params = %v, want %v", line["params"], want)
+			}
+		})
+	}
+
+	if fields["token"] != "s3cret" || headers.Get("Authorization") != "Bearer s3cret" {
+		t.Error("redaction changed the fields of the entry")
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"flat", `{"username":"alice","password":"hunter2"}`, `{"password":"[REDACTED]","username":"alice"}`},
+		{"nested", `{"user":{"Token":"abc","name":"alice"},"keys":[{"api_key":"k1"},{"id":2}]}`, `{"keys":[{"api_key":"[REDACTED]"},{"id":2}],"user":{"Token":"[REDACTED]","name":"alice"}}`},
+		{"lyrics are kept", `{"text":"Ooh baby","id":12345678901234567890}`, `{"id":12345678901234567890,"text":"Ooh baby"}`},
+		{"not json", `group=Muse&password=hunter2`, `group=Muse&password=hunter2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactJSON([]byte(tt.in)); got != tt.want {
+				t.Errorf("RedactJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
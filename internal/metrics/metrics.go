@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "songlib"
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	httpResponseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_response_size_bytes",
+		Help:      "HTTP response body size by route pattern and method.",
+		Buckets:   prometheus.ExponentialBuckets(128, 4, 8),
+	}, []string{"method", "route"})
+
+	repositoryQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_query_duration_seconds",
+		Help:      "Latency of repository queries by query type.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"query"})
+
+	externalApiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "external_api_requests_total",
+		Help:      "Calls to the external song info API by error class (none on success).",
+	}, []string{"error_class"})
+
+	externalApiDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "external_api_request_duration_seconds",
+		Help:      "Latency of calls to the external song info API.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// NewRegistry returns a registry with the service metrics, Go runtime and
+// process metrics, and database/sql pool statistics of db and the read
+// replica, each if not nil.
+func NewRegistry(db, replica *sql.DB, stats func(ctx context.Context) ([]models.SongStats, error)) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestDuration,
+		httpResponseSize,
+		repositoryQueryDuration,
+		externalApiRequests,
+		externalApiDuration,
+		newSongsCollector(stats),
+	)
+	if db != nil {
+		registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+	}
+	if replica != nil {
+		registry.MustRegister(collectors.NewDBStatsCollector(replica, "postgres_replica"))
+	}
+	return registry
+}
+
+func ObserveHTTPRequest(method, route, status string, duration time.Duration, size int) {
+	httpRequestDuration.WithLabelValues(method, route, status).Observe(duration.Seconds())
+	httpResponseSize.WithLabelValues(method, route).Observe(float64(size))
+}
+
+// TimeQuery starts timing a repository query; call the returned func when
+// the query has finished.
+func TimeQuery(query string) func() {
+	start := time.Now()
+	return func() {
+		repositoryQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+	}
+}
+
+func ObserveExternalApiCall(duration time.Duration, errorClass string) {
+	externalApiRequests.WithLabelValues(errorClass).Inc()
+	externalApiDuration.Observe(duration.Seconds())
+}
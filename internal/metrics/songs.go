@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// songsScrapeTimeout bounds the statistics query so a slow database cannot
+// stall a scrape.
+const songsScrapeTimeout = 5 * time.Second
+
+var (
+	songsTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "songs", "total"),
+		"Number of songs per library.",
+		[]string{"library"}, nil,
+	)
+	songsMissingTextDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "songs", "missing_text"),
+		"Number of songs without lyrics per library.",
+		[]string{"library"}, nil,
+	)
+	songsMissingLinkDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "songs", "missing_link"),
+		"Number of songs without a link per library.",
+		[]string{"library"}, nil,
+	)
+	songsScrapeErrorDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "songs", "scrape_error"),
+		"1 if the songs statistics could not be collected on this scrape.",
+		nil, nil,
+	)
+)
+
+// songsCollector queries the business gauges on every scrape instead of
+// keeping counters in memory, so the values are correct across replicas.
+type songsCollector struct {
+	stats func(ctx context.Context) ([]models.SongStats, error)
+}
+
+func newSongsCollector(stats func(ctx context.Context) ([]models.SongStats, error)) *songsCollector {
+	return &songsCollector{stats: stats}
+}
+
+func (c *songsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- songsTotalDesc
+	ch <- songsMissingTextDesc
+	ch <- songsMissingLinkDesc
+	ch <- songsScrapeErrorDesc
+}
+
+func (c *songsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), songsScrapeTimeout)
+	defer cancel()
+
+	stats, err := c.stats(ctx)
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(songsScrapeErrorDesc, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(songsScrapeErrorDesc, prometheus.GaugeValue, 0)
+
+	for _, s := range stats {
+		ch <- prometheus.MustNewConstMetric(songsTotalDesc, prometheus.GaugeValue, float64(s.Total), s.Library)
+		ch <- prometheus.MustNewConstMetric(songsMissingTextDesc, prometheus.GaugeValue, float64(s.MissingText), s.Library)
+		ch <- prometheus.MustNewConstMetric(songsMissingLinkDesc, prometheus.GaugeValue, float64(s.MissingLink), s.Library)
+	}
+}
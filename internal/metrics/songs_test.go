@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSongsCollector(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats []models.SongStats
+		err   error
+		want  string
+	}{
+		{
+			name: "libraries",
+			stats: []models.SongStats{
+				{Library: "default", Total: 3, MissingText: 1, MissingLink: 2},
+				{Library: "team-a", Total: 1},
+			},
+			want: `
+# HELP songlib_songs_missing_link Number of songs without a link per library.
+# TYPE songlib_songs_missing_link gauge
+songlib_songs_missing_link{library="default"} 2
+songlib_songs_missing_link{library="team-a"} 0
+# HELP songlib_songs_missing_text Number of songs without lyrics per library.
+# TYPE songlib_songs_missing_text gauge
+songlib_songs_missing_text{library="default"} 1
+songlib_songs_missing_text{library="team-a"} 0
+# HELP songlib_songs_scrape_error 1 if the songs statistics could not be collected on this scrape.
+# TYPE songlib_songs_scrape_error gauge
+songlib_songs_scrape_error 0
+# HELP songlib_songs_total Number of songs per library.
+# TYPE songlib_songs_total gauge
+songlib_songs_total{library="default"} 3
+songlib_songs_total{library="team-a"} 1
+`,
+		},
+		{
+			name: "scrape error",
+			err:  errors.New("database is down"),
+			want: `
+# HELP songlib_songs_scrape_error 1 if the songs statistics could not be collected on this scrape.
+# TYPE songlib_songs_scrape_error gauge
+songlib_songs_scrape_error 1
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := newSongsCollector(func(ctx context.Context) ([]models.SongStats, error) {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("stats queried without a deadline")
+				}
+				return tt.stats, tt.err
+			})
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
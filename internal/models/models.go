@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Song struct {
 	ID          int    `json:"id" db:"id"`
 	Group       string `json:"group" db:"group_name"`
@@ -7,4 +12,332 @@ type Song struct {
 	ReleaseDate string `json:"release_date" db:"release_date"`
 	Text        string `json:"text" db:"text"`
 	Link        string `json:"link" db:"link"`
+	CreatedBy   string `json:"created_by,omitempty" db:"created_by"`
+	UpdatedBy   string `json:"updated_by,omitempty" db:"updated_by"`
+	PlayCount   int64  `json:"play_count" db:"play_count"`
+	// Rating is the average of the song's approved reviews, rounded to two
+	// decimals, and 0 without any.
+	Rating      float64 `json:"rating" db:"rating"`
+	RatingCount int     `json:"rating_count" db:"rating_count"`
+	// Language is the ISO 639-1 code of the language detected in the text
+	// when it was written, empty if it could not be told, with a confidence
+	// from 0 to 1.
+	Language           string  `json:"language" db:"language"`
+	LanguageConfidence float64 `json:"language_confidence" db:"language_confidence"`
+	// Explicit is ExplicitOverride if it is set, and otherwise whether the
+	// text had a word of the explicit wordlist when it was written.
+	Explicit         bool  `json:"explicit" db:"explicit"`
+	ExplicitOverride *bool `json:"explicit_override,omitempty" db:"explicit_override"`
+	// Annotations are filled in only on request, for the verses returned.
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+type ApiKey struct {
+	ID        int        `json:"id" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	Prefix    string     `json:"prefix" db:"prefix"`
+	Hash      string     `json:"-" db:"key_hash"`
+	Scopes    []string   `json:"scopes" db:"scopes"`
+	Library   string     `json:"library,omitempty" db:"library"`
+	CleanMode string     `json:"clean_mode" db:"clean_mode"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type Library struct {
+	ID        int       `json:"id" db:"id"`
+	Slug      string    `json:"slug" db:"slug"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type AuditEvent struct {
+	ID         int64           `json:"id" db:"id"`
+	OccurredAt time.Time       `json:"occurred_at" db:"occurred_at"`
+	Actor      string          `json:"actor" db:"actor"`
+	Action     string          `json:"action" db:"action"`
+	Library    string          `json:"library,omitempty" db:"library"`
+	Entity     string          `json:"entity" db:"entity"`
+	EntityID   int             `json:"entity_id,omitempty" db:"entity_id"`
+	RequestID  string          `json:"request_id,omitempty" db:"request_id"`
+	SourceIP   string          `json:"source_ip,omitempty" db:"source_ip"`
+	Before     json.RawMessage `json:"before,omitempty" db:"before" swaggertype:"object"`
+	After      json.RawMessage `json:"after,omitempty" db:"after" swaggertype:"object"`
+}
+
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	Library   string
+	Entity    string
+	EntityID  int
+	RequestID string
+	From      *time.Time
+	To        *time.Time
+}
+
+type HealthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+type SongStats struct {
+	Library     string `json:"library"`
+	Total       int64  `json:"total"`
+	MissingText int64  `json:"missing_text"`
+	MissingLink int64  `json:"missing_link"`
+}
+
+// EnrichResult reports what an enrichment run changed for one song.
+type EnrichResult struct {
+	SongID  int      `json:"song_id"`
+	Group   string   `json:"group"`
+	Song    string   `json:"song"`
+	Updated []string `json:"updated"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Playlist visibilities. Private playlists are seen only by their owner.
+const (
+	PlaylistPrivate = "private"
+	PlaylistPublic  = "public"
+)
+
+type Playlist struct {
+	ID          int             `json:"id" db:"id"`
+	Name        string          `json:"name" db:"name"`
+	Owner       string          `json:"owner" db:"owner"`
+	Description string          `json:"description" db:"description"`
+	Visibility  string          `json:"visibility" db:"visibility"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	Entries     []PlaylistEntry `json:"entries,omitempty"`
+}
+
+// PlaylistUpdate holds the playlist fields to change; nil fields are kept.
+type PlaylistUpdate struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+// PlaylistEntry is one song in a playlist. The same song may appear more
+// than once. Entries are ordered by Position, starting at 1.
+type PlaylistEntry struct {
+	ID       int       `json:"id" db:"id"`
+	Position int       `json:"position" db:"position"`
+	SongID   int       `json:"song_id" db:"song_id"`
+	Group    string    `json:"group" db:"group_name"`
+	Song     string    `json:"song" db:"song_name"`
+	Link     string    `json:"link,omitempty" db:"link"`
+	AddedBy  string    `json:"added_by,omitempty" db:"added_by"`
+	AddedAt  time.Time `json:"added_at" db:"added_at"`
+}
+
+type User struct {
+	ID           int       `json:"id" db:"id"`
+	Username     string    `json:"username" db:"username"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	Role         string    `json:"role" db:"role"`
+	Library      string    `json:"library,omitempty" db:"library"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Session is a login of a user. Username, Role and Library are those of the
+// user.
+type Session struct {
+	ID        int        `json:"id" db:"id"`
+	UserID    int        `json:"user_id" db:"user_id"`
+	Username  string     `json:"username" db:"username"`
+	Role      string     `json:"role" db:"role"`
+	Library   string     `json:"library,omitempty" db:"library"`
+	Hash      string     `json:"-" db:"token_hash"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type Play struct {
+	ID       int64     `json:"id" db:"id"`
+	SongID   int       `json:"song_id" db:"song_id"`
+	Group    string    `json:"group" db:"group_name"`
+	Song     string    `json:"song" db:"song_name"`
+	PlayedAt time.Time `json:"played_at" db:"played_at"`
+}
+
+type TopSong struct {
+	SongID int    `json:"song_id"`
+	Group  string `json:"group"`
+	Song   string `json:"song"`
+	Plays  int64  `json:"plays"`
+}
+
+type TopGroup struct {
+	Group string `json:"group"`
+	Plays int64  `json:"plays"`
+}
+
+// TopReport lists the most played songs and groups since Since, or of all
+// time if it is nil.
+type TopReport struct {
+	Since  *time.Time `json:"since,omitempty"`
+	Songs  []TopSong  `json:"songs"`
+	Groups []TopGroup `json:"groups"`
+}
+
+// Review moderation states. Only approved reviews are listed to everyone;
+// rejected ones do not count towards the song's rating.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+type Review struct {
+	ID        int       `json:"id" db:"id"`
+	SongID    int       `json:"song_id" db:"song_id"`
+	Actor     string    `json:"actor" db:"actor"`
+	Rating    int       `json:"rating" db:"rating"`
+	Body      string    `json:"body" db:"body"`
+	Status    string    `json:"status" db:"status"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// ReviewFilter selects reviews; zero fields match any review.
+type ReviewFilter struct {
+	SongID int
+	Actor  string
+	Status string
+}
+
+// Annotation anchor units: an anchor spans whole lines or characters of a
+// verse.
+const (
+	AnchorLines = "line"
+	AnchorChars = "char"
+)
+
+// AnnotationAnchor locates a passage of a song's text: the lines or
+// characters (runes) Start to End, exclusive and counted from 0, of the verse
+// with index Verse in the text split on blank lines.
+type AnnotationAnchor struct {
+	Verse int    `json:"verse" db:"verse"`
+	Unit  string `json:"unit" db:"unit"`
+	Start int    `json:"start" db:"range_start"`
+	End   int    `json:"end" db:"range_end"`
+}
+
+// Annotation explains a passage of a song's text. Quote is the passage as it
+// was annotated; when the text changes the anchor moves to where the quote
+// is found, and the annotation is Orphaned if it is gone.
+type Annotation struct {
+	ID     int `json:"id" db:"id"`
+	SongID int `json:"song_id" db:"song_id"`
+	AnnotationAnchor
+	Quote     string    `json:"quote" db:"quote"`
+	Body      string    `json:"body" db:"body"`
+	Author    string    `json:"author" db:"author"`
+	Votes     int       `json:"votes" db:"votes"`
+	Orphaned  bool      `json:"orphaned" db:"orphaned"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// AnnotationUpdate holds the annotation fields to change; nil fields are
+// kept.
+type AnnotationUpdate struct {
+	Body   *string           `json:"body"`
+	Anchor *AnnotationAnchor `json:"anchor"`
+}
+
+// Tag types.
+const (
+	TagGenre    = "genre"
+	TagMood     = "mood"
+	TagLanguage = "language"
+	TagEra      = "era"
+)
+
+// Tag classifies songs of a library. Tags are written as type:name, e.g.
+// genre:rock; names are lowercase.
+type Tag struct {
+	Type string `json:"type" db:"type"`
+	Name string `json:"name" db:"name"`
+}
+
+func (t Tag) String() string {
+	return t.Type + ":" + t.Name
+}
+
+// TagCount is a tag with the number of live songs it classifies.
+type TagCount struct {
+	Tag
+	Count int `json:"count"`
+}
+
+type YearCount struct {
+	Year  int `json:"year"`
+	Count int `json:"count"`
+}
+
+type GroupCount struct {
+	Group string `json:"group"`
+	Count int    `json:"count"`
+}
+
+// SongFacets counts the songs matching a filter per tag, per release year
+// and per group, most frequent first except years, which are in order.
+type SongFacets struct {
+	Tags   []TagCount   `json:"tags"`
+	Years  []YearCount  `json:"years"`
+	Groups []GroupCount `json:"groups"`
+}
+
+// Translation is the text of a song in another language, keyed by its
+// BCP-47 language tag. Alignment[i] is the verse of the original text that
+// verse i of the translation renders; verses are counted from 0 as in
+// GetSongWithVerses.
+type Translation struct {
+	ID         int       `json:"id" db:"id"`
+	SongID     int       `json:"song_id" db:"song_id"`
+	Language   string    `json:"language" db:"language"`
+	Text       string    `json:"text" db:"text"`
+	Translator string    `json:"translator" db:"translator"`
+	Alignment  []int     `json:"alignment" db:"alignment"`
+	CreatedBy  string    `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Verse is one verse of a song text. Original and OriginalVerse are set for
+// verses of a translation when the original is asked for.
+type Verse struct {
+	Index         int    `json:"index"`
+	Text          string `json:"text"`
+	OriginalVerse *int   `json:"original_verse,omitempty"`
+	Original      string `json:"original,omitempty"`
+}
+
+// SongVerses is a page of the verses of a song in one language. Language
+// and Translator are empty for the original text.
+type SongVerses struct {
+	SongID     int    `json:"song_id"`
+	Group      string `json:"group"`
+	Song       string `json:"song"`
+	Language   string `json:"language,omitempty"`
+	Translator string `json:"translator,omitempty"`
+	// OriginalLanguage is the language detected in the original text.
+	OriginalLanguage string `json:"original_language,omitempty"`
+	// Transliteration is the scheme the verses were transliterated with
+	// into the Latin script, if any.
+	Transliteration string `json:"transliteration,omitempty"`
+	// Languages lists the languages the song is translated into.
+	Languages []string `json:"languages"`
+	Verses    []Verse  `json:"verses"`
 }
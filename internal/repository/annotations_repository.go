@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const annotationColumns = `a.id, a.song_id, a.verse, a.unit, a.range_start, a.range_end, a.quote, a.body, a.author, a.votes, a.orphaned, a.created_at, a.updated_at`
+
+func scanAnnotation(row interface{ Scan(...interface{}) error }, a *models.Annotation) error {
+	return row.Scan(&a.ID, &a.SongID, &a.Verse, &a.Unit, &a.Start, &a.End, &a.Quote, &a.Body, &a.Author, &a.Votes, &a.Orphaned, &a.CreatedAt, &a.UpdatedAt)
+}
+
+func (r *ApiRepository) AddAnnotation(ctx context.Context, annotation *models.Annotation) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO annotations (song_id, verse, unit, range_start, range_end, quote, body, author)
+		SELECT id, $2, $3, $4, $5, $6, $7, $8 FROM songs WHERE id = $1 AND library_id = $9 AND deleted_at IS NULL
+		RETURNING id, created_at, updated_at`,
+		annotation.SongID, annotation.Verse, annotation.Unit, annotation.Start, annotation.End,
+		annotation.Quote, annotation.Body, annotation.Author, r.libraryID,
+	).Scan(&annotation.ID, &annotation.CreatedAt, &annotation.UpdatedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error inserting annotation: ", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) GetAnnotation(ctx context.Context, id int) (*models.Annotation, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var annotation models.Annotation
+	row := r.db.QueryRowContext(ctx, `SELECT `+annotationColumns+`
+		FROM annotations a JOIN songs s ON s.id = a.song_id
+		WHERE a.id = $1 AND s.library_id = $2 AND s.deleted_at IS NULL`+r.hideExplicit("s"), id, r.libraryID)
+	if err := scanAnnotation(row, &annotation); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error fetching annotation: ", err)
+		}
+		return nil, err
+	}
+
+	return &annotation, nil
+}
+
+func (r *ApiRepository) GetAnnotations(ctx context.Context, songID int) ([]models.Annotation, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_annotations")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, `SELECT `+annotationColumns+`
+		FROM annotations a JOIN songs s ON s.id = a.song_id
+		WHERE a.song_id = $1 AND s.library_id = $2 AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		ORDER BY a.verse, a.range_start, a.id`, songID, r.libraryID)
+	if err != nil {
+		logger.Error("Error executing GetAnnotations query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []models.Annotation
+	for rows.Next() {
+		var annotation models.Annotation
+		if err := scanAnnotation(rows, &annotation); err != nil {
+			logger.Error("Error scanning GetAnnotations rows: ", err)
+			return nil, err
+		}
+		annotations = append(annotations, annotation)
+	}
+
+	return annotations, rows.Err()
+}
+
+func (r *ApiRepository) UpdateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "update_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	err := r.db.QueryRowContext(ctx, `UPDATE annotations
+		SET verse = $2, unit = $3, range_start = $4, range_end = $5, quote = $6, body = $7, orphaned = $8, updated_at = NOW()
+		WHERE id = $1 AND song_id IN (SELECT id FROM songs WHERE library_id = $9 AND deleted_at IS NULL)
+		RETURNING updated_at`,
+		annotation.ID, annotation.Verse, annotation.Unit, annotation.Start, annotation.End,
+		annotation.Quote, annotation.Body, annotation.Orphaned, r.libraryID,
+	).Scan(&annotation.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		logger.Error("Error updating annotation: ", err)
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+func (r *ApiRepository) DeleteAnnotation(ctx context.Context, id int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM annotations
+		WHERE id = $1 AND song_id IN (SELECT id FROM songs WHERE library_id = $2 AND deleted_at IS NULL)`, id, r.libraryID)
+	if err != nil {
+		logger.Error("Error deleting annotation: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// SetAnnotationVote runs in a transaction and locks the annotation, so that
+// the total always matches the votes. It returns sql.ErrNoRows if the
+// annotation is not in the library.
+func (r *ApiRepository) SetAnnotationVote(ctx context.Context, id int, actor string, value int) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "set_annotation_vote")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	return r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*ApiRepository)
+
+		var locked int
+		err := tx.db.QueryRowContext(ctx, `SELECT a.id FROM annotations a JOIN songs s ON s.id = a.song_id
+			WHERE a.id = $1 AND s.library_id = $2 AND s.deleted_at IS NULL
+			FOR UPDATE OF a`, id, r.libraryID).Scan(&locked)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				logger.Error("Error locking annotation: ", err)
+			}
+			return err
+		}
+
+		if value == 0 {
+			_, err = tx.db.ExecContext(ctx, `DELETE FROM annotation_votes WHERE annotation_id = $1 AND actor = $2`, id, actor)
+		} else {
+			_, err = tx.db.ExecContext(ctx, `INSERT INTO annotation_votes (annotation_id, actor, value) VALUES ($1, $2, $3)
+				ON CONFLICT (annotation_id, actor) DO UPDATE SET value = EXCLUDED.value`, id, actor, value)
+		}
+		if err != nil {
+			logger.Error("Error saving annotation vote: ", err)
+			return err
+		}
+
+		_, err = tx.db.ExecContext(ctx, `UPDATE annotations
+			SET votes = (SELECT COALESCE(SUM(value), 0) FROM annotation_votes WHERE annotation_id = $1)
+			WHERE id = $1`, id)
+		if err != nil {
+			logger.Error("Error updating annotation votes: ", err)
+		}
+		return err
+	})
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const auditColumns = `id, occurred_at, actor, action, COALESCE(library, ''), entity, COALESCE(entity_id, 0),
+	COALESCE(request_id, ''), COALESCE(source_ip, ''), before, after`
+
+func (r *ApiRepository) AddAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_audit_event")
+	defer done()
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO audit_events (actor, action, library, entity, entity_id, request_id, source_ip, before, after)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, 0), NULLIF($6, ''), NULLIF($7, ''), $8, $9)
+		RETURNING id, occurred_at`,
+		event.Actor, event.Action, event.Library, event.Entity, event.EntityID, event.RequestID, event.SourceIP,
+		nullableJSON(event.Before), nullableJSON(event.After),
+	).Scan(&event.ID, &event.OccurredAt)
+	if err != nil {
+		logger.Error("Error inserting audit event: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int, offset int) ([]models.AuditEvent, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_audit_events")
+	defer done()
+
+	query, args := auditQuery(filter)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	var events []models.AuditEvent
+	err := r.scanAuditEvents(ctx, query, args, func(event *models.AuditEvent) error {
+		events = append(events, *event)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Successfully fetched %d audit events", len(events))
+	return events, nil
+}
+
+// StreamAuditEvents calls fn for every matching event in chronological order
+// without buffering the result set, which keeps exports of large ranges cheap.
+func (r *ApiRepository) StreamAuditEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	ctx, done := r.trace(ctx, "stream_audit_events")
+	defer done()
+
+	query, args := auditQuery(filter)
+	query += " ORDER BY id"
+
+	return r.scanAuditEvents(ctx, query, args, fn)
+}
+
+func (r *ApiRepository) scanAuditEvents(ctx context.Context, query string, args []interface{}, fn func(event *models.AuditEvent) error) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error("Error executing audit events query: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.AuditEvent
+		var before, after []byte
+		if err := rows.Scan(&event.ID, &event.OccurredAt, &event.Actor, &event.Action, &event.Library, &event.Entity,
+			&event.EntityID, &event.RequestID, &event.SourceIP, &before, &after); err != nil {
+			logger.Error("Error scanning audit events rows: ", err)
+			return err
+		}
+		event.Before = before
+		event.After = after
+
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func auditQuery(filter models.AuditFilter) (string, []interface{}) {
+	query := "SELECT " + auditColumns + " FROM audit_events WHERE 1=1"
+	args := []interface{}{}
+
+	add := func(clause string, value interface{}) {
+		args = append(args, value)
+		query += fmt.Sprintf(" AND "+clause, len(args))
+	}
+
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.Library != "" {
+		add("library = $%d", filter.Library)
+	}
+	if filter.Entity != "" {
+		add("entity = $%d", filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		add("entity_id = $%d", filter.EntityID)
+	}
+	if filter.RequestID != "" {
+		add("request_id = $%d", filter.RequestID)
+	}
+	if filter.From != nil {
+		add("occurred_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		add("occurred_at < $%d", *filter.To)
+	}
+
+	return query, args
+}
+
+func nullableJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
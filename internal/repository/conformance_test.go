@@ -0,0 +1,2200 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/db"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/pkg/migrator"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// The conformance suite runs the same checks against every Store, so the
+// in-memory and SQLite stores can stand in for Postgres. The Postgres run
+// needs TEST_DATABASE_URL and works in a throwaway schema there.
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func TestMemoryRepositoryConformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) Store {
+		return NewMemoryRepository(testLogger())
+	})
+}
+
+func TestSqliteRepositoryConformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) Store {
+		sqlite, err := db.OpenSQLite(":memory:")
+		if err != nil {
+			t.Fatalf("open sqlite: %v", err)
+		}
+		t.Cleanup(func() { sqlite.Close() })
+
+		store, err := NewSqliteRepository(context.Background(), sqlite, testLogger())
+		if err != nil {
+			t.Fatalf("NewSqliteRepository: %v", err)
+		}
+		return store
+	})
+}
+
+func TestApiRepositoryConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	runConformance(t, func(t *testing.T) Store {
+		pg := openTestSchema(t, dsn)
+		if err := migrator.RunDatabaseMigrations(context.Background(), pg); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		return NewApiRepository(pg, nil, testLogger())
+	})
+}
+
+// openTestSchema returns a pool whose connections use a fresh schema, which
+// is dropped when the test ends.
+func openTestSchema(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	name := fmt.Sprintf("repository_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + name); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + name + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	separator := " "
+	if strings.Contains(dsn, "://") {
+		separator = "&"
+		if !strings.Contains(dsn, "?") {
+			separator = "?"
+		}
+	}
+	pg, err := sql.Open("postgres", dsn+separator+"search_path="+name)
+	if err != nil {
+		t.Fatalf("open schema: %v", err)
+	}
+	t.Cleanup(func() { pg.Close() })
+
+	return pg
+}
+
+func runConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store Store)
+	}{
+		{"AddAndGetSong", testAddAndGetSong},
+		{"LibraryScoping", testLibraryScoping},
+		{"Filtering", testFiltering},
+		{"Pagination", testPagination},
+		{"VerseSlicing", testVerseSlicing},
+		{"Update", testUpdate},
+		{"DeleteAndPurge", testDeleteAndPurge},
+		{"MergeSongs", testMergeSongs},
+		{"Transactions", testTransactions},
+		{"TransactionRetries", testTransactionRetries},
+		{"Libraries", testLibraries},
+		{"ApiKeys", testApiKeys},
+		{"AuditEvents", testAuditEvents},
+		{"Playlists", testPlaylists},
+		{"PlaylistEntries", testPlaylistEntries},
+		{"Users", testUsers},
+		{"Favorites", testFavorites},
+		{"Plays", testPlays},
+		{"Reviews", testReviews},
+		{"Annotations", testAnnotations},
+		{"Tags", testTags},
+		{"Translations", testTranslations},
+		{"Language", testLanguage},
+		{"Explicit", testExplicit},
+		{"WithoutExplicit", testWithoutExplicit},
+		{"DetectExplicit", testDetectExplicit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func defaultRepo(t *testing.T, store Store) Repository {
+	t.Helper()
+
+	library, err := store.GetLibraryBySlug(context.Background(), "default")
+	if err != nil {
+		t.Fatalf("default library: %v", err)
+	}
+	return store.ForLibrary(library.ID)
+}
+
+func addSong(t *testing.T, repo Repository, song models.Song) models.Song {
+	t.Helper()
+
+	if err := repo.AddNewSong(context.Background(), &song); err != nil {
+		t.Fatalf("AddNewSong(%s - %s): %v", song.Group, song.Song, err)
+	}
+	return song
+}
+
+func songIDs(songs []models.Song) []int {
+	ids := []int{}
+	for _, s := range songs {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []int) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func testAddAndGetSong(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	first := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria", ReleaseDate: "2003-12-01 00:00:00",
+		Text: "verse", Link: "https://example.com", CreatedBy: "api_key:alice"})
+	second := addSong(t, repo, models.Song{Group: "Muse", Song: "Undated", CreatedBy: "api_key:alice"})
+
+	if first.ID == 0 || second.ID <= first.ID {
+		t.Fatalf("IDs %d, %d: want increasing non-zero IDs", first.ID, second.ID)
+	}
+
+	got, err := repo.GetSong(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("GetSong: %v", err)
+	}
+	want := models.Song{ID: first.ID, Group: "Muse", Song: "Hysteria", ReleaseDate: "2003-12-01",
+		Text: "verse", Link: "https://example.com", CreatedBy: "api_key:alice", UpdatedBy: "api_key:alice"}
+	if !reflect.DeepEqual(*got, want) {
+		t.Fatalf("GetSong = %+v, want %+v", *got, want)
+	}
+
+	got, err = repo.GetSong(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("GetSong: %v", err)
+	}
+	if got.ReleaseDate != "" || got.Text != "" || got.Link != "" {
+		t.Fatalf("GetSong = %+v, want empty release date, text and link", *got)
+	}
+
+	if _, err := repo.GetSong(ctx, second.ID+100); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetSong(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testLibraryScoping(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	otherRepo := store.ForLibrary(other.ID)
+
+	song := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising"})
+
+	if _, err := otherRepo.GetSong(ctx, song.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetSong from other library error = %v, want sql.ErrNoRows", err)
+	}
+	if songs, err := otherRepo.GetData(ctx, nil, "", 10, 0); err != nil || len(songs) != 0 {
+		t.Fatalf("GetData from other library = %v, %v, want no songs", songs, err)
+	}
+	if n, err := otherRepo.DeleteSong(ctx, song.ID); err != nil || n != 0 {
+		t.Fatalf("DeleteSong from other library = %d, %v, want 0", n, err)
+	}
+
+	if _, err := store.GetData(ctx, nil, "", 10, 0); !errors.Is(err, ErrLibraryRequired) {
+		t.Fatalf("GetData without library error = %v, want ErrLibraryRequired", err)
+	}
+	if err := store.AddNewSong(ctx, &models.Song{Group: "g", Song: "s"}); !errors.Is(err, ErrLibraryRequired) {
+		t.Fatalf("AddNewSong without library error = %v, want ErrLibraryRequired", err)
+	}
+}
+
+func testFiltering(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	hysteria := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria", ReleaseDate: "2003-12-01", Text: "It's bugging me"})
+	uprising := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising", ReleaseDate: "2009-09-07", Text: "Paranoia is in bloom"})
+	kino := addSong(t, repo, models.Song{Group: "Кино", Song: "Группа крови", ReleaseDate: "1988-01-01", Link: "https://example.com/kino"})
+	addSong(t, repo, models.Song{Group: "Museum", Song: "100%_done"})
+
+	tests := []struct {
+		filter map[string]string
+		want   []int
+	}{
+		{nil, []int{hysteria.ID, uprising.ID, kino.ID, kino.ID + 1}},
+		{map[string]string{"group_name": "muse"}, []int{hysteria.ID, uprising.ID}},
+		{map[string]string{"group_name": "MUSE%"}, []int{hysteria.ID, uprising.ID, kino.ID + 1}},
+		{map[string]string{"group_name": "кино"}, []int{kino.ID}},
+		{map[string]string{"song_name": "%RISING"}, []int{uprising.ID}},
+		{map[string]string{"song_name": "_ysteria"}, []int{hysteria.ID}},
+		{map[string]string{"song_name": `100\%\_done`}, []int{kino.ID + 1}},
+		{map[string]string{"song_name": `100\%x`}, []int{}},
+		{map[string]string{"text": "%bloom%"}, []int{uprising.ID}},
+		{map[string]string{"link": "%kino"}, []int{kino.ID}},
+		{map[string]string{"release_date": "2009-09-07"}, []int{uprising.ID}},
+		{map[string]string{"group_name": "Muse", "release_date": "2003-12-01"}, []int{hysteria.ID}},
+		{map[string]string{"group_name": "Muse", "song_name": "Кино"}, []int{}},
+	}
+	for _, tt := range tests {
+		songs, err := repo.GetData(ctx, tt.filter, "", 10, 0)
+		if err != nil {
+			t.Fatalf("GetData(%v): %v", tt.filter, err)
+		}
+		if got := songIDs(songs); !equalIDs(got, tt.want) {
+			t.Errorf("GetData(%v) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+
+	if _, err := repo.GetData(ctx, map[string]string{"id; DROP TABLE songs": "1"}, "", 10, 0); err == nil {
+		t.Error("GetData with an unknown filter key succeeded")
+	}
+}
+
+func testPagination(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	var ids []int
+	for i := 0; i < 5; i++ {
+		ids = append(ids, addSong(t, repo, models.Song{Group: "Band", Song: fmt.Sprintf("Song %d", i)}).ID)
+	}
+
+	tests := []struct {
+		limit, offset int
+		want          []int
+	}{
+		{2, 0, ids[0:2]},
+		{2, 2, ids[2:4]},
+		{2, 4, ids[4:5]},
+		{10, 0, ids},
+		{2, 5, []int{}},
+	}
+	for _, tt := range tests {
+		songs, err := repo.GetData(ctx, nil, "", tt.limit, tt.offset)
+		if err != nil {
+			t.Fatalf("GetData(limit %d, offset %d): %v", tt.limit, tt.offset, err)
+		}
+		if got := songIDs(songs); !equalIDs(got, tt.want) {
+			t.Errorf("GetData(limit %d, offset %d) = %v, want %v", tt.limit, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func testVerseSlicing(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	song := addSong(t, repo, models.Song{Group: "Band", Song: "Verses", ReleaseDate: "2001-02-03", Text: "one\nline\n\ntwo\n\nthree"})
+	empty := addSong(t, repo, models.Song{Group: "Band", Song: "Instrumental"})
+
+	tests := []struct {
+		id, limit, offset int
+		want              string
+	}{
+		{song.ID, 2, 0, "one\nline\n\ntwo"},
+		{song.ID, 1, 1, "two"},
+		{song.ID, 5, 2, "three"},
+		{empty.ID, 1, 0, ""},
+	}
+	for _, tt := range tests {
+		got, err := repo.GetSongPagi(ctx, tt.id, tt.limit, tt.offset)
+		if err != nil {
+			t.Fatalf("GetSongPagi(%d, %d, %d): %v", tt.id, tt.limit, tt.offset, err)
+		}
+		if got.Text != tt.want {
+			t.Errorf("GetSongPagi(%d, %d, %d) text = %q, want %q", tt.id, tt.limit, tt.offset, got.Text, tt.want)
+		}
+	}
+
+	got, err := repo.GetSongPagi(ctx, song.ID, 1, 0)
+	if err != nil || got.ReleaseDate != "2001-02-03" || got.Group != "Band" {
+		t.Fatalf("GetSongPagi = %+v, %v, want the song's details with release date 2001-02-03", got, err)
+	}
+
+	if _, err := repo.GetSongPagi(ctx, song.ID, 1, 3); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("GetSongPagi past the last verse error = %v, want ErrOffsetOutOfRange", err)
+	}
+	if _, err := repo.GetSongPagi(ctx, empty.ID+100, 1, 0); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSongPagi(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testUpdate(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	song := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria", ReleaseDate: "2003-12-01",
+		Text: "old", Link: "https://example.com", CreatedBy: "api_key:alice"})
+
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: song.ID, Text: "new", ReleaseDate: "2004-01-02 15:04:05", UpdatedBy: "jwt:bob"}); err != nil {
+		t.Fatalf("UpdateSongData: %v", err)
+	}
+
+	got, err := repo.GetSong(ctx, song.ID)
+	if err != nil {
+		t.Fatalf("GetSong: %v", err)
+	}
+	want := models.Song{ID: song.ID, Group: "Muse", Song: "Hysteria", ReleaseDate: "2004-01-02",
+		Text: "new", Link: "https://example.com", CreatedBy: "api_key:alice", UpdatedBy: "jwt:bob"}
+	if !reflect.DeepEqual(*got, want) {
+		t.Fatalf("after update GetSong = %+v, want %+v", *got, want)
+	}
+
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: song.ID, UpdatedBy: "jwt:bob"}); err == nil {
+		t.Error("UpdateSongData without fields succeeded")
+	}
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: song.ID + 100, Text: "x"}); err != nil {
+		t.Errorf("UpdateSongData(missing) = %v, want no error", err)
+	}
+
+	if _, err := repo.DeleteSong(ctx, song.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: song.ID, Text: "ghost"}); err != nil {
+		t.Fatalf("UpdateSongData(deleted): %v", err)
+	}
+	if _, err := repo.GetSong(ctx, song.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted song is visible after update: %v", err)
+	}
+}
+
+func testDeleteAndPurge(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	kept := addSong(t, repo, models.Song{Group: "Band", Song: "Kept"})
+	deleted := addSong(t, repo, models.Song{Group: "Band", Song: "Deleted"})
+
+	if n, err := repo.DeleteSong(ctx, deleted.ID); err != nil || n != 1 {
+		t.Fatalf("DeleteSong = %d, %v, want 1", n, err)
+	}
+	if n, err := repo.DeleteSong(ctx, deleted.ID); err != nil || n != 0 {
+		t.Fatalf("second DeleteSong = %d, %v, want 0", n, err)
+	}
+
+	songs, err := repo.GetData(ctx, nil, "", 10, 0)
+	if err != nil || !equalIDs(songIDs(songs), []int{kept.ID}) {
+		t.Fatalf("GetData after delete = %v, %v, want only %d", songIDs(songs), err, kept.ID)
+	}
+
+	if ids, err := repo.PurgeDeletedSongs(ctx, time.Now().Add(-time.Hour)); err != nil || len(ids) != 0 {
+		t.Fatalf("PurgeDeletedSongs(an hour ago) = %v, %v, want nothing", ids, err)
+	}
+	if ids, err := repo.PurgeDeletedSongs(ctx, time.Now().Add(time.Minute)); err != nil || !equalIDs(ids, []int{deleted.ID}) {
+		t.Fatalf("PurgeDeletedSongs = %v, %v, want [%d]", ids, err, deleted.ID)
+	}
+	if ids, err := repo.PurgeDeletedSongs(ctx, time.Now().Add(time.Minute)); err != nil || len(ids) != 0 {
+		t.Fatalf("second PurgeDeletedSongs = %v, %v, want nothing", ids, err)
+	}
+}
+
+func testMergeSongs(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	target := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria"})
+	first := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria (Live)"})
+	second := addSong(t, repo, models.Song{Group: "muse", Song: "hysteria"})
+	trashed := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria (Demo)"})
+	other := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising"})
+	if _, err := repo.DeleteSong(ctx, trashed.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+
+	for _, play := range []struct {
+		songID int
+		actor  string
+	}{{target.ID, "user:alice"}, {first.ID, "user:alice"}, {second.ID, "user:bob"}, {second.ID, "user:bob"}} {
+		if err := repo.AddPlay(ctx, &models.Play{SongID: play.songID}, play.actor); err != nil {
+			t.Fatalf("AddPlay(%d): %v", play.songID, err)
+		}
+	}
+	for _, favorite := range []struct {
+		songID int
+		actor  string
+	}{{target.ID, "user:alice"}, {first.ID, "user:alice"}, {first.ID, "user:bob"}, {second.ID, "user:bob"}} {
+		if err := repo.AddFavorite(ctx, favorite.actor, favorite.songID); err != nil {
+			t.Fatalf("AddFavorite(%d): %v", favorite.songID, err)
+		}
+	}
+	for _, review := range []models.Review{
+		{SongID: target.ID, Actor: "user:alice", Rating: 5, Status: models.ReviewApproved},
+		{SongID: first.ID, Actor: "user:alice", Rating: 1, Status: models.ReviewApproved},
+		{SongID: first.ID, Actor: "user:bob", Rating: 2, Status: models.ReviewApproved},
+		{SongID: second.ID, Actor: "user:bob", Rating: 4, Status: models.ReviewApproved},
+	} {
+		if err := repo.SaveReview(ctx, &review); err != nil {
+			t.Fatalf("SaveReview(%d, %s): %v", review.SongID, review.Actor, err)
+		}
+	}
+	if _, err := repo.TagSongs(ctx, []int{target.ID, first.ID}, []models.Tag{{Type: "genre", Name: "rock"}}); err != nil {
+		t.Fatalf("TagSongs(rock): %v", err)
+	}
+	if _, err := repo.TagSongs(ctx, []int{second.ID}, []models.Tag{{Type: "mood", Name: "angry"}}); err != nil {
+		t.Fatalf("TagSongs(angry): %v", err)
+	}
+	playlist := addPlaylist(t, repo, models.Playlist{Name: "Mix", Owner: "user:alice", Visibility: models.PlaylistPrivate})
+	for _, id := range []int{first.ID, other.ID, target.ID} {
+		if err := repo.AddPlaylistEntry(ctx, &models.PlaylistEntry{SongID: id}, playlist.ID); err != nil {
+			t.Fatalf("AddPlaylistEntry(%d): %v", id, err)
+		}
+	}
+
+	if merged, err := store.ForLibrary(999).MergeSongs(ctx, target.ID, []int{first.ID}); err != nil || len(merged) != 0 {
+		t.Fatalf("MergeSongs in another library = %v, %v, want nothing", merged, err)
+	}
+	if merged, err := repo.MergeSongs(ctx, trashed.ID, []int{first.ID}); err != nil || len(merged) != 0 {
+		t.Fatalf("MergeSongs into a trashed song = %v, %v, want nothing", merged, err)
+	}
+	merged, err := repo.MergeSongs(ctx, target.ID, []int{second.ID, target.ID, trashed.ID, first.ID, other.ID + 100})
+	if err != nil || !equalIDs(merged, []int{first.ID, second.ID}) {
+		t.Fatalf("MergeSongs = %v, %v, want [%d %d]", merged, err, first.ID, second.ID)
+	}
+	if err := repo.RefreshSongRating(ctx, target.ID); err != nil {
+		t.Fatalf("RefreshSongRating: %v", err)
+	}
+
+	songs, err := repo.GetData(ctx, nil, "", 10, 0)
+	if err != nil || !equalIDs(songIDs(songs), []int{target.ID, other.ID}) {
+		t.Fatalf("GetData after merge = %v, %v, want [%d %d]", songIDs(songs), err, target.ID, other.ID)
+	}
+	song, err := repo.GetSong(ctx, target.ID)
+	if err != nil || song.PlayCount != 4 || song.Rating != 4.5 || song.RatingCount != 2 {
+		t.Fatalf("merged song = %+v, %v, want 4 plays and the reviews of alice and bob (4.5 of 2)", song, err)
+	}
+
+	for actor, want := range map[string]int{"user:alice": 2, "user:bob": 2} {
+		if plays, err := repo.GetPlays(ctx, actor, 10, 0); err != nil || len(plays) != want || plays[0].SongID != target.ID || plays[len(plays)-1].SongID != target.ID {
+			t.Errorf("GetPlays(%s) = %+v, %v, want %d plays of the target", actor, plays, err, want)
+		}
+		if favorites, err := repo.GetFavorites(ctx, actor, 10, 0); err != nil || !equalIDs(songIDs(favorites), []int{target.ID}) {
+			t.Errorf("GetFavorites(%s) = %v, %v, want only the target", actor, songIDs(favorites), err)
+		}
+	}
+
+	reviews, err := repo.GetReviews(ctx, models.ReviewFilter{SongID: target.ID}, 10, 0)
+	if err != nil {
+		t.Fatalf("GetReviews: %v", err)
+	}
+	ratings := map[string]int{}
+	for _, review := range reviews {
+		ratings[review.Actor] = review.Rating
+	}
+	if want := map[string]int{"user:alice": 5, "user:bob": 4}; fmt.Sprint(ratings) != fmt.Sprint(want) {
+		t.Errorf("reviews of the merged song = %v, want %v: the target's, else the latest", ratings, want)
+	}
+
+	tags, err := repo.GetSongTags(ctx, []int{target.ID})
+	if err != nil || len(tags[target.ID]) != 2 {
+		t.Errorf("GetSongTags = %+v, %v, want rock and angry", tags, err)
+	}
+
+	entries, err := repo.GetPlaylistEntries(ctx, playlist.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylistEntries: %v", err)
+	}
+	var entrySongs []int
+	for _, entry := range entries {
+		entrySongs = append(entrySongs, entry.SongID)
+	}
+	if want := []int{target.ID, other.ID, target.ID}; fmt.Sprint(entrySongs) != fmt.Sprint(want) {
+		t.Errorf("playlist songs = %v, want %v in the same positions", entrySongs, want)
+	}
+
+	if merged, err := repo.MergeSongs(ctx, target.ID, []int{first.ID}); err != nil || len(merged) != 0 {
+		t.Fatalf("second MergeSongs = %v, %v, want nothing", merged, err)
+	}
+}
+
+func testTransactions(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	song := addSong(t, repo, models.Song{Group: "Band", Song: "Original"})
+
+	errRollback := errors.New("rollback")
+	err := repo.WithTx(ctx, func(tx Repository) error {
+		if err := tx.UpdateSongData(ctx, &models.Song{ID: song.ID, Song: "Changed"}); err != nil {
+			return err
+		}
+		if err := tx.AddNewSong(ctx, &models.Song{Group: "Band", Song: "Added"}); err != nil {
+			return err
+		}
+		got, err := tx.GetSong(ctx, song.ID)
+		if err != nil {
+			return err
+		}
+		if got.Song != "Changed" {
+			t.Errorf("transaction does not see its own update: %+v", got)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx = %v, want the error returned by fn", err)
+	}
+
+	songs, err := repo.GetData(ctx, nil, "", 10, 0)
+	if err != nil || len(songs) != 1 || songs[0].Song != "Original" {
+		t.Fatalf("after rollback GetData = %+v, %v, want the original song only", songs, err)
+	}
+
+	err = repo.WithTxOptions(ctx, TxOptions{Isolation: sql.LevelRepeatableRead}, func(tx Repository) error {
+		if err := tx.UpdateSongData(ctx, &models.Song{ID: song.ID, Song: "Committed"}); err != nil {
+			return err
+		}
+		// Nested transactions join the outer one.
+		return tx.WithTx(ctx, func(tx Repository) error {
+			_, err := tx.DeleteSong(ctx, song.ID)
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTxOptions: %v", err)
+	}
+	if _, err := repo.GetSong(ctx, song.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("after commit GetSong error = %v, want the song deleted", err)
+	}
+
+	// A failing nested transaction rolls back the outer one too.
+	err = repo.WithTx(ctx, func(tx Repository) error {
+		if err := tx.AddNewSong(ctx, &models.Song{Group: "Band", Song: "Outer"}); err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(tx Repository) error {
+			if err := tx.AddNewSong(ctx, &models.Song{Group: "Band", Song: "Inner"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("nested WithTx = %v, want the error of the inner fn", err)
+	}
+	if songs, err := repo.GetData(ctx, map[string]string{"group_name": "Band"}, "", 10, 0); err != nil || len(songs) != 0 {
+		t.Fatalf("after nested rollback GetData = %+v, %v, want nothing", songs, err)
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("WithTx recovered %v, want the panic of fn to propagate", p)
+			}
+		}()
+		repo.WithTx(ctx, func(tx Repository) error {
+			if err := tx.AddNewSong(ctx, &models.Song{Group: "Band", Song: "Panicked"}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+	if songs, err := repo.GetData(ctx, map[string]string{"group_name": "Band"}, "", 10, 0); err != nil || len(songs) != 0 {
+		t.Fatalf("after panic GetData = %+v, %v, want nothing", songs, err)
+	}
+	// The store is still usable after the panic.
+	if err := repo.WithTx(ctx, func(tx Repository) error { return nil }); err != nil {
+		t.Fatalf("WithTx after panic: %v", err)
+	}
+}
+
+func testTransactionRetries(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	errOther := errors.New("not a conflict")
+	tests := []struct {
+		name        string
+		errs        []error
+		maxAttempts int
+		attempts    int
+		committed   bool
+	}{
+		{"serialization failure", []error{&pq.Error{Code: "40001"}}, 0, 2, true},
+		{"deadlock", []error{&pq.Error{Code: "40P01"}, &pq.Error{Code: "40001"}}, 0, 3, true},
+		{"wrapped conflict", []error{fmt.Errorf("save: %w", &pq.Error{Code: "40001"})}, 0, 2, true},
+		{"out of attempts", []error{&pq.Error{Code: "40001"}, &pq.Error{Code: "40001"}, &pq.Error{Code: "40001"}}, 0, DefaultTxAttempts, false},
+		{"max attempts", []error{&pq.Error{Code: "40001"}, &pq.Error{Code: "40001"}}, 2, 2, false},
+		{"unique violation", []error{&pq.Error{Code: "23505"}}, 0, 1, false},
+		{"other error", []error{errOther}, 0, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := repo.WithTxOptions(ctx, TxOptions{MaxAttempts: tt.maxAttempts}, func(tx Repository) error {
+				attempts++
+				if err := tx.AddNewSong(ctx, &models.Song{Group: "Retry", Song: tt.name}); err != nil {
+					return err
+				}
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if attempts != tt.attempts {
+				t.Errorf("fn ran %d times, want %d", attempts, tt.attempts)
+			}
+			if tt.committed != (err == nil) {
+				t.Fatalf("WithTxOptions = %v, committed want %v", err, tt.committed)
+			}
+			if !tt.committed && !errors.Is(err, tt.errs[tt.attempts-1]) {
+				t.Errorf("WithTxOptions = %v, want the error of the last attempt", err)
+			}
+
+			want := 0
+			if tt.committed {
+				want = 1
+			}
+			songs, err := repo.GetData(ctx, map[string]string{"song_name": tt.name}, "", 10, 0)
+			if err != nil || len(songs) != want {
+				t.Fatalf("songs of the transaction = %+v, %v, want %d: failed attempts roll back", songs, err, want)
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		attempts := 0
+		err := repo.WithTx(ctx, func(tx Repository) error {
+			attempts++
+			cancel()
+			return &pq.Error{Code: "40001"}
+		})
+		if !errors.Is(err, context.Canceled) || attempts != 1 {
+			t.Fatalf("WithTx = %v after %d attempts, want context.Canceled after 1", err, attempts)
+		}
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		// Only the outermost transaction retries; a nested one joins it and
+		// hands the conflict up.
+		outer, inner := 0, 0
+		err := repo.WithTx(ctx, func(tx Repository) error {
+			outer++
+			return tx.WithTx(ctx, func(tx Repository) error {
+				inner++
+				if inner == 1 {
+					return &pq.Error{Code: "40001"}
+				}
+				return nil
+			})
+		})
+		if err != nil || outer != 2 || inner != 2 {
+			t.Fatalf("nested WithTx = %v, ran outer %d and inner %d times, want 2 each", err, outer, inner)
+		}
+	})
+}
+
+func testLibraries(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	target := &models.Library{Slug: "archive", Name: "Archive"}
+	if err := store.AddLibrary(ctx, target); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	if target.ID == 0 || target.CreatedAt.IsZero() {
+		t.Fatalf("AddLibrary did not set ID and creation time: %+v", target)
+	}
+	if err := store.AddLibrary(ctx, &models.Library{Slug: "archive", Name: "Again"}); err == nil {
+		t.Fatal("AddLibrary with a duplicate slug succeeded")
+	}
+	if _, err := store.GetLibraryBySlug(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetLibraryBySlug(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	libraries, err := store.GetLibraries(ctx)
+	if err != nil || len(libraries) != 2 || libraries[0].Slug != "archive" || libraries[1].Slug != "default" {
+		t.Fatalf("GetLibraries = %+v, %v, want archive and default", libraries, err)
+	}
+
+	a := addSong(t, repo, models.Song{Group: "Band", Song: "A", Text: "text", CreatedBy: "cli:root"})
+	b := addSong(t, repo, models.Song{Group: "Band", Song: "B", Link: "https://example.com"})
+	gone := addSong(t, repo, models.Song{Group: "Band", Song: "Gone"})
+	if _, err := repo.DeleteSong(ctx, gone.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+
+	if n, err := store.CopySongs(ctx, libraries[1].ID, target.ID, []int{b.ID, gone.ID}, "api_key:9"); err != nil || n != 1 {
+		t.Fatalf("CopySongs(selected) = %d, %v, want 1", n, err)
+	}
+	if n, err := store.CopySongs(ctx, libraries[1].ID, target.ID, nil, "api_key:9"); err != nil || n != 2 {
+		t.Fatalf("CopySongs(all) = %d, %v, want 2", n, err)
+	}
+
+	copies, err := store.ForLibrary(target.ID).GetData(ctx, map[string]string{"song_name": "A"}, "", 10, 0)
+	if err != nil || len(copies) != 1 || copies[0].ID == a.ID || copies[0].Text != "text" || copies[0].CreatedBy != "api_key:9" || copies[0].UpdatedBy != "api_key:9" {
+		t.Fatalf("copied song = %+v, %v, want a new copy of song A made by api_key:9", copies, err)
+	}
+
+	stats, err := store.GetSongStats(ctx)
+	if err != nil {
+		t.Fatalf("GetSongStats: %v", err)
+	}
+	got := map[string]models.SongStats{}
+	for _, s := range stats {
+		got[s.Library] = s
+	}
+	want := map[string]models.SongStats{
+		"archive": {Library: "archive", Total: 3, MissingText: 2, MissingLink: 1},
+		"default": {Library: "default", Total: 2, MissingText: 1, MissingLink: 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("GetSongStats = %v, want %v", got, want)
+	}
+}
+
+func testApiKeys(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	key := &models.ApiKey{Name: "ci", Prefix: "abcd1234", Hash: strings.Repeat("a", 64), Scopes: []string{"songs:read", "songs:write"}, Library: "default", CleanMode: "mask"}
+	if err := store.AddApiKey(ctx, key); err != nil {
+		t.Fatalf("AddApiKey: %v", err)
+	}
+	if key.ID == 0 || key.CreatedAt.IsZero() {
+		t.Fatalf("AddApiKey did not set ID and creation time: %+v", key)
+	}
+	if err := store.AddApiKey(ctx, &models.ApiKey{Name: "dup", Prefix: "x", Hash: key.Hash, Scopes: []string{"admin"}, CleanMode: "off"}); err == nil {
+		t.Fatal("AddApiKey with a duplicate hash succeeded")
+	}
+
+	got, err := store.GetApiKeyByHash(ctx, key.Hash)
+	if err != nil {
+		t.Fatalf("GetApiKeyByHash: %v", err)
+	}
+	if got.ID != key.ID || got.Name != "ci" || got.Library != "default" || strings.Join(got.Scopes, " ") != "songs:read songs:write" || got.CleanMode != "mask" || got.RevokedAt != nil {
+		t.Fatalf("GetApiKeyByHash = %+v", got)
+	}
+
+	if n, err := store.RevokeApiKey(ctx, key.ID); err != nil || n != 1 {
+		t.Fatalf("RevokeApiKey = %d, %v, want 1", n, err)
+	}
+	if n, err := store.RevokeApiKey(ctx, key.ID); err != nil || n != 0 {
+		t.Fatalf("second RevokeApiKey = %d, %v, want 0", n, err)
+	}
+	if got, err := store.GetApiKeyByHash(ctx, key.Hash); err != nil || got.RevokedAt == nil {
+		t.Fatalf("revoked key = %+v, %v, want revoked_at set", got, err)
+	}
+
+	if _, err := store.GetApiKeyByHash(ctx, strings.Repeat("b", 64)); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetApiKeyByHash(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testAuditEvents(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	for i, actor := range []string{"api_key:a", "jwt:b", "api_key:a"} {
+		event := &models.AuditEvent{Actor: actor, Action: "update", Library: "default", Entity: "song", EntityID: i + 1,
+			RequestID: fmt.Sprintf("req-%d", i), After: []byte(`{"id":1}`)}
+		if err := store.AddAuditEvent(ctx, event); err != nil {
+			t.Fatalf("AddAuditEvent: %v", err)
+		}
+		if event.ID == 0 || event.OccurredAt.IsZero() {
+			t.Fatalf("AddAuditEvent did not set ID and time: %+v", event)
+		}
+	}
+
+	events, err := store.GetAuditEvents(ctx, models.AuditFilter{}, 2, 0)
+	if err != nil || len(events) != 2 || events[0].EntityID != 3 || events[1].EntityID != 2 {
+		t.Fatalf("GetAuditEvents = %+v, %v, want the newest two first", events, err)
+	}
+	if events[0].Before != nil || string(events[0].After) == "" {
+		t.Fatalf("GetAuditEvents snapshots: before %q, after %q", events[0].Before, events[0].After)
+	}
+
+	events, err = store.GetAuditEvents(ctx, models.AuditFilter{Actor: "api_key:a"}, 10, 1)
+	if err != nil || len(events) != 1 || events[0].EntityID != 1 {
+		t.Fatalf("GetAuditEvents(actor, offset 1) = %+v, %v, want the first event", events, err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if events, err := store.GetAuditEvents(ctx, models.AuditFilter{From: &future}, 10, 0); err != nil || len(events) != 0 {
+		t.Fatalf("GetAuditEvents(from the future) = %+v, %v, want nothing", events, err)
+	}
+
+	var streamed []int
+	err = store.StreamAuditEvents(ctx, models.AuditFilter{Entity: "song"}, func(event *models.AuditEvent) error {
+		streamed = append(streamed, event.EntityID)
+		return nil
+	})
+	if err != nil || !equalIDs(streamed, []int{1, 2, 3}) {
+		t.Fatalf("StreamAuditEvents = %v, %v, want [1 2 3]", streamed, err)
+	}
+
+	// Events written in a transaction go with its mutation.
+	errRollback := errors.New("rollback")
+	err = defaultRepo(t, store).WithTx(ctx, func(repo Repository) error {
+		if err := repo.AddAuditEvent(ctx, &models.AuditEvent{Actor: "jwt:b", Action: "create", Entity: "song", EntityID: 4}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx error = %v, want %v", err, errRollback)
+	}
+	err = store.WithLibraryTx(ctx, func(repo LibraryRepository) error {
+		if err := repo.AddLibrary(ctx, &models.Library{Slug: "audited", Name: "Audited"}); err != nil {
+			return err
+		}
+		if err := repo.AddAuditEvent(ctx, &models.AuditEvent{Actor: "jwt:b", Action: "create", Entity: "library", EntityID: 5}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithLibraryTx error = %v, want %v", err, errRollback)
+	}
+	if events, err := store.GetAuditEvents(ctx, models.AuditFilter{Actor: "jwt:b"}, 10, 0); err != nil || len(events) != 1 {
+		t.Fatalf("GetAuditEvents after rollback = %+v, %v, want the committed event only", events, err)
+	}
+	if _, err := store.GetLibraryBySlug(ctx, "audited"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetLibraryBySlug after rollback error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func addPlaylist(t *testing.T, repo Repository, playlist models.Playlist) models.Playlist {
+	t.Helper()
+
+	if err := repo.AddPlaylist(context.Background(), &playlist); err != nil {
+		t.Fatalf("AddPlaylist(%s): %v", playlist.Name, err)
+	}
+	return playlist
+}
+
+func playlistIDs(playlists []models.Playlist) []int {
+	ids := []int{}
+	for _, p := range playlists {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func testPlaylists(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	mine := addPlaylist(t, repo, models.Playlist{Name: "Mine", Owner: "api_key:alice", Description: "road trip", Visibility: models.PlaylistPrivate})
+	shared := addPlaylist(t, repo, models.Playlist{Name: "Shared", Owner: "api_key:bob", Visibility: models.PlaylistPublic})
+	hidden := addPlaylist(t, repo, models.Playlist{Name: "Hidden", Owner: "api_key:bob", Visibility: models.PlaylistPrivate})
+
+	if mine.ID == 0 || mine.CreatedAt.IsZero() {
+		t.Fatalf("AddPlaylist = %+v, want ID and created_at", mine)
+	}
+
+	got, err := repo.GetPlaylist(ctx, mine.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylist: %v", err)
+	}
+	if got.Name != "Mine" || got.Owner != "api_key:alice" || got.Description != "road trip" || got.Visibility != models.PlaylistPrivate {
+		t.Fatalf("GetPlaylist = %+v", *got)
+	}
+
+	tests := []struct {
+		viewer string
+		want   []int
+	}{
+		{"", []int{mine.ID, shared.ID, hidden.ID}},
+		{"api_key:alice", []int{mine.ID, shared.ID}},
+		{"api_key:bob", []int{shared.ID, hidden.ID}},
+		{"anonymous", []int{shared.ID}},
+	}
+	for _, tt := range tests {
+		playlists, err := repo.GetPlaylists(ctx, tt.viewer, 10, 0)
+		if err != nil {
+			t.Fatalf("GetPlaylists(%q): %v", tt.viewer, err)
+		}
+		if got := playlistIDs(playlists); !equalIDs(got, tt.want) {
+			t.Errorf("GetPlaylists(%q) = %v, want %v", tt.viewer, got, tt.want)
+		}
+	}
+	if playlists, err := repo.GetPlaylists(ctx, "", 1, 1); err != nil || !equalIDs(playlistIDs(playlists), []int{shared.ID}) {
+		t.Errorf("GetPlaylists(limit 1, offset 1) = %v, %v, want [%d]", playlistIDs(playlists), err, shared.ID)
+	}
+
+	mine.Name, mine.Description, mine.Visibility = "Renamed", "", models.PlaylistPublic
+	if n, err := repo.UpdatePlaylist(ctx, &mine); err != nil || n != 1 {
+		t.Fatalf("UpdatePlaylist = %d, %v, want 1", n, err)
+	}
+	got, err = repo.GetPlaylist(ctx, mine.ID)
+	if err != nil || got.Name != "Renamed" || got.Description != "" || got.Visibility != models.PlaylistPublic || got.Owner != "api_key:alice" {
+		t.Fatalf("after update GetPlaylist = %+v, %v", got, err)
+	}
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	otherRepo := store.ForLibrary(other.ID)
+	if _, err := otherRepo.GetPlaylist(ctx, mine.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPlaylist from other library error = %v, want sql.ErrNoRows", err)
+	}
+	if playlists, err := otherRepo.GetPlaylists(ctx, "", 10, 0); err != nil || len(playlists) != 0 {
+		t.Errorf("GetPlaylists from other library = %v, %v, want none", playlistIDs(playlists), err)
+	}
+	if n, err := otherRepo.DeletePlaylist(ctx, mine.ID); err != nil || n != 0 {
+		t.Errorf("DeletePlaylist from other library = %d, %v, want 0", n, err)
+	}
+	if _, err := store.GetPlaylists(ctx, "", 10, 0); !errors.Is(err, ErrLibraryRequired) {
+		t.Errorf("GetPlaylists without library error = %v, want ErrLibraryRequired", err)
+	}
+
+	if n, err := repo.DeletePlaylist(ctx, hidden.ID); err != nil || n != 1 {
+		t.Fatalf("DeletePlaylist = %d, %v, want 1", n, err)
+	}
+	if _, err := repo.GetPlaylist(ctx, hidden.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPlaylist(deleted) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func entryIDs(entries []models.PlaylistEntry) []int {
+	ids := []int{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func testPlaylistEntries(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	first := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria", Link: "https://example.com/hysteria"})
+	second := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising"})
+	playlist := addPlaylist(t, repo, models.Playlist{Name: "Mix", Owner: "api_key:alice", Visibility: models.PlaylistPrivate})
+
+	add := func(songID, position int) models.PlaylistEntry {
+		t.Helper()
+		entry := models.PlaylistEntry{SongID: songID, AddedBy: "api_key:alice"}
+		if err := repo.AddPlaylistEntry(ctx, &entry, playlist.ID); err != nil {
+			t.Fatalf("AddPlaylistEntry(song %d): %v", songID, err)
+		}
+		if entry.Position != position {
+			t.Fatalf("AddPlaylistEntry(song %d) position = %d, want %d", songID, entry.Position, position)
+		}
+		return entry
+	}
+	allIDs := func() []int {
+		t.Helper()
+		ids, err := repo.GetPlaylistEntryIDs(ctx, playlist.ID)
+		if err != nil {
+			t.Fatalf("GetPlaylistEntryIDs: %v", err)
+		}
+		return ids
+	}
+	entries := func() []models.PlaylistEntry {
+		t.Helper()
+		entries, err := repo.GetPlaylistEntries(ctx, playlist.ID)
+		if err != nil {
+			t.Fatalf("GetPlaylistEntries: %v", err)
+		}
+		return entries
+	}
+
+	a := add(first.ID, 1)
+	b := add(second.ID, 2)
+	c := add(first.ID, 3)
+
+	got := entries()
+	if !equalIDs(entryIDs(got), []int{a.ID, b.ID, c.ID}) {
+		t.Fatalf("entries = %v, want %v", entryIDs(got), []int{a.ID, b.ID, c.ID})
+	}
+	want := models.PlaylistEntry{ID: a.ID, Position: 1, SongID: first.ID, Group: "Muse", Song: "Hysteria",
+		Link: "https://example.com/hysteria", AddedBy: "api_key:alice"}
+	got[0].AddedAt = time.Time{}
+	if got[0] != want {
+		t.Errorf("first entry = %+v, want %+v", got[0], want)
+	}
+
+	if err := repo.SetPlaylistPositions(ctx, playlist.ID, []int{c.ID, a.ID, b.ID}); err != nil {
+		t.Fatalf("SetPlaylistPositions: %v", err)
+	}
+	got = entries()
+	if !equalIDs(entryIDs(got), []int{c.ID, a.ID, b.ID}) || got[0].Position != 1 || got[2].Position != 3 {
+		t.Fatalf("after reorder entries = %+v", got)
+	}
+
+	if n, err := repo.DeletePlaylistEntry(ctx, playlist.ID, a.ID); err != nil || n != 1 {
+		t.Fatalf("DeletePlaylistEntry = %d, %v, want 1", n, err)
+	}
+	if n, err := repo.DeletePlaylistEntry(ctx, playlist.ID+100, b.ID); err != nil || n != 0 {
+		t.Fatalf("DeletePlaylistEntry(wrong playlist) = %d, %v, want 0", n, err)
+	}
+	if got := entryIDs(entries()); !equalIDs(got, []int{c.ID, b.ID}) {
+		t.Fatalf("after delete entries = %v, want %v", got, []int{c.ID, b.ID})
+	}
+
+	// Positions are unique, so every entry has to be renumbered at once.
+	if err := repo.SetPlaylistPositions(ctx, playlist.ID, []int{b.ID}); err == nil {
+		t.Fatal("SetPlaylistPositions moving an entry onto another one succeeded")
+	}
+	if got := entries(); !equalIDs(entryIDs(got), []int{c.ID, b.ID}) || got[0].Position != 1 || got[1].Position != 3 {
+		t.Fatalf("entries after failed renumbering = %+v", got)
+	}
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	foreign := addSong(t, store.ForLibrary(other.ID), models.Song{Group: "Other", Song: "Foreign"})
+	if err := repo.AddPlaylistEntry(ctx, &models.PlaylistEntry{SongID: foreign.ID}, playlist.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("AddPlaylistEntry(song of other library) error = %v, want sql.ErrNoRows", err)
+	}
+	if entries, err := store.ForLibrary(other.ID).GetPlaylistEntries(ctx, playlist.ID); err != nil || len(entries) != 0 {
+		t.Errorf("GetPlaylistEntries from other library = %v, %v, want none", entryIDs(entries), err)
+	}
+
+	// Songs in the trash drop out of playlists and their entries go when
+	// the song is purged.
+	if _, err := repo.DeleteSong(ctx, first.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	if got := entryIDs(entries()); !equalIDs(got, []int{b.ID}) {
+		t.Fatalf("entries with song in trash = %v, want [%d]", got, b.ID)
+	}
+	if got := allIDs(); !equalIDs(got, []int{c.ID, b.ID}) {
+		t.Fatalf("all entries with song in trash = %v, want %v", got, []int{c.ID, b.ID})
+	}
+	if ids, err := store.ForLibrary(other.ID).GetPlaylistEntryIDs(ctx, playlist.ID); err != nil || len(ids) != 0 {
+		t.Errorf("GetPlaylistEntryIDs from other library = %v, %v, want none", ids, err)
+	}
+	// Hidden entries still hold their positions.
+	d := add(second.ID, 4)
+	if n, err := repo.DeletePlaylistEntry(ctx, playlist.ID, d.ID); err != nil || n != 1 {
+		t.Fatalf("DeletePlaylistEntry = %d, %v, want 1", n, err)
+	}
+
+	explicit := true
+	if _, err := repo.SetExplicitOverride(ctx, second.ID, &explicit); err != nil {
+		t.Fatalf("SetExplicitOverride: %v", err)
+	}
+	clean := repo.WithoutExplicit()
+	if got, err := clean.GetPlaylistEntries(ctx, playlist.ID); err != nil || len(got) != 0 {
+		t.Fatalf("clean GetPlaylistEntries = %v, %v, want none", entryIDs(got), err)
+	}
+	if got, err := clean.GetPlaylistEntryIDs(ctx, playlist.ID); err != nil || !equalIDs(got, []int{c.ID, b.ID}) {
+		t.Fatalf("clean GetPlaylistEntryIDs = %v, %v, want %v", got, err, []int{c.ID, b.ID})
+	}
+	if _, err := repo.SetExplicitOverride(ctx, second.ID, nil); err != nil {
+		t.Fatalf("SetExplicitOverride(nil): %v", err)
+	}
+	if err := repo.AddPlaylistEntry(ctx, &models.PlaylistEntry{SongID: first.ID}, playlist.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("AddPlaylistEntry(song in trash) error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := repo.PurgeDeletedSongs(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("PurgeDeletedSongs: %v", err)
+	}
+	if got := entryIDs(entries()); !equalIDs(got, []int{b.ID}) {
+		t.Fatalf("entries after purge = %v, want [%d]", got, b.ID)
+	}
+
+	errRollback := errors.New("rollback")
+	err := repo.WithTx(ctx, func(tx Repository) error {
+		entry := models.PlaylistEntry{SongID: second.ID}
+		if err := tx.AddPlaylistEntry(ctx, &entry, playlist.ID); err != nil {
+			return err
+		}
+		if err := tx.SetPlaylistPositions(ctx, playlist.ID, []int{entry.ID, b.ID}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx error = %v, want %v", err, errRollback)
+	}
+	if got := entries(); !equalIDs(entryIDs(got), []int{b.ID}) || got[0].Position != 3 {
+		t.Fatalf("entries after rollback = %+v, want only %d at position 3", got, b.ID)
+	}
+
+	if _, err := repo.DeletePlaylist(ctx, playlist.ID); err != nil {
+		t.Fatalf("DeletePlaylist: %v", err)
+	}
+	if got := entries(); len(got) != 0 {
+		t.Fatalf("entries of deleted playlist = %v", entryIDs(got))
+	}
+}
+
+func testUsers(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	user := &models.User{Username: "alice", PasswordHash: "hash", Role: "editor"}
+	if err := store.AddUser(ctx, user); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	if user.ID == 0 || user.CreatedAt.IsZero() {
+		t.Fatalf("AddUser did not set ID and creation time: %+v", user)
+	}
+	if err := store.AddUser(ctx, &models.User{Username: "alice", PasswordHash: "other", Role: "reader"}); err == nil {
+		t.Fatal("AddUser with a duplicate username succeeded")
+	}
+
+	got, err := store.GetUserByName(ctx, "alice")
+	if err != nil || got.ID != user.ID || got.PasswordHash != "hash" || got.Role != "editor" || got.Library != "" {
+		t.Fatalf("GetUserByName = %+v, %v", got, err)
+	}
+
+	if err := store.AddLibrary(ctx, &models.Library{Slug: "club", Name: "Club"}); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	bound := &models.User{Username: "bob", PasswordHash: "hash", Role: "reader", Library: "club"}
+	if err := store.AddUser(ctx, bound); err != nil {
+		t.Fatalf("AddUser(bound): %v", err)
+	}
+	if got, err := store.GetUserByName(ctx, "bob"); err != nil || got.Library != "club" {
+		t.Fatalf("GetUserByName(bound) = %+v, %v", got, err)
+	}
+	boundSession := &models.Session{UserID: bound.ID, Hash: strings.Repeat("e", 64), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.AddSession(ctx, boundSession); err != nil {
+		t.Fatalf("AddSession(bound): %v", err)
+	}
+	if got, err := store.GetSessionByHash(ctx, boundSession.Hash); err != nil || got.Library != "club" {
+		t.Fatalf("GetSessionByHash(bound) = %+v, %v", got, err)
+	}
+	if _, err := store.GetUserByName(ctx, "carol"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetUserByName(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	session := &models.Session{UserID: user.ID, Hash: strings.Repeat("c", 64), ExpiresAt: expires}
+	if err := store.AddSession(ctx, session); err != nil {
+		t.Fatalf("AddSession: %v", err)
+	}
+	if session.ID == 0 || session.CreatedAt.IsZero() {
+		t.Fatalf("AddSession did not set ID and creation time: %+v", session)
+	}
+
+	gotSession, err := store.GetSessionByHash(ctx, session.Hash)
+	if err != nil {
+		t.Fatalf("GetSessionByHash: %v", err)
+	}
+	if gotSession.ID != session.ID || gotSession.Username != "alice" || gotSession.Role != "editor" || gotSession.Library != "" ||
+		!gotSession.ExpiresAt.Equal(expires) || gotSession.RevokedAt != nil {
+		t.Fatalf("GetSessionByHash = %+v", gotSession)
+	}
+
+	if n, err := store.RevokeSession(ctx, session.ID); err != nil || n != 1 {
+		t.Fatalf("RevokeSession = %d, %v, want 1", n, err)
+	}
+	if n, err := store.RevokeSession(ctx, session.ID); err != nil || n != 0 {
+		t.Fatalf("second RevokeSession = %d, %v, want 0", n, err)
+	}
+	if got, err := store.GetSessionByHash(ctx, session.Hash); err != nil || got.RevokedAt == nil {
+		t.Fatalf("revoked session = %+v, %v, want revoked_at set", got, err)
+	}
+	if _, err := store.GetSessionByHash(ctx, strings.Repeat("d", 64)); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetSessionByHash(missing) error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testFavorites(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	first := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising"})
+	second := addSong(t, repo, models.Song{Group: "Muse", Song: "Madness"})
+	trashed := addSong(t, repo, models.Song{Group: "Muse", Song: "Gone"})
+
+	for _, id := range []int{first.ID, second.ID, trashed.ID, first.ID} {
+		if err := repo.AddFavorite(ctx, "user:alice", id); err != nil {
+			t.Fatalf("AddFavorite(%d): %v", id, err)
+		}
+	}
+	if err := repo.AddFavorite(ctx, "user:bob", second.ID); err != nil {
+		t.Fatalf("AddFavorite(bob): %v", err)
+	}
+	if err := repo.AddFavorite(ctx, "user:alice", trashed.ID+100); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("AddFavorite(missing) error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := repo.DeleteSong(ctx, trashed.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+
+	favorites, err := repo.GetFavorites(ctx, "user:alice", 10, 0)
+	if err != nil {
+		t.Fatalf("GetFavorites: %v", err)
+	}
+	if got, want := songIDs(favorites), []int{second.ID, first.ID}; !equalIDs(got, want) {
+		t.Fatalf("GetFavorites = %v, want %v, newest first without trashed songs", got, want)
+	}
+	if favorites, err := repo.GetFavorites(ctx, "user:alice", 1, 1); err != nil || !equalIDs(songIDs(favorites), []int{first.ID}) {
+		t.Fatalf("GetFavorites(limit 1, offset 1) = %v, %v", songIDs(favorites), err)
+	}
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	if err := store.ForLibrary(other.ID).AddFavorite(ctx, "user:alice", first.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("AddFavorite from other library error = %v, want sql.ErrNoRows", err)
+	}
+
+	if n, err := repo.DeleteFavorite(ctx, "user:alice", second.ID); err != nil || n != 1 {
+		t.Fatalf("DeleteFavorite = %d, %v, want 1", n, err)
+	}
+	if n, err := repo.DeleteFavorite(ctx, "user:alice", second.ID); err != nil || n != 0 {
+		t.Fatalf("second DeleteFavorite = %d, %v, want 0", n, err)
+	}
+	if favorites, err := repo.GetFavorites(ctx, "user:bob", 10, 0); err != nil || !equalIDs(songIDs(favorites), []int{second.ID}) {
+		t.Fatalf("bob's favorites = %v, %v", songIDs(favorites), err)
+	}
+}
+
+func testPlays(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	hysteria := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria"})
+	uprising := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising"})
+	innuendo := addSong(t, repo, models.Song{Group: "Queen", Song: "Innuendo"})
+
+	var last models.Play
+	for _, id := range []int{innuendo.ID, uprising.ID, hysteria.ID, uprising.ID, innuendo.ID, uprising.ID} {
+		last = models.Play{SongID: id}
+		if err := repo.AddPlay(ctx, &last, "user:alice"); err != nil {
+			t.Fatalf("AddPlay(%d): %v", id, err)
+		}
+	}
+	if last.ID == 0 || last.PlayedAt.IsZero() || last.Group != "Muse" || last.Song != "Uprising" {
+		t.Fatalf("AddPlay did not fill in the play: %+v", last)
+	}
+	if err := repo.AddPlay(ctx, &models.Play{SongID: hysteria.ID}, "user:bob"); err != nil {
+		t.Fatalf("AddPlay(bob): %v", err)
+	}
+	if err := repo.AddPlay(ctx, &models.Play{SongID: innuendo.ID + 100}, "user:alice"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("AddPlay(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	if song, err := repo.GetSong(ctx, uprising.ID); err != nil || song.PlayCount != 3 {
+		t.Fatalf("play count of Uprising = %+v, %v, want 3", song, err)
+	}
+
+	byPlays, err := repo.GetData(ctx, nil, "-play_count", 10, 0)
+	if err != nil {
+		t.Fatalf("GetData(-play_count): %v", err)
+	}
+	if got, want := songIDs(byPlays), []int{uprising.ID, hysteria.ID, innuendo.ID}; !equalIDs(got, want) {
+		t.Fatalf("GetData(-play_count) = %v, want %v", got, want)
+	}
+	if byPlays, err := repo.GetData(ctx, nil, "play_count", 1, 0); err != nil || !equalIDs(songIDs(byPlays), []int{hysteria.ID}) {
+		t.Fatalf("GetData(play_count, limit 1) = %v, %v", songIDs(byPlays), err)
+	}
+	if _, err := repo.GetData(ctx, nil, "play_count; DROP TABLE songs", 10, 0); err == nil {
+		t.Fatal("GetData with an unknown sort succeeded")
+	}
+
+	plays, err := repo.GetPlays(ctx, "user:alice", 2, 0)
+	if err != nil {
+		t.Fatalf("GetPlays: %v", err)
+	}
+	if len(plays) != 2 || plays[0].ID != last.ID || plays[1].SongID != innuendo.ID || plays[1].Song != "Innuendo" {
+		t.Fatalf("GetPlays = %+v, want the newest two", plays)
+	}
+
+	songs, err := repo.GetTopSongs(ctx, "user:alice", time.Time{}, 2)
+	if err != nil {
+		t.Fatalf("GetTopSongs: %v", err)
+	}
+	want := []models.TopSong{
+		{SongID: uprising.ID, Group: "Muse", Song: "Uprising", Plays: 3},
+		{SongID: innuendo.ID, Group: "Queen", Song: "Innuendo", Plays: 2},
+	}
+	if fmt.Sprint(songs) != fmt.Sprint(want) {
+		t.Fatalf("GetTopSongs = %+v, want %+v", songs, want)
+	}
+
+	groups, err := repo.GetTopGroups(ctx, "user:alice", time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatalf("GetTopGroups: %v", err)
+	}
+	if want := []models.TopGroup{{Group: "Muse", Plays: 4}, {Group: "Queen", Plays: 2}}; fmt.Sprint(groups) != fmt.Sprint(want) {
+		t.Fatalf("GetTopGroups = %+v, want %+v", groups, want)
+	}
+	if groups, err := repo.GetTopGroups(ctx, "user:alice", time.Now().Add(time.Hour), 10); err != nil || len(groups) != 0 {
+		t.Fatalf("GetTopGroups(future window) = %+v, %v, want none", groups, err)
+	}
+
+	if _, err := repo.DeleteSong(ctx, uprising.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	if songs, err := repo.GetTopSongs(ctx, "user:alice", time.Time{}, 10); err != nil || len(songs) != 2 || songs[0].SongID != innuendo.ID {
+		t.Fatalf("GetTopSongs after trashing = %+v, %v", songs, err)
+	}
+}
+
+func reviewIDs(reviews []models.Review) []int {
+	ids := make([]int, len(reviews))
+	for i, review := range reviews {
+		ids[i] = review.ID
+	}
+	return ids
+}
+
+// saveReview saves the review and refreshes the song's rating, as the
+// service does.
+func saveReview(t *testing.T, repo Repository, review models.Review) models.Review {
+	t.Helper()
+
+	if err := repo.SaveReview(context.Background(), &review); err != nil {
+		t.Fatalf("SaveReview(%+v): %v", review, err)
+	}
+	if err := repo.RefreshSongRating(context.Background(), review.SongID); err != nil {
+		t.Fatalf("RefreshSongRating(%d): %v", review.SongID, err)
+	}
+	return review
+}
+
+func testReviews(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	hysteria := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria"})
+	uprising := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising"})
+	innuendo := addSong(t, repo, models.Song{Group: "Queen", Song: "Innuendo"})
+
+	alice := saveReview(t, repo, models.Review{SongID: hysteria.ID, Actor: "user:alice", Rating: 5, Body: "Great riff", Status: models.ReviewPending})
+	if alice.ID == 0 || alice.CreatedAt.IsZero() || alice.UpdatedAt.IsZero() {
+		t.Fatalf("SaveReview did not fill in the review: %+v", alice)
+	}
+	saveReview(t, repo, models.Review{SongID: hysteria.ID, Actor: "user:bob", Rating: 4, Status: models.ReviewApproved})
+	carol := saveReview(t, repo, models.Review{SongID: hysteria.ID, Actor: "user:carol", Rating: 4, Status: models.ReviewApproved})
+	saveReview(t, repo, models.Review{SongID: uprising.ID, Actor: "user:alice", Rating: 3, Status: models.ReviewApproved})
+	if err := repo.SaveReview(ctx, &models.Review{SongID: innuendo.ID + 100, Actor: "user:alice", Rating: 3, Status: models.ReviewApproved}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("SaveReview(missing song) error = %v, want sql.ErrNoRows", err)
+	}
+
+	// Reviews waiting for moderation do not count.
+	if song, err := repo.GetSong(ctx, hysteria.ID); err != nil || song.Rating != 4 || song.RatingCount != 2 {
+		t.Fatalf("rating of Hysteria = %+v, %v, want 4 of 2", song, err)
+	}
+
+	// Saving again replaces the actor's review.
+	updated := saveReview(t, repo, models.Review{SongID: hysteria.ID, Actor: "user:alice", Rating: 2, Body: "Grew tired of it", Status: models.ReviewPending})
+	if updated.ID != alice.ID || updated.Rating != 2 || !updated.CreatedAt.Equal(alice.CreatedAt) {
+		t.Fatalf("SaveReview again = %+v, want review %d replaced", updated, alice.ID)
+	}
+	got, err := repo.GetReview(ctx, alice.ID)
+	if err != nil || got.Body != "Grew tired of it" || got.Actor != "user:alice" || got.Status != models.ReviewPending {
+		t.Fatalf("GetReview = %+v, %v", got, err)
+	}
+	if _, err := repo.GetReview(ctx, alice.ID+100); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetReview(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	if n, err := repo.SetReviewStatus(ctx, carol.ID, models.ReviewRejected); err != nil || n != 1 {
+		t.Fatalf("SetReviewStatus = %d, %v", n, err)
+	}
+	if err := repo.RefreshSongRating(ctx, hysteria.ID); err != nil {
+		t.Fatalf("RefreshSongRating: %v", err)
+	}
+	if song, err := repo.GetSong(ctx, hysteria.ID); err != nil || song.Rating != 4 || song.RatingCount != 1 {
+		t.Fatalf("rating of Hysteria without the rejected review = %+v, %v, want 4 of 1", song, err)
+	}
+
+	reviews, err := repo.GetReviews(ctx, models.ReviewFilter{SongID: hysteria.ID}, 10, 0)
+	if err != nil {
+		t.Fatalf("GetReviews: %v", err)
+	}
+	if len(reviews) != 3 || reviews[0].ID != alice.ID {
+		t.Fatalf("GetReviews(song) = %v, want 3 with the updated one first", reviewIDs(reviews))
+	}
+	if reviews, err := repo.GetReviews(ctx, models.ReviewFilter{SongID: hysteria.ID, Status: models.ReviewApproved}, 10, 0); err != nil || len(reviews) != 1 || reviews[0].Actor != "user:bob" {
+		t.Fatalf("GetReviews(approved) = %+v, %v", reviews, err)
+	}
+	if reviews, err := repo.GetReviews(ctx, models.ReviewFilter{Actor: "user:alice"}, 1, 1); err != nil || len(reviews) != 1 || reviews[0].SongID != uprising.ID {
+		t.Fatalf("GetReviews(actor, page 2) = %+v, %v", reviews, err)
+	}
+
+	if n, err := repo.SetReviewStatus(ctx, alice.ID, models.ReviewApproved); err != nil || n != 1 {
+		t.Fatalf("SetReviewStatus = %d, %v", n, err)
+	}
+	if err := repo.RefreshSongRating(ctx, hysteria.ID); err != nil {
+		t.Fatalf("RefreshSongRating: %v", err)
+	}
+	if song, err := repo.GetSong(ctx, hysteria.ID); err != nil || song.Rating != 3 || song.RatingCount != 2 {
+		t.Fatalf("rating of Hysteria with the approved review = %+v, %v, want 3 of 2", song, err)
+	}
+
+	byRating, err := repo.GetData(ctx, nil, "-rating", 10, 0)
+	if err != nil {
+		t.Fatalf("GetData(-rating): %v", err)
+	}
+	// Equal ratings are ordered by the number of ratings.
+	if got, want := songIDs(byRating), []int{hysteria.ID, uprising.ID, innuendo.ID}; !equalIDs(got, want) {
+		t.Fatalf("GetData(-rating) = %v, want %v", got, want)
+	}
+	if rated, err := repo.GetData(ctx, map[string]string{"min_rating": "3", "group_name": "muse"}, "rating", 10, 0); err != nil || !equalIDs(songIDs(rated), []int{uprising.ID, hysteria.ID}) {
+		t.Fatalf("GetData(min_rating 3) = %v, %v", songIDs(rated), err)
+	}
+	if rated, err := repo.GetData(ctx, map[string]string{"min_rating": "3.5"}, "", 10, 0); err != nil || len(rated) != 0 {
+		t.Fatalf("GetData(min_rating 3.5) = %v, %v, want none", songIDs(rated), err)
+	}
+	if _, err := repo.GetData(ctx, map[string]string{"min_rating": "high"}, "", 10, 0); err == nil {
+		t.Fatal("GetData with an invalid min_rating succeeded")
+	}
+
+	if n, err := repo.DeleteReview(ctx, "user:bob", hysteria.ID); err != nil || n != 1 {
+		t.Fatalf("DeleteReview = %d, %v", n, err)
+	}
+	if n, err := repo.DeleteReview(ctx, "user:bob", hysteria.ID); err != nil || n != 0 {
+		t.Fatalf("DeleteReview again = %d, %v, want 0", n, err)
+	}
+	if err := repo.RefreshSongRating(ctx, hysteria.ID); err != nil {
+		t.Fatalf("RefreshSongRating: %v", err)
+	}
+	if song, err := repo.GetSong(ctx, hysteria.ID); err != nil || song.Rating != 2 || song.RatingCount != 1 {
+		t.Fatalf("rating of Hysteria after deletion = %+v, %v, want 2 of 1", song, err)
+	}
+
+	if _, err := repo.DeleteSong(ctx, uprising.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	if reviews, err := repo.GetReviews(ctx, models.ReviewFilter{Actor: "user:alice"}, 10, 0); err != nil || len(reviews) != 1 {
+		t.Fatalf("GetReviews after trashing = %+v, %v", reviews, err)
+	}
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	otherRepo := store.ForLibrary(other.ID)
+	if reviews, err := otherRepo.GetReviews(ctx, models.ReviewFilter{}, 10, 0); err != nil || len(reviews) != 0 {
+		t.Fatalf("GetReviews from other library = %+v, %v, want none", reviews, err)
+	}
+	if n, err := otherRepo.SetReviewStatus(ctx, alice.ID, models.ReviewApproved); err != nil || n != 0 {
+		t.Fatalf("SetReviewStatus from other library = %d, %v, want 0", n, err)
+	}
+}
+
+func addAnnotation(t *testing.T, repo Repository, annotation models.Annotation) models.Annotation {
+	t.Helper()
+
+	if err := repo.AddAnnotation(context.Background(), &annotation); err != nil {
+		t.Fatalf("AddAnnotation(%q): %v", annotation.Quote, err)
+	}
+	return annotation
+}
+
+func annotationIDs(annotations []models.Annotation) []int {
+	ids := []int{}
+	for _, a := range annotations {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func testAnnotations(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	song := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria", Text: "It's bugging me\nGrating me\n\nAnd twisting me around"})
+
+	chorus := addAnnotation(t, repo, models.Annotation{SongID: song.ID,
+		AnnotationAnchor: models.AnnotationAnchor{Verse: 1, Unit: models.AnchorChars, Start: 4, End: 11},
+		Quote:            "twisting", Body: "Wordplay", Author: "user:alice"})
+	opening := addAnnotation(t, repo, models.Annotation{SongID: song.ID,
+		AnnotationAnchor: models.AnnotationAnchor{Verse: 0, Unit: models.AnchorLines, Start: 0, End: 1},
+		Quote:            "It's bugging me", Body: "The opening line", Author: "user:bob"})
+	if chorus.ID == 0 || opening.ID <= chorus.ID || chorus.CreatedAt.IsZero() {
+		t.Fatalf("AddAnnotation did not fill in the annotations: %+v, %+v", chorus, opening)
+	}
+	if err := repo.AddAnnotation(ctx, &models.Annotation{SongID: song.ID + 100, AnnotationAnchor: models.AnnotationAnchor{Unit: models.AnchorLines, End: 1}, Quote: "x", Body: "x", Author: "user:alice"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("AddAnnotation(missing song) error = %v, want sql.ErrNoRows", err)
+	}
+
+	got, err := repo.GetAnnotation(ctx, chorus.ID)
+	if err != nil || got.AnnotationAnchor != chorus.AnnotationAnchor || got.Quote != "twisting" || got.Author != "user:alice" || got.Orphaned {
+		t.Fatalf("GetAnnotation = %+v, %v", got, err)
+	}
+	if _, err := repo.GetAnnotation(ctx, chorus.ID+100); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetAnnotation(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	// Annotations are listed in text order.
+	annotations, err := repo.GetAnnotations(ctx, song.ID)
+	if err != nil || !equalIDs(annotationIDs(annotations), []int{opening.ID, chorus.ID}) {
+		t.Fatalf("GetAnnotations = %v, %v", annotationIDs(annotations), err)
+	}
+
+	got.AnnotationAnchor = models.AnnotationAnchor{Verse: 0, Unit: models.AnchorLines, Start: 1, End: 2}
+	got.Quote, got.Body, got.Orphaned = "Grating me", "Moved", true
+	if n, err := repo.UpdateAnnotation(ctx, got); err != nil || n != 1 {
+		t.Fatalf("UpdateAnnotation = %d, %v", n, err)
+	}
+	if updated, err := repo.GetAnnotation(ctx, chorus.ID); err != nil || updated.Start != 1 || updated.Unit != models.AnchorLines ||
+		updated.Quote != "Grating me" || updated.Body != "Moved" || !updated.Orphaned {
+		t.Fatalf("after update GetAnnotation = %+v, %v", updated, err)
+	}
+	if n, err := repo.UpdateAnnotation(ctx, &models.Annotation{ID: chorus.ID + 100, AnnotationAnchor: got.AnnotationAnchor, Quote: "x", Body: "x"}); err != nil || n != 0 {
+		t.Fatalf("UpdateAnnotation(missing) = %d, %v, want 0", n, err)
+	}
+
+	for _, vote := range []struct {
+		actor string
+		value int
+		want  int
+	}{
+		{"user:alice", 1, 1},
+		{"user:bob", 1, 2},
+		{"user:alice", -1, 0},
+		{"user:alice", -1, 0},
+		{"user:bob", 0, -1},
+	} {
+		if err := repo.SetAnnotationVote(ctx, opening.ID, vote.actor, vote.value); err != nil {
+			t.Fatalf("SetAnnotationVote(%s, %d): %v", vote.actor, vote.value, err)
+		}
+		if got, err := repo.GetAnnotation(ctx, opening.ID); err != nil || got.Votes != vote.want {
+			t.Fatalf("votes after %s voted %d = %+v, %v, want %d", vote.actor, vote.value, got, err, vote.want)
+		}
+	}
+	if err := repo.SetAnnotationVote(ctx, opening.ID+100, "user:alice", 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("SetAnnotationVote(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	otherRepo := store.ForLibrary(other.ID)
+	if _, err := otherRepo.GetAnnotation(ctx, opening.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetAnnotation from other library error = %v, want sql.ErrNoRows", err)
+	}
+	if n, err := otherRepo.DeleteAnnotation(ctx, opening.ID); err != nil || n != 0 {
+		t.Fatalf("DeleteAnnotation from other library = %d, %v, want 0", n, err)
+	}
+
+	if n, err := repo.DeleteAnnotation(ctx, opening.ID); err != nil || n != 1 {
+		t.Fatalf("DeleteAnnotation = %d, %v", n, err)
+	}
+	if _, err := repo.DeleteSong(ctx, song.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	if annotations, err := repo.GetAnnotations(ctx, song.ID); err != nil || len(annotations) != 0 {
+		t.Fatalf("GetAnnotations of a trashed song = %v, %v, want none", annotationIDs(annotations), err)
+	}
+	if _, err := repo.PurgeDeletedSongs(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PurgeDeletedSongs: %v", err)
+	}
+}
+
+func tagCounts(tags []models.TagCount) string {
+	counts := make([]string, len(tags))
+	for i, tag := range tags {
+		counts[i] = fmt.Sprintf("%s=%d", tag, tag.Count)
+	}
+	return strings.Join(counts, " ")
+}
+
+func testTags(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	hysteria := addSong(t, repo, models.Song{Group: "Muse", Song: "Hysteria", ReleaseDate: "2003-12-01"})
+	uprising := addSong(t, repo, models.Song{Group: "Muse", Song: "Uprising", ReleaseDate: "2009-09-07"})
+	innuendo := addSong(t, repo, models.Song{Group: "Queen", Song: "Innuendo", ReleaseDate: "1991-01-14"})
+	untitled := addSong(t, repo, models.Song{Group: "Queen", Song: "Untitled"})
+
+	rock := models.Tag{Type: models.TagGenre, Name: "rock"}
+	dark := models.Tag{Type: models.TagMood, Name: "dark"}
+	english := models.Tag{Type: models.TagLanguage, Name: "en"}
+
+	if n, err := repo.TagSongs(ctx, []int{hysteria.ID, uprising.ID, innuendo.ID, innuendo.ID + 100}, []models.Tag{rock, english}); err != nil || n != 6 {
+		t.Fatalf("TagSongs = %d, %v, want 6", n, err)
+	}
+	if n, err := repo.TagSongs(ctx, []int{hysteria.ID, uprising.ID}, []models.Tag{rock, dark}); err != nil || n != 2 {
+		t.Fatalf("TagSongs again = %d, %v, want only the 2 new ones", n, err)
+	}
+
+	songTags, err := repo.GetSongTags(ctx, []int{hysteria.ID, untitled.ID, innuendo.ID + 100})
+	if err != nil {
+		t.Fatalf("GetSongTags: %v", err)
+	}
+	if got := fmt.Sprint(songTags[hysteria.ID]); got != "[genre:rock language:en mood:dark]" {
+		t.Errorf("tags of Hysteria = %s", got)
+	}
+	if tags, ok := songTags[untitled.ID]; !ok || len(tags) != 0 {
+		t.Errorf("tags of an untagged song = %v, %v, want an empty list", tags, ok)
+	}
+	if _, ok := songTags[innuendo.ID+100]; ok {
+		t.Error("GetSongTags returned a missing song")
+	}
+
+	for _, tt := range []struct {
+		filter map[string]string
+		want   []int
+	}{
+		{map[string]string{"tags": "genre:rock,mood:dark"}, []int{hysteria.ID, uprising.ID}},
+		{map[string]string{"tags": "Genre:Rock, genre:rock"}, []int{hysteria.ID, uprising.ID, innuendo.ID}},
+		{map[string]string{"tags": "mood:dark,era:80s", "tag_mode": "any"}, []int{hysteria.ID, uprising.ID}},
+		{map[string]string{"tags": "mood:dark,era:80s"}, []int{}},
+		{map[string]string{"tags": "genre:rock", "group_name": "queen"}, []int{innuendo.ID}},
+	} {
+		songs, err := repo.GetData(ctx, tt.filter, "", 10, 0)
+		if err != nil {
+			t.Fatalf("GetData(%v): %v", tt.filter, err)
+		}
+		if got := songIDs(songs); !equalIDs(got, tt.want) {
+			t.Errorf("GetData(%v) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+	for _, filter := range []map[string]string{
+		{"tags": "rock"},
+		{"tags": "colour:red"},
+		{"tags": "genre:rock", "tag_mode": "some"},
+	} {
+		if _, err := repo.GetData(ctx, filter, "", 10, 0); err == nil {
+			t.Errorf("GetData(%v) succeeded", filter)
+		}
+	}
+
+	facets, err := repo.GetSongFacets(ctx, map[string]string{}, 10)
+	if err != nil {
+		t.Fatalf("GetSongFacets: %v", err)
+	}
+	if got := tagCounts(facets.Tags); got != "genre:rock=3 language:en=3 mood:dark=2" {
+		t.Errorf("tag facets = %s", got)
+	}
+	if got := fmt.Sprint(facets.Years); got != "[{1991 1} {2003 1} {2009 1}]" {
+		t.Errorf("year facets = %s", got)
+	}
+	if got := fmt.Sprint(facets.Groups); got != "[{Muse 2} {Queen 2}]" {
+		t.Errorf("group facets = %s", got)
+	}
+	facets, err = repo.GetSongFacets(ctx, map[string]string{"tags": "mood:dark"}, 10)
+	if err != nil || tagCounts(facets.Tags) != "genre:rock=2 language:en=2 mood:dark=2" || fmt.Sprint(facets.Groups) != "[{Muse 2}]" {
+		t.Errorf("facets of dark songs = %+v, %v", facets, err)
+	}
+	if facets, err := repo.GetSongFacets(ctx, map[string]string{"group_name": "queen"}, 1); err != nil || len(facets.Groups) != 1 || len(facets.Years) != 1 {
+		t.Errorf("facets of Queen limited to 1 group = %+v, %v", facets, err)
+	}
+
+	if n, err := repo.UntagSongs(ctx, []int{hysteria.ID, innuendo.ID}, []models.Tag{dark, {Type: models.TagEra, Name: "80s"}}); err != nil || n != 1 {
+		t.Fatalf("UntagSongs = %d, %v, want 1", n, err)
+	}
+	if _, err := repo.DeleteSong(ctx, uprising.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	tags, err := repo.GetTags(ctx, "")
+	if err != nil {
+		t.Fatalf("GetTags: %v", err)
+	}
+	// Tags without songs are kept; trashed songs do not count.
+	if got := tagCounts(tags); got != "genre:rock=2 language:en=2 mood:dark=0" {
+		t.Errorf("GetTags = %s", got)
+	}
+	if tags, err := repo.GetTags(ctx, models.TagMood); err != nil || len(tags) != 1 {
+		t.Errorf("GetTags(mood) = %v, %v", tags, err)
+	}
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	otherRepo := store.ForLibrary(other.ID)
+	if n, err := otherRepo.TagSongs(ctx, []int{hysteria.ID}, []models.Tag{dark}); err != nil || n != 0 {
+		t.Fatalf("TagSongs from other library = %d, %v, want 0", n, err)
+	}
+	if tags, err := otherRepo.GetTags(ctx, ""); err != nil || len(tags) != 1 || tags[0].Count != 0 {
+		t.Errorf("GetTags of other library = %v, %v, want its own unused tag", tags, err)
+	}
+	if songTags, err := otherRepo.GetSongTags(ctx, []int{hysteria.ID}); err != nil || len(songTags) != 0 {
+		t.Errorf("GetSongTags from other library = %v, %v", songTags, err)
+	}
+
+	if _, err := repo.PurgeDeletedSongs(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PurgeDeletedSongs: %v", err)
+	}
+}
+
+func testTranslations(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	song := addSong(t, repo, models.Song{Group: "Кино", Song: "Группа крови", Text: "Тёплое место\n\nГруппа крови"})
+
+	en := &models.Translation{SongID: song.ID, Language: "en", Text: "A warm place\n\nBlood type", Translator: "Joanna Stingray",
+		Alignment: []int{0, 1}, CreatedBy: "user:alice"}
+	if err := repo.SaveTranslation(ctx, en); err != nil || en.ID == 0 || en.CreatedAt.IsZero() {
+		t.Fatalf("SaveTranslation = %+v, %v", en, err)
+	}
+	de := &models.Translation{SongID: song.ID, Language: "de", Text: "Blutgruppe", Translator: "Anna", Alignment: []int{1}, CreatedBy: "user:bob"}
+	if err := repo.SaveTranslation(ctx, de); err != nil {
+		t.Fatalf("SaveTranslation(de): %v", err)
+	}
+	if err := repo.SaveTranslation(ctx, &models.Translation{SongID: song.ID + 100, Language: "en", Text: "x", Translator: "x", Alignment: []int{0}}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("SaveTranslation(missing song) error = %v, want sql.ErrNoRows", err)
+	}
+
+	// Saving the same language again replaces the translation but keeps
+	// its ID and author.
+	replaced := &models.Translation{SongID: song.ID, Language: "en", Text: "Warmth\n\nBlood type", Translator: "Anon",
+		Alignment: []int{0, 1}, CreatedBy: "user:bob"}
+	if err := repo.SaveTranslation(ctx, replaced); err != nil || replaced.ID != en.ID || replaced.CreatedBy != "user:alice" {
+		t.Fatalf("SaveTranslation(replace) = %+v, %v", replaced, err)
+	}
+
+	got, err := repo.GetTranslation(ctx, song.ID, "en")
+	if err != nil || got.Text != "Warmth\n\nBlood type" || got.Translator != "Anon" || !reflect.DeepEqual(got.Alignment, []int{0, 1}) {
+		t.Fatalf("GetTranslation = %+v, %v", got, err)
+	}
+	if _, err := repo.GetTranslation(ctx, song.ID, "fr"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetTranslation(missing) error = %v, want sql.ErrNoRows", err)
+	}
+
+	translations, err := repo.GetTranslations(ctx, song.ID)
+	if err != nil || len(translations) != 2 || translations[0].Language != "de" || translations[1].Language != "en" {
+		t.Fatalf("GetTranslations = %+v, %v", translations, err)
+	}
+
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	otherRepo := store.ForLibrary(other.ID)
+	if _, err := otherRepo.GetTranslation(ctx, song.ID, "en"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetTranslation from other library error = %v, want sql.ErrNoRows", err)
+	}
+	if n, err := otherRepo.DeleteTranslation(ctx, song.ID, "en"); err != nil || n != 0 {
+		t.Fatalf("DeleteTranslation from other library = %d, %v, want 0", n, err)
+	}
+
+	if n, err := repo.DeleteTranslation(ctx, song.ID, "de"); err != nil || n != 1 {
+		t.Fatalf("DeleteTranslation = %d, %v", n, err)
+	}
+	if n, err := repo.DeleteTranslation(ctx, song.ID, "de"); err != nil || n != 0 {
+		t.Fatalf("DeleteTranslation again = %d, %v, want 0", n, err)
+	}
+
+	if _, err := repo.DeleteSong(ctx, song.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+	if translations, err := repo.GetTranslations(ctx, song.ID); err != nil || len(translations) != 0 {
+		t.Fatalf("GetTranslations of a trashed song = %+v, %v, want none", translations, err)
+	}
+	if _, err := repo.PurgeDeletedSongs(ctx, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("PurgeDeletedSongs: %v", err)
+	}
+}
+
+func testLanguage(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	kino := addSong(t, repo, models.Song{Group: "Кино", Song: "Группа крови", Text: "Тёплое место, но улицы ждут отпечатков наших ног"})
+	if kino.Language != "ru" || kino.LanguageConfidence <= 0 {
+		t.Fatalf("AddNewSong language = %q %v, want ru", kino.Language, kino.LanguageConfidence)
+	}
+	muse := addSong(t, repo, models.Song{Group: "Muse", Song: "Starlight", Text: "Far away, this ship is taking me far away from the memories of the people who care if I live or die"})
+	latin := addSong(t, repo, models.Song{Group: "Kino", Song: "Zvezda", Text: "Zvezda po imeni solntse"})
+	empty := addSong(t, repo, models.Song{Group: "Silence", Song: "Nothing"})
+	if empty.Language != "" || empty.LanguageConfidence != 0 {
+		t.Fatalf("AddNewSong without text language = %q %v, want none", empty.Language, empty.LanguageConfidence)
+	}
+
+	got, err := repo.GetSong(ctx, kino.ID)
+	if err != nil || got.Language != "ru" || got.LanguageConfidence != kino.LanguageConfidence {
+		t.Fatalf("GetSong = %+v, %v", got, err)
+	}
+	// Songs written since languages are detected need no backfill.
+	if detected, err := repo.DetectSongLanguages(ctx); err != nil || len(detected) != 0 {
+		t.Fatalf("DetectSongLanguages = %+v, %v, want none", detected, err)
+	}
+
+	filters := []struct {
+		filter map[string]string
+		want   []int
+	}{
+		{map[string]string{"language": "ru"}, []int{kino.ID}},
+		{map[string]string{"language": "en"}, []int{muse.ID}},
+		{map[string]string{"min_language_confidence": "0.01"}, []int{kino.ID, muse.ID}},
+		{map[string]string{"min_language_confidence": "1"}, []int{}},
+		// The text filter matches the lyrics in either script.
+		{map[string]string{"text": "%ulitsy zhdut%"}, []int{kino.ID}},
+		{map[string]string{"text": "%ТЁПЛОЕ%"}, []int{kino.ID}},
+		{map[string]string{"text": "%звезда по%"}, []int{latin.ID}},
+		{map[string]string{"text": "%ship%"}, []int{muse.ID}},
+	}
+	for _, f := range filters {
+		songs, err := repo.GetData(ctx, f.filter, "", 10, 0)
+		if err != nil || !equalIDs(songIDs(songs), f.want) {
+			t.Errorf("GetData(%v) = %v, %v, want %v", f.filter, songIDs(songs), err, f.want)
+		}
+	}
+	if _, err := repo.GetData(ctx, map[string]string{"min_language_confidence": "2"}, "", 10, 0); err == nil {
+		t.Errorf("GetData(min_language_confidence=2) succeeded, want an error")
+	}
+
+	// Changing the text detects the language again; other changes keep it.
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: kino.ID, Text: "Blood type on my sleeve, and my number on the sleeve", UpdatedBy: "user:alice"}); err != nil {
+		t.Fatalf("UpdateSongData: %v", err)
+	}
+	if got, err := repo.GetSong(ctx, kino.ID); err != nil || got.Language != "en" {
+		t.Fatalf("GetSong after text update = %+v, %v, want en", got, err)
+	}
+	if songs, err := repo.GetData(ctx, map[string]string{"text": "%ulitsy%"}, "", 10, 0); err != nil || len(songs) != 0 {
+		t.Fatalf("GetData(old latin text) = %v, %v, want none", songIDs(songs), err)
+	}
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: muse.ID, Link: "https://example.com", UpdatedBy: "user:alice"}); err != nil {
+		t.Fatalf("UpdateSongData: %v", err)
+	}
+	if got, err := repo.GetSong(ctx, muse.ID); err != nil || got.Language != "en" {
+		t.Fatalf("GetSong after link update = %+v, %v, want en", got, err)
+	}
+
+	library, err := store.GetLibraryBySlug(ctx, "default")
+	if err != nil {
+		t.Fatalf("default library: %v", err)
+	}
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	if _, err := store.CopySongs(ctx, library.ID, other.ID, []int{latin.ID}, "api_key:9"); err != nil {
+		t.Fatalf("CopySongs: %v", err)
+	}
+	copied, err := store.ForLibrary(other.ID).GetData(ctx, map[string]string{"text": "%звезда%"}, "", 10, 0)
+	if err != nil || len(copied) != 1 || copied[0].Language != latin.Language {
+		t.Fatalf("copied songs = %+v, %v", copied, err)
+	}
+}
+
+func testExplicit(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	// Writes carry the wordlist's verdict in Explicit.
+	flagged := addSong(t, repo, models.Song{Group: "Rage", Song: "Killing", Text: "Flagged words", Explicit: true})
+	clean := addSong(t, repo, models.Song{Group: "Beatles", Song: "Yesterday", Text: "All my troubles"})
+	yes := true
+	forced := addSong(t, repo, models.Song{Group: "Nirvana", Song: "Polly", Text: "Polly wants a cracker", ExplicitOverride: &yes})
+	if !flagged.Explicit || clean.Explicit || !forced.Explicit {
+		t.Fatalf("AddNewSong explicit = %v %v %v, want true false true", flagged.Explicit, clean.Explicit, forced.Explicit)
+	}
+
+	got, err := repo.GetSong(ctx, forced.ID)
+	if err != nil || !got.Explicit || got.ExplicitOverride == nil || !*got.ExplicitOverride {
+		t.Fatalf("GetSong = %+v, %v", got, err)
+	}
+	if got, err := repo.GetSong(ctx, flagged.ID); err != nil || !got.Explicit || got.ExplicitOverride != nil {
+		t.Fatalf("GetSong = %+v, %v", got, err)
+	}
+
+	explicitIDs := func(value string) []int {
+		t.Helper()
+		songs, err := repo.GetData(ctx, map[string]string{"explicit": value}, "", 10, 0)
+		if err != nil {
+			t.Fatalf("GetData(explicit=%s): %v", value, err)
+		}
+		return songIDs(songs)
+	}
+	if ids := explicitIDs("false"); !equalIDs(ids, []int{clean.ID}) {
+		t.Fatalf("GetData(explicit=false) = %v, want %v", ids, []int{clean.ID})
+	}
+	if ids := explicitIDs("true"); !equalIDs(ids, []int{flagged.ID, forced.ID}) {
+		t.Fatalf("GetData(explicit=true) = %v, want %v", ids, []int{flagged.ID, forced.ID})
+	}
+	if _, err := repo.GetData(ctx, map[string]string{"explicit": "maybe"}, "", 10, 0); err == nil {
+		t.Errorf("GetData(explicit=maybe) succeeded, want an error")
+	}
+
+	// The override wins over the wordlist until it is dropped.
+	no := false
+	if n, err := repo.SetExplicitOverride(ctx, flagged.ID, &no); err != nil || n != 1 {
+		t.Fatalf("SetExplicitOverride = %d, %v", n, err)
+	}
+	if ids := explicitIDs("false"); !equalIDs(ids, []int{flagged.ID, clean.ID}) {
+		t.Fatalf("GetData(explicit=false) after override = %v", ids)
+	}
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: flagged.ID, Text: "Other flagged words", Explicit: true, UpdatedBy: "user:alice"}); err != nil {
+		t.Fatalf("UpdateSongData: %v", err)
+	}
+	if got, err := repo.GetSong(ctx, flagged.ID); err != nil || got.Explicit {
+		t.Fatalf("GetSong after text update = %+v, %v, want the override kept", got, err)
+	}
+	if n, err := repo.SetExplicitOverride(ctx, flagged.ID, nil); err != nil || n != 1 {
+		t.Fatalf("SetExplicitOverride(nil) = %d, %v", n, err)
+	}
+	if got, err := repo.GetSong(ctx, flagged.ID); err != nil || !got.Explicit || got.ExplicitOverride != nil {
+		t.Fatalf("GetSong after dropping the override = %+v, %v", got, err)
+	}
+	if n, err := repo.SetExplicitOverride(ctx, 9999, &yes); err != nil || n != 0 {
+		t.Fatalf("SetExplicitOverride(missing) = %d, %v, want 0", n, err)
+	}
+
+	// Updates that leave the text alone keep the verdict.
+	if err := repo.UpdateSongData(ctx, &models.Song{ID: flagged.ID, Link: "https://example.com", UpdatedBy: "user:alice"}); err != nil {
+		t.Fatalf("UpdateSongData: %v", err)
+	}
+	if got, err := repo.GetSong(ctx, flagged.ID); err != nil || !got.Explicit {
+		t.Fatalf("GetSong after link update = %+v, %v, want explicit", got, err)
+	}
+
+	library, err := store.GetLibraryBySlug(ctx, "default")
+	if err != nil {
+		t.Fatalf("default library: %v", err)
+	}
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	if _, err := store.CopySongs(ctx, library.ID, other.ID, []int{flagged.ID, forced.ID}, "api_key:9"); err != nil {
+		t.Fatalf("CopySongs: %v", err)
+	}
+	copied, err := store.ForLibrary(other.ID).GetData(ctx, map[string]string{"explicit": "true"}, "", 10, 0)
+	if err != nil || len(copied) != 2 || copied[0].ExplicitOverride != nil || copied[1].ExplicitOverride == nil {
+		t.Fatalf("copied songs = %+v, %v", copied, err)
+	}
+}
+
+func testWithoutExplicit(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	explicit := addSong(t, repo, models.Song{Group: "Rage", Song: "Killing", Text: "Flagged words", Explicit: true})
+	no := false
+	cleaned := addSong(t, repo, models.Song{Group: "Rage", Song: "Bombtrack", Text: "Flagged words", Explicit: true, ExplicitOverride: &no})
+	plain := addSong(t, repo, models.Song{Group: "Beatles", Song: "Yesterday", Text: "All my troubles"})
+	playlist := addPlaylist(t, repo, models.Playlist{Name: "Mix", Owner: "api_key:alice", Visibility: models.PlaylistPublic})
+	rock := models.Tag{Type: models.TagGenre, Name: "rock"}
+	if _, err := repo.TagSongs(ctx, []int{explicit.ID, cleaned.ID, plain.ID}, []models.Tag{rock}); err != nil {
+		t.Fatalf("TagSongs: %v", err)
+	}
+
+	var review models.Review
+	var annotation models.Annotation
+	for _, s := range []models.Song{explicit, cleaned, plain} {
+		if err := repo.AddPlaylistEntry(ctx, &models.PlaylistEntry{SongID: s.ID, AddedBy: "api_key:alice"}, playlist.ID); err != nil {
+			t.Fatalf("AddPlaylistEntry: %v", err)
+		}
+		if err := repo.AddFavorite(ctx, "user:alice", s.ID); err != nil {
+			t.Fatalf("AddFavorite: %v", err)
+		}
+		if err := repo.AddPlay(ctx, &models.Play{SongID: s.ID}, "user:alice"); err != nil {
+			t.Fatalf("AddPlay: %v", err)
+		}
+		r := models.Review{SongID: s.ID, Actor: "user:alice", Rating: 4, Status: models.ReviewApproved}
+		if err := repo.SaveReview(ctx, &r); err != nil {
+			t.Fatalf("SaveReview: %v", err)
+		}
+		a := addAnnotation(t, repo, models.Annotation{SongID: s.ID,
+			AnnotationAnchor: models.AnnotationAnchor{Unit: models.AnchorLines, End: 1}, Quote: s.Text, Body: "Note", Author: "user:alice"})
+		if err := repo.SaveTranslation(ctx, &models.Translation{SongID: s.ID, Language: "de", Text: "Text", Translator: "Anna", Alignment: []int{0}}); err != nil {
+			t.Fatalf("SaveTranslation: %v", err)
+		}
+		if s.ID == explicit.ID {
+			review, annotation = r, a
+		}
+	}
+
+	clean := repo.WithoutExplicit()
+	want := []int{cleaned.ID, plain.ID}
+
+	songs, err := clean.GetData(ctx, nil, "", 10, 0)
+	if err != nil || !equalIDs(songIDs(songs), want) {
+		t.Fatalf("GetData = %v, %v, want %v", songIDs(songs), err, want)
+	}
+	if songs, err := clean.GetData(ctx, map[string]string{"explicit": "true"}, "", 10, 0); err != nil || len(songs) != 0 {
+		t.Fatalf("GetData(explicit=true) = %v, %v, want none", songIDs(songs), err)
+	}
+	if _, err := clean.GetSong(ctx, explicit.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetSong(explicit) error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := clean.GetSongPagi(ctx, explicit.ID, 10, 0); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetSongPagi(explicit) error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := clean.GetSong(ctx, cleaned.ID); err != nil {
+		t.Fatalf("GetSong(cleaned by hand): %v", err)
+	}
+	if _, err := repo.GetSong(ctx, explicit.ID); err != nil {
+		t.Fatalf("GetSong(explicit) without clean mode: %v", err)
+	}
+
+	if entries, err := clean.GetPlaylistEntries(ctx, playlist.ID); err != nil || len(entries) != 2 || entries[0].SongID == explicit.ID || entries[1].SongID == explicit.ID {
+		t.Fatalf("GetPlaylistEntries = %+v, %v, want the entries of songs %v", entries, err, want)
+	}
+	if err := clean.AddPlaylistEntry(ctx, &models.PlaylistEntry{SongID: explicit.ID}, playlist.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("AddPlaylistEntry(explicit) error = %v, want sql.ErrNoRows", err)
+	}
+	if favorites, err := clean.GetFavorites(ctx, "user:alice", 10, 0); err != nil || !equalIDs(songIDs(favorites), []int{plain.ID, cleaned.ID}) {
+		t.Fatalf("GetFavorites = %v, %v, want %v", songIDs(favorites), err, []int{plain.ID, cleaned.ID})
+	}
+	if plays, err := clean.GetPlays(ctx, "user:alice", 10, 0); err != nil || len(plays) != 2 || plays[0].SongID == explicit.ID || plays[1].SongID == explicit.ID {
+		t.Fatalf("GetPlays = %+v, %v, want the plays of songs %v", plays, err, want)
+	}
+	if top, err := clean.GetTopSongs(ctx, "user:alice", time.Time{}, 10); err != nil || len(top) != 2 {
+		t.Fatalf("GetTopSongs = %+v, %v, want 2 songs", top, err)
+	}
+	if top, err := clean.GetTopGroups(ctx, "user:alice", time.Time{}, 10); err != nil || len(top) != 2 || top[0].Plays != 1 || top[1].Plays != 1 {
+		t.Fatalf("GetTopGroups = %+v, %v, want one play of each group", top, err)
+	}
+
+	if _, err := clean.GetReview(ctx, review.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetReview(explicit) error = %v, want sql.ErrNoRows", err)
+	}
+	if reviews, err := clean.GetReviews(ctx, models.ReviewFilter{}, 10, 0); err != nil || len(reviews) != 2 {
+		t.Fatalf("GetReviews = %+v, %v, want 2 reviews", reviews, err)
+	}
+	if _, err := clean.GetAnnotation(ctx, annotation.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetAnnotation(explicit) error = %v, want sql.ErrNoRows", err)
+	}
+	if annotations, err := clean.GetAnnotations(ctx, explicit.ID); err != nil || len(annotations) != 0 {
+		t.Fatalf("GetAnnotations = %+v, %v, want none", annotations, err)
+	}
+	if _, err := clean.GetTranslation(ctx, explicit.ID, "de"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetTranslation(explicit) error = %v, want sql.ErrNoRows", err)
+	}
+	if translations, err := clean.GetTranslations(ctx, explicit.ID); err != nil || len(translations) != 0 {
+		t.Fatalf("GetTranslations = %+v, %v, want none", translations, err)
+	}
+
+	if tags, err := clean.GetTags(ctx, ""); err != nil || len(tags) != 1 || tags[0].Count != 2 {
+		t.Fatalf("GetTags = %+v, %v, want rock counted twice", tags, err)
+	}
+	if songTags, err := clean.GetSongTags(ctx, []int{explicit.ID, plain.ID}); err != nil || len(songTags) != 1 || songTags[plain.ID] == nil {
+		t.Fatalf("GetSongTags = %+v, %v, want only song %d", songTags, err, plain.ID)
+	}
+	if facets, err := clean.GetSongFacets(ctx, nil, 10); err != nil || len(facets.Groups) != 2 || facets.Groups[0].Count != 1 {
+		t.Fatalf("GetSongFacets = %+v, %v", facets, err)
+	}
+
+	// Transactions and library scopes keep hiding them.
+	err = clean.WithTx(ctx, func(tx Repository) error {
+		if _, err := tx.GetSong(ctx, explicit.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetSong(explicit) in a transaction error = %v, want sql.ErrNoRows", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	library, err := store.GetLibraryBySlug(ctx, "default")
+	if err != nil {
+		t.Fatalf("default library: %v", err)
+	}
+	if _, err := clean.ForLibrary(library.ID).GetSong(ctx, explicit.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetSong(explicit) after ForLibrary error = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testDetectExplicit(t *testing.T, store Store) {
+	ctx := context.Background()
+	repo := defaultRepo(t, store)
+
+	// Songs written before detection all start out clean; trashed ones are
+	// checked too.
+	curse := addSong(t, repo, models.Song{Group: "Rage", Song: "Killing", Text: "Curse words"})
+	no := false
+	kept := addSong(t, repo, models.Song{Group: "Rage", Song: "Bombtrack", Text: "Curse here", ExplicitOverride: &no})
+	trashed := addSong(t, repo, models.Song{Group: "Rage", Song: "Bulls", Text: "Curse there"})
+	plain := addSong(t, repo, models.Song{Group: "Beatles", Song: "Yesterday", Text: "All my troubles"})
+	if _, err := repo.DeleteSong(ctx, trashed.ID); err != nil {
+		t.Fatalf("DeleteSong: %v", err)
+	}
+
+	curses := func(text string) bool { return strings.Contains(text, "Curse") }
+	changed, err := repo.DetectExplicitSongs(ctx, "v1", curses)
+	if err != nil || !equalIDs(songIDs(changed), []int{curse.ID, kept.ID, trashed.ID}) {
+		t.Fatalf("DetectExplicitSongs = %+v, %v, want %v", changed, err, []int{curse.ID, kept.ID, trashed.ID})
+	}
+	for _, song := range changed {
+		if !song.Explicit {
+			t.Errorf("DetectExplicitSongs verdict for song %d = false, want true", song.ID)
+		}
+	}
+	if got, err := repo.GetSong(ctx, curse.ID); err != nil || !got.Explicit {
+		t.Fatalf("GetSong after detection = %+v, %v, want explicit", got, err)
+	}
+	if got, err := repo.GetSong(ctx, kept.ID); err != nil || got.Explicit {
+		t.Fatalf("GetSong with an override = %+v, %v, want the override kept", got, err)
+	}
+	if got, err := repo.GetSong(ctx, plain.ID); err != nil || got.Explicit {
+		t.Fatalf("GetSong without curses = %+v, %v", got, err)
+	}
+
+	// The same wordlist is not checked again, another one is.
+	troubles := func(text string) bool { return strings.Contains(text, "troubles") }
+	if changed, err := repo.DetectExplicitSongs(ctx, "v1", troubles); err != nil || len(changed) != 0 {
+		t.Fatalf("DetectExplicitSongs(same wordlist) = %+v, %v, want 0", changed, err)
+	}
+	if changed, err := repo.DetectExplicitSongs(ctx, "v2", troubles); err != nil || len(changed) != 4 {
+		t.Fatalf("DetectExplicitSongs(new wordlist) = %+v, %v, want 4", changed, err)
+	}
+	if songs, err := repo.GetData(ctx, map[string]string{"explicit": "true"}, "", 10, 0); err != nil || !equalIDs(songIDs(songs), []int{plain.ID}) {
+		t.Fatalf("explicit songs = %v, %v, want %v", songIDs(songs), err, []int{plain.ID})
+	}
+
+	library, err := store.GetLibraryBySlug(ctx, "default")
+	if err != nil {
+		t.Fatalf("default library: %v", err)
+	}
+	other := &models.Library{Slug: "other", Name: "Other"}
+	if err := store.AddLibrary(ctx, other); err != nil {
+		t.Fatalf("AddLibrary: %v", err)
+	}
+	if _, err := store.CopySongs(ctx, library.ID, other.ID, []int{curse.ID}, "api_key:9"); err != nil {
+		t.Fatalf("CopySongs: %v", err)
+	}
+	// Every library keeps the wordlist it was checked with.
+	if changed, err := store.ForLibrary(other.ID).DetectExplicitSongs(ctx, "v2", curses); err != nil || len(changed) != 1 {
+		t.Fatalf("DetectExplicitSongs(other library) = %+v, %v, want 1", changed, err)
+	}
+
+	// Translations are checked with the text.
+	if err := repo.SaveTranslation(ctx, &models.Translation{SongID: plain.ID, Language: "de", Text: "Curse", Translator: "Hans", Alignment: []int{0}}); err != nil {
+		t.Fatalf("SaveTranslation: %v", err)
+	}
+	if changed, err := repo.DetectExplicitSongs(ctx, "v3", curses); err != nil || len(changed) != 3 {
+		t.Fatalf("DetectExplicitSongs(translations) = %+v, %v, want 3", changed, err)
+	}
+	if got, err := repo.GetSong(ctx, plain.ID); err != nil || !got.Explicit {
+		t.Fatalf("GetSong with a flagged translation = %+v, %v, want explicit", got, err)
+	}
+
+	if n, err := repo.SetExplicitDetected(ctx, plain.ID, false); err != nil || n != 1 {
+		t.Fatalf("SetExplicitDetected = %d, %v", n, err)
+	}
+	if got, err := repo.GetSong(ctx, plain.ID); err != nil || got.Explicit {
+		t.Fatalf("GetSong after SetExplicitDetected = %+v, %v, want not explicit", got, err)
+	}
+	if n, err := repo.SetExplicitDetected(ctx, kept.ID, true); err != nil || n != 1 {
+		t.Fatalf("SetExplicitDetected(override) = %d, %v", n, err)
+	}
+	if got, err := repo.GetSong(ctx, kept.ID); err != nil || got.Explicit {
+		t.Fatalf("GetSong with an override after SetExplicitDetected = %+v, %v, want the override kept", got, err)
+	}
+	if n, err := store.ForLibrary(other.ID).SetExplicitDetected(ctx, plain.ID, true); err != nil || n != 0 {
+		t.Fatalf("SetExplicitDetected(other library) = %d, %v, want 0", n, err)
+	}
+	if _, err := store.DetectExplicitSongs(ctx, "v2", troubles); !errors.Is(err, ErrLibraryRequired) {
+		t.Fatalf("DetectExplicitSongs without a library error = %v, want ErrLibraryRequired", err)
+	}
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+func (r *ApiRepository) AddApiKey(ctx context.Context, key *models.ApiKey) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_api_key")
+	defer done()
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO api_keys (name, prefix, key_hash, scopes, library_id, clean_mode)
+		VALUES ($1, $2, $3, $4, (SELECT id FROM libraries WHERE slug = NULLIF($5, '')), $6)
+		RETURNING id, created_at`,
+		key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, " "), key.Library, key.CleanMode,
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		logger.Error("Error inserting api key: ", err)
+		return err
+	}
+
+	logger.Infof("Api key '%s' (%s) created", key.Name, key.Prefix)
+	return nil
+}
+
+func (r *ApiRepository) GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	ctx, done := r.trace(ctx, "get_api_key")
+	defer done()
+
+	var key models.ApiKey
+	var scopes string
+	err := r.db.QueryRowContext(ctx, `SELECT k.id, k.name, k.prefix, k.key_hash, k.scopes, COALESCE(l.slug, ''), k.clean_mode, k.created_at, k.revoked_at
+		FROM api_keys k LEFT JOIN libraries l ON l.id = k.library_id
+		WHERE k.key_hash = $1`, hash).Scan(
+		&key.ID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.Library, &key.CleanMode, &key.CreatedAt, &key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+
+	return &key, nil
+}
+
+func (r *ApiRepository) RevokeApiKey(ctx context.Context, id int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "revoke_api_key")
+	defer done()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		logger.Error("Error revoking api key: ", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		logger.Error("Error fetching rows affected: ", err)
+		return 0, err
+	}
+
+	if rowsAffected == 0 {
+		logger.Warnf("No active api key found with ID %d", id)
+	}
+
+	return rowsAffected, nil
+}
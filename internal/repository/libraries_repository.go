@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+func (r *ApiRepository) AddLibrary(ctx context.Context, library *models.Library) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_library")
+	defer done()
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO libraries (slug, name) VALUES ($1, $2) RETURNING id, created_at`,
+		library.Slug, library.Name,
+	).Scan(&library.ID, &library.CreatedAt)
+	if err != nil {
+		logger.Error("Error inserting library: ", err)
+		return err
+	}
+
+	logger.Infof("Library '%s' created", library.Slug)
+	return nil
+}
+
+func (r *ApiRepository) GetLibraryBySlug(ctx context.Context, slug string) (*models.Library, error) {
+	ctx, done := r.trace(ctx, "get_library")
+	defer done()
+
+	var library models.Library
+	err := r.db.QueryRowContext(ctx, `SELECT id, slug, name, created_at FROM libraries WHERE slug = $1`, slug).Scan(
+		&library.ID, &library.Slug, &library.Name, &library.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &library, nil
+}
+
+func (r *ApiRepository) GetLibraries(ctx context.Context) ([]models.Library, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_libraries")
+	defer done()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT id, slug, name, created_at FROM libraries ORDER BY slug`)
+	if err != nil {
+		logger.Error("Error executing GetLibraries query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var libraries []models.Library
+	for rows.Next() {
+		var library models.Library
+		if err := rows.Scan(&library.ID, &library.Slug, &library.Name, &library.CreatedAt); err != nil {
+			logger.Error("Error scanning GetLibraries rows: ", err)
+			return nil, err
+		}
+		libraries = append(libraries, library)
+	}
+
+	return libraries, rows.Err()
+}
+
+// CopySongs duplicates songs of one library into another, created and last
+// updated by actor. When songIDs is empty the whole library is copied.
+func (r *ApiRepository) CopySongs(ctx context.Context, fromID, toID int, songIDs []int, actor string) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "copy_songs")
+	defer done()
+
+	query := `INSERT INTO songs (library_id, group_name, song_name, release_date, text, link, created_by, updated_by, language, language_confidence, text_latin, explicit_detected, explicit_override)
+		SELECT $1, group_name, song_name, release_date, text, link, $3, $3, language, language_confidence, text_latin, explicit_detected, explicit_override
+		FROM songs WHERE library_id = $2 AND deleted_at IS NULL`
+	args := []interface{}{toID, fromID, actor}
+
+	if len(songIDs) > 0 {
+		placeholders := make([]string, len(songIDs))
+		for i, id := range songIDs {
+			placeholders[i] = fmt.Sprintf("$%d", i+4)
+			args = append(args, id)
+		}
+		query += " AND id IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	result, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		logger.Error("Error copying songs: ", err)
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		logger.Error("Error fetching rows affected: ", err)
+		return 0, err
+	}
+
+	logger.Infof("Copied %d songs from library %d to library %d", rowsAffected, fromID, toID)
+	return rowsAffected, nil
+}
+
+// GetSongStats counts songs per library, including those missing lyrics or a link.
+func (r *ApiRepository) GetSongStats(ctx context.Context) ([]models.SongStats, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_song_stats")
+	defer done()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT l.slug,
+			COUNT(s.id),
+			COUNT(s.id) FILTER (WHERE COALESCE(s.text, '') = ''),
+			COUNT(s.id) FILTER (WHERE COALESCE(s.link, '') = '')
+		FROM libraries l LEFT JOIN songs s ON s.library_id = l.id AND s.deleted_at IS NULL
+		GROUP BY l.slug`)
+	if err != nil {
+		logger.Error("Error executing GetSongStats query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.SongStats
+	for rows.Next() {
+		var s models.SongStats
+		if err := rows.Scan(&s.Library, &s.Total, &s.MissingText, &s.MissingLink); err != nil {
+			logger.Error("Error scanning GetSongStats rows: ", err)
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+func (r *ApiRepository) AddFavorite(ctx context.Context, actor string, songID int) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_favorite")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	// Selecting from song tells a missing song (no rows) apart from a song
+	// that is already a favorite.
+	var id int
+	err := r.db.QueryRowContext(ctx, `WITH song AS (
+			SELECT id FROM songs WHERE id = $2 AND library_id = $3 AND deleted_at IS NULL
+		), added AS (
+			INSERT INTO favorites (actor, song_id) SELECT $1, id FROM song ON CONFLICT DO NOTHING
+		)
+		SELECT id FROM song`, actor, songID, r.libraryID).Scan(&id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error inserting favorite: ", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) DeleteFavorite(ctx context.Context, actor string, songID int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_favorite")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM favorites
+		WHERE actor = $1 AND song_id = (SELECT id FROM songs WHERE id = $2 AND library_id = $3 AND deleted_at IS NULL)`,
+		actor, songID, r.libraryID,
+	)
+	if err != nil {
+		logger.Error("Error deleting favorite: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) GetFavorites(ctx context.Context, actor string, limit int, offset int) ([]models.Song, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_favorites")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, `SELECT s.id, s.group_name, s.song_name, COALESCE(s.release_date::text, ''), COALESCE(s.text, ''), COALESCE(s.link, ''),
+			COALESCE(s.created_by, ''), COALESCE(s.updated_by, ''), s.play_count, s.rating, s.rating_count, s.language, s.language_confidence, COALESCE(s.explicit_override, s.explicit_detected), s.explicit_override
+		FROM favorites f JOIN songs s ON s.id = f.song_id
+		WHERE f.actor = $1 AND s.library_id = $2 AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		ORDER BY f.created_at DESC, s.id DESC LIMIT $3 OFFSET $4`, actor, r.libraryID, limit, offset)
+	if err != nil {
+		logger.Error("Error executing GetFavorites query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var songs []models.Song
+	for rows.Next() {
+		var song models.Song
+		if err := rows.Scan(&song.ID, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link, &song.CreatedBy, &song.UpdatedBy, &song.PlayCount, &song.Rating, &song.RatingCount, &song.Language, &song.LanguageConfidence, &song.Explicit, &song.ExplicitOverride); err != nil {
+			logger.Error("Error scanning GetFavorites rows: ", err)
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+
+	return songs, rows.Err()
+}
+
+func (r *ApiRepository) AddPlay(ctx context.Context, play *models.Play, actor string) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_play")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	err := r.db.QueryRowContext(ctx, `WITH song AS (
+			UPDATE songs SET play_count = play_count + 1
+			WHERE id = $1 AND library_id = $2 AND deleted_at IS NULL
+			RETURNING id, group_name, song_name
+		), play AS (
+			INSERT INTO plays (song_id, actor) SELECT id, $3 FROM song RETURNING id, played_at
+		)
+		SELECT play.id, play.played_at, song.group_name, song.song_name FROM play, song`,
+		play.SongID, r.libraryID, actor,
+	).Scan(&play.ID, &play.PlayedAt, &play.Group, &play.Song)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error inserting play: ", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) GetPlays(ctx context.Context, actor string, limit int, offset int) ([]models.Play, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_plays")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, `SELECT p.id, p.song_id, s.group_name, s.song_name, p.played_at
+		FROM plays p JOIN songs s ON s.id = p.song_id
+		WHERE p.actor = $1 AND s.library_id = $2 AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		ORDER BY p.played_at DESC, p.id DESC LIMIT $3 OFFSET $4`, actor, r.libraryID, limit, offset)
+	if err != nil {
+		logger.Error("Error executing GetPlays query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plays []models.Play
+	for rows.Next() {
+		var play models.Play
+		if err := rows.Scan(&play.ID, &play.SongID, &play.Group, &play.Song, &play.PlayedAt); err != nil {
+			logger.Error("Error scanning GetPlays rows: ", err)
+			return nil, err
+		}
+		plays = append(plays, play)
+	}
+
+	return plays, rows.Err()
+}
+
+func (r *ApiRepository) GetTopSongs(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopSong, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_top_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, `SELECT s.id, s.group_name, s.song_name, COUNT(*) AS plays
+		FROM plays p JOIN songs s ON s.id = p.song_id
+		WHERE p.actor = $1 AND s.library_id = $2 AND s.deleted_at IS NULL AND p.played_at >= $3`+r.hideExplicit("s")+`
+		GROUP BY s.id, s.group_name, s.song_name
+		ORDER BY plays DESC, s.id LIMIT $4`, actor, r.libraryID, since, limit)
+	if err != nil {
+		logger.Error("Error executing GetTopSongs query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []models.TopSong
+	for rows.Next() {
+		var song models.TopSong
+		if err := rows.Scan(&song.SongID, &song.Group, &song.Song, &song.Plays); err != nil {
+			logger.Error("Error scanning GetTopSongs rows: ", err)
+			return nil, err
+		}
+		top = append(top, song)
+	}
+
+	return top, rows.Err()
+}
+
+func (r *ApiRepository) GetTopGroups(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopGroup, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_top_groups")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, `SELECT s.group_name, COUNT(*) AS plays
+		FROM plays p JOIN songs s ON s.id = p.song_id
+		WHERE p.actor = $1 AND s.library_id = $2 AND s.deleted_at IS NULL AND p.played_at >= $3`+r.hideExplicit("s")+`
+		GROUP BY s.group_name
+		ORDER BY plays DESC, s.group_name LIMIT $4`, actor, r.libraryID, since, limit)
+	if err != nil {
+		logger.Error("Error executing GetTopGroups query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []models.TopGroup
+	for rows.Next() {
+		var group models.TopGroup
+		if err := rows.Scan(&group.Group, &group.Plays); err != nil {
+			logger.Error("Error scanning GetTopGroups rows: ", err)
+			return nil, err
+		}
+		top = append(top, group)
+	}
+
+	return top, rows.Err()
+}
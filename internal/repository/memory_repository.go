@@ -0,0 +1,2132 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryRepository keeps everything in process memory. It behaves like
+// ApiRepository and is meant for local development and tests; data is lost
+// on restart. Transactions run one at a time on a copy of the data that
+// replaces it on commit.
+type MemoryRepository struct {
+	mem *memoryStore
+	// tx is the transaction's copy of the data, nil outside transactions.
+	tx        *memoryData
+	logger    *logrus.Logger
+	libraryID int
+	// clean is set on repositories returned by WithoutExplicit.
+	clean bool
+}
+
+type memoryStore struct {
+	mu   sync.Mutex
+	data *memoryData
+}
+
+type memoryData struct {
+	songs     []memorySong
+	libraries []models.Library
+	keys      []memoryKey
+	audit     []models.AuditEvent
+	playlists []memoryPlaylist
+	entries   []memoryEntry
+	users     []memoryUser
+	sessions  []models.Session
+	favorites []memoryFavorite
+	plays     []memoryPlay
+	reviews   []models.Review
+
+	annotations     []models.Annotation
+	annotationVotes []memoryVote
+	tags            []memoryTag
+	songTags        []memorySongTag
+	translations    []models.Translation
+	// explicitWordlists maps library IDs to the fingerprint of the wordlist
+	// their songs were last checked against.
+	explicitWordlists map[int]string
+
+	lastSongID     int
+	lastLibraryID  int
+	lastKeyID      int
+	lastAuditID    int64
+	lastPlaylistID int
+	lastEntryID    int
+	lastUserID     int
+	lastSessionID  int
+	lastPlayID     int64
+	lastReviewID   int
+
+	lastAnnotationID  int
+	lastTagID         int
+	lastTranslationID int
+}
+
+// memorySong keeps the wordlist verdict apart from Song.Explicit, which
+// holds the effective flag as the SQL stores compute it.
+type memorySong struct {
+	models.Song
+	libraryID        int
+	deletedAt        *time.Time
+	explicitDetected bool
+}
+
+func (s *memorySong) setExplicit(detected bool, override *bool) {
+	if override != nil {
+		value := *override
+		override = &value
+	}
+	s.explicitDetected, s.ExplicitOverride = detected, override
+	s.Explicit = explicitSong(&models.Song{Explicit: detected, ExplicitOverride: override})
+}
+
+type memoryPlaylist struct {
+	models.Playlist
+	libraryID int
+}
+
+type memoryEntry struct {
+	id         int
+	playlistID int
+	songID     int
+	position   int
+	addedBy    string
+	addedAt    time.Time
+}
+
+type memoryFavorite struct {
+	actor     string
+	songID    int
+	createdAt time.Time
+}
+
+type memoryPlay struct {
+	id       int64
+	songID   int
+	actor    string
+	playedAt time.Time
+}
+
+type memoryVote struct {
+	annotationID int
+	actor        string
+	value        int
+}
+
+type memoryTag struct {
+	models.Tag
+	id        int
+	libraryID int
+}
+
+type memorySongTag struct {
+	songID int
+	tagID  int
+}
+
+type memoryKey struct {
+	models.ApiKey
+	libraryID int
+}
+
+type memoryUser struct {
+	models.User
+	libraryID int
+}
+
+// NewMemoryRepository creates an empty store holding only the default
+// library, like a freshly migrated database.
+func NewMemoryRepository(logger *logrus.Logger) *MemoryRepository {
+	data := &memoryData{}
+	data.addLibrary(&models.Library{Slug: "default", Name: "Default library"})
+
+	return &MemoryRepository{
+		mem:    &memoryStore{data: data},
+		logger: logger,
+	}
+}
+
+func (r *MemoryRepository) ForLibrary(libraryID int) Repository {
+	return &MemoryRepository{
+		mem:       r.mem,
+		tx:        r.tx,
+		logger:    r.logger,
+		libraryID: libraryID,
+		clean:     r.clean,
+	}
+}
+
+func (r *MemoryRepository) WithoutExplicit() Repository {
+	clean := *r
+	clean.clean = true
+	return &clean
+}
+
+// visible reports whether s exists for the repository: clean repositories
+// do not see explicit songs.
+func (r *MemoryRepository) visible(s *memorySong) bool {
+	return s != nil && !(r.clean && s.Explicit)
+}
+
+// do runs fn on the data, locking it unless in a transaction, which holds
+// the lock already.
+func (r *MemoryRepository) do(fn func(data *memoryData) error) error {
+	if r.tx != nil {
+		return fn(r.tx)
+	}
+
+	r.mem.mu.Lock()
+	defer r.mem.mu.Unlock()
+	return fn(r.mem.data)
+}
+
+// doSongs is do for song queries, which need a library.
+func (r *MemoryRepository) doSongs(fn func(data *memoryData) error) error {
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+	return r.do(fn)
+}
+
+func (r *MemoryRepository) WithTx(ctx context.Context, fn func(repo Repository) error) error {
+	return r.WithTxOptions(ctx, TxOptions{}, fn)
+}
+
+func (r *MemoryRepository) WithLibraryTx(ctx context.Context, fn func(repo LibraryRepository) error) error {
+	return r.WithTx(ctx, func(repo Repository) error {
+		return fn(repo.(*MemoryRepository))
+	})
+}
+
+// WithTxOptions runs fn on a private copy of the data that replaces the
+// store's data if fn succeeds. Transactions are serialized, so every
+// isolation level is effectively serializable and only fn itself can report
+// a conflict to retry. Using the outer repository inside fn deadlocks.
+func (r *MemoryRepository) WithTxOptions(ctx context.Context, opts TxOptions, fn func(repo Repository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	_, err := retryTx(ctx, r.logger, opts, func() error {
+		return r.runTx(opts, fn)
+	})
+	return err
+}
+
+func (r *MemoryRepository) runTx(opts TxOptions, fn func(repo Repository) error) error {
+	r.mem.mu.Lock()
+	defer r.mem.mu.Unlock()
+
+	tx := r.mem.data.clone()
+	if err := fn(&MemoryRepository{mem: r.mem, tx: tx, logger: r.logger, libraryID: r.libraryID, clean: r.clean}); err != nil {
+		return err
+	}
+
+	if !opts.ReadOnly {
+		r.mem.data = tx
+	}
+	return nil
+}
+
+func (d *memoryData) clone() *memoryData {
+	c := *d
+	c.songs = append([]memorySong(nil), d.songs...)
+	c.libraries = append([]models.Library(nil), d.libraries...)
+	c.keys = append([]memoryKey(nil), d.keys...)
+	c.audit = append([]models.AuditEvent(nil), d.audit...)
+	c.playlists = append([]memoryPlaylist(nil), d.playlists...)
+	c.entries = append([]memoryEntry(nil), d.entries...)
+	c.users = append([]memoryUser(nil), d.users...)
+	c.sessions = append([]models.Session(nil), d.sessions...)
+	c.favorites = append([]memoryFavorite(nil), d.favorites...)
+	c.plays = append([]memoryPlay(nil), d.plays...)
+	c.reviews = append([]models.Review(nil), d.reviews...)
+	c.annotations = append([]models.Annotation(nil), d.annotations...)
+	c.annotationVotes = append([]memoryVote(nil), d.annotationVotes...)
+	c.tags = append([]memoryTag(nil), d.tags...)
+	c.songTags = append([]memorySongTag(nil), d.songTags...)
+	c.translations = append([]models.Translation(nil), d.translations...)
+	c.explicitWordlists = make(map[int]string, len(d.explicitWordlists))
+	for id, fingerprint := range d.explicitWordlists {
+		c.explicitWordlists[id] = fingerprint
+	}
+	return &c
+}
+
+// song returns the live song with the given ID in the library.
+func (d *memoryData) song(libraryID, id int) *memorySong {
+	for i := range d.songs {
+		s := &d.songs[i]
+		if s.ID == id && s.libraryID == libraryID && s.deletedAt == nil {
+			return s
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetData(ctx context.Context, filter map[string]string, order string, limit int, offset int) ([]models.Song, error) {
+	if err := checkSongFilter(filter); err != nil {
+		return nil, err
+	}
+	less, err := songLess(order)
+	if err != nil {
+		return nil, err
+	}
+
+	date, err := normalizeDate(filter["release_date"])
+	if err != nil {
+		return nil, err
+	}
+
+	var songs []models.Song
+	err = r.doSongs(func(data *memoryData) error {
+		songs = data.matchingSongs(r.libraryID, r.clean, filter, date)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(songs, func(i, j int) bool { return less(&songs[i], &songs[j]) })
+	songs = page(songs, limit, offset)
+
+	log.FromContext(ctx, r.logger).Infof("Successfully fetched %d songs", len(songs))
+	return songs, nil
+}
+
+// songLess is the comparison for a sort name of songSortOrders. Songs are
+// stored by ID, so a stable sort breaks ties by ID.
+func songLess(order string) (func(a, b *models.Song) bool, error) {
+	if _, err := songOrder(order); err != nil {
+		return nil, err
+	}
+
+	switch order {
+	case "-id":
+		return func(a, b *models.Song) bool { return a.ID > b.ID }, nil
+	case "play_count":
+		return func(a, b *models.Song) bool { return a.PlayCount < b.PlayCount }, nil
+	case "-play_count":
+		return func(a, b *models.Song) bool { return a.PlayCount > b.PlayCount }, nil
+	case "rating":
+		return func(a, b *models.Song) bool {
+			if a.Rating != b.Rating {
+				return a.Rating < b.Rating
+			}
+			return a.RatingCount < b.RatingCount
+		}, nil
+	case "-rating":
+		return func(a, b *models.Song) bool {
+			if a.Rating != b.Rating {
+				return a.Rating > b.Rating
+			}
+			return a.RatingCount > b.RatingCount
+		}, nil
+	default:
+		return func(a, b *models.Song) bool { return a.ID < b.ID }, nil
+	}
+}
+
+// page returns the items of the page given by limit and offset.
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// matchingSongs returns the live songs of the library matching a checked
+// filter, with its release date normalized to date, leaving out explicit
+// songs if clean is set.
+func (d *memoryData) matchingSongs(libraryID int, clean bool, filter map[string]string, date string) []models.Song {
+	tags, all, _ := tagFilter(filter)
+
+	var songs []models.Song
+	for _, s := range d.songs {
+		if s.libraryID != libraryID || s.deletedAt != nil || clean && s.Explicit || !matchSong(s.Song, filter, date) {
+			continue
+		}
+		if len(tags) > 0 && !d.matchTags(s.ID, tags, all) {
+			continue
+		}
+		songs = append(songs, s.Song)
+	}
+	return songs
+}
+
+// matchTags reports whether the song has all of tags, or any if all is
+// false.
+func (d *memoryData) matchTags(songID int, tags []models.Tag, all bool) bool {
+	has := map[models.Tag]bool{}
+	for _, tag := range d.tagsOf(songID) {
+		has[tag] = true
+	}
+
+	matched := 0
+	for _, tag := range tags {
+		if has[tag] {
+			matched++
+		}
+	}
+	if all {
+		return matched == len(tags)
+	}
+	return matched > 0
+}
+
+func matchSong(song models.Song, filter map[string]string, date string) bool {
+	for key, value := range filter {
+		var field string
+		switch key {
+		case "tags", "tag_mode":
+			continue
+		case "release_date":
+			if song.ReleaseDate == "" || song.ReleaseDate != date {
+				return false
+			}
+			continue
+		case "min_rating":
+			if rating, _ := minRating(value); song.Rating < rating {
+				return false
+			}
+			continue
+		case "language":
+			if song.Language != value {
+				return false
+			}
+			continue
+		case "min_language_confidence":
+			if confidence, _ := minLanguageConfidence(value); song.LanguageConfidence < confidence {
+				return false
+			}
+			continue
+		case "explicit":
+			if explicit, _ := explicitFilter(value); song.Explicit != explicit {
+				return false
+			}
+			continue
+		case "text":
+			if !likeMatch(value, song.Text) && !likeMatch(latinText(value), latinText(song.Text)) {
+				return false
+			}
+			continue
+		case "group_name":
+			field = song.Group
+		case "song_name":
+			field = song.Song
+		case "link":
+			field = song.Link
+		}
+		if !likeMatch(value, field) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *MemoryRepository) GetSong(ctx context.Context, id int) (*models.Song, error) {
+	var song models.Song
+	err := r.doSongs(func(data *memoryData) error {
+		s := data.song(r.libraryID, id)
+		if !r.visible(s) {
+			return sql.ErrNoRows
+		}
+		song = s.Song
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+func (r *MemoryRepository) GetSongPagi(ctx context.Context, id int, limit int, offset int) (*models.Song, error) {
+	song, err := r.GetSong(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if song.Text, _, err = sliceVerses(song.Text, limit, offset); err != nil {
+		return nil, err
+	}
+	return song, nil
+}
+
+func (r *MemoryRepository) DeleteSong(ctx context.Context, id int) (int64, error) {
+	var deleted int64
+	err := r.doSongs(func(data *memoryData) error {
+		if s := data.song(r.libraryID, id); s != nil {
+			now := time.Now()
+			s.deletedAt = &now
+			deleted = 1
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *MemoryRepository) MergeSongs(ctx context.Context, targetID int, sourceIDs []int) ([]int, error) {
+	var merged []int
+	err := r.doSongs(func(data *memoryData) error {
+		target := data.song(r.libraryID, targetID)
+		if target == nil {
+			return nil
+		}
+
+		now := time.Now()
+		sources := map[int]bool{}
+		for _, id := range sourceIDs {
+			if s := data.song(r.libraryID, id); s != nil && id != targetID {
+				s.deletedAt = &now
+				target.PlayCount += s.PlayCount
+				s.PlayCount = 0
+				sources[id] = true
+				merged = append(merged, id)
+			}
+		}
+		sort.Ints(merged)
+
+		for i := range data.plays {
+			if sources[data.plays[i].songID] {
+				data.plays[i].songID = targetID
+			}
+		}
+		for i := range data.entries {
+			if sources[data.entries[i].songID] {
+				data.entries[i].songID = targetID
+			}
+		}
+
+		// An actor's favorite of the target, or else their earliest of the
+		// sources, is kept.
+		favorites := data.favorites[:0]
+		kept := map[string]int{}
+		for _, f := range data.favorites {
+			if f.songID == targetID {
+				kept[f.actor] = -1
+			}
+		}
+		for _, f := range data.favorites {
+			if !sources[f.songID] {
+				favorites = append(favorites, f)
+				continue
+			}
+			if i, ok := kept[f.actor]; !ok {
+				f.songID = targetID
+				kept[f.actor] = len(favorites)
+				favorites = append(favorites, f)
+			} else if i >= 0 && f.createdAt.Before(favorites[i].createdAt) {
+				favorites[i].createdAt = f.createdAt
+			}
+		}
+		data.favorites = favorites
+
+		// An actor's review of the target, or else their most recent one of
+		// the sources, wins; the others stay with the trashed sources.
+		winners := map[string]*models.Review{}
+		for i := range data.reviews {
+			review := &data.reviews[i]
+			if review.SongID == targetID {
+				winners[review.Actor] = nil
+			}
+		}
+		for i := range data.reviews {
+			review := &data.reviews[i]
+			if !sources[review.SongID] {
+				continue
+			}
+			winner, ok := winners[review.Actor]
+			if ok && (winner == nil || winner.UpdatedAt.After(review.UpdatedAt) || winner.UpdatedAt.Equal(review.UpdatedAt) && winner.ID > review.ID) {
+				continue
+			}
+			winners[review.Actor] = review
+		}
+		for _, review := range winners {
+			if review != nil {
+				review.SongID = targetID
+			}
+		}
+
+		tagged := map[int]bool{}
+		for _, st := range data.songTags {
+			if st.songID == targetID {
+				tagged[st.tagID] = true
+			}
+		}
+		for _, st := range data.songTags {
+			if sources[st.songID] && !tagged[st.tagID] {
+				tagged[st.tagID] = true
+				data.songTags = append(data.songTags, memorySongTag{songID: targetID, tagID: st.tagID})
+			}
+		}
+		return nil
+	})
+	return merged, err
+}
+
+func (r *MemoryRepository) UpdateSongData(ctx context.Context, song *models.Song) error {
+	if song.Group == "" && song.Song == "" && song.Text == "" && song.Link == "" && song.ReleaseDate == "" {
+		return errors.New("no fields to update")
+	}
+
+	date, err := normalizeDate(song.ReleaseDate)
+	if err != nil {
+		return err
+	}
+
+	return r.doSongs(func(data *memoryData) error {
+		s := data.song(r.libraryID, song.ID)
+		if s == nil {
+			return nil
+		}
+		if song.Group != "" {
+			s.Group = song.Group
+		}
+		if song.Song != "" {
+			s.Song.Song = song.Song
+		}
+		if song.Text != "" {
+			detectLanguage(song)
+			s.Text, s.Language, s.LanguageConfidence = song.Text, song.Language, song.LanguageConfidence
+			s.setExplicit(song.Explicit, s.ExplicitOverride)
+		}
+		if song.Link != "" {
+			s.Link = song.Link
+		}
+		if date != "" {
+			s.ReleaseDate = date
+		}
+		s.UpdatedBy = song.UpdatedBy
+		return nil
+	})
+}
+
+func (r *MemoryRepository) AddNewSong(ctx context.Context, song *models.Song) error {
+	date, err := normalizeDate(song.ReleaseDate)
+	if err != nil {
+		return err
+	}
+
+	return r.doSongs(func(data *memoryData) error {
+		if data.library(r.libraryID) == nil {
+			return fmt.Errorf("library %d does not exist", r.libraryID)
+		}
+
+		data.lastSongID++
+		song.ID = data.lastSongID
+		detectLanguage(song)
+
+		stored := *song
+		stored.ReleaseDate = date
+		stored.UpdatedBy = song.CreatedBy
+		stored.PlayCount, stored.Rating, stored.RatingCount = 0, 0, 0
+		s := memorySong{Song: stored, libraryID: r.libraryID}
+		s.setExplicit(song.Explicit, song.ExplicitOverride)
+		data.songs = append(data.songs, s)
+		song.Explicit = s.Explicit
+		return nil
+	})
+}
+
+func (r *MemoryRepository) SetExplicitOverride(ctx context.Context, id int, override *bool) (int64, error) {
+	var updated int64
+	err := r.doSongs(func(data *memoryData) error {
+		if s := data.song(r.libraryID, id); s != nil {
+			s.setExplicit(s.explicitDetected, override)
+			updated = 1
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (r *MemoryRepository) SetExplicitDetected(ctx context.Context, id int, detected bool) (int64, error) {
+	var updated int64
+	err := r.doSongs(func(data *memoryData) error {
+		for i := range data.songs {
+			if s := &data.songs[i]; s.libraryID == r.libraryID && s.ID == id {
+				s.setExplicit(detected, s.ExplicitOverride)
+				updated = 1
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// DetectSongLanguages retries the songs whose language could not be told,
+// as the memory store never writes text without detecting it.
+func (r *MemoryRepository) DetectSongLanguages(ctx context.Context) ([]models.Song, error) {
+	var detected []models.Song
+	err := r.doSongs(func(data *memoryData) error {
+		for i := range data.songs {
+			s := &data.songs[i]
+			if s.libraryID != r.libraryID || s.Text == "" || s.Language != "" {
+				continue
+			}
+			if detectLanguage(&s.Song); s.Language != "" {
+				detected = append(detected, models.Song{ID: s.ID, Language: s.Language, LanguageConfidence: s.LanguageConfidence})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return detected, nil
+}
+
+func (r *MemoryRepository) DetectExplicitSongs(ctx context.Context, fingerprint string, contains func(text string) bool) ([]models.Song, error) {
+	var updated []models.Song
+	err := r.doSongs(func(data *memoryData) error {
+		if data.explicitWordlists[r.libraryID] == fingerprint {
+			return nil
+		}
+		for i := range data.songs {
+			s := &data.songs[i]
+			if s.libraryID != r.libraryID {
+				continue
+			}
+			explicit := contains(s.Text)
+			for _, translation := range data.translations {
+				explicit = explicit || translation.SongID == s.ID && contains(translation.Text)
+			}
+			if explicit != s.explicitDetected {
+				s.setExplicit(explicit, s.ExplicitOverride)
+				updated = append(updated, models.Song{ID: s.ID, Explicit: explicit})
+			}
+		}
+		if data.explicitWordlists == nil {
+			data.explicitWordlists = map[int]string{}
+		}
+		data.explicitWordlists[r.libraryID] = fingerprint
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (r *MemoryRepository) PurgeDeletedSongs(ctx context.Context, before time.Time) ([]int, error) {
+	var ids []int
+	err := r.doSongs(func(data *memoryData) error {
+		purged := map[int]bool{}
+		kept := data.songs[:0]
+		for _, s := range data.songs {
+			if s.libraryID == r.libraryID && s.deletedAt != nil && s.deletedAt.Before(before) {
+				ids = append(ids, s.ID)
+				purged[s.ID] = true
+				continue
+			}
+			kept = append(kept, s)
+		}
+		data.songs = kept
+		data.deleteEntries(func(e *memoryEntry) bool { return purged[e.songID] })
+
+		favorites := data.favorites[:0]
+		for _, f := range data.favorites {
+			if !purged[f.songID] {
+				favorites = append(favorites, f)
+			}
+		}
+		data.favorites = favorites
+
+		plays := data.plays[:0]
+		for _, p := range data.plays {
+			if !purged[p.songID] {
+				plays = append(plays, p)
+			}
+		}
+		data.plays = plays
+
+		reviews := data.reviews[:0]
+		for _, review := range data.reviews {
+			if !purged[review.SongID] {
+				reviews = append(reviews, review)
+			}
+		}
+		data.reviews = reviews
+
+		annotations := data.annotations[:0]
+		for _, annotation := range data.annotations {
+			if !purged[annotation.SongID] {
+				annotations = append(annotations, annotation)
+				continue
+			}
+			data.deleteAnnotationVotes(annotation.ID)
+		}
+		data.annotations = annotations
+
+		songTags := data.songTags[:0]
+		for _, st := range data.songTags {
+			if !purged[st.songID] {
+				songTags = append(songTags, st)
+			}
+		}
+		data.songTags = songTags
+
+		translations := data.translations[:0]
+		for _, translation := range data.translations {
+			if !purged[translation.SongID] {
+				translations = append(translations, translation)
+			}
+		}
+		data.translations = translations
+		return nil
+	})
+	return ids, err
+}
+
+func (r *MemoryRepository) AddApiKey(ctx context.Context, key *models.ApiKey) error {
+	return r.do(func(data *memoryData) error {
+		for _, k := range data.keys {
+			if k.Hash == key.Hash {
+				return errors.New("api key hash already exists")
+			}
+		}
+
+		stored := memoryKey{ApiKey: *key}
+		stored.Scopes = append([]string(nil), key.Scopes...)
+		if library := data.libraryBySlug(key.Library); library != nil {
+			stored.libraryID = library.ID
+		}
+
+		data.lastKeyID++
+		key.ID = data.lastKeyID
+		key.CreatedAt = time.Now()
+		stored.ID, stored.CreatedAt = key.ID, key.CreatedAt
+
+		data.keys = append(data.keys, stored)
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	var key models.ApiKey
+	err := r.do(func(data *memoryData) error {
+		for _, k := range data.keys {
+			if k.Hash != hash {
+				continue
+			}
+			key = k.ApiKey
+			key.Scopes = append([]string(nil), k.Scopes...)
+			key.Library = data.librarySlug(k.libraryID)
+			return nil
+		}
+		return sql.ErrNoRows
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *MemoryRepository) RevokeApiKey(ctx context.Context, id int) (int64, error) {
+	var revoked int64
+	err := r.do(func(data *memoryData) error {
+		for i := range data.keys {
+			k := &data.keys[i]
+			if k.ID == id && k.RevokedAt == nil {
+				now := time.Now()
+				k.RevokedAt = &now
+				revoked = 1
+			}
+		}
+		return nil
+	})
+	return revoked, err
+}
+
+func (r *MemoryRepository) AddAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	return r.do(func(data *memoryData) error {
+		data.lastAuditID++
+		event.ID = data.lastAuditID
+		event.OccurredAt = time.Now()
+		data.audit = append(data.audit, *event)
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int, offset int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := r.do(func(data *memoryData) error {
+		skipped := 0
+		for i := len(data.audit) - 1; i >= 0 && len(events) < limit; i-- {
+			if !matchAuditEvent(&data.audit[i], filter) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			events = append(events, data.audit[i])
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (r *MemoryRepository) StreamAuditEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	// Copy the matches first so fn runs without holding the lock.
+	var events []models.AuditEvent
+	err := r.do(func(data *memoryData) error {
+		for i := range data.audit {
+			if matchAuditEvent(&data.audit[i], filter) {
+				events = append(events, data.audit[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range events {
+		if err := fn(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchAuditEvent(event *models.AuditEvent, filter models.AuditFilter) bool {
+	switch {
+	case filter.Actor != "" && event.Actor != filter.Actor,
+		filter.Action != "" && event.Action != filter.Action,
+		filter.Library != "" && event.Library != filter.Library,
+		filter.Entity != "" && event.Entity != filter.Entity,
+		filter.EntityID != 0 && event.EntityID != filter.EntityID,
+		filter.RequestID != "" && event.RequestID != filter.RequestID,
+		filter.From != nil && event.OccurredAt.Before(*filter.From),
+		filter.To != nil && !event.OccurredAt.Before(*filter.To):
+		return false
+	}
+	return true
+}
+
+func (r *MemoryRepository) AddLibrary(ctx context.Context, library *models.Library) error {
+	return r.do(func(data *memoryData) error {
+		if data.libraryBySlug(library.Slug) != nil {
+			return fmt.Errorf("library %q already exists", library.Slug)
+		}
+		data.addLibrary(library)
+		return nil
+	})
+}
+
+func (d *memoryData) addLibrary(library *models.Library) {
+	d.lastLibraryID++
+	library.ID = d.lastLibraryID
+	library.CreatedAt = time.Now()
+	d.libraries = append(d.libraries, *library)
+}
+
+func (d *memoryData) library(id int) *models.Library {
+	for i := range d.libraries {
+		if d.libraries[i].ID == id {
+			return &d.libraries[i]
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) libraryBySlug(slug string) *models.Library {
+	for i := range d.libraries {
+		if d.libraries[i].Slug == slug {
+			return &d.libraries[i]
+		}
+	}
+	return nil
+}
+
+// librarySlug returns the slug of the library with the given ID, or an empty
+// string for unbound credentials.
+func (d *memoryData) librarySlug(id int) string {
+	if library := d.library(id); library != nil {
+		return library.Slug
+	}
+	return ""
+}
+
+func (r *MemoryRepository) GetLibraryBySlug(ctx context.Context, slug string) (*models.Library, error) {
+	var library models.Library
+	err := r.do(func(data *memoryData) error {
+		l := data.libraryBySlug(slug)
+		if l == nil {
+			return sql.ErrNoRows
+		}
+		library = *l
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &library, nil
+}
+
+func (r *MemoryRepository) GetLibraries(ctx context.Context) ([]models.Library, error) {
+	var libraries []models.Library
+	err := r.do(func(data *memoryData) error {
+		libraries = append(libraries, data.libraries...)
+		return nil
+	})
+	sort.Slice(libraries, func(i, j int) bool { return libraries[i].Slug < libraries[j].Slug })
+	return libraries, err
+}
+
+func (r *MemoryRepository) CopySongs(ctx context.Context, fromID, toID int, songIDs []int, actor string) (int64, error) {
+	wanted := make(map[int]bool, len(songIDs))
+	for _, id := range songIDs {
+		wanted[id] = true
+	}
+
+	var copied int64
+	err := r.do(func(data *memoryData) error {
+		if data.library(toID) == nil {
+			return fmt.Errorf("library %d does not exist", toID)
+		}
+
+		for _, s := range data.songs {
+			if s.libraryID != fromID || s.deletedAt != nil || (len(wanted) > 0 && !wanted[s.ID]) {
+				continue
+			}
+			data.lastSongID++
+			s.ID = data.lastSongID
+			s.libraryID = toID
+			s.CreatedBy, s.UpdatedBy = actor, actor
+			s.PlayCount, s.Rating, s.RatingCount = 0, 0, 0
+			data.songs = append(data.songs, s)
+			copied++
+		}
+		return nil
+	})
+	return copied, err
+}
+
+func (r *MemoryRepository) GetSongStats(ctx context.Context) ([]models.SongStats, error) {
+	var stats []models.SongStats
+	err := r.do(func(data *memoryData) error {
+		for _, l := range data.libraries {
+			s := models.SongStats{Library: l.Slug}
+			for _, song := range data.songs {
+				if song.libraryID != l.ID || song.deletedAt != nil {
+					continue
+				}
+				s.Total++
+				if song.Text == "" {
+					s.MissingText++
+				}
+				if song.Link == "" {
+					s.MissingLink++
+				}
+			}
+			stats = append(stats, s)
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// playlist returns the playlist with the given ID in the library.
+func (d *memoryData) playlist(libraryID, id int) *memoryPlaylist {
+	for i := range d.playlists {
+		p := &d.playlists[i]
+		if p.ID == id && p.libraryID == libraryID {
+			return p
+		}
+	}
+	return nil
+}
+
+// deleteEntries removes the entries for which match returns true and
+// reports how many there were.
+func (d *memoryData) deleteEntries(match func(e *memoryEntry) bool) int64 {
+	var deleted int64
+	kept := d.entries[:0]
+	for i := range d.entries {
+		if match(&d.entries[i]) {
+			deleted++
+			continue
+		}
+		kept = append(kept, d.entries[i])
+	}
+	d.entries = kept
+	return deleted
+}
+
+func (r *MemoryRepository) AddPlaylist(ctx context.Context, playlist *models.Playlist) error {
+	return r.doSongs(func(data *memoryData) error {
+		if data.library(r.libraryID) == nil {
+			return fmt.Errorf("library %d does not exist", r.libraryID)
+		}
+
+		data.lastPlaylistID++
+		playlist.ID = data.lastPlaylistID
+		playlist.CreatedAt = time.Now()
+
+		stored := *playlist
+		stored.Entries = nil
+		data.playlists = append(data.playlists, memoryPlaylist{Playlist: stored, libraryID: r.libraryID})
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetPlaylist(ctx context.Context, id int) (*models.Playlist, error) {
+	var playlist models.Playlist
+	err := r.doSongs(func(data *memoryData) error {
+		p := data.playlist(r.libraryID, id)
+		if p == nil {
+			return sql.ErrNoRows
+		}
+		playlist = p.Playlist
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+func (r *MemoryRepository) GetPlaylists(ctx context.Context, viewer string, limit int, offset int) ([]models.Playlist, error) {
+	var playlists []models.Playlist
+	err := r.doSongs(func(data *memoryData) error {
+		skipped := 0
+		for _, p := range data.playlists {
+			if p.libraryID != r.libraryID || (viewer != "" && p.Owner != viewer && p.Visibility != models.PlaylistPublic) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if len(playlists) == limit {
+				break
+			}
+			playlists = append(playlists, p.Playlist)
+		}
+		return nil
+	})
+	return playlists, err
+}
+
+func (r *MemoryRepository) UpdatePlaylist(ctx context.Context, playlist *models.Playlist) (int64, error) {
+	var updated int64
+	err := r.doSongs(func(data *memoryData) error {
+		if p := data.playlist(r.libraryID, playlist.ID); p != nil {
+			p.Name = playlist.Name
+			p.Description = playlist.Description
+			p.Visibility = playlist.Visibility
+			updated = 1
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (r *MemoryRepository) DeletePlaylist(ctx context.Context, id int) (int64, error) {
+	var deleted int64
+	err := r.doSongs(func(data *memoryData) error {
+		kept := data.playlists[:0]
+		for _, p := range data.playlists {
+			if p.ID == id && p.libraryID == r.libraryID {
+				deleted = 1
+				continue
+			}
+			kept = append(kept, p)
+		}
+		data.playlists = kept
+		if deleted > 0 {
+			data.deleteEntries(func(e *memoryEntry) bool { return e.playlistID == id })
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *MemoryRepository) GetPlaylistEntries(ctx context.Context, playlistID int) ([]models.PlaylistEntry, error) {
+	var entries []models.PlaylistEntry
+	err := r.doSongs(func(data *memoryData) error {
+		if data.playlist(r.libraryID, playlistID) == nil {
+			return nil
+		}
+		for _, e := range data.entries {
+			if e.playlistID != playlistID {
+				continue
+			}
+			song := data.song(r.libraryID, e.songID)
+			if !r.visible(song) {
+				continue
+			}
+			entries = append(entries, models.PlaylistEntry{
+				ID:       e.id,
+				Position: e.position,
+				SongID:   e.songID,
+				Group:    song.Group,
+				Song:     song.Song.Song,
+				Link:     song.Link,
+				AddedBy:  e.addedBy,
+				AddedAt:  e.addedAt,
+			})
+		}
+		return nil
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Position != entries[j].Position {
+			return entries[i].Position < entries[j].Position
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, err
+}
+
+func (r *MemoryRepository) GetPlaylistEntryIDs(ctx context.Context, playlistID int) ([]int, error) {
+	var entries []memoryEntry
+	err := r.doSongs(func(data *memoryData) error {
+		if data.playlist(r.libraryID, playlistID) == nil {
+			return nil
+		}
+		for _, e := range data.entries {
+			if e.playlistID == playlistID {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].position != entries[j].position {
+			return entries[i].position < entries[j].position
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	var ids []int
+	for _, e := range entries {
+		ids = append(ids, e.id)
+	}
+	return ids, err
+}
+
+func (r *MemoryRepository) AddPlaylistEntry(ctx context.Context, entry *models.PlaylistEntry, playlistID int) error {
+	return r.doSongs(func(data *memoryData) error {
+		if data.playlist(r.libraryID, playlistID) == nil || !r.visible(data.song(r.libraryID, entry.SongID)) {
+			return sql.ErrNoRows
+		}
+
+		entry.Position = 1
+		for _, e := range data.entries {
+			if e.playlistID == playlistID && e.position >= entry.Position {
+				entry.Position = e.position + 1
+			}
+		}
+
+		data.lastEntryID++
+		entry.ID = data.lastEntryID
+		entry.AddedAt = time.Now()
+		data.entries = append(data.entries, memoryEntry{
+			id:         entry.ID,
+			playlistID: playlistID,
+			songID:     entry.SongID,
+			position:   entry.Position,
+			addedBy:    entry.AddedBy,
+			addedAt:    entry.AddedAt,
+		})
+		return nil
+	})
+}
+
+func (r *MemoryRepository) DeletePlaylistEntry(ctx context.Context, playlistID, entryID int) (int64, error) {
+	var deleted int64
+	err := r.doSongs(func(data *memoryData) error {
+		if data.playlist(r.libraryID, playlistID) == nil {
+			return nil
+		}
+		deleted = data.deleteEntries(func(e *memoryEntry) bool { return e.id == entryID && e.playlistID == playlistID })
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *MemoryRepository) SetPlaylistPositions(ctx context.Context, playlistID int, entryIDs []int) error {
+	positions := make(map[int]int, len(entryIDs))
+	for i, id := range entryIDs {
+		positions[id] = i + 1
+	}
+
+	return r.doSongs(func(data *memoryData) error {
+		if data.playlist(r.libraryID, playlistID) == nil {
+			return nil
+		}
+		// Positions are unique like in the databases, so the new ones are
+		// checked before anything changes.
+		taken := make(map[int]bool)
+		for _, e := range data.entries {
+			if e.playlistID != playlistID {
+				continue
+			}
+			position, ok := positions[e.id]
+			if !ok {
+				position = e.position
+			}
+			if taken[position] {
+				return fmt.Errorf("playlist %d has two entries at position %d", playlistID, position)
+			}
+			taken[position] = true
+		}
+
+		for i := range data.entries {
+			e := &data.entries[i]
+			if position, ok := positions[e.id]; ok && e.playlistID == playlistID {
+				e.position = position
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRepository) AddUser(ctx context.Context, user *models.User) error {
+	return r.do(func(data *memoryData) error {
+		for _, u := range data.users {
+			if u.Username == user.Username {
+				return fmt.Errorf("user %q already exists", user.Username)
+			}
+		}
+
+		stored := memoryUser{User: *user}
+		if library := data.libraryBySlug(user.Library); library != nil {
+			stored.libraryID = library.ID
+		}
+
+		data.lastUserID++
+		user.ID = data.lastUserID
+		user.CreatedAt = time.Now()
+		stored.ID, stored.CreatedAt = user.ID, user.CreatedAt
+
+		data.users = append(data.users, stored)
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetUserByName(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	err := r.do(func(data *memoryData) error {
+		for _, u := range data.users {
+			if u.Username == username {
+				user = u.User
+				user.Library = data.librarySlug(u.libraryID)
+				return nil
+			}
+		}
+		return sql.ErrNoRows
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *MemoryRepository) AddSession(ctx context.Context, session *models.Session) error {
+	return r.do(func(data *memoryData) error {
+		data.lastSessionID++
+		session.ID = data.lastSessionID
+		session.CreatedAt = time.Now()
+		data.sessions = append(data.sessions, *session)
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetSessionByHash(ctx context.Context, hash string) (*models.Session, error) {
+	var session models.Session
+	err := r.do(func(data *memoryData) error {
+		for _, s := range data.sessions {
+			if s.Hash != hash {
+				continue
+			}
+			for _, u := range data.users {
+				if u.ID == s.UserID {
+					session = s
+					session.Username, session.Role = u.Username, u.Role
+					session.Library = data.librarySlug(u.libraryID)
+					return nil
+				}
+			}
+		}
+		return sql.ErrNoRows
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *MemoryRepository) RevokeSession(ctx context.Context, id int) (int64, error) {
+	var revoked int64
+	err := r.do(func(data *memoryData) error {
+		for i := range data.sessions {
+			s := &data.sessions[i]
+			if s.ID == id && s.RevokedAt == nil {
+				now := time.Now()
+				s.RevokedAt = &now
+				revoked = 1
+			}
+		}
+		return nil
+	})
+	return revoked, err
+}
+
+func (r *MemoryRepository) AddFavorite(ctx context.Context, actor string, songID int) error {
+	return r.doSongs(func(data *memoryData) error {
+		if data.song(r.libraryID, songID) == nil {
+			return sql.ErrNoRows
+		}
+		for _, f := range data.favorites {
+			if f.actor == actor && f.songID == songID {
+				return nil
+			}
+		}
+		data.favorites = append(data.favorites, memoryFavorite{actor: actor, songID: songID, createdAt: time.Now()})
+		return nil
+	})
+}
+
+func (r *MemoryRepository) DeleteFavorite(ctx context.Context, actor string, songID int) (int64, error) {
+	var deleted int64
+	err := r.doSongs(func(data *memoryData) error {
+		if data.song(r.libraryID, songID) == nil {
+			return nil
+		}
+		kept := data.favorites[:0]
+		for _, f := range data.favorites {
+			if f.actor == actor && f.songID == songID {
+				deleted++
+				continue
+			}
+			kept = append(kept, f)
+		}
+		data.favorites = kept
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *MemoryRepository) GetFavorites(ctx context.Context, actor string, limit int, offset int) ([]models.Song, error) {
+	var favorites []memoryFavorite
+	var songs []models.Song
+	err := r.doSongs(func(data *memoryData) error {
+		for _, f := range data.favorites {
+			if f.actor == actor && r.visible(data.song(r.libraryID, f.songID)) {
+				favorites = append(favorites, f)
+			}
+		}
+		sort.SliceStable(favorites, func(i, j int) bool {
+			if !favorites[i].createdAt.Equal(favorites[j].createdAt) {
+				return favorites[i].createdAt.After(favorites[j].createdAt)
+			}
+			return favorites[i].songID > favorites[j].songID
+		})
+		for _, f := range page(favorites, limit, offset) {
+			songs = append(songs, data.song(r.libraryID, f.songID).Song)
+		}
+		return nil
+	})
+	return songs, err
+}
+
+func (r *MemoryRepository) AddPlay(ctx context.Context, play *models.Play, actor string) error {
+	return r.doSongs(func(data *memoryData) error {
+		s := data.song(r.libraryID, play.SongID)
+		if s == nil {
+			return sql.ErrNoRows
+		}
+		s.PlayCount++
+
+		data.lastPlayID++
+		play.ID = data.lastPlayID
+		play.Group, play.Song, play.PlayedAt = s.Group, s.Song.Song, time.Now()
+		data.plays = append(data.plays, memoryPlay{id: play.ID, songID: play.SongID, actor: actor, playedAt: play.PlayedAt})
+		return nil
+	})
+}
+
+// actorPlays returns the plays of actor since the given time of live songs
+// in the library, newest first, leaving out explicit songs if clean is set.
+func (d *memoryData) actorPlays(libraryID int, clean bool, actor string, since time.Time) []models.Play {
+	var plays []models.Play
+	for i := len(d.plays) - 1; i >= 0; i-- {
+		p := d.plays[i]
+		if p.actor != actor || p.playedAt.Before(since) {
+			continue
+		}
+		if s := d.song(libraryID, p.songID); s != nil && !(clean && s.Explicit) {
+			plays = append(plays, models.Play{ID: p.id, SongID: p.songID, Group: s.Group, Song: s.Song.Song, PlayedAt: p.playedAt})
+		}
+	}
+	return plays
+}
+
+func (r *MemoryRepository) GetPlays(ctx context.Context, actor string, limit int, offset int) ([]models.Play, error) {
+	var plays []models.Play
+	err := r.doSongs(func(data *memoryData) error {
+		plays = page(data.actorPlays(r.libraryID, r.clean, actor, time.Time{}), limit, offset)
+		return nil
+	})
+	return plays, err
+}
+
+func (r *MemoryRepository) GetTopSongs(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopSong, error) {
+	var top []models.TopSong
+	err := r.doSongs(func(data *memoryData) error {
+		index := map[int]int{}
+		for _, p := range data.actorPlays(r.libraryID, r.clean, actor, since) {
+			i, ok := index[p.SongID]
+			if !ok {
+				i = len(top)
+				index[p.SongID] = i
+				top = append(top, models.TopSong{SongID: p.SongID, Group: p.Group, Song: p.Song})
+			}
+			top[i].Plays++
+		}
+		sort.SliceStable(top, func(i, j int) bool {
+			if top[i].Plays != top[j].Plays {
+				return top[i].Plays > top[j].Plays
+			}
+			return top[i].SongID < top[j].SongID
+		})
+		top = page(top, limit, 0)
+		return nil
+	})
+	return top, err
+}
+
+func (r *MemoryRepository) GetTopGroups(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopGroup, error) {
+	var top []models.TopGroup
+	err := r.doSongs(func(data *memoryData) error {
+		index := map[string]int{}
+		for _, p := range data.actorPlays(r.libraryID, r.clean, actor, since) {
+			i, ok := index[p.Group]
+			if !ok {
+				i = len(top)
+				index[p.Group] = i
+				top = append(top, models.TopGroup{Group: p.Group})
+			}
+			top[i].Plays++
+		}
+		sort.SliceStable(top, func(i, j int) bool {
+			if top[i].Plays != top[j].Plays {
+				return top[i].Plays > top[j].Plays
+			}
+			return top[i].Group < top[j].Group
+		})
+		top = page(top, limit, 0)
+		return nil
+	})
+	return top, err
+}
+
+// review returns the review with the given ID of a live song in the
+// library.
+func (d *memoryData) review(libraryID, id int) *models.Review {
+	for i := range d.reviews {
+		review := &d.reviews[i]
+		if review.ID == id && d.song(libraryID, review.SongID) != nil {
+			return review
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) SaveReview(ctx context.Context, review *models.Review) error {
+	return r.doSongs(func(data *memoryData) error {
+		if data.song(r.libraryID, review.SongID) == nil {
+			return sql.ErrNoRows
+		}
+
+		now := time.Now()
+		for i := range data.reviews {
+			stored := &data.reviews[i]
+			if stored.SongID == review.SongID && stored.Actor == review.Actor {
+				stored.Rating, stored.Body, stored.Status, stored.UpdatedAt = review.Rating, review.Body, review.Status, now
+				*review = *stored
+				return nil
+			}
+		}
+
+		data.lastReviewID++
+		review.ID = data.lastReviewID
+		review.CreatedAt, review.UpdatedAt = now, now
+		data.reviews = append(data.reviews, *review)
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	var review models.Review
+	err := r.doSongs(func(data *memoryData) error {
+		stored := data.review(r.libraryID, id)
+		if stored == nil || !r.visible(data.song(r.libraryID, stored.SongID)) {
+			return sql.ErrNoRows
+		}
+		review = *stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (r *MemoryRepository) GetReviews(ctx context.Context, filter models.ReviewFilter, limit int, offset int) ([]models.Review, error) {
+	var reviews []models.Review
+	err := r.doSongs(func(data *memoryData) error {
+		for _, review := range data.reviews {
+			switch {
+			case !r.visible(data.song(r.libraryID, review.SongID)),
+				filter.SongID != 0 && review.SongID != filter.SongID,
+				filter.Actor != "" && review.Actor != filter.Actor,
+				filter.Status != "" && review.Status != filter.Status:
+				continue
+			}
+			reviews = append(reviews, review)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(reviews, func(i, j int) bool {
+		if !reviews[i].UpdatedAt.Equal(reviews[j].UpdatedAt) {
+			return reviews[i].UpdatedAt.After(reviews[j].UpdatedAt)
+		}
+		return reviews[i].ID > reviews[j].ID
+	})
+	return page(reviews, limit, offset), nil
+}
+
+func (r *MemoryRepository) DeleteReview(ctx context.Context, actor string, songID int) (int64, error) {
+	var deleted int64
+	err := r.doSongs(func(data *memoryData) error {
+		if data.song(r.libraryID, songID) == nil {
+			return nil
+		}
+		kept := data.reviews[:0]
+		for _, review := range data.reviews {
+			if review.Actor == actor && review.SongID == songID {
+				deleted++
+				continue
+			}
+			kept = append(kept, review)
+		}
+		data.reviews = kept
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *MemoryRepository) SetReviewStatus(ctx context.Context, id int, status string) (int64, error) {
+	var updated int64
+	err := r.doSongs(func(data *memoryData) error {
+		if review := data.review(r.libraryID, id); review != nil {
+			review.Status = status
+			updated = 1
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (r *MemoryRepository) RefreshSongRating(ctx context.Context, songID int) error {
+	return r.doSongs(func(data *memoryData) error {
+		s := data.song(r.libraryID, songID)
+		if s == nil {
+			return nil
+		}
+
+		var sum, count int
+		for _, review := range data.reviews {
+			if review.SongID == songID && review.Status == models.ReviewApproved {
+				sum += review.Rating
+				count++
+			}
+		}
+
+		s.Rating, s.RatingCount = 0, count
+		if count > 0 {
+			s.Rating = math.Round(float64(sum)/float64(count)*100) / 100
+		}
+		return nil
+	})
+}
+
+// annotation returns the annotation with the given ID of a live song in the
+// library.
+func (d *memoryData) annotation(libraryID, id int) *models.Annotation {
+	for i := range d.annotations {
+		annotation := &d.annotations[i]
+		if annotation.ID == id && d.song(libraryID, annotation.SongID) != nil {
+			return annotation
+		}
+	}
+	return nil
+}
+
+func (d *memoryData) deleteAnnotationVotes(annotationID int) {
+	votes := d.annotationVotes[:0]
+	for _, v := range d.annotationVotes {
+		if v.annotationID != annotationID {
+			votes = append(votes, v)
+		}
+	}
+	d.annotationVotes = votes
+}
+
+func (r *MemoryRepository) AddAnnotation(ctx context.Context, annotation *models.Annotation) error {
+	return r.doSongs(func(data *memoryData) error {
+		if data.song(r.libraryID, annotation.SongID) == nil {
+			return sql.ErrNoRows
+		}
+
+		now := time.Now()
+		data.lastAnnotationID++
+		annotation.ID = data.lastAnnotationID
+		annotation.Votes, annotation.Orphaned = 0, false
+		annotation.CreatedAt, annotation.UpdatedAt = now, now
+		data.annotations = append(data.annotations, *annotation)
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetAnnotation(ctx context.Context, id int) (*models.Annotation, error) {
+	var annotation models.Annotation
+	err := r.doSongs(func(data *memoryData) error {
+		stored := data.annotation(r.libraryID, id)
+		if stored == nil || !r.visible(data.song(r.libraryID, stored.SongID)) {
+			return sql.ErrNoRows
+		}
+		annotation = *stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+func (r *MemoryRepository) GetAnnotations(ctx context.Context, songID int) ([]models.Annotation, error) {
+	var annotations []models.Annotation
+	err := r.doSongs(func(data *memoryData) error {
+		if !r.visible(data.song(r.libraryID, songID)) {
+			return nil
+		}
+		for _, annotation := range data.annotations {
+			if annotation.SongID == songID {
+				annotations = append(annotations, annotation)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(annotations, func(i, j int) bool {
+		a, b := annotations[i], annotations[j]
+		if a.Verse != b.Verse {
+			return a.Verse < b.Verse
+		}
+		if a.Start != b.Start {
+			return a.Start < b.Start
+		}
+		return a.ID < b.ID
+	})
+	return annotations, nil
+}
+
+func (r *MemoryRepository) UpdateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error) {
+	var updated int64
+	err := r.doSongs(func(data *memoryData) error {
+		stored := data.annotation(r.libraryID, annotation.ID)
+		if stored == nil {
+			return nil
+		}
+		stored.AnnotationAnchor = annotation.AnnotationAnchor
+		stored.Quote, stored.Body, stored.Orphaned = annotation.Quote, annotation.Body, annotation.Orphaned
+		stored.UpdatedAt = time.Now()
+		annotation.UpdatedAt = stored.UpdatedAt
+		updated = 1
+		return nil
+	})
+	return updated, err
+}
+
+func (r *MemoryRepository) DeleteAnnotation(ctx context.Context, id int) (int64, error) {
+	var deleted int64
+	err := r.doSongs(func(data *memoryData) error {
+		if data.annotation(r.libraryID, id) == nil {
+			return nil
+		}
+		kept := data.annotations[:0]
+		for _, annotation := range data.annotations {
+			if annotation.ID == id {
+				deleted++
+				continue
+			}
+			kept = append(kept, annotation)
+		}
+		data.annotations = kept
+		data.deleteAnnotationVotes(id)
+		return nil
+	})
+	return deleted, err
+}
+
+func (r *MemoryRepository) SetAnnotationVote(ctx context.Context, id int, actor string, value int) error {
+	return r.doSongs(func(data *memoryData) error {
+		annotation := data.annotation(r.libraryID, id)
+		if annotation == nil {
+			return sql.ErrNoRows
+		}
+
+		votes, found, total := data.annotationVotes[:0], false, 0
+		for _, v := range data.annotationVotes {
+			if v.annotationID == id && v.actor == actor {
+				found = true
+				if value == 0 {
+					continue
+				}
+				v.value = value
+			}
+			if v.annotationID == id {
+				total += v.value
+			}
+			votes = append(votes, v)
+		}
+		if !found && value != 0 {
+			votes = append(votes, memoryVote{annotationID: id, actor: actor, value: value})
+			total += value
+		}
+		data.annotationVotes = votes
+		annotation.Votes = total
+		return nil
+	})
+}
+
+// tag returns the tag of the library, or nil if there is none.
+func (d *memoryData) tag(libraryID int, tag models.Tag) *memoryTag {
+	for i := range d.tags {
+		t := &d.tags[i]
+		if t.libraryID == libraryID && t.Tag == tag {
+			return t
+		}
+	}
+	return nil
+}
+
+// tagsOf returns the tags of the song sorted by type and name.
+func (d *memoryData) tagsOf(songID int) []models.Tag {
+	tags := []models.Tag{}
+	for _, st := range d.songTags {
+		if st.songID != songID {
+			continue
+		}
+		for _, t := range d.tags {
+			if t.id == st.tagID {
+				tags = append(tags, t.Tag)
+			}
+		}
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return tagLess(tags[i], tags[j]) })
+	return tags
+}
+
+func tagLess(a, b models.Tag) bool {
+	if a.Type != b.Type {
+		return a.Type < b.Type
+	}
+	return a.Name < b.Name
+}
+
+func (r *MemoryRepository) GetTags(ctx context.Context, tagType string) ([]models.TagCount, error) {
+	var tags []models.TagCount
+	err := r.doSongs(func(data *memoryData) error {
+		for _, t := range data.tags {
+			if t.libraryID != r.libraryID || tagType != "" && t.Type != tagType {
+				continue
+			}
+			tag := models.TagCount{Tag: t.Tag}
+			for _, st := range data.songTags {
+				if st.tagID == t.id && r.visible(data.song(r.libraryID, st.songID)) {
+					tag.Count++
+				}
+			}
+			tags = append(tags, tag)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return tagLess(tags[i].Tag, tags[j].Tag) })
+	return tags, nil
+}
+
+func (r *MemoryRepository) GetSongTags(ctx context.Context, songIDs []int) (map[int][]models.Tag, error) {
+	songTags := map[int][]models.Tag{}
+	err := r.doSongs(func(data *memoryData) error {
+		for _, id := range songIDs {
+			if r.visible(data.song(r.libraryID, id)) {
+				songTags[id] = data.tagsOf(id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return songTags, nil
+}
+
+func (r *MemoryRepository) TagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error) {
+	var added int64
+	err := r.doSongs(func(data *memoryData) error {
+		for _, tag := range tags {
+			t := data.tag(r.libraryID, tag)
+			if t == nil {
+				data.lastTagID++
+				data.tags = append(data.tags, memoryTag{Tag: tag, id: data.lastTagID, libraryID: r.libraryID})
+				t = &data.tags[len(data.tags)-1]
+			}
+
+			for _, songID := range songIDs {
+				if data.song(r.libraryID, songID) == nil || data.hasSongTag(songID, t.id) {
+					continue
+				}
+				data.songTags = append(data.songTags, memorySongTag{songID: songID, tagID: t.id})
+				added++
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+func (d *memoryData) hasSongTag(songID, tagID int) bool {
+	for _, st := range d.songTags {
+		if st.songID == songID && st.tagID == tagID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryRepository) UntagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error) {
+	var removed int64
+	err := r.doSongs(func(data *memoryData) error {
+		remove := map[memorySongTag]bool{}
+		for _, tag := range tags {
+			t := data.tag(r.libraryID, tag)
+			if t == nil {
+				continue
+			}
+			for _, songID := range songIDs {
+				if data.song(r.libraryID, songID) != nil {
+					remove[memorySongTag{songID: songID, tagID: t.id}] = true
+				}
+			}
+		}
+
+		kept := data.songTags[:0]
+		for _, st := range data.songTags {
+			if remove[st] {
+				removed++
+				continue
+			}
+			kept = append(kept, st)
+		}
+		data.songTags = kept
+		return nil
+	})
+	return removed, err
+}
+
+func (r *MemoryRepository) GetSongFacets(ctx context.Context, filter map[string]string, limit int) (*models.SongFacets, error) {
+	if err := checkSongFilter(filter); err != nil {
+		return nil, err
+	}
+	date, err := normalizeDate(filter["release_date"])
+	if err != nil {
+		return nil, err
+	}
+
+	tagCounts, yearCounts, groupCounts := map[models.Tag]int{}, map[int]int{}, map[string]int{}
+	err = r.doSongs(func(data *memoryData) error {
+		for _, song := range data.matchingSongs(r.libraryID, r.clean, filter, date) {
+			for _, tag := range data.tagsOf(song.ID) {
+				tagCounts[tag]++
+			}
+			if song.ReleaseDate != "" {
+				year, _ := strconv.Atoi(song.ReleaseDate[:4])
+				yearCounts[year]++
+			}
+			groupCounts[song.Group]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	facets := &models.SongFacets{Tags: []models.TagCount{}, Years: []models.YearCount{}, Groups: []models.GroupCount{}}
+	for tag, count := range tagCounts {
+		facets.Tags = append(facets.Tags, models.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(facets.Tags, func(i, j int) bool {
+		a, b := facets.Tags[i], facets.Tags[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return tagLess(a.Tag, b.Tag)
+	})
+
+	for year, count := range yearCounts {
+		facets.Years = append(facets.Years, models.YearCount{Year: year, Count: count})
+	}
+	sort.Slice(facets.Years, func(i, j int) bool { return facets.Years[i].Year < facets.Years[j].Year })
+
+	for group, count := range groupCounts {
+		facets.Groups = append(facets.Groups, models.GroupCount{Group: group, Count: count})
+	}
+	sort.Slice(facets.Groups, func(i, j int) bool {
+		a, b := facets.Groups[i], facets.Groups[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Group < b.Group
+	})
+	facets.Groups = page(facets.Groups, limit, 0)
+	if facets.Groups == nil {
+		facets.Groups = []models.GroupCount{}
+	}
+
+	return facets, nil
+}
+
+// translation returns the translation of a live song in the library into
+// language.
+func (d *memoryData) translation(libraryID, songID int, language string) *models.Translation {
+	if d.song(libraryID, songID) == nil {
+		return nil
+	}
+	for i := range d.translations {
+		translation := &d.translations[i]
+		if translation.SongID == songID && translation.Language == language {
+			return translation
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) SaveTranslation(ctx context.Context, translation *models.Translation) error {
+	return r.doSongs(func(data *memoryData) error {
+		if data.song(r.libraryID, translation.SongID) == nil {
+			return sql.ErrNoRows
+		}
+
+		now := time.Now()
+		alignment := append([]int{}, translation.Alignment...)
+		if stored := data.translation(r.libraryID, translation.SongID, translation.Language); stored != nil {
+			stored.Text, stored.Translator, stored.Alignment = translation.Text, translation.Translator, alignment
+			stored.UpdatedAt = now
+			translation.ID, translation.CreatedBy = stored.ID, stored.CreatedBy
+			translation.CreatedAt, translation.UpdatedAt = stored.CreatedAt, stored.UpdatedAt
+			return nil
+		}
+
+		data.lastTranslationID++
+		translation.ID = data.lastTranslationID
+		translation.CreatedAt, translation.UpdatedAt = now, now
+		stored := *translation
+		stored.Alignment = alignment
+		data.translations = append(data.translations, stored)
+		return nil
+	})
+}
+
+func (r *MemoryRepository) GetTranslation(ctx context.Context, songID int, language string) (*models.Translation, error) {
+	var translation models.Translation
+	err := r.doSongs(func(data *memoryData) error {
+		stored := data.translation(r.libraryID, songID, language)
+		if stored == nil || !r.visible(data.song(r.libraryID, songID)) {
+			return sql.ErrNoRows
+		}
+		translation = *stored
+		translation.Alignment = append([]int{}, stored.Alignment...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &translation, nil
+}
+
+func (r *MemoryRepository) GetTranslations(ctx context.Context, songID int) ([]models.Translation, error) {
+	var translations []models.Translation
+	err := r.doSongs(func(data *memoryData) error {
+		if !r.visible(data.song(r.libraryID, songID)) {
+			return nil
+		}
+		for _, translation := range data.translations {
+			if translation.SongID == songID {
+				translation.Alignment = append([]int{}, translation.Alignment...)
+				translations = append(translations, translation)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(translations, func(i, j int) bool {
+		return translations[i].Language < translations[j].Language
+	})
+	return translations, nil
+}
+
+func (r *MemoryRepository) DeleteTranslation(ctx context.Context, songID int, language string) (int64, error) {
+	var deleted int64
+	err := r.doSongs(func(data *memoryData) error {
+		if data.translation(r.libraryID, songID, language) == nil {
+			return nil
+		}
+		kept := data.translations[:0]
+		for _, translation := range data.translations {
+			if translation.SongID == songID && translation.Language == language {
+				deleted++
+				continue
+			}
+			kept = append(kept, translation)
+		}
+		data.translations = kept
+		return nil
+	})
+	return deleted, err
+}
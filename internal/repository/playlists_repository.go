@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/lib/pq"
+)
+
+const playlistColumns = "id, name, owner, description, visibility, created_at"
+
+func (r *ApiRepository) AddPlaylist(ctx context.Context, playlist *models.Playlist) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO playlists (library_id, name, owner, description, visibility)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		r.libraryID, playlist.Name, playlist.Owner, playlist.Description, playlist.Visibility,
+	).Scan(&playlist.ID, &playlist.CreatedAt)
+	if err != nil {
+		logger.Error("Error inserting playlist: ", err)
+		return err
+	}
+
+	logger.Infof("Playlist '%s' created", playlist.Name)
+	return nil
+}
+
+func (r *ApiRepository) GetPlaylist(ctx context.Context, id int) (*models.Playlist, error) {
+	ctx, done := r.trace(ctx, "get_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var playlist models.Playlist
+	err := r.db.QueryRowContext(ctx, "SELECT "+playlistColumns+" FROM playlists WHERE id = $1 AND library_id = $2", id, r.libraryID).Scan(
+		&playlist.ID, &playlist.Name, &playlist.Owner, &playlist.Description, &playlist.Visibility, &playlist.CreatedAt,
+	)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error fetching playlist: ", err)
+		}
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+func (r *ApiRepository) GetPlaylists(ctx context.Context, viewer string, limit int, offset int) ([]models.Playlist, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_playlists")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, "SELECT "+playlistColumns+` FROM playlists
+		WHERE library_id = $1 AND ($2 = '' OR owner = $2 OR visibility = 'public')
+		ORDER BY id LIMIT $3 OFFSET $4`, r.libraryID, viewer, limit, offset)
+	if err != nil {
+		logger.Error("Error executing GetPlaylists query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playlists []models.Playlist
+	for rows.Next() {
+		var playlist models.Playlist
+		if err := rows.Scan(&playlist.ID, &playlist.Name, &playlist.Owner, &playlist.Description, &playlist.Visibility, &playlist.CreatedAt); err != nil {
+			logger.Error("Error scanning GetPlaylists rows: ", err)
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+
+	return playlists, rows.Err()
+}
+
+func (r *ApiRepository) UpdatePlaylist(ctx context.Context, playlist *models.Playlist) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "update_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE playlists SET name = $1, description = $2, visibility = $3
+		WHERE id = $4 AND library_id = $5`,
+		playlist.Name, playlist.Description, playlist.Visibility, playlist.ID, r.libraryID,
+	)
+	if err != nil {
+		logger.Error("Error updating playlist: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) DeletePlaylist(ctx context.Context, id int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM playlists WHERE id = $1 AND library_id = $2`, id, r.libraryID)
+	if err != nil {
+		logger.Error("Error deleting playlist: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) GetPlaylistEntries(ctx context.Context, playlistID int) ([]models.PlaylistEntry, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_playlist_entries")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT e.id, e.position, e.song_id, s.group_name, s.song_name, COALESCE(s.link, ''),
+			COALESCE(e.added_by, ''), e.added_at
+		FROM playlist_entries e
+		JOIN playlists p ON p.id = e.playlist_id
+		JOIN songs s ON s.id = e.song_id AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		WHERE e.playlist_id = $1 AND p.library_id = $2
+		ORDER BY e.position, e.id`, playlistID, r.libraryID)
+	if err != nil {
+		logger.Error("Error executing GetPlaylistEntries query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.PlaylistEntry
+	for rows.Next() {
+		var entry models.PlaylistEntry
+		if err := rows.Scan(&entry.ID, &entry.Position, &entry.SongID, &entry.Group, &entry.Song, &entry.Link, &entry.AddedBy, &entry.AddedAt); err != nil {
+			logger.Error("Error scanning GetPlaylistEntries rows: ", err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (r *ApiRepository) GetPlaylistEntryIDs(ctx context.Context, playlistID int) ([]int, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_playlist_entry_ids")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT e.id
+		FROM playlist_entries e JOIN playlists p ON p.id = e.playlist_id
+		WHERE e.playlist_id = $1 AND p.library_id = $2
+		ORDER BY e.position, e.id`, playlistID, r.libraryID)
+	if err != nil {
+		logger.Error("Error executing GetPlaylistEntryIDs query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			logger.Error("Error scanning GetPlaylistEntryIDs rows: ", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// AddPlaylistEntry appends the entry to the playlist. The song must belong
+// to the repository's library.
+func (r *ApiRepository) AddPlaylistEntry(ctx context.Context, entry *models.PlaylistEntry, playlistID int) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_playlist_entry")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO playlist_entries (playlist_id, song_id, position, added_by)
+		SELECT p.id, s.id, (SELECT COALESCE(MAX(position), 0) + 1 FROM playlist_entries WHERE playlist_id = p.id), NULLIF($3, '')
+		FROM playlists p JOIN songs s ON s.library_id = p.library_id AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		WHERE p.id = $1 AND s.id = $2 AND p.library_id = $4
+		RETURNING id, position, added_at`,
+		playlistID, entry.SongID, entry.AddedBy, r.libraryID,
+	).Scan(&entry.ID, &entry.Position, &entry.AddedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error inserting playlist entry: ", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) DeletePlaylistEntry(ctx context.Context, playlistID, entryID int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_playlist_entry")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM playlist_entries
+		WHERE id = $1 AND playlist_id = (SELECT id FROM playlists WHERE id = $2 AND library_id = $3)`,
+		entryID, playlistID, r.libraryID,
+	)
+	if err != nil {
+		logger.Error("Error deleting playlist entry: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) SetPlaylistPositions(ctx context.Context, playlistID int, entryIDs []int) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "set_playlist_positions")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	// Touching the playlist makes concurrent changes to it conflict, so that
+	// one of them is retried. Two appends would otherwise both take the same
+	// free position, which the deferred constraint only rejects on commit.
+	result, err := r.db.ExecContext(ctx, `UPDATE playlists SET id = id WHERE id = $1 AND library_id = $2`, playlistID, r.libraryID)
+	if err != nil {
+		logger.Error("Error locking playlist: ", err)
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	ids := make([]int64, len(entryIDs))
+	for i, id := range entryIDs {
+		ids[i] = int64(id)
+	}
+
+	_, err = r.db.ExecContext(ctx, `UPDATE playlist_entries e SET position = v.position
+		FROM unnest($1::int[]) WITH ORDINALITY AS v(id, position)
+		WHERE e.id = v.id AND e.playlist_id = $2`,
+		pq.Array(ids), playlistID,
+	)
+	if err != nil {
+		logger.Error("Error rewriting playlist positions: ", err)
+	}
+	return err
+}
@@ -1,28 +1,327 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"time"
 
+	"github.com/VadimBorzenkov/online-song-library/internal/metrics"
 	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var ErrLibraryRequired = errors.New("repository is not scoped to a library")
+
+// Repository gives access to the songs, playlists, favorites, plays,
+// reviews, annotations, tags and translations of a single library.
+// Use ForLibrary to obtain a scoped copy; every query on it is restricted to
+// that library.
 type Repository interface {
-	GetData(filter map[string]string, limit int, offset int) ([]models.Song, error)
-	GetSongPagi(id int, limit int, offset int) (*models.Song, error)
-	DeleteSong(id int) (int64, error)
-	UpdateSongData(song *models.Song) error
-	AddNewSong(song *models.Song) error
+	AuditWriter
+	PlaylistRepository
+	ListeningRepository
+	ReviewRepository
+	AnnotationRepository
+	TagRepository
+	TranslationRepository
+	ForLibrary(libraryID int) Repository
+	// WithoutExplicit returns a copy of the repository to which explicit
+	// songs do not exist when reading: they are left out of listings,
+	// favorites, plays, playlists and tag counts, and reading one song, its
+	// annotations, reviews, tags or translations finds nothing.
+	WithoutExplicit() Repository
+	// GetData lists songs matching filter in the order named by sort, see
+	// songSortOrders.
+	GetData(ctx context.Context, filter map[string]string, sort string, limit int, offset int) ([]models.Song, error)
+	GetSong(ctx context.Context, id int) (*models.Song, error)
+	GetSongPagi(ctx context.Context, id int, limit int, offset int) (*models.Song, error)
+	DeleteSong(ctx context.Context, id int) (int64, error)
+	// MergeSongs merges the source songs into the target song: their plays,
+	// play counts, playlist entries, favorites, reviews and tags go to the
+	// target and the sources to the trash. Of the reviews of an actor, the
+	// one of the target or else the most recent one of the sources wins; the
+	// others, like annotations and translations, which are anchored to the
+	// text of their song, stay with the trashed sources. Sources that are
+	// not live songs of the library are left out, and nothing is merged if
+	// the target is not one. It returns the IDs of the merged songs and
+	// leaves the target's rating as is.
+	MergeSongs(ctx context.Context, targetID int, sourceIDs []int) ([]int, error)
+	UpdateSongData(ctx context.Context, song *models.Song) error
+	AddNewSong(ctx context.Context, song *models.Song) error
+	PurgeDeletedSongs(ctx context.Context, before time.Time) ([]int, error)
+	// DetectSongLanguages detects the language of songs whose text was
+	// written before languages were detected on writes and returns those
+	// it could tell one for, with only ID, Language and LanguageConfidence
+	// set.
+	DetectSongLanguages(ctx context.Context) ([]models.Song, error)
+	// DetectExplicitSongs checks the text of the library's songs, trashed
+	// ones included, and of their translations with contains unless the
+	// library was last checked against the wordlist with the same
+	// fingerprint, and returns the songs whose verdict changed, with only
+	// ID and Explicit, the new verdict, set.
+	DetectExplicitSongs(ctx context.Context, fingerprint string, contains func(text string) bool) ([]models.Song, error)
+	// SetExplicitDetected sets the explicit flag the wordlist gave, e.g.
+	// after a translation of the song changed.
+	SetExplicitDetected(ctx context.Context, id int, detected bool) (int64, error)
+	// SetExplicitOverride sets or, with nil, clears the explicit flag set by
+	// hand, which wins over the one the wordlist gave.
+	SetExplicitOverride(ctx context.Context, id int, override *bool) (int64, error)
+	WithTx(ctx context.Context, fn func(repo Repository) error) error
+	WithTxOptions(ctx context.Context, opts TxOptions, fn func(repo Repository) error) error
+}
+
+// PlaylistRepository is the playlist part of Repository. Entries of songs in
+// the trash are left out, except by GetPlaylistEntryIDs. Positions are
+// unique within a playlist and kept dense by the caller, which rewrites them
+// with SetPlaylistPositions after every change.
+type PlaylistRepository interface {
+	AddPlaylist(ctx context.Context, playlist *models.Playlist) error
+	GetPlaylist(ctx context.Context, id int) (*models.Playlist, error)
+	// GetPlaylists lists public playlists and those owned by viewer, or all
+	// playlists if viewer is empty.
+	GetPlaylists(ctx context.Context, viewer string, limit int, offset int) ([]models.Playlist, error)
+	UpdatePlaylist(ctx context.Context, playlist *models.Playlist) (int64, error)
+	DeletePlaylist(ctx context.Context, id int) (int64, error)
+	GetPlaylistEntries(ctx context.Context, playlistID int) ([]models.PlaylistEntry, error)
+	// GetPlaylistEntryIDs lists every entry of the playlist in order, those
+	// of songs in the trash or hidden from clean principals included.
+	GetPlaylistEntryIDs(ctx context.Context, playlistID int) ([]int, error)
+	// AddPlaylistEntry appends the entry after every other one and sets its
+	// position.
+	AddPlaylistEntry(ctx context.Context, entry *models.PlaylistEntry, playlistID int) error
+	DeletePlaylistEntry(ctx context.Context, playlistID, entryID int) (int64, error)
+	// SetPlaylistPositions numbers the given entries 1, 2, ... in order. It
+	// must be given every entry of the playlist, or the positions collide.
+	SetPlaylistPositions(ctx context.Context, playlistID int, entryIDs []int) error
+}
+
+// ListeningRepository keeps the favorites and plays of actors. Songs in the
+// trash are left out of every listing.
+type ListeningRepository interface {
+	// AddFavorite marks the song as a favorite of actor; marking it again
+	// is a no-op. It returns sql.ErrNoRows if the song is not in the library.
+	AddFavorite(ctx context.Context, actor string, songID int) error
+	DeleteFavorite(ctx context.Context, actor string, songID int) (int64, error)
+	// GetFavorites lists the favorites of actor, most recently added first.
+	GetFavorites(ctx context.Context, actor string, limit int, offset int) ([]models.Song, error)
+	// AddPlay records a play of play.SongID by actor and increments the
+	// song's play count. It returns sql.ErrNoRows if the song is not in the
+	// library.
+	AddPlay(ctx context.Context, play *models.Play, actor string) error
+	// GetPlays lists the plays of actor, newest first.
+	GetPlays(ctx context.Context, actor string, limit int, offset int) ([]models.Play, error)
+	// GetTopSongs and GetTopGroups count the plays of actor since the given
+	// time, or of all time if it is zero, most played first.
+	GetTopSongs(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopSong, error)
+	GetTopGroups(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopGroup, error)
+}
+
+// ReviewRepository keeps song reviews, at most one per actor and song.
+// Reviews of songs in the trash are left out. Writes leave the song's rating
+// as is; the caller runs RefreshSongRating in the same transaction.
+type ReviewRepository interface {
+	// SaveReview adds the review of review.Actor for review.SongID or
+	// replaces its rating, body and status. It returns sql.ErrNoRows if the
+	// song is not in the library.
+	SaveReview(ctx context.Context, review *models.Review) error
+	GetReview(ctx context.Context, id int) (*models.Review, error)
+	// GetReviews lists the reviews matching filter, most recently updated
+	// first.
+	GetReviews(ctx context.Context, filter models.ReviewFilter, limit int, offset int) ([]models.Review, error)
+	DeleteReview(ctx context.Context, actor string, songID int) (int64, error)
+	SetReviewStatus(ctx context.Context, id int, status string) (int64, error)
+	// RefreshSongRating recomputes the song's rating and rating count from
+	// its approved reviews.
+	RefreshSongRating(ctx context.Context, songID int) error
+}
+
+// AnnotationRepository keeps annotations of song texts. Annotations of songs
+// in the trash are left out.
+type AnnotationRepository interface {
+	// AddAnnotation returns sql.ErrNoRows if the song is not in the library.
+	AddAnnotation(ctx context.Context, annotation *models.Annotation) error
+	GetAnnotation(ctx context.Context, id int) (*models.Annotation, error)
+	// GetAnnotations lists the annotations of the song in text order.
+	GetAnnotations(ctx context.Context, songID int) ([]models.Annotation, error)
+	// UpdateAnnotation saves the anchor, quote, body and orphaned flag.
+	UpdateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error)
+	DeleteAnnotation(ctx context.Context, id int) (int64, error)
+	// SetAnnotationVote records the vote of actor, 1 or -1, or withdraws it
+	// if value is 0, and updates the annotation's vote total.
+	SetAnnotationVote(ctx context.Context, id int, actor string, value int) error
+}
+
+// TagRepository keeps the tags of a library and the songs they classify.
+// Songs in the trash are left out.
+type TagRepository interface {
+	// GetTags lists the tags of the library of the given type, or of all
+	// types if tagType is empty, with the number of songs they classify.
+	GetTags(ctx context.Context, tagType string) ([]models.TagCount, error)
+	// GetSongTags returns the tags of each of the songs that is in the
+	// library, sorted by type and name; songs without tags map to an empty
+	// list.
+	GetSongTags(ctx context.Context, songIDs []int) (map[int][]models.Tag, error)
+	// TagSongs adds the tags, creating them as needed, to the songs and
+	// returns how many song tags were added.
+	TagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error)
+	// UntagSongs removes the tags from the songs and returns how many song
+	// tags were removed. Tags left without songs are kept.
+	UntagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error)
+	// GetSongFacets counts the songs matching filter per tag, release year
+	// and group, keeping the limit most frequent groups.
+	GetSongFacets(ctx context.Context, filter map[string]string, limit int) (*models.SongFacets, error)
+}
+
+// TranslationRepository keeps translations of song texts, at most one per
+// song and language. Translations of songs in the trash are left out.
+type TranslationRepository interface {
+	// SaveTranslation adds the translation of translation.SongID into
+	// translation.Language or replaces its text, translator and alignment.
+	// It returns sql.ErrNoRows if the song is not in the library.
+	SaveTranslation(ctx context.Context, translation *models.Translation) error
+	// GetTranslation returns sql.ErrNoRows if the song has no translation
+	// into language.
+	GetTranslation(ctx context.Context, songID int, language string) (*models.Translation, error)
+	// GetTranslations lists the translations of the song by language.
+	GetTranslations(ctx context.Context, songID int) ([]models.Translation, error)
+	DeleteTranslation(ctx context.Context, songID int, language string) (int64, error)
+}
+
+type KeyRepository interface {
+	AddApiKey(ctx context.Context, key *models.ApiKey) error
+	GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error)
+	RevokeApiKey(ctx context.Context, id int) (int64, error)
+}
+
+// UserRepository keeps user accounts and their login sessions. Users are not
+// bound to a library.
+type UserRepository interface {
+	AddUser(ctx context.Context, user *models.User) error
+	GetUserByName(ctx context.Context, username string) (*models.User, error)
+	AddSession(ctx context.Context, session *models.Session) error
+	// GetSessionByHash returns the session with the user's name and role.
+	GetSessionByHash(ctx context.Context, hash string) (*models.Session, error)
+	RevokeSession(ctx context.Context, id int) (int64, error)
+}
+
+// AuthRepository is what authentication needs: api keys, users and
+// sessions.
+type AuthRepository interface {
+	KeyRepository
+	UserRepository
+}
+
+// AuditWriter appends audit events. Repository and LibraryRepository include
+// it so that an event is written in the transaction of the mutation it
+// records.
+type AuditWriter interface {
+	AddAuditEvent(ctx context.Context, event *models.AuditEvent) error
+}
+
+type AuditRepository interface {
+	AuditWriter
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int, offset int) ([]models.AuditEvent, error)
+	StreamAuditEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error
+}
+
+type LibraryRepository interface {
+	AuditWriter
+	AddLibrary(ctx context.Context, library *models.Library) error
+	GetLibraryBySlug(ctx context.Context, slug string) (*models.Library, error)
+	GetLibraries(ctx context.Context) ([]models.Library, error)
+	// CopySongs copies the songs, or all songs if songIDs is empty, of one
+	// library into another as created and last updated by actor.
+	CopySongs(ctx context.Context, fromID, toID int, songIDs []int, actor string) (int64, error)
+	GetSongStats(ctx context.Context) ([]models.SongStats, error)
+	// WithLibraryTx runs fn with a repository bound to a new transaction,
+	// like WithTx.
+	WithLibraryTx(ctx context.Context, fn func(repo LibraryRepository) error) error
+}
+
+// Store is all the storage the services need. ApiRepository (Postgres),
+// SqliteRepository and MemoryRepository implement it.
+type Store interface {
+	Repository
+	KeyRepository
+	UserRepository
+	AuditRepository
+	LibraryRepository
 }
 
 type ApiRepository struct {
-	db     *sql.DB
-	logger *logrus.Logger
+	// db is the primary, or the transaction when inTx is set.
+	db dbtx
+	// replica serves song listings, which tolerate replication lag. It is
+	// db itself when no replica is configured or inside a transaction.
+	replica dbtx
+	// pool is the primary connection pool transactions are started on.
+	pool      *sql.DB
+	logger    *logrus.Logger
+	libraryID int
+	inTx      bool
+	// clean is set on repositories returned by WithoutExplicit.
+	clean bool
+}
+
+// NewApiRepository creates a repository on db. replica may be nil.
+func NewApiRepository(db, replica *sql.DB, logger *logrus.Logger) *ApiRepository {
+	if replica == nil {
+		replica = db
+	}
+
+	return &ApiRepository{
+		db:      db,
+		replica: replica,
+		pool:    db,
+		logger:  logger,
+	}
 }
 
-func NewApiRepository(db *sql.DB, logger *logrus.Logger) *ApiRepository {
+func (r *ApiRepository) ForLibrary(libraryID int) Repository {
 	return &ApiRepository{
-		db:     db,
-		logger: logger,
+		db:        r.db,
+		replica:   r.replica,
+		pool:      r.pool,
+		logger:    r.logger,
+		libraryID: libraryID,
+		inTx:      r.inTx,
+		clean:     r.clean,
+	}
+}
+
+func (r *ApiRepository) WithoutExplicit() Repository {
+	clean := *r
+	clean.clean = true
+	return &clean
+}
+
+// hideExplicit returns the condition leaving explicit songs out of queries
+// on clean repositories, and nothing otherwise.
+func (r *ApiRepository) hideExplicit(alias string) string {
+	if !r.clean {
+		return ""
+	}
+	return notExplicit(alias)
+}
+
+// trace starts a span and a latency timer for a repository query. Call the
+// returned func once the query, including reading its rows, has finished.
+func (r *ApiRepository) trace(ctx context.Context, query string) (context.Context, func()) {
+	return traceQuery(ctx, "postgresql", query)
+}
+
+func traceQuery(ctx context.Context, system, query string) (context.Context, func()) {
+	ctx, span := tracing.Start(ctx, "repository."+query,
+		attribute.String("db.system", system),
+		attribute.String("db.operation", query),
+	)
+	done := metrics.TimeQuery(query)
+
+	return ctx, func() {
+		done()
+		span.End()
 	}
 }
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const reviewColumns = `r.id, r.song_id, r.actor, r.rating, r.body, r.status, r.created_at, r.updated_at`
+
+func scanReview(row interface{ Scan(...interface{}) error }, review *models.Review) error {
+	return row.Scan(&review.ID, &review.SongID, &review.Actor, &review.Rating, &review.Body, &review.Status, &review.CreatedAt, &review.UpdatedAt)
+}
+
+func (r *ApiRepository) SaveReview(ctx context.Context, review *models.Review) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "save_review")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO reviews (song_id, actor, rating, body, status)
+		SELECT id, $2, $3, $4, $5 FROM songs WHERE id = $1 AND library_id = $6 AND deleted_at IS NULL
+		ON CONFLICT (song_id, actor) DO UPDATE
+			SET rating = EXCLUDED.rating, body = EXCLUDED.body, status = EXCLUDED.status, updated_at = NOW()
+		RETURNING id, created_at, updated_at`,
+		review.SongID, review.Actor, review.Rating, review.Body, review.Status, r.libraryID,
+	).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error saving review: ", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_review")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var review models.Review
+	row := r.db.QueryRowContext(ctx, `SELECT `+reviewColumns+`
+		FROM reviews r JOIN songs s ON s.id = r.song_id
+		WHERE r.id = $1 AND s.library_id = $2 AND s.deleted_at IS NULL`+r.hideExplicit("s"), id, r.libraryID)
+	if err := scanReview(row, &review); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error fetching review: ", err)
+		}
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+func (r *ApiRepository) GetReviews(ctx context.Context, filter models.ReviewFilter, limit int, offset int) ([]models.Review, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_reviews")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	query := `SELECT ` + reviewColumns + `
+		FROM reviews r JOIN songs s ON s.id = r.song_id
+		WHERE s.library_id = $1 AND s.deleted_at IS NULL` + r.hideExplicit("s")
+	args := []interface{}{r.libraryID}
+
+	if filter.SongID != 0 {
+		args = append(args, filter.SongID)
+		query += fmt.Sprintf(" AND r.song_id = $%d", len(args))
+	}
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		query += fmt.Sprintf(" AND r.actor = $%d", len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(" AND r.status = $%d", len(args))
+	}
+
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY r.updated_at DESC, r.id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := r.replica.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error("Error executing GetReviews query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []models.Review
+	for rows.Next() {
+		var review models.Review
+		if err := scanReview(rows, &review); err != nil {
+			logger.Error("Error scanning GetReviews rows: ", err)
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+func (r *ApiRepository) DeleteReview(ctx context.Context, actor string, songID int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_review")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM reviews
+		WHERE actor = $1 AND song_id = (SELECT id FROM songs WHERE id = $2 AND library_id = $3 AND deleted_at IS NULL)`,
+		actor, songID, r.libraryID,
+	)
+	if err != nil {
+		logger.Error("Error deleting review: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) SetReviewStatus(ctx context.Context, id int, status string) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "set_review_status")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE reviews SET status = $2
+		WHERE id = $1 AND song_id IN (SELECT id FROM songs WHERE library_id = $3 AND deleted_at IS NULL)`,
+		id, status, r.libraryID,
+	)
+	if err != nil {
+		logger.Error("Error updating review status: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) RefreshSongRating(ctx context.Context, songID int) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "refresh_song_rating")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	_, err := r.db.ExecContext(ctx, `UPDATE songs SET rating = COALESCE(stats.rating, 0), rating_count = stats.count
+		FROM (
+			SELECT ROUND(AVG(rating), 2) AS rating, COUNT(*) AS count
+			FROM reviews WHERE song_id = $1 AND status = 'approved'
+		) stats
+		WHERE id = $1 AND library_id = $2`, songID, r.libraryID)
+	if err != nil {
+		logger.Error("Error refreshing song rating: ", err)
+		return err
+	}
+
+	return nil
+}
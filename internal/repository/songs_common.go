@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/pkg/langdetect"
+	"github.com/VadimBorzenkov/online-song-library/pkg/translit"
+)
+
+var ErrOffsetOutOfRange = errors.New("offset out of range")
+
+// Song filter keys accepted by GetData. release_date, language and explicit
+// (true or false) match exactly, min_rating and min_language_confidence keep songs with at least
+// the given number, tags keeps songs with the comma separated tags (all of
+// them, or any if tag_mode is "any"), and the others match
+// case-insensitively as ILIKE patterns. text also matches the lyrics
+// transliterated into the Latin script, see latinText.
+var songFilterColumns = map[string]bool{
+	"group_name":   true,
+	"song_name":    true,
+	"release_date": true,
+	"text":         true,
+	"link":         true,
+	"min_rating":   true,
+	"tags":         true,
+	"tag_mode":     true,
+	"language":     true,
+	// min_language_confidence is a number from 0 to 1.
+	"min_language_confidence": true,
+	"explicit":                true,
+}
+
+// Values of the tag_mode filter.
+const (
+	TagModeAll = "all"
+	TagModeAny = "any"
+)
+
+func checkSongFilter(filter map[string]string) error {
+	for key := range filter {
+		if !songFilterColumns[key] {
+			return fmt.Errorf("unknown song filter %q", key)
+		}
+	}
+	if value, ok := filter["min_rating"]; ok {
+		if _, err := minRating(value); err != nil {
+			return err
+		}
+	}
+	if value, ok := filter["min_language_confidence"]; ok {
+		if _, err := minLanguageConfidence(value); err != nil {
+			return err
+		}
+	}
+	if value, ok := filter["explicit"]; ok {
+		if _, err := explicitFilter(value); err != nil {
+			return err
+		}
+	}
+	if _, _, err := tagFilter(filter); err != nil {
+		return err
+	}
+	return nil
+}
+
+// tagFilter returns the tags of the filter and whether songs must have all
+// of them. There are no tags if the filter has none.
+func tagFilter(filter map[string]string) ([]models.Tag, bool, error) {
+	all := true
+	switch mode := filter["tag_mode"]; mode {
+	case "", TagModeAll:
+	case TagModeAny:
+		all = false
+	default:
+		return nil, false, fmt.Errorf("invalid tag_mode %q: must be %s or %s", mode, TagModeAll, TagModeAny)
+	}
+
+	value, ok := filter["tags"]
+	if !ok {
+		return nil, all, nil
+	}
+	tags, err := ParseTags(value)
+	return tags, all, err
+}
+
+// ParseTag parses a tag written as type:name. The name is trimmed and
+// lowercased.
+func ParseTag(value string) (models.Tag, error) {
+	tagType, name, ok := strings.Cut(value, ":")
+	tag := models.Tag{Type: strings.ToLower(strings.TrimSpace(tagType)), Name: strings.ToLower(strings.TrimSpace(name))}
+
+	switch {
+	case !ok || tag.Name == "":
+		return tag, fmt.Errorf("invalid tag %q: must be type:name", value)
+	case utf8.RuneCountInString(tag.Name) > maxTagName:
+		return tag, fmt.Errorf("invalid tag %q: name is longer than %d characters", value, maxTagName)
+	}
+	switch tag.Type {
+	case models.TagGenre, models.TagMood, models.TagLanguage, models.TagEra:
+		return tag, nil
+	}
+	return tag, fmt.Errorf("invalid tag %q: type must be %s, %s, %s or %s", value, models.TagGenre, models.TagMood, models.TagLanguage, models.TagEra)
+}
+
+// ParseTags parses a comma separated list of tags, dropping duplicates.
+func ParseTags(value string) ([]models.Tag, error) {
+	var tags []models.Tag
+	seen := map[models.Tag]bool{}
+	for _, part := range strings.Split(value, ",") {
+		tag, err := ParseTag(part)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+const maxTagName = 100
+
+// minRating parses the value of the min_rating filter.
+func minRating(value string) (float64, error) {
+	rating, err := strconv.ParseFloat(value, 64)
+	if err != nil || rating < 0 || rating > 5 {
+		return 0, fmt.Errorf("invalid min_rating %q: must be a number from 0 to 5", value)
+	}
+	return rating, nil
+}
+
+// minLanguageConfidence parses the value of the min_language_confidence
+// filter.
+func minLanguageConfidence(value string) (float64, error) {
+	confidence, err := strconv.ParseFloat(value, 64)
+	if err != nil || confidence < 0 || confidence > 1 {
+		return 0, fmt.Errorf("invalid min_language_confidence %q: must be a number from 0 to 1", value)
+	}
+	return confidence, nil
+}
+
+// notExplicit is the condition that leaves explicit songs out of a query on
+// songs, see Repository.WithoutExplicit. alias is the name the query gives
+// the songs table, if any.
+func notExplicit(alias string) string {
+	if alias != "" {
+		alias += "."
+	}
+	return " AND NOT COALESCE(" + alias + "explicit_override, " + alias + "explicit_detected)"
+}
+
+// explicitFilter parses the value of the explicit filter.
+func explicitFilter(value string) (bool, error) {
+	explicit, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid explicit %q: must be true or false", value)
+	}
+	return explicit, nil
+}
+
+// explicitSong returns whether the song is explicit: its override if set,
+// and otherwise the verdict of the wordlist, which writes carry in Explicit.
+func explicitSong(song *models.Song) bool {
+	if song.ExplicitOverride != nil {
+		return *song.ExplicitOverride
+	}
+	return song.Explicit
+}
+
+// detectLanguage sets the language of the song from its text. Every
+// repository calls it when the text is written, so the stored language
+// always belongs to the stored text.
+func detectLanguage(song *models.Song) {
+	song.Language, song.LanguageConfidence = langdetect.Detect(song.Text)
+}
+
+// latinText returns text with Cyrillic letters written in Latin ones the
+// informal way, which is how lyrics in Latin letters are usually typed. The
+// text filter matches it with the transliterated pattern, so lyrics are
+// found whichever script either is written in.
+func latinText(text string) string {
+	latin, _ := translit.Transliterate(text, translit.Informal)
+	return latin
+}
+
+// songSortOrders maps the sort names GetData accepts to ORDER BY clauses. A
+// leading minus sorts descending; ties are broken by ID.
+var songSortOrders = map[string]string{
+	"":            "id",
+	"id":          "id",
+	"-id":         "id DESC",
+	"play_count":  "play_count, id",
+	"-play_count": "play_count DESC, id",
+	"rating":      "rating, rating_count, id",
+	"-rating":     "rating DESC, rating_count DESC, id",
+}
+
+// songOrder returns the ORDER BY clause for sort.
+func songOrder(sort string) (string, error) {
+	order, ok := songSortOrders[sort]
+	if !ok {
+		return "", fmt.Errorf("unknown song sort %q", sort)
+	}
+	return order, nil
+}
+
+// verseSeparator separates verses in song texts.
+const verseSeparator = "\n\n"
+
+// SplitVerses splits a song text into the verses GetSongPagi pages through
+// and annotations are anchored to.
+func SplitVerses(text string) []string {
+	return strings.Split(text, verseSeparator)
+}
+
+// sliceVerses returns limit verses of text starting at verse offset.
+func sliceVerses(text string, limit, offset int) (string, int, error) {
+	verses := SplitVerses(text)
+
+	if offset >= len(verses) {
+		return "", 0, ErrOffsetOutOfRange
+	}
+
+	end := offset + limit
+	if end > len(verses) {
+		end = len(verses)
+	}
+
+	return strings.Join(verses[offset:end], verseSeparator), end - offset, nil
+}
+
+// releaseDateLayouts are the inputs the release_date DATE column accepts in
+// the Postgres repository, which keeps only the date part.
+var releaseDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// normalizeDate converts a release date to the YYYY-MM-DD form the Postgres
+// repository returns. An empty date stays empty (NULL).
+func normalizeDate(date string) (string, error) {
+	if date == "" {
+		return "", nil
+	}
+	for _, layout := range releaseDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid input syntax for type date: %q", date)
+}
+
+// likeMatch reports whether s matches the ILIKE pattern: % matches any
+// sequence, _ any single character and a backslash escapes the next one,
+// all compared case-insensitively.
+func likeMatch(pattern, s string) bool {
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			expr.WriteString(".*")
+		case r == '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.MustCompile(expr.String()).MatchString(s)
+}
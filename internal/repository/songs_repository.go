@@ -1,101 +1,278 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
 	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/lib/pq"
 )
 
-func (repo *ApiRepository) GetData(filter map[string]string, limit int, offset int) ([]models.Song, error) {
-	var songs []models.Song
-	query := "SELECT id, group_name, song_name, COALESCE(release_date::text, '') AS release_date, COALESCE(text, '') AS text, COALESCE(link, '') AS link FROM songs WHERE 1=1"
-	args := []interface{}{}
+func (repo *ApiRepository) GetData(ctx context.Context, filter map[string]string, sort string, limit int, offset int) ([]models.Song, error) {
+	logger := log.FromContext(ctx, repo.logger)
 
-	i := 1
-	for key, value := range filter {
-		if key == "release_date" {
-			query += fmt.Sprintf(" AND release_date = $%d", i)
-		} else {
-			query += fmt.Sprintf(" AND %s ILIKE $%d", key, i)
-		}
-		args = append(args, value)
-		i++
+	ctx, done := repo.trace(ctx, "get_data")
+	defer done()
+
+	if repo.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	if err := checkSongFilter(filter); err != nil {
+		return nil, err
+	}
+	order, err := songOrder(sort)
+	if err != nil {
+		return nil, err
 	}
 
-	query += fmt.Sprintf(" LIMIT $%d OFFSET $%d", i, i+1)
+	var songs []models.Song
+	query := "SELECT id, group_name, song_name, COALESCE(release_date::text, '') AS release_date, COALESCE(text, '') AS text, COALESCE(link, '') AS link, COALESCE(created_by, '') AS created_by, COALESCE(updated_by, '') AS updated_by, play_count, rating, rating_count, language, language_confidence, COALESCE(explicit_override, explicit_detected), explicit_override FROM songs WHERE library_id = $1 AND deleted_at IS NULL" + repo.hideExplicit("")
+	args := []interface{}{repo.libraryID}
+
+	conditions, args := songConditions(filter, args)
+	query += conditions + fmt.Sprintf(" ORDER BY %s LIMIT $%d OFFSET $%d", order, len(args)+1, len(args)+2)
 	args = append(args, limit, offset)
 
-	rows, err := repo.db.Query(query, args...)
+	rows, err := repo.replica.QueryContext(ctx, query, args...)
 	if err != nil {
-		repo.logger.Error("Error executing GetData query: ", err)
+		logger.Error("Error executing GetData query: ", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var song models.Song
-		if err := rows.Scan(&song.ID, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link); err != nil {
-			repo.logger.Error("Error scanning GetData rows: ", err)
+		if err := rows.Scan(&song.ID, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link, &song.CreatedBy, &song.UpdatedBy, &song.PlayCount, &song.Rating, &song.RatingCount, &song.Language, &song.LanguageConfidence, &song.Explicit, &song.ExplicitOverride); err != nil {
+			logger.Error("Error scanning GetData rows: ", err)
 			return nil, err
 		}
 		songs = append(songs, song)
 	}
 
-	repo.logger.Infof("Successfully fetched %d songs", len(songs))
+	logger.Infof("Successfully fetched %d songs", len(songs))
 	return songs, nil
 }
 
-func (repo *ApiRepository) GetSongPagi(id int, limit int, offset int) (*models.Song, error) {
+// songConditions returns the conditions of a checked song filter, to follow
+// a WHERE clause on songs, and args with their arguments appended.
+func songConditions(filter map[string]string, args []interface{}) (string, []interface{}) {
+	var query string
+	for key, value := range filter {
+		switch key {
+		case "tags", "tag_mode":
+			continue
+		case "release_date":
+			query += fmt.Sprintf(" AND release_date = $%d", len(args)+1)
+		case "min_rating":
+			query += fmt.Sprintf(" AND rating >= $%d", len(args)+1)
+		case "language":
+			query += fmt.Sprintf(" AND language = $%d", len(args)+1)
+		case "min_language_confidence":
+			query += fmt.Sprintf(" AND language_confidence >= $%d", len(args)+1)
+		case "explicit":
+			query += fmt.Sprintf(" AND COALESCE(explicit_override, explicit_detected) = $%d", len(args)+1)
+		case "text":
+			query += fmt.Sprintf(" AND (text ILIKE $%d OR text_latin ILIKE $%d)", len(args)+1, len(args)+2)
+			args = append(args, value, latinText(value))
+			continue
+		default:
+			query += fmt.Sprintf(" AND %s ILIKE $%d", key, len(args)+1)
+		}
+		args = append(args, value)
+	}
+
+	tags, all, _ := tagFilter(filter)
+	if len(tags) == 0 {
+		return query, args
+	}
+
+	pairs := make([]string, len(tags))
+	for i, tag := range tags {
+		pairs[i] = fmt.Sprintf("($%d, $%d)", len(args)+1, len(args)+2)
+		args = append(args, tag.Type, tag.Name)
+	}
+	query += " AND id IN (SELECT st.song_id FROM song_tags st JOIN tags t ON t.id = st.tag_id WHERE (t.type, t.name) IN (" + strings.Join(pairs, ", ") + ")"
+	if all {
+		query += fmt.Sprintf(" GROUP BY st.song_id HAVING COUNT(*) = %d", len(tags))
+	}
+	return query + ")", args
+}
+
+func (repo *ApiRepository) GetSong(ctx context.Context, id int) (*models.Song, error) {
+	logger := log.FromContext(ctx, repo.logger)
+
+	ctx, done := repo.trace(ctx, "get_song")
+	defer done()
+
+	if repo.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
 	var song models.Song
-	err := repo.db.QueryRow("SELECT id, group_name, song_name, release_date, text, link FROM songs WHERE id = $1", id).Scan(
-		&song.ID, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link,
+	err := repo.db.QueryRowContext(ctx, "SELECT id, group_name, song_name, COALESCE(release_date::text, ''), COALESCE(text, ''), COALESCE(link, ''), COALESCE(created_by, ''), COALESCE(updated_by, ''), play_count, rating, rating_count, language, language_confidence, COALESCE(explicit_override, explicit_detected), explicit_override FROM songs WHERE id = $1 AND library_id = $2 AND deleted_at IS NULL"+repo.hideExplicit(""), id, repo.libraryID).Scan(
+		&song.ID, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link, &song.CreatedBy, &song.UpdatedBy, &song.PlayCount, &song.Rating, &song.RatingCount, &song.Language, &song.LanguageConfidence, &song.Explicit, &song.ExplicitOverride,
 	)
 	if err != nil {
-		repo.logger.Error("Error fetching song for pagination: ", err)
+		logger.Error("Error fetching song: ", err)
 		return nil, err
 	}
 
-	verses := strings.Split(song.Text, "\n\n")
+	return &song, nil
+}
+
+func (repo *ApiRepository) GetSongPagi(ctx context.Context, id int, limit int, offset int) (*models.Song, error) {
+	logger := log.FromContext(ctx, repo.logger)
+
+	ctx, done := repo.trace(ctx, "get_song_pagi")
+	defer done()
 
-	if offset >= len(verses) {
-		repo.logger.Warn("Offset out of range for GetSongPagi")
-		return nil, errors.New("offset out of range")
+	if repo.libraryID == 0 {
+		return nil, ErrLibraryRequired
 	}
 
-	end := offset + limit
-	if end > len(verses) {
-		end = len(verses)
+	var song models.Song
+	err := repo.replica.QueryRowContext(ctx, "SELECT id, group_name, song_name, COALESCE(release_date::text, ''), COALESCE(text, ''), COALESCE(link, ''), COALESCE(created_by, ''), COALESCE(updated_by, ''), play_count, rating, rating_count, language, language_confidence, COALESCE(explicit_override, explicit_detected), explicit_override FROM songs WHERE id = $1 AND library_id = $2 AND deleted_at IS NULL"+repo.hideExplicit(""), id, repo.libraryID).Scan(
+		&song.ID, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link, &song.CreatedBy, &song.UpdatedBy, &song.PlayCount, &song.Rating, &song.RatingCount, &song.Language, &song.LanguageConfidence, &song.Explicit, &song.ExplicitOverride,
+	)
+	if err != nil {
+		logger.Error("Error fetching song for pagination: ", err)
+		return nil, err
+	}
+
+	var verses int
+	song.Text, verses, err = sliceVerses(song.Text, limit, offset)
+	if err != nil {
+		logger.Warn("Offset out of range for GetSongPagi")
+		return nil, err
 	}
 
-	song.Text = strings.Join(verses[offset:end], "\n\n")
-	repo.logger.Infof("Returning %d verses from song '%s'", end-offset, song.Song)
+	logger.Infof("Returning %d verses from song '%s'", verses, song.Song)
 	return &song, nil
 }
 
-func (r *ApiRepository) DeleteSong(id int) (int64, error) {
-	result, err := r.db.Exec("DELETE FROM songs WHERE id = $1", id)
+func (r *ApiRepository) DeleteSong(ctx context.Context, id int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_song")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	// Deleted songs go to the trash; PurgeDeletedSongs removes them for good.
+	result, err := r.db.ExecContext(ctx, "UPDATE songs SET deleted_at = NOW() WHERE id = $1 AND library_id = $2 AND deleted_at IS NULL", id, r.libraryID)
 	if err != nil {
-		r.logger.Error("Error deleting song: ", err)
+		logger.Error("Error deleting song: ", err)
 		return 0, err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		r.logger.Error("Error fetching rows affected: ", err)
+		logger.Error("Error fetching rows affected: ", err)
 		return 0, err
 	}
 
 	if rowsAffected == 0 {
-		r.logger.Warnf("No song found with ID %d", id)
+		logger.Warnf("No song found with ID %d", id)
 	}
 
 	return rowsAffected, nil
 }
 
-func (r *ApiRepository) UpdateSongData(song *models.Song) error {
+// mergeSongStatements move what belongs to the songs $2 to the song $1
+// once they are trashed. Of the reviews of an actor, the one of the target
+// or else the most recent one of the sources wins.
+var mergeSongStatements = []string{
+	`UPDATE songs SET play_count = play_count + (SELECT COALESCE(SUM(play_count), 0) FROM songs WHERE id = ANY($2::int[])) WHERE id = $1`,
+	`UPDATE songs SET play_count = 0 WHERE id = ANY($2::int[])`,
+	`UPDATE plays SET song_id = $1 WHERE song_id = ANY($2::int[])`,
+	`UPDATE playlist_entries SET song_id = $1 WHERE song_id = ANY($2::int[])`,
+	`INSERT INTO favorites (actor, song_id, created_at)
+		SELECT actor, $1, MIN(created_at) FROM favorites WHERE song_id = ANY($2::int[]) GROUP BY actor
+		ON CONFLICT (actor, song_id) DO NOTHING`,
+	`DELETE FROM favorites WHERE song_id = ANY($2::int[])`,
+	`UPDATE reviews r SET song_id = $1
+		WHERE r.song_id = ANY($2::int[]) AND NOT EXISTS (
+			SELECT 1 FROM reviews o WHERE o.actor = r.actor AND (o.song_id = $1
+				OR o.song_id = ANY($2::int[]) AND (o.updated_at, o.id) > (r.updated_at, r.id)))`,
+	`INSERT INTO song_tags (song_id, tag_id)
+		SELECT DISTINCT $1::int, tag_id FROM song_tags WHERE song_id = ANY($2::int[])
+		ON CONFLICT (song_id, tag_id) DO NOTHING`,
+}
+
+func (r *ApiRepository) MergeSongs(ctx context.Context, targetID int, sourceIDs []int) ([]int, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "merge_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var merged []int
+	err := r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*ApiRepository)
+		merged = nil
+
+		rows, err := tx.db.QueryContext(ctx, `UPDATE songs SET deleted_at = NOW()
+			WHERE id = ANY($1::int[]) AND id <> $2 AND library_id = $3 AND deleted_at IS NULL
+				AND EXISTS (SELECT 1 FROM songs WHERE id = $2 AND library_id = $3 AND deleted_at IS NULL)
+			RETURNING id`, pq.Array(sourceIDs), targetID, r.libraryID)
+		if err != nil {
+			logger.Error("Error trashing merged songs: ", err)
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			merged = append(merged, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(merged) == 0 {
+			return nil
+		}
+		sort.Ints(merged)
+
+		for _, statement := range mergeSongStatements {
+			if _, err := tx.db.ExecContext(ctx, statement, targetID, pq.Array(merged)); err != nil {
+				logger.Error("Error merging songs: ", err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Merged songs %v into song %d", merged, targetID)
+	return merged, nil
+}
+
+func (r *ApiRepository) UpdateSongData(ctx context.Context, song *models.Song) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "update_song")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
 	query := `UPDATE songs SET`
 	params := []interface{}{}
 	paramCounter := 1
@@ -113,9 +290,12 @@ func (r *ApiRepository) UpdateSongData(song *models.Song) error {
 	}
 
 	if song.Text != "" {
-		query += ` text = $` + strconv.Itoa(paramCounter) + `,`
-		params = append(params, song.Text)
-		paramCounter++
+		detectLanguage(song)
+		query += ` text = $` + strconv.Itoa(paramCounter) + `, language = $` + strconv.Itoa(paramCounter+1) +
+			`, language_confidence = $` + strconv.Itoa(paramCounter+2) + `, text_latin = $` + strconv.Itoa(paramCounter+3) +
+			`, explicit_detected = $` + strconv.Itoa(paramCounter+4) + `,`
+		params = append(params, song.Text, song.Language, song.LanguageConfidence, latinText(song.Text), song.Explicit)
+		paramCounter += 5
 	}
 
 	if song.Link != "" {
@@ -131,34 +311,248 @@ func (r *ApiRepository) UpdateSongData(song *models.Song) error {
 	}
 
 	if len(params) == 0 {
-		r.logger.Error("No fields to update")
+		logger.Error("No fields to update")
 		return errors.New("no fields to update")
 	}
 
-	query = query[:len(query)-1]
+	query += ` updated_by = $` + strconv.Itoa(paramCounter)
+	params = append(params, song.UpdatedBy)
+	paramCounter++
 
-	query += ` WHERE id = $` + strconv.Itoa(paramCounter)
-	params = append(params, song.ID)
+	query += ` WHERE id = $` + strconv.Itoa(paramCounter) + ` AND library_id = $` + strconv.Itoa(paramCounter+1) + ` AND deleted_at IS NULL`
+	params = append(params, song.ID, r.libraryID)
 
-	_, err := r.db.Exec(query, params...)
+	_, err := r.db.ExecContext(ctx, query, params...)
 	if err != nil {
-		r.logger.Error("Error updating song: ", err)
+		logger.Error("Error updating song: ", err)
 		return err
 	}
 
-	r.logger.Infof("Song with ID %d successfully updated", song.ID)
+	logger.Infof("Song with ID %d successfully updated by %s", song.ID, song.UpdatedBy)
 	return nil
 }
 
-func (r *ApiRepository) AddNewSong(song *models.Song) error {
-	_, err := r.db.Exec(`INSERT INTO songs (group_name, song_name, release_date, text, link) VALUES ($1, $2, $3, $4, $5)`,
-		song.Group, song.Song, song.ReleaseDate, song.Text, song.Link,
-	)
+func (r *ApiRepository) AddNewSong(ctx context.Context, song *models.Song) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_song")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	detectLanguage(song)
+	err := r.db.QueryRowContext(ctx, `INSERT INTO songs (library_id, group_name, song_name, release_date, text, link, created_by, updated_by, language, language_confidence, text_latin,
+			explicit_detected, explicit_override)
+		VALUES ($1, $2, $3, NULLIF($4, '')::date, $5, $6, $7, $7, $8, $9, $10, $11, $12) RETURNING id`,
+		r.libraryID, song.Group, song.Song, song.ReleaseDate, song.Text, song.Link, song.CreatedBy, song.Language, song.LanguageConfidence, latinText(song.Text),
+		song.Explicit, song.ExplicitOverride,
+	).Scan(&song.ID)
 	if err != nil {
-		r.logger.Error("Error inserting new song: ", err)
+		logger.Error("Error inserting new song: ", err)
 		return err
 	}
+	song.Explicit = explicitSong(song)
 
-	r.logger.Infof("New song '%s' by group '%s' added successfully", song.Song, song.Group)
+	logger.Infof("New song '%s' by group '%s' added successfully by %s", song.Song, song.Group, song.CreatedBy)
 	return nil
 }
+
+func (r *ApiRepository) SetExplicitOverride(ctx context.Context, id int, override *bool) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "set_explicit_override")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, "UPDATE songs SET explicit_override = $1 WHERE id = $2 AND library_id = $3 AND deleted_at IS NULL", override, id, r.libraryID)
+	if err != nil {
+		logger.Error("Error setting explicit override: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) SetExplicitDetected(ctx context.Context, id int, detected bool) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "set_explicit_detected")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, "UPDATE songs SET explicit_detected = $1 WHERE id = $2 AND library_id = $3", detected, id, r.libraryID)
+	if err != nil {
+		logger.Error("Error setting explicit flag: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) DetectSongLanguages(ctx context.Context) ([]models.Song, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "detect_song_languages")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	// Written text always has a transliteration, unless it was written
+	// before migration 17.
+	rows, err := r.db.QueryContext(ctx, "SELECT id, text FROM songs WHERE library_id = $1 AND COALESCE(text, '') <> '' AND text_latin = ''", r.libraryID)
+	if err != nil {
+		logger.Error("Error fetching songs without a language: ", err)
+		return nil, err
+	}
+	var songs []models.Song
+	for rows.Next() {
+		var song models.Song
+		if err := rows.Scan(&song.ID, &song.Text); err != nil {
+			rows.Close()
+			logger.Error("Error scanning songs without a language: ", err)
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		logger.Error("Error fetching songs without a language: ", err)
+		return nil, err
+	}
+
+	var detected []models.Song
+	for i := range songs {
+		song := &songs[i]
+		detectLanguage(song)
+		_, err := r.db.ExecContext(ctx, "UPDATE songs SET language = $1, language_confidence = $2, text_latin = $3 WHERE id = $4 AND library_id = $5",
+			song.Language, song.LanguageConfidence, latinText(song.Text), song.ID, r.libraryID)
+		if err != nil {
+			logger.Error("Error updating song language: ", err)
+			return nil, err
+		}
+		if song.Language != "" {
+			detected = append(detected, models.Song{ID: song.ID, Language: song.Language, LanguageConfidence: song.LanguageConfidence})
+		}
+	}
+
+	logger.Infof("Detected the language of %d of %d songs", len(detected), len(songs))
+	return detected, nil
+}
+
+func (r *ApiRepository) DetectExplicitSongs(ctx context.Context, fingerprint string, contains func(text string) bool) ([]models.Song, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "detect_explicit_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var checked string
+	if err := r.db.QueryRowContext(ctx, "SELECT explicit_wordlist FROM libraries WHERE id = $1", r.libraryID).Scan(&checked); err != nil {
+		logger.Error("Error fetching the library's explicit wordlist: ", err)
+		return nil, err
+	}
+	if checked == fingerprint {
+		return nil, nil
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT s.id, COALESCE(s.text, ''), s.explicit_detected,
+			COALESCE((SELECT string_agg(t.text, E'\n\n') FROM song_translations t WHERE t.song_id = s.id), '')
+		FROM songs s WHERE s.library_id = $1`, r.libraryID)
+	if err != nil {
+		logger.Error("Error fetching songs to check for explicit words: ", err)
+		return nil, err
+	}
+	var changed []models.Song
+	total := 0
+	for rows.Next() {
+		var song models.Song
+		var translations string
+		if err := rows.Scan(&song.ID, &song.Text, &song.Explicit, &translations); err != nil {
+			rows.Close()
+			logger.Error("Error scanning songs to check for explicit words: ", err)
+			return nil, err
+		}
+		total++
+		if explicit := contains(song.Text) || contains(translations); explicit != song.Explicit {
+			song.Explicit = explicit
+			changed = append(changed, song)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		logger.Error("Error fetching songs to check for explicit words: ", err)
+		return nil, err
+	}
+
+	// A song whose text changed meanwhile was checked when it was written.
+	var updated []models.Song
+	for _, song := range changed {
+		result, err := r.db.ExecContext(ctx, "UPDATE songs SET explicit_detected = $1 WHERE id = $2 AND library_id = $3 AND COALESCE(text, '') = $4",
+			song.Explicit, song.ID, r.libraryID, song.Text)
+		if err != nil {
+			logger.Error("Error updating explicit flag: ", err)
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			updated = append(updated, models.Song{ID: song.ID, Explicit: song.Explicit})
+		}
+	}
+
+	if _, err := r.db.ExecContext(ctx, "UPDATE libraries SET explicit_wordlist = $1 WHERE id = $2", fingerprint, r.libraryID); err != nil {
+		logger.Error("Error saving the library's explicit wordlist: ", err)
+		return nil, err
+	}
+
+	logger.Infof("Checked %d songs for explicit words, %d changed", total, len(updated))
+	return updated, nil
+}
+
+// PurgeDeletedSongs permanently removes songs that were deleted before the
+// given time and returns their IDs.
+func (r *ApiRepository) PurgeDeletedSongs(ctx context.Context, before time.Time) ([]int, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "purge_deleted_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, "DELETE FROM songs WHERE library_id = $1 AND deleted_at < $2 RETURNING id", r.libraryID, before)
+	if err != nil {
+		logger.Error("Error purging deleted songs: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			logger.Error("Error scanning purged song IDs: ", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("Error purging deleted songs: ", err)
+		return nil, err
+	}
+
+	logger.Infof("Purged %d deleted songs", len(ids))
+	return ids, nil
+}
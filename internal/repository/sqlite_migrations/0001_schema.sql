@@ -0,0 +1,65 @@
+-- Base schema of the SQLite store, the equivalent of Postgres migrations 1
+-- to 9. Timestamps are Unix nanoseconds, release dates YYYY-MM-DD text.
+
+CREATE TABLE libraries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+INSERT INTO libraries (slug, name, created_at)
+VALUES ('default', 'Default library', CAST(strftime('%s', 'now') AS INTEGER) * 1000000000);
+
+CREATE TABLE songs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    library_id INTEGER NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+    group_name TEXT NOT NULL,
+    song_name TEXT NOT NULL,
+    release_date TEXT,
+    text TEXT,
+    link TEXT,
+    created_by TEXT,
+    updated_by TEXT,
+    deleted_at INTEGER
+);
+
+CREATE INDEX idx_song ON songs (group_name, song_name);
+CREATE INDEX idx_songs_library ON songs (library_id);
+
+CREATE TABLE api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT NOT NULL,
+    library_id INTEGER REFERENCES libraries(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    revoked_at INTEGER
+);
+
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at INTEGER NOT NULL,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    library TEXT,
+    entity TEXT NOT NULL,
+    entity_id INTEGER,
+    request_id TEXT,
+    source_ip TEXT,
+    before TEXT,
+    after TEXT
+);
+
+CREATE INDEX idx_audit_events_occurred_at ON audit_events (occurred_at);
+
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
@@ -0,0 +1,27 @@
+-- Playlists, see Postgres migration 10.
+
+CREATE TABLE playlists (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    library_id INTEGER NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    owner TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    visibility TEXT NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'public')),
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_playlists_library ON playlists (library_id, owner);
+
+CREATE TABLE playlist_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    playlist_id INTEGER NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    added_by TEXT,
+    added_at INTEGER NOT NULL,
+    -- SQLite cannot defer the check, so positions are renumbered in two
+    -- passes, see SetPlaylistPositions.
+    UNIQUE (playlist_id, position)
+);
+
+CREATE INDEX idx_playlist_entries_song ON playlist_entries (song_id);
@@ -0,0 +1,41 @@
+-- Users, sessions, favorites and plays, see Postgres migrations 11 and 12.
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL,
+    library_id INTEGER REFERENCES libraries(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    revoked_at INTEGER
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id);
+
+CREATE TABLE favorites (
+    actor TEXT NOT NULL,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (actor, song_id)
+);
+
+CREATE TABLE plays (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    actor TEXT NOT NULL,
+    played_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_plays_actor ON plays (actor, played_at);
+CREATE INDEX idx_plays_song ON plays (song_id);
+
+ALTER TABLE songs ADD COLUMN play_count INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX idx_songs_play_count ON songs (library_id, play_count);
@@ -0,0 +1,19 @@
+-- Reviews and song ratings, see Postgres migration 13.
+
+CREATE TABLE reviews (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    actor TEXT NOT NULL,
+    rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    UNIQUE (song_id, actor)
+);
+
+CREATE INDEX idx_reviews_status ON reviews (status, updated_at);
+
+ALTER TABLE songs ADD COLUMN rating REAL NOT NULL DEFAULT 0;
+ALTER TABLE songs ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX idx_songs_rating ON songs (library_id, rating);
@@ -0,0 +1,27 @@
+-- Annotations and their votes, see Postgres migration 14.
+
+CREATE TABLE annotations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    verse INTEGER NOT NULL CHECK (verse >= 0),
+    unit TEXT NOT NULL CHECK (unit IN ('line', 'char')),
+    range_start INTEGER NOT NULL CHECK (range_start >= 0),
+    range_end INTEGER NOT NULL,
+    quote TEXT NOT NULL,
+    body TEXT NOT NULL,
+    author TEXT NOT NULL,
+    votes INTEGER NOT NULL DEFAULT 0,
+    orphaned INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    CHECK (range_end > range_start)
+);
+
+CREATE INDEX idx_annotations_song ON annotations (song_id, verse, range_start);
+
+CREATE TABLE annotation_votes (
+    annotation_id INTEGER NOT NULL REFERENCES annotations(id) ON DELETE CASCADE,
+    actor TEXT NOT NULL,
+    value INTEGER NOT NULL CHECK (value IN (-1, 1)),
+    PRIMARY KEY (annotation_id, actor)
+);
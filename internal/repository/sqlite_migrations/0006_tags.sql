@@ -0,0 +1,17 @@
+-- Tags and the songs they classify, see Postgres migration 15.
+
+CREATE TABLE tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    library_id INTEGER NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+    type TEXT NOT NULL CHECK (type IN ('genre', 'mood', 'language', 'era')),
+    name TEXT NOT NULL,
+    UNIQUE (library_id, type, name)
+);
+
+CREATE TABLE song_tags (
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (song_id, tag_id)
+);
+
+CREATE INDEX idx_song_tags_tag ON song_tags (tag_id);
@@ -0,0 +1,15 @@
+-- Translations of song texts, see Postgres migration 16. alignment holds
+-- the verse indexes separated by spaces.
+
+CREATE TABLE song_translations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    language TEXT NOT NULL,
+    text TEXT NOT NULL,
+    translator TEXT NOT NULL,
+    alignment TEXT NOT NULL,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    UNIQUE (song_id, language)
+);
@@ -0,0 +1,6 @@
+-- Detected song language and transliterated lyrics, see Postgres migration 17.
+
+ALTER TABLE songs ADD COLUMN language TEXT NOT NULL DEFAULT '';
+ALTER TABLE songs ADD COLUMN language_confidence REAL NOT NULL DEFAULT 0;
+ALTER TABLE songs ADD COLUMN text_latin TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_songs_language ON songs (library_id, language);
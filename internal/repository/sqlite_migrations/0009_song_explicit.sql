@@ -0,0 +1,9 @@
+-- Explicit songs, the wordlist they were last checked against and clean mode
+-- api keys, see Postgres migration 18.
+
+ALTER TABLE songs ADD COLUMN explicit_detected INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE songs ADD COLUMN explicit_override INTEGER;
+
+ALTER TABLE libraries ADD COLUMN explicit_wordlist TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE api_keys ADD COLUMN clean_mode TEXT NOT NULL DEFAULT 'off' CHECK (clean_mode IN ('off', 'exclude', 'mask'));
@@ -0,0 +1,2275 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/sirupsen/logrus"
+	"modernc.org/sqlite"
+)
+
+// sqliteMigrations are the SQLite schema scripts, applied in file name
+// order. The number of applied scripts is stored in PRAGMA user_version.
+//
+//go:embed sqlite_migrations/*.sql
+var sqliteMigrations embed.FS
+
+func init() {
+	// SQLite's LIKE only folds ASCII, so filters use ilike(pattern, value)
+	// with the same rules as Postgres ILIKE.
+	sqlite.MustRegisterDeterministicScalarFunction("ilike", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, ok1 := sqliteText(args[0])
+		value, ok2 := sqliteText(args[1])
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		return likeMatch(pattern, value), nil
+	})
+}
+
+func sqliteText(v driver.Value) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case []byte:
+		return string(x), true
+	default:
+		return "", false
+	}
+}
+
+// SqliteRepository stores everything in an embedded SQLite database, for
+// running the service without Postgres. It behaves like ApiRepository.
+type SqliteRepository struct {
+	db        dbtx
+	pool      *sql.DB
+	logger    *logrus.Logger
+	libraryID int
+	inTx      bool
+	// clean is set on repositories returned by WithoutExplicit.
+	clean bool
+}
+
+// NewSqliteRepository creates the schema in db, or brings an existing one
+// up to date. db should be opened with db.OpenSQLite.
+func NewSqliteRepository(ctx context.Context, db *sql.DB, logger *logrus.Logger) (*SqliteRepository, error) {
+	if err := migrateSqlite(ctx, db, logger); err != nil {
+		return nil, err
+	}
+
+	return &SqliteRepository{
+		db:     db,
+		pool:   db,
+		logger: logger,
+	}, nil
+}
+
+// migrateSqlite applies the scripts newer than the database's
+// user_version in a single transaction.
+func migrateSqlite(ctx context.Context, db *sql.DB, logger *logrus.Logger) error {
+	scripts, err := fs.Glob(sqliteMigrations, "sqlite_migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(scripts)
+
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("read sqlite schema version: %w", err)
+	}
+	if version > len(scripts) {
+		return fmt.Errorf("sqlite schema version %d is newer than this build (%d)", version, len(scripts))
+	}
+	if version == len(scripts) {
+		return nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range scripts[version:] {
+		script, err := sqliteMigrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+			return fmt.Errorf("apply %s: %w", path.Base(name), err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", len(scripts))); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Infof("Migrated SQLite schema from version %d to %d", version, len(scripts))
+	return nil
+}
+
+func (r *SqliteRepository) ForLibrary(libraryID int) Repository {
+	return &SqliteRepository{
+		db:        r.db,
+		pool:      r.pool,
+		logger:    r.logger,
+		libraryID: libraryID,
+		inTx:      r.inTx,
+		clean:     r.clean,
+	}
+}
+
+func (r *SqliteRepository) WithoutExplicit() Repository {
+	clean := *r
+	clean.clean = true
+	return &clean
+}
+
+// hideExplicit returns the condition leaving explicit songs out of queries
+// on clean repositories, and nothing otherwise.
+func (r *SqliteRepository) hideExplicit(alias string) string {
+	if !r.clean {
+		return ""
+	}
+	return notExplicit(alias)
+}
+
+func (r *SqliteRepository) trace(ctx context.Context, query string) (context.Context, func()) {
+	return traceQuery(ctx, "sqlite", query)
+}
+
+func (r *SqliteRepository) WithTx(ctx context.Context, fn func(repo Repository) error) error {
+	return r.WithTxOptions(ctx, TxOptions{}, fn)
+}
+
+func (r *SqliteRepository) WithLibraryTx(ctx context.Context, fn func(repo LibraryRepository) error) error {
+	return r.WithTx(ctx, func(repo Repository) error {
+		return fn(repo.(*SqliteRepository))
+	})
+}
+
+// WithTxOptions runs fn in a transaction like ApiRepository.WithTxOptions.
+// SQLite transactions are always serializable and the store uses a single
+// connection, so the isolation level is ignored and only fn itself can
+// report a conflict to retry. Using the outer repository inside fn
+// deadlocks.
+func (r *SqliteRepository) WithTxOptions(ctx context.Context, opts TxOptions, fn func(repo Repository) error) error {
+	if r.inTx {
+		return fn(r)
+	}
+
+	_, err := retryTx(ctx, r.logger, opts, func() error {
+		return r.runTx(ctx, opts, fn)
+	})
+	return err
+}
+
+func (r *SqliteRepository) runTx(ctx context.Context, opts TxOptions, fn func(repo Repository) error) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	tx, err := r.pool.BeginTx(ctx, &sql.TxOptions{ReadOnly: opts.ReadOnly})
+	if err != nil {
+		logger.Error("Error starting transaction: ", err)
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&SqliteRepository{db: tx, pool: r.pool, logger: r.logger, libraryID: r.libraryID, inTx: true, clean: r.clean}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			logger.Error("Error rolling back transaction: ", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("Error committing transaction: ", err)
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+func unixNano(t time.Time) int64 {
+	return t.UnixNano()
+}
+
+func fromUnixNano(n sql.NullInt64) *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := time.Unix(0, n.Int64)
+	return &t
+}
+
+const sqliteSongColumns = `id, group_name, song_name, COALESCE(release_date, ''), COALESCE(text, ''), COALESCE(link, ''),
+	COALESCE(created_by, ''), COALESCE(updated_by, ''), play_count, rating, rating_count, language, language_confidence,
+	COALESCE(explicit_override, explicit_detected), explicit_override`
+
+func scanSong(row interface{ Scan(...interface{}) error }, song *models.Song) error {
+	return row.Scan(&song.ID, &song.Group, &song.Song, &song.ReleaseDate, &song.Text, &song.Link, &song.CreatedBy, &song.UpdatedBy, &song.PlayCount, &song.Rating, &song.RatingCount, &song.Language, &song.LanguageConfidence, &song.Explicit, &song.ExplicitOverride)
+}
+
+func (r *SqliteRepository) GetData(ctx context.Context, filter map[string]string, sort string, limit int, offset int) ([]models.Song, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_data")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+	if err := checkSongFilter(filter); err != nil {
+		return nil, err
+	}
+	order, err := songOrder(sort)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + sqliteSongColumns + " FROM songs WHERE library_id = ? AND deleted_at IS NULL" + r.hideExplicit("")
+	args := []interface{}{r.libraryID}
+
+	conditions, args, err := sqliteSongConditions(filter, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query += conditions + " ORDER BY " + order + " LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error("Error executing GetData query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var songs []models.Song
+	for rows.Next() {
+		var song models.Song
+		if err := scanSong(rows, &song); err != nil {
+			logger.Error("Error scanning GetData rows: ", err)
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+
+	logger.Infof("Successfully fetched %d songs", len(songs))
+	return songs, rows.Err()
+}
+
+// sqliteSongConditions returns the conditions of a checked song filter, to
+// follow a WHERE clause on songs, and args with their arguments appended.
+func sqliteSongConditions(filter map[string]string, args []interface{}) (string, []interface{}, error) {
+	var query string
+	for key, value := range filter {
+		switch key {
+		case "tags", "tag_mode":
+		case "release_date":
+			date, err := normalizeDate(value)
+			if err != nil {
+				return "", nil, err
+			}
+			query += " AND release_date = ?"
+			args = append(args, date)
+		case "min_rating":
+			rating, err := minRating(value)
+			if err != nil {
+				return "", nil, err
+			}
+			query += " AND rating >= ?"
+			args = append(args, rating)
+		case "language":
+			query += " AND language = ?"
+			args = append(args, value)
+		case "min_language_confidence":
+			confidence, err := minLanguageConfidence(value)
+			if err != nil {
+				return "", nil, err
+			}
+			query += " AND language_confidence >= ?"
+			args = append(args, confidence)
+		case "explicit":
+			explicit, err := explicitFilter(value)
+			if err != nil {
+				return "", nil, err
+			}
+			query += " AND COALESCE(explicit_override, explicit_detected) = ?"
+			args = append(args, explicit)
+		case "text":
+			query += " AND (ilike(?, text) OR ilike(?, text_latin))"
+			args = append(args, value, latinText(value))
+		default:
+			query += fmt.Sprintf(" AND ilike(?, %s)", key)
+			args = append(args, value)
+		}
+	}
+
+	tags, all, err := tagFilter(filter)
+	if err != nil || len(tags) == 0 {
+		return query, args, err
+	}
+
+	pairs := make([]string, len(tags))
+	for i, tag := range tags {
+		pairs[i] = "(?, ?)"
+		args = append(args, tag.Type, tag.Name)
+	}
+	query += " AND id IN (SELECT st.song_id FROM song_tags st JOIN tags t ON t.id = st.tag_id WHERE (t.type, t.name) IN (VALUES " + strings.Join(pairs, ", ") + ")"
+	if all {
+		query += fmt.Sprintf(" GROUP BY st.song_id HAVING COUNT(*) = %d", len(tags))
+	}
+	return query + ")", args, nil
+}
+
+func (r *SqliteRepository) GetSong(ctx context.Context, id int) (*models.Song, error) {
+	ctx, done := r.trace(ctx, "get_song")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var song models.Song
+	row := r.db.QueryRowContext(ctx, "SELECT "+sqliteSongColumns+" FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL"+r.hideExplicit(""), id, r.libraryID)
+	if err := scanSong(row, &song); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error fetching song: ", err)
+		}
+		return nil, err
+	}
+
+	return &song, nil
+}
+
+func (r *SqliteRepository) GetSongPagi(ctx context.Context, id int, limit int, offset int) (*models.Song, error) {
+	song, err := r.GetSong(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if song.Text, _, err = sliceVerses(song.Text, limit, offset); err != nil {
+		return nil, err
+	}
+	return song, nil
+}
+
+func (r *SqliteRepository) DeleteSong(ctx context.Context, id int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_song")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, "UPDATE songs SET deleted_at = ? WHERE id = ? AND library_id = ? AND deleted_at IS NULL",
+		unixNano(time.Now()), id, r.libraryID)
+	if err != nil {
+		logger.Error("Error deleting song: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// sqliteMergeSongStatements are mergeSongStatements with the song ?1 as
+// the target and the list sources as the merged songs.
+var sqliteMergeSongStatements = []string{
+	`UPDATE songs SET play_count = play_count + (SELECT COALESCE(SUM(play_count), 0) FROM songs WHERE id IN sources) WHERE id = ?1`,
+	`UPDATE songs SET play_count = 0 WHERE id IN sources`,
+	`UPDATE plays SET song_id = ?1 WHERE song_id IN sources`,
+	`UPDATE playlist_entries SET song_id = ?1 WHERE song_id IN sources`,
+	`INSERT INTO favorites (actor, song_id, created_at)
+		SELECT actor, ?1, MIN(created_at) FROM favorites WHERE song_id IN sources GROUP BY actor
+		ON CONFLICT (actor, song_id) DO NOTHING`,
+	`DELETE FROM favorites WHERE song_id IN sources`,
+	`UPDATE reviews AS r SET song_id = ?1
+		WHERE r.song_id IN sources AND NOT EXISTS (
+			SELECT 1 FROM reviews o WHERE o.actor = r.actor AND (o.song_id = ?1
+				OR o.song_id IN sources AND (o.updated_at, o.id) > (r.updated_at, r.id)))`,
+	`INSERT INTO song_tags (song_id, tag_id)
+		SELECT DISTINCT ?1, tag_id FROM song_tags WHERE song_id IN sources
+		ON CONFLICT (song_id, tag_id) DO NOTHING`,
+}
+
+func (r *SqliteRepository) MergeSongs(ctx context.Context, targetID int, sourceIDs []int) ([]int, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "merge_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+	if len(sourceIDs) == 0 {
+		return nil, nil
+	}
+
+	var merged []int
+	err := r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*SqliteRepository)
+		merged = nil
+
+		in, args := sqliteIn(sourceIDs, nil)
+		rows, err := tx.db.QueryContext(ctx, `UPDATE songs SET deleted_at = ?
+			WHERE id IN `+in+` AND id <> ? AND library_id = ? AND deleted_at IS NULL
+				AND EXISTS (SELECT 1 FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL)
+			RETURNING id`, append(append([]interface{}{unixNano(time.Now())}, args...), targetID, r.libraryID, targetID, r.libraryID)...)
+		if err != nil {
+			logger.Error("Error trashing merged songs: ", err)
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			merged = append(merged, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(merged) == 0 {
+			return nil
+		}
+		sort.Ints(merged)
+
+		// The merged songs are ?2 and on, after the target.
+		placeholders := make([]string, len(merged))
+		args = []interface{}{targetID}
+		for i, id := range merged {
+			placeholders[i] = "?" + strconv.Itoa(i+2)
+			args = append(args, id)
+		}
+		sources := "(" + strings.Join(placeholders, ", ") + ")"
+
+		for _, statement := range sqliteMergeSongStatements {
+			if _, err := tx.db.ExecContext(ctx, strings.ReplaceAll(statement, "IN sources", "IN "+sources), args...); err != nil {
+				logger.Error("Error merging songs: ", err)
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Merged songs %v into song %d", merged, targetID)
+	return merged, nil
+}
+
+func (r *SqliteRepository) UpdateSongData(ctx context.Context, song *models.Song) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "update_song")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	date, err := normalizeDate(song.ReleaseDate)
+	if err != nil {
+		return err
+	}
+
+	var sets []string
+	var args []interface{}
+	for _, f := range []struct {
+		column, value string
+	}{
+		{"group_name", song.Group},
+		{"song_name", song.Song},
+		{"text", song.Text},
+		{"link", song.Link},
+		{"release_date", date},
+	} {
+		if f.value != "" {
+			sets = append(sets, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+
+	if len(sets) == 0 {
+		return errors.New("no fields to update")
+	}
+	if song.Text != "" {
+		detectLanguage(song)
+		sets = append(sets, "language = ?", "language_confidence = ?", "text_latin = ?", "explicit_detected = ?")
+		args = append(args, song.Language, song.LanguageConfidence, latinText(song.Text), song.Explicit)
+	}
+
+	sets = append(sets, "updated_by = ?")
+	args = append(args, song.UpdatedBy, song.ID, r.libraryID)
+
+	_, err = r.db.ExecContext(ctx, "UPDATE songs SET "+strings.Join(sets, ", ")+" WHERE id = ? AND library_id = ? AND deleted_at IS NULL", args...)
+	if err != nil {
+		logger.Error("Error updating song: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) AddNewSong(ctx context.Context, song *models.Song) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_song")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	date, err := normalizeDate(song.ReleaseDate)
+	if err != nil {
+		return err
+	}
+
+	detectLanguage(song)
+	err = r.db.QueryRowContext(ctx, `INSERT INTO songs (library_id, group_name, song_name, release_date, text, link, created_by, updated_by, language, language_confidence, text_latin,
+			explicit_detected, explicit_override)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		r.libraryID, song.Group, song.Song, date, song.Text, song.Link, song.CreatedBy, song.CreatedBy, song.Language, song.LanguageConfidence, latinText(song.Text),
+		song.Explicit, song.ExplicitOverride,
+	).Scan(&song.ID)
+	if err != nil {
+		logger.Error("Error inserting new song: ", err)
+		return err
+	}
+	song.Explicit = explicitSong(song)
+	return nil
+}
+
+func (r *SqliteRepository) SetExplicitOverride(ctx context.Context, id int, override *bool) (int64, error) {
+	ctx, done := r.trace(ctx, "set_explicit_override")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, "UPDATE songs SET explicit_override = ? WHERE id = ? AND library_id = ? AND deleted_at IS NULL", override, id, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error setting explicit override: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) SetExplicitDetected(ctx context.Context, id int, detected bool) (int64, error) {
+	ctx, done := r.trace(ctx, "set_explicit_detected")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, "UPDATE songs SET explicit_detected = ? WHERE id = ? AND library_id = ?", detected, id, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error setting explicit flag: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) DetectSongLanguages(ctx context.Context) ([]models.Song, error) {
+	ctx, done := r.trace(ctx, "detect_song_languages")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, text FROM songs WHERE library_id = ? AND COALESCE(text, '') <> '' AND text_latin = ''", r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error fetching songs without a language: ", err)
+		return nil, err
+	}
+	var songs []models.Song
+	for rows.Next() {
+		var song models.Song
+		if err := rows.Scan(&song.ID, &song.Text); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var detected []models.Song
+	for i := range songs {
+		song := &songs[i]
+		detectLanguage(song)
+		_, err := r.db.ExecContext(ctx, "UPDATE songs SET language = ?, language_confidence = ?, text_latin = ? WHERE id = ? AND library_id = ?",
+			song.Language, song.LanguageConfidence, latinText(song.Text), song.ID, r.libraryID)
+		if err != nil {
+			log.FromContext(ctx, r.logger).Error("Error updating song language: ", err)
+			return nil, err
+		}
+		if song.Language != "" {
+			detected = append(detected, models.Song{ID: song.ID, Language: song.Language, LanguageConfidence: song.LanguageConfidence})
+		}
+	}
+	return detected, nil
+}
+
+func (r *SqliteRepository) DetectExplicitSongs(ctx context.Context, fingerprint string, contains func(text string) bool) ([]models.Song, error) {
+	ctx, done := r.trace(ctx, "detect_explicit_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var checked string
+	if err := r.db.QueryRowContext(ctx, "SELECT explicit_wordlist FROM libraries WHERE id = ?", r.libraryID).Scan(&checked); err != nil {
+		log.FromContext(ctx, r.logger).Error("Error fetching the library's explicit wordlist: ", err)
+		return nil, err
+	}
+	if checked == fingerprint {
+		return nil, nil
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT s.id, COALESCE(s.text, ''), s.explicit_detected,
+			COALESCE((SELECT group_concat(t.text, char(10) || char(10)) FROM song_translations t WHERE t.song_id = s.id), '')
+		FROM songs s WHERE s.library_id = ?`, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error fetching songs to check for explicit words: ", err)
+		return nil, err
+	}
+	var changed []models.Song
+	for rows.Next() {
+		var song models.Song
+		var translations string
+		if err := rows.Scan(&song.ID, &song.Text, &song.Explicit, &translations); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if explicit := contains(song.Text) || contains(translations); explicit != song.Explicit {
+			song.Explicit = explicit
+			changed = append(changed, song)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var updated []models.Song
+	for _, song := range changed {
+		result, err := r.db.ExecContext(ctx, "UPDATE songs SET explicit_detected = ? WHERE id = ? AND library_id = ? AND COALESCE(text, '') = ?",
+			song.Explicit, song.ID, r.libraryID, song.Text)
+		if err != nil {
+			log.FromContext(ctx, r.logger).Error("Error updating explicit flag: ", err)
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			updated = append(updated, models.Song{ID: song.ID, Explicit: song.Explicit})
+		}
+	}
+
+	if _, err := r.db.ExecContext(ctx, "UPDATE libraries SET explicit_wordlist = ? WHERE id = ?", fingerprint, r.libraryID); err != nil {
+		log.FromContext(ctx, r.logger).Error("Error saving the library's explicit wordlist: ", err)
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (r *SqliteRepository) PurgeDeletedSongs(ctx context.Context, before time.Time) ([]int, error) {
+	ctx, done := r.trace(ctx, "purge_deleted_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	return r.queryIDs(ctx, "DELETE FROM songs WHERE library_id = ? AND deleted_at < ? RETURNING id", r.libraryID, unixNano(before))
+}
+
+func (r *SqliteRepository) queryIDs(ctx context.Context, query string, args ...interface{}) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *SqliteRepository) AddApiKey(ctx context.Context, key *models.ApiKey) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_api_key")
+	defer done()
+
+	key.CreatedAt = time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO api_keys (name, prefix, key_hash, scopes, library_id, clean_mode, created_at)
+		VALUES (?, ?, ?, ?, (SELECT id FROM libraries WHERE slug = NULLIF(?, '')), ?, ?)
+		RETURNING id`,
+		key.Name, key.Prefix, key.Hash, strings.Join(key.Scopes, " "), key.Library, key.CleanMode, unixNano(key.CreatedAt),
+	).Scan(&key.ID)
+	if err != nil {
+		logger.Error("Error inserting api key: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) GetApiKeyByHash(ctx context.Context, hash string) (*models.ApiKey, error) {
+	ctx, done := r.trace(ctx, "get_api_key")
+	defer done()
+
+	var key models.ApiKey
+	var scopes string
+	var createdAt int64
+	var revokedAt sql.NullInt64
+	err := r.db.QueryRowContext(ctx, `SELECT k.id, k.name, k.prefix, k.key_hash, k.scopes, COALESCE(l.slug, ''), k.clean_mode, k.created_at, k.revoked_at
+		FROM api_keys k LEFT JOIN libraries l ON l.id = k.library_id
+		WHERE k.key_hash = ?`, hash).Scan(
+		&key.ID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.Library, &key.CleanMode, &createdAt, &revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.CreatedAt = time.Unix(0, createdAt)
+	key.RevokedAt = fromUnixNano(revokedAt)
+
+	return &key, nil
+}
+
+func (r *SqliteRepository) RevokeApiKey(ctx context.Context, id int) (int64, error) {
+	ctx, done := r.trace(ctx, "revoke_api_key")
+	defer done()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, unixNano(time.Now()), id)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error revoking api key: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) AddAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	ctx, done := r.trace(ctx, "add_audit_event")
+	defer done()
+
+	event.OccurredAt = time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO audit_events (occurred_at, actor, action, library, entity, entity_id, request_id, source_ip, before, after)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, 0), NULLIF(?, ''), NULLIF(?, ''), ?, ?)
+		RETURNING id`,
+		unixNano(event.OccurredAt), event.Actor, event.Action, event.Library, event.Entity, event.EntityID,
+		event.RequestID, event.SourceIP, nullableJSON(event.Before), nullableJSON(event.After),
+	).Scan(&event.ID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error inserting audit event: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit int, offset int) ([]models.AuditEvent, error) {
+	ctx, done := r.trace(ctx, "get_audit_events")
+	defer done()
+
+	query, args := sqliteAuditQuery(filter)
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	var events []models.AuditEvent
+	err := r.scanAuditEvents(ctx, query, args, func(event *models.AuditEvent) error {
+		events = append(events, *event)
+		return nil
+	})
+	return events, err
+}
+
+func (r *SqliteRepository) StreamAuditEvents(ctx context.Context, filter models.AuditFilter, fn func(event *models.AuditEvent) error) error {
+	ctx, done := r.trace(ctx, "stream_audit_events")
+	defer done()
+
+	query, args := sqliteAuditQuery(filter)
+	return r.scanAuditEvents(ctx, query+" ORDER BY id", args, fn)
+}
+
+func (r *SqliteRepository) scanAuditEvents(ctx context.Context, query string, args []interface{}, fn func(event *models.AuditEvent) error) error {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing audit events query: ", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.AuditEvent
+		var occurredAt int64
+		var before, after sql.NullString
+		if err := rows.Scan(&event.ID, &occurredAt, &event.Actor, &event.Action, &event.Library, &event.Entity,
+			&event.EntityID, &event.RequestID, &event.SourceIP, &before, &after); err != nil {
+			return err
+		}
+		event.OccurredAt = time.Unix(0, occurredAt)
+		if before.Valid {
+			event.Before = []byte(before.String)
+		}
+		if after.Valid {
+			event.After = []byte(after.String)
+		}
+
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func sqliteAuditQuery(filter models.AuditFilter) (string, []interface{}) {
+	query := "SELECT " + auditColumns + " FROM audit_events WHERE 1=1"
+	var args []interface{}
+
+	add := func(clause string, value interface{}) {
+		query += " AND " + clause
+		args = append(args, value)
+	}
+
+	if filter.Actor != "" {
+		add("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		add("action = ?", filter.Action)
+	}
+	if filter.Library != "" {
+		add("library = ?", filter.Library)
+	}
+	if filter.Entity != "" {
+		add("entity = ?", filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		add("entity_id = ?", filter.EntityID)
+	}
+	if filter.RequestID != "" {
+		add("request_id = ?", filter.RequestID)
+	}
+	if filter.From != nil {
+		add("occurred_at >= ?", unixNano(*filter.From))
+	}
+	if filter.To != nil {
+		add("occurred_at < ?", unixNano(*filter.To))
+	}
+
+	return query, args
+}
+
+func (r *SqliteRepository) AddLibrary(ctx context.Context, library *models.Library) error {
+	ctx, done := r.trace(ctx, "add_library")
+	defer done()
+
+	library.CreatedAt = time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO libraries (slug, name, created_at) VALUES (?, ?, ?) RETURNING id`,
+		library.Slug, library.Name, unixNano(library.CreatedAt),
+	).Scan(&library.ID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error inserting library: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) GetLibraryBySlug(ctx context.Context, slug string) (*models.Library, error) {
+	ctx, done := r.trace(ctx, "get_library")
+	defer done()
+
+	var library models.Library
+	var createdAt int64
+	err := r.db.QueryRowContext(ctx, `SELECT id, slug, name, created_at FROM libraries WHERE slug = ?`, slug).Scan(
+		&library.ID, &library.Slug, &library.Name, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	library.CreatedAt = time.Unix(0, createdAt)
+
+	return &library, nil
+}
+
+func (r *SqliteRepository) GetLibraries(ctx context.Context) ([]models.Library, error) {
+	ctx, done := r.trace(ctx, "get_libraries")
+	defer done()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT id, slug, name, created_at FROM libraries ORDER BY slug`)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing GetLibraries query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var libraries []models.Library
+	for rows.Next() {
+		var library models.Library
+		var createdAt int64
+		if err := rows.Scan(&library.ID, &library.Slug, &library.Name, &createdAt); err != nil {
+			return nil, err
+		}
+		library.CreatedAt = time.Unix(0, createdAt)
+		libraries = append(libraries, library)
+	}
+
+	return libraries, rows.Err()
+}
+
+func (r *SqliteRepository) CopySongs(ctx context.Context, fromID, toID int, songIDs []int, actor string) (int64, error) {
+	ctx, done := r.trace(ctx, "copy_songs")
+	defer done()
+
+	query := `INSERT INTO songs (library_id, group_name, song_name, release_date, text, link, created_by, updated_by, language, language_confidence, text_latin, explicit_detected, explicit_override)
+		SELECT ?, group_name, song_name, release_date, text, link, ?, ?, language, language_confidence, text_latin, explicit_detected, explicit_override
+		FROM songs WHERE library_id = ? AND deleted_at IS NULL`
+	args := []interface{}{toID, actor, actor, fromID}
+
+	if len(songIDs) > 0 {
+		query += " AND id IN (?" + strings.Repeat(", ?", len(songIDs)-1) + ")"
+		for _, id := range songIDs {
+			args = append(args, id)
+		}
+	}
+
+	result, err := r.db.ExecContext(ctx, query+" ORDER BY id", args...)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error copying songs: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) GetSongStats(ctx context.Context) ([]models.SongStats, error) {
+	ctx, done := r.trace(ctx, "get_song_stats")
+	defer done()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT l.slug,
+			COUNT(s.id),
+			COUNT(s.id) FILTER (WHERE COALESCE(s.text, '') = ''),
+			COUNT(s.id) FILTER (WHERE COALESCE(s.link, '') = '')
+		FROM libraries l LEFT JOIN songs s ON s.library_id = l.id AND s.deleted_at IS NULL
+		GROUP BY l.slug ORDER BY l.slug`)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing GetSongStats query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.SongStats
+	for rows.Next() {
+		var s models.SongStats
+		if err := rows.Scan(&s.Library, &s.Total, &s.MissingText, &s.MissingLink); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
+
+func scanPlaylist(row interface{ Scan(...interface{}) error }, playlist *models.Playlist) error {
+	var createdAt int64
+	if err := row.Scan(&playlist.ID, &playlist.Name, &playlist.Owner, &playlist.Description, &playlist.Visibility, &createdAt); err != nil {
+		return err
+	}
+	playlist.CreatedAt = time.Unix(0, createdAt)
+	return nil
+}
+
+func (r *SqliteRepository) AddPlaylist(ctx context.Context, playlist *models.Playlist) error {
+	ctx, done := r.trace(ctx, "add_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	playlist.CreatedAt = time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO playlists (library_id, name, owner, description, visibility, created_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		r.libraryID, playlist.Name, playlist.Owner, playlist.Description, playlist.Visibility, unixNano(playlist.CreatedAt),
+	).Scan(&playlist.ID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error inserting playlist: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) GetPlaylist(ctx context.Context, id int) (*models.Playlist, error) {
+	ctx, done := r.trace(ctx, "get_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var playlist models.Playlist
+	row := r.db.QueryRowContext(ctx, "SELECT "+playlistColumns+" FROM playlists WHERE id = ? AND library_id = ?", id, r.libraryID)
+	if err := scanPlaylist(row, &playlist); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error fetching playlist: ", err)
+		}
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+func (r *SqliteRepository) GetPlaylists(ctx context.Context, viewer string, limit int, offset int) ([]models.Playlist, error) {
+	ctx, done := r.trace(ctx, "get_playlists")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+playlistColumns+` FROM playlists
+		WHERE library_id = ?1 AND (?2 = '' OR owner = ?2 OR visibility = 'public')
+		ORDER BY id LIMIT ?3 OFFSET ?4`, r.libraryID, viewer, limit, offset)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing GetPlaylists query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playlists []models.Playlist
+	for rows.Next() {
+		var playlist models.Playlist
+		if err := scanPlaylist(rows, &playlist); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+
+	return playlists, rows.Err()
+}
+
+func (r *SqliteRepository) UpdatePlaylist(ctx context.Context, playlist *models.Playlist) (int64, error) {
+	ctx, done := r.trace(ctx, "update_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE playlists SET name = ?, description = ?, visibility = ?
+		WHERE id = ? AND library_id = ?`,
+		playlist.Name, playlist.Description, playlist.Visibility, playlist.ID, r.libraryID,
+	)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error updating playlist: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) DeletePlaylist(ctx context.Context, id int) (int64, error) {
+	ctx, done := r.trace(ctx, "delete_playlist")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM playlists WHERE id = ? AND library_id = ?`, id, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error deleting playlist: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) GetPlaylistEntries(ctx context.Context, playlistID int) ([]models.PlaylistEntry, error) {
+	ctx, done := r.trace(ctx, "get_playlist_entries")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT e.id, e.position, e.song_id, s.group_name, s.song_name, COALESCE(s.link, ''),
+			COALESCE(e.added_by, ''), e.added_at
+		FROM playlist_entries e
+		JOIN playlists p ON p.id = e.playlist_id
+		JOIN songs s ON s.id = e.song_id AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		WHERE e.playlist_id = ? AND p.library_id = ?
+		ORDER BY e.position, e.id`, playlistID, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing GetPlaylistEntries query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.PlaylistEntry
+	for rows.Next() {
+		var entry models.PlaylistEntry
+		var addedAt int64
+		if err := rows.Scan(&entry.ID, &entry.Position, &entry.SongID, &entry.Group, &entry.Song, &entry.Link, &entry.AddedBy, &addedAt); err != nil {
+			return nil, err
+		}
+		entry.AddedAt = time.Unix(0, addedAt)
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (r *SqliteRepository) GetPlaylistEntryIDs(ctx context.Context, playlistID int) ([]int, error) {
+	ctx, done := r.trace(ctx, "get_playlist_entry_ids")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT e.id
+		FROM playlist_entries e JOIN playlists p ON p.id = e.playlist_id
+		WHERE e.playlist_id = ? AND p.library_id = ?
+		ORDER BY e.position, e.id`, playlistID, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing GetPlaylistEntryIDs query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (r *SqliteRepository) AddPlaylistEntry(ctx context.Context, entry *models.PlaylistEntry, playlistID int) error {
+	ctx, done := r.trace(ctx, "add_playlist_entry")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	entry.AddedAt = time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO playlist_entries (playlist_id, song_id, position, added_by, added_at)
+		SELECT p.id, s.id, (SELECT COALESCE(MAX(position), 0) + 1 FROM playlist_entries WHERE playlist_id = p.id), NULLIF(?, ''), ?
+		FROM playlists p JOIN songs s ON s.library_id = p.library_id AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		WHERE p.id = ? AND s.id = ? AND p.library_id = ?
+		RETURNING id, position`,
+		entry.AddedBy, unixNano(entry.AddedAt), playlistID, entry.SongID, r.libraryID,
+	).Scan(&entry.ID, &entry.Position)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.FromContext(ctx, r.logger).Error("Error inserting playlist entry: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) DeletePlaylistEntry(ctx context.Context, playlistID, entryID int) (int64, error) {
+	ctx, done := r.trace(ctx, "delete_playlist_entry")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM playlist_entries
+		WHERE id = ? AND playlist_id = (SELECT id FROM playlists WHERE id = ? AND library_id = ?)`,
+		entryID, playlistID, r.libraryID,
+	)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error deleting playlist entry: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) SetPlaylistPositions(ctx context.Context, playlistID int, entryIDs []int) error {
+	ctx, done := r.trace(ctx, "set_playlist_positions")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	// The unique position is checked row by row, so the entries first move
+	// to negative positions no other entry holds and then flip over.
+	return r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*SqliteRepository)
+
+		for i, id := range entryIDs {
+			_, err := tx.db.ExecContext(ctx, `UPDATE playlist_entries SET position = ?
+				WHERE id = ? AND playlist_id = (SELECT id FROM playlists WHERE id = ? AND library_id = ?)`,
+				-(i + 1), id, playlistID, tx.libraryID,
+			)
+			if err != nil {
+				log.FromContext(ctx, tx.logger).Error("Error rewriting playlist positions: ", err)
+				return err
+			}
+		}
+
+		_, err := tx.db.ExecContext(ctx, `UPDATE playlist_entries SET position = -position WHERE playlist_id = ? AND position < 0`, playlistID)
+		if err != nil {
+			log.FromContext(ctx, tx.logger).Error("Error rewriting playlist positions: ", err)
+		}
+		return err
+	})
+}
+
+func (r *SqliteRepository) AddUser(ctx context.Context, user *models.User) error {
+	ctx, done := r.trace(ctx, "add_user")
+	defer done()
+
+	user.CreatedAt = time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO users (username, password_hash, role, library_id, created_at)
+		VALUES (?, ?, ?, (SELECT id FROM libraries WHERE slug = NULLIF(?, '')), ?) RETURNING id`,
+		user.Username, user.PasswordHash, user.Role, user.Library, unixNano(user.CreatedAt),
+	).Scan(&user.ID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error inserting user: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) GetUserByName(ctx context.Context, username string) (*models.User, error) {
+	ctx, done := r.trace(ctx, "get_user")
+	defer done()
+
+	var user models.User
+	var createdAt int64
+	err := r.db.QueryRowContext(ctx, `SELECT u.id, u.username, u.password_hash, u.role, COALESCE(l.slug, ''), u.created_at
+		FROM users u LEFT JOIN libraries l ON l.id = u.library_id
+		WHERE u.username = ?`, username).Scan(
+		&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Library, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	user.CreatedAt = time.Unix(0, createdAt)
+
+	return &user, nil
+}
+
+func (r *SqliteRepository) AddSession(ctx context.Context, session *models.Session) error {
+	ctx, done := r.trace(ctx, "add_session")
+	defer done()
+
+	session.CreatedAt = time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO sessions (user_id, token_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?) RETURNING id`,
+		session.UserID, session.Hash, unixNano(session.CreatedAt), unixNano(session.ExpiresAt),
+	).Scan(&session.ID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error inserting session: ", err)
+	}
+	return err
+}
+
+func (r *SqliteRepository) GetSessionByHash(ctx context.Context, hash string) (*models.Session, error) {
+	ctx, done := r.trace(ctx, "get_session")
+	defer done()
+
+	var session models.Session
+	var createdAt, expiresAt int64
+	var revokedAt sql.NullInt64
+	err := r.db.QueryRowContext(ctx, `SELECT s.id, s.user_id, u.username, u.role, COALESCE(l.slug, ''), s.token_hash, s.created_at, s.expires_at, s.revoked_at
+		FROM sessions s JOIN users u ON u.id = s.user_id LEFT JOIN libraries l ON l.id = u.library_id
+		WHERE s.token_hash = ?`, hash).Scan(
+		&session.ID, &session.UserID, &session.Username, &session.Role, &session.Library, &session.Hash, &createdAt, &expiresAt, &revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	session.CreatedAt = time.Unix(0, createdAt)
+	session.ExpiresAt = time.Unix(0, expiresAt)
+	session.RevokedAt = fromUnixNano(revokedAt)
+
+	return &session, nil
+}
+
+func (r *SqliteRepository) RevokeSession(ctx context.Context, id int) (int64, error) {
+	ctx, done := r.trace(ctx, "revoke_session")
+	defer done()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, unixNano(time.Now()), id)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error revoking session: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) AddFavorite(ctx context.Context, actor string, songID int) error {
+	ctx, done := r.trace(ctx, "add_favorite")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `INSERT INTO favorites (actor, song_id, created_at)
+		SELECT ?, id, ? FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING`,
+		actor, unixNano(time.Now()), songID, r.libraryID,
+	)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error inserting favorite: ", err)
+		return err
+	}
+
+	// Nothing inserted means either a missing song or an existing favorite.
+	if added, err := result.RowsAffected(); err != nil || added > 0 {
+		return err
+	}
+	var id int
+	return r.db.QueryRowContext(ctx, `SELECT id FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL`, songID, r.libraryID).Scan(&id)
+}
+
+func (r *SqliteRepository) DeleteFavorite(ctx context.Context, actor string, songID int) (int64, error) {
+	ctx, done := r.trace(ctx, "delete_favorite")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM favorites
+		WHERE actor = ? AND song_id = (SELECT id FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL)`,
+		actor, songID, r.libraryID,
+	)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error deleting favorite: ", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) GetFavorites(ctx context.Context, actor string, limit int, offset int) ([]models.Song, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_favorites")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+sqliteSongColumns+` FROM songs
+		JOIN favorites f ON f.song_id = songs.id
+		WHERE f.actor = ? AND library_id = ? AND deleted_at IS NULL`+r.hideExplicit("")+`
+		ORDER BY f.created_at DESC, songs.id DESC LIMIT ? OFFSET ?`, actor, r.libraryID, limit, offset)
+	if err != nil {
+		logger.Error("Error executing GetFavorites query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var songs []models.Song
+	for rows.Next() {
+		var song models.Song
+		if err := scanSong(rows, &song); err != nil {
+			logger.Error("Error scanning GetFavorites rows: ", err)
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+
+	return songs, rows.Err()
+}
+
+// AddPlay runs in a transaction as SQLite cannot update the song and insert
+// the play in one statement.
+func (r *SqliteRepository) AddPlay(ctx context.Context, play *models.Play, actor string) error {
+	ctx, done := r.trace(ctx, "add_play")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	return r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*SqliteRepository)
+
+		err := tx.db.QueryRowContext(ctx, `UPDATE songs SET play_count = play_count + 1
+			WHERE id = ? AND library_id = ? AND deleted_at IS NULL
+			RETURNING group_name, song_name`, play.SongID, r.libraryID).Scan(&play.Group, &play.Song)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.FromContext(ctx, r.logger).Error("Error counting play: ", err)
+			}
+			return err
+		}
+
+		play.PlayedAt = time.Now()
+		err = tx.db.QueryRowContext(ctx, `INSERT INTO plays (song_id, actor, played_at) VALUES (?, ?, ?) RETURNING id`,
+			play.SongID, actor, unixNano(play.PlayedAt)).Scan(&play.ID)
+		if err != nil {
+			log.FromContext(ctx, r.logger).Error("Error inserting play: ", err)
+		}
+		return err
+	})
+}
+
+func (r *SqliteRepository) GetPlays(ctx context.Context, actor string, limit int, offset int) ([]models.Play, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_plays")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT p.id, p.song_id, s.group_name, s.song_name, p.played_at
+		FROM plays p JOIN songs s ON s.id = p.song_id
+		WHERE p.actor = ? AND s.library_id = ? AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		ORDER BY p.played_at DESC, p.id DESC LIMIT ? OFFSET ?`, actor, r.libraryID, limit, offset)
+	if err != nil {
+		logger.Error("Error executing GetPlays query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plays []models.Play
+	for rows.Next() {
+		var play models.Play
+		var playedAt int64
+		if err := rows.Scan(&play.ID, &play.SongID, &play.Group, &play.Song, &playedAt); err != nil {
+			logger.Error("Error scanning GetPlays rows: ", err)
+			return nil, err
+		}
+		play.PlayedAt = time.Unix(0, playedAt)
+		plays = append(plays, play)
+	}
+
+	return plays, rows.Err()
+}
+
+// sinceNano converts the start of a top window, zero meaning all time.
+func sinceNano(since time.Time) int64 {
+	if since.IsZero() {
+		return math.MinInt64
+	}
+	return unixNano(since)
+}
+
+func (r *SqliteRepository) GetTopSongs(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopSong, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_top_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT s.id, s.group_name, s.song_name, COUNT(*) AS plays
+		FROM plays p JOIN songs s ON s.id = p.song_id
+		WHERE p.actor = ? AND s.library_id = ? AND s.deleted_at IS NULL AND p.played_at >= ?`+r.hideExplicit("s")+`
+		GROUP BY s.id
+		ORDER BY plays DESC, s.id LIMIT ?`, actor, r.libraryID, sinceNano(since), limit)
+	if err != nil {
+		logger.Error("Error executing GetTopSongs query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []models.TopSong
+	for rows.Next() {
+		var song models.TopSong
+		if err := rows.Scan(&song.SongID, &song.Group, &song.Song, &song.Plays); err != nil {
+			logger.Error("Error scanning GetTopSongs rows: ", err)
+			return nil, err
+		}
+		top = append(top, song)
+	}
+
+	return top, rows.Err()
+}
+
+func (r *SqliteRepository) GetTopGroups(ctx context.Context, actor string, since time.Time, limit int) ([]models.TopGroup, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_top_groups")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT s.group_name, COUNT(*) AS plays
+		FROM plays p JOIN songs s ON s.id = p.song_id
+		WHERE p.actor = ? AND s.library_id = ? AND s.deleted_at IS NULL AND p.played_at >= ?`+r.hideExplicit("s")+`
+		GROUP BY s.group_name
+		ORDER BY plays DESC, s.group_name LIMIT ?`, actor, r.libraryID, sinceNano(since), limit)
+	if err != nil {
+		logger.Error("Error executing GetTopGroups query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []models.TopGroup
+	for rows.Next() {
+		var group models.TopGroup
+		if err := rows.Scan(&group.Group, &group.Plays); err != nil {
+			logger.Error("Error scanning GetTopGroups rows: ", err)
+			return nil, err
+		}
+		top = append(top, group)
+	}
+
+	return top, rows.Err()
+}
+
+const sqliteReviewColumns = `r.id, r.song_id, r.actor, r.rating, r.body, r.status, r.created_at, r.updated_at`
+
+func scanSqliteReview(row interface{ Scan(...interface{}) error }, review *models.Review) error {
+	var createdAt, updatedAt int64
+	if err := row.Scan(&review.ID, &review.SongID, &review.Actor, &review.Rating, &review.Body, &review.Status, &createdAt, &updatedAt); err != nil {
+		return err
+	}
+	review.CreatedAt, review.UpdatedAt = time.Unix(0, createdAt), time.Unix(0, updatedAt)
+	return nil
+}
+
+func (r *SqliteRepository) SaveReview(ctx context.Context, review *models.Review) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "save_review")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	now := unixNano(time.Now())
+	var createdAt, updatedAt int64
+	err := r.db.QueryRowContext(ctx, `INSERT INTO reviews (song_id, actor, rating, body, status, created_at, updated_at)
+		SELECT id, ?, ?, ?, ?, ?, ? FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL
+		ON CONFLICT (song_id, actor) DO UPDATE
+			SET rating = excluded.rating, body = excluded.body, status = excluded.status, updated_at = excluded.updated_at
+		RETURNING id, created_at, updated_at`,
+		review.Actor, review.Rating, review.Body, review.Status, now, now, review.SongID, r.libraryID,
+	).Scan(&review.ID, &createdAt, &updatedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error saving review: ", err)
+		}
+		return err
+	}
+
+	review.CreatedAt, review.UpdatedAt = time.Unix(0, createdAt), time.Unix(0, updatedAt)
+	return nil
+}
+
+func (r *SqliteRepository) GetReview(ctx context.Context, id int) (*models.Review, error) {
+	ctx, done := r.trace(ctx, "get_review")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var review models.Review
+	row := r.db.QueryRowContext(ctx, `SELECT `+sqliteReviewColumns+`
+		FROM reviews r JOIN songs s ON s.id = r.song_id
+		WHERE r.id = ? AND s.library_id = ? AND s.deleted_at IS NULL`+r.hideExplicit("s"), id, r.libraryID)
+	if err := scanSqliteReview(row, &review); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error fetching review: ", err)
+		}
+		return nil, err
+	}
+
+	return &review, nil
+}
+
+func (r *SqliteRepository) GetReviews(ctx context.Context, filter models.ReviewFilter, limit int, offset int) ([]models.Review, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_reviews")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	query := `SELECT ` + sqliteReviewColumns + `
+		FROM reviews r JOIN songs s ON s.id = r.song_id
+		WHERE s.library_id = ? AND s.deleted_at IS NULL` + r.hideExplicit("s")
+	args := []interface{}{r.libraryID}
+
+	if filter.SongID != 0 {
+		query += " AND r.song_id = ?"
+		args = append(args, filter.SongID)
+	}
+	if filter.Actor != "" {
+		query += " AND r.actor = ?"
+		args = append(args, filter.Actor)
+	}
+	if filter.Status != "" {
+		query += " AND r.status = ?"
+		args = append(args, filter.Status)
+	}
+
+	query += " ORDER BY r.updated_at DESC, r.id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Error("Error executing GetReviews query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []models.Review
+	for rows.Next() {
+		var review models.Review
+		if err := scanSqliteReview(rows, &review); err != nil {
+			logger.Error("Error scanning GetReviews rows: ", err)
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+func (r *SqliteRepository) DeleteReview(ctx context.Context, actor string, songID int) (int64, error) {
+	ctx, done := r.trace(ctx, "delete_review")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM reviews
+		WHERE actor = ? AND song_id = (SELECT id FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL)`,
+		actor, songID, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error deleting review: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) SetReviewStatus(ctx context.Context, id int, status string) (int64, error) {
+	ctx, done := r.trace(ctx, "set_review_status")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE reviews SET status = ?
+		WHERE id = ? AND song_id IN (SELECT id FROM songs WHERE library_id = ? AND deleted_at IS NULL)`,
+		status, id, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error updating review status: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) RefreshSongRating(ctx context.Context, songID int) error {
+	ctx, done := r.trace(ctx, "refresh_song_rating")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	_, err := r.db.ExecContext(ctx, `UPDATE songs SET
+			rating = COALESCE((SELECT ROUND(AVG(reviews.rating), 2) FROM reviews WHERE song_id = ? AND status = 'approved'), 0),
+			rating_count = (SELECT COUNT(*) FROM reviews WHERE song_id = ? AND status = 'approved')
+		WHERE id = ? AND library_id = ?`, songID, songID, songID, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error refreshing song rating: ", err)
+		return err
+	}
+
+	return nil
+}
+
+const sqliteAnnotationColumns = `a.id, a.song_id, a.verse, a.unit, a.range_start, a.range_end, a.quote, a.body, a.author, a.votes, a.orphaned, a.created_at, a.updated_at`
+
+func scanSqliteAnnotation(row interface{ Scan(...interface{}) error }, a *models.Annotation) error {
+	var createdAt, updatedAt int64
+	if err := row.Scan(&a.ID, &a.SongID, &a.Verse, &a.Unit, &a.Start, &a.End, &a.Quote, &a.Body, &a.Author, &a.Votes, &a.Orphaned, &createdAt, &updatedAt); err != nil {
+		return err
+	}
+	a.CreatedAt, a.UpdatedAt = time.Unix(0, createdAt), time.Unix(0, updatedAt)
+	return nil
+}
+
+func (r *SqliteRepository) AddAnnotation(ctx context.Context, annotation *models.Annotation) error {
+	ctx, done := r.trace(ctx, "add_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	now := time.Now()
+	err := r.db.QueryRowContext(ctx, `INSERT INTO annotations (song_id, verse, unit, range_start, range_end, quote, body, author, created_at, updated_at)
+		SELECT id, ?, ?, ?, ?, ?, ?, ?, ?, ? FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL
+		RETURNING id`,
+		annotation.Verse, annotation.Unit, annotation.Start, annotation.End, annotation.Quote, annotation.Body, annotation.Author,
+		unixNano(now), unixNano(now), annotation.SongID, r.libraryID,
+	).Scan(&annotation.ID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error inserting annotation: ", err)
+		}
+		return err
+	}
+
+	annotation.CreatedAt, annotation.UpdatedAt = now, now
+	return nil
+}
+
+func (r *SqliteRepository) GetAnnotation(ctx context.Context, id int) (*models.Annotation, error) {
+	ctx, done := r.trace(ctx, "get_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var annotation models.Annotation
+	row := r.db.QueryRowContext(ctx, `SELECT `+sqliteAnnotationColumns+`
+		FROM annotations a JOIN songs s ON s.id = a.song_id
+		WHERE a.id = ? AND s.library_id = ? AND s.deleted_at IS NULL`+r.hideExplicit("s"), id, r.libraryID)
+	if err := scanSqliteAnnotation(row, &annotation); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error fetching annotation: ", err)
+		}
+		return nil, err
+	}
+
+	return &annotation, nil
+}
+
+func (r *SqliteRepository) GetAnnotations(ctx context.Context, songID int) ([]models.Annotation, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_annotations")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+sqliteAnnotationColumns+`
+		FROM annotations a JOIN songs s ON s.id = a.song_id
+		WHERE a.song_id = ? AND s.library_id = ? AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		ORDER BY a.verse, a.range_start, a.id`, songID, r.libraryID)
+	if err != nil {
+		logger.Error("Error executing GetAnnotations query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []models.Annotation
+	for rows.Next() {
+		var annotation models.Annotation
+		if err := scanSqliteAnnotation(rows, &annotation); err != nil {
+			logger.Error("Error scanning GetAnnotations rows: ", err)
+			return nil, err
+		}
+		annotations = append(annotations, annotation)
+	}
+
+	return annotations, rows.Err()
+}
+
+func (r *SqliteRepository) UpdateAnnotation(ctx context.Context, annotation *models.Annotation) (int64, error) {
+	ctx, done := r.trace(ctx, "update_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, `UPDATE annotations
+		SET verse = ?, unit = ?, range_start = ?, range_end = ?, quote = ?, body = ?, orphaned = ?, updated_at = ?
+		WHERE id = ? AND song_id IN (SELECT id FROM songs WHERE library_id = ? AND deleted_at IS NULL)`,
+		annotation.Verse, annotation.Unit, annotation.Start, annotation.End, annotation.Quote, annotation.Body, annotation.Orphaned,
+		unixNano(now), annotation.ID, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error updating annotation: ", err)
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if n > 0 {
+		annotation.UpdatedAt = now
+	}
+	return n, err
+}
+
+func (r *SqliteRepository) DeleteAnnotation(ctx context.Context, id int) (int64, error) {
+	ctx, done := r.trace(ctx, "delete_annotation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM annotations
+		WHERE id = ? AND song_id IN (SELECT id FROM songs WHERE library_id = ? AND deleted_at IS NULL)`, id, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error deleting annotation: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// SetAnnotationVote runs in a transaction so that the total always matches
+// the votes. It returns sql.ErrNoRows if the annotation is not in the
+// library.
+func (r *SqliteRepository) SetAnnotationVote(ctx context.Context, id int, actor string, value int) error {
+	ctx, done := r.trace(ctx, "set_annotation_vote")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	return r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*SqliteRepository)
+		logger := log.FromContext(ctx, r.logger)
+
+		if _, err := tx.GetAnnotation(ctx, id); err != nil {
+			return err
+		}
+
+		var err error
+		if value == 0 {
+			_, err = tx.db.ExecContext(ctx, `DELETE FROM annotation_votes WHERE annotation_id = ? AND actor = ?`, id, actor)
+		} else {
+			_, err = tx.db.ExecContext(ctx, `INSERT INTO annotation_votes (annotation_id, actor, value) VALUES (?, ?, ?)
+				ON CONFLICT (annotation_id, actor) DO UPDATE SET value = excluded.value`, id, actor, value)
+		}
+		if err != nil {
+			logger.Error("Error saving annotation vote: ", err)
+			return err
+		}
+
+		_, err = tx.db.ExecContext(ctx, `UPDATE annotations
+			SET votes = (SELECT COALESCE(SUM(value), 0) FROM annotation_votes WHERE annotation_id = ?)
+			WHERE id = ?`, id, id)
+		if err != nil {
+			logger.Error("Error updating annotation votes: ", err)
+		}
+		return err
+	})
+}
+
+// sqliteIn returns the placeholders of an IN list of ids and appends the ids
+// to args.
+func sqliteIn(ids []int, args []interface{}) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+	return "(" + strings.Join(placeholders, ", ") + ")", args
+}
+
+// sqliteTagValues returns a VALUES list of the type and name of tags and
+// appends them to args.
+func sqliteTagValues(tags []models.Tag, args []interface{}) (string, []interface{}) {
+	rows := make([]string, len(tags))
+	for i, tag := range tags {
+		rows[i] = "(?, ?)"
+		args = append(args, tag.Type, tag.Name)
+	}
+	return "VALUES " + strings.Join(rows, ", "), args
+}
+
+func (r *SqliteRepository) GetTags(ctx context.Context, tagType string) ([]models.TagCount, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_tags")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT t.type, t.name, COUNT(s.id)
+		FROM tags t
+		LEFT JOIN song_tags st ON st.tag_id = t.id
+		LEFT JOIN songs s ON s.id = st.song_id AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		WHERE t.library_id = ? AND (? = '' OR t.type = ?)
+		GROUP BY t.id, t.type, t.name
+		ORDER BY t.type, t.name`, r.libraryID, tagType, tagType)
+	if err != nil {
+		logger.Error("Error executing GetTags query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.TagCount
+	for rows.Next() {
+		var tag models.TagCount
+		if err := rows.Scan(&tag.Type, &tag.Name, &tag.Count); err != nil {
+			logger.Error("Error scanning GetTags rows: ", err)
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (r *SqliteRepository) GetSongTags(ctx context.Context, songIDs []int) (map[int][]models.Tag, error) {
+	ctx, done := r.trace(ctx, "get_song_tags")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	in, args := sqliteIn(songIDs, []interface{}{r.libraryID})
+	rows, err := r.db.QueryContext(ctx, `SELECT s.id, t.type, t.name
+		FROM songs s
+		LEFT JOIN song_tags st ON st.song_id = s.id
+		LEFT JOIN tags t ON t.id = st.tag_id
+		WHERE s.library_id = ? AND s.deleted_at IS NULL AND s.id IN `+in+r.hideExplicit("s")+`
+		ORDER BY s.id, t.type, t.name`, args...)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error executing GetSongTags query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSongTags(rows)
+}
+
+func (r *SqliteRepository) TagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error) {
+	ctx, done := r.trace(ctx, "tag_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	var added int64
+	err := r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*SqliteRepository)
+		logger := log.FromContext(ctx, r.logger)
+
+		values, args := sqliteTagValues(tags, []interface{}{r.libraryID})
+		_, err := tx.db.ExecContext(ctx, `INSERT INTO tags (library_id, type, name)
+			SELECT ?, v.column1, v.column2 FROM (`+values+`) v
+			WHERE true
+			ON CONFLICT (library_id, type, name) DO NOTHING`, args...)
+		if err != nil {
+			logger.Error("Error creating tags: ", err)
+			return err
+		}
+
+		values, args = sqliteTagValues(tags, nil)
+		in, args := sqliteIn(songIDs, append(args, r.libraryID))
+		result, err := tx.db.ExecContext(ctx, `INSERT INTO song_tags (song_id, tag_id)
+			SELECT s.id, t.id
+			FROM songs s
+			JOIN tags t ON t.library_id = s.library_id
+			JOIN (`+values+`) v ON v.column1 = t.type AND v.column2 = t.name
+			WHERE s.library_id = ? AND s.deleted_at IS NULL AND s.id IN `+in+`
+			ON CONFLICT (song_id, tag_id) DO NOTHING`, args...)
+		if err != nil {
+			logger.Error("Error tagging songs: ", err)
+			return err
+		}
+
+		added, err = result.RowsAffected()
+		return err
+	})
+	return added, err
+}
+
+func (r *SqliteRepository) UntagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error) {
+	ctx, done := r.trace(ctx, "untag_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	values, args := sqliteTagValues(tags, []interface{}{r.libraryID})
+	in, args := sqliteIn(songIDs, append(args, r.libraryID))
+	result, err := r.db.ExecContext(ctx, `DELETE FROM song_tags
+		WHERE tag_id IN (SELECT id FROM tags WHERE library_id = ? AND (type, name) IN (`+values+`))
+			AND song_id IN (SELECT id FROM songs WHERE library_id = ? AND deleted_at IS NULL AND id IN `+in+`)`, args...)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error untagging songs: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *SqliteRepository) GetSongFacets(ctx context.Context, filter map[string]string, limit int) (*models.SongFacets, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_song_facets")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+	if err := checkSongFilter(filter); err != nil {
+		return nil, err
+	}
+
+	conditions, args, err := sqliteSongConditions(filter, []interface{}{r.libraryID})
+	if err != nil {
+		return nil, err
+	}
+	matched := "SELECT id FROM songs WHERE library_id = ? AND deleted_at IS NULL" + r.hideExplicit("") + conditions
+
+	facets := &models.SongFacets{Tags: []models.TagCount{}, Years: []models.YearCount{}, Groups: []models.GroupCount{}}
+	err = r.queryFacet(ctx, `SELECT t.type, t.name, COUNT(*)
+		FROM song_tags st JOIN tags t ON t.id = st.tag_id
+		WHERE st.song_id IN (`+matched+`)
+		GROUP BY t.type, t.name
+		ORDER BY COUNT(*) DESC, t.type, t.name`, args, func(rows *sql.Rows) error {
+		var tag models.TagCount
+		if err := rows.Scan(&tag.Type, &tag.Name, &tag.Count); err != nil {
+			return err
+		}
+		facets.Tags = append(facets.Tags, tag)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error counting songs per tag: ", err)
+		return nil, err
+	}
+
+	err = r.queryFacet(ctx, `SELECT CAST(substr(release_date, 1, 4) AS INTEGER) AS year, COUNT(*)
+		FROM songs
+		WHERE id IN (`+matched+`) AND release_date IS NOT NULL
+		GROUP BY year
+		ORDER BY year`, args, func(rows *sql.Rows) error {
+		var year models.YearCount
+		if err := rows.Scan(&year.Year, &year.Count); err != nil {
+			return err
+		}
+		facets.Years = append(facets.Years, year)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error counting songs per year: ", err)
+		return nil, err
+	}
+
+	err = r.queryFacet(ctx, `SELECT group_name, COUNT(*)
+		FROM songs
+		WHERE id IN (`+matched+`)
+		GROUP BY group_name
+		ORDER BY COUNT(*) DESC, group_name
+		LIMIT ?`, append(args, limit), func(rows *sql.Rows) error {
+		var group models.GroupCount
+		if err := rows.Scan(&group.Group, &group.Count); err != nil {
+			return err
+		}
+		facets.Groups = append(facets.Groups, group)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error counting songs per group: ", err)
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+func (r *SqliteRepository) queryFacet(ctx context.Context, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const sqliteTranslationColumns = `t.id, t.song_id, t.language, t.text, t.translator, t.alignment, t.created_by, t.created_at, t.updated_at`
+
+func scanSqliteTranslation(row interface{ Scan(...interface{}) error }, t *models.Translation) error {
+	var alignment string
+	var createdAt, updatedAt int64
+	if err := row.Scan(&t.ID, &t.SongID, &t.Language, &t.Text, &t.Translator, &alignment, &t.CreatedBy, &createdAt, &updatedAt); err != nil {
+		return err
+	}
+	t.Alignment = []int{}
+	for _, field := range strings.Fields(alignment) {
+		verse, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("invalid alignment %q: %w", alignment, err)
+		}
+		t.Alignment = append(t.Alignment, verse)
+	}
+	t.CreatedAt, t.UpdatedAt = time.Unix(0, createdAt), time.Unix(0, updatedAt)
+	return nil
+}
+
+func sqliteAlignment(alignment []int) string {
+	fields := make([]string, len(alignment))
+	for i, verse := range alignment {
+		fields[i] = strconv.Itoa(verse)
+	}
+	return strings.Join(fields, " ")
+}
+
+func (r *SqliteRepository) SaveTranslation(ctx context.Context, translation *models.Translation) error {
+	ctx, done := r.trace(ctx, "save_translation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	now := unixNano(time.Now())
+	var createdAt, updatedAt int64
+	err := r.db.QueryRowContext(ctx, `INSERT INTO song_translations (song_id, language, text, translator, alignment, created_by, created_at, updated_at)
+		SELECT id, ?, ?, ?, ?, ?, ?, ? FROM songs WHERE id = ? AND library_id = ? AND deleted_at IS NULL
+		ON CONFLICT (song_id, language) DO UPDATE
+			SET text = excluded.text, translator = excluded.translator, alignment = excluded.alignment, updated_at = excluded.updated_at
+		RETURNING id, created_by, created_at, updated_at`,
+		translation.Language, translation.Text, translation.Translator, sqliteAlignment(translation.Alignment),
+		translation.CreatedBy, now, now, translation.SongID, r.libraryID,
+	).Scan(&translation.ID, &translation.CreatedBy, &createdAt, &updatedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error saving translation: ", err)
+		}
+		return err
+	}
+
+	translation.CreatedAt, translation.UpdatedAt = time.Unix(0, createdAt), time.Unix(0, updatedAt)
+	return nil
+}
+
+func (r *SqliteRepository) GetTranslation(ctx context.Context, songID int, language string) (*models.Translation, error) {
+	ctx, done := r.trace(ctx, "get_translation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var translation models.Translation
+	row := r.db.QueryRowContext(ctx, `SELECT `+sqliteTranslationColumns+`
+		FROM song_translations t JOIN songs s ON s.id = t.song_id
+		WHERE t.song_id = ? AND t.language = ? AND s.library_id = ? AND s.deleted_at IS NULL`+r.hideExplicit("s"),
+		songID, language, r.libraryID)
+	if err := scanSqliteTranslation(row, &translation); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, r.logger).Error("Error fetching translation: ", err)
+		}
+		return nil, err
+	}
+
+	return &translation, nil
+}
+
+func (r *SqliteRepository) GetTranslations(ctx context.Context, songID int) ([]models.Translation, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_translations")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+sqliteTranslationColumns+`
+		FROM song_translations t JOIN songs s ON s.id = t.song_id
+		WHERE t.song_id = ? AND s.library_id = ? AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		ORDER BY t.language`, songID, r.libraryID)
+	if err != nil {
+		logger.Error("Error executing GetTranslations query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var translations []models.Translation
+	for rows.Next() {
+		var translation models.Translation
+		if err := scanSqliteTranslation(rows, &translation); err != nil {
+			logger.Error("Error scanning GetTranslations rows: ", err)
+			return nil, err
+		}
+		translations = append(translations, translation)
+	}
+
+	return translations, rows.Err()
+}
+
+func (r *SqliteRepository) DeleteTranslation(ctx context.Context, songID int, language string) (int64, error) {
+	ctx, done := r.trace(ctx, "delete_translation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM song_translations
+		WHERE song_id = ? AND language = ?
+			AND song_id IN (SELECT id FROM songs WHERE library_id = ? AND deleted_at IS NULL)`,
+		songID, language, r.libraryID)
+	if err != nil {
+		log.FromContext(ctx, r.logger).Error("Error deleting translation: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
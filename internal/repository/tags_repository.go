@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/lib/pq"
+)
+
+// tagArrays splits tags into the type and name arrays the queries unnest.
+func tagArrays(tags []models.Tag) (interface{}, interface{}) {
+	types, names := make([]string, len(tags)), make([]string, len(tags))
+	for i, tag := range tags {
+		types[i], names[i] = tag.Type, tag.Name
+	}
+	return pq.Array(types), pq.Array(names)
+}
+
+func idArray(ids []int) interface{} {
+	array := make([]int64, len(ids))
+	for i, id := range ids {
+		array[i] = int64(id)
+	}
+	return pq.Array(array)
+}
+
+func (r *ApiRepository) GetTags(ctx context.Context, tagType string) ([]models.TagCount, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_tags")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, `SELECT t.type, t.name, COUNT(s.id)
+		FROM tags t
+		LEFT JOIN song_tags st ON st.tag_id = t.id
+		LEFT JOIN songs s ON s.id = st.song_id AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		WHERE t.library_id = $1 AND ($2::text = '' OR t.type = $2::text)
+		GROUP BY t.id, t.type, t.name
+		ORDER BY t.type, t.name`, r.libraryID, tagType)
+	if err != nil {
+		logger.Error("Error executing GetTags query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []models.TagCount
+	for rows.Next() {
+		var tag models.TagCount
+		if err := rows.Scan(&tag.Type, &tag.Name, &tag.Count); err != nil {
+			logger.Error("Error scanning GetTags rows: ", err)
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (r *ApiRepository) GetSongTags(ctx context.Context, songIDs []int) (map[int][]models.Tag, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_song_tags")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.db.QueryContext(ctx, `SELECT s.id, t.type, t.name
+		FROM songs s
+		LEFT JOIN song_tags st ON st.song_id = s.id
+		LEFT JOIN tags t ON t.id = st.tag_id
+		WHERE s.library_id = $1 AND s.deleted_at IS NULL AND s.id = ANY($2::int[])`+r.hideExplicit("s")+`
+		ORDER BY s.id, t.type, t.name`, r.libraryID, idArray(songIDs))
+	if err != nil {
+		logger.Error("Error executing GetSongTags query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSongTags(rows)
+}
+
+// scanSongTags collects rows of song ID, tag type and tag name, where the
+// tag is NULL for songs without tags.
+func scanSongTags(rows *sql.Rows) (map[int][]models.Tag, error) {
+	songTags := map[int][]models.Tag{}
+	for rows.Next() {
+		var songID int
+		var tagType, name sql.NullString
+		if err := rows.Scan(&songID, &tagType, &name); err != nil {
+			return nil, err
+		}
+		if _, ok := songTags[songID]; !ok {
+			songTags[songID] = []models.Tag{}
+		}
+		if tagType.Valid {
+			songTags[songID] = append(songTags[songID], models.Tag{Type: tagType.String, Name: name.String})
+		}
+	}
+	return songTags, rows.Err()
+}
+
+func (r *ApiRepository) TagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error) {
+	ctx, done := r.trace(ctx, "tag_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	types, names := tagArrays(tags)
+
+	var added int64
+	err := r.WithTx(ctx, func(repo Repository) error {
+		tx := repo.(*ApiRepository)
+		logger := log.FromContext(ctx, r.logger)
+
+		_, err := tx.db.ExecContext(ctx, `INSERT INTO tags (library_id, type, name)
+			SELECT $1, v.type, v.name FROM unnest($2::text[], $3::text[]) AS v(type, name)
+			ON CONFLICT (library_id, type, name) DO NOTHING`, r.libraryID, types, names)
+		if err != nil {
+			logger.Error("Error creating tags: ", err)
+			return err
+		}
+
+		result, err := tx.db.ExecContext(ctx, `INSERT INTO song_tags (song_id, tag_id)
+			SELECT s.id, t.id
+			FROM songs s
+			JOIN tags t ON t.library_id = s.library_id
+			JOIN unnest($2::text[], $3::text[]) AS v(type, name) ON v.type = t.type AND v.name = t.name
+			WHERE s.library_id = $1 AND s.deleted_at IS NULL AND s.id = ANY($4::int[])
+			ON CONFLICT (song_id, tag_id) DO NOTHING`, r.libraryID, types, names, idArray(songIDs))
+		if err != nil {
+			logger.Error("Error tagging songs: ", err)
+			return err
+		}
+
+		added, err = result.RowsAffected()
+		return err
+	})
+	return added, err
+}
+
+func (r *ApiRepository) UntagSongs(ctx context.Context, songIDs []int, tags []models.Tag) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "untag_songs")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	types, names := tagArrays(tags)
+	result, err := r.db.ExecContext(ctx, `DELETE FROM song_tags st
+		USING songs s, tags t, unnest($2::text[], $3::text[]) AS v(type, name)
+		WHERE st.song_id = s.id AND st.tag_id = t.id AND v.type = t.type AND v.name = t.name
+			AND s.library_id = $1 AND s.deleted_at IS NULL AND s.id = ANY($4::int[]) AND t.library_id = $1`,
+		r.libraryID, types, names, idArray(songIDs))
+	if err != nil {
+		logger.Error("Error untagging songs: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (r *ApiRepository) GetSongFacets(ctx context.Context, filter map[string]string, limit int) (*models.SongFacets, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_song_facets")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+	if err := checkSongFilter(filter); err != nil {
+		return nil, err
+	}
+
+	conditions, args := songConditions(filter, []interface{}{r.libraryID})
+	matched := "SELECT id FROM songs WHERE library_id = $1 AND deleted_at IS NULL" + r.hideExplicit("") + conditions
+
+	facets := &models.SongFacets{Tags: []models.TagCount{}, Years: []models.YearCount{}, Groups: []models.GroupCount{}}
+	err := r.queryFacet(ctx, `SELECT t.type, t.name, COUNT(*)
+		FROM song_tags st JOIN tags t ON t.id = st.tag_id
+		WHERE st.song_id IN (`+matched+`)
+		GROUP BY t.type, t.name
+		ORDER BY COUNT(*) DESC, t.type, t.name`, args, func(rows *sql.Rows) error {
+		var tag models.TagCount
+		if err := rows.Scan(&tag.Type, &tag.Name, &tag.Count); err != nil {
+			return err
+		}
+		facets.Tags = append(facets.Tags, tag)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error counting songs per tag: ", err)
+		return nil, err
+	}
+
+	err = r.queryFacet(ctx, `SELECT EXTRACT(YEAR FROM release_date)::int AS year, COUNT(*)
+		FROM songs
+		WHERE id IN (`+matched+`) AND release_date IS NOT NULL
+		GROUP BY year
+		ORDER BY year`, args, func(rows *sql.Rows) error {
+		var year models.YearCount
+		if err := rows.Scan(&year.Year, &year.Count); err != nil {
+			return err
+		}
+		facets.Years = append(facets.Years, year)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error counting songs per year: ", err)
+		return nil, err
+	}
+
+	err = r.queryFacet(ctx, `SELECT group_name, COUNT(*)
+		FROM songs
+		WHERE id IN (`+matched+`)
+		GROUP BY group_name
+		ORDER BY COUNT(*) DESC, group_name
+		LIMIT `+fmt.Sprintf("$%d", len(args)+1), append(args, limit), func(rows *sql.Rows) error {
+		var group models.GroupCount
+		if err := rows.Scan(&group.Group, &group.Count); err != nil {
+			return err
+		}
+		facets.Groups = append(facets.Groups, group)
+		return nil
+	})
+	if err != nil {
+		logger.Error("Error counting songs per group: ", err)
+		return nil, err
+	}
+
+	return facets, nil
+}
+
+func (r *ApiRepository) queryFacet(ctx context.Context, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := r.replica.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
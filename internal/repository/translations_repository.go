@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/lib/pq"
+)
+
+const translationColumns = `t.id, t.song_id, t.language, t.text, t.translator, t.alignment, t.created_by, t.created_at, t.updated_at`
+
+func scanTranslation(row interface{ Scan(...interface{}) error }, t *models.Translation) error {
+	var alignment []int64
+	if err := row.Scan(&t.ID, &t.SongID, &t.Language, &t.Text, &t.Translator, pq.Array(&alignment), &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return err
+	}
+	t.Alignment = make([]int, len(alignment))
+	for i, verse := range alignment {
+		t.Alignment[i] = int(verse)
+	}
+	return nil
+}
+
+func (r *ApiRepository) SaveTranslation(ctx context.Context, translation *models.Translation) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "save_translation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return ErrLibraryRequired
+	}
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO song_translations (song_id, language, text, translator, alignment, created_by)
+		SELECT id, $2, $3, $4, $5, $6 FROM songs WHERE id = $1 AND library_id = $7 AND deleted_at IS NULL
+		ON CONFLICT (song_id, language) DO UPDATE
+			SET text = EXCLUDED.text, translator = EXCLUDED.translator, alignment = EXCLUDED.alignment, updated_at = NOW()
+		RETURNING id, created_by, created_at, updated_at`,
+		translation.SongID, translation.Language, translation.Text, translation.Translator,
+		idArray(translation.Alignment), translation.CreatedBy, r.libraryID,
+	).Scan(&translation.ID, &translation.CreatedBy, &translation.CreatedAt, &translation.UpdatedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error saving translation: ", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) GetTranslation(ctx context.Context, songID int, language string) (*models.Translation, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_translation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	var translation models.Translation
+	row := r.db.QueryRowContext(ctx, `SELECT `+translationColumns+`
+		FROM song_translations t JOIN songs s ON s.id = t.song_id
+		WHERE t.song_id = $1 AND t.language = $2 AND s.library_id = $3 AND s.deleted_at IS NULL`+r.hideExplicit("s"),
+		songID, language, r.libraryID)
+	if err := scanTranslation(row, &translation); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logger.Error("Error fetching translation: ", err)
+		}
+		return nil, err
+	}
+
+	return &translation, nil
+}
+
+func (r *ApiRepository) GetTranslations(ctx context.Context, songID int) ([]models.Translation, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "get_translations")
+	defer done()
+
+	if r.libraryID == 0 {
+		return nil, ErrLibraryRequired
+	}
+
+	rows, err := r.replica.QueryContext(ctx, `SELECT `+translationColumns+`
+		FROM song_translations t JOIN songs s ON s.id = t.song_id
+		WHERE t.song_id = $1 AND s.library_id = $2 AND s.deleted_at IS NULL`+r.hideExplicit("s")+`
+		ORDER BY t.language`, songID, r.libraryID)
+	if err != nil {
+		logger.Error("Error executing GetTranslations query: ", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var translations []models.Translation
+	for rows.Next() {
+		var translation models.Translation
+		if err := scanTranslation(rows, &translation); err != nil {
+			logger.Error("Error scanning GetTranslations rows: ", err)
+			return nil, err
+		}
+		translations = append(translations, translation)
+	}
+
+	return translations, rows.Err()
+}
+
+func (r *ApiRepository) DeleteTranslation(ctx context.Context, songID int, language string) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "delete_translation")
+	defer done()
+
+	if r.libraryID == 0 {
+		return 0, ErrLibraryRequired
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM song_translations
+		WHERE song_id = $1 AND language = $2
+			AND song_id IN (SELECT id FROM songs WHERE library_id = $3 AND deleted_at IS NULL)`,
+		songID, language, r.libraryID)
+	if err != nil {
+		logger.Error("Error deleting translation: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// dbtx is what queries need from *sql.DB and *sql.Tx, so the same
+// repository code runs inside and outside a transaction.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// TxOptions configures a transaction started by WithTxOptions.
+type TxOptions struct {
+	// Isolation defaults to the server default, READ COMMITTED.
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// MaxAttempts is how often the transaction is run when it fails with a
+	// serialization failure or deadlock. Zero means DefaultTxAttempts.
+	MaxAttempts int
+}
+
+// DefaultTxAttempts is the number of attempts when TxOptions.MaxAttempts is
+// not set.
+const DefaultTxAttempts = 3
+
+// txRetryDelay is the base delay before retrying a failed transaction. It
+// doubles with every attempt and is jittered.
+const txRetryDelay = 20 * time.Millisecond
+
+// WithTx runs fn in a READ COMMITTED transaction. See WithTxOptions.
+func (r *ApiRepository) WithTx(ctx context.Context, fn func(repo Repository) error) error {
+	return r.WithTxOptions(ctx, TxOptions{}, fn)
+}
+
+func (r *ApiRepository) WithLibraryTx(ctx context.Context, fn func(repo LibraryRepository) error) error {
+	return r.WithTx(ctx, func(repo Repository) error {
+		return fn(repo.(*ApiRepository))
+	})
+}
+
+// WithTxOptions runs fn with a repository bound to a new transaction,
+// scoped to the same library. The transaction commits if fn returns nil and
+// rolls back otherwise. On serialization failures and deadlocks the whole
+// transaction, fn included, is retried, so fn must not have side effects
+// outside the database. Called on a repository that is already in a
+// transaction, fn joins it and opts are ignored.
+func (r *ApiRepository) WithTxOptions(ctx context.Context, opts TxOptions, fn func(repo Repository) error) (err error) {
+	if r.inTx {
+		return fn(r)
+	}
+
+	ctx, span := tracing.Start(ctx, "repository.transaction",
+		attribute.String("db.isolation", opts.Isolation.String()),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	attempts, err := retryTx(ctx, r.logger, opts, func() error {
+		return r.runTx(ctx, opts, fn)
+	})
+	span.SetAttributes(attribute.Int("db.tx.attempts", attempts))
+	return err
+}
+
+// retryTx runs the transaction run until it succeeds, fails with an error
+// that is not retryable or has run opts.MaxAttempts times, backing off in
+// between, and returns the number of attempts made. Every store retries the
+// same way, whether the conflict came from the database or from fn.
+func retryTx(ctx context.Context, logger *logrus.Logger, opts TxOptions, run func() error) (int, error) {
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultTxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := run()
+		if err == nil || !retryable(err) || attempt >= attempts {
+			return attempt, err
+		}
+
+		delay := txRetryDelay << (attempt - 1)
+		delay += time.Duration(rand.Int63n(int64(delay)))
+		log.FromContext(ctx, logger).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("Retrying transaction after conflict: ", err)
+
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *ApiRepository) runTx(ctx context.Context, opts TxOptions, fn func(repo Repository) error) (err error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	tx, err := r.pool.BeginTx(ctx, &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		logger.Error("Error starting transaction: ", err)
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	// Reads inside the transaction go to the primary too, so that they see
+	// the transaction's own writes.
+	txRepo := &ApiRepository{
+		db:        tx,
+		replica:   tx,
+		pool:      r.pool,
+		logger:    r.logger,
+		libraryID: r.libraryID,
+		inTx:      true,
+		clean:     r.clean,
+	}
+
+	if err := fn(txRepo); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			logger.Error("Error rolling back transaction: ", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error("Error committing transaction: ", err)
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether err is a serialization failure or a deadlock,
+// after which the transaction can simply be run again.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return true
+	}
+	return false
+}
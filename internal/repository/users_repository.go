@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+func (r *ApiRepository) AddUser(ctx context.Context, user *models.User) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_user")
+	defer done()
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO users (username, password_hash, role, library_id)
+		VALUES ($1, $2, $3, (SELECT id FROM libraries WHERE slug = NULLIF($4, ''))) RETURNING id, created_at`,
+		user.Username, user.PasswordHash, user.Role, user.Library,
+	).Scan(&user.ID, &user.CreatedAt)
+	if err != nil {
+		logger.Error("Error inserting user: ", err)
+		return err
+	}
+
+	logger.Infof("User '%s' created", user.Username)
+	return nil
+}
+
+func (r *ApiRepository) GetUserByName(ctx context.Context, username string) (*models.User, error) {
+	ctx, done := r.trace(ctx, "get_user")
+	defer done()
+
+	var user models.User
+	err := r.db.QueryRowContext(ctx, `SELECT u.id, u.username, u.password_hash, u.role, COALESCE(l.slug, ''), u.created_at
+		FROM users u LEFT JOIN libraries l ON l.id = u.library_id
+		WHERE u.username = $1`, username).Scan(
+		&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Library, &user.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *ApiRepository) AddSession(ctx context.Context, session *models.Session) error {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "add_session")
+	defer done()
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO sessions (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3) RETURNING id, created_at`,
+		session.UserID, session.Hash, session.ExpiresAt,
+	).Scan(&session.ID, &session.CreatedAt)
+	if err != nil {
+		logger.Error("Error inserting session: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func (r *ApiRepository) GetSessionByHash(ctx context.Context, hash string) (*models.Session, error) {
+	ctx, done := r.trace(ctx, "get_session")
+	defer done()
+
+	var session models.Session
+	err := r.db.QueryRowContext(ctx, `SELECT s.id, s.user_id, u.username, u.role, COALESCE(l.slug, ''), s.token_hash, s.created_at, s.expires_at, s.revoked_at
+		FROM sessions s JOIN users u ON u.id = s.user_id LEFT JOIN libraries l ON l.id = u.library_id
+		WHERE s.token_hash = $1`, hash).Scan(
+		&session.ID, &session.UserID, &session.Username, &session.Role, &session.Library, &session.Hash, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *ApiRepository) RevokeSession(ctx context.Context, id int) (int64, error) {
+	logger := log.FromContext(ctx, r.logger)
+
+	ctx, done := r.trace(ctx, "revoke_session")
+	defer done()
+
+	result, err := r.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		logger.Error("Error revoking session: ", err)
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,442 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrAnnotationNotFound = errors.New("annotation not found")
+	ErrInvalidAnnotation  = errors.New("invalid annotation")
+	// ErrAnnotationForbidden is returned when someone other than the author
+	// or an admin changes an annotation.
+	ErrAnnotationForbidden = errors.New("only the author or an admin may change the annotation")
+)
+
+const maxAnnotationBody = 2000
+
+// annotationTx is the isolation of annotation writes. The quote is taken
+// from the song text, so a concurrent change to the text makes the
+// transaction retry instead of anchoring to the old text.
+var annotationTx = repository.TxOptions{Isolation: sql.LevelRepeatableRead}
+
+// annotator returns the library scoped repository and the actor the request
+// writes as.
+func (s *ApiAnnotationService) annotator(ctx context.Context) (repository.Repository, string, error) {
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil, "", ErrAuthenticationRequired
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Annotation")
+	if err != nil {
+		return nil, "", err
+	}
+	return repo, principal.Actor(), nil
+}
+
+func (s *ApiAnnotationService) songText(ctx context.Context, repo repository.Repository, songID int) (string, error) {
+	song, err := repo.GetSong(ctx, songID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+		}
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch song: ", err)
+		return "", err
+	}
+	return song.Text, nil
+}
+
+// editable fetches an annotation the caller may change: their own, or any
+// if they are an admin.
+func (s *ApiAnnotationService) editable(ctx context.Context, repo repository.Repository, actor string, id int) (*models.Annotation, error) {
+	annotation, err := repo.GetAnnotation(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrAnnotationNotFound, id)
+		}
+		log.FromContext(ctx, s.logger).WithField("annotationID", id).Error("Failed to fetch annotation: ", err)
+		return nil, err
+	}
+
+	if annotation.Author != actor && !auth.PrincipalFromContext(ctx).HasScope(auth.ScopeAdmin) {
+		return nil, ErrAnnotationForbidden
+	}
+	return annotation, nil
+}
+
+func annotationBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	switch {
+	case body == "":
+		return "", fmt.Errorf("%w: body is required", ErrInvalidAnnotation)
+	case utf8.RuneCountInString(body) > maxAnnotationBody:
+		return "", fmt.Errorf("%w: body is longer than %d characters", ErrInvalidAnnotation, maxAnnotationBody)
+	}
+	return body, nil
+}
+
+// anchorQuote returns the part of text the anchor points at. Lines are
+// counted within the verse and characters within the verse's text; both
+// ranges are zero based and exclude the end.
+func anchorQuote(text string, anchor models.AnnotationAnchor) (string, error) {
+	verses := repository.SplitVerses(text)
+	if anchor.Verse < 0 || anchor.Verse >= len(verses) {
+		return "", fmt.Errorf("%w: the song has no verse %d", ErrInvalidAnnotation, anchor.Verse)
+	}
+	if anchor.Start < 0 || anchor.End <= anchor.Start {
+		return "", fmt.Errorf("%w: the range must be non-empty and start at 0 or later", ErrInvalidAnnotation)
+	}
+
+	var quote string
+	switch anchor.Unit {
+	case models.AnchorLines:
+		lines := strings.Split(verses[anchor.Verse], "\n")
+		if anchor.End > len(lines) {
+			return "", fmt.Errorf("%w: verse %d has %d lines", ErrInvalidAnnotation, anchor.Verse, len(lines))
+		}
+		quote = strings.Join(lines[anchor.Start:anchor.End], "\n")
+	case models.AnchorChars:
+		runes := []rune(verses[anchor.Verse])
+		if anchor.End > len(runes) {
+			return "", fmt.Errorf("%w: verse %d has %d characters", ErrInvalidAnnotation, anchor.Verse, len(runes))
+		}
+		quote = string(runes[anchor.Start:anchor.End])
+	default:
+		return "", fmt.Errorf("%w: unit must be %s or %s", ErrInvalidAnnotation, models.AnchorLines, models.AnchorChars)
+	}
+
+	if strings.TrimSpace(quote) == "" {
+		return "", fmt.Errorf("%w: the range covers only whitespace", ErrInvalidAnnotation)
+	}
+	return quote, nil
+}
+
+// quoteMatches returns the starts of the ranges of verse that read quote, in
+// the annotation's unit.
+func quoteMatches(verse, quote, unit string) []int {
+	var starts []int
+	if unit == models.AnchorLines {
+		lines, want := strings.Split(verse, "\n"), strings.Split(quote, "\n")
+		for i := 0; i+len(want) <= len(lines); i++ {
+			if strings.Join(lines[i:i+len(want)], "\n") == quote {
+				starts = append(starts, i)
+			}
+		}
+		return starts
+	}
+
+	for offset, rest := 0, verse; ; {
+		i := strings.Index(rest, quote)
+		if i < 0 {
+			return starts
+		}
+		start := offset + utf8.RuneCountInString(rest[:i])
+		starts = append(starts, start)
+		_, size := utf8.DecodeRuneInString(rest[i:])
+		offset, rest = start+1, rest[i+size:]
+	}
+}
+
+// remapAnchor points the annotation at its quote in the new text, choosing
+// the occurrence nearest to the old anchor: in the same verse if possible,
+// then the closest start. It reports false if the quote is gone.
+func remapAnchor(text string, annotation *models.Annotation) bool {
+	if quote, err := anchorQuote(text, annotation.AnnotationAnchor); err == nil && quote == annotation.Quote {
+		return true
+	}
+
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+
+	found := false
+	best := annotation.AnnotationAnchor
+	for verse, verseText := range repository.SplitVerses(text) {
+		for _, start := range quoteMatches(verseText, annotation.Quote, annotation.Unit) {
+			verseDist, startDist := abs(verse-annotation.Verse), abs(start-annotation.Start)
+			bestVerseDist, bestStartDist := abs(best.Verse-annotation.Verse), abs(best.Start-annotation.Start)
+			if found && (verseDist > bestVerseDist || verseDist == bestVerseDist && startDist >= bestStartDist) {
+				continue
+			}
+
+			found = true
+			best.Verse, best.Start = verse, start
+		}
+	}
+	if !found {
+		return false
+	}
+
+	length := annotation.End - annotation.Start
+	best.End = best.Start + length
+	annotation.AnnotationAnchor = best
+	return true
+}
+
+// remapAnnotations moves the annotations of a song whose text changed to
+// where their quotes are in the new text. Annotations whose quote is gone
+// are flagged as orphaned; they are kept and come back if the quote does.
+func remapAnnotations(ctx context.Context, repo repository.Repository, baseLogger *logrus.Logger, songID int, text string) error {
+	logger := log.FromContext(ctx, baseLogger)
+
+	annotations, err := repo.GetAnnotations(ctx, songID)
+	if err != nil {
+		logger.WithField("songID", songID).Error("Failed to fetch annotations: ", err)
+		return err
+	}
+
+	var moved, orphaned int
+	for i := range annotations {
+		annotation := &annotations[i]
+		before := *annotation
+
+		annotation.Orphaned = !remapAnchor(text, annotation)
+		if annotation.AnnotationAnchor == before.AnnotationAnchor && annotation.Orphaned == before.Orphaned {
+			continue
+		}
+
+		if _, err := repo.UpdateAnnotation(ctx, annotation); err != nil {
+			logger.WithField("annotationID", annotation.ID).Error("Failed to remap annotation: ", err)
+			return err
+		}
+		if annotation.Orphaned {
+			orphaned++
+		} else {
+			moved++
+		}
+	}
+
+	if moved > 0 || orphaned > 0 {
+		logger.WithFields(logrus.Fields{
+			"songID":   songID,
+			"moved":    moved,
+			"orphaned": orphaned,
+		}).Info("Annotations remapped")
+	}
+	return nil
+}
+
+// inlineAnnotations attaches to the song the annotations of the verses from
+// offset on that it holds. Orphaned annotations are left out.
+func inlineAnnotations(song *models.Song, annotations []models.Annotation, offset int) {
+	verses := len(repository.SplitVerses(song.Text))
+
+	song.Annotations = []models.Annotation{}
+	for _, annotation := range annotations {
+		if !annotation.Orphaned && annotation.Verse >= offset && annotation.Verse < offset+verses {
+			song.Annotations = append(song.Annotations, annotation)
+		}
+	}
+}
+
+// GetAnnotations lists the annotations of a song in text order, including
+// orphaned ones.
+func (s *ApiAnnotationService) GetAnnotations(ctx context.Context, songID int) (_ []models.Annotation, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetAnnotations", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Annotation")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.songText(ctx, repo, songID); err != nil {
+		return nil, err
+	}
+
+	annotations, err := repo.GetAnnotations(ctx, songID)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch annotations: ", err)
+		return nil, err
+	}
+
+	annotations = append([]models.Annotation{}, annotations...)
+	mask := lyricsMask(ctx, s.words)
+	for i := range annotations {
+		annotations[i].Quote = mask(annotations[i].Quote)
+	}
+	return annotations, nil
+}
+
+// AddAnnotation annotates the part of the song's text the anchor points at.
+func (s *ApiAnnotationService) AddAnnotation(ctx context.Context, songID int, anchor models.AnnotationAnchor, body string) (_ *models.Annotation, err error) {
+	ctx, span := tracing.Start(ctx, "service.AddAnnotation", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	if body, err = annotationBody(body); err != nil {
+		return nil, err
+	}
+
+	repo, actor, err := s.annotator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	annotation := &models.Annotation{SongID: songID, AnnotationAnchor: anchor, Body: body, Author: actor}
+	err = repo.WithTxOptions(ctx, annotationTx, func(repo repository.Repository) error {
+		text, err := s.songText(ctx, repo, songID)
+		if err != nil {
+			return err
+		}
+		if annotation.Quote, err = anchorQuote(text, anchor); err != nil {
+			return err
+		}
+
+		if err := repo.AddAnnotation(ctx, annotation); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+			}
+			log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to add annotation: ", err)
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionCreate, audit.EntityAnnotation, annotation.ID, nil, annotation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"annotationID": annotation.ID,
+		"songID":       songID,
+	}).Info("Annotation added")
+	annotation.Quote = lyricsMask(ctx, s.words)(annotation.Quote)
+	return annotation, nil
+}
+
+// UpdateAnnotation changes the body or the anchor of an annotation. A new
+// anchor is checked against the current text and clears the orphaned flag.
+func (s *ApiAnnotationService) UpdateAnnotation(ctx context.Context, id int, update models.AnnotationUpdate) (_ *models.Annotation, err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdateAnnotation", attribute.Int("annotation.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	if update.Body == nil && update.Anchor == nil {
+		return nil, fmt.Errorf("%w: nothing to update", ErrInvalidAnnotation)
+	}
+	if update.Body != nil {
+		body, err := annotationBody(*update.Body)
+		if err != nil {
+			return nil, err
+		}
+		update.Body = &body
+	}
+
+	repo, actor, err := s.annotator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after *models.Annotation
+	err = repo.WithTxOptions(ctx, annotationTx, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.editable(ctx, repo, actor, id); err != nil {
+			return err
+		}
+
+		changed := *before
+		if update.Body != nil {
+			changed.Body = *update.Body
+		}
+		if update.Anchor != nil {
+			text, err := s.songText(ctx, repo, before.SongID)
+			if err != nil {
+				return err
+			}
+			if changed.Quote, err = anchorQuote(text, *update.Anchor); err != nil {
+				return err
+			}
+			changed.AnnotationAnchor, changed.Orphaned = *update.Anchor, false
+		}
+
+		if _, err := repo.UpdateAnnotation(ctx, &changed); err != nil {
+			log.FromContext(ctx, s.logger).WithField("annotationID", id).Error("Failed to update annotation: ", err)
+			return err
+		}
+		after = &changed
+
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntityAnnotation, id, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after.Quote = lyricsMask(ctx, s.words)(after.Quote)
+	return after, nil
+}
+
+func (s *ApiAnnotationService) DeleteAnnotation(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteAnnotation", attribute.Int("annotation.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.annotator(ctx)
+	if err != nil {
+		return err
+	}
+
+	var before *models.Annotation
+	return repo.WithTxOptions(ctx, annotationTx, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.editable(ctx, repo, actor, id); err != nil {
+			return err
+		}
+
+		if _, err := repo.DeleteAnnotation(ctx, id); err != nil {
+			log.FromContext(ctx, s.logger).WithField("annotationID", id).Error("Failed to delete annotation: ", err)
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionDelete, audit.EntityAnnotation, id, before, nil)
+	})
+}
+
+// VoteAnnotation sets the caller's vote on an annotation: 1 up, -1 down, 0
+// to withdraw it. It returns the annotation with the new total.
+func (s *ApiAnnotationService) VoteAnnotation(ctx context.Context, id, value int) (_ *models.Annotation, err error) {
+	ctx, span := tracing.Start(ctx, "service.VoteAnnotation", attribute.Int("annotation.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	if value < -1 || value > 1 {
+		return nil, fmt.Errorf("%w: vote must be -1, 0 or 1", ErrInvalidAnnotation)
+	}
+
+	repo, actor, err := s.annotator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.SetAnnotationVote(ctx, id, actor, value); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrAnnotationNotFound, id)
+		}
+		log.FromContext(ctx, s.logger).WithField("annotationID", id).Error("Failed to save annotation vote: ", err)
+		return nil, err
+	}
+
+	annotation, err := repo.GetAnnotation(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrAnnotationNotFound, id)
+		}
+		log.FromContext(ctx, s.logger).WithField("annotationID", id).Error("Failed to fetch annotation: ", err)
+		return nil, err
+	}
+	annotation.Quote = lyricsMask(ctx, s.words)(annotation.Quote)
+	return annotation, nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+func lines(verse, start, end int) models.AnnotationAnchor {
+	return models.AnnotationAnchor{Verse: verse, Unit: models.AnchorLines, Start: start, End: end}
+}
+
+func chars(verse, start, end int) models.AnnotationAnchor {
+	return models.AnnotationAnchor{Verse: verse, Unit: models.AnchorChars, Start: start, End: end}
+}
+
+func TestAnchorQuote(t *testing.T) {
+	const text = "one\ntwo\nthree\n\nночь, улица\nфонарь, аптека"
+
+	tests := []struct {
+		name   string
+		anchor models.AnnotationAnchor
+		// quote is empty when the anchor is rejected.
+		quote string
+	}{
+		{"lines", lines(0, 1, 3), "two\nthree"},
+		{"whole verse", lines(1, 0, 2), "ночь, улица\nфонарь, аптека"},
+		{"chars", chars(1, 6, 11), "улица"},
+		{"chars across lines", chars(0, 2, 6), "e\ntw"},
+		{"last char", chars(1, 25, 26), "а"},
+
+		{"negative verse", lines(-1, 0, 1), ""},
+		{"verse out of range", lines(2, 0, 1), ""},
+		{"negative start", lines(0, -1, 1), ""},
+		{"empty range", chars(0, 2, 2), ""},
+		{"reversed range", chars(0, 3, 2), ""},
+		{"lines out of range", lines(0, 2, 4), ""},
+		{"chars out of range", chars(1, 20, 27), ""},
+		{"whitespace only", chars(0, 3, 4), ""},
+		{"unknown unit", models.AnnotationAnchor{Verse: 0, Unit: "word", Start: 0, End: 1}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, err := anchorQuote(text, tt.anchor)
+			if tt.quote == "" {
+				if !errors.Is(err, ErrInvalidAnnotation) {
+					t.Fatalf("anchorQuote = %q, %v, want ErrInvalidAnnotation", quote, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("anchorQuote: %v", err)
+			}
+			if quote != tt.quote {
+				t.Errorf("quote = %q, want %q", quote, tt.quote)
+			}
+		})
+	}
+}
+
+func TestQuoteMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		verse  string
+		quote  string
+		unit   string
+		starts []int
+	}{
+		{"line", "a\nb\na\nb\na", "a", models.AnchorLines, []int{0, 2, 4}},
+		{"lines", "a\nb\na\nb\na", "a\nb", models.AnchorLines, []int{0, 2}},
+		{"overlapping lines", "a\na\na", "a\na", models.AnchorLines, []int{0, 1}},
+		{"whole lines only", "ab\nb", "b", models.AnchorLines, []int{1}},
+		{"no line", "a\nb", "c", models.AnchorLines, nil},
+		{"chars", "ночь, ночь", "ночь", models.AnchorChars, []int{0, 6}},
+		{"overlapping chars", "ааа", "аа", models.AnchorChars, []int{0, 1}},
+		{"chars across lines", "a\nb\na\nb", "b\na", models.AnchorChars, []int{2}},
+		{"no chars", "ночь", "день", models.AnchorChars, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if starts := quoteMatches(tt.verse, tt.quote, tt.unit); !reflect.DeepEqual(starts, tt.starts) {
+				t.Errorf("quoteMatches = %v, want %v", starts, tt.starts)
+			}
+		})
+	}
+}
+
+func TestRemapAnchor(t *testing.T) {
+	tests := []struct {
+		name   string
+		anchor models.AnnotationAnchor
+		quote  string
+		text   string
+		// want is the new anchor; ok is false when the quote is gone.
+		want models.AnnotationAnchor
+		ok   bool
+	}{
+		{"unchanged", lines(0, 1, 2), "two", "one\ntwo\nthree\n\nfour", lines(0, 1, 2), true},
+		{"line added before", lines(0, 1, 2), "two", "zero\none\ntwo\nthree\n\nfour", lines(0, 2, 3), true},
+		{"line removed before", lines(0, 1, 2), "two", "two\nthree\n\nfour", lines(0, 0, 1), true},
+		{"verse added before", lines(0, 1, 2), "two", "intro\n\none\ntwo\nthree\n\nfour", lines(1, 1, 2), true},
+		{"edit inside", lines(0, 1, 2), "two", "one\ntwo!\nthree\n\nfour", lines(0, 1, 2), false},
+		{"edit after", lines(0, 1, 2), "two", "one\ntwo\nthree!\n\nfour!", lines(0, 1, 2), true},
+		{"several lines", lines(0, 1, 3), "two\nthree", "zero\none\ntwo\nthree", lines(0, 2, 4), true},
+		{"moved to another verse", lines(0, 1, 2), "two", "one\nthree\n\nfour\ntwo", lines(1, 1, 2), true},
+
+		{"nearest start", lines(0, 2, 3), "two", "two\nA\nB\ntwo", lines(0, 3, 4), true},
+		{"tie goes to the first", lines(0, 2, 3), "two", "two\nA\nB\nC\ntwo", lines(0, 0, 1), true},
+		{"same verse first", lines(0, 0, 1), "two", "A\nB\nC\ntwo\n\ntwo", lines(0, 3, 4), true},
+		{"nearest verse", lines(2, 0, 1), "two", "two\n\nA\n\nB\n\nC\n\ntwo", lines(0, 0, 1), true},
+		{"nearest verse then start", lines(1, 0, 1), "two", "A\ntwo\n\nB\n\ntwo", lines(2, 0, 1), true},
+
+		{"chars unchanged", chars(0, 6, 11), "улица", "ночь, улица", chars(0, 6, 11), true},
+		{"chars edit before", chars(0, 6, 11), "улица", "ночь улица", chars(0, 5, 10), true},
+		{"chars edit inside", chars(0, 6, 11), "улица", "ночь, улицы", chars(0, 6, 11), false},
+		{"chars edit after", chars(0, 6, 11), "улица", "ночь, улица, фонарь", chars(0, 6, 11), true},
+		{"chars nearest", chars(0, 6, 11), "улица", "улица, ночь, улица", chars(0, 0, 5), true},
+		{"chars within a word", chars(0, 0, 3), "ноч", "полночь", chars(0, 3, 6), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotation := &models.Annotation{AnnotationAnchor: tt.anchor, Quote: tt.quote}
+			if ok := remapAnchor(tt.text, annotation); ok != tt.ok {
+				t.Fatalf("remapAnchor = %v, want %v", ok, tt.ok)
+			}
+			if annotation.AnnotationAnchor != tt.want {
+				t.Errorf("anchor = %+v, want %+v", annotation.AnnotationAnchor, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineAnnotations(t *testing.T) {
+	annotations := []models.Annotation{
+		{ID: 1, AnnotationAnchor: lines(0, 0, 1)},
+		{ID: 2, AnnotationAnchor: lines(1, 0, 1)},
+		{ID: 3, AnnotationAnchor: lines(2, 0, 1)},
+		{ID: 4, AnnotationAnchor: lines(2, 0, 1), Orphaned: true},
+		{ID: 5, AnnotationAnchor: lines(3, 0, 1)},
+		{ID: 6, AnnotationAnchor: lines(4, 0, 1)},
+	}
+
+	tests := []struct {
+		name   string
+		text   string
+		offset int
+		ids    []int
+	}{
+		{"whole song", "a\n\nb\n\nc\n\nd\n\ne", 0, []int{1, 2, 3, 5, 6}},
+		{"first verses", "a\n\nb", 0, []int{1, 2}},
+		{"page", "c\n\nd", 2, []int{3, 5}},
+		{"past the annotations", "f", 5, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			song := &models.Song{Text: tt.text}
+			inlineAnnotations(song, annotations, tt.offset)
+
+			if song.Annotations == nil {
+				t.Fatal("annotations are nil")
+			}
+			ids := []int{}
+			for _, annotation := range song.Annotations {
+				ids = append(ids, annotation.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("annotations = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tenant"
+	"github.com/sirupsen/logrus"
+)
+
+// Record appends an audit event for a mutation through repo, the transaction
+// of the mutation, so that neither is kept without the other: a failure is
+// returned to roll the mutation back. Actor, library and request details are
+// taken from ctx; before and after are stored as JSON snapshots.
+func (s *ApiAuditService) Record(ctx context.Context, repo repository.AuditWriter, action, entity string, entityID int, before, after interface{}) error {
+	logger := log.FromContext(ctx, s.logger)
+
+	source := audit.SourceFromContext(ctx)
+	event := &models.AuditEvent{
+		Actor:     auth.PrincipalFromContext(ctx).Actor(),
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		RequestID: source.RequestID,
+		SourceIP:  source.IP,
+		Before:    s.snapshot(ctx, before),
+		After:     s.snapshot(ctx, after),
+	}
+
+	if library := tenant.LibraryFromContext(ctx); library != nil {
+		event.Library = library.Slug
+	}
+
+	if err := repo.AddAuditEvent(ctx, event); err != nil {
+		logger.WithFields(logrus.Fields{
+			"action":   action,
+			"entity":   entity,
+			"entityID": entityID,
+			"actor":    event.Actor,
+		}).Error("Failed to record audit event: ", err)
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	return nil
+}
+
+func (s *ApiAuditService) GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]models.AuditEvent, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	events, err := s.repo.GetAuditEvents(ctx, filter, limit, offset)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"filter": filter,
+			"limit":  limit,
+			"offset": offset,
+		}).Error("Failed to fetch audit events: ", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// ExportAuditEvents writes every matching event to w as newline delimited JSON.
+func (s *ApiAuditService) ExportAuditEvents(ctx context.Context, filter models.AuditFilter, w io.Writer) error {
+	logger := log.FromContext(ctx, s.logger)
+
+	encoder := json.NewEncoder(w)
+
+	count := 0
+	err := s.repo.StreamAuditEvents(ctx, filter, func(event *models.AuditEvent) error {
+		count++
+		return encoder.Encode(event)
+	})
+	if err != nil {
+		logger.WithField("filter", filter).Error("Failed to export audit events: ", err)
+		return err
+	}
+
+	logger.Infof("Exported %d audit events", count)
+	return nil
+}
+
+func (s *ApiAuditService) snapshot(ctx context.Context, value interface{}) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.FromContext(ctx, s.logger).Error("Failed to encode audit snapshot: ", err)
+		return nil
+	}
+
+	return data
+}
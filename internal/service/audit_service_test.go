@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tenant"
+	"github.com/VadimBorzenkov/online-song-library/pkg/explicit"
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// testContext returns a request context of the api key 7 in the default
+// library of store.
+func testContext(t *testing.T, store *repository.MemoryRepository) context.Context {
+	t.Helper()
+
+	library, err := store.GetLibraryBySlug(context.Background(), tenant.DefaultLibrary)
+	if err != nil {
+		t.Fatalf("GetLibraryBySlug: %v", err)
+	}
+	ctx := tenant.WithLibrary(context.Background(), library)
+	ctx = auth.WithPrincipal(ctx, &auth.Principal{Method: auth.MethodApiKey, KeyID: 7, Scopes: []string{auth.ScopeAdmin}})
+	return audit.WithSource(ctx, audit.Source{RequestID: "req-1", IP: "203.0.113.7"})
+}
+
+func TestAuditRecord(t *testing.T) {
+	store := repository.NewMemoryRepository(testLogger())
+	s := NewApiAuditService(store, testLogger())
+	ctx := testContext(t, store)
+
+	before := map[string]string{"song": "Old"}
+	after := map[string]string{"song": "New"}
+	if err := s.Record(ctx, store, audit.ActionUpdate, audit.EntitySong, 12, before, after); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if err := s.Record(context.Background(), store, audit.ActionCreate, audit.EntityLibrary, 3, nil, after); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	events, err := s.GetAuditEvents(context.Background(), models.AuditFilter{Entity: audit.EntitySong}, 10, 0)
+	if err != nil {
+		t.Fatalf("GetAuditEvents: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("got %d song events, want 1", len(events))
+	}
+	event := events[0]
+	if event.Actor != "api_key:7" || event.Action != audit.ActionUpdate || event.Library != tenant.DefaultLibrary || event.EntityID != 12 ||
+		event.RequestID != "req-1" || event.SourceIP != "203.0.113.7" || event.OccurredAt.IsZero() {
+		t.Errorf("event = %+v", event)
+	}
+	if string(event.Before) != `{"song":"Old"}` || string(event.After) != `{"song":"New"}` {
+		t.Errorf("snapshots = %s, %s", event.Before, event.After)
+	}
+
+	events, err = s.GetAuditEvents(context.Background(), models.AuditFilter{Entity: audit.EntityLibrary}, 10, 0)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("GetAuditEvents = %v, %v", events, err)
+	}
+	if event := events[0]; event.Actor != "anonymous" || event.Library != "" || event.RequestID != "" || event.Before != nil {
+		t.Errorf("event without a request = %+v", event)
+	}
+}
+
+func TestAuditExport(t *testing.T) {
+	store := repository.NewMemoryRepository(testLogger())
+	s := NewApiAuditService(store, testLogger())
+	ctx := testContext(t, store)
+
+	for id := 1; id <= 3; id++ {
+		if err := s.Record(ctx, store, audit.ActionCreate, audit.EntitySong, id, nil, map[string]int{"id": id}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if err := s.Record(ctx, store, audit.ActionDelete, audit.EntitySong, 2, nil, nil); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := s.ExportAuditEvents(ctx, models.AuditFilter{Action: audit.ActionCreate}, &out); err != nil {
+		t.Fatalf("ExportAuditEvents: %v", err)
+	}
+
+	var ids []int
+	lines := bufio.NewScanner(&out)
+	for lines.Scan() {
+		var event models.AuditEvent
+		if err := json.Unmarshal(lines.Bytes(), &event); err != nil {
+			t.Fatalf("line %q: %v", lines.Text(), err)
+		}
+		if event.Action != audit.ActionCreate {
+			t.Errorf("exported a %s event", event.Action)
+		}
+		ids = append(ids, event.EntityID)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("exported songs %v, want %v", ids, want)
+	}
+}
+
+// failingAudit fails to record any event.
+type failingAudit struct {
+	AuditService
+}
+
+func (failingAudit) Record(ctx context.Context, repo repository.AuditWriter, action, entity string, entityID int, before, after interface{}) error {
+	return errors.New("audit log is full")
+}
+
+func TestAuditFailureRollsBackMutation(t *testing.T) {
+	store := repository.NewMemoryRepository(testLogger())
+	ctx := testContext(t, store)
+
+	recorded := NewApiService(store, testLogger(), &config.Config{}, NewApiAuditService(store, testLogger()), explicit.Default())
+	song := &models.Song{Group: "Kino", Song: "Gruppa krovi"}
+	if err := recorded.ImportSong(ctx, song); err != nil {
+		t.Fatalf("ImportSong: %v", err)
+	}
+
+	s := NewApiService(store, testLogger(), &config.Config{}, failingAudit{}, explicit.Default())
+	if err := s.ImportSong(ctx, &models.Song{Group: "Kino", Song: "Kukushka"}); err == nil {
+		t.Fatal("ImportSong succeeded without an audit event")
+	}
+	if err := s.DeleteSong(ctx, song.ID); err == nil {
+		t.Fatal("DeleteSong succeeded without an audit event")
+	}
+
+	songs, err := recorded.GetSongsWithPaginate(ctx, nil, "", 10, 0)
+	if err != nil {
+		t.Fatalf("GetSongsWithPaginate: %v", err)
+	}
+	if len(songs) != 1 || songs[0].ID != song.ID {
+		t.Errorf("songs = %+v, want only %q", songs, song.Song)
+	}
+}
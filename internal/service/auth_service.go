@@ -0,0 +1,274 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoSession is returned by Logout for callers that did not log in with a
+// password.
+var ErrNoSession = errors.New("request is not authenticated with a session")
+
+const maxUsername = 100
+
+// Authenticate resolves a bearer token to a principal. Tokens carrying the
+// api key or session prefix are looked up by hash, everything else is
+// treated as a JWT.
+func (s *ApiAuthService) Authenticate(ctx context.Context, token string) (*auth.Principal, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	if auth.IsSessionToken(token) {
+		return s.authenticateSession(ctx, token)
+	}
+
+	if !auth.IsApiKey(token) {
+		principal, err := s.jwt.Verify(token)
+		if err != nil {
+			logger.WithField("error", err).Debug("JWT verification failed")
+			return nil, err
+		}
+		return principal, nil
+	}
+
+	key, err := s.repo.GetApiKeyByHash(ctx, auth.HashApiKey(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, auth.ErrInvalidCredentials
+		}
+		logger.Error("Failed to look up api key: ", err)
+		return nil, err
+	}
+
+	if key.RevokedAt != nil {
+		logger.WithFields(logrus.Fields{
+			"keyID":  key.ID,
+			"prefix": key.Prefix,
+		}).Warn("Revoked api key used")
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	return &auth.Principal{
+		Subject:   key.Name,
+		Method:    auth.MethodApiKey,
+		KeyID:     key.ID,
+		Scopes:    key.Scopes,
+		Library:   key.Library,
+		CleanMode: key.CleanMode,
+	}, nil
+}
+
+// CreateApiKey mints a new key and returns its plaintext. The plaintext is
+// only available at this point; afterwards only the hash is kept.
+// An empty library leaves the key unbound, letting it select any library,
+// and an empty cleanMode means auth.CleanModeOff.
+func (s *ApiAuthService) CreateApiKey(ctx context.Context, name string, scopes []string, library, cleanMode string) (string, *models.ApiKey, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	if name == "" {
+		return "", nil, errors.New("api key name is required")
+	}
+
+	if len(scopes) == 0 {
+		return "", nil, errors.New("api key needs at least one scope")
+	}
+
+	if err := auth.ValidateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+
+	if cleanMode == "" {
+		cleanMode = auth.CleanModeOff
+	}
+	if err := auth.ValidateCleanMode(cleanMode); err != nil {
+		return "", nil, err
+	}
+
+	plain, prefix, hash, err := auth.GenerateApiKey()
+	if err != nil {
+		logger.Error("Failed to generate api key: ", err)
+		return "", nil, err
+	}
+
+	key := &models.ApiKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    scopes,
+		Library:   library,
+		CleanMode: cleanMode,
+	}
+
+	if err := s.repo.AddApiKey(ctx, key); err != nil {
+		logger.WithField("name", name).Error("Failed to store api key: ", err)
+		return "", nil, err
+	}
+
+	return plain, key, nil
+}
+
+func (s *ApiAuthService) RevokeApiKey(ctx context.Context, id int) error {
+	logger := log.FromContext(ctx, s.logger)
+
+	rowsAffected, err := s.repo.RevokeApiKey(ctx, id)
+	if err != nil {
+		logger.WithField("keyID", id).Error("Failed to revoke api key: ", err)
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("active api key with ID %d not found", id)
+	}
+
+	logger.Infof("Successfully revoked api key with ID %d", id)
+	return nil
+}
+
+func (s *ApiAuthService) authenticateSession(ctx context.Context, token string) (*auth.Principal, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	session, err := s.repo.GetSessionByHash(ctx, auth.HashApiKey(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, auth.ErrInvalidCredentials
+		}
+		logger.Error("Failed to look up session: ", err)
+		return nil, err
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		logger.WithFields(logrus.Fields{
+			"sessionID": session.ID,
+			"username":  session.Username,
+		}).Debug("Expired or revoked session used")
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	scopes, err := auth.ScopesForRole(session.Role)
+	if err != nil {
+		logger.WithField("username", session.Username).Error("User has an unknown role: ", err)
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	return &auth.Principal{
+		Subject:   session.Username,
+		Method:    auth.MethodUser,
+		SessionID: session.ID,
+		Scopes:    scopes,
+		Library:   session.Library,
+	}, nil
+}
+
+// CreateUser adds a user who logs in with password and gets the scopes of
+// role. An empty library leaves the user unbound, like an api key.
+func (s *ApiAuthService) CreateUser(ctx context.Context, username, password, role, library string) (*models.User, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	if utf8.RuneCountInString(username) > maxUsername {
+		return nil, fmt.Errorf("username must be at most %d characters long", maxUsername)
+	}
+
+	role = strings.ToLower(role)
+	if _, err := auth.ScopesForRole(role); err != nil {
+		return nil, err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repo.GetUserByName(ctx, username); err == nil {
+		return nil, fmt.Errorf("user %q already exists", username)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		logger.WithField("username", username).Error("Failed to look up user: ", err)
+		return nil, err
+	}
+
+	user := &models.User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		Library:      library,
+	}
+	if err := s.repo.AddUser(ctx, user); err != nil {
+		logger.WithField("username", username).Error("Failed to store user: ", err)
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Login checks the password and starts a session, returning its token. The
+// token is only available at this point; afterwards only the hash is kept.
+func (s *ApiAuthService) Login(ctx context.Context, username, password string) (string, *models.Session, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	var hash string
+	user, err := s.repo.GetUserByName(ctx, username)
+	switch {
+	case err == nil:
+		hash = user.PasswordHash
+	case !errors.Is(err, sql.ErrNoRows):
+		logger.WithField("username", username).Error("Failed to look up user: ", err)
+		return "", nil, err
+	}
+
+	// An unknown user is checked against a dummy hash, so both take as long.
+	if !auth.CheckPassword(hash, password) {
+		logger.WithField("username", username).Warn("Failed login")
+		return "", nil, auth.ErrInvalidCredentials
+	}
+
+	plain, tokenHash, err := auth.GenerateSessionToken()
+	if err != nil {
+		logger.Error("Failed to generate session token: ", err)
+		return "", nil, err
+	}
+
+	session := &models.Session{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		Hash:      tokenHash,
+		ExpiresAt: time.Now().Add(s.sessionTTL),
+	}
+	if err := s.repo.AddSession(ctx, session); err != nil {
+		logger.WithField("username", username).Error("Failed to store session: ", err)
+		return "", nil, err
+	}
+
+	logger.WithField("username", username).Info("User logged in")
+	return plain, session, nil
+}
+
+// Logout revokes the session the request was authenticated with.
+func (s *ApiAuthService) Logout(ctx context.Context) error {
+	logger := log.FromContext(ctx, s.logger)
+
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == nil || principal.SessionID == 0 {
+		return ErrNoSession
+	}
+
+	if _, err := s.repo.RevokeSession(ctx, principal.SessionID); err != nil {
+		logger.WithField("sessionID", principal.SessionID).Error("Failed to revoke session: ", err)
+		return err
+	}
+
+	logger.WithField("username", principal.Subject).Info("User logged out")
+	return nil
+}
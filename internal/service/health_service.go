@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/pkg/migrator"
+)
+
+const (
+	HealthStatusOK   = "ok"
+	HealthStatusFail = "fail"
+
+	healthCheckTimeout = 2 * time.Second
+)
+
+func (s *ApiHealthService) Liveness() *models.HealthReport {
+	return &models.HealthReport{Status: HealthStatusOK}
+}
+
+// Readiness runs every dependency check concurrently and reports ok only if
+// all of them pass.
+func (s *ApiHealthService) Readiness(ctx context.Context) *models.HealthReport {
+	logger := log.FromContext(ctx, s.logger)
+
+	checks := map[string]func(ctx context.Context) error{}
+	if s.db != nil {
+		checks["database"] = s.checkDatabase
+	}
+	if s.checkSchema {
+		checks["migrations"] = s.checkMigrations
+	}
+	if s.replica != nil {
+		checks["database_replica"] = s.replica.PingContext
+	}
+	if s.checkExternal {
+		checks["external_api"] = s.exApi.Ping
+	}
+
+	report := &models.HealthReport{
+		Status: HealthStatusOK,
+		Checks: make(map[string]models.HealthCheck, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+
+			result := s.run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != HealthStatusOK {
+				report.Status = HealthStatusFail
+				logger.WithField("check", name).Warn("Readiness check failed: ", result.Error)
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func (s *ApiHealthService) run(ctx context.Context, check func(ctx context.Context) error) models.HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := models.HealthCheck{
+		Status:    HealthStatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = HealthStatusFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func (s *ApiHealthService) checkDatabase(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *ApiHealthService) checkMigrations(ctx context.Context) error {
+	expected, err := migrator.LatestVersion()
+	if err != nil {
+		return fmt.Errorf("read migrations: %w", err)
+	}
+
+	version, dirty, err := migrator.Version(ctx, s.db)
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", version)
+	}
+
+	if version != expected {
+		return fmt.Errorf("schema version %d, expected %d", version, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/db"
+	"github.com/VadimBorzenkov/online-song-library/pkg/migrator"
+)
+
+// testDB returns an in-memory database whose golang-migrate version table
+// records version, or has no row if version is 0.
+func testDB(t *testing.T, version uint, dirty bool) *sql.DB {
+	t.Helper()
+
+	conn, err := db.OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if _, err := conn.Exec("CREATE TABLE schema_migrations (version BIGINT NOT NULL, dirty BOOLEAN NOT NULL)"); err != nil {
+		t.Fatalf("create version table: %v", err)
+	}
+	if version > 0 {
+		if _, err := conn.Exec("INSERT INTO schema_migrations VALUES (?, ?)", version, dirty); err != nil {
+			t.Fatalf("record version: %v", err)
+		}
+	}
+	return conn
+}
+
+func closedDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	conn := testDB(t, 0, false)
+	conn.Close()
+	return conn
+}
+
+func TestReadiness(t *testing.T) {
+	latest, err := migrator.LatestVersion()
+	if err != nil {
+		t.Fatalf("LatestVersion: %v", err)
+	}
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer provider.Close()
+
+	tests := []struct {
+		name     string
+		storage  string
+		db       func(t *testing.T) *sql.DB
+		replica  func(t *testing.T) *sql.DB
+		external string
+		// checks maps each check run to whether it passes.
+		checks map[string]bool
+	}{
+		{"memory", config.StorageMemory, nil, nil, "", map[string]bool{}},
+		{"sqlite", config.StorageSQLite, func(t *testing.T) *sql.DB { return testDB(t, 0, false) }, nil, "", map[string]bool{"database": true}},
+		{"postgres migrated", config.StoragePostgres, func(t *testing.T) *sql.DB { return testDB(t, latest, false) }, nil, "", map[string]bool{"database": true, "migrations": true}},
+		{"postgres behind", config.StoragePostgres, func(t *testing.T) *sql.DB { return testDB(t, latest-1, false) }, nil, "", map[string]bool{"database": true, "migrations": false}},
+		{"postgres dirty", config.StoragePostgres, func(t *testing.T) *sql.DB { return testDB(t, latest, true) }, nil, "", map[string]bool{"database": true, "migrations": false}},
+		{"postgres never migrated", config.StoragePostgres, func(t *testing.T) *sql.DB { return testDB(t, 0, false) }, nil, "", map[string]bool{"database": true, "migrations": false}},
+		{"database down", config.StoragePostgres, closedDB, nil, "", map[string]bool{"database": false, "migrations": false}},
+		{"replica", config.StorageSQLite, func(t *testing.T) *sql.DB { return testDB(t, 0, false) }, func(t *testing.T) *sql.DB { return testDB(t, 0, false) }, "", map[string]bool{"database": true, "database_replica": true}},
+		{"replica down", config.StorageSQLite, func(t *testing.T) *sql.DB { return testDB(t, 0, false) }, closedDB, "", map[string]bool{"database": true, "database_replica": false}},
+		{"provider", config.StorageMemory, nil, nil, provider.URL + "/up", map[string]bool{"external_api": true}},
+		{"provider failing", config.StorageMemory, nil, nil, provider.URL + "/down", map[string]bool{"external_api": false}},
+		{"provider unreachable", config.StorageMemory, nil, nil, "http://127.0.0.1:1", map[string]bool{"external_api": false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var database, replica *sql.DB
+			if tt.db != nil {
+				database = tt.db(t)
+			}
+			if tt.replica != nil {
+				replica = tt.replica(t)
+			}
+			cfg := &config.Config{
+				Storage:                tt.storage,
+				ExternalApiURL:         tt.external,
+				ExternalApiTimeout:     time.Second,
+				HealthCheckExternalApi: tt.external != "",
+			}
+
+			report := NewApiHealthService(database, replica, testLogger(), cfg).Readiness(context.Background())
+
+			checks := map[string]bool{}
+			want := HealthStatusOK
+			for name, check := range report.Checks {
+				checks[name] = check.Status == HealthStatusOK
+				if (check.Status == HealthStatusOK) != (check.Error == "") {
+					t.Errorf("check %s = %+v", name, check)
+				}
+			}
+			for _, ok := range tt.checks {
+				if !ok {
+					want = HealthStatusFail
+				}
+			}
+			if !reflect.DeepEqual(checks, tt.checks) {
+				t.Errorf("checks = %v, want %v", checks, tt.checks)
+			}
+			if report.Status != want {
+				t.Errorf("status = %s, want %s", report.Status, want)
+			}
+		})
+	}
+
+	t.Run("migrations error", func(t *testing.T) {
+		cfg := &config.Config{Storage: config.StoragePostgres}
+		report := NewApiHealthService(testDB(t, latest-1, false), nil, testLogger(), cfg).Readiness(context.Background())
+		if got := report.Checks["migrations"].Error; !strings.Contains(got, "expected") {
+			t.Errorf("migrations error = %q", got)
+		}
+	})
+}
+
+func TestLiveness(t *testing.T) {
+	s := NewApiHealthService(closedDB(t), nil, testLogger(), &config.Config{Storage: config.StoragePostgres})
+	if report := s.Liveness(); report.Status != HealthStatusOK || len(report.Checks) != 0 {
+		t.Errorf("Liveness = %+v, want ok without checks", report)
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tenant"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrLibraryNotFound = errors.New("library not found")
+	// ErrLibraryForbidden is returned to credentials bound to a library for
+	// anything that reaches beyond it.
+	ErrLibraryForbidden = errors.New("credentials are bound to another library")
+)
+
+// boundLibrary returns the slug of the library the principal is bound to,
+// empty if it may use any library.
+func boundLibrary(ctx context.Context) string {
+	if principal := auth.PrincipalFromContext(ctx); principal != nil {
+		return principal.Library
+	}
+	return ""
+}
+
+func (s *ApiLibraryService) GetLibrary(ctx context.Context, slug string) (*models.Library, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	library, err := s.repo.GetLibraryBySlug(ctx, slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrLibraryNotFound, slug)
+		}
+		logger.WithField("library", slug).Error("Failed to fetch library: ", err)
+		return nil, err
+	}
+
+	return library, nil
+}
+
+// GetLibraries lists the libraries, only the bound one for credentials bound
+// to a library.
+func (s *ApiLibraryService) GetLibraries(ctx context.Context) ([]models.Library, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	libraries, err := s.repo.GetLibraries(ctx)
+	if err != nil {
+		logger.Error("Failed to fetch libraries: ", err)
+		return nil, err
+	}
+
+	if bound := boundLibrary(ctx); bound != "" {
+		visible := libraries[:0]
+		for _, library := range libraries {
+			if library.Slug == bound {
+				visible = append(visible, library)
+			}
+		}
+		libraries = visible
+	}
+
+	return libraries, nil
+}
+
+// CreateLibrary creates a library. Credentials bound to a library may not.
+func (s *ApiLibraryService) CreateLibrary(ctx context.Context, slug, name string) (*models.Library, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	if bound := boundLibrary(ctx); bound != "" {
+		logger.WithFields(logrus.Fields{
+			"library": bound,
+			"slug":    slug,
+		}).Warn("Library creation attempted with credentials bound to a library")
+		return nil, ErrLibraryForbidden
+	}
+
+	if !tenant.ValidSlug(slug) {
+		return nil, fmt.Errorf("invalid library slug %q", slug)
+	}
+
+	if name == "" {
+		name = slug
+	}
+
+	library := &models.Library{
+		Slug: slug,
+		Name: name,
+	}
+
+	err := s.repo.WithLibraryTx(ctx, func(repo repository.LibraryRepository) error {
+		if err := repo.AddLibrary(ctx, library); err != nil {
+			logger.WithField("library", slug).Error("Failed to create library: ", err)
+			return err
+		}
+
+		return s.audit.Record(tenant.WithLibrary(ctx, library), repo, audit.ActionCreate, audit.EntityLibrary, library.ID, nil, library)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return library, nil
+}
+
+// CopySongs copies songs between libraries. An empty songIDs copies every
+// song of the source library. The copies are created by the caller, not by
+// the authors of the originals. Credentials bound to a library may only name
+// that library on both sides.
+func (s *ApiLibraryService) CopySongs(ctx context.Context, from, to string, songIDs []int) (int64, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	if bound := boundLibrary(ctx); bound != "" && (from != bound || to != bound) {
+		logger.WithFields(logrus.Fields{
+			"library": bound,
+			"from":    from,
+			"to":      to,
+		}).Warn("Song copy attempted outside of the bound library")
+		return 0, fmt.Errorf("%w: %s", ErrLibraryForbidden, bound)
+	}
+
+	source, err := s.GetLibrary(ctx, from)
+	if err != nil {
+		return 0, err
+	}
+
+	target, err := s.GetLibrary(ctx, to)
+	if err != nil {
+		return 0, err
+	}
+
+	if source.ID == target.ID {
+		return 0, errors.New("source and target library must differ")
+	}
+
+	var copied int64
+	err = s.repo.WithLibraryTx(ctx, func(repo repository.LibraryRepository) error {
+		var err error
+		if copied, err = repo.CopySongs(ctx, source.ID, target.ID, songIDs, auth.PrincipalFromContext(ctx).Actor()); err != nil {
+			logger.WithFields(logrus.Fields{
+				"from": from,
+				"to":   to,
+			}).Error("Failed to copy songs: ", err)
+			return err
+		}
+
+		return s.audit.Record(tenant.WithLibrary(ctx, target), repo, audit.ActionImport, audit.EntityLibrary, target.ID, nil, map[string]interface{}{
+			"from":     from,
+			"song_ids": songIDs,
+			"copied":   copied,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"from":   from,
+		"to":     to,
+		"copied": copied,
+		"actor":  auth.PrincipalFromContext(ctx).Actor(),
+	}).Info("Successfully copied songs between libraries")
+	return copied, nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrSongNotFound = errors.New("song not found")
+	// ErrAuthenticationRequired is returned to anonymous callers, who have
+	// no favorites or history of their own.
+	ErrAuthenticationRequired = errors.New("authentication required")
+)
+
+// listener returns the library scoped repository and the actor whose
+// favorites and plays the request works on.
+func (s *ApiListeningService) listener(ctx context.Context) (repository.Repository, string, error) {
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil, "", ErrAuthenticationRequired
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Listening")
+	if err != nil {
+		return nil, "", err
+	}
+
+	return repo, principal.Actor(), nil
+}
+
+func (s *ApiListeningService) AddFavorite(ctx context.Context, songID int) (err error) {
+	ctx, span := tracing.Start(ctx, "service.AddFavorite", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.listener(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := repo.AddFavorite(ctx, actor, songID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+		}
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to add favorite: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveFavorite unmarks a favorite. Removing a song that is not a favorite
+// is not an error.
+func (s *ApiListeningService) RemoveFavorite(ctx context.Context, songID int) (err error) {
+	ctx, span := tracing.Start(ctx, "service.RemoveFavorite", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.listener(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := repo.DeleteFavorite(ctx, actor, songID); err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to remove favorite: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *ApiListeningService) GetFavorites(ctx context.Context, limit, offset int) (_ []models.Song, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetFavorites")
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.listener(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	songs, err := repo.GetFavorites(ctx, actor, limit, offset)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+			"limit":  limit,
+			"offset": offset,
+		}).Error("Failed to fetch favorites: ", err)
+		return nil, err
+	}
+
+	mask := lyricsMask(ctx, s.words)
+	for i := range songs {
+		songs[i].Text = mask(songs[i].Text)
+	}
+	return songs, nil
+}
+
+// RecordPlay records that the caller played the song and bumps its play
+// count.
+func (s *ApiListeningService) RecordPlay(ctx context.Context, songID int) (_ *models.Play, err error) {
+	ctx, span := tracing.Start(ctx, "service.RecordPlay", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.listener(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	play := &models.Play{SongID: songID}
+	if err := repo.AddPlay(ctx, play, actor); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+		}
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to record play: ", err)
+		return nil, err
+	}
+
+	return play, nil
+}
+
+func (s *ApiListeningService) GetHistory(ctx context.Context, limit, offset int) (_ []models.Play, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetHistory")
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.listener(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plays, err := repo.GetPlays(ctx, actor, limit, offset)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+			"limit":  limit,
+			"offset": offset,
+		}).Error("Failed to fetch listening history: ", err)
+		return nil, err
+	}
+
+	return plays, nil
+}
+
+// GetTop reports the caller's most played songs and groups over the last
+// window, or of all time if window is zero.
+func (s *ApiListeningService) GetTop(ctx context.Context, window time.Duration, limit int) (_ *models.TopReport, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetTop")
+	defer func() { tracing.End(span, err) }()
+
+	logger := log.FromContext(ctx, s.logger)
+
+	repo, actor, err := s.listener(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.TopReport{Songs: []models.TopSong{}, Groups: []models.TopGroup{}}
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+		report.Since = &since
+	}
+
+	songs, err := repo.GetTopSongs(ctx, actor, since, limit)
+	if err != nil {
+		logger.WithField("window", window).Error("Failed to fetch top songs: ", err)
+		return nil, err
+	}
+	groups, err := repo.GetTopGroups(ctx, actor, since, limit)
+	if err != nil {
+		logger.WithField("window", window).Error("Failed to fetch top groups: ", err)
+		return nil, err
+	}
+
+	report.Songs = append(report.Songs, songs...)
+	report.Groups = append(report.Groups, groups...)
+	return report, nil
+}
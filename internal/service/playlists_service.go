@@ -0,0 +1,546 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	// ErrPlaylistNotFound is also returned for private playlists of other
+	// users, so that their existence is not revealed.
+	ErrPlaylistNotFound      = errors.New("playlist not found")
+	ErrPlaylistForbidden     = errors.New("playlist belongs to another user")
+	ErrPlaylistEntryNotFound = errors.New("playlist entry not found")
+	ErrInvalidPlaylist       = errors.New("invalid playlist")
+	ErrUnknownExportFormat   = errors.New("unknown export format")
+)
+
+// Playlist export formats.
+const (
+	ExportM3U  = "m3u"
+	ExportXSPF = "xspf"
+)
+
+const (
+	maxPlaylistName        = 255
+	maxPlaylistDescription = 2000
+)
+
+// viewer returns the owner name playlists are filtered by, empty for admins
+// who see every playlist.
+func viewer(ctx context.Context) string {
+	principal := auth.PrincipalFromContext(ctx)
+	if principal.HasScope(auth.ScopeAdmin) {
+		return ""
+	}
+	return principal.Actor()
+}
+
+// load fetches a playlist the caller may see and, if write is set, change.
+func (s *ApiPlaylistService) load(ctx context.Context, repo repository.Repository, id int, write bool) (*models.Playlist, error) {
+	playlist, err := repo.GetPlaylist(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrPlaylistNotFound, id)
+		}
+		log.FromContext(ctx, s.logger).WithField("playlistID", id).Error("Failed to fetch playlist: ", err)
+		return nil, err
+	}
+
+	viewer := viewer(ctx)
+	switch {
+	case viewer == "" || playlist.Owner == viewer:
+	case playlist.Visibility != models.PlaylistPublic:
+		return nil, fmt.Errorf("%w: %d", ErrPlaylistNotFound, id)
+	case write:
+		return nil, fmt.Errorf("%w: %d", ErrPlaylistForbidden, id)
+	}
+
+	return playlist, nil
+}
+
+// loadWithEntries is load that also fills in the entries.
+func (s *ApiPlaylistService) loadWithEntries(ctx context.Context, repo repository.Repository, id int, write bool) (*models.Playlist, error) {
+	playlist, err := s.load(ctx, repo, id, write)
+	if err != nil {
+		return nil, err
+	}
+
+	if playlist.Entries, err = repo.GetPlaylistEntries(ctx, id); err != nil {
+		log.FromContext(ctx, s.logger).WithField("playlistID", id).Error("Failed to fetch playlist entries: ", err)
+		return nil, err
+	}
+	return playlist, nil
+}
+
+func validatePlaylist(playlist *models.Playlist) error {
+	playlist.Name = strings.TrimSpace(playlist.Name)
+	switch {
+	case playlist.Name == "":
+		return fmt.Errorf("%w: name is required", ErrInvalidPlaylist)
+	case utf8.RuneCountInString(playlist.Name) > maxPlaylistName:
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidPlaylist, maxPlaylistName)
+	case utf8.RuneCountInString(playlist.Description) > maxPlaylistDescription:
+		return fmt.Errorf("%w: description is longer than %d characters", ErrInvalidPlaylist, maxPlaylistDescription)
+	case playlist.Visibility != models.PlaylistPrivate && playlist.Visibility != models.PlaylistPublic:
+		return fmt.Errorf("%w: visibility must be %s or %s", ErrInvalidPlaylist, models.PlaylistPrivate, models.PlaylistPublic)
+	}
+	return nil
+}
+
+func (s *ApiPlaylistService) GetPlaylists(ctx context.Context, limit, offset int) (_ []models.Playlist, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetPlaylists")
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	playlists, err := repo.GetPlaylists(ctx, viewer(ctx), limit, offset)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+			"limit":  limit,
+			"offset": offset,
+		}).Error("Failed to fetch playlists: ", err)
+		return nil, err
+	}
+
+	return playlists, nil
+}
+
+func (s *ApiPlaylistService) GetPlaylist(ctx context.Context, id int) (_ *models.Playlist, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetPlaylist", attribute.Int("playlist.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.loadWithEntries(ctx, repo, id, false)
+}
+
+// CreatePlaylist stores a new playlist owned by the caller. Visibility
+// defaults to private.
+func (s *ApiPlaylistService) CreatePlaylist(ctx context.Context, playlist *models.Playlist) (err error) {
+	ctx, span := tracing.Start(ctx, "service.CreatePlaylist")
+	defer func() { tracing.End(span, err) }()
+
+	logger := log.FromContext(ctx, s.logger)
+
+	if playlist.Visibility == "" {
+		playlist.Visibility = models.PlaylistPrivate
+	}
+	if err := validatePlaylist(playlist); err != nil {
+		return err
+	}
+	playlist.Owner = auth.PrincipalFromContext(ctx).Actor()
+	playlist.Entries = nil
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return err
+	}
+
+	err = repo.WithTx(ctx, func(repo repository.Repository) error {
+		if err := repo.AddPlaylist(ctx, playlist); err != nil {
+			logger.WithField("name", playlist.Name).Error("Failed to create playlist: ", err)
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionCreate, audit.EntityPlaylist, playlist.ID, nil, playlist)
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"playlistID": playlist.ID,
+		"owner":      playlist.Owner,
+	}).Info("Playlist created")
+	return nil
+}
+
+func (s *ApiPlaylistService) UpdatePlaylist(ctx context.Context, id int, update models.PlaylistUpdate) (_ *models.Playlist, err error) {
+	ctx, span := tracing.Start(ctx, "service.UpdatePlaylist", attribute.Int("playlist.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	if update.Name == nil && update.Description == nil && update.Visibility == nil {
+		return nil, fmt.Errorf("%w: no fields to update", ErrInvalidPlaylist)
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after *models.Playlist
+	err = repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.load(ctx, repo, id, true); err != nil {
+			return err
+		}
+
+		changed := *before
+		if update.Name != nil {
+			changed.Name = *update.Name
+		}
+		if update.Description != nil {
+			changed.Description = *update.Description
+		}
+		if update.Visibility != nil {
+			changed.Visibility = *update.Visibility
+		}
+		if err := validatePlaylist(&changed); err != nil {
+			return err
+		}
+
+		if _, err := repo.UpdatePlaylist(ctx, &changed); err != nil {
+			log.FromContext(ctx, s.logger).WithField("playlistID", id).Error("Failed to update playlist: ", err)
+			return err
+		}
+
+		if after, err = s.loadWithEntries(ctx, repo, id, false); err != nil {
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntityPlaylist, id, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return after, nil
+}
+
+func (s *ApiPlaylistService) DeletePlaylist(ctx context.Context, id int) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeletePlaylist", attribute.Int("playlist.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return err
+	}
+
+	var before *models.Playlist
+	return repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.loadWithEntries(ctx, repo, id, true); err != nil {
+			return err
+		}
+
+		if _, err := repo.DeletePlaylist(ctx, id); err != nil {
+			log.FromContext(ctx, s.logger).WithField("playlistID", id).Error("Failed to delete playlist: ", err)
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionDelete, audit.EntityPlaylist, id, before, nil)
+	})
+}
+
+// DuplicatePlaylist copies a playlist the caller can see, entries included,
+// into a new private playlist owned by the caller. An empty name means the
+// original name with " (copy)" appended.
+func (s *ApiPlaylistService) DuplicatePlaylist(ctx context.Context, id int, name string) (_ *models.Playlist, err error) {
+	ctx, span := tracing.Start(ctx, "service.DuplicatePlaylist", attribute.Int("playlist.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	actor := auth.PrincipalFromContext(ctx).Actor()
+
+	var duplicate *models.Playlist
+	err = repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		source, err := s.loadWithEntries(ctx, repo, id, false)
+		if err != nil {
+			return err
+		}
+
+		duplicate = &models.Playlist{
+			Name:        name,
+			Owner:       actor,
+			Description: source.Description,
+			Visibility:  models.PlaylistPrivate,
+		}
+		if duplicate.Name == "" {
+			duplicate.Name = source.Name + " (copy)"
+		}
+		if err := validatePlaylist(duplicate); err != nil {
+			return err
+		}
+
+		if err := repo.AddPlaylist(ctx, duplicate); err != nil {
+			return err
+		}
+		for _, entry := range source.Entries {
+			copied := models.PlaylistEntry{SongID: entry.SongID, AddedBy: actor}
+			if err := repo.AddPlaylistEntry(ctx, &copied, duplicate.ID); err != nil {
+				return err
+			}
+		}
+
+		if duplicate, err = s.loadWithEntries(ctx, repo, duplicate.ID, false); err != nil {
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionCreate, audit.EntityPlaylist, duplicate.ID, nil, duplicate)
+	})
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("playlistID", id).Error("Failed to duplicate playlist: ", err)
+		return nil, err
+	}
+
+	return duplicate, nil
+}
+
+// AddEntry inserts a song at position, shifting later entries down. Zero
+// appends it.
+func (s *ApiPlaylistService) AddEntry(ctx context.Context, playlistID, songID, position int) (*models.Playlist, error) {
+	return s.changeEntries(ctx, "service.AddPlaylistEntry", playlistID, func(repo repository.Repository, entries []models.PlaylistEntry) ([]models.PlaylistEntry, error) {
+		at := position
+		if at == 0 {
+			at = len(entries) + 1
+		}
+		if at < 1 || at > len(entries)+1 {
+			return nil, fmt.Errorf("%w: position must be between 1 and %d", ErrInvalidPlaylist, len(entries)+1)
+		}
+
+		entry := models.PlaylistEntry{SongID: songID, AddedBy: auth.PrincipalFromContext(ctx).Actor()}
+		if err := repo.AddPlaylistEntry(ctx, &entry, playlistID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("%w: song with ID %d not found", ErrInvalidPlaylist, songID)
+			}
+			return nil, err
+		}
+
+		entries = append(entries, models.PlaylistEntry{})
+		copy(entries[at:], entries[at-1:])
+		entries[at-1] = entry
+		return entries, nil
+	})
+}
+
+func (s *ApiPlaylistService) RemoveEntry(ctx context.Context, playlistID, entryID int) (*models.Playlist, error) {
+	return s.changeEntries(ctx, "service.RemovePlaylistEntry", playlistID, func(repo repository.Repository, entries []models.PlaylistEntry) ([]models.PlaylistEntry, error) {
+		i := entryIndex(entries, entryID)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %d", ErrPlaylistEntryNotFound, entryID)
+		}
+
+		if _, err := repo.DeletePlaylistEntry(ctx, playlistID, entryID); err != nil {
+			return nil, err
+		}
+		return append(entries[:i], entries[i+1:]...), nil
+	})
+}
+
+// MoveEntry moves an entry to position, counted from 1 among the current
+// entries.
+func (s *ApiPlaylistService) MoveEntry(ctx context.Context, playlistID, entryID, position int) (*models.Playlist, error) {
+	return s.changeEntries(ctx, "service.MovePlaylistEntry", playlistID, func(repo repository.Repository, entries []models.PlaylistEntry) ([]models.PlaylistEntry, error) {
+		i := entryIndex(entries, entryID)
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %d", ErrPlaylistEntryNotFound, entryID)
+		}
+		if position < 1 || position > len(entries) {
+			return nil, fmt.Errorf("%w: position must be between 1 and %d", ErrInvalidPlaylist, len(entries))
+		}
+
+		entry := entries[i]
+		entries = append(entries[:i], entries[i+1:]...)
+		entries = append(entries, models.PlaylistEntry{})
+		copy(entries[position:], entries[position-1:])
+		entries[position-1] = entry
+		return entries, nil
+	})
+}
+
+// placeEntries fits the new order of the visible entries into the places
+// they held among all entries, so that the entries of songs in the trash or
+// hidden from a clean principal stay where they are. Added entries that do
+// not fit go at the end.
+func placeEntries(all []int, visible, reordered []models.PlaylistEntry) []int {
+	wasVisible := make(map[int]bool, len(visible))
+	for _, e := range visible {
+		wasVisible[e.ID] = true
+	}
+
+	ids := make([]int, 0, len(all)+1)
+	next := 0
+	for _, id := range all {
+		if !wasVisible[id] {
+			ids = append(ids, id)
+			continue
+		}
+		if next < len(reordered) {
+			ids = append(ids, reordered[next].ID)
+			next++
+		}
+	}
+	for _, e := range reordered[next:] {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func entryIndex(entries []models.PlaylistEntry, id int) int {
+	for i, e := range entries {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// changeEntries runs fn on the entries of a playlist the caller may change
+// and stores the order fn returns by rewriting every position, all in one
+// transaction. Entries the caller does not see keep their places. Concurrent
+// changes to the same playlist make it retry.
+func (s *ApiPlaylistService) changeEntries(ctx context.Context, name string, playlistID int, fn func(repo repository.Repository, entries []models.PlaylistEntry) ([]models.PlaylistEntry, error)) (_ *models.Playlist, err error) {
+	ctx, span := tracing.Start(ctx, name, attribute.Int("playlist.id", playlistID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after *models.Playlist
+	err = repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.loadWithEntries(ctx, repo, playlistID, true); err != nil {
+			return err
+		}
+		all, err := repo.GetPlaylistEntryIDs(ctx, playlistID)
+		if err != nil {
+			return err
+		}
+
+		entries, err := fn(repo, append([]models.PlaylistEntry(nil), before.Entries...))
+		if err != nil {
+			return err
+		}
+
+		if err := repo.SetPlaylistPositions(ctx, playlistID, placeEntries(all, before.Entries, entries)); err != nil {
+			return err
+		}
+
+		if after, err = s.loadWithEntries(ctx, repo, playlistID, false); err != nil {
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntityPlaylist, playlistID, before, after)
+	})
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("playlistID", playlistID).Error("Failed to change playlist entries: ", err)
+		return nil, err
+	}
+
+	return after, nil
+}
+
+// ExportPlaylist writes a playlist the caller can see as M3U or XSPF. Songs
+// without a link cannot be played and are left out.
+func (s *ApiPlaylistService) ExportPlaylist(ctx context.Context, id int, format string, w io.Writer) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ExportPlaylist",
+		attribute.Int("playlist.id", id),
+		attribute.String("export.format", format),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if format != ExportM3U && format != ExportXSPF {
+		return fmt.Errorf("%w: %q", ErrUnknownExportFormat, format)
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Playlist")
+	if err != nil {
+		return err
+	}
+
+	playlist, err := s.loadWithEntries(ctx, repo, id, false)
+	if err != nil {
+		return err
+	}
+
+	if format == ExportXSPF {
+		return writeXSPF(w, playlist)
+	}
+	return writeM3U(w, playlist)
+}
+
+// m3uLine keeps a value on a single line.
+var m3uLine = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+func writeM3U(w io.Writer, playlist *models.Playlist) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#EXTM3U\n#PLAYLIST:%s\n", m3uLine.Replace(playlist.Name))
+	for _, entry := range playlist.Entries {
+		if entry.Link == "" {
+			continue
+		}
+		fmt.Fprintf(bw, "#EXTINF:-1,%s - %s\n%s\n", m3uLine.Replace(entry.Group), m3uLine.Replace(entry.Song), m3uLine.Replace(entry.Link))
+	}
+	return bw.Flush()
+}
+
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version    string      `xml:"version,attr"`
+	Title      string      `xml:"title"`
+	Creator    string      `xml:"creator"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator"`
+}
+
+func writeXSPF(w io.Writer, playlist *models.Playlist) error {
+	doc := xspfPlaylist{
+		Version:    "1",
+		Title:      playlist.Name,
+		Creator:    playlist.Owner,
+		Annotation: playlist.Description,
+		Tracks:     []xspfTrack{},
+	}
+	for _, entry := range playlist.Entries {
+		if entry.Link == "" {
+			continue
+		}
+		doc.Tracks = append(doc.Tracks, xspfTrack{Location: entry.Link, Title: entry.Song, Creator: entry.Group})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrReviewNotFound = errors.New("review not found")
+	ErrInvalidReview  = errors.New("invalid review")
+	// ErrReviewForbidden is returned when someone other than a moderator
+	// asks for reviews that are not approved.
+	ErrReviewForbidden = errors.New("only moderators see reviews that are not approved")
+)
+
+const (
+	minRating     = 1
+	maxRating     = 5
+	maxReviewBody = 2000
+)
+
+// reviewTx is the isolation of review writes. Every write refreshes the
+// song's rating, so concurrent reviews of a song conflict on the song row
+// and are retried instead of computing the rating from stale reviews.
+var reviewTx = repository.TxOptions{Isolation: sql.LevelRepeatableRead}
+
+func validReviewStatus(status string) bool {
+	switch status {
+	case models.ReviewPending, models.ReviewApproved, models.ReviewRejected:
+		return true
+	}
+	return false
+}
+
+// reviewer returns the library scoped repository and the actor whose review
+// the request works on.
+func (s *ApiReviewService) reviewer(ctx context.Context) (repository.Repository, string, error) {
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil, "", ErrAuthenticationRequired
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Review")
+	if err != nil {
+		return nil, "", err
+	}
+	return repo, principal.Actor(), nil
+}
+
+// ownReview returns the review of actor for the song, or nil if there is
+// none.
+func (s *ApiReviewService) ownReview(ctx context.Context, repo repository.Repository, actor string, songID int) (*models.Review, error) {
+	reviews, err := repo.GetReviews(ctx, models.ReviewFilter{SongID: songID, Actor: actor}, 1, 0)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch review: ", err)
+		return nil, err
+	}
+	if len(reviews) == 0 {
+		return nil, nil
+	}
+	return &reviews[0], nil
+}
+
+// refreshRating updates the rating of the song after a change to its
+// reviews.
+func (s *ApiReviewService) refreshRating(ctx context.Context, repo repository.Repository, songID int) error {
+	if err := repo.RefreshSongRating(ctx, songID); err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to refresh song rating: ", err)
+		return err
+	}
+	return nil
+}
+
+// SaveReview adds or replaces the caller's review of the song. A review with
+// a body waits for moderation; a bare rating is approved at once.
+func (s *ApiReviewService) SaveReview(ctx context.Context, songID, rating int, body string) (_ *models.Review, err error) {
+	ctx, span := tracing.Start(ctx, "service.SaveReview", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	body = strings.TrimSpace(body)
+	switch {
+	case rating < minRating || rating > maxRating:
+		return nil, fmt.Errorf("%w: rating must be from %d to %d", ErrInvalidReview, minRating, maxRating)
+	case utf8.RuneCountInString(body) > maxReviewBody:
+		return nil, fmt.Errorf("%w: body is longer than %d characters", ErrInvalidReview, maxReviewBody)
+	}
+
+	repo, actor, err := s.reviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	review := &models.Review{SongID: songID, Actor: actor, Rating: rating, Body: body, Status: models.ReviewPending}
+	if body == "" {
+		review.Status = models.ReviewApproved
+	}
+
+	var before *models.Review
+	err = repo.WithTxOptions(ctx, reviewTx, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.ownReview(ctx, repo, actor, songID); err != nil {
+			return err
+		}
+
+		if err := repo.SaveReview(ctx, review); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+			}
+			log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to save review: ", err)
+			return err
+		}
+		if err := s.refreshRating(ctx, repo, songID); err != nil {
+			return err
+		}
+
+		if before == nil {
+			return s.audit.Record(ctx, repo, audit.ActionCreate, audit.EntityReview, review.ID, nil, review)
+		}
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntityReview, review.ID, before, review)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"reviewID": review.ID,
+		"songID":   songID,
+		"status":   review.Status,
+	}).Info("Review saved")
+	return review, nil
+}
+
+func (s *ApiReviewService) GetOwnReview(ctx context.Context, songID int) (_ *models.Review, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetOwnReview", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.reviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	review, err := s.ownReview(ctx, repo, actor, songID)
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, fmt.Errorf("%w: song %d", ErrReviewNotFound, songID)
+	}
+	return review, nil
+}
+
+func (s *ApiReviewService) DeleteReview(ctx context.Context, songID int) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteReview", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, actor, err := s.reviewer(ctx)
+	if err != nil {
+		return err
+	}
+
+	var before *models.Review
+	return repo.WithTxOptions(ctx, reviewTx, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.ownReview(ctx, repo, actor, songID); err != nil {
+			return err
+		}
+		if before == nil {
+			return fmt.Errorf("%w: song %d", ErrReviewNotFound, songID)
+		}
+
+		if _, err := repo.DeleteReview(ctx, actor, songID); err != nil {
+			log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to delete review: ", err)
+			return err
+		}
+		if err := s.refreshRating(ctx, repo, songID); err != nil {
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionDelete, audit.EntityReview, before.ID, before, nil)
+	})
+}
+
+// reviewStatus checks that the caller may list reviews with status, which
+// defaults to approved.
+func reviewStatus(ctx context.Context, status string) (string, error) {
+	if status == "" {
+		return models.ReviewApproved, nil
+	}
+	if !validReviewStatus(status) {
+		return "", fmt.Errorf("%w: unknown status %q", ErrInvalidReview, status)
+	}
+	if status != models.ReviewApproved && !auth.PrincipalFromContext(ctx).HasScope(auth.ScopeAdmin) {
+		return "", ErrReviewForbidden
+	}
+	return status, nil
+}
+
+// GetSongReviews lists the reviews of a song with the given status, approved
+// by default. Only moderators may list other statuses.
+func (s *ApiReviewService) GetSongReviews(ctx context.Context, songID int, status string, limit, offset int) (_ []models.Review, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetSongReviews", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	logger := log.FromContext(ctx, s.logger)
+
+	if status, err = reviewStatus(ctx, status); err != nil {
+		return nil, err
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Review")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := repo.GetSong(ctx, songID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+		}
+		logger.WithField("songID", songID).Error("Failed to fetch song: ", err)
+		return nil, err
+	}
+
+	reviews, err := repo.GetReviews(ctx, models.ReviewFilter{SongID: songID, Status: status}, limit, offset)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"songID": songID,
+			"limit":  limit,
+			"offset": offset,
+		}).Error("Failed to fetch reviews: ", err)
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// GetReviews lists the reviews of the library with the given status, the
+// moderation queue of pending reviews by default.
+func (s *ApiReviewService) GetReviews(ctx context.Context, status string, limit, offset int) (_ []models.Review, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetReviews")
+	defer func() { tracing.End(span, err) }()
+
+	if status != "" && !validReviewStatus(status) {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidReview, status)
+	}
+	if status == "" {
+		status = models.ReviewPending
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Review")
+	if err != nil {
+		return nil, err
+	}
+
+	reviews, err := repo.GetReviews(ctx, models.ReviewFilter{Status: status}, limit, offset)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+			"status": status,
+			"limit":  limit,
+			"offset": offset,
+		}).Error("Failed to fetch reviews: ", err)
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// ModerateReview sets the status of a review and updates the song's rating,
+// which counts approved reviews only.
+func (s *ApiReviewService) ModerateReview(ctx context.Context, id int, status string) (_ *models.Review, err error) {
+	ctx, span := tracing.Start(ctx, "service.ModerateReview", attribute.Int("review.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	if !validReviewStatus(status) {
+		return nil, fmt.Errorf("%w: status must be %s, %s or %s", ErrInvalidReview, models.ReviewPending, models.ReviewApproved, models.ReviewRejected)
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Review")
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after *models.Review
+	err = repo.WithTxOptions(ctx, reviewTx, func(repo repository.Repository) error {
+		var err error
+		if before, err = repo.GetReview(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", ErrReviewNotFound, id)
+			}
+			log.FromContext(ctx, s.logger).WithField("reviewID", id).Error("Failed to fetch review: ", err)
+			return err
+		}
+
+		if _, err := repo.SetReviewStatus(ctx, id, status); err != nil {
+			log.FromContext(ctx, s.logger).WithField("reviewID", id).Error("Failed to update review status: ", err)
+			return err
+		}
+		if err := s.refreshRating(ctx, repo, before.SongID); err != nil {
+			return err
+		}
+
+		changed := *before
+		changed.Status = status
+		after = &changed
+
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntityReview, id, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"reviewID": id,
+		"status":   status,
+	}).Info("Review moderated")
+	return after, nil
+}
@@ -1,9 +1,19 @@
 package service
 
 import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
 	"github.com/VadimBorzenkov/online-song-library/config"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/metrics"
 	"github.com/VadimBorzenkov/online-song-library/internal/models"
 	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tenant"
+	"github.com/VadimBorzenkov/online-song-library/pkg/explicit"
 	externalapi "github.com/VadimBorzenkov/online-song-library/pkg/external_api"
 	"github.com/sirupsen/logrus"
 )
@@ -13,11 +23,99 @@ const (
 )
 
 type SongService interface {
-	GetSongsWithPaginate(filter map[string]string, limit, offset int) ([]models.Song, error)
-	GetSongWithVerses(id, limit, offset int) (*models.Song, error)
-	AddNewSong(group, song string) (*models.Song, error)
-	DeleteSong(id int) error
-	UpdateSong(song *models.Song) error
+	GetSongsWithPaginate(ctx context.Context, filter map[string]string, sort string, limit, offset int) ([]models.Song, error)
+	GetSongWithVerses(ctx context.Context, id, limit, offset int, withAnnotations bool, scheme string) (*models.Song, error)
+	AddNewSong(ctx context.Context, group, song string) (*models.Song, error)
+	DeleteSong(ctx context.Context, id int) error
+	MergeSongs(ctx context.Context, targetID int, sourceIDs []int) (*models.Song, error)
+	UpdateSong(ctx context.Context, song *models.Song) error
+	ImportSong(ctx context.Context, song *models.Song) error
+	ExportSongs(ctx context.Context, fn func(song *models.Song) error) error
+	EnrichSongs(ctx context.Context, missingTextOnly bool) ([]models.EnrichResult, error)
+	PurgeTrash(ctx context.Context, olderThan time.Duration) (int, error)
+	DetectLanguages(ctx context.Context) (int, error)
+	DetectExplicit(ctx context.Context) (int, error)
+	SetExplicit(ctx context.Context, id int, override *bool) (*models.Song, error)
+}
+
+type AuthService interface {
+	Authenticate(ctx context.Context, token string) (*auth.Principal, error)
+	CreateApiKey(ctx context.Context, name string, scopes []string, library, cleanMode string) (string, *models.ApiKey, error)
+	RevokeApiKey(ctx context.Context, id int) error
+	CreateUser(ctx context.Context, username, password, role, library string) (*models.User, error)
+	Login(ctx context.Context, username, password string) (string, *models.Session, error)
+	Logout(ctx context.Context) error
+}
+
+type LibraryService interface {
+	GetLibrary(ctx context.Context, slug string) (*models.Library, error)
+	GetLibraries(ctx context.Context) ([]models.Library, error)
+	CreateLibrary(ctx context.Context, slug, name string) (*models.Library, error)
+	CopySongs(ctx context.Context, from, to string, songIDs []int) (int64, error)
+}
+
+type PlaylistService interface {
+	GetPlaylists(ctx context.Context, limit, offset int) ([]models.Playlist, error)
+	GetPlaylist(ctx context.Context, id int) (*models.Playlist, error)
+	CreatePlaylist(ctx context.Context, playlist *models.Playlist) error
+	UpdatePlaylist(ctx context.Context, id int, update models.PlaylistUpdate) (*models.Playlist, error)
+	DeletePlaylist(ctx context.Context, id int) error
+	DuplicatePlaylist(ctx context.Context, id int, name string) (*models.Playlist, error)
+	AddEntry(ctx context.Context, playlistID, songID, position int) (*models.Playlist, error)
+	RemoveEntry(ctx context.Context, playlistID, entryID int) (*models.Playlist, error)
+	MoveEntry(ctx context.Context, playlistID, entryID, position int) (*models.Playlist, error)
+	ExportPlaylist(ctx context.Context, id int, format string, w io.Writer) error
+}
+
+type ListeningService interface {
+	AddFavorite(ctx context.Context, songID int) error
+	RemoveFavorite(ctx context.Context, songID int) error
+	GetFavorites(ctx context.Context, limit, offset int) ([]models.Song, error)
+	RecordPlay(ctx context.Context, songID int) (*models.Play, error)
+	GetHistory(ctx context.Context, limit, offset int) ([]models.Play, error)
+	GetTop(ctx context.Context, window time.Duration, limit int) (*models.TopReport, error)
+}
+
+type ReviewService interface {
+	SaveReview(ctx context.Context, songID, rating int, body string) (*models.Review, error)
+	GetOwnReview(ctx context.Context, songID int) (*models.Review, error)
+	DeleteReview(ctx context.Context, songID int) error
+	GetSongReviews(ctx context.Context, songID int, status string, limit, offset int) ([]models.Review, error)
+	GetReviews(ctx context.Context, status string, limit, offset int) ([]models.Review, error)
+	ModerateReview(ctx context.Context, id int, status string) (*models.Review, error)
+}
+
+type TagService interface {
+	GetTags(ctx context.Context, tagType string) ([]models.TagCount, error)
+	GetSongTags(ctx context.Context, songID int) ([]models.Tag, error)
+	TagSongs(ctx context.Context, songIDs []int, add, remove []string) (int64, int64, error)
+	GetFacets(ctx context.Context, filter map[string]string, limit int) (*models.SongFacets, error)
+}
+
+type AnnotationService interface {
+	GetAnnotations(ctx context.Context, songID int) ([]models.Annotation, error)
+	AddAnnotation(ctx context.Context, songID int, anchor models.AnnotationAnchor, body string) (*models.Annotation, error)
+	UpdateAnnotation(ctx context.Context, id int, update models.AnnotationUpdate) (*models.Annotation, error)
+	DeleteAnnotation(ctx context.Context, id int) error
+	VoteAnnotation(ctx context.Context, id, value int) (*models.Annotation, error)
+}
+
+type TranslationService interface {
+	GetTranslations(ctx context.Context, songID int) ([]models.Translation, error)
+	SaveTranslation(ctx context.Context, songID int, lang, text, translator string, alignment []int) (*models.Translation, error)
+	DeleteTranslation(ctx context.Context, songID int, lang string) error
+	GetVerses(ctx context.Context, songID int, lang, acceptLanguage string, withOriginal bool, scheme string, limit, offset int) (*models.SongVerses, error)
+}
+
+type AuditService interface {
+	Record(ctx context.Context, repo repository.AuditWriter, action, entity string, entityID int, before, after interface{}) error
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]models.AuditEvent, error)
+	ExportAuditEvents(ctx context.Context, filter models.AuditFilter, w io.Writer) error
+}
+
+type HealthService interface {
+	Liveness() *models.HealthReport
+	Readiness(ctx context.Context) *models.HealthReport
 }
 
 type ApiService struct {
@@ -25,14 +123,234 @@ type ApiService struct {
 	logger *logrus.Logger
 	cfg    *config.Config
 	exApi  *externalapi.ExternalApiClient
+	audit  AuditService
+	words  *explicit.Wordlist
 }
 
-func NewApiService(repo repository.Repository, logger *logrus.Logger, cfg *config.Config) *ApiService {
-	client := externalapi.NewExternalApiClient(cfg.ExternalApiURL, logger)
+func NewApiService(repo repository.Repository, logger *logrus.Logger, cfg *config.Config, audit AuditService, words *explicit.Wordlist) *ApiService {
+	client := externalapi.NewExternalApiClient(cfg.ExternalApiURL, cfg.ExternalApiTimeout, logger)
+	client.Observer = metrics.ObserveExternalApiCall
+	client.LoggerFromContext = func(ctx context.Context) logrus.FieldLogger {
+		return log.FromContext(ctx, logger)
+	}
 	return &ApiService{
 		repo:   repo,
 		logger: logger,
 		cfg:    cfg,
 		exApi:  client,
+		audit:  audit,
+		words:  words,
+	}
+}
+
+// libraryRepo scopes repo to the library resolved for the request, hiding
+// explicit songs from clean-mode principals. operation names what was
+// attempted, for the log when no library was resolved.
+func libraryRepo(ctx context.Context, repo repository.Repository, logger *logrus.Logger, operation string) (repository.Repository, error) {
+	repo, err := unfilteredLibraryRepo(ctx, repo, logger, operation)
+	if err != nil {
+		return nil, err
+	}
+	return cleanRepo(ctx, repo), nil
+}
+
+// unfilteredLibraryRepo is libraryRepo showing explicit songs to everyone,
+// for writes that must read back a song they made explicit.
+func unfilteredLibraryRepo(ctx context.Context, repo repository.Repository, logger *logrus.Logger, operation string) (repository.Repository, error) {
+	library := tenant.LibraryFromContext(ctx)
+	if library == nil {
+		log.FromContext(ctx, logger).Errorf("%s operation attempted without a library", operation)
+		return nil, ErrNoLibrary
+	}
+	return repo.ForLibrary(library.ID), nil
+}
+
+// cleanRepo hides explicit songs in repo from clean-mode principals.
+func cleanRepo(ctx context.Context, repo repository.Repository) repository.Repository {
+	if auth.PrincipalFromContext(ctx).Clean() {
+		return repo.WithoutExplicit()
+	}
+	return repo
+}
+
+// lyricsMask returns the function lyrics go through before they are shown
+// to the principal of ctx: in auth.CleanModeMask it masks the words of the
+// wordlist, otherwise it keeps the text as it is.
+func lyricsMask(ctx context.Context, words *explicit.Wordlist) func(text string) string {
+	if principal := auth.PrincipalFromContext(ctx); principal.Clean() && principal.CleanMode == auth.CleanModeMask {
+		return words.Mask
+	}
+	return func(text string) string { return text }
+}
+
+// explicitLyrics tells whether the text of a song or of one of its
+// translations has a word of the wordlist.
+func explicitLyrics(words *explicit.Wordlist, text string, translations []models.Translation) bool {
+	if words.Contains(text) {
+		return true
+	}
+	for _, translation := range translations {
+		if words.Contains(translation.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+type ApiAuthService struct {
+	repo       repository.AuthRepository
+	logger     *logrus.Logger
+	jwt        *auth.JWTVerifier
+	sessionTTL time.Duration
+}
+
+func NewApiAuthService(repo repository.AuthRepository, logger *logrus.Logger, cfg *config.Config) (*ApiAuthService, error) {
+	verifier, err := auth.NewJWTVerifier(cfg.JWTSecret, cfg.JWTPublicKeyFile, cfg.JWTIssuer, cfg.JWTAudience)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApiAuthService{
+		repo:       repo,
+		logger:     logger,
+		jwt:        verifier,
+		sessionTTL: cfg.SessionTTL,
+	}, nil
+}
+
+type ApiLibraryService struct {
+	repo   repository.LibraryRepository
+	logger *logrus.Logger
+	audit  AuditService
+}
+
+func NewApiLibraryService(repo repository.LibraryRepository, logger *logrus.Logger, audit AuditService) *ApiLibraryService {
+	return &ApiLibraryService{
+		repo:   repo,
+		logger: logger,
+		audit:  audit,
+	}
+}
+
+type ApiPlaylistService struct {
+	repo   repository.Repository
+	logger *logrus.Logger
+	audit  AuditService
+}
+
+func NewApiPlaylistService(repo repository.Repository, logger *logrus.Logger, audit AuditService) *ApiPlaylistService {
+	return &ApiPlaylistService{
+		repo:   repo,
+		logger: logger,
+		audit:  audit,
+	}
+}
+
+type ApiListeningService struct {
+	repo   repository.Repository
+	logger *logrus.Logger
+	words  *explicit.Wordlist
+}
+
+func NewApiListeningService(repo repository.Repository, logger *logrus.Logger, words *explicit.Wordlist) *ApiListeningService {
+	return &ApiListeningService{
+		repo:   repo,
+		logger: logger,
+		words:  words,
+	}
+}
+
+type ApiReviewService struct {
+	repo   repository.Repository
+	logger *logrus.Logger
+	audit  AuditService
+}
+
+func NewApiReviewService(repo repository.Repository, logger *logrus.Logger, audit AuditService) *ApiReviewService {
+	return &ApiReviewService{
+		repo:   repo,
+		logger: logger,
+		audit:  audit,
+	}
+}
+
+type ApiAnnotationService struct {
+	repo   repository.Repository
+	logger *logrus.Logger
+	audit  AuditService
+	words  *explicit.Wordlist
+}
+
+func NewApiAnnotationService(repo repository.Repository, logger *logrus.Logger, audit AuditService, words *explicit.Wordlist) *ApiAnnotationService {
+	return &ApiAnnotationService{
+		repo:   repo,
+		logger: logger,
+		audit:  audit,
+		words:  words,
+	}
+}
+
+type ApiTagService struct {
+	repo   repository.Repository
+	logger *logrus.Logger
+	audit  AuditService
+}
+
+func NewApiTagService(repo repository.Repository, logger *logrus.Logger, audit AuditService) *ApiTagService {
+	return &ApiTagService{
+		repo:   repo,
+		logger: logger,
+		audit:  audit,
+	}
+}
+
+type ApiTranslationService struct {
+	repo   repository.Repository
+	logger *logrus.Logger
+	audit  AuditService
+	words  *explicit.Wordlist
+}
+
+func NewApiTranslationService(repo repository.Repository, logger *logrus.Logger, audit AuditService, words *explicit.Wordlist) *ApiTranslationService {
+	return &ApiTranslationService{
+		repo:   repo,
+		logger: logger,
+		audit:  audit,
+		words:  words,
+	}
+}
+
+type ApiAuditService struct {
+	repo   repository.AuditRepository
+	logger *logrus.Logger
+}
+
+func NewApiAuditService(repo repository.AuditRepository, logger *logrus.Logger) *ApiAuditService {
+	return &ApiAuditService{
+		repo:   repo,
+		logger: logger,
+	}
+}
+
+type ApiHealthService struct {
+	db            *sql.DB
+	replica       *sql.DB
+	logger        *logrus.Logger
+	exApi         *externalapi.ExternalApiClient
+	checkExternal bool
+	// checkSchema is set for Postgres, whose schema is migrated separately.
+	checkSchema bool
+}
+
+// NewApiHealthService creates the health checks. db is nil for memory
+// storage and replica may be nil.
+func NewApiHealthService(db, replica *sql.DB, logger *logrus.Logger, cfg *config.Config) *ApiHealthService {
+	return &ApiHealthService{
+		db:            db,
+		replica:       replica,
+		checkSchema:   cfg.Storage == config.StoragePostgres,
+		logger:        logger,
+		exApi:         externalapi.NewExternalApiClient(cfg.ExternalApiURL, cfg.ExternalApiTimeout, logger),
+		checkExternal: cfg.HealthCheckExternalApi,
 	}
 }
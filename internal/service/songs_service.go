@@ -1,12 +1,27 @@
 package service
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"time"
 
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
 	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrNoLibrary = errors.New("no library selected for request")
+	// ErrInvalidMerge is returned for a merge without sources or of a song
+	// into itself.
+	ErrInvalidMerge = errors.New("invalid merge")
 )
 
 var possibleDateFormats = []string{
@@ -20,15 +35,19 @@ var possibleDateFormats = []string{
 	"02.01.2006",
 }
 
-func (h *ApiService) parseAndFormatDate(dateStr string) (string, error) {
+func (h *ApiService) parseAndFormatDate(ctx context.Context, dateStr string) (_ string, err error) {
+	logger := log.FromContext(ctx, h.logger)
+
+	_, span := tracing.Start(ctx, "service.parseAndFormatDate", attribute.String("date", dateStr))
+	defer func() { tracing.End(span, err) }()
+
 	var parsedDate time.Time
-	var err error
 
 	for _, format := range possibleDateFormats {
 		parsedDate, err = time.Parse(format, dateStr)
 		if err == nil {
 			formattedDate := parsedDate.Format(DateFormat)
-			h.logger.WithFields(logrus.Fields{
+			logger.WithFields(logrus.Fields{
 				"inputDate":     dateStr,
 				"parsedDate":    parsedDate,
 				"formattedDate": formattedDate,
@@ -36,35 +55,73 @@ func (h *ApiService) parseAndFormatDate(dateStr string) (string, error) {
 			}).Info("Successfully parsed and formatted date")
 			return formattedDate, nil
 		}
-		h.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"inputDate": dateStr,
 			"format":    format,
 			"error":     err,
 		}).Debug("Failed to parse date with format")
 	}
 
-	h.logger.WithField("inputDate", dateStr).Error("Failed to parse date in all formats")
+	logger.WithField("inputDate", dateStr).Error("Failed to parse date in all formats")
 	return "", errors.New("invalid date format")
 }
 
-func (s *ApiService) GetSongsWithPaginate(filter map[string]string, limit, offset int) ([]models.Song, error) {
-	songs, err := s.repo.GetData(filter, limit, offset)
+func (s *ApiService) GetSongsWithPaginate(ctx context.Context, filter map[string]string, sort string, limit, offset int) (_ []models.Song, err error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	ctx, span := tracing.Start(ctx, "service.GetSongsWithPaginate")
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return nil, err
+	}
+
+	songs, err := repo.GetData(ctx, filter, sort, limit, offset)
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"filter": filter,
+			"sort":   sort,
 			"limit":  limit,
 			"offset": offset,
 		}).Error("Failed to fetch songs: ", err)
 		return nil, err
 	}
 
+	mask := lyricsMask(ctx, s.words)
+	for i := range songs {
+		songs[i].Text = mask(songs[i].Text)
+	}
 	return songs, nil
 }
 
-func (s *ApiService) GetSongWithVerses(id, limit, offset int) (*models.Song, error) {
-	song, err := s.repo.GetSongPagi(id, limit, offset)
+// GetSongWithVerses returns limit verses of the song from verse offset on,
+// with the annotations of those verses if withAnnotations is set. A scheme
+// other than "" writes the verses in the Latin script, see translit.
+// Explicit songs are not found in clean mode, and in auth.CleanModeMask the
+// words of the wordlist are masked in the verses of the others.
+func (s *ApiService) GetSongWithVerses(ctx context.Context, id, limit, offset int, withAnnotations bool, scheme string) (_ *models.Song, err error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	ctx, span := tracing.Start(ctx, "service.GetSongWithVerses", attribute.Int("song.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	transliterate, err := transliterator(scheme)
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{
+		return nil, err
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return nil, err
+	}
+
+	song, err := repo.GetSongPagi(ctx, id, limit, offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d", ErrSongNotFound, id)
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
 			"songID": id,
 			"limit":  limit,
 			"offset": offset,
@@ -72,23 +129,56 @@ func (s *ApiService) GetSongWithVerses(id, limit, offset int) (*models.Song, err
 		return nil, err
 	}
 
-	s.logger.Infof("Successfully fetched song '%s' with %d verses", song.Song, limit)
+	if withAnnotations {
+		annotations, err := repo.GetAnnotations(ctx, id)
+		if err != nil {
+			logger.WithField("songID", id).Error("Failed to fetch annotations: ", err)
+			return nil, err
+		}
+		inlineAnnotations(song, annotations, offset)
+	}
+
+	mask := lyricsMask(ctx, s.words)
+
+	// Anchors keep pointing into the original text; quotes follow the
+	// verses into the Latin script.
+	song.Text = transliterate(mask(song.Text))
+	for i := range song.Annotations {
+		song.Annotations[i].Quote = transliterate(mask(song.Annotations[i].Quote))
+	}
+
+	logger.Infof("Successfully fetched song '%s' with %d verses", song.Song, limit)
 	return song, nil
 }
 
-func (s *ApiService) AddNewSong(group, song string) (*models.Song, error) {
-	songDetail, err := s.exApi.FetchSongInfo(group, song)
+func (s *ApiService) AddNewSong(ctx context.Context, group, song string) (_ *models.Song, err error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	ctx, span := tracing.Start(ctx, "service.AddNewSong",
+		attribute.String("song.group", group),
+		attribute.String("song.name", song),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	actor := auth.PrincipalFromContext(ctx).Actor()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{
+		return nil, err
+	}
+
+	songDetail, err := s.exApi.FetchSongInfo(ctx, group, song)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
 			"group": group,
 			"song":  song,
 		}).Error("Failed to fetch song details from external API: ", err)
 		return nil, err
 	}
 
-	formattedDate, err := s.parseAndFormatDate(songDetail.ReleaseDate)
+	formattedDate, err := s.parseAndFormatDate(ctx, songDetail.ReleaseDate)
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"group":       group,
 			"song":        song,
 			"releaseDate": songDetail.ReleaseDate,
@@ -102,45 +192,526 @@ func (s *ApiService) AddNewSong(group, song string) (*models.Song, error) {
 		ReleaseDate: formattedDate,
 		Text:        songDetail.Text,
 		Link:        songDetail.Link,
+		CreatedBy:   actor,
+		UpdatedBy:   actor,
+		Explicit:    s.words.Contains(songDetail.Text),
 	}
 
-	err = s.repo.AddNewSong(newSong)
+	err = repo.WithTx(ctx, func(repo repository.Repository) error {
+		if err := repo.AddNewSong(ctx, newSong); err != nil {
+			logger.WithFields(logrus.Fields{
+				"group": newSong.Group,
+				"song":  newSong.Song,
+			}).Error("Failed to add new song to the database: ", err)
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionCreate, audit.EntitySong, newSong.ID, nil, newSong)
+	})
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{
-			"group": newSong.Group,
-			"song":  newSong.Song,
-		}).Error("Failed to add new song to the database: ", err)
 		return nil, err
 	}
 
 	return newSong, nil
 }
 
-func (s *ApiService) UpdateSong(song *models.Song) error {
-	err := s.repo.UpdateSongData(song)
+func (s *ApiService) UpdateSong(ctx context.Context, song *models.Song) (err error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	ctx, span := tracing.Start(ctx, "service.UpdateSong", attribute.Int("song.id", song.ID))
+	defer func() { tracing.End(span, err) }()
+
+	song.UpdatedBy = auth.PrincipalFromContext(ctx).Actor()
+
+	repo, err := unfilteredLibraryRepo(ctx, s.repo, s.logger, "Song")
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{
-			"songID": song.ID,
-			"song":   song.Song,
-		}).Error("Failed to update song: ", err)
 		return err
 	}
 
-	return nil
+	// The snapshots must bracket exactly this update, so a concurrent
+	// change to the song makes the transaction retry. Clean-mode editors
+	// can only change songs they see, but the new text may make the song
+	// explicit, so it is read back unfiltered.
+	var before, after *models.Song
+	return repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		visible := cleanRepo(ctx, repo)
+
+		var err error
+		if before, err = s.getSong(ctx, visible, song.ID); err != nil {
+			return err
+		}
+
+		if song.Text != "" {
+			translations, err := visible.GetTranslations(ctx, song.ID)
+			if err != nil {
+				logger.WithField("songID", song.ID).Error("Failed to fetch translations: ", err)
+				return err
+			}
+			song.Explicit = explicitLyrics(s.words, song.Text, translations)
+		}
+
+		if err := visible.UpdateSongData(ctx, song); err != nil {
+			logger.WithFields(logrus.Fields{
+				"songID": song.ID,
+				"song":   song.Song,
+				"actor":  song.UpdatedBy,
+			}).Error("Failed to update song: ", err)
+			return err
+		}
+
+		if after, err = s.getSong(ctx, repo, song.ID); err != nil {
+			return err
+		}
+
+		if after.Text != before.Text {
+			if err := remapAnnotations(ctx, repo, s.logger, song.ID, after.Text); err != nil {
+				return err
+			}
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntitySong, song.ID, before, after)
+	})
 }
 
-func (s *ApiService) DeleteSong(id int) error {
+func (s *ApiService) DeleteSong(ctx context.Context, id int) (err error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	ctx, span := tracing.Start(ctx, "service.DeleteSong", attribute.Int("song.id", id))
+	defer func() { tracing.End(span, err) }()
 
-	rowsAffected, err := s.repo.DeleteSong(id)
+	actor := auth.PrincipalFromContext(ctx).Actor()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
 	if err != nil {
-		s.logger.WithField("songID", id).Error("Failed to delete song: ", err)
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("song with ID %d not found", id)
+	var before *models.Song
+	err = repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		var err error
+		if before, err = s.getSong(ctx, repo, id); err != nil {
+			return err
+		}
+
+		rowsAffected, err := repo.DeleteSong(ctx, id)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"songID": id,
+				"actor":  actor,
+			}).Error("Failed to delete song: ", err)
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return fmt.Errorf("song with ID %d not found", id)
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionDelete, audit.EntitySong, id, before, nil)
+	})
+	if err != nil {
+		return err
 	}
 
-	s.logger.Infof("Successfully deleted song with ID %d", id)
+	logger.Infof("Successfully deleted song with ID %d by %s", id, actor)
 	return nil
 }
+
+// MergeSongs merges duplicates into the song targetID: their plays,
+// favorites, playlist entries, reviews and tags go to it and the duplicates
+// to the trash, see repository.Repository.MergeSongs. Every song takes a
+// merge audit event, the duplicates naming the song they were merged into.
+func (s *ApiService) MergeSongs(ctx context.Context, targetID int, sourceIDs []int) (_ *models.Song, err error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	ctx, span := tracing.Start(ctx, "service.MergeSongs", attribute.Int("song.id", targetID), attribute.IntSlice("song.sources", sourceIDs))
+	defer func() { tracing.End(span, err) }()
+
+	if len(sourceIDs) == 0 {
+		return nil, fmt.Errorf("%w: no songs to merge", ErrInvalidMerge)
+	}
+	for _, id := range sourceIDs {
+		if id == targetID {
+			return nil, fmt.Errorf("%w: song %d cannot be merged into itself", ErrInvalidMerge, id)
+		}
+	}
+
+	actor := auth.PrincipalFromContext(ctx).Actor()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return nil, err
+	}
+
+	var after *models.Song
+	err = repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		songs := map[int]*models.Song{}
+		for _, id := range append([]int{targetID}, sourceIDs...) {
+			song, err := repo.GetSong(ctx, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", ErrSongNotFound, id)
+			}
+			if err != nil {
+				logger.WithField("songID", id).Error("Failed to fetch song: ", err)
+				return err
+			}
+			songs[id] = song
+		}
+
+		merged, err := repo.MergeSongs(ctx, targetID, sourceIDs)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"songID":  targetID,
+				"sources": sourceIDs,
+				"actor":   actor,
+			}).Error("Failed to merge songs: ", err)
+			return err
+		}
+
+		if err := repo.RefreshSongRating(ctx, targetID); err != nil {
+			logger.WithField("songID", targetID).Error("Failed to refresh song rating: ", err)
+			return err
+		}
+
+		if after, err = s.getSong(ctx, repo, targetID); err != nil {
+			return err
+		}
+
+		if err := s.audit.Record(ctx, repo, audit.ActionMerge, audit.EntitySong, targetID, songs[targetID], after); err != nil {
+			return err
+		}
+		for _, id := range merged {
+			if err := s.audit.Record(ctx, repo, audit.ActionMerge, audit.EntitySong, id, songs[id], map[string]interface{}{"merged_into": targetID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Successfully merged songs %v into song %d by %s", sourceIDs, targetID, actor)
+	after.Text = lyricsMask(ctx, s.words)(after.Text)
+	return after, nil
+}
+
+// getSong loads the full song, e.g. for audit snapshots, mapping a missing
+// row to the same not found error DeleteSong reports.
+func (s *ApiService) getSong(ctx context.Context, repo repository.Repository, id int) (*models.Song, error) {
+	logger := log.FromContext(ctx, s.logger)
+
+	song, err := repo.GetSong(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("song with ID %d not found", id)
+		}
+		logger.WithField("songID", id).Error("Failed to fetch song: ", err)
+		return nil, err
+	}
+
+	return song, nil
+}
+
+// ImportSong stores a song as given, without asking the external API. The
+// release date, if any, is normalized like for new songs, and whether it is
+// explicit is told by the wordlist unless ExplicitOverride is set.
+func (s *ApiService) ImportSong(ctx context.Context, song *models.Song) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ImportSong")
+	defer func() { tracing.End(span, err) }()
+
+	logger := log.FromContext(ctx, s.logger)
+
+	if song.Group == "" || song.Song == "" {
+		return errors.New("group and song are required")
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return err
+	}
+
+	if song.ReleaseDate != "" {
+		if song.ReleaseDate, err = s.parseAndFormatDate(ctx, song.ReleaseDate); err != nil {
+			return err
+		}
+	}
+
+	actor := auth.PrincipalFromContext(ctx).Actor()
+	song.CreatedBy = actor
+	song.UpdatedBy = actor
+	song.Explicit = s.words.Contains(song.Text)
+
+	return repo.WithTx(ctx, func(repo repository.Repository) error {
+		if err := repo.AddNewSong(ctx, song); err != nil {
+			logger.WithFields(logrus.Fields{
+				"group": song.Group,
+				"song":  song.Song,
+			}).Error("Failed to import song: ", err)
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionImport, audit.EntitySong, song.ID, nil, song)
+	})
+}
+
+// exportPageSize is how many songs ExportSongs reads per query.
+const exportPageSize = 500
+
+// ExportSongs calls fn for every song of the library, in ID order, leaving
+// out explicit songs in clean mode.
+func (s *ApiService) ExportSongs(ctx context.Context, fn func(song *models.Song) error) (err error) {
+	ctx, span := tracing.Start(ctx, "service.ExportSongs")
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return err
+	}
+
+	mask := lyricsMask(ctx, s.words)
+	for offset := 0; ; offset += exportPageSize {
+		songs, err := repo.GetData(ctx, nil, "", exportPageSize, offset)
+		if err != nil {
+			return err
+		}
+
+		for i := range songs {
+			songs[i].Text = mask(songs[i].Text)
+			if err := fn(&songs[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(songs) < exportPageSize {
+			return nil
+		}
+	}
+}
+
+// EnrichSongs fills in the text, link and release date of songs that lack
+// them from the external API. With missingTextOnly only songs without text
+// are considered. Failures are reported per song and do not stop the run.
+func (s *ApiService) EnrichSongs(ctx context.Context, missingTextOnly bool) (_ []models.EnrichResult, err error) {
+	ctx, span := tracing.Start(ctx, "service.EnrichSongs")
+	defer func() { tracing.End(span, err) }()
+
+	var candidates []models.Song
+	err = s.ExportSongs(ctx, func(song *models.Song) error {
+		missing := song.Text == ""
+		if !missingTextOnly {
+			missing = missing || song.Link == "" || song.ReleaseDate == ""
+		}
+		if missing {
+			candidates = append(candidates, *song)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.EnrichResult, 0, len(candidates))
+	for _, song := range candidates {
+		results = append(results, s.enrichSong(ctx, song))
+	}
+
+	return results, nil
+}
+
+func (s *ApiService) enrichSong(ctx context.Context, song models.Song) models.EnrichResult {
+	result := models.EnrichResult{
+		SongID:  song.ID,
+		Group:   song.Group,
+		Song:    song.Song,
+		Updated: []string{},
+	}
+
+	detail, err := s.exApi.FetchSongInfo(ctx, song.Group, song.Song)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	patch := &models.Song{ID: song.ID}
+	if song.Text == "" && detail.Text != "" {
+		patch.Text = detail.Text
+		result.Updated = append(result.Updated, "text")
+	}
+	if song.Link == "" && detail.Link != "" {
+		patch.Link = detail.Link
+		result.Updated = append(result.Updated, "link")
+	}
+	if song.ReleaseDate == "" && detail.ReleaseDate != "" {
+		if date, err := s.parseAndFormatDate(ctx, detail.ReleaseDate); err == nil {
+			patch.ReleaseDate = date
+			result.Updated = append(result.Updated, "release_date")
+		}
+	}
+
+	if len(result.Updated) == 0 {
+		return result
+	}
+
+	if err := s.UpdateSong(ctx, patch); err != nil {
+		result.Updated = []string{}
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// PurgeTrash permanently removes songs of the library that were deleted more
+// than olderThan ago and returns how many were removed.
+func (s *ApiService) PurgeTrash(ctx context.Context, olderThan time.Duration) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "service.PurgeTrash")
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int
+	err = repo.WithTx(ctx, func(repo repository.Repository) error {
+		var err error
+		if ids, err = repo.PurgeDeletedSongs(ctx, time.Now().Add(-olderThan)); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := s.audit.Record(ctx, repo, audit.ActionPurge, audit.EntitySong, id, nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+// languageSnapshot is the part of a song DetectLanguages writes, for the
+// audit log.
+type languageSnapshot struct {
+	Language           string  `json:"language"`
+	LanguageConfidence float64 `json:"language_confidence"`
+}
+
+// explicitSnapshot is the part of a song DetectExplicit writes, for the
+// audit log.
+type explicitSnapshot struct {
+	ExplicitDetected bool `json:"explicit_detected"`
+}
+
+// DetectLanguages detects the language of the library's songs written before
+// languages were detected on every write, and returns for how many it could
+// tell one.
+func (s *ApiService) DetectLanguages(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "service.DetectLanguages")
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return 0, err
+	}
+
+	var detected []models.Song
+	err = repo.WithTx(ctx, func(repo repository.Repository) error {
+		var err error
+		if detected, err = repo.DetectSongLanguages(ctx); err != nil {
+			log.FromContext(ctx, s.logger).Error("Failed to detect song languages: ", err)
+			return err
+		}
+
+		for _, song := range detected {
+			after := languageSnapshot{Language: song.Language, LanguageConfidence: song.LanguageConfidence}
+			if err := s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntitySong, song.ID, languageSnapshot{}, after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(detected), nil
+}
+
+// DetectExplicit checks the library's songs with the explicit wordlist if
+// they were last checked with another one, or never, and returns for how
+// many songs the verdict changed.
+func (s *ApiService) DetectExplicit(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "service.DetectExplicit")
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return 0, err
+	}
+
+	var changed []models.Song
+	err = repo.WithTx(ctx, func(repo repository.Repository) error {
+		var err error
+		if changed, err = repo.DetectExplicitSongs(ctx, s.words.Fingerprint(), s.words.Contains); err != nil {
+			log.FromContext(ctx, s.logger).Error("Failed to detect explicit songs: ", err)
+			return err
+		}
+
+		for _, song := range changed {
+			before, after := explicitSnapshot{ExplicitDetected: !song.Explicit}, explicitSnapshot{ExplicitDetected: song.Explicit}
+			if err := s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntitySong, song.ID, before, after); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(changed), nil
+}
+
+// SetExplicit sets whether the song is explicit by hand, overriding the
+// wordlist, or with a nil override goes back to the wordlist's verdict.
+func (s *ApiService) SetExplicit(ctx context.Context, id int, override *bool) (_ *models.Song, err error) {
+	ctx, span := tracing.Start(ctx, "service.SetExplicit", attribute.Int("song.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	logger := log.FromContext(ctx, s.logger)
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Song")
+	if err != nil {
+		return nil, err
+	}
+
+	var before, after *models.Song
+	err = repo.WithTxOptions(ctx, repository.TxOptions{Isolation: sql.LevelRepeatableRead}, func(repo repository.Repository) error {
+		var err error
+		if before, err = repo.GetSong(ctx, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", ErrSongNotFound, id)
+			}
+			return err
+		}
+
+		if _, err := repo.SetExplicitOverride(ctx, id, override); err != nil {
+			logger.WithField("songID", id).Error("Failed to set explicit override: ", err)
+			return err
+		}
+
+		if after, err = s.getSong(ctx, repo, id); err != nil {
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntitySong, id, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after.Text = lyricsMask(ctx, s.words)(after.Text)
+	return after, nil
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var ErrInvalidTag = errors.New("invalid tag")
+
+// maxTaggedSongs bounds the songs of one bulk tagging request.
+const maxTaggedSongs = 500
+
+func parseTags(values []string) ([]models.Tag, error) {
+	var tags []models.Tag
+	seen := map[models.Tag]bool{}
+	for _, value := range values {
+		tag, err := repository.ParseTag(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTag, err)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// GetTags lists the tags of the library of the given type, or of all types,
+// with the number of songs they classify.
+func (s *ApiTagService) GetTags(ctx context.Context, tagType string) (_ []models.TagCount, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetTags")
+	defer func() { tracing.End(span, err) }()
+
+	switch tagType {
+	case "", models.TagGenre, models.TagMood, models.TagLanguage, models.TagEra:
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidTag, tagType)
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Tag")
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := repo.GetTags(ctx, tagType)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("type", tagType).Error("Failed to fetch tags: ", err)
+		return nil, err
+	}
+
+	return append([]models.TagCount{}, tags...), nil
+}
+
+func (s *ApiTagService) GetSongTags(ctx context.Context, songID int) (_ []models.Tag, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetSongTags", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Tag")
+	if err != nil {
+		return nil, err
+	}
+
+	songTags, err := repo.GetSongTags(ctx, []int{songID})
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch song tags: ", err)
+		return nil, err
+	}
+
+	tags, ok := songTags[songID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+	}
+	return tags, nil
+}
+
+// TagSongs adds and removes tags of the songs in one transaction and
+// returns how many song tags were added and removed. Every song must be in
+// the library. Each song whose tags changed gets an audit event.
+func (s *ApiTagService) TagSongs(ctx context.Context, songIDs []int, add, remove []string) (added, removed int64, err error) {
+	ctx, span := tracing.Start(ctx, "service.TagSongs", attribute.Int("songs", len(songIDs)))
+	defer func() { tracing.End(span, err) }()
+
+	logger := log.FromContext(ctx, s.logger)
+
+	switch {
+	case len(songIDs) == 0:
+		return 0, 0, fmt.Errorf("%w: no songs given", ErrInvalidTag)
+	case len(songIDs) > maxTaggedSongs:
+		return 0, 0, fmt.Errorf("%w: at most %d songs may be tagged at once", ErrInvalidTag, maxTaggedSongs)
+	case len(add) == 0 && len(remove) == 0:
+		return 0, 0, fmt.Errorf("%w: no tags to add or remove", ErrInvalidTag)
+	}
+
+	addTags, err := parseTags(add)
+	if err != nil {
+		return 0, 0, err
+	}
+	removeTags, err := parseTags(remove)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, tag := range addTags {
+		for _, other := range removeTags {
+			if tag == other {
+				return 0, 0, fmt.Errorf("%w: %s is both added and removed", ErrInvalidTag, tag)
+			}
+		}
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Tag")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var before, after map[int][]models.Tag
+	err = repo.WithTx(ctx, func(repo repository.Repository) error {
+		var err error
+		if before, err = repo.GetSongTags(ctx, songIDs); err != nil {
+			logger.Error("Failed to fetch song tags: ", err)
+			return err
+		}
+		for _, id := range songIDs {
+			if _, ok := before[id]; !ok {
+				return fmt.Errorf("%w: %d", ErrSongNotFound, id)
+			}
+		}
+
+		if len(addTags) > 0 {
+			if added, err = repo.TagSongs(ctx, songIDs, addTags); err != nil {
+				logger.Error("Failed to tag songs: ", err)
+				return err
+			}
+		}
+		if len(removeTags) > 0 {
+			if removed, err = repo.UntagSongs(ctx, songIDs, removeTags); err != nil {
+				logger.Error("Failed to untag songs: ", err)
+				return err
+			}
+		}
+
+		if after, err = repo.GetSongTags(ctx, songIDs); err != nil {
+			logger.Error("Failed to fetch song tags: ", err)
+			return err
+		}
+
+		for id, tags := range after {
+			if fmt.Sprint(tags) == fmt.Sprint(before[id]) {
+				continue
+			}
+			if err := s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntitySong, id,
+				map[string][]models.Tag{"tags": before[id]}, map[string][]models.Tag{"tags": tags}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"songs":   len(songIDs),
+		"added":   added,
+		"removed": removed,
+	}).Info("Songs tagged")
+	return added, removed, nil
+}
+
+// GetFacets counts the songs matching filter per tag, release year and
+// group, keeping the limit most frequent groups.
+func (s *ApiTagService) GetFacets(ctx context.Context, filter map[string]string, limit int) (_ *models.SongFacets, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetFacets")
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Tag")
+	if err != nil {
+		return nil, err
+	}
+
+	facets, err := repo.GetSongFacets(ctx, filter, limit)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+			"filters": filter,
+			"limit":   limit,
+		}).Error("Failed to count song facets: ", err)
+		return nil, err
+	}
+
+	return facets, nil
+}
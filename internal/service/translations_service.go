@@ -0,0 +1,388 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/audit"
+	"github.com/VadimBorzenkov/online-song-library/internal/auth"
+	"github.com/VadimBorzenkov/online-song-library/internal/log"
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+	"github.com/VadimBorzenkov/online-song-library/internal/repository"
+	"github.com/VadimBorzenkov/online-song-library/internal/tracing"
+	"github.com/VadimBorzenkov/online-song-library/pkg/translit"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/text/language"
+)
+
+var (
+	ErrTranslationNotFound = errors.New("translation not found")
+	ErrInvalidTranslation  = errors.New("invalid translation")
+	ErrUnknownScheme       = errors.New("unknown transliteration scheme")
+)
+
+const maxTranslator = 255
+
+// translationTx is the isolation of translation writes, which check the
+// alignment against the verses of the song text.
+var translationTx = repository.TxOptions{Isolation: sql.LevelRepeatableRead}
+
+// languageTag parses a BCP-47 language tag into its canonical form, so that
+// en-us and en-US name the same translation.
+func languageTag(value string) (string, error) {
+	tag, err := language.Parse(value)
+	if err != nil || tag == language.Und {
+		return "", fmt.Errorf("%w: %q is not a BCP-47 language tag", ErrInvalidTranslation, value)
+	}
+	return tag.String(), nil
+}
+
+// alignTranslation checks that alignment maps every verse of the
+// translation, in order, to a verse of the original. Without an alignment
+// verse i renders verse i of the original, or its last verse.
+func alignTranslation(original, text string, alignment []int) ([]int, error) {
+	originalVerses := len(repository.SplitVerses(original))
+	verses := len(repository.SplitVerses(text))
+
+	if alignment == nil {
+		alignment = make([]int, verses)
+		for i := range alignment {
+			alignment[i] = min(i, originalVerses-1)
+		}
+		return alignment, nil
+	}
+
+	if len(alignment) != verses {
+		return nil, fmt.Errorf("%w: the alignment has %d entries for %d verses", ErrInvalidTranslation, len(alignment), verses)
+	}
+	for i, verse := range alignment {
+		switch {
+		case verse < 0 || verse >= originalVerses:
+			return nil, fmt.Errorf("%w: the original has no verse %d", ErrInvalidTranslation, verse)
+		case i > 0 && verse < alignment[i-1]:
+			return nil, fmt.Errorf("%w: the alignment must follow the order of the original", ErrInvalidTranslation)
+		}
+	}
+	return alignment, nil
+}
+
+func (s *ApiTranslationService) song(ctx context.Context, repo repository.Repository, songID int) (*models.Song, error) {
+	song, err := repo.GetSong(ctx, songID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+		}
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch song: ", err)
+		return nil, err
+	}
+	return song, nil
+}
+
+// detectExplicit checks the song with its translations as they are now
+// against the wordlist, after one of them changed.
+func (s *ApiTranslationService) detectExplicit(ctx context.Context, repo repository.Repository, song *models.Song) error {
+	translations, err := repo.GetTranslations(ctx, song.ID)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", song.ID).Error("Failed to fetch translations: ", err)
+		return err
+	}
+
+	if _, err := repo.SetExplicitDetected(ctx, song.ID, explicitLyrics(s.words, song.Text, translations)); err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", song.ID).Error("Failed to update explicit flag: ", err)
+		return err
+	}
+	return nil
+}
+
+// GetTranslations lists the translations of a song by language.
+func (s *ApiTranslationService) GetTranslations(ctx context.Context, songID int) (_ []models.Translation, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetTranslations", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Translation")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.song(ctx, repo, songID); err != nil {
+		return nil, err
+	}
+
+	translations, err := repo.GetTranslations(ctx, songID)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch translations: ", err)
+		return nil, err
+	}
+
+	translations = append([]models.Translation{}, translations...)
+	mask := lyricsMask(ctx, s.words)
+	for i := range translations {
+		translations[i].Text = mask(translations[i].Text)
+	}
+	return translations, nil
+}
+
+// SaveTranslation adds or replaces the translation of a song into lang.
+// A nil alignment pairs the verses of the translation with those of the
+// original in order.
+func (s *ApiTranslationService) SaveTranslation(ctx context.Context, songID int, lang, text, translator string, alignment []int) (_ *models.Translation, err error) {
+	ctx, span := tracing.Start(ctx, "service.SaveTranslation",
+		attribute.Int("song.id", songID),
+		attribute.String("translation.language", lang),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == nil {
+		return nil, ErrAuthenticationRequired
+	}
+
+	if lang, err = languageTag(lang); err != nil {
+		return nil, err
+	}
+	translator = strings.TrimSpace(translator)
+	switch {
+	case strings.TrimSpace(text) == "":
+		return nil, fmt.Errorf("%w: text is required", ErrInvalidTranslation)
+	case translator == "":
+		return nil, fmt.Errorf("%w: translator is required", ErrInvalidTranslation)
+	case utf8.RuneCountInString(translator) > maxTranslator:
+		return nil, fmt.Errorf("%w: translator is longer than %d characters", ErrInvalidTranslation, maxTranslator)
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Translation")
+	if err != nil {
+		return nil, err
+	}
+
+	translation := &models.Translation{SongID: songID, Language: lang, Text: text, Translator: translator, CreatedBy: principal.Actor()}
+	var before *models.Translation
+	err = repo.WithTxOptions(ctx, translationTx, func(repo repository.Repository) error {
+		song, err := s.song(ctx, repo, songID)
+		if err != nil {
+			return err
+		}
+		if translation.Alignment, err = alignTranslation(song.Text, text, alignment); err != nil {
+			return err
+		}
+
+		before, err = repo.GetTranslation(ctx, songID, lang)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch translation: ", err)
+			return err
+		}
+
+		if err := repo.SaveTranslation(ctx, translation); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %d", ErrSongNotFound, songID)
+			}
+			log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to save translation: ", err)
+			return err
+		}
+
+		if err := s.detectExplicit(ctx, repo, song); err != nil {
+			return err
+		}
+
+		if before == nil {
+			return s.audit.Record(ctx, repo, audit.ActionCreate, audit.EntityTranslation, translation.ID, nil, translation)
+		}
+		return s.audit.Record(ctx, repo, audit.ActionUpdate, audit.EntityTranslation, translation.ID, before, translation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"songID":   songID,
+		"language": lang,
+	}).Info("Translation saved")
+	return translation, nil
+}
+
+func (s *ApiTranslationService) DeleteTranslation(ctx context.Context, songID int, lang string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.DeleteTranslation",
+		attribute.Int("song.id", songID),
+		attribute.String("translation.language", lang),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if lang, err = languageTag(lang); err != nil {
+		return err
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Translation")
+	if err != nil {
+		return err
+	}
+
+	var before *models.Translation
+	err = repo.WithTx(ctx, func(repo repository.Repository) error {
+		song, err := s.song(ctx, repo, songID)
+		if err != nil {
+			return err
+		}
+		if before, err = repo.GetTranslation(ctx, songID, lang); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("%w: %s", ErrTranslationNotFound, lang)
+			}
+			return err
+		}
+
+		if _, err := repo.DeleteTranslation(ctx, songID, lang); err != nil {
+			log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to delete translation: ", err)
+			return err
+		}
+
+		if err := s.detectExplicit(ctx, repo, song); err != nil {
+			return err
+		}
+
+		return s.audit.Record(ctx, repo, audit.ActionDelete, audit.EntityTranslation, before.ID, before, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.FromContext(ctx, s.logger).WithFields(logrus.Fields{
+		"songID":   songID,
+		"language": lang,
+	}).Info("Translation deleted")
+	return nil
+}
+
+// pickTranslation chooses the translation to show, or nil for the original
+// text, whose detected language is original. An explicit lang must match
+// the original or one of the translations; otherwise the best match for the
+// Accept-Language header is taken, or the original if nothing matches.
+func pickTranslation(translations []models.Translation, original, lang, acceptLanguage string) (*models.Translation, error) {
+	var desired []language.Tag
+	if lang != "" {
+		tag, err := language.Parse(lang)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a BCP-47 language tag", ErrInvalidTranslation, lang)
+		}
+		desired = []language.Tag{tag}
+	} else if acceptLanguage != "" {
+		// A malformed header is treated as if it were absent.
+		desired, _, _ = language.ParseAcceptLanguage(acceptLanguage)
+	}
+	if len(desired) == 0 || len(translations) == 0 && original == "" {
+		if lang != "" {
+			return nil, fmt.Errorf("%w: %s", ErrTranslationNotFound, lang)
+		}
+		return nil, nil
+	}
+
+	// The original comes first, so that it wins when its language matches
+	// as well as a translation.
+	var supported []language.Tag
+	if original != "" {
+		supported = append(supported, language.Make(original))
+	}
+	for _, translation := range translations {
+		supported = append(supported, language.Make(translation.Language))
+	}
+	_, index, confidence := language.NewMatcher(supported).Match(desired...)
+
+	switch {
+	case lang != "" && confidence < language.High:
+		return nil, fmt.Errorf("%w: %s", ErrTranslationNotFound, lang)
+	case confidence == language.No:
+		return nil, nil
+	case original != "":
+		if index == 0 {
+			return nil, nil
+		}
+		index--
+	}
+	return &translations[index], nil
+}
+
+// transliterator returns the function writing text in the Latin script with
+// scheme, or keeping it as it is if scheme is "".
+func transliterator(scheme string) (func(string) string, error) {
+	if scheme == "" {
+		return func(text string) string { return text }, nil
+	}
+	if !slices.Contains(translit.Schemes, scheme) {
+		return nil, fmt.Errorf("%w: %q, must be one of %s", ErrUnknownScheme, scheme, strings.Join(translit.Schemes, ", "))
+	}
+	return func(text string) string {
+		latin, _ := translit.Transliterate(text, scheme)
+		return latin
+	}, nil
+}
+
+// GetVerses returns limit verses from verse offset on of the song's text in
+// the language picked by lang or acceptLanguage, see pickTranslation. With
+// withOriginal, each verse of a translation carries the verse of the
+// original it renders. A scheme other than "" writes the verses in the
+// Latin script, see translit. Explicit songs are not found in clean mode.
+func (s *ApiTranslationService) GetVerses(ctx context.Context, songID int, lang, acceptLanguage string, withOriginal bool, scheme string, limit, offset int) (_ *models.SongVerses, err error) {
+	ctx, span := tracing.Start(ctx, "service.GetVerses", attribute.Int("song.id", songID))
+	defer func() { tracing.End(span, err) }()
+
+	transliterate, err := transliterator(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := libraryRepo(ctx, s.repo, s.logger, "Translation")
+	if err != nil {
+		return nil, err
+	}
+
+	song, err := s.song(ctx, repo, songID)
+	if err != nil {
+		return nil, err
+	}
+	translations, err := repo.GetTranslations(ctx, songID)
+	if err != nil {
+		log.FromContext(ctx, s.logger).WithField("songID", songID).Error("Failed to fetch translations: ", err)
+		return nil, err
+	}
+
+	translation, err := pickTranslation(translations, song.Language, lang, acceptLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.SongVerses{SongID: song.ID, Group: song.Group, Song: song.Song, OriginalLanguage: song.Language,
+		Transliteration: scheme, Languages: []string{}, Verses: []models.Verse{}}
+	for _, t := range translations {
+		result.Languages = append(result.Languages, t.Language)
+	}
+
+	text := song.Text
+	if translation != nil {
+		text = translation.Text
+		result.Language, result.Translator = translation.Language, translation.Translator
+	}
+
+	// Masking keeps words within their verse, so verse indexes still
+	// match the alignment.
+	mask := lyricsMask(ctx, s.words)
+	verses := repository.SplitVerses(mask(text))
+	if offset >= len(verses) {
+		return nil, repository.ErrOffsetOutOfRange
+	}
+	original := repository.SplitVerses(mask(song.Text))
+	for i := offset; i < len(verses) && i < offset+limit; i++ {
+		verse := models.Verse{Index: i, Text: transliterate(verses[i])}
+		// The original may have lost verses since the translation was
+		// aligned; such verses are returned without one.
+		if translation != nil && withOriginal && i < len(translation.Alignment) && translation.Alignment[i] < len(original) {
+			index := translation.Alignment[i]
+			verse.OriginalVerse, verse.Original = &index, transliterate(original[index])
+		}
+		result.Verses = append(result.Verses, verse)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,29 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/VadimBorzenkov/online-song-library/internal/models"
+)
+
+const DefaultLibrary = "default"
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type libraryKey struct{}
+
+func WithLibrary(ctx context.Context, library *models.Library) context.Context {
+	return context.WithValue(ctx, libraryKey{}, library)
+}
+
+func LibraryFromContext(ctx context.Context) *models.Library {
+	library, _ := ctx.Value(libraryKey{}).(*models.Library)
+	return library
+}
+
+// ValidSlug reports whether s can be used as a library slug. Slugs double as
+// subdomain labels, so they follow the DNS label rules.
+func ValidSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}
@@ -0,0 +1,32 @@
+package tenant
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidSlug(t *testing.T) {
+	tests := []struct {
+		slug  string
+		valid bool
+	}{
+		{"default", true},
+		{"team-a", true},
+		{"2024", true},
+		{"a", true},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+		{"", false},
+		{"-team", false},
+		{"Team", false},
+		{"team_a", false},
+		{"team.a", false},
+		{"команда", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidSlug(tt.slug); got != tt.valid {
+			t.Errorf("ValidSlug(%q) = %v, want %v", tt.slug, got, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var recorder = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	m.Run()
+}
+
+// ended returns the ended spans of the trace, by name.
+func ended(traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans[span.Name()] = span
+		}
+	}
+	return spans
+}
+
+func TestSpans(t *testing.T) {
+	ctx, server := StartServer(context.Background(), "GET /songs/:id")
+	traceID := server.SpanContext().TraceID()
+
+	func() (err error) {
+		ctx, span := Start(ctx, "service.GetSong", attribute.Int("song.id", 7))
+		defer func() { End(span, err) }()
+
+		func() (err error) {
+			_, span := Start(ctx, "repository.GetSong")
+			defer func() { End(span, err) }()
+			return errors.New("connection reset")
+		}()
+		return nil
+	}()
+	End(server, nil)
+
+	spans := ended(traceID)
+	if len(spans) != 3 {
+		t.Fatalf("got spans %v, want 3", spans)
+	}
+
+	root, service, repository := spans["GET /songs/:id"], spans["service.GetSong"], spans["repository.GetSong"]
+	if root.SpanKind() != trace.SpanKindServer || root.Parent().IsValid() {
+		t.Errorf("server span kind %s, parent %v", root.SpanKind(), root.Parent())
+	}
+	if service.Parent().SpanID() != root.SpanContext().SpanID() || repository.Parent().SpanID() != service.SpanContext().SpanID() {
+		t.Error("spans are not nested")
+	}
+	if attrs := service.Attributes(); len(attrs) != 1 || attrs[0] != attribute.Int("song.id", 7) {
+		t.Errorf("service attributes = %v", attrs)
+	}
+
+	if status := service.Status(); status.Code != codes.Unset {
+		t.Errorf("service status = %+v, want unset", status)
+	}
+	if status := repository.Status(); status.Code != codes.Error || status.Description != "connection reset" {
+		t.Errorf("repository status = %+v, want the error", status)
+	}
+	if events := repository.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("repository events = %v, want the recorded error", events)
+	}
+}
+
+func TestInit(t *testing.T) {
+	for _, exporter := range []string{"", ExporterNone} {
+		shutdown, err := Init(context.Background(), exporter)
+		if err != nil {
+			t.Fatalf("Init(%q): %v", exporter, err)
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+	}
+
+	if _, err := Init(context.Background(), "jaeger"); err == nil {
+		t.Error("Init accepted an unknown exporter")
+	}
+}
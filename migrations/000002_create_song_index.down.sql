@@ -1 +1 @@
-DROP INDEX idx_song ON songs;
\ No newline at end of file
+DROP INDEX IF EXISTS idx_song;
\ No newline at end of file
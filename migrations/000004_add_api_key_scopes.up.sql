@@ -0,0 +1 @@
+ALTER TABLE api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT 'songs:read';
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE songs
+    DROP COLUMN IF EXISTS created_by,
+    DROP COLUMN IF EXISTS updated_by;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE songs
+    ADD COLUMN created_by VARCHAR(150),
+    ADD COLUMN updated_by VARCHAR(150);
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS library_id;
+DROP INDEX IF EXISTS idx_songs_library;
+ALTER TABLE songs DROP COLUMN IF EXISTS library_id;
+DROP TABLE IF EXISTS libraries;
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE libraries (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(63) NOT NULL UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO libraries (slug, name) VALUES ('default', 'Default library');
+
+ALTER TABLE songs ADD COLUMN library_id INTEGER REFERENCES libraries(id) ON DELETE CASCADE;
+UPDATE songs SET library_id = (SELECT id FROM libraries WHERE slug = 'default');
+ALTER TABLE songs ALTER COLUMN library_id SET NOT NULL;
+CREATE INDEX idx_songs_library ON songs (library_id);
+
+ALTER TABLE api_keys ADD COLUMN library_id INTEGER REFERENCES libraries(id) ON DELETE CASCADE;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor VARCHAR(150) NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    library VARCHAR(63),
+    entity VARCHAR(32) NOT NULL,
+    entity_id INTEGER,
+    request_id VARCHAR(64),
+    source_ip VARCHAR(45),
+    before JSONB,
+    after JSONB
+);
+
+CREATE INDEX idx_audit_events_occurred_at ON audit_events (occurred_at);
+CREATE INDEX idx_audit_events_actor ON audit_events (actor);
+CREATE INDEX idx_audit_events_entity ON audit_events (entity, entity_id);
+
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
\ No newline at end of file
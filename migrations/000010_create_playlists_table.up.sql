@@ -0,0 +1,25 @@
+CREATE TABLE playlists (
+    id SERIAL PRIMARY KEY,
+    library_id INTEGER NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    owner VARCHAR(150) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    visibility VARCHAR(16) NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'public')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_playlists_library ON playlists (library_id, owner);
+
+CREATE TABLE playlist_entries (
+    id SERIAL PRIMARY KEY,
+    playlist_id INTEGER NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    added_by VARCHAR(150),
+    added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Deferred, so that entries can be renumbered in any order within a
+    -- transaction.
+    CONSTRAINT playlist_entries_position_key UNIQUE (playlist_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX idx_playlist_entries_song ON playlist_entries (song_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_songs_play_count;
+ALTER TABLE songs DROP COLUMN IF EXISTS play_count;
+DROP TABLE IF EXISTS plays;
+DROP TABLE IF EXISTS favorites;
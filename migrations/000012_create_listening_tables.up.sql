@@ -0,0 +1,22 @@
+-- Favorites and plays belong to an actor (user:alice, api_key:dj), like
+-- playlist owners, so every kind of credential has its own history.
+CREATE TABLE favorites (
+    actor VARCHAR(150) NOT NULL,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (actor, song_id)
+);
+
+CREATE TABLE plays (
+    id BIGSERIAL PRIMARY KEY,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    actor VARCHAR(150) NOT NULL,
+    played_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_plays_actor ON plays (actor, played_at);
+CREATE INDEX idx_plays_song ON plays (song_id);
+
+-- play_count mirrors the number of plays so listings can sort by it.
+ALTER TABLE songs ADD COLUMN play_count BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX idx_songs_play_count ON songs (library_id, play_count);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_songs_rating;
+ALTER TABLE songs DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE songs DROP COLUMN IF EXISTS rating;
+DROP TABLE IF EXISTS reviews;
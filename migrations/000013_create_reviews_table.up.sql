@@ -0,0 +1,21 @@
+-- A review belongs to an actor like favorites and plays; each actor has at
+-- most one review per song.
+CREATE TABLE reviews (
+    id SERIAL PRIMARY KEY,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    actor VARCHAR(150) NOT NULL,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (song_id, actor)
+);
+
+CREATE INDEX idx_reviews_status ON reviews (status, updated_at);
+
+-- rating and rating_count summarize the approved reviews so listings can
+-- filter and sort by them.
+ALTER TABLE songs ADD COLUMN rating DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE songs ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX idx_songs_rating ON songs (library_id, rating);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS annotation_votes;
+DROP TABLE IF EXISTS annotations;
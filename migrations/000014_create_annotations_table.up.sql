@@ -0,0 +1,28 @@
+-- Annotations explain a passage of a song's text: range_start to range_end
+-- (exclusive) counts lines or characters of the verse with the given index.
+-- quote keeps the passage so the anchor can follow edits of the text.
+CREATE TABLE annotations (
+    id SERIAL PRIMARY KEY,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    verse INTEGER NOT NULL CHECK (verse >= 0),
+    unit VARCHAR(10) NOT NULL CHECK (unit IN ('line', 'char')),
+    range_start INTEGER NOT NULL CHECK (range_start >= 0),
+    range_end INTEGER NOT NULL,
+    quote TEXT NOT NULL,
+    body TEXT NOT NULL,
+    author VARCHAR(150) NOT NULL,
+    votes INTEGER NOT NULL DEFAULT 0,
+    orphaned BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (range_end > range_start)
+);
+
+CREATE INDEX idx_annotations_song ON annotations (song_id, verse, range_start);
+
+CREATE TABLE annotation_votes (
+    annotation_id INTEGER NOT NULL REFERENCES annotations(id) ON DELETE CASCADE,
+    actor VARCHAR(150) NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    PRIMARY KEY (annotation_id, actor)
+);
@@ -0,0 +1,17 @@
+-- Tags classify songs of a library. A tag is identified by its type and a
+-- lowercase name, e.g. genre "rock".
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    library_id INTEGER NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('genre', 'mood', 'language', 'era')),
+    name VARCHAR(100) NOT NULL,
+    UNIQUE (library_id, type, name)
+);
+
+CREATE TABLE song_tags (
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (song_id, tag_id)
+);
+
+CREATE INDEX idx_song_tags_tag ON song_tags (tag_id);
@@ -0,0 +1,15 @@
+-- Translations of song texts, one per song and BCP-47 language tag.
+-- alignment[i] is the verse of the original text (zero based) that verse i
+-- of the translation renders.
+CREATE TABLE song_translations (
+    id SERIAL PRIMARY KEY,
+    song_id INTEGER NOT NULL REFERENCES songs(id) ON DELETE CASCADE,
+    language VARCHAR(35) NOT NULL,
+    text TEXT NOT NULL,
+    translator VARCHAR(255) NOT NULL,
+    alignment INTEGER[] NOT NULL,
+    created_by VARCHAR(150) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (song_id, language)
+);
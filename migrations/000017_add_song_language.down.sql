@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_songs_language;
+ALTER TABLE songs DROP COLUMN IF EXISTS text_latin;
+ALTER TABLE songs DROP COLUMN IF EXISTS language_confidence;
+ALTER TABLE songs DROP COLUMN IF EXISTS language;
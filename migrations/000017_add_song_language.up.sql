@@ -0,0 +1,7 @@
+-- language and language_confidence hold the language detected in the lyrics
+-- on every write; text_latin is the lyrics transliterated into the Latin
+-- script, which the text filter matches as well.
+ALTER TABLE songs ADD COLUMN language VARCHAR(35) NOT NULL DEFAULT '';
+ALTER TABLE songs ADD COLUMN language_confidence DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE songs ADD COLUMN text_latin TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_songs_language ON songs (library_id, language);
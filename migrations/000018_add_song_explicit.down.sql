@@ -0,0 +1,4 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS clean_mode;
+ALTER TABLE libraries DROP COLUMN IF EXISTS explicit_wordlist;
+ALTER TABLE songs DROP COLUMN IF EXISTS explicit_override;
+ALTER TABLE songs DROP COLUMN IF EXISTS explicit_detected;
@@ -0,0 +1,14 @@
+-- explicit_detected is whether the lyrics had a word of the explicit
+-- wordlist when they were written; explicit_override, if not NULL, is set by
+-- hand and wins over it.
+ALTER TABLE songs ADD COLUMN explicit_detected BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE songs ADD COLUMN explicit_override BOOLEAN;
+
+-- explicit_wordlist is the fingerprint of the wordlist the explicit_detected
+-- flags of the library's songs were last checked against; songs written
+-- before this migration have never been checked.
+ALTER TABLE libraries ADD COLUMN explicit_wordlist VARCHAR(64) NOT NULL DEFAULT '';
+
+-- Keys in clean mode do not see explicit songs, and with mask see the words
+-- of the wordlist masked.
+ALTER TABLE api_keys ADD COLUMN clean_mode VARCHAR(10) NOT NULL DEFAULT 'off' CHECK (clean_mode IN ('off', 'exclude', 'mask'));
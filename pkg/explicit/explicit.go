@@ -0,0 +1,200 @@
+// Package explicit finds explicit words in lyrics using a wordlist.
+package explicit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+//go:embed words.txt
+var defaultWords string
+
+// Wordlist holds explicit words. An entry with a trailing * matches every
+// word it starts. Words are compared in lower case with ё read as е.
+type Wordlist struct {
+	words    map[string]bool
+	prefixes []string
+}
+
+// Default returns the built-in wordlist, which covers English, Russian,
+// Ukrainian, German, French, Spanish, Italian, Portuguese and Polish.
+func Default() *Wordlist {
+	list, err := Parse(strings.NewReader(defaultWords))
+	if err != nil {
+		panic("explicit: invalid built-in wordlist: " + err.Error())
+	}
+	return list
+}
+
+// Load reads a wordlist from the file at path, or returns the built-in one
+// if path is empty.
+func Load(path string) (*Wordlist, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read explicit wordlist: %w", err)
+	}
+	defer f.Close()
+
+	list, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("read explicit wordlist %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// Parse reads a wordlist with one entry per line. Blank lines and lines
+// starting with # are skipped.
+func Parse(r io.Reader) (*Wordlist, error) {
+	list := &Wordlist{words: map[string]bool{}}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		word, prefix := strings.CutSuffix(entry, "*")
+		word = normalize(word)
+		if word == "" || strings.IndexFunc(word, func(r rune) bool { return !isWordRune(r) }) >= 0 {
+			return nil, fmt.Errorf("line %d: %q is not a word", line, entry)
+		}
+		if prefix {
+			list.prefixes = append(list.prefixes, word)
+		} else {
+			list.words[word] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Len returns the number of entries of the wordlist.
+func (l *Wordlist) Len() int {
+	return len(l.words) + len(l.prefixes)
+}
+
+// Fingerprint identifies the entries of the wordlist: lists with the same
+// entries, in whatever order, spelling or with whatever comments, have the
+// same fingerprint.
+func (l *Wordlist) Fingerprint() string {
+	entries := make([]string, 0, l.Len())
+	for word := range l.words {
+		entries = append(entries, word)
+	}
+	for _, prefix := range l.prefixes {
+		entries = append(entries, prefix+"*")
+	}
+	slices.Sort(entries)
+	entries = slices.Compact(entries)
+
+	sum := sha256.Sum256([]byte(strings.Join(entries, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Contains reports whether text has a word of the list.
+func (l *Wordlist) Contains(text string) bool {
+	found := false
+	eachWord(text, func(start, end int) bool {
+		found = l.match(text[start:end])
+		return !found
+	})
+	return found
+}
+
+// Mask replaces all letters but the first of every word of the list in text
+// with asterisks.
+func (l *Wordlist) Mask(text string) string {
+	var b strings.Builder
+	last := 0
+	eachWord(text, func(start, end int) bool {
+		word := text[start:end]
+		if !l.match(word) {
+			return true
+		}
+
+		b.WriteString(text[last:start])
+		for i, r := range word {
+			if i == 0 || r == '-' {
+				b.WriteRune(r)
+			} else {
+				b.WriteByte('*')
+			}
+		}
+		last = end
+		return true
+	})
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func (l *Wordlist) match(word string) bool {
+	word = normalize(word)
+	if l.words[word] {
+		return true
+	}
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(word string) string {
+	return strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+}
+
+// isWordRune reports whether r belongs to a word: letters, combining marks
+// and the hyphen of compounds such as foda-se.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.Is(unicode.Mn, r) || r == '-'
+}
+
+// eachWord calls fn with the byte offsets of every word of text until fn
+// returns false. Hyphens only join letters, so a dash is not a word.
+func eachWord(text string, fn func(start, end int) bool) {
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) && (r != '-' || start >= 0) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			if !fn(start, trimHyphens(text, start, i)) {
+				return
+			}
+			start = -1
+		}
+	}
+	if start >= 0 {
+		fn(start, trimHyphens(text, start, len(text)))
+	}
+}
+
+// trimHyphens drops the hyphens ending the word from start to end.
+func trimHyphens(text string, start, end int) int {
+	for end > start && text[end-1] == '-' {
+		end--
+	}
+	return end
+}
@@ -0,0 +1,142 @@
+package explicit
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, text string) *Wordlist {
+	t.Helper()
+	list, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return list
+}
+
+func TestEachWord(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"one two", []string{"one", "two"}},
+		{"a - b", []string{"a", "b"}},
+		{"well-known--", []string{"well-known"}},
+		{"-word-", []string{"word"}},
+		{"x--y", []string{"x--y"}},
+		{"Ёлка, дом!", []string{"Ёлка", "дом"}},
+		{"café café", []string{"café", "café"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		eachWord(tt.text, func(start, end int) bool {
+			got = append(got, tt.text[start:end])
+			return true
+		})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("eachWord(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	list := mustParse(t, "bad\nfoda-se\nхрен*\nёлка\n")
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"nothing here", "nothing here"},
+		{"bad", "b**"},
+		{"Too BAD, so bad.", "Too B**, so b**."},
+		{"badly", "badly"},
+		{"-bad-", "-b**-"},
+		{"Foda-se!", "F***-**!"},
+		{"хреновый и ХРЕН", "х******* и Х***"},
+		{"Елка и ёлка", "Е*** и ё***"},
+	}
+	for _, tt := range tests {
+		if got := list.Mask(tt.text); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	list := mustParse(t, "bad\nхрен*\n")
+
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"", false},
+		{"a good song", false},
+		{"badly done", false},
+		{"so Bad", true},
+		{"хреновина", true},
+		{"bad-", true},
+	}
+	for _, tt := range tests {
+		if got := list.Contains(tt.text); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		len  int
+		err  string
+	}{
+		{"empty", "", 0, ""},
+		{"comments and blank lines", "# words\n\n  bad  \nhell*\n", 2, ""},
+		{"two words", "bad\nbad word\n", 0, `line 2: "bad word" is not a word`},
+		{"bare asterisk", "*\n", 0, `line 1: "*" is not a word`},
+		{"digits", "# list\n4ss\n", 0, `line 2: "4ss" is not a word`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := Parse(strings.NewReader(tt.text))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Parse error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if list.Len() != tt.len {
+				t.Errorf("Len() = %d, want %d", list.Len(), tt.len)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	list := mustParse(t, "bad\nhell*\nёлка\n")
+	same := mustParse(t, "# reordered\nHELL*\n\nелка\nBad\nbad\n")
+	other := mustParse(t, "bad\nhell\nёлка\n")
+
+	if list.Fingerprint() != same.Fingerprint() {
+		t.Errorf("fingerprints of the same entries differ")
+	}
+	if list.Fingerprint() == other.Fingerprint() {
+		t.Errorf("a word and a prefix have the same fingerprint")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	list := Default()
+	if list.Len() == 0 {
+		t.Fatalf("built-in wordlist is empty")
+	}
+	if !list.Contains("What the FUCKING hell") {
+		t.Errorf("built-in wordlist does not match a prefix entry")
+	}
+}
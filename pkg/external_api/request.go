@@ -1,22 +1,58 @@
 package externalapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Error classes passed to Observer. Unexpected status codes are reported as
+// status_4xx, status_5xx and so on.
+const (
+	ErrorClassNone    = "none"
+	ErrorClassTimeout = "timeout"
+	ErrorClassNetwork = "network"
+	ErrorClassDecode  = "decode"
 )
 
 type ExternalApiClient struct {
 	ApiURL string
 	logger *logrus.Logger
+	client *http.Client
+
+	// Observer, if set, is called after every FetchSongInfo call with its
+	// duration and error class.
+	Observer func(duration time.Duration, errorClass string)
+
+	// LoggerFromContext, if set, returns the request scoped logger for ctx so
+	// that client lines share the caller's request fields.
+	LoggerFromContext func(ctx context.Context) logrus.FieldLogger
+}
+
+func (e *ExternalApiClient) log(ctx context.Context) logrus.FieldLogger {
+	if e.LoggerFromContext != nil {
+		return e.LoggerFromContext(ctx)
+	}
+	return e.logger
 }
 
-func NewExternalApiClient(apiURL string, logger *logrus.Logger) *ExternalApiClient {
+// NewExternalApiClient creates a client whose requests give up after
+// timeout; zero means no timeout.
+func NewExternalApiClient(apiURL string, timeout time.Duration, logger *logrus.Logger) *ExternalApiClient {
 	return &ExternalApiClient{
 		ApiURL: apiURL,
 		logger: logger,
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   timeout,
+		},
 	}
 }
 
@@ -26,17 +62,38 @@ type response struct {
 	Link        string `json:"link"`
 }
 
-func (e *ExternalApiClient) FetchSongInfo(group, song string) (*response, error) {
+func (e *ExternalApiClient) FetchSongInfo(ctx context.Context, group, song string) (*response, error) {
+	logger := e.log(ctx)
+
 	url := fmt.Sprintf("%sgroup=%s&song=%s", e.ApiURL, group, song)
-	e.logger.WithFields(logrus.Fields{
+	logger.WithFields(logrus.Fields{
 		"url":   url,
 		"group": group,
 		"song":  song,
 	}).Info("Fetching song info from external API")
 
-	resp, err := http.Get(url)
+	start := time.Now()
+	errorClass := ErrorClassNone
+	defer func() {
+		if e.Observer != nil {
+			e.Observer(time.Since(start), errorClass)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		errorClass = ErrorClassNetwork
+		return nil, err
+	}
+
+	resp, err := e.client.Do(req)
 	if err != nil {
-		e.logger.WithFields(logrus.Fields{
+		errorClass = ErrorClassNetwork
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			errorClass = ErrorClassTimeout
+		}
+		logger.WithFields(logrus.Fields{
 			"url":   url,
 			"group": group,
 			"song":  song,
@@ -46,7 +103,8 @@ func (e *ExternalApiClient) FetchSongInfo(group, song string) (*response, error)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		e.logger.WithFields(logrus.Fields{
+		errorClass = fmt.Sprintf("status_%dxx", resp.StatusCode/100)
+		logger.WithFields(logrus.Fields{
 			"url":        url,
 			"group":      group,
 			"song":       song,
@@ -55,7 +113,7 @@ func (e *ExternalApiClient) FetchSongInfo(group, song string) (*response, error)
 		return nil, fmt.Errorf("failed to fetch song info: status code %d", resp.StatusCode)
 	}
 
-	e.logger.WithFields(logrus.Fields{
+	logger.WithFields(logrus.Fields{
 		"url":        url,
 		"group":      group,
 		"song":       song,
@@ -64,7 +122,8 @@ func (e *ExternalApiClient) FetchSongInfo(group, song string) (*response, error)
 
 	var response response
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		e.logger.WithFields(logrus.Fields{
+		errorClass = ErrorClassDecode
+		logger.WithFields(logrus.Fields{
 			"url":   url,
 			"group": group,
 			"song":  song,
@@ -73,10 +132,31 @@ func (e *ExternalApiClient) FetchSongInfo(group, song string) (*response, error)
 		return nil, err
 	}
 
-	e.logger.WithFields(logrus.Fields{
+	logger.WithFields(logrus.Fields{
 		"group": group,
 		"song":  song,
 	}).Info("Successfully decoded song info")
 
 	return &response, nil
 }
+
+// Ping checks that the external API answers at all. Any response below 500
+// counts as reachable, since the probe sends no song parameters.
+func (e *ExternalApiClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.ApiURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("external api returned status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
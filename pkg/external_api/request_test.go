@@ -0,0 +1,74 @@
+package externalapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFetchSongInfoErrorClass(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("song") {
+		case "ok":
+			w.Write([]byte(`{"releaseDate": "16.07.2006", "text": "Ooh baby", "link": "https://example.com"}`))
+		case "missing":
+			http.NotFound(w, r)
+		case "broken":
+			w.WriteHeader(http.StatusBadGateway)
+		case "garbage":
+			w.Write([]byte(`<html>`))
+		case "slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer provider.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name  string
+		url   string
+		song  string
+		class string
+	}{
+		{"success", provider.URL, "ok", ErrorClassNone},
+		{"not found", provider.URL, "missing", "status_4xx"},
+		{"server error", provider.URL, "broken", "status_5xx"},
+		{"invalid body", provider.URL, "garbage", ErrorClassDecode},
+		{"timeout", provider.URL, "slow", ErrorClassTimeout},
+		{"unreachable", closed.URL, "ok", ErrorClassNetwork},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewExternalApiClient(tt.url+"/info?", 100*time.Millisecond, logger)
+			var classes []string
+			client.Observer = func(duration time.Duration, errorClass string) {
+				classes = append(classes, errorClass)
+			}
+
+			info, err := client.FetchSongInfo(context.Background(), "Muse", tt.song)
+			if (err == nil) != (tt.class == ErrorClassNone) {
+				t.Fatalf("FetchSongInfo = %+v, %v", info, err)
+			}
+			if len(classes) != 1 || classes[0] != tt.class {
+				t.Errorf("observed %v, want [%s]", classes, tt.class)
+			}
+			if err == nil && (info.ReleaseDate != "16.07.2006" || info.Text != "Ooh baby" || info.Link != "https://example.com") {
+				t.Errorf("info = %+v", info)
+			}
+		})
+	}
+}
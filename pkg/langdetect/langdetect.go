@@ -0,0 +1,189 @@
+// Package langdetect guesses the language of a text offline, from the
+// scripts of its letters, letters particular to a language and frequent
+// short words.
+package langdetect
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// profile describes a language written in the Latin or Cyrillic script.
+type profile struct {
+	lang string
+	// letters are used by the language and few others of its script.
+	letters string
+	// words are frequent words of the language.
+	words []string
+	// foreign are letters of the script the language does not use.
+	foreign string
+}
+
+var latin = []profile{
+	{"en", "", []string{"the", "and", "you", "to", "of", "in", "is", "it", "my", "me", "that", "i'm", "your", "for", "on", "with", "be", "we", "all", "can't", "don't", "love", "baby", "what", "when"}, ""},
+	{"de", "äöüß", []string{"der", "die", "das", "und", "ich", "nicht", "du", "ist", "mit", "ein", "eine", "mich", "dich", "auf", "wir", "sie", "es", "zu", "mein", "dein", "nur", "noch", "wie", "auch", "liebe"}, ""},
+	{"fr", "éèêëàâçùûîïœ", []string{"le", "la", "les", "et", "je", "tu", "de", "des", "un", "une", "est", "que", "qui", "pas", "moi", "toi", "dans", "pour", "mon", "ma", "sur", "avec", "nous", "vous", "amour", "ne", "rien", "suis", "tout", "plus"}, ""},
+	{"es", "ñ¿¡áíóú", []string{"el", "la", "los", "las", "y", "que", "de", "yo", "tu", "te", "me", "mi", "es", "en", "un", "una", "por", "con", "no", "amor", "corazón", "como", "pero", "más", "para", "quiero", "tengo", "eres", "estoy", "sin"}, ""},
+	{"it", "àèéìòù", []string{"il", "la", "le", "e", "che", "di", "io", "tu", "mi", "ti", "non", "un", "una", "sono", "per", "con", "del", "della", "amore", "cuore", "come", "ma", "più", "anche", "questo"}, ""},
+	{"pt", "ãõçáâêôà", []string{"o", "a", "os", "as", "e", "que", "de", "eu", "você", "não", "um", "uma", "meu", "minha", "com", "para", "do", "da", "em", "amor", "coração", "mais", "como", "mas", "tudo"}, ""},
+	{"nl", "", []string{"de", "het", "een", "en", "ik", "je", "jij", "niet", "van", "dat", "is", "op", "met", "mijn", "wij", "zijn", "maar", "voor", "ook", "nog", "wat", "liefde", "hij", "zij", "naar"}, ""},
+	{"pl", "ąćęłńóśźż", []string{"i", "w", "nie", "się", "na", "że", "to", "jest", "ja", "ty", "mnie", "cię", "z", "do", "jak", "tak", "ale", "mój", "moja", "co", "już", "miłość", "tylko", "jestem", "być"}, ""},
+	{"tr", "ğışçöü", []string{"ve", "bir", "bu", "ben", "sen", "ne", "da", "de", "için", "gibi", "çok", "beni", "seni", "aşk", "değil", "ile", "var", "yok", "mi", "o", "kalbim", "her", "daha", "şey", "bana"}, ""},
+}
+
+var cyrillic = []profile{
+	{"ru", "ыэёъ", []string{"и", "в", "не", "на", "я", "что", "ты", "с", "он", "как", "меня", "тебя", "мне", "это", "но", "так", "все", "мы", "по", "был", "где", "только", "когда", "если", "любовь", "мой", "моя", "твой", "нет", "да"}, "іїєґўђјљњћџ"},
+	{"uk", "іїєґ", []string{"і", "в", "у", "не", "на", "я", "що", "ти", "з", "як", "мене", "тебе", "мені", "це", "але", "так", "все", "ми", "по", "де", "тільки", "коли", "якщо", "любов", "мій", "та", "моя", "твій", "ні"}, "ыэёъўђјљњћџ"},
+	{"be", "іўё", []string{"і", "ў", "у", "не", "на", "я", "што", "ты", "з", "як", "мяне", "цябе", "мне", "гэта", "але", "так", "усё", "мы", "па", "дзе", "толькі", "калі", "каханне", "мой", "яна", "яго", "мая", "твой"}, "ищъїєґђјљњћџ"},
+	{"bg", "", []string{"и", "в", "не", "на", "аз", "че", "ти", "с", "се", "да", "как", "мен", "теб", "това", "но", "така", "всичко", "ние", "по", "съм", "ще", "за", "от", "любов", "само", "мой", "моя", "твоя", "няма"}, "ыэёіїєґўђјљњћџ"},
+	{"sr", "ђјљњћџ", []string{"и", "у", "не", "на", "ја", "да", "ти", "се", "је", "са", "као", "мене", "тебе", "ми", "али", "тако", "све", "по", "где", "само", "кад", "ако", "љубав", "мој", "си", "моја", "твој", "нема"}, "йщъыьэюяёіїєґў"},
+}
+
+// scriptLanguages are the languages of scripts used by one language only,
+// among those detected.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hangul, "ko"},
+	{unicode.Georgian, "ka"},
+	{unicode.Armenian, "hy"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Han, "zh"},
+}
+
+// scripts are the scripts Detect tells apart, in order of preference.
+var scripts = func() []*unicode.RangeTable {
+	tables := []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic}
+	for _, s := range scriptLanguages {
+		tables = append(tables, s.table)
+	}
+	return tables
+}()
+
+// letterWeight is what a letter particular to a language counts for
+// against a frequent word.
+const letterWeight = 0.25
+
+// foreignWeight is what a letter the language does not use counts against
+// it.
+const foreignWeight = 1
+
+// Detect returns the ISO 639-1 code of the language of text and a
+// confidence between 0 and 1, or an empty code and 0 if the language cannot
+// be told, e.g. for text without letters.
+func Detect(text string) (string, float64) {
+	counts := map[*unicode.RangeTable]int{}
+	letters, kana := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Latin):
+			counts[unicode.Latin]++
+		case unicode.In(r, unicode.Cyrillic):
+			counts[unicode.Cyrillic]++
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		default:
+			for _, s := range scriptLanguages {
+				if unicode.In(r, s.table) {
+					counts[s.table]++
+					break
+				}
+			}
+		}
+	}
+	if letters == 0 {
+		return "", 0
+	}
+
+	// Japanese mixes kana with Han characters.
+	if kana > 0 {
+		return "ja", confidence(float64(kana+counts[unicode.Han])/float64(letters), 1, float64(kana+counts[unicode.Han])/4)
+	}
+
+	// Ties go to the script listed first.
+	script := unicode.Latin
+	for _, table := range scripts {
+		if counts[table] > counts[script] {
+			script = table
+		}
+	}
+	share := float64(counts[script]) / float64(letters)
+
+	var profiles []profile
+	switch script {
+	case unicode.Latin:
+		profiles = latin
+	case unicode.Cyrillic:
+		profiles = cyrillic
+	default:
+		for _, s := range scriptLanguages {
+			if s.table == script {
+				return s.lang, confidence(share, 1, float64(counts[script])/4)
+			}
+		}
+	}
+
+	lang, best, second := score(strings.ToLower(text), profiles)
+	if best == 0 {
+		return "", 0
+	}
+	return lang, confidence(share, best/(best+second), best)
+}
+
+// score rates text, in lower case, against each profile and returns the
+// best language with its score and the score of the runner-up.
+func score(text string, profiles []profile) (string, float64, float64) {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	scores := make([]float64, len(profiles))
+	for i, p := range profiles {
+		for _, word := range words {
+			for _, w := range p.words {
+				if word == w {
+					scores[i]++
+					break
+				}
+			}
+		}
+		for _, r := range text {
+			switch {
+			case strings.ContainsRune(p.letters, r):
+				scores[i] += letterWeight
+			case strings.ContainsRune(p.foreign, r):
+				scores[i] -= foreignWeight
+			}
+		}
+	}
+
+	var lang string
+	var best, second float64
+	for i, s := range scores {
+		switch {
+		case s > best:
+			lang, best, second = profiles[i].lang, s, best
+		case s > second:
+			second = s
+		}
+	}
+	return lang, best, second
+}
+
+// confidence combines the share of the letters in the detected script, the
+// margin of the detected language over the runner-up, from 0.5 to 1, and
+// the amount of evidence, rounded to two decimals.
+func confidence(share, margin, evidence float64) float64 {
+	certainty := 1 - math.Exp(-evidence/3)
+	return math.Round(share*margin*certainty*100) / 100
+}
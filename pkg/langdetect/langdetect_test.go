@@ -0,0 +1,75 @@
+package langdetect
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		lang       string
+		confidence float64
+	}{
+		{"empty", "", "", 0},
+		{"no letters", "12345 !?", "", 0},
+		{"no known words", "xyz qwrt", "", 0},
+		{"english words", "the and", "en", 0.49},
+		{"german words", "Ich liebe dich", "de", 0.63},
+		{"ukrainian words and letters", "Я тебе кохаю, і ти мене", "uk", 0.67},
+		{"tie goes to the first profile", "de", "fr", 0.14},
+		{"greek script", "αβγδ", "el", 0.28},
+		{"share of the script", "ab αβγδ", "el", 0.19},
+		{"kana", "こんにちは", "ja", 0.34},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lang, confidence := Detect(tt.text)
+			if lang != tt.lang || confidence != tt.confidence {
+				t.Errorf("Detect(%q) = %q, %v, want %q, %v", tt.text, lang, confidence, tt.lang, tt.confidence)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		profiles []profile
+		lang     string
+		best     float64
+		second   float64
+	}{
+		{"repeated words", "the and the", latin, "en", 3, 0},
+		{"particular letter", "straße", latin, "de", letterWeight, 0},
+		{"foreign letters", "ы", cyrillic, "ru", letterWeight, 0},
+		{"shared word", "de", latin, "fr", 1, 1},
+		{"apostrophe in a word", "don't", latin, "en", 1, 0},
+		{"nothing matches", "xyz", latin, "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lang, best, second := score(tt.text, tt.profiles)
+			if lang != tt.lang || best != tt.best || second != tt.second {
+				t.Errorf("score(%q) = %q, %v, %v, want %q, %v, %v", tt.text, lang, best, second, tt.lang, tt.best, tt.second)
+			}
+		})
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	tests := []struct {
+		share, margin, evidence float64
+		want                    float64
+	}{
+		{1, 1, 0, 0},
+		{1, 1, 3, 0.63},
+		{0.5, 1, 3, 0.32},
+		{1, 0.5, 3, 0.32},
+		{1, 1, 30, 1},
+	}
+	for _, tt := range tests {
+		if got := confidence(tt.share, tt.margin, tt.evidence); got != tt.want {
+			t.Errorf("confidence(%v, %v, %v) = %v, want %v", tt.share, tt.margin, tt.evidence, got, tt.want)
+		}
+	}
+}
@@ -1,31 +1,161 @@
 package migrator
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"io/fs"
+	"strconv"
+	"strings"
 
-	"github.com/golang-migrate/migrate"
-	"github.com/golang-migrate/migrate/database/postgres"
-	_ "github.com/golang-migrate/migrate/source/file"
+	"github.com/VadimBorzenkov/online-song-library/migrations"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
-func RunDatabaseMigrations(db *sql.DB) error {
-	driver, err := postgres.WithInstance(db, &postgres.Config{})
+// ErrNoChange is returned by Up, Down and Goto when there was nothing to do.
+var ErrNoChange = migrate.ErrNoChange
+
+// Migrator applies the embedded migrations. It holds one connection from the
+// pool; Close returns it without closing the pool.
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+func New(ctx context.Context, db *sql.DB) (*Migrator, error) {
+	source, err := iofs.New(migrations.FS, ".")
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// postgres.WithInstance would close db along with the migrator, so hand
+	// it a single connection instead.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"postgres",
-		driver,
-	)
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	return &Migrator{m: m}, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	return m.m.Up()
+}
+
+// Down rolls back n migrations.
+func (m *Migrator) Down(n int) error {
+	return m.m.Steps(-n)
+}
+
+// Steps applies n migrations, or rolls back -n if n is negative.
+func (m *Migrator) Steps(n int) error {
+	return m.m.Steps(n)
+}
+
+// Goto migrates up or down to the given version.
+func (m *Migrator) Goto(version uint) error {
+	return m.m.Migrate(version)
+}
+
+// Force records version as applied and clears the dirty flag without running
+// anything, to recover from a failed migration after fixing it by hand.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+// Version returns the current version and whether it is dirty. A database
+// without migrations reports version 0.
+func (m *Migrator) Version() (uint, bool, error) {
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+func (m *Migrator) Close() error {
+	sourceErr, dbErr := m.m.Close()
+	if sourceErr != nil {
+		return sourceErr
+	}
+	return dbErr
+}
+
+func RunDatabaseMigrations(ctx context.Context, db *sql.DB) error {
+	m, err := New(ctx, db)
 	if err != nil {
 		return err
 	}
+	defer m.Close()
 
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+	if err := m.Up(); err != nil && !errors.Is(err, ErrNoChange) {
 		return err
 	}
 
 	return nil
 }
+
+// Version reports the schema version recorded by golang-migrate. It reads the
+// version table directly so that it is cheap enough for readiness probes.
+func Version(ctx context.Context, db *sql.DB) (uint, bool, error) {
+	var version int64
+	var dirty bool
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM `+postgres.DefaultMigrationsTable+` LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return uint(version), dirty, nil
+}
+
+// LatestVersion returns the highest migration version shipped with the
+// binary, i.e. the version a fully migrated database is expected to be at.
+func LatestVersion() (uint, error) {
+	versions, err := Versions()
+	if err != nil || len(versions) == 0 {
+		return 0, err
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Versions lists the embedded migration versions in ascending order.
+func Versions() ([]uint, error) {
+	entries, err := fs.ReadDir(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []uint
+	seen := map[uint64]bool{}
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			continue
+		}
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil || seen[version] {
+			continue
+		}
+		seen[version] = true
+		versions = append(versions, uint(version))
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,150 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// TestMigrationsRoundTrip applies every migration, rolls it back and applies
+// it again, checking that each down restores the schema its up started
+// from. It needs a Postgres database in TEST_DATABASE_URL and works in a
+// throwaway schema there.
+func TestMigrationsRoundTrip(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	db := openTestSchema(t, dsn)
+
+	m, err := New(ctx, db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer m.Close()
+
+	versions, err := Versions()
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+
+	for _, version := range versions {
+		before := schema(t, db)
+
+		if err := m.Steps(1); err != nil {
+			t.Fatalf("up to %d: %v", version, err)
+		}
+		applied := schema(t, db)
+
+		if err := m.Steps(-1); err != nil {
+			t.Fatalf("down from %d: %v", version, err)
+		}
+		if after := schema(t, db); after != before {
+			t.Fatalf("down from %d did not restore the schema:\nbefore:\n%s\nafter:\n%s", version, before, after)
+		}
+
+		if err := m.Steps(1); err != nil {
+			t.Fatalf("up to %d again: %v", version, err)
+		}
+		if again := schema(t, db); again != applied {
+			t.Fatalf("reapplying %d produced a different schema:\nfirst:\n%s\nsecond:\n%s", version, applied, again)
+		}
+
+		current, dirty, err := m.Version()
+		if err != nil || dirty || current != version {
+			t.Fatalf("after %d: version %d, dirty %v, err %v", version, current, dirty, err)
+		}
+	}
+
+	latest, err := LatestVersion()
+	if err != nil {
+		t.Fatalf("LatestVersion: %v", err)
+	}
+	if current, _, _ := m.Version(); current != latest {
+		t.Fatalf("version %d, expected latest %d", current, latest)
+	}
+}
+
+// openTestSchema returns a pool whose connections use a fresh schema, which
+// is dropped when the test ends.
+func openTestSchema(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	name := fmt.Sprintf("migrator_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + name); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + name + " CASCADE"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	separator := " "
+	if strings.Contains(dsn, "://") {
+		separator = "&"
+		if !strings.Contains(dsn, "?") {
+			separator = "?"
+		}
+	}
+	db, err := sql.Open("postgres", dsn+separator+"search_path="+name)
+	if err != nil {
+		t.Fatalf("open schema: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// schema describes the tables, columns, indexes, constraints and triggers of
+// the current schema, excluding the migrations bookkeeping table.
+func schema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+
+	queries := []string{
+		`SELECT table_name || '.' || column_name || ' ' || data_type || ' ' || is_nullable || ' ' || COALESCE(column_default, '')
+		   FROM information_schema.columns
+		  WHERE table_schema = current_schema() AND table_name <> 'schema_migrations'`,
+		`SELECT indexdef FROM pg_indexes
+		  WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`,
+		`SELECT conrelid::regclass::text || ' ' || pg_get_constraintdef(oid) FROM pg_constraint
+		  WHERE connamespace = current_schema()::regnamespace`,
+		`SELECT event_object_table || ' ' || trigger_name || ' ' || event_manipulation FROM information_schema.triggers
+		  WHERE trigger_schema = current_schema()`,
+		`SELECT proname FROM pg_proc WHERE pronamespace = current_schema()::regnamespace`,
+	}
+
+	var lines []string
+	for _, query := range queries {
+		rows, err := db.Query(query + " ORDER BY 1")
+		if err != nil {
+			t.Fatalf("describe schema: %v", err)
+		}
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				t.Fatalf("describe schema: %v", err)
+			}
+			lines = append(lines, line)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatalf("describe schema: %v", err)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock the tests move by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestMemoryStore(t *testing.T) (*MemoryStore, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore(time.Hour)
+	s.now = clock.Now
+	t.Cleanup(func() { s.Close() })
+	return s, clock
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	s, clock := newTestMemoryStore(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Burst: 3}
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    Result
+	}{
+		{"first", 0, Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+		{"second", 0, Result{Allowed: true, Limit: 3, Remaining: 1, Reset: time.Second}},
+		{"third", 0, Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond}},
+		{"empty", 0, Result{Allowed: false, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{"half refilled", 250 * time.Millisecond, Result{Allowed: false, Limit: 3, Remaining: 0, Reset: 1250 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+		{"refilled", 250 * time.Millisecond, Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 1500 * time.Millisecond}},
+		{"capped at burst", time.Hour, Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 500 * time.Millisecond}},
+	}
+
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		got, err := s.Take(ctx, "client", limit)
+		if err != nil {
+			t.Fatalf("%s: Take: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: Take = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestMemoryStoreKeysAreSeparate(t *testing.T) {
+	s, _ := newTestMemoryStore(t)
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 1}
+
+	if res, _ := s.Take(ctx, "a", limit); !res.Allowed {
+		t.Fatalf("Take(a) = %+v, want allowed", res)
+	}
+	if res, _ := s.Take(ctx, "a", limit); res.Allowed {
+		t.Fatalf("second Take(a) = %+v, want denied", res)
+	}
+	if res, _ := s.Take(ctx, "b", limit); !res.Allowed {
+		t.Fatalf("Take(b) = %+v, want allowed", res)
+	}
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	s, clock := newTestMemoryStore(t)
+	ctx := context.Background()
+
+	s.Take(ctx, "slow", Limit{Rate: 1, Burst: 10})
+	s.Take(ctx, "fast", Limit{Rate: 10, Burst: 10})
+
+	// Buckets go once they have refilled completely, and not before.
+	clock.Advance(50 * time.Millisecond)
+	s.cleanup()
+	if len(s.buckets) != 2 {
+		t.Fatalf("buckets after 50ms = %d, want 2", len(s.buckets))
+	}
+
+	clock.Advance(50 * time.Millisecond)
+	s.cleanup()
+	if _, ok := s.buckets["fast"]; ok {
+		t.Errorf("refilled bucket kept")
+	}
+	if _, ok := s.buckets["slow"]; !ok {
+		t.Errorf("bucket still refilling dropped")
+	}
+
+	// A dropped bucket starts out full again.
+	if res, _ := s.Take(ctx, "fast", Limit{Rate: 10, Burst: 10}); !res.Allowed || res.Remaining != 9 {
+		t.Errorf("Take after cleanup = %+v, want a full bucket", res)
+	}
+}
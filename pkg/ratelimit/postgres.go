@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// refill is the bucket level after refilling it for the time elapsed since
+// the last update, capped at the burst size ($2). $3 is the refill rate.
+const refill = `LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM (NOW() - b.updated_at))::float8 * $3::float8)`
+
+// takeQuery refills and, if at least one token is available, consumes a
+// token in a single statement so that concurrent replicas never race. The
+// database clock is used so replicas with skewed clocks agree.
+var takeQuery = fmt.Sprintf(`INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+VALUES ($1, $2::float8 - 1, TRUE, NOW())
+ON CONFLICT (key) DO UPDATE SET
+    tokens = CASE WHEN %[1]s >= 1 THEN %[1]s - 1 ELSE %[1]s END,
+    allowed = %[1]s >= 1,
+    updated_at = NOW()
+RETURNING tokens, allowed`, refill)
+
+// cleanupQuery deletes the buckets last used more than $1 seconds ago.
+const cleanupQuery = `DELETE FROM rate_limit_buckets WHERE updated_at < NOW() - $1 * INTERVAL '1 second'`
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so that limits
+// hold across replicas.
+type PostgresStore struct {
+	db     *sql.DB
+	idle   time.Duration
+	logger *logrus.Logger
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewPostgresStore creates the store and starts a janitor that deletes the
+// buckets unused for idle, so idle clients do not accumulate. With idle at
+// least the longest RefillTime of the limits, deleted buckets were full
+// anyway. Call Close to stop it.
+func NewPostgresStore(db *sql.DB, cleanupInterval, idle time.Duration, logger *logrus.Logger) *PostgresStore {
+	s := &PostgresStore{
+		db:     db,
+		idle:   idle,
+		logger: logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go s.janitor(cleanupInterval)
+
+	return s
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	var tokens float64
+	var allowed bool
+	if err := s.db.QueryRowContext(ctx, takeQuery, key, limit.Burst, limit.Rate).Scan(&tokens, &allowed); err != nil {
+		return Result{}, err
+	}
+
+	return result(allowed, tokens, limit), nil
+}
+
+func (s *PostgresStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *PostgresStore) janitor(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.cleanup(interval)
+		}
+	}
+}
+
+// cleanup failures are only logged: the next run deletes the buckets.
+func (s *PostgresStore) cleanup(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, cleanupQuery, s.idle.Seconds())
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Failed to delete idle rate limit buckets")
+		return
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		s.logger.WithFields(logrus.Fields{
+			"buckets": n,
+		}).Debug("Deleted idle rate limit buckets")
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestPostgresStore returns a store on a temporary rate_limit_buckets
+// table, private to its single connection.
+func newTestPostgresStore(t *testing.T, idle time.Duration) *PostgresStore {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`CREATE TEMPORARY TABLE rate_limit_buckets (
+		key VARCHAR(255) PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		allowed BOOLEAN NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := NewPostgresStore(db, time.Hour, idle, logger)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// backdate moves the last update of the bucket d into the past, as the
+// store reads the database clock.
+func backdate(t *testing.T, s *PostgresStore, key string, d time.Duration) {
+	t.Helper()
+
+	if _, err := s.db.Exec("UPDATE rate_limit_buckets SET updated_at = updated_at - $2 * INTERVAL '1 second' WHERE key = $1", key, d.Seconds()); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+}
+
+func TestPostgresStoreTake(t *testing.T) {
+	s := newTestPostgresStore(t, time.Hour)
+	ctx := context.Background()
+	limit := Limit{Rate: 2, Burst: 3}
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		allowed bool
+		remain  int
+	}{
+		{"first", 0, true, 2},
+		{"second", 0, true, 1},
+		{"third", 0, true, 0},
+		{"empty", 0, false, 0},
+		{"refilled", 600 * time.Millisecond, true, 0},
+		{"capped at burst", time.Hour, true, 2},
+	}
+
+	for _, step := range steps {
+		backdate(t, s, "client", step.advance)
+		got, err := s.Take(ctx, "client", limit)
+		if err != nil {
+			t.Fatalf("%s: Take: %v", step.name, err)
+		}
+		if got.Allowed != step.allowed || got.Remaining != step.remain || got.Limit != 3 {
+			t.Errorf("%s: Take = %+v, want allowed %v with %d remaining", step.name, got, step.allowed, step.remain)
+		}
+		if !got.Allowed && (got.RetryAfter <= 0 || got.RetryAfter > 500*time.Millisecond) {
+			t.Errorf("%s: RetryAfter = %v, want at most 500ms", step.name, got.RetryAfter)
+		}
+	}
+}
+
+func TestPostgresStoreCleanup(t *testing.T) {
+	s := newTestPostgresStore(t, time.Minute)
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 10}
+
+	for _, key := range []string{"idle", "active"} {
+		if _, err := s.Take(ctx, key, limit); err != nil {
+			t.Fatalf("Take(%s): %v", key, err)
+		}
+	}
+	backdate(t, s, "idle", 2*time.Minute)
+	backdate(t, s, "active", 30*time.Second)
+
+	s.cleanup(time.Second)
+
+	var keys []string
+	rows, err := s.db.Query("SELECT key FROM rate_limit_buckets ORDER BY key")
+	if err != nil {
+		t.Fatalf("list buckets: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) != 1 || keys[0] != "active" {
+		t.Fatalf("buckets after cleanup = %v, want [active]", keys)
+	}
+}
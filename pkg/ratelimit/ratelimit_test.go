@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitRefillTime(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  time.Duration
+	}{
+		{Limit{Rate: 2, Burst: 10}, 5 * time.Second},
+		{Limit{Rate: 0.5, Burst: 3}, 6 * time.Second},
+		{Limit{Rate: 0, Burst: 10}, 0},
+		{Limit{Rate: 1, Burst: 0}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.limit.RefillTime(); got != tt.want {
+			t.Errorf("%+v.RefillTime() = %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 10}
+
+	tests := []struct {
+		name    string
+		allowed bool
+		tokens  float64
+		want    Result
+	}{
+		{"full", true, 10, Result{Allowed: true, Limit: 10, Remaining: 10}},
+		{"fractional", true, 4.5, Result{Allowed: true, Limit: 10, Remaining: 4, Reset: 2750 * time.Millisecond}},
+		{"empty", true, 0, Result{Allowed: true, Limit: 10, Remaining: 0, Reset: 5 * time.Second}},
+		{"denied", false, 0.5, Result{Allowed: false, Limit: 10, Remaining: 0, Reset: 4750 * time.Millisecond, RetryAfter: 250 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := result(tt.allowed, tt.tokens, limit); got != tt.want {
+				t.Errorf("result(%v, %v) = %+v, want %+v", tt.allowed, tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
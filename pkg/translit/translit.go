@@ -0,0 +1,112 @@
+// Package translit transliterates Cyrillic text into the Latin script.
+package translit
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Schemes.
+const (
+	// ISO9 is ISO 9:1995, identical to system A of GOST 7.79-2000: one
+	// Latin letter, with diacritics, per Cyrillic letter, so it can be
+	// reversed.
+	ISO9 = "iso9"
+	// GOST is system B of GOST 7.79-2000, which keeps to ASCII.
+	GOST = "gost"
+	// ICAO is the scheme of ICAO Doc 9303 used in Russian passports.
+	ICAO = "icao"
+	// Informal is the common spelling of Russian in Latin letters, as
+	// used in song titles and chats.
+	Informal = "informal"
+)
+
+// Schemes lists the supported schemes.
+var Schemes = []string{ISO9, GOST, ICAO, Informal}
+
+var ErrUnknownScheme = errors.New("unknown transliteration scheme")
+
+// tables map lowercase Cyrillic letters of Russian, Ukrainian and
+// Belarusian to their transliteration. Letters missing from a table are
+// kept.
+var tables = map[string]map[rune]string{
+	ISO9: {
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g̀", 'д': "d", 'е': "e", 'ё': "ë", 'є': "ê",
+		'ж': "ž", 'з': "z", 'и': "i", 'і': "ì", 'ї': "ï", 'й': "j", 'к': "k", 'л': "l", 'м': "m",
+		'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ў': "ǔ", 'ф': "f",
+		'х': "h", 'ц': "c", 'ч': "č", 'ш': "š", 'щ': "ŝ", 'ъ': "ʺ", 'ы': "y", 'ь': "ʹ", 'э': "è",
+		'ю': "û", 'я': "â",
+	},
+	GOST: {
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g`", 'д': "d", 'е': "e", 'ё': "yo", 'є': "ye",
+		'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "yi", 'й': "j", 'к': "k", 'л': "l", 'м': "m",
+		'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ў': "u`", 'ф': "f",
+		'х': "x", 'ц': "cz", 'ч': "ch", 'ш': "sh", 'щ': "shh", 'ъ': "``", 'ы': "y`", 'ь': "`", 'э': "e`",
+		'ю': "yu", 'я': "ya",
+	},
+	ICAO: {
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "e", 'є': "ie",
+		'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m",
+		'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ў': "u", 'ф': "f",
+		'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "ie", 'ы': "y", 'ь': "", 'э': "e",
+		'ю': "iu", 'я': "ia",
+	},
+	Informal: {
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'ґ': "g", 'д': "d", 'е': "e", 'ё': "yo", 'є': "ye",
+		'ж': "zh", 'з': "z", 'и': "i", 'і': "i", 'ї': "yi", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+		'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ў': "w", 'ф': "f",
+		'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e",
+		'ю': "yu", 'я': "ya",
+	},
+}
+
+// gostSoftC are the letters before which system B writes ц as c rather
+// than cz.
+var gostSoftC = map[rune]bool{'е': true, 'и': true, 'і': true, 'ы': true, 'й': true, 'я': true}
+
+// Transliterate writes the Cyrillic letters of text in the Latin script
+// using scheme and keeps everything else. A capital letter becomes a
+// capital, or an all caps digraph within an all caps word.
+func Transliterate(text, scheme string) (string, error) {
+	table, ok := tables[scheme]
+	if !ok {
+		return "", ErrUnknownScheme
+	}
+
+	runes := []rune(text)
+	var b strings.Builder
+	b.Grow(len(text))
+	for i, r := range runes {
+		lower := unicode.ToLower(r)
+		latin, ok := table[lower]
+		if !ok {
+			b.WriteRune(r)
+			continue
+		}
+		if scheme == GOST && lower == 'ц' && i+1 < len(runes) && gostSoftC[unicode.ToLower(runes[i+1])] {
+			latin = "c"
+		}
+
+		if lower != r && latin != "" {
+			if allCaps(runes, i) {
+				latin = strings.ToUpper(latin)
+			} else {
+				first := []rune(latin)
+				latin = string(unicode.ToUpper(first[0])) + string(first[1:])
+			}
+		}
+		b.WriteString(latin)
+	}
+	return b.String(), nil
+}
+
+// allCaps reports whether the capital letter at i is part of a word
+// written in capitals: the next letter, or the previous one at the end of a
+// word, is a capital too.
+func allCaps(runes []rune, i int) bool {
+	if i+1 < len(runes) && unicode.IsLetter(runes[i+1]) {
+		return unicode.IsUpper(runes[i+1])
+	}
+	return i > 0 && unicode.IsUpper(runes[i-1])
+}
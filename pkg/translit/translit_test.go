@@ -0,0 +1,74 @@
+package translit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		text   string
+		want   string
+	}{
+		{"iso9 diacritics", ISO9, "ёжик и щука", "ëžik i ŝuka"},
+		{"iso9 signs", ISO9, "объём, мать", "obʺëm, matʹ"},
+		{"iso9 ukrainian", ISO9, "Їжак і ґанок", "Ïžak ì g̀anok"},
+		{"gost hard c", GOST, "Царь", "Czar`"},
+		{"gost soft c before и", GOST, "цирк", "cirk"},
+		{"gost soft c before ы", GOST, "Цыган", "Cy`gan"},
+		{"gost soft c before capital", GOST, "ЦИРК", "CIRK"},
+		{"gost c before other vowels", GOST, "цапля, цокот", "czaplya, czokot"},
+		{"icao", ICAO, "Щукин Юрий", "Shchukin Iurii"},
+		{"icao soft sign is dropped", ICAO, "Игорь", "Igor"},
+		{"informal", Informal, "Группа крови", "Gruppa krovi"},
+		{"informal signs are dropped", Informal, "подъезд, Ъ", "podezd, "},
+		{"all caps digraphs", ICAO, "ЖЖЁТ ЩУКА", "ZHZHET SHCHUKA"},
+		{"all caps word ending in a digraph", GOST, "ЁЖ", "YOZH"},
+		{"capital digraph in a word", Informal, "Жизнь", "Zhizn"},
+		{"single capital digraph", Informal, "Ж и Ш", "Zh i Sh"},
+		{"capital before punctuation", Informal, "Ш!", "Sh!"},
+		{"other scripts are kept", Informal, "Кино — Kino 1982", "Kino — Kino 1982"},
+		{"empty", GOST, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Transliterate(tt.text, tt.scheme)
+			if err != nil {
+				t.Fatalf("Transliterate(%q, %s): %v", tt.text, tt.scheme, err)
+			}
+			if got != tt.want {
+				t.Errorf("Transliterate(%q, %s) = %q, want %q", tt.text, tt.scheme, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransliterateUnknownScheme(t *testing.T) {
+	if _, err := Transliterate("текст", "bgn"); !errors.Is(err, ErrUnknownScheme) {
+		t.Fatalf("Transliterate with an unknown scheme error = %v, want ErrUnknownScheme", err)
+	}
+}
+
+func TestAllCaps(t *testing.T) {
+	tests := []struct {
+		text string
+		i    int
+		want bool
+	}{
+		{"ЩУКА", 0, true},
+		{"Щука", 0, false},
+		{"ЁЖ", 1, true},
+		{"Ж", 0, false},
+		{"Ж!", 0, false},
+		{"ОЙ Ж", 3, false},
+		{"ЁЖ!", 1, true},
+		{"Ж ЁЖ", 0, false},
+	}
+	for _, tt := range tests {
+		if got := allCaps([]rune(tt.text), tt.i); got != tt.want {
+			t.Errorf("allCaps(%q, %d) = %v, want %v", tt.text, tt.i, got, tt.want)
+		}
+	}
+}